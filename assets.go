@@ -7,5 +7,10 @@ import (
 //go:embed migrations/*.sql
 var Migrations embed.FS
 
+// SQLiteMigrations are the migrations of a library kept in SQLite.
+//
+//go:embed migrations/sqlite/*.sql
+var SQLiteMigrations embed.FS
+
 //go:embed web/*
 var WebAssets embed.FS
@@ -2,6 +2,7 @@ package main
 
 import (
 	"log"
+	"os"
 
 	"github.com/banjuer/kompanion/config"
 	"github.com/banjuer/kompanion/internal/app"
@@ -16,6 +17,14 @@ func main() {
 		log.Fatalf("Config error: %s", err)
 	}
 
+	// kompanion <command>, maintenance commands are listed by kompanion help
+	if len(os.Args) > 1 {
+		if err = app.Admin(cfg, os.Args[1:], os.Stdout); err != nil {
+			log.Fatalf("Error: %s", err)
+		}
+		return
+	}
+
 	// Run
 	app.Run(cfg)
 }
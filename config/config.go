@@ -2,9 +2,17 @@ package config
 
 import (
 	"fmt"
+	"net"
+	"net/mail"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/cache"
+	"github.com/banjuer/kompanion/pkg/cron"
+	"github.com/banjuer/kompanion/pkg/ratelimit"
 )
 
 type (
@@ -17,6 +25,26 @@ type (
 		PG
 		BookStorage
 		Metadata
+		SMTP
+		Digest
+		Converter
+		Backup
+		Sentry
+		CoverCache
+		Cache
+		Downloads
+		CDN
+		Library
+		Uploads
+		Outbound
+		OIDC
+		Features
+		Extensions
+		Hooks
+		Webhooks
+		Tracing
+		RateLimit
+		Sync
 	}
 
 	// App -.
@@ -32,9 +60,11 @@ type (
 		Storage  string
 	}
 
-	// HTTP -.
+	// HTTP -. TrustedProxies are the IPs or CIDRs of reverse proxies whose
+	// X-Forwarded-For tells the client IP, none by default.
 	HTTP struct {
-		Port string
+		Port           string
+		TrustedProxies []string
 	}
 
 	// Log -.
@@ -46,6 +76,8 @@ type (
 	PG struct {
 		PoolMax int
 		URL     string
+		// Migrate applies the migrations the database lacks at startup.
+		Migrate bool
 	}
 
 	BookStorage struct {
@@ -56,10 +88,200 @@ type (
 	Metadata struct {
 		Provider            string
 		DoubanCookie        string
+		DoubanInterval      time.Duration
 		CookieCloudURL      string
 		CookieCloudUUID     string
 		CookieCloudPassword string
 		CookieCloudDomain   string
+		DOIProvider         string
+		CrossrefMailto      string
+		GoogleBooksAPIKey   string
+		// Providers in the order their fields are preferred, "file" being
+		// the metadata of the book file
+		Providers []string
+		// FieldOrder overrides the order per field, e.g. "cover=openlibrary"
+		FieldOrder string
+		// CoverSource fetches covers by ISBN for books without one, "none"
+		// or "openlibrary"
+		CoverSource string
+	}
+
+	// SMTP - outgoing mail for send to device, disabled when Host is empty.
+	SMTP struct {
+		Host     string
+		Port     int
+		Username string
+		Password string
+		From     string
+	}
+
+	// Digest - emails of the books added to the library, sent over SMTP to
+	// the To addresses every Interval. Off without addresses.
+	Digest struct {
+		To       []string
+		Interval time.Duration
+	}
+
+	// Converter - calibre ebook-convert used for formats a device does not accept,
+	// and the workers running the queued conversions on this server.
+	Converter struct {
+		Binary     string
+		Workers    int
+		JobTimeout time.Duration // visibility timeout of a claimed job
+	}
+
+	// Backup - scheduled database dumps, disabled when Path is empty.
+	Backup struct {
+		Path       string
+		Interval   time.Duration
+		Schedule   string // cron expression, replaces Interval when set
+		Files      bool   // copy book files into the backup
+		Retention  int
+		PGDump     string
+		UploadType string
+		UploadPath string
+		// restore test of the latest backup
+		VerifyInterval time.Duration
+		VerifySamples  int
+		PGRestore      string
+		PSQL           string
+	}
+
+	// Sentry - panic reporting to Sentry or GlitchTip, disabled when DSN is empty.
+	Sentry struct {
+		DSN         string
+		Environment string
+	}
+
+	// CoverCache - resized covers kept on disk, least recently used are removed first.
+	CoverCache struct {
+		Path    string
+		MaxSize int64 // bytes
+		// cwebp and avifenc making WebP and AVIF covers, looked up in PATH
+		// when empty
+		WebPEncoder string
+		AVIFEncoder string
+	}
+
+	// Cache - listings and covers kept between requests, in memory or in
+	// Redis when RedisURL is set. Off when MaxSize is 0 without Redis.
+	Cache struct {
+		RedisURL string
+		MaxSize  int64 // bytes
+		TTL      time.Duration
+	}
+
+	// Downloads - signed links that download a book without credentials.
+	Downloads struct {
+		Secret  string // a random one is used when empty
+		LinkTTL time.Duration
+	}
+
+	// CDN - serves the book storage so downloads and covers skip the
+	// server, links are signed with a CloudFront key pair when one is set.
+	CDN struct {
+		URL        string
+		KeyPairID  string
+		PrivateKey string // path of the PEM encoded RSA key
+		LinkTTL    time.Duration
+	}
+
+	// Library - integrity check of the stored book files, off when 0, the
+	// unrar executable reading CBR comics, the layout of stored files, the
+	// patterns reading metadata from upload file names and the most books
+	// per listing page, for admins and everyone else.
+	Library struct {
+		Storage          string // postgres or sqlite
+		SQLitePath       string // database file of the sqlite storage
+		VerifyInterval   time.Duration
+		Unrar            string
+		PathTemplate     string
+		FilenamePatterns []string
+		MaxPageSize      int
+		AdminMaxPageSize int
+	}
+
+	// Uploads - limits of book uploads on shared instances, zero values
+	// do not limit.
+	Uploads struct {
+		MaxSize  int64    // bytes
+		Formats  []string // file extensions
+		MaxBooks int      // per account
+		Quota    int64    // bytes per account
+	}
+
+	// Outbound - requests to metadata providers and other services, through
+	// Proxy (http, https or socks5, the *_PROXY environment when empty) and
+	// trusting the PEM bundle CAFile besides the system roots.
+	Outbound struct {
+		Proxy   string
+		CAFile  string
+		Timeout time.Duration
+	}
+
+	// Features - optional parts of the server switched off by default, the
+	// settings switch them on again.
+	Features struct {
+		Disabled []string
+	}
+
+	// Extensions - external programs handling hooks, see pkg/extension.
+	Extensions struct {
+		Commands []string
+		Timeout  time.Duration
+	}
+
+	// Hooks - shell commands run on library events, see extension.Command.
+	Hooks struct {
+		BookAdded    string
+		BookFinished string
+		Timeout      time.Duration
+	}
+
+	// Webhooks - URLs library events are posted to, see pkg/webhook.
+	Webhooks struct {
+		URLs   []string
+		Secret string
+		// Events to post, all when empty
+		Events []string
+	}
+
+	// Tracing - spans of requests exported to an OpenTelemetry collector,
+	// see pkg/tracing. Off when Endpoint is empty.
+	Tracing struct {
+		Endpoint string // OTLP/HTTP base URL, e.g. http://localhost:4318
+		Service  string
+		Headers  map[string]string
+		// SampleRatio is the share of requests traced, from 0 to 1
+		SampleRatio float64
+	}
+
+	// RateLimit - requests a client may make, like 120/m, per account or
+	// IP. Empty limits are off, buckets are kept in memory or postgres.
+	RateLimit struct {
+		Store  string
+		Sync   string // KOReader progress sync
+		Search string // book searches
+		API    string // everything under /api
+	}
+
+	// Sync - what a device pushing a position behind the latest one of
+	// another device does: latest, furthest or keep-both, see sync.Conflict*.
+	Sync struct {
+		Conflicts string
+	}
+
+	// OIDC - single sign-on with an OpenID Connect provider, off when
+	// Issuer is empty. AutoProvision creates the users the provider knows.
+	OIDC struct {
+		Issuer        string
+		ClientID      string
+		ClientSecret  string
+		RedirectURL   string
+		Name          string
+		Scopes        []string
+		UsernameClaim string
+		AutoProvision bool
 	}
 )
 
@@ -90,7 +312,95 @@ func NewConfig(version string) (*Config, error) {
 		return nil, err
 	}
 
-	metadata := readMetadataConfig()
+	metadata, err := readMetadataConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	smtp, err := readSMTPConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	digest, err := readDigestConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	converter, err := readConverterConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	backup, err := readBackupConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	coverCache, err := readCoverCacheConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	responseCache, err := readCacheConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	downloads, err := readDownloadsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	cdn, err := readCDNConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	library, err := readLibraryConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	uploads, err := readUploadsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	outbound, err := readOutboundConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	oidc, err := readOIDCConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	extensions, err := readExtensionsConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	hooks, err := readHooksConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	tracing, err := readTracingConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	rateLimit, err := readRateLimitConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	sync, err := readSyncConfig()
+	if err != nil {
+		return nil, err
+	}
 
 	return &Config{
 		App: App{
@@ -103,6 +413,31 @@ func NewConfig(version string) (*Config, error) {
 		PG:          postgres,
 		BookStorage: bookStorage,
 		Metadata:    metadata,
+		SMTP:        smtp,
+		Digest:      digest,
+		Converter:   converter,
+		Backup:      backup,
+		Sentry: Sentry{
+			DSN:         readPrefixedEnv("SENTRY_DSN"),
+			Environment: readPrefixedEnv("SENTRY_ENVIRONMENT"),
+		},
+		CoverCache: coverCache,
+		Cache:      responseCache,
+		Downloads:  downloads,
+		CDN:        cdn,
+		Library:    library,
+		Uploads:    uploads,
+		Outbound:   outbound,
+		OIDC:       oidc,
+		Features: Features{
+			Disabled: strings.Split(readPrefixedEnv("DISABLED_FEATURES"), ","),
+		},
+		Extensions: extensions,
+		Hooks:      hooks,
+		Webhooks:   readWebhooksConfig(),
+		Tracing:    tracing,
+		RateLimit:  rateLimit,
+		Sync:       sync,
 	}, nil
 }
 
@@ -131,8 +466,20 @@ func readHTTPConfig() (HTTP, error) {
 		port = "8080"
 	}
 
+	var proxies []string
+	for _, proxy := range strings.Split(readPrefixedEnv("HTTP_TRUSTED_PROXIES"), ",") {
+		if proxy = strings.TrimSpace(proxy); proxy == "" {
+			continue
+		}
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			return HTTP{}, fmt.Errorf("trusted proxy %q is not an IP or CIDR", proxy)
+		}
+		proxies = append(proxies, proxy)
+	}
+
 	return HTTP{
-		Port: port,
+		Port:           port,
+		TrustedProxies: proxies,
 	}, nil
 }
 
@@ -166,9 +513,19 @@ func readPostgresConfig() (PG, error) {
 		return PG{}, fmt.Errorf("postgres url is empty")
 	}
 
+	migrate := true
+	if migrateEnv := readPrefixedEnv("PG_MIGRATE"); migrateEnv != "" {
+		b, err := strconv.ParseBool(migrateEnv)
+		if err != nil {
+			return PG{}, fmt.Errorf("pg migrate is not a boolean")
+		}
+		migrate = b
+	}
+
 	return PG{
 		PoolMax: poolMax,
 		URL:     url,
+		Migrate: migrate,
 	}, nil
 }
 
@@ -184,28 +541,586 @@ func readBookStorageConfig() (BookStorage, error) {
 	}, nil
 }
 
-func readMetadataConfig() Metadata {
+func readMetadataConfig() (Metadata, error) {
 	provider := readPrefixedEnv("METADATA_PROVIDER")
 	if provider == "" {
 		provider = "none"
 	}
 
+	doiProvider := readPrefixedEnv("METADATA_DOI_PROVIDER")
+	if doiProvider == "" {
+		doiProvider = "none"
+	}
+
+	doubanInterval := 2 * time.Second
+	if intervalEnv := readPrefixedEnv("DOUBAN_REQUEST_INTERVAL"); intervalEnv != "" {
+		d, err := time.ParseDuration(intervalEnv)
+		if err != nil || d < 0 {
+			return Metadata{}, fmt.Errorf("douban request interval is not a duration")
+		}
+		doubanInterval = d
+	}
+
 	domain := readPrefixedEnv("COOKIECLOUD_DOMAIN")
 	if domain == "" {
 		domain = "douban.com"
 	}
 
+	coverSource := strings.ToLower(readPrefixedEnv("METADATA_COVER_SOURCE"))
+	switch coverSource {
+	case "":
+		coverSource = "none"
+	case "none", "openlibrary":
+	default:
+		return Metadata{}, fmt.Errorf("metadata cover source must be none or openlibrary")
+	}
+
+	var providers []string
+	for _, name := range strings.Split(readPrefixedEnv("METADATA_PROVIDERS"), ",") {
+		if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+			providers = append(providers, name)
+		}
+	}
+	if len(providers) == 0 {
+		// the chain of the single provider settings: the DOI record over
+		// the file, the ISBN provider filling in
+		if strings.ToLower(doiProvider) == "crossref" {
+			providers = append(providers, "crossref")
+		}
+		providers = append(providers, "file")
+		if strings.ToLower(provider) == "douban" {
+			providers = append(providers, "douban")
+		}
+	}
+
 	return Metadata{
 		Provider:            provider,
 		DoubanCookie:        readPrefixedEnv("DOUBAN_COOKIE"),
+		DoubanInterval:      doubanInterval,
 		CookieCloudURL:      readPrefixedEnv("COOKIECLOUD_URL"),
 		CookieCloudUUID:     readPrefixedEnv("COOKIECLOUD_UUID"),
 		CookieCloudPassword: readPrefixedEnv("COOKIECLOUD_PASSWORD"),
 		CookieCloudDomain:   domain,
+		DOIProvider:         doiProvider,
+		CrossrefMailto:      readPrefixedEnv("CROSSREF_MAILTO"),
+		GoogleBooksAPIKey:   readPrefixedEnv("GOOGLE_BOOKS_API_KEY"),
+		Providers:           providers,
+		FieldOrder:          readPrefixedEnv("METADATA_FIELD_ORDER"),
+		CoverSource:         coverSource,
+	}, nil
+}
+
+func readSMTPConfig() (SMTP, error) {
+	port := 587
+	if portEnv := readPrefixedEnv("SMTP_PORT"); portEnv != "" {
+		p, err := strconv.Atoi(portEnv)
+		if err != nil {
+			return SMTP{}, fmt.Errorf("smtp port is not a number")
+		}
+		port = p
+	}
+
+	username := readPrefixedEnv("SMTP_USERNAME")
+	from := readPrefixedEnv("SMTP_FROM")
+	if from == "" {
+		from = username
+	}
+
+	return SMTP{
+		Host:     readPrefixedEnv("SMTP_HOST"),
+		Port:     port,
+		Username: username,
+		Password: readPrefixedEnv("SMTP_PASSWORD"),
+		From:     from,
+	}, nil
+}
+
+func readDigestConfig() (Digest, error) {
+	var to []string
+	for _, address := range strings.Split(readPrefixedEnv("DIGEST_TO"), ",") {
+		if address = strings.TrimSpace(address); address == "" {
+			continue
+		}
+		if _, err := mail.ParseAddress(address); err != nil {
+			return Digest{}, fmt.Errorf("digest address %q is not an email address", address)
+		}
+		to = append(to, address)
+	}
+
+	interval := 7 * 24 * time.Hour
+	if intervalEnv := readPrefixedEnv("DIGEST_INTERVAL"); intervalEnv != "" {
+		d, err := time.ParseDuration(intervalEnv)
+		if err != nil || d <= 0 {
+			return Digest{}, fmt.Errorf("digest interval is not a positive duration")
+		}
+		interval = d
+	}
+
+	return Digest{To: to, Interval: interval}, nil
+}
+
+func readConverterConfig() (Converter, error) {
+	workers := 1
+	if workersEnv := readPrefixedEnv("CONVERTER_WORKERS"); workersEnv != "" {
+		n, err := strconv.Atoi(workersEnv)
+		if err != nil || n < 0 {
+			return Converter{}, fmt.Errorf("converter workers is not a number")
+		}
+		workers = n
 	}
+
+	jobTimeout := 10 * time.Minute
+	if timeoutEnv := readPrefixedEnv("CONVERTER_JOB_TIMEOUT"); timeoutEnv != "" {
+		d, err := time.ParseDuration(timeoutEnv)
+		if err != nil || d <= 0 {
+			return Converter{}, fmt.Errorf("converter job timeout is not a positive duration")
+		}
+		jobTimeout = d
+	}
+
+	return Converter{
+		Binary:     readPrefixedEnv("EBOOK_CONVERT"),
+		Workers:    workers,
+		JobTimeout: jobTimeout,
+	}, nil
+}
+
+func readBackupConfig() (Backup, error) {
+	interval := 24 * time.Hour
+	if intervalEnv := readPrefixedEnv("BACKUP_INTERVAL"); intervalEnv != "" {
+		d, err := time.ParseDuration(intervalEnv)
+		if err != nil {
+			return Backup{}, fmt.Errorf("backup interval is not a duration")
+		}
+		interval = d
+	}
+
+	schedule := readPrefixedEnv("BACKUP_SCHEDULE")
+	if schedule != "" {
+		if _, err := cron.Parse(schedule); err != nil {
+			return Backup{}, fmt.Errorf("backup schedule: %w", err)
+		}
+	}
+
+	var files bool
+	if filesEnv := readPrefixedEnv("BACKUP_FILES"); filesEnv != "" {
+		b, err := strconv.ParseBool(filesEnv)
+		if err != nil {
+			return Backup{}, fmt.Errorf("backup files is not a boolean")
+		}
+		files = b
+	}
+
+	retention := 7
+	if retentionEnv := readPrefixedEnv("BACKUP_RETENTION"); retentionEnv != "" {
+		r, err := strconv.Atoi(retentionEnv)
+		if err != nil {
+			return Backup{}, fmt.Errorf("backup retention is not a number")
+		}
+		retention = r
+	}
+
+	verifyInterval := 7 * 24 * time.Hour
+	if intervalEnv := readPrefixedEnv("BACKUP_VERIFY_INTERVAL"); intervalEnv != "" {
+		d, err := time.ParseDuration(intervalEnv)
+		if err != nil {
+			return Backup{}, fmt.Errorf("backup verify interval is not a duration")
+		}
+		verifyInterval = d
+	}
+
+	verifySamples := 5
+	if samplesEnv := readPrefixedEnv("BACKUP_VERIFY_SAMPLES"); samplesEnv != "" {
+		n, err := strconv.Atoi(samplesEnv)
+		if err != nil {
+			return Backup{}, fmt.Errorf("backup verify samples is not a number")
+		}
+		verifySamples = n
+	}
+
+	return Backup{
+		Path:       readPrefixedEnv("BACKUP_PATH"),
+		Interval:   interval,
+		Schedule:   schedule,
+		Files:      files,
+		Retention:  retention,
+		PGDump:     readPrefixedEnv("BACKUP_PG_DUMP"),
+		UploadType: readPrefixedEnv("BACKUP_UPLOAD_TYPE"),
+		UploadPath: readPrefixedEnv("BACKUP_UPLOAD_PATH"),
+
+		VerifyInterval: verifyInterval,
+		VerifySamples:  verifySamples,
+		PGRestore:      readPrefixedEnv("BACKUP_PG_RESTORE"),
+		PSQL:           readPrefixedEnv("BACKUP_PSQL"),
+	}, nil
+}
+
+func readCoverCacheConfig() (CoverCache, error) {
+	path := readPrefixedEnv("COVER_CACHE_PATH")
+	if path == "" {
+		path = filepath.Join(os.TempDir(), "kompanion-covers")
+	}
+
+	sizeMB := 256
+	if sizeEnv := readPrefixedEnv("COVER_CACHE_SIZE"); sizeEnv != "" {
+		n, err := strconv.Atoi(sizeEnv)
+		if err != nil {
+			return CoverCache{}, fmt.Errorf("cover cache size is not a number")
+		}
+		sizeMB = n
+	}
+
+	return CoverCache{
+		Path:        path,
+		MaxSize:     int64(sizeMB) << 20,
+		WebPEncoder: readPrefixedEnv("CWEBP"),
+		AVIFEncoder: readPrefixedEnv("AVIFENC"),
+	}, nil
+}
+
+func readCacheConfig() (Cache, error) {
+	c := Cache{
+		RedisURL: readPrefixedEnv("CACHE_REDIS_URL"),
+		MaxSize:  64 << 20,
+		TTL:      5 * time.Minute,
+	}
+	if c.RedisURL != "" {
+		if _, err := cache.NewRedis(c.RedisURL); err != nil {
+			return Cache{}, fmt.Errorf("cache redis url is not like redis://host:6379/0")
+		}
+	}
+	if sizeEnv := readPrefixedEnv("CACHE_SIZE"); sizeEnv != "" {
+		n, err := strconv.Atoi(sizeEnv)
+		if err != nil || n < 0 {
+			return Cache{}, fmt.Errorf("cache size is not a number")
+		}
+		c.MaxSize = int64(n) << 20
+	}
+	if ttlEnv := readPrefixedEnv("CACHE_TTL"); ttlEnv != "" {
+		d, err := time.ParseDuration(ttlEnv)
+		if err != nil || d <= 0 {
+			return Cache{}, fmt.Errorf("cache ttl is not a duration")
+		}
+		c.TTL = d
+	}
+	return c, nil
+}
+
+func readDownloadsConfig() (Downloads, error) {
+	ttl := 15 * time.Minute
+	if ttlEnv := readPrefixedEnv("DOWNLOAD_LINK_TTL"); ttlEnv != "" {
+		d, err := time.ParseDuration(ttlEnv)
+		if err != nil {
+			return Downloads{}, fmt.Errorf("download link ttl is not a duration")
+		}
+		ttl = d
+	}
+
+	return Downloads{
+		Secret:  readPrefixedEnv("DOWNLOAD_SECRET"),
+		LinkTTL: ttl,
+	}, nil
+}
+
+func readCDNConfig() (CDN, error) {
+	ttl := time.Hour
+	if ttlEnv := readPrefixedEnv("CDN_LINK_TTL"); ttlEnv != "" {
+		d, err := time.ParseDuration(ttlEnv)
+		if err != nil || d <= 0 {
+			return CDN{}, fmt.Errorf("cdn link ttl is not a positive duration")
+		}
+		ttl = d
+	}
+
+	return CDN{
+		URL:        readPrefixedEnv("CDN_URL"),
+		KeyPairID:  readPrefixedEnv("CDN_KEY_PAIR_ID"),
+		PrivateKey: readPrefixedEnv("CDN_PRIVATE_KEY"),
+		LinkTTL:    ttl,
+	}, nil
+}
+
+func readLibraryConfig() (Library, error) {
+	storage := readPrefixedEnv("LIBRARY_STORAGE")
+	if storage == "" {
+		storage = "postgres"
+	}
+	if storage != "postgres" && storage != "sqlite" {
+		return Library{}, fmt.Errorf("library storage must be postgres or sqlite")
+	}
+	sqlitePath := readPrefixedEnv("LIBRARY_SQLITE_PATH")
+	if sqlitePath == "" {
+		sqlitePath = "kompanion.db"
+	}
+
+	verifyInterval := 7 * 24 * time.Hour
+	if intervalEnv := readPrefixedEnv("LIBRARY_VERIFY_INTERVAL"); intervalEnv != "" {
+		d, err := time.ParseDuration(intervalEnv)
+		if err != nil {
+			return Library{}, fmt.Errorf("library verify interval is not a duration")
+		}
+		verifyInterval = d
+	}
+
+	var filenamePatterns []string
+	for _, pattern := range strings.Split(readPrefixedEnv("FILENAME_PATTERNS"), ";") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			filenamePatterns = append(filenamePatterns, pattern)
+		}
+	}
+
+	maxPageSize := 100
+	if sizeEnv := readPrefixedEnv("LIBRARY_MAX_PAGE_SIZE"); sizeEnv != "" {
+		n, err := strconv.Atoi(sizeEnv)
+		if err != nil || n < 1 {
+			return Library{}, fmt.Errorf("library max page size is not a positive number")
+		}
+		maxPageSize = n
+	}
+	adminMaxPageSize := max(1000, maxPageSize)
+	if sizeEnv := readPrefixedEnv("LIBRARY_ADMIN_MAX_PAGE_SIZE"); sizeEnv != "" {
+		n, err := strconv.Atoi(sizeEnv)
+		if err != nil || n < 1 {
+			return Library{}, fmt.Errorf("library admin max page size is not a positive number")
+		}
+		adminMaxPageSize = n
+	}
+
+	return Library{
+		Storage:          storage,
+		SQLitePath:       sqlitePath,
+		VerifyInterval:   verifyInterval,
+		Unrar:            readPrefixedEnv("UNRAR"),
+		PathTemplate:     readPrefixedEnv("LIBRARY_PATH_TEMPLATE"),
+		FilenamePatterns: filenamePatterns,
+		MaxPageSize:      maxPageSize,
+		AdminMaxPageSize: adminMaxPageSize,
+	}, nil
+}
+
+func readUploadsConfig() (Uploads, error) {
+	var sizeMB int
+	if sizeEnv := readPrefixedEnv("UPLOAD_MAX_SIZE"); sizeEnv != "" {
+		n, err := strconv.Atoi(sizeEnv)
+		if err != nil || n < 0 {
+			return Uploads{}, fmt.Errorf("upload max size is not a number of megabytes")
+		}
+		sizeMB = n
+	}
+
+	var maxBooks int
+	if booksEnv := readPrefixedEnv("UPLOAD_MAX_BOOKS"); booksEnv != "" {
+		n, err := strconv.Atoi(booksEnv)
+		if err != nil || n < 0 {
+			return Uploads{}, fmt.Errorf("upload max books is not a number")
+		}
+		maxBooks = n
+	}
+
+	var quotaMB int
+	if quotaEnv := readPrefixedEnv("UPLOAD_QUOTA"); quotaEnv != "" {
+		n, err := strconv.Atoi(quotaEnv)
+		if err != nil || n < 0 {
+			return Uploads{}, fmt.Errorf("upload quota is not a number of megabytes")
+		}
+		quotaMB = n
+	}
+
+	var formats []string
+	for _, format := range strings.Split(readPrefixedEnv("UPLOAD_FORMATS"), ",") {
+		if format = strings.TrimSpace(format); format != "" {
+			formats = append(formats, format)
+		}
+	}
+
+	return Uploads{
+		MaxSize:  int64(sizeMB) << 20,
+		Formats:  formats,
+		MaxBooks: maxBooks,
+		Quota:    int64(quotaMB) << 20,
+	}, nil
 }
 
 func readPrefixedEnv(key string) string {
 	envKey := fmt.Sprintf("KOMPANION_%s", strings.ToUpper(key))
 	return os.Getenv(envKey)
 }
+
+func readOutboundConfig() (Outbound, error) {
+	timeout := 8 * time.Second
+	if timeoutEnv := readPrefixedEnv("HTTP_CLIENT_TIMEOUT"); timeoutEnv != "" {
+		d, err := time.ParseDuration(timeoutEnv)
+		if err != nil || d <= 0 {
+			return Outbound{}, fmt.Errorf("http client timeout is not a duration")
+		}
+		timeout = d
+	}
+
+	return Outbound{
+		Proxy:   readPrefixedEnv("HTTP_PROXY"),
+		CAFile:  readPrefixedEnv("HTTP_CA_FILE"),
+		Timeout: timeout,
+	}, nil
+}
+
+func readExtensionsConfig() (Extensions, error) {
+	timeout := 10 * time.Second
+	if timeoutEnv := readPrefixedEnv("EXTENSION_TIMEOUT"); timeoutEnv != "" {
+		d, err := time.ParseDuration(timeoutEnv)
+		if err != nil || d <= 0 {
+			return Extensions{}, fmt.Errorf("extension timeout is not a duration")
+		}
+		timeout = d
+	}
+
+	var commands []string
+	for _, command := range strings.Split(readPrefixedEnv("EXTENSIONS"), ",") {
+		if command = strings.TrimSpace(command); command != "" {
+			commands = append(commands, command)
+		}
+	}
+
+	return Extensions{
+		Commands: commands,
+		Timeout:  timeout,
+	}, nil
+}
+
+func readHooksConfig() (Hooks, error) {
+	timeout := 30 * time.Second
+	if timeoutEnv := readPrefixedEnv("HOOK_TIMEOUT"); timeoutEnv != "" {
+		d, err := time.ParseDuration(timeoutEnv)
+		if err != nil || d <= 0 {
+			return Hooks{}, fmt.Errorf("hook timeout is not a duration")
+		}
+		timeout = d
+	}
+
+	return Hooks{
+		BookAdded:    strings.TrimSpace(readPrefixedEnv("ON_BOOK_ADDED")),
+		BookFinished: strings.TrimSpace(readPrefixedEnv("ON_BOOK_FINISHED")),
+		Timeout:      timeout,
+	}, nil
+}
+
+func readWebhooksConfig() Webhooks {
+	webhooks := Webhooks{Secret: readPrefixedEnv("WEBHOOK_SECRET")}
+	for _, url := range strings.Split(readPrefixedEnv("WEBHOOK_URLS"), ",") {
+		if url = strings.TrimSpace(url); url != "" {
+			webhooks.URLs = append(webhooks.URLs, url)
+		}
+	}
+	for _, event := range strings.Split(readPrefixedEnv("WEBHOOK_EVENTS"), ",") {
+		if event = strings.TrimSpace(event); event != "" {
+			webhooks.Events = append(webhooks.Events, event)
+		}
+	}
+	return webhooks
+}
+
+func readTracingConfig() (Tracing, error) {
+	tracing := Tracing{
+		Endpoint:    strings.TrimSpace(readPrefixedEnv("TRACING_ENDPOINT")),
+		Service:     readPrefixedEnv("TRACING_SERVICE_NAME"),
+		Headers:     make(map[string]string),
+		SampleRatio: 1,
+	}
+	if tracing.Service == "" {
+		tracing.Service = "kompanion"
+	}
+	for _, header := range strings.Split(readPrefixedEnv("TRACING_HEADERS"), ",") {
+		if strings.TrimSpace(header) == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(header, "=")
+		if !ok || strings.TrimSpace(key) == "" {
+			return Tracing{}, fmt.Errorf("tracing header %q is not key=value", header)
+		}
+		tracing.Headers[strings.TrimSpace(key)] = strings.TrimSpace(value)
+	}
+	if ratioEnv := readPrefixedEnv("TRACING_SAMPLE_RATIO"); ratioEnv != "" {
+		ratio, err := strconv.ParseFloat(ratioEnv, 64)
+		if err != nil || ratio < 0 || ratio > 1 {
+			return Tracing{}, fmt.Errorf("tracing sample ratio is not a number from 0 to 1")
+		}
+		tracing.SampleRatio = ratio
+	}
+	return tracing, nil
+}
+
+func readRateLimitConfig() (RateLimit, error) {
+	rateLimit := RateLimit{
+		Store:  readPrefixedEnv("RATE_LIMIT_STORE"),
+		Sync:   readPrefixedEnv("RATE_LIMIT_SYNC"),
+		Search: readPrefixedEnv("RATE_LIMIT_SEARCH"),
+		API:    readPrefixedEnv("RATE_LIMIT_API"),
+	}
+	switch rateLimit.Store {
+	case "":
+		rateLimit.Store = "memory"
+	case "memory", "postgres":
+	default:
+		return RateLimit{}, fmt.Errorf("rate limit store must be memory or postgres")
+	}
+	for _, limit := range []string{rateLimit.Sync, rateLimit.Search, rateLimit.API} {
+		if _, _, err := ratelimit.ParseLimit(limit); err != nil {
+			return RateLimit{}, err
+		}
+	}
+	return rateLimit, nil
+}
+
+func readSyncConfig() (Sync, error) {
+	conflicts := readPrefixedEnv("SYNC_CONFLICTS")
+	if conflicts == "" {
+		conflicts = "latest"
+	}
+	if conflicts != "latest" && conflicts != "furthest" && conflicts != "keep-both" {
+		return Sync{}, fmt.Errorf("sync conflicts must be latest, furthest or keep-both")
+	}
+	return Sync{Conflicts: conflicts}, nil
+}
+
+func readOIDCConfig() (OIDC, error) {
+	issuer := readPrefixedEnv("OIDC_ISSUER")
+	if issuer == "" {
+		return OIDC{}, nil
+	}
+	clientID := readPrefixedEnv("OIDC_CLIENT_ID")
+	redirectURL := readPrefixedEnv("OIDC_REDIRECT_URL")
+	if clientID == "" || redirectURL == "" {
+		return OIDC{}, fmt.Errorf("oidc client id and redirect url are required with an issuer")
+	}
+
+	name := readPrefixedEnv("OIDC_NAME")
+	if name == "" {
+		name = "SSO"
+	}
+
+	autoProvision := false
+	if provisionEnv := readPrefixedEnv("OIDC_AUTO_PROVISION"); provisionEnv != "" {
+		b, err := strconv.ParseBool(provisionEnv)
+		if err != nil {
+			return OIDC{}, fmt.Errorf("oidc auto provision is not a boolean")
+		}
+		autoProvision = b
+	}
+
+	var scopes []string
+	for _, scope := range strings.FieldsFunc(readPrefixedEnv("OIDC_SCOPES"), func(r rune) bool { return r == ',' || r == ' ' }) {
+		if scope != "openid" {
+			scopes = append(scopes, scope)
+		}
+	}
+
+	return OIDC{
+		Issuer:        issuer,
+		ClientID:      clientID,
+		ClientSecret:  readPrefixedEnv("OIDC_CLIENT_SECRET"),
+		RedirectURL:   redirectURL,
+		Name:          name,
+		Scopes:        scopes,
+		UsernameClaim: readPrefixedEnv("OIDC_USERNAME_CLAIM"),
+		AutoProvision: autoProvision,
+	}, nil
+}
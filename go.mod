@@ -6,6 +6,7 @@ toolchain go1.23.0
 
 require (
 	github.com/Eun/go-hit v0.5.23
+	github.com/andybalholm/brotli v1.1.1
 	github.com/dustinkirkland/golang-petname v0.0.0-20240428194347-eebcea082ee0
 	github.com/foolin/goview v0.3.0
 	github.com/gin-gonic/gin v1.7.7
@@ -17,15 +18,16 @@ require (
 	github.com/prometheus/client_golang v1.11.0
 	github.com/rs/zerolog v1.26.1
 	github.com/shopspring/decimal v1.4.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
 	github.com/stretchr/testify v1.9.0
 	github.com/wcharczuk/go-chart/v2 v2.1.0
 	golang.org/x/crypto v0.31.0
+	golang.org/x/image v0.18.0
 )
 
 require (
 	github.com/golang/freetype v0.0.0-20170609003504-e2365dfdc4a0 // indirect
 	github.com/jackc/puddle/v2 v2.2.1 // indirect
-	golang.org/x/image v0.18.0 // indirect
 	golang.org/x/sync v0.10.0 // indirect
 )
 
@@ -0,0 +1,76 @@
+package annotations
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+// AnnotationDatabaseRepo -.
+type AnnotationDatabaseRepo struct {
+	*postgres.Postgres
+}
+
+// NewAnnotationDatabaseRepo -.
+func NewAnnotationDatabaseRepo(pg *postgres.Postgres) *AnnotationDatabaseRepo {
+	return &AnnotationDatabaseRepo{pg}
+}
+
+func (r *AnnotationDatabaseRepo) Upsert(ctx context.Context, a entity.Annotation) error {
+	sql := `
+		INSERT INTO annotation_entry
+			(koreader_partial_md5, kind, page, position, chapter, text, note, color, auth_device_name, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		ON CONFLICT (koreader_partial_md5, kind, page, position) DO UPDATE
+		SET chapter = EXCLUDED.chapter,
+			text = EXCLUDED.text,
+			note = EXCLUDED.note,
+			color = EXCLUDED.color,
+			auth_device_name = EXCLUDED.auth_device_name,
+			updated_at = NOW()
+	`
+	args := []interface{}{a.Document, a.Kind, a.Page, a.Position, a.Chapter, a.Text, a.Note, a.Color, a.AuthDeviceName, time.Unix(a.Timestamp, 0)}
+
+	_, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("AnnotationDatabaseRepo - Upsert - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (r *AnnotationDatabaseRepo) ListByDocument(ctx context.Context, document string) ([]entity.Annotation, error) {
+	query := `
+		SELECT koreader_partial_md5, kind, page, position, chapter, text, note, color, auth_device_name, created_at
+		FROM annotation_entry
+		WHERE koreader_partial_md5 = $1
+		ORDER BY page, position, created_at
+	`
+
+	rows, err := r.Pool.Query(ctx, query, document)
+	if err != nil {
+		return nil, fmt.Errorf("AnnotationDatabaseRepo - ListByDocument - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	annotations := make([]entity.Annotation, 0)
+	for rows.Next() {
+		var a entity.Annotation
+		var chapter, note, color sql.NullString
+		var createdAt time.Time
+		err = rows.Scan(&a.Document, &a.Kind, &a.Page, &a.Position, &chapter, &a.Text, &note, &color, &a.AuthDeviceName, &createdAt)
+		if err != nil {
+			return nil, fmt.Errorf("AnnotationDatabaseRepo - ListByDocument - rows.Scan: %w", err)
+		}
+		a.Chapter = chapter.String
+		a.Note = note.String
+		a.Color = color.String
+		a.Timestamp = createdAt.Unix()
+		annotations = append(annotations, a)
+	}
+
+	return annotations, nil
+}
@@ -0,0 +1,87 @@
+package annotations
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+var ErrUnknownFormat = errors.New("unknown export format")
+
+const (
+	FormatMarkdown = "markdown"
+	FormatJSON     = "json"
+)
+
+// AnnotationSyncUseCase -.
+type AnnotationSyncUseCase struct {
+	repo AnnotationRepo
+}
+
+// NewAnnotationSync -.
+func NewAnnotationSync(r AnnotationRepo) *AnnotationSyncUseCase {
+	return &AnnotationSyncUseCase{repo: r}
+}
+
+// Import stores annotations pushed by a device, updating the ones already known
+// by their place in the document.
+func (uc *AnnotationSyncUseCase) Import(ctx context.Context, document string, deviceName string, annotations []entity.Annotation) (int, error) {
+	imported := 0
+	for _, a := range annotations {
+		a.Document = document
+		a.AuthDeviceName = deviceName
+		a.Kind = normalizeKind(a.Kind)
+		if a.Timestamp == 0 {
+			a.Timestamp = time.Now().Unix()
+		}
+		if a.Kind != entity.AnnotationBookmark && strings.TrimSpace(a.Text) == "" && strings.TrimSpace(a.Note) == "" {
+			continue
+		}
+
+		err := uc.repo.Upsert(ctx, a)
+		if err != nil {
+			return imported, fmt.Errorf("AnnotationSyncUseCase - Import - s.repo.Upsert: %w", err)
+		}
+		imported++
+	}
+	return imported, nil
+}
+
+func (uc *AnnotationSyncUseCase) List(ctx context.Context, document string) ([]entity.Annotation, error) {
+	annotations, err := uc.repo.ListByDocument(ctx, document)
+	if err != nil {
+		return nil, fmt.Errorf("AnnotationSyncUseCase - List - s.repo.ListByDocument: %w", err)
+	}
+	return annotations, nil
+}
+
+func (uc *AnnotationSyncUseCase) Export(ctx context.Context, book entity.Book, format string) ([]byte, error) {
+	annotations, err := uc.List(ctx, book.DocumentID)
+	if err != nil {
+		return nil, err
+	}
+
+	switch format {
+	case FormatMarkdown, "md", "":
+		return RenderMarkdown(book, annotations), nil
+	case FormatJSON:
+		return RenderJSON(book, annotations)
+	default:
+		return nil, fmt.Errorf("AnnotationSyncUseCase - Export - %s: %w", format, ErrUnknownFormat)
+	}
+}
+
+func normalizeKind(kind string) string {
+	switch strings.ToLower(kind) {
+	case entity.AnnotationNote:
+		return entity.AnnotationNote
+	case entity.AnnotationBookmark:
+		return entity.AnnotationBookmark
+	default:
+		return entity.AnnotationHighlight
+	}
+}
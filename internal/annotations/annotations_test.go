@@ -0,0 +1,105 @@
+package annotations_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/annotations"
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+func TestImportSkipsEmptyHighlights(t *testing.T) {
+	repo := &fakeAnnotationRepo{}
+	uc := annotations.NewAnnotationSync(repo)
+
+	n, err := uc.Import(context.Background(), "doc", "kindle", []entity.Annotation{
+		{Kind: "highlight", Page: 3, Text: "It was a bright cold day"},
+		{Kind: "highlight", Page: 4},
+		{Kind: "bookmark", Page: 10},
+		{Kind: "unknown", Page: 12, Text: "falls back to highlight"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n != 3 {
+		t.Fatalf("expected 3 imported annotations, got %d", n)
+	}
+	for _, a := range repo.stored {
+		if a.Document != "doc" || a.AuthDeviceName != "kindle" {
+			t.Fatalf("expected document and device to be set, got %+v", a)
+		}
+	}
+	if repo.stored[2].Kind != entity.AnnotationHighlight {
+		t.Fatalf("expected unknown kind to become highlight, got %q", repo.stored[2].Kind)
+	}
+}
+
+func TestExportMarkdownGroupsByChapter(t *testing.T) {
+	repo := &fakeAnnotationRepo{stored: []entity.Annotation{
+		{Kind: "highlight", Page: 1, Chapter: "Part One", Text: "first"},
+		{Kind: "highlight", Page: 2, Chapter: "Part One", Text: "second", Note: "my note"},
+		{Kind: "bookmark", Page: 9, Chapter: "Part Two"},
+	}}
+	uc := annotations.NewAnnotationSync(repo)
+
+	out, err := uc.Export(context.Background(), entity.Book{Title: "1984", Author: "George Orwell"}, annotations.FormatMarkdown)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	md := string(out)
+	if strings.Count(md, "## Part One") != 1 || !strings.Contains(md, "## Part Two") {
+		t.Fatalf("expected one heading per chapter, got:\n%s", md)
+	}
+	if !strings.Contains(md, "> second") || !strings.Contains(md, "my note") {
+		t.Fatalf("expected highlight text and note, got:\n%s", md)
+	}
+	if !strings.Contains(md, "Bookmark, page 9") {
+		t.Fatalf("expected bookmark line, got:\n%s", md)
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	uc := annotations.NewAnnotationSync(&fakeAnnotationRepo{})
+
+	out, err := uc.Export(context.Background(), entity.Book{Title: "1984", DocumentID: "doc"}, annotations.FormatJSON)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var decoded struct {
+		Document    string              `json:"document"`
+		Annotations []entity.Annotation `json:"annotations"`
+	}
+	if err := json.Unmarshal(out, &decoded); err != nil {
+		t.Fatalf("expected valid json: %v", err)
+	}
+	if decoded.Document != "doc" || decoded.Annotations == nil {
+		t.Fatalf("unexpected export: %s", out)
+	}
+}
+
+func TestExportUnknownFormat(t *testing.T) {
+	uc := annotations.NewAnnotationSync(&fakeAnnotationRepo{})
+
+	_, err := uc.Export(context.Background(), entity.Book{}, "pdf")
+	if !errors.Is(err, annotations.ErrUnknownFormat) {
+		t.Fatalf("expected ErrUnknownFormat, got %v", err)
+	}
+}
+
+type fakeAnnotationRepo struct {
+	stored []entity.Annotation
+}
+
+func (r *fakeAnnotationRepo) Upsert(_ context.Context, a entity.Annotation) error {
+	r.stored = append(r.stored, a)
+	return nil
+}
+
+func (r *fakeAnnotationRepo) ListByDocument(context.Context, string) ([]entity.Annotation, error) {
+	return r.stored, nil
+}
@@ -0,0 +1,75 @@
+package annotations
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// RenderMarkdown renders annotations grouped by chapter, in the order they
+// appear in the document.
+func RenderMarkdown(book entity.Book, annotations []entity.Annotation) []byte {
+	var b strings.Builder
+
+	b.WriteString("# " + book.Title + "\n")
+	if book.Author != "" {
+		b.WriteString("\n_" + book.Author + "_\n")
+	}
+
+	chapter := ""
+	for i, a := range annotations {
+		if a.Chapter != chapter || i == 0 {
+			chapter = a.Chapter
+			if chapter != "" {
+				b.WriteString("\n## " + chapter + "\n")
+			}
+		}
+
+		b.WriteString("\n")
+		switch a.Kind {
+		case entity.AnnotationBookmark:
+			fmt.Fprintf(&b, "- Bookmark, page %d\n", a.Page)
+		default:
+			for _, line := range strings.Split(strings.TrimSpace(a.Text), "\n") {
+				b.WriteString("> " + line + "\n")
+			}
+			if a.Note != "" {
+				b.WriteString("\n" + strings.TrimSpace(a.Note) + "\n")
+			}
+			fmt.Fprintf(&b, "\n<sub>page %d", a.Page)
+			if a.Timestamp > 0 {
+				b.WriteString(", " + time.Unix(a.Timestamp, 0).UTC().Format("2006-01-02 15:04"))
+			}
+			b.WriteString("</sub>\n")
+		}
+	}
+
+	return []byte(b.String())
+}
+
+type jsonExport struct {
+	Title       string              `json:"title"`
+	Author      string              `json:"author"`
+	Document    string              `json:"document"`
+	Annotations []entity.Annotation `json:"annotations"`
+}
+
+// RenderJSON renders annotations in a shape close to KOReader's own JSON exporter.
+func RenderJSON(book entity.Book, annotations []entity.Annotation) ([]byte, error) {
+	if annotations == nil {
+		annotations = []entity.Annotation{}
+	}
+	data, err := json.MarshalIndent(jsonExport{
+		Title:       book.Title,
+		Author:      book.Author,
+		Document:    book.DocumentID,
+		Annotations: annotations,
+	}, "", "  ")
+	if err != nil {
+		return nil, fmt.Errorf("RenderJSON - json.Marshal: %w", err)
+	}
+	return data, nil
+}
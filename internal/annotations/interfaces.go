@@ -0,0 +1,22 @@
+package annotations
+
+import (
+	"context"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+type (
+	// Annotations -.
+	Annotations interface {
+		Import(ctx context.Context, document string, deviceName string, annotations []entity.Annotation) (int, error)
+		List(ctx context.Context, document string) ([]entity.Annotation, error)
+		Export(ctx context.Context, book entity.Book, format string) ([]byte, error)
+	}
+
+	// AnnotationRepo -.
+	AnnotationRepo interface {
+		Upsert(ctx context.Context, annotation entity.Annotation) error
+		ListByDocument(ctx context.Context, document string) ([]entity.Annotation, error)
+	}
+)
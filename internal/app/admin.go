@@ -0,0 +1,498 @@
+package app
+
+import (
+	"context"
+	"crypto/sha1"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/banjuer/kompanion/config"
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/calibre"
+	"github.com/banjuer/kompanion/pkg/comic"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+const adminUsage = `usage: kompanion [command]
+
+Without a command the server is started. Maintenance commands work on the
+configured database and book storage, the server may keep running:
+
+  reindex                          rebuild the library search indexes
+  covers [--all]                   extract missing covers again, --all replaces every cover
+  covers --dedup                   move covers to content addressed files shared by books, record cover sizes
+  verify                           check book files against their hashes and record the issues
+  rescan                           fill empty metadata fields from the book files
+  ingests [--clean]                list uploads that failed after writing their files,
+                                   --clean removes what they left in storage
+  book archive <book id>           keep a book exactly as stored, edits and deletion fail
+  book unarchive <book id>         allow edits and deletion of an archived book again
+  import <dir> [--tags] [--collections] [--depth <n>] [--dry-run]
+                                   store the books of a directory tree, --tags adds the folder
+                                   names to their tags, --collections puts each folder in a
+                                   collection, --dry-run lists them without storing
+  formats prune [--keep <formats>] [--drop <formats>] [--exclude <book ids>] [--dry-run]
+                                   delete redundant formats of books with several files, the
+                                   other formats once a book has a --keep one (epub,azw3) and
+                                   --drop ones (mobi) always, --dry-run shows the space freed
+  calibre [host[:port]] [--password <password>] [--delete]
+                                   connect to Calibre as a wireless device until it ejects,
+                                   Calibre is found on the network without a host, --delete
+                                   lets deleting a book in Calibre delete it from the library
+  user add <username> <password>   add a web account, a reader
+  user list                        list web accounts with their roles
+  user role <username> <role>      make a web account an admin, editor or reader
+  user merge <from> <into>         move shelves, reviews and downloads to another account
+  user delete <username> [--dry-run]
+                                   delete a web account with its sessions, shelves and reviews
+  device list                      list active devices
+  device add <name> <password>     add a device
+  device deactivate <name>         deactivate a device
+  device merge <from> <into>       move progress, annotations and statistics to another device
+  device delete <name> [--dry-run] delete a device with its progress, annotations and statistics
+  restore [backup id]              restore a backup, the server must be stopped
+  migrate                          apply the database migrations this version ships
+  migrate version                  show the database schema version
+`
+
+var errUsage = errors.New("invalid command")
+
+// Admin runs a maintenance command, args are the command line arguments
+// without the program name. Results are written to out.
+func Admin(cfg *config.Config, args []string, out io.Writer) error {
+	if len(args) == 0 || args[0] == "help" || args[0] == "-h" || args[0] == "--help" {
+		fmt.Fprint(out, adminUsage)
+		return nil
+	}
+	if args[0] == "restore" {
+		runID := ""
+		if len(args) > 1 {
+			runID = args[1]
+		}
+		Restore(cfg, runID)
+		return nil
+	}
+
+	l := logger.New(cfg.Log.Level)
+	if args[0] == "migrate" {
+		return adminMigrate(cfg, args, l, out)
+	}
+
+	pg, err := postgres.New(cfg.PG.URL, postgres.MaxPoolSize(cfg.PG.PoolMax))
+	if err != nil {
+		return fmt.Errorf("app - Admin - postgres.New: %w", err)
+	}
+	defer pg.Close()
+
+	ctx := context.Background()
+	switch args[0] {
+	case "reindex", "covers", "verify", "rescan", "ingests", "book", "import", "formats", "calibre":
+		bookStorage, err := storage.NewStorage(cfg.BookStorage.Type, cfg.BookStorage.Path, pg)
+		if err != nil {
+			return fmt.Errorf("app - Admin - storage.NewStorage: %w", err)
+		}
+		bookRepo, closeBookRepo, err := newBookRepo(cfg, pg, l)
+		if err != nil {
+			return fmt.Errorf("app - Admin - %w", err)
+		}
+		defer closeBookRepo()
+		shelf := library.NewBookShelf(bookStorage, bookRepo, l)
+		shelf.SetMetadataChain(newMetadataChain(cfg, loadExtensions(cfg, l), l))
+		shelf.SetCoverSource(newCoverSource(cfg, l))
+		shelf.SetComicReader(comic.New(cfg.Library.Unrar))
+		if args[0] == "calibre" {
+			err = adminCalibre(ctx, shelf, args[1:], l, out)
+		} else {
+			err = adminLibrary(ctx, shelf, args, out)
+		}
+		if errors.Is(err, errUsage) {
+			fmt.Fprint(out, adminUsage)
+		}
+		return err
+	case "user", "device":
+		if cfg.Auth.Storage != "postgres" {
+			return fmt.Errorf("app - Admin - accounts are kept in %s storage, not in the database", cfg.Auth.Storage)
+		}
+		bookRepo, closeBookRepo, err := newBookRepo(cfg, pg, l)
+		if err != nil {
+			return fmt.Errorf("app - Admin - %w", err)
+		}
+		defer closeBookRepo()
+		authService := auth.InitAuthService(auth.NewUserDatabaseRepo(pg), cfg.Auth.Username, cfg.Auth.Password)
+		authService.SetAccountDataRepo(newAccountDataRepo(pg, bookRepo))
+		authService.SetAPIKeyRepo(auth.NewAPIKeyDatabaseRepo(pg))
+		err = adminAccounts(ctx, authService, args, out)
+		if errors.Is(err, errUsage) {
+			fmt.Fprint(out, adminUsage)
+		}
+		return err
+	default:
+		fmt.Fprint(out, adminUsage)
+		return fmt.Errorf("app - Admin - %w: %s", errUsage, args[0])
+	}
+}
+
+func adminMigrate(cfg *config.Config, args []string, l logger.Interface, out io.Writer) error {
+	var version schemaVersion
+	var err error
+	switch {
+	case len(args) == 1:
+		version, err = migrateUp(cfg.PG.URL, l)
+	case len(args) == 2 && args[1] == "version":
+		version, err = migrateVersion(cfg.PG.URL, l)
+	default:
+		fmt.Fprint(out, adminUsage)
+		return fmt.Errorf("app - Admin - %w: %s", errUsage, strings.Join(args, " "))
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, version)
+	if cfg.Library.Storage != "sqlite" {
+		return nil
+	}
+	if len(args) == 1 {
+		version, err = migrateUp(sqliteURL(cfg.Library.SQLitePath), l)
+	} else {
+		version, err = migrateVersion(sqliteURL(cfg.Library.SQLitePath), l)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "sqlite library:", version)
+	return nil
+}
+
+func adminLibrary(ctx context.Context, shelf *library.BookShelf, args []string, out io.Writer) error {
+	var report library.MaintenanceReport
+	var err error
+	switch {
+	case args[0] == "reindex" && len(args) == 1:
+		if err = shelf.Reindex(ctx); err != nil {
+			return err
+		}
+		fmt.Fprintln(out, "library reindexed")
+		return nil
+	case args[0] == "covers" && len(args) == 1:
+		report, err = shelf.RebuildCovers(ctx, false)
+	case args[0] == "covers" && len(args) == 2 && args[1] == "--all":
+		report, err = shelf.RebuildCovers(ctx, true)
+	case args[0] == "covers" && len(args) == 2 && args[1] == "--dedup":
+		report, err = shelf.DedupCovers(ctx)
+	case args[0] == "verify" && len(args) == 1:
+		return adminVerify(ctx, shelf, out)
+	case args[0] == "rescan" && len(args) == 1:
+		report, err = shelf.RescanMetadata(ctx)
+	case args[0] == "ingests" && len(args) == 1:
+		return adminIngests(ctx, shelf, out)
+	case args[0] == "ingests" && len(args) == 2 && args[1] == "--clean":
+		report, err = shelf.CleanIngests(ctx)
+	case args[0] == "book" && len(args) == 3 && (args[1] == "archive" || args[1] == "unarchive"):
+		return adminArchive(ctx, shelf, args[1] == "archive", args[2], out)
+	case args[0] == "import" && len(args) >= 2:
+		return adminImport(ctx, shelf, args[1:], out)
+	case args[0] == "formats" && len(args) >= 2 && args[1] == "prune":
+		return adminPrune(ctx, shelf, args[2:], out)
+	default:
+		return fmt.Errorf("app - Admin - %w: %s", errUsage, strings.Join(args, " "))
+	}
+
+	for _, p := range report.Problems {
+		fmt.Fprintf(out, "%s %q: %s\n", p.BookID, p.Title, p.Problem)
+	}
+	fmt.Fprintf(out, "%d books, %d changed, %d problems\n", report.Books, report.Changed, len(report.Problems))
+	return err
+}
+
+func adminVerify(ctx context.Context, shelf *library.BookShelf, out io.Writer) error {
+	check, issues, err := shelf.VerifyLibrary(ctx)
+	for _, issue := range issues {
+		fmt.Fprintf(out, "%s %q: %s %s\n", issue.BookID, issue.Title, issue.Kind, issue.Detail)
+	}
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%d books, %d issues\n", check.Books, check.Issues)
+	if check.Issues > 0 {
+		return fmt.Errorf("app - Admin - %d issues found", check.Issues)
+	}
+	return nil
+}
+
+func adminIngests(ctx context.Context, shelf *library.BookShelf, out io.Writer) error {
+	entries, err := shelf.Ingests(ctx)
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		fmt.Fprintf(out, "%s %s book %s file %s cover %q: %s\n", e.UpdatedAt.Format(time.RFC3339), e.State, e.BookID, e.FilePath, e.CoverPath, e.Detail)
+	}
+	fmt.Fprintf(out, "%d uploads\n", len(entries))
+	return nil
+}
+
+func adminArchive(ctx context.Context, shelf *library.BookShelf, archive bool, bookID string, out io.Writer) error {
+	var book entity.Book
+	var err error
+	if archive {
+		book, err = shelf.ArchiveBook(ctx, bookID)
+	} else {
+		book, err = shelf.UnarchiveBook(ctx, bookID)
+	}
+	if err != nil {
+		return err
+	}
+	if book.Archived() {
+		fmt.Fprintf(out, "%s %q archived since %s\n", book.ID, book.Title, book.ArchivedAt.Format(time.RFC3339))
+	} else {
+		fmt.Fprintf(out, "%s %q is not archived\n", book.ID, book.Title)
+	}
+	return nil
+}
+
+func adminImport(ctx context.Context, shelf *library.BookShelf, args []string, out io.Writer) error {
+	var opts library.ImportOptions
+	root, dryRun := "", false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--tags":
+			opts.Tags = true
+		case args[i] == "--collections":
+			opts.Collections = true
+		case args[i] == "--dry-run":
+			dryRun = true
+		case args[i] == "--depth" && i+1 < len(args):
+			depth, err := strconv.Atoi(args[i+1])
+			if err != nil || depth < 0 {
+				return fmt.Errorf("app - Admin - %w: --depth %s", errUsage, args[i+1])
+			}
+			opts.Depth = depth
+			i++
+		case root == "" && !strings.HasPrefix(args[i], "-"):
+			root = args[i]
+		default:
+			return fmt.Errorf("app - Admin - %w: import %s", errUsage, strings.Join(args, " "))
+		}
+	}
+	if root == "" {
+		return fmt.Errorf("app - Admin - %w: import needs a directory", errUsage)
+	}
+
+	plan, err := library.PlanImport(root, opts)
+	if err != nil {
+		return err
+	}
+	for _, tag := range plan.Tags {
+		fmt.Fprintf(out, "tag %q: %d books\n", tag.Value, tag.Count)
+	}
+	for _, collection := range plan.Collections {
+		fmt.Fprintf(out, "collection %q: %d books\n", collection.Value, collection.Count)
+	}
+	if dryRun {
+		for _, file := range plan.Files {
+			fmt.Fprintf(out, "%s\ttags %s\tcollection %q\n", file.Path, strings.Join(file.Tags, ", "), file.Collection)
+		}
+		fmt.Fprintf(out, "%d files, nothing stored\n", len(plan.Files))
+		return nil
+	}
+
+	report, err := shelf.ImportDirectory(ctx, root, plan)
+	for _, p := range report.Problems {
+		fmt.Fprintf(out, "%s: %s\n", p.Title, p.Problem)
+	}
+	fmt.Fprintf(out, "%d files, %d books stored, %d problems\n", report.Books, report.Changed, len(report.Problems))
+	return err
+}
+
+func adminPrune(ctx context.Context, shelf *library.BookShelf, args []string, out io.Writer) error {
+	var policy library.PrunePolicy
+	dryRun := false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--keep" && i+1 < len(args):
+			policy.Keep = strings.Split(args[i+1], ",")
+			i++
+		case args[i] == "--drop" && i+1 < len(args):
+			policy.Drop = strings.Split(args[i+1], ",")
+			i++
+		case args[i] == "--exclude" && i+1 < len(args):
+			policy.Exclude = append(policy.Exclude, strings.Split(args[i+1], ",")...)
+			i++
+		case args[i] == "--dry-run":
+			dryRun = true
+		default:
+			return fmt.Errorf("app - Admin - %w: formats prune %s", errUsage, strings.Join(args, " "))
+		}
+	}
+	if policy.Keep == nil && policy.Drop == nil {
+		policy.Keep, policy.Drop = []string{"epub", "azw3"}, []string{"mobi"}
+	}
+
+	report, err := shelf.PruneFormats(ctx, policy, dryRun)
+	for _, f := range report.Files {
+		fmt.Fprintf(out, "%s %q: %s %d bytes\n", f.Book.ID, f.Book.Title, f.File.Format, f.File.FileSize)
+	}
+	for _, p := range report.Problems {
+		fmt.Fprintf(out, "%s %q: %s\n", p.BookID, p.Title, p.Problem)
+	}
+	verb := "freed"
+	if dryRun {
+		verb = "reclaimable, nothing deleted"
+	}
+	fmt.Fprintf(out, "%d books with several formats, %d files, %.1f MB %s, %d problems\n",
+		report.Books, len(report.Files), float64(report.Bytes)/(1<<20), verb, len(report.Problems))
+	return err
+}
+
+// calibreDiscoverTimeout is how long to look for Calibre on the network.
+const calibreDiscoverTimeout = 10 * time.Second
+
+func adminCalibre(ctx context.Context, shelf *library.BookShelf, args []string, l logger.Interface, out io.Writer) error {
+	address, password, allowDelete := "", "", false
+	for i := 0; i < len(args); i++ {
+		switch {
+		case args[i] == "--password" && i+1 < len(args):
+			password = args[i+1]
+			i++
+		case args[i] == "--delete":
+			allowDelete = true
+		case address == "" && !strings.HasPrefix(args[i], "-"):
+			address = args[i]
+		default:
+			return fmt.Errorf("app - Admin - %w: calibre %s", errUsage, strings.Join(args, " "))
+		}
+	}
+
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	if address == "" {
+		discoverCtx, cancel := context.WithTimeout(ctx, calibreDiscoverTimeout)
+		defer cancel()
+		var err error
+		if address, err = calibre.Discover(discoverCtx); err != nil {
+			return fmt.Errorf("app - Admin - no calibre found, start its wireless device connection: %w", err)
+		}
+	} else if _, _, err := net.SplitHostPort(address); err != nil {
+		address = net.JoinHostPort(address, strconv.Itoa(calibre.DefaultPort))
+	}
+
+	// Calibre caches the metadata of a device under its uuid
+	hostname, _ := os.Hostname()
+	sum := sha1.Sum([]byte("kompanion " + hostname))
+	lib := shelf.CalibreLibrary(allowDelete)
+	device := &calibre.Device{
+		Name:       "kompanion",
+		UUID:       fmt.Sprintf("%x-%x-%x-%x-%x", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16]),
+		Password:   password,
+		Extensions: lib.Extensions(),
+		Library:    lib,
+		Logger:     l,
+	}
+	fmt.Fprintf(out, "connecting to calibre at %s, eject the device in calibre to stop\n", address)
+	if err := device.Connect(ctx, address); err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "calibre ejected the library")
+	return nil
+}
+
+func adminAccounts(ctx context.Context, a *auth.AuthService, args []string, out io.Writer) error {
+	var result auth.MergeResult
+	var err error
+	command := strings.Join(args[:min(len(args), 2)], " ")
+	switch {
+	case command == "user add" && len(args) == 4:
+		if err = a.RegisterUser(ctx, args[2], args[3]); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "user %s added\n", args[2])
+		return nil
+	case command == "user list" && len(args) == 2:
+		users, err := a.ListUsers(ctx)
+		if err != nil {
+			return err
+		}
+		for _, user := range users {
+			fmt.Fprintf(out, "%s\t%s\n", user.Username, user.Role)
+		}
+		return nil
+	case command == "user role" && len(args) == 4:
+		if err = a.SetUserRole(ctx, args[2], args[3]); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "user %s is %s now\n", args[2], args[3])
+		return nil
+	case command == "user merge" && len(args) == 4:
+		result, err = a.MergeUsers(ctx, args[2], args[3])
+	case command == "device list" && len(args) == 2:
+		devices, err := a.ListDevices(ctx)
+		if err != nil {
+			return err
+		}
+		for _, device := range devices {
+			fmt.Fprintln(out, device.Name)
+		}
+		return nil
+	case command == "device add" && len(args) == 4:
+		if err = a.AddUserDevice(ctx, args[2], args[3]); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "device %s added\n", args[2])
+		return nil
+	case command == "device deactivate" && len(args) == 3:
+		if err = a.DeactivateUserDevice(ctx, args[2]); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "device %s deactivated\n", args[2])
+		return nil
+	case command == "device merge" && len(args) == 4:
+		result, err = a.MergeDevices(ctx, args[2], args[3])
+	case (command == "user delete" || command == "device delete") && (len(args) == 3 || len(args) == 4 && args[3] == "--dry-run"):
+		return adminDelete(ctx, a, args[0], args[2], len(args) == 4, out)
+	default:
+		return fmt.Errorf("app - Admin - %w: %s", errUsage, strings.Join(args, " "))
+	}
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "%s merged into %s: %d progress, %d annotations, %d statistics books, %d downloads, %d reading statuses, %d reviews\n",
+		args[2], args[3], result.Progress, result.Annotations, result.StatsBooks, result.Downloads, result.ReadingStatus, result.Reviews)
+	return nil
+}
+
+func adminDelete(ctx context.Context, a *auth.AuthService, kind, name string, dryRun bool, out io.Writer) error {
+	var report auth.DeletionReport
+	var err error
+	if kind == "user" {
+		report, err = a.DeleteUserData(ctx, name, dryRun)
+	} else {
+		report, err = a.DeleteDeviceData(ctx, name, dryRun)
+	}
+	if err != nil {
+		return err
+	}
+	if report.Empty() {
+		return fmt.Errorf("app - Admin - %s %s not found", kind, name)
+	}
+
+	verb := "deleted"
+	if dryRun {
+		verb = "would be deleted"
+	}
+	fmt.Fprintf(out, "%s %s %s: %d sessions, %d progress, %d annotations, %d statistics books, %d statistics pages, %d reading statuses, %d reviews, %d share links, %d loans; %d downloads and %d collections anonymized\n",
+		kind, name, verb, report.Sessions, report.Progress, report.Annotations, report.StatsBooks, report.StatsPages, report.ReadingStatus, report.Reviews, report.ShareLinks, report.Loans, report.Downloads, report.Collections)
+	return nil
+}
@@ -2,6 +2,7 @@
 package app
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"os"
@@ -13,25 +14,64 @@ import (
 	"github.com/gin-gonic/gin"
 
 	"github.com/banjuer/kompanion/config"
+	"github.com/banjuer/kompanion/internal/annotations"
 	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/backup"
 	"github.com/banjuer/kompanion/internal/bookmeta"
+	"github.com/banjuer/kompanion/internal/controller/http/middleware"
 	"github.com/banjuer/kompanion/internal/controller/http/opds"
 	v1 "github.com/banjuer/kompanion/internal/controller/http/v1"
 	"github.com/banjuer/kompanion/internal/controller/http/web"
 	"github.com/banjuer/kompanion/internal/controller/http/webdav"
+	"github.com/banjuer/kompanion/internal/digest"
 	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
 	"github.com/banjuer/kompanion/internal/stats"
 	"github.com/banjuer/kompanion/internal/storage"
 	"github.com/banjuer/kompanion/internal/sync"
+	"github.com/banjuer/kompanion/pkg/cdn"
+	"github.com/banjuer/kompanion/pkg/comic"
+	"github.com/banjuer/kompanion/pkg/converter"
+	"github.com/banjuer/kompanion/pkg/cron"
+	"github.com/banjuer/kompanion/pkg/diskcache"
+	"github.com/banjuer/kompanion/pkg/extension"
+	"github.com/banjuer/kompanion/pkg/httpclient"
 	"github.com/banjuer/kompanion/pkg/httpserver"
 	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/mailer"
+	"github.com/banjuer/kompanion/pkg/oidc"
 	"github.com/banjuer/kompanion/pkg/postgres"
+	"github.com/banjuer/kompanion/pkg/sentry"
+	"github.com/banjuer/kompanion/pkg/signedurl"
+	"github.com/banjuer/kompanion/pkg/thumbnail"
+	"github.com/banjuer/kompanion/pkg/tracing"
 )
 
+// schedulerRetry is how often a server without the scheduler lock tries to
+// take it over, and how often the one holding it checks its connection.
+const schedulerRetry = 30 * time.Second
+
+// loanExpiryCheck is how often loans that expired are ended and both
+// accounts told.
+const loanExpiryCheck = 5 * time.Minute
+
 // Run creates objects via constructors.
 func Run(cfg *config.Config) {
 	l := logger.New(cfg.Log.Level)
 
+	// Schema
+	if cfg.PG.Migrate {
+		version, err := migrateUp(cfg.PG.URL, l)
+		if err != nil {
+			l.Fatal(fmt.Errorf("app - Run - migrateUp: %w", err))
+		}
+		l.Info("app - Run - %s", version)
+	} else if version, err := migrateVersion(cfg.PG.URL, l); err != nil {
+		l.Warn("app - Run - migrateVersion: %s", err)
+	} else if version.Current < version.Latest || version.Dirty {
+		l.Warn("app - Run - %s, run kompanion migrate", version)
+	}
+
 	// Repository
 	pg, err := postgres.New(cfg.PG.URL, postgres.MaxPoolSize(cfg.PG.PoolMax))
 	if err != nil {
@@ -43,6 +83,10 @@ func Run(cfg *config.Config) {
 	if err != nil {
 		l.Fatal(fmt.Errorf("app - Run - storage.NewStorage: %w", err))
 	}
+	tracer := newTracer(cfg, l)
+	if tracer != nil {
+		bookStorage = storage.Traced(bookStorage)
+	}
 
 	// Use case
 	var repo auth.UserRepo
@@ -59,16 +103,166 @@ func Run(cfg *config.Config) {
 		cfg.Auth.Username,
 		cfg.Auth.Password,
 	)
+	authService.SetAPIKeyRepo(auth.NewAPIKeyDatabaseRepo(pg))
+	authService.SetIdentityRepo(auth.NewIdentityDatabaseRepo(pg), cfg.OIDC.AutoProvision)
 	progress := sync.NewProgressSync(sync.NewProgressDatabaseRepo(pg))
-	metadataProvider := newMetadataProvider(cfg, l)
-	shelf := library.NewBookShelf(bookStorage, library.NewBookDatabaseRepo(pg), l, metadataProvider)
+	progress.SetConflictStrategy(cfg.Sync.Conflicts, sync.NewConflictDatabaseRepo(pg))
+	instanceSettings := settings.NewInstanceSettings(settings.NewSettingsDatabaseRepo(pg))
+	features, err := settings.FeaturesWithout(cfg.Features.Disabled)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Run - settings.FeaturesWithout: %w", err))
+	}
+	instanceSettings.SetFeatureDefaults(features)
+	extensions := loadExtensions(cfg, l)
+	bookRepo, closeBookRepo, err := newBookRepo(cfg, pg, l)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Run - newBookRepo: %w", err))
+	}
+	defer closeBookRepo()
+	authService.SetAccountDataRepo(newAccountDataRepo(pg, bookRepo))
+	shelf := library.NewBookShelf(bookStorage, bookRepo, l)
+	shelf.SetMetadataChain(newMetadataChain(cfg, extensions, l))
+	shelf.SetCoverSource(newCoverSource(cfg, l))
+	shelf.SetMetadataRules(instanceSettings)
+	shelf.SetUploadPreferences(authService)
+	for _, e := range extensions {
+		if e.description.Handles(extension.HookPostIngest) {
+			shelf.AddIngestHook(e)
+		}
+		if e.description.Handles(extension.HookPreDownload) {
+			shelf.AddDownloadHook(e)
+		}
+	}
+	if cfg.Hooks.BookAdded != "" {
+		shelf.AddIngestHook(commandHook{extension.NewCommand(cfg.Hooks.BookAdded, cfg.Hooks.Timeout), l})
+	}
+	if cfg.Hooks.BookFinished != "" {
+		shelf.AddFinishHook(commandHook{extension.NewCommand(cfg.Hooks.BookFinished, cfg.Hooks.Timeout), l})
+	}
+	if len(cfg.Webhooks.URLs) > 0 {
+		webhooks := newWebhookHooks(cfg, l)
+		shelf.AddIngestHook(webhooks)
+		shelf.AddDeleteHook(webhooks)
+		shelf.AddFinishHook(webhooks)
+		shelf.AddLoanHook(webhooks)
+		progress.AddHook(webhooks)
+	}
+	bookConverter := featureConverter{converter.New(cfg.Converter.Binary), instanceSettings}
+	shelf.SetConverter(bookConverter)
+	var smtpMailer *mailer.SMTP
+	if cfg.SMTP.Host != "" {
+		smtpMailer = mailer.New(cfg.SMTP.Host, cfg.SMTP.Port, cfg.SMTP.Username, cfg.SMTP.Password, cfg.SMTP.From)
+		shelf.SetDelivery(smtpMailer, bookConverter)
+	}
+	coverCache, err := diskcache.New(cfg.CoverCache.Path, cfg.CoverCache.MaxSize)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Run - diskcache.New: %w", err))
+	}
+	shelf.SetCoverCache(coverCache)
+	shelf.SetCoverEncoder(thumbnail.NewEncoder(cfg.CoverCache.WebPEncoder, cfg.CoverCache.AVIFEncoder))
+	if responseCache := newCache(cfg); responseCache != nil {
+		shelf.SetCache(responseCache, cfg.Cache.TTL)
+	}
+	shelf.SetComicReader(comic.New(cfg.Library.Unrar))
+	pathTemplate, err := library.ParsePathTemplate(cfg.Library.PathTemplate)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Run - library.ParsePathTemplate: %w", err))
+	}
+	shelf.SetPathTemplate(pathTemplate)
+	filenamePatterns, err := library.ParseFilenamePatterns(cfg.Library.FilenamePatterns)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Run - library.ParseFilenamePatterns: %w", err))
+	}
+	shelf.SetFilenamePatterns(filenamePatterns)
+	shelf.SetPageLimits(library.PageLimits{Max: cfg.Library.MaxPageSize, AdminMax: cfg.Library.AdminMaxPageSize})
+	shelf.SetUploadLimits(library.UploadLimits{
+		MaxSize:  cfg.Uploads.MaxSize,
+		Formats:  cfg.Uploads.Formats,
+		MaxBooks: cfg.Uploads.MaxBooks,
+		Quota:    cfg.Uploads.Quota,
+	})
+	if cfg.CDN.URL != "" {
+		shelf.SetCDN(newCDN(cfg, l))
+	}
+	downloadLinks, err := signedurl.New([]byte(cfg.Downloads.Secret), cfg.Downloads.LinkTTL)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Run - signedurl.New: %w", err))
+	}
+	registerMetrics(pg, shelf)
 	rs := stats.NewKOReaderPGStats(pg)
+	annotationSync := annotations.NewAnnotationSync(annotations.NewAnnotationDatabaseRepo(pg))
+	backups := newBackupService(cfg, pg, shelf, bookStorage, l)
+	if cfg.Backup.Files {
+		backups.EnableSnapshots(bookStorage)
+	}
+
+	// servers sharing the database run each job once, the scheduled jobs
+	// run on the one holding the scheduler lock
+	locker := postgres.NewLocker(cfg.PG.URL)
+	defer locker.Close()
+	backups.SetJobLock(locker)
+	shelf.SetJobLock(locker)
+	shelf.SetIngestLock(locker)
+	// and the workers of all of them take turns on the queued jobs
+	shelf.SetJobQueue(library.NewJobDatabaseRepo(pg), library.JobOptions{
+		Worker:  workerName(),
+		Workers: cfg.Converter.Workers,
+		Timeout: cfg.Converter.JobTimeout,
+	})
+
+	rateLimit, rateBuckets := newRateLimit(cfg, pg, l)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if tracer != nil {
+		go tracer.Run(ctx, func(err error) { l.Error("app - Run - tracer.Run: %s", err) })
+	}
+	go shelf.RunJobs(ctx)
+	go locker.Lead(ctx, "scheduler", schedulerRetry, func(ctx context.Context) {
+		l.Info("app - Run - running the scheduled jobs")
+		if cfg.Backup.Path != "" && cfg.Backup.Schedule != "" {
+			// validated by config
+			schedule, _ := cron.Parse(cfg.Backup.Schedule)
+			go backups.ScheduleCron(ctx, schedule)
+		} else if cfg.Backup.Path != "" && cfg.Backup.Interval > 0 {
+			go backups.Schedule(ctx, cfg.Backup.Interval)
+		}
+		if cfg.Backup.Path != "" && cfg.Backup.VerifyInterval > 0 {
+			go backups.ScheduleVerify(ctx, cfg.Backup.VerifyInterval)
+		}
+		if cfg.Library.VerifyInterval > 0 {
+			go shelf.ScheduleVerifyLibrary(ctx, cfg.Library.VerifyInterval)
+		}
+		go shelf.ScheduleEndExpiredLoans(ctx, loanExpiryCheck)
+		if smtpMailer != nil && len(cfg.Digest.To) > 0 {
+			go digest.New(shelf, instanceSettings, smtpMailer, cfg.Digest.To, l).Schedule(ctx, cfg.Digest.Interval)
+		}
+		if rateBuckets != nil {
+			go pruneRateLimits(ctx, rateBuckets, l)
+		}
+		<-ctx.Done()
+	})
 
 	// HTTP Server
 	handler := gin.New()
-	web.NewRouter(handler, l, authService, progress, shelf, rs, cfg.Version)
-	v1.NewRouter(handler, l, authService, progress, shelf)
-	opds.NewRouter(handler, l, authService, progress, shelf)
+	// X-Forwarded-For is believed from the configured proxies only, the
+	// rate limits count clients by their IP
+	if err = handler.SetTrustedProxies(cfg.HTTP.TrustedProxies); err != nil {
+		l.Fatal(fmt.Errorf("app - Run - handler.SetTrustedProxies: %w", err))
+	}
+	if tracer != nil {
+		handler.Use(middleware.Tracing(tracer))
+	}
+	handler.Use(middleware.Recovery(l, newErrorReporter(cfg, l)))
+	handler.Use(middleware.Metrics())
+	if rateLimit != nil {
+		handler.Use(rateLimit)
+	}
+	handler.Use(middleware.Locale())
+	handler.Use(middleware.Compress())
+	web.NewRouter(handler, l, authService, progress, shelf, rs, annotationSync, instanceSettings, backups, downloadLinks, newSingleSignOn(cfg, l), cfg.Version)
+	v1.NewRouter(handler, l, authService, progress, shelf, annotationSync, instanceSettings, backups, downloadLinks)
+	opds.NewRouter(handler, l, authService, progress, shelf, instanceSettings)
 	webdav.NewRouter(handler, authService, l, rs, shelf)
 	httpServer := httpserver.New(handler, httpserver.Port(cfg.HTTP.Port))
 
@@ -90,11 +284,207 @@ func Run(cfg *config.Config) {
 	}
 }
 
-func newMetadataProvider(cfg *config.Config, l logger.Interface) bookmeta.Provider {
-	if strings.ToLower(cfg.Metadata.Provider) != "douban" {
+func newErrorReporter(cfg *config.Config, l logger.Interface) middleware.Reporter {
+	if cfg.Sentry.DSN == "" {
+		return nil
+	}
+	client, err := sentry.New(cfg.Sentry.DSN, cfg.Version, cfg.Sentry.Environment)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Run - sentry.New: %w", err))
+	}
+	client.SetHTTPClient(newHTTPClient(cfg, l))
+	return client
+}
+
+// workerName names this server in the jobs its workers claim.
+func workerName() string {
+	host, _ := os.Hostname()
+	return fmt.Sprintf("%s-%d", host, os.Getpid())
+}
+
+// featureConverter converts while the conversions feature is on.
+type featureConverter struct {
+	library.Converter
+	settings settings.Settings
+}
+
+func (c featureConverter) Convert(ctx context.Context, source, format string) (string, error) {
+	if features, _ := c.settings.Features(ctx); !features.Enabled(settings.FeatureConversions) {
+		return "", fmt.Errorf("featureConverter - Convert - %w", settings.ErrFeatureDisabled)
+	}
+	return c.Converter.Convert(ctx, source, format)
+}
+
+// newSingleSignOn is the OpenID Connect login, none without an issuer.
+func newSingleSignOn(cfg *config.Config, l logger.Interface) web.SingleSignOn {
+	if cfg.OIDC.Issuer == "" {
+		return web.SingleSignOn{}
+	}
+	client := oidc.New(oidc.Config{
+		Issuer:        cfg.OIDC.Issuer,
+		ClientID:      cfg.OIDC.ClientID,
+		ClientSecret:  cfg.OIDC.ClientSecret,
+		RedirectURL:   cfg.OIDC.RedirectURL,
+		Scopes:        cfg.OIDC.Scopes,
+		UsernameClaim: cfg.OIDC.UsernameClaim,
+	}, newHTTPClient(cfg, l))
+	return web.SingleSignOn{Client: client, Name: cfg.OIDC.Name}
+}
+
+// newHTTPClient is the client of requests leaving the server, through the
+// configured proxy and trusting the configured CA bundle.
+func newHTTPClient(cfg *config.Config, l logger.Interface) *http.Client {
+	client, err := httpclient.New(httpclient.Options{
+		Proxy:   cfg.Outbound.Proxy,
+		CAFile:  cfg.Outbound.CAFile,
+		Timeout: cfg.Outbound.Timeout,
+	})
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Run - httpclient.New: %w", err))
+	}
+	if cfg.Tracing.Endpoint != "" {
+		client.Transport = tracing.Transport(client.Transport)
+	}
+	return client
+}
+
+// Restore replaces the database and the book files with a backup, the latest
+// one when runID is empty. The server must be stopped.
+func Restore(cfg *config.Config, runID string) {
+	l := logger.New(cfg.Log.Level)
+
+	pg, err := postgres.New(cfg.PG.URL, postgres.MaxPoolSize(cfg.PG.PoolMax))
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Restore - postgres.New: %w", err))
+	}
+	defer pg.Close()
+
+	bookStorage, err := storage.NewStorage(cfg.BookStorage.Type, cfg.BookStorage.Path, pg)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Restore - storage.NewStorage: %w", err))
+	}
+	bookRepo, closeBookRepo, err := newBookRepo(cfg, pg, l)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Restore - newBookRepo: %w", err))
+	}
+	defer closeBookRepo()
+	shelf := library.NewBookShelf(bookStorage, bookRepo, l)
+	backups := newBackupService(cfg, pg, shelf, bookStorage, l)
+
+	restored, err := backups.Restore(context.Background(), runID)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Restore - backups.Restore: %w", err))
+	}
+	for _, path := range restored.Missing {
+		l.Warn("app - Restore - book file missing: %s", path)
+	}
+	l.Info("app - Restore - restored backup %s of %s, %d book files copied",
+		restored.Run.ID, restored.Run.StartedAt.Format(time.RFC3339), restored.Files)
+}
+
+func newBackupService(cfg *config.Config, pg *postgres.Postgres, shelf *library.BookShelf, bookStorage storage.Storage, l logger.Interface) *backup.BackupService {
+	var backupStorage, upload storage.Storage
+	if cfg.Backup.Path != "" {
+		fs, err := storage.NewFilesystemStorage(cfg.Backup.Path)
+		if err != nil {
+			l.Fatal(fmt.Errorf("app - Run - backup storage.NewFilesystemStorage: %w", err))
+		}
+		backupStorage = fs
+	}
+	if cfg.Backup.UploadType != "" {
+		st, err := storage.NewStorage(cfg.Backup.UploadType, cfg.Backup.UploadPath, pg)
+		if err != nil {
+			l.Fatal(fmt.Errorf("app - Run - backup upload storage.NewStorage: %w", err))
+		}
+		upload = st
+	}
+
+	backups := backup.NewBackupService(
+		backup.NewRunDatabaseRepo(pg),
+		backup.NewPGDump(cfg.Backup.PGDump, cfg.PG.URL),
+		shelf,
+		backupStorage,
+		upload,
+		cfg.Backup.Retention,
+		l,
+	)
+	backups.EnableVerification(
+		backup.NewPGRestore(cfg.Backup.PGRestore, cfg.Backup.PSQL, cfg.PG.URL),
+		backup.NewScratchDatabaseRepo(pg),
+		bookStorage,
+		cfg.Backup.VerifySamples,
+	)
+	return backups
+}
+
+func newCDN(cfg *config.Config, l logger.Interface) *cdn.Signer {
+	var key []byte
+	if cfg.CDN.PrivateKey != "" {
+		k, err := os.ReadFile(cfg.CDN.PrivateKey)
+		if err != nil {
+			l.Fatal(fmt.Errorf("app - Run - read cdn private key: %w", err))
+		}
+		key = k
+	}
+	signer, err := cdn.New(cfg.CDN.URL, cfg.CDN.KeyPairID, key, cfg.CDN.LinkTTL)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Run - cdn.New: %w", err))
+	}
+	return signer
+}
+
+// newCoverSource fetches covers for books without one, nil unless a
+// cover source is configured.
+func newCoverSource(cfg *config.Config, l logger.Interface) library.CoverSource {
+	if cfg.Metadata.CoverSource != "openlibrary" {
+		return nil
+	}
+	return bookmeta.NewOpenLibraryCovers(newHTTPClient(cfg, l))
+}
+
+// newMetadataChain orders the metadata providers, the extensions answering
+// metadata following them, nil when only the file metadata is used.
+func newMetadataChain(cfg *config.Config, extensions []extensionHooks, l logger.Interface) *bookmeta.Chain {
+	client := newHTTPClient(cfg, l)
+	var sources []bookmeta.Source
+	for _, name := range cfg.Metadata.Providers {
+		source := bookmeta.Source{Name: name}
+		switch name {
+		case bookmeta.FileSource:
+		case "openlibrary":
+			source.ISBN = bookmeta.NewOpenLibraryProvider(client)
+		case "googlebooks":
+			source.ISBN = bookmeta.NewGoogleBooksProvider(cfg.Metadata.GoogleBooksAPIKey, client)
+		case "crossref":
+			source.DOI = bookmeta.NewCrossrefProvider(cfg.Metadata.CrossrefMailto, client)
+		case "douban":
+			source.ISBN = newDoubanProvider(cfg, client, l)
+		default:
+			l.Fatal(fmt.Errorf("app - Run - unknown metadata provider %q, known are file, openlibrary, googlebooks, crossref, douban", name))
+		}
+		sources = append(sources, source)
+	}
+	for _, e := range extensions {
+		if e.description.Handles(extension.HookMetadata) {
+			sources = append(sources, bookmeta.Source{Name: e.description.Name, ISBN: e})
+		}
+	}
+
+	rules, err := bookmeta.ParseFieldRules(cfg.Metadata.FieldOrder)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Run - bookmeta.ParseFieldRules: %w", err))
+	}
+	chain, err := bookmeta.NewChain(sources, rules)
+	if err != nil {
+		l.Fatal(fmt.Errorf("app - Run - bookmeta.NewChain: %w", err))
+	}
+	if len(chain.Sources()) == 1 {
 		return nil
 	}
+	return chain
+}
 
+func newDoubanProvider(cfg *config.Config, client *http.Client, l logger.Interface) *bookmeta.DoubanProvider {
 	var cookieSource bookmeta.CookieSource
 	switch {
 	case strings.TrimSpace(cfg.Metadata.DoubanCookie) != "":
@@ -104,14 +494,15 @@ func newMetadataProvider(cfg *config.Config, l logger.Interface) bookmeta.Provid
 			cfg.Metadata.CookieCloudURL,
 			cfg.Metadata.CookieCloudUUID,
 			cfg.Metadata.CookieCloudPassword,
-			&http.Client{Timeout: 8 * time.Second},
+			client,
 		)
 	default:
+		// lookups fail with ErrNoCookie, the other providers still answer
 		l.Warn("app - Run - douban metadata provider enabled without cookie configuration")
-		return nil
 	}
 
-	provider := bookmeta.NewDoubanProvider(cookieSource, &http.Client{Timeout: 8 * time.Second})
+	provider := bookmeta.NewDoubanProvider(cookieSource, client)
 	provider.SetCookieDomain(cfg.Metadata.CookieCloudDomain)
+	provider.SetRequestInterval(cfg.Metadata.DoubanInterval)
 	return provider
 }
@@ -0,0 +1,20 @@
+package app
+
+import (
+	"github.com/banjuer/kompanion/config"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/pkg/cache"
+)
+
+// newCache returns the cache for listings and covers, nil when it is off.
+func newCache(cfg *config.Config) library.ResponseCache {
+	if cfg.Cache.RedisURL != "" {
+		// validated by config
+		redis, _ := cache.NewRedis(cfg.Cache.RedisURL)
+		return redis
+	}
+	if cfg.Cache.MaxSize == 0 {
+		return nil
+	}
+	return cache.NewMemory(cfg.Cache.MaxSize)
+}
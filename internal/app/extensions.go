@@ -0,0 +1,117 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/banjuer/kompanion/config"
+	"github.com/banjuer/kompanion/internal/bookmeta"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/pkg/extension"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+// extensionHooks runs the hooks of an external program for the library.
+type extensionHooks struct {
+	process     *extension.Process
+	description extension.Description
+}
+
+// loadExtensions asks each configured program for the hooks it handles.
+func loadExtensions(cfg *config.Config, l logger.Interface) []extensionHooks {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	extensions := make([]extensionHooks, 0, len(cfg.Extensions.Commands))
+	for _, command := range cfg.Extensions.Commands {
+		process := extension.New(command, cfg.Extensions.Timeout)
+		description, err := process.Describe(ctx)
+		if err != nil {
+			l.Fatal(fmt.Errorf("app - Run - extension %s: %w", command, err))
+		}
+		l.Info("app - Run - extension %s handles %v", description.Name, description.Hooks)
+		extensions = append(extensions, extensionHooks{process: process, description: description})
+	}
+	return extensions
+}
+
+func (e extensionHooks) BookIngested(ctx context.Context, book entity.Book) error {
+	return e.process.Call(ctx, extension.HookPostIngest, extension.IngestRequest{Book: extensionBook(book)}, nil)
+}
+
+func (e extensionHooks) BeforeDownload(ctx context.Context, book entity.Book) error {
+	return e.process.Call(ctx, extension.HookPreDownload, extension.DownloadRequest{
+		Book:   extensionBook(book),
+		Format: book.Extension(),
+	}, nil)
+}
+
+func (e extensionHooks) LookupByISBN(ctx context.Context, isbn string) (bookmeta.LookupResult, error) {
+	var resp extension.MetadataResponse
+	if err := e.process.Call(ctx, extension.HookMetadata, extension.MetadataRequest{ISBN: isbn}, &resp); err != nil {
+		return bookmeta.LookupResult{}, err
+	}
+	return bookmeta.LookupResult{
+		Book: entity.Book{
+			Title:       resp.Book.Title,
+			Author:      resp.Book.Author,
+			Description: resp.Book.Description,
+			Publisher:   resp.Book.Publisher,
+			Year:        resp.Book.Year,
+			ISBN:        resp.Book.ISBN,
+			DOI:         resp.Book.DOI,
+			Series:      resp.Book.Series,
+			Language:    resp.Book.Language,
+			Genres:      resp.Book.Genres,
+		},
+		Cover: resp.Cover,
+	}, nil
+}
+
+// commandHook runs a shell command on a library event in the background, so
+// a slow command does not hold up the request. Its output is logged.
+type commandHook struct {
+	command *extension.Command
+	l       logger.Interface
+}
+
+func (h commandHook) BookIngested(ctx context.Context, book entity.Book) error {
+	h.run(ctx, extension.Event{Event: extension.EventBookAdded, Username: library.UploaderFrom(ctx), Book: extensionBook(book)})
+	return nil
+}
+
+func (h commandHook) BookFinished(ctx context.Context, username string, book entity.Book) error {
+	h.run(ctx, extension.Event{Event: extension.EventBookFinished, Username: username, Book: extensionBook(book)})
+	return nil
+}
+
+func (h commandHook) run(ctx context.Context, event extension.Event) {
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		output, err := h.command.Run(ctx, event)
+		if err != nil {
+			h.l.Error("app - hooks - %s %s: %s: %s", event.Event, event.Book.ID, err, output)
+			return
+		}
+		h.l.Info("app - hooks - %s %s: %s", event.Event, event.Book.ID, output)
+	}()
+}
+
+func extensionBook(book entity.Book) extension.Book {
+	return extension.Book{
+		ID:          book.ID,
+		Title:       book.Title,
+		Author:      book.Author,
+		Publisher:   book.Publisher,
+		Year:        book.Year,
+		ISBN:        book.ISBN,
+		DOI:         book.DOI,
+		Series:      book.Series,
+		Language:    book.Language,
+		Format:      book.Format,
+		Genres:      book.Genres,
+		Description: book.Description,
+	}
+}
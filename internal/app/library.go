@@ -0,0 +1,42 @@
+package app
+
+import (
+	"fmt"
+
+	"github.com/banjuer/kompanion/config"
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/postgres"
+	"github.com/banjuer/kompanion/pkg/sqlite"
+)
+
+// newBookRepo returns the repo of the configured library storage and the
+// func closing it. A SQLite library is migrated when it is opened, its
+// file belongs to this server alone.
+func newBookRepo(cfg *config.Config, pg *postgres.Postgres, l logger.Interface) (library.BookRepo, func(), error) {
+	if cfg.Library.Storage != "sqlite" {
+		return library.NewBookDatabaseRepo(pg), func() {}, nil
+	}
+	version, err := migrateUp(sqliteURL(cfg.Library.SQLitePath), l)
+	if err != nil {
+		return nil, nil, fmt.Errorf("app - newBookRepo - migrateUp: %w", err)
+	}
+	l.Info("app - newBookRepo - sqlite library %s", version)
+
+	db, err := sqlite.New(cfg.Library.SQLitePath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("app - newBookRepo - sqlite.New: %w", err)
+	}
+	return library.NewBookSQLiteRepo(db), db.Close, nil
+}
+
+// newAccountDataRepo merges and deletes the rows of accounts in the
+// database the library is kept in too.
+func newAccountDataRepo(pg *postgres.Postgres, bookRepo library.BookRepo) *auth.AccountDataDatabaseRepo {
+	accounts := auth.NewAccountDataDatabaseRepo(pg)
+	if sqliteRepo, ok := bookRepo.(*library.BookSQLiteRepo); ok {
+		accounts.SetLibraryDB(sqliteRepo.SQLite)
+	}
+	return accounts
+}
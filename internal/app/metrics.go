@@ -0,0 +1,24 @@
+package app
+
+import (
+	"context"
+
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/pkg/metrics"
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+// registerMetrics adds the library size and, for a real database, the
+// connection pool to the metrics served on /metrics.
+func registerMetrics(pg *postgres.Postgres, shelf *library.BookShelf) {
+	shelf.SetMetrics(metrics.Library{})
+	prometheus.MustRegister(metrics.NewStorageCollector(func(ctx context.Context) (int, int64, error) {
+		usage, err := shelf.LibraryUsage(ctx)
+		return usage.Books, usage.Bytes(), err
+	}))
+	if pool, ok := pg.Pool.(metrics.PoolStater); ok {
+		prometheus.MustRegister(metrics.NewPoolCollector(pool))
+	}
+}
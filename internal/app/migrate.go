@@ -1,70 +1,142 @@
-//go:build migrate
-
 package app
 
 import (
 	"errors"
-	"log"
-	"os"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"strings"
 	"time"
 
-	"github.com/golang-migrate/migrate/v4"
 	"github.com/banjuer/kompanion"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/golang-migrate/migrate/v4"
+	"github.com/golang-migrate/migrate/v4/source"
 
 	// migrate tools
 	_ "github.com/golang-migrate/migrate/v4/database/postgres"
+	_ "github.com/golang-migrate/migrate/v4/database/sqlite3"
 	"github.com/golang-migrate/migrate/v4/source/iofs"
 )
 
 const (
-	_defaultAttempts = 20
-	_defaultTimeout  = time.Second
+	_migrateAttempts = 20
+	_migrateTimeout  = time.Second
 )
 
-func init() {
-	databaseURL, ok := os.LookupEnv("KOMPANION_PG_URL")
-	if !ok || len(databaseURL) == 0 {
-		log.Fatalf("migrate: environment variable not declared: KOMPANION_PG_URL")
+// schemaVersion is the migration the database is at and the newest one
+// shipped with the binary. A dirty schema stopped in the middle of a
+// migration and needs a look by hand.
+type schemaVersion struct {
+	Current uint
+	Latest  uint
+	Dirty   bool
+}
+
+func (v schemaVersion) String() string {
+	s := fmt.Sprintf("database schema at version %d, latest %d", v.Current, v.Latest)
+	if v.Dirty {
+		s += ", dirty"
+	}
+	return s
+}
+
+// migrateUp applies the embedded migrations the database lacks. Servers
+// starting together take turns, the migrations run under a database lock.
+func migrateUp(databaseURL string, l logger.Interface) (schemaVersion, error) {
+	m, latest, err := newMigrate(databaseURL, l)
+	if err != nil {
+		return schemaVersion{}, err
+	}
+	defer m.Close()
+
+	if err = m.Up(); err != nil && !errors.Is(err, migrate.ErrNoChange) {
+		return schemaVersion{}, fmt.Errorf("app - migrateUp - m.Up: %w", err)
+	}
+	return readSchemaVersion(m, latest)
+}
+
+// migrateVersion reports the schema version without changing it.
+func migrateVersion(databaseURL string, l logger.Interface) (schemaVersion, error) {
+	m, latest, err := newMigrate(databaseURL, l)
+	if err != nil {
+		return schemaVersion{}, err
 	}
+	defer m.Close()
+	return readSchemaVersion(m, latest)
+}
 
-	databaseURL += "?sslmode=disable"
+func readSchemaVersion(m *migrate.Migrate, latest uint) (schemaVersion, error) {
+	current, dirty, err := m.Version()
+	if err != nil && !errors.Is(err, migrate.ErrNilVersion) {
+		return schemaVersion{}, fmt.Errorf("app - readSchemaVersion - m.Version: %w", err)
+	}
+	return schemaVersion{Current: current, Latest: latest, Dirty: dirty}, nil
+}
 
-	var (
-		attempts = _defaultAttempts
-		err      error
-		m        *migrate.Migrate
-	)
+// sqliteURL is the URL migrate opens the SQLite library at path with.
+func sqliteURL(path string) string {
+	return "sqlite3://" + path
+}
 
-	d, err := iofs.New(kompanion.Migrations, "migrations")
+// newMigrate connects to the database, waiting for it to come up, and
+// returns the newest embedded migration with it. A sqlite3 URL gets the
+// migrations of the SQLite library.
+func newMigrate(databaseURL string, l logger.Interface) (*migrate.Migrate, uint, error) {
+	var d source.Driver
+	var err error
+	if strings.HasPrefix(databaseURL, "sqlite3://") {
+		d, err = iofs.New(kompanion.SQLiteMigrations, "migrations/sqlite")
+	} else {
+		d, err = iofs.New(kompanion.Migrations, "migrations")
+		databaseURL = migrateURL(databaseURL)
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("app - newMigrate - iofs.New: %w", err)
+	}
+	latest, err := latestMigration(d)
 	if err != nil {
-		log.Fatal(err)
+		return nil, 0, fmt.Errorf("app - newMigrate - latestMigration: %w", err)
 	}
 
-	for attempts > 0 {
+	var m *migrate.Migrate
+	for attempts := _migrateAttempts; attempts > 0; attempts-- {
 		m, err = migrate.NewWithSourceInstance("iofs", d, databaseURL)
 		if err == nil {
-			break
+			return m, latest, nil
 		}
-
-		log.Printf("Migrate: postgres is trying to connect, attempts left: %d", attempts)
-		time.Sleep(_defaultTimeout)
-		attempts--
+		l.Info("app - newMigrate - database is trying to connect, attempts left: %d", attempts-1)
+		time.Sleep(_migrateTimeout)
 	}
+	return nil, 0, fmt.Errorf("app - newMigrate - migrate.NewWithSourceInstance: %w", err)
+}
 
+func latestMigration(d source.Driver) (uint, error) {
+	version, err := d.First()
 	if err != nil {
-		log.Fatalf("Migrate: postgres connect error: %s", err)
+		return 0, err
 	}
-
-	err = m.Up()
-	defer m.Close()
-	if err != nil && !errors.Is(err, migrate.ErrNoChange) {
-		log.Fatalf("Migrate: up error: %s", err)
+	for {
+		next, err := d.Next(version)
+		if errors.Is(err, fs.ErrNotExist) {
+			return version, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		version = next
 	}
+}
 
-	if errors.Is(err, migrate.ErrNoChange) {
-		log.Printf("Migrate: no change")
-		return
+// migrateURL turns TLS off unless the URL configures it, like the migrate
+// builds did before.
+func migrateURL(databaseURL string) string {
+	u, err := url.Parse(databaseURL)
+	if err != nil || u.Query().Has("sslmode") {
+		return databaseURL
 	}
-
-	log.Printf("Migrate: up success")
+	q := u.Query()
+	q.Set("sslmode", "disable")
+	u.RawQuery = q.Encode()
+	return u.String()
 }
@@ -0,0 +1,66 @@
+package app
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/config"
+	"github.com/banjuer/kompanion/internal/controller/http/middleware"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/postgres"
+	"github.com/banjuer/kompanion/pkg/ratelimit"
+)
+
+// rateLimitPrune is how often buckets that filled up again are deleted
+// from the database.
+const rateLimitPrune = 10 * time.Minute
+
+// newRateLimit returns the middleware enforcing the configured limits,
+// nil without any. Buckets kept in postgres are returned for pruning.
+func newRateLimit(cfg *config.Config, pg *postgres.Postgres, l logger.Interface) (gin.HandlerFunc, *ratelimit.Postgres) {
+	var scopes []middleware.RateScope
+	add := func(name, value string, match func(c *gin.Context) bool) {
+		// validated by config
+		if limit, ok, _ := ratelimit.ParseLimit(value); ok {
+			scopes = append(scopes, middleware.RateScope{Name: name, Limit: limit, Match: match})
+		}
+	}
+	add("sync", cfg.RateLimit.Sync, func(c *gin.Context) bool {
+		return strings.HasPrefix(c.Request.URL.Path, "/syncs/")
+	})
+	add("search", cfg.RateLimit.Search, func(c *gin.Context) bool {
+		path := strings.TrimSuffix(c.Request.URL.Path, "/")
+		return c.Query("q") != "" && (path == "/api/books" || path == "/books")
+	})
+	add("api", cfg.RateLimit.API, func(c *gin.Context) bool {
+		return strings.HasPrefix(c.Request.URL.Path, "/api/")
+	})
+	if len(scopes) == 0 {
+		return nil, nil
+	}
+
+	if cfg.RateLimit.Store == "postgres" {
+		store := ratelimit.NewPostgres(pg)
+		return middleware.RateLimit(store, l, scopes...), store
+	}
+	return middleware.RateLimit(ratelimit.NewMemory(), l, scopes...), nil
+}
+
+// pruneRateLimits deletes full buckets from the database until ctx is done.
+func pruneRateLimits(ctx context.Context, store *ratelimit.Postgres, l logger.Interface) {
+	ticker := time.NewTicker(rateLimitPrune)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := store.Prune(ctx); err != nil {
+				l.Error("app - pruneRateLimits - %s", err)
+			}
+		}
+	}
+}
@@ -0,0 +1,20 @@
+package app
+
+import (
+	"github.com/banjuer/kompanion/config"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/tracing"
+)
+
+// newTracer exports spans to the configured collector, nil when tracing
+// is off.
+func newTracer(cfg *config.Config, l logger.Interface) *tracing.Tracer {
+	if cfg.Tracing.Endpoint == "" {
+		return nil
+	}
+	tracer := tracing.New(cfg.Tracing.Endpoint, cfg.Tracing.Service, newHTTPClient(cfg, l))
+	tracer.SetHeaders(cfg.Tracing.Headers)
+	tracer.SetSampleRatio(cfg.Tracing.SampleRatio)
+	l.Info("app - Run - tracing to %s", cfg.Tracing.Endpoint)
+	return tracer
+}
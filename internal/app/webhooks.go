@@ -0,0 +1,127 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/config"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/pkg/extension"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/webhook"
+)
+
+// Events posted to webhooks.
+const (
+	webhookBookAdded       = "book.added"
+	webhookBookDeleted     = "book.deleted"
+	webhookBookFinished    = "book.finished"
+	webhookLoanStarted     = "loan.started"
+	webhookLoanEnded       = "loan.ended"
+	webhookProgressUpdated = "progress.updated"
+)
+
+var webhookEvents = []string{webhookBookAdded, webhookBookDeleted, webhookBookFinished, webhookLoanStarted, webhookLoanEnded, webhookProgressUpdated}
+
+type webhookBook struct {
+	Username string         `json:"username,omitempty"`
+	Book     extension.Book `json:"book"`
+}
+
+type webhookLoan struct {
+	Lender    string         `json:"lender"`
+	Borrower  string         `json:"borrower"`
+	ExpiresAt time.Time      `json:"expires_at"`
+	Book      extension.Book `json:"book"`
+}
+
+type webhookProgress struct {
+	Device     string  `json:"device"`
+	Document   string  `json:"document"`
+	Percentage float64 `json:"percentage"`
+	Progress   string  `json:"progress"`
+	Timestamp  int64   `json:"timestamp"`
+}
+
+// webhookHooks posts library events to the webhooks in the background,
+// deliveries that fail for good are logged.
+type webhookHooks struct {
+	sender *webhook.Sender
+	events map[string]bool
+	l      logger.Interface
+}
+
+func newWebhookHooks(cfg *config.Config, l logger.Interface) webhookHooks {
+	events := make(map[string]bool, len(webhookEvents))
+	for _, event := range cfg.Webhooks.Events {
+		known := false
+		for _, name := range webhookEvents {
+			known = known || name == event
+		}
+		if !known {
+			l.Fatal(fmt.Errorf("app - Run - unknown webhook event %q, known are %s", event, strings.Join(webhookEvents, ", ")))
+		}
+		events[event] = true
+	}
+	if len(events) == 0 {
+		for _, event := range webhookEvents {
+			events[event] = true
+		}
+	}
+	return webhookHooks{
+		sender: webhook.New(cfg.Webhooks.URLs, cfg.Webhooks.Secret, newHTTPClient(cfg, l)),
+		events: events,
+		l:      l,
+	}
+}
+
+func (h webhookHooks) BookIngested(ctx context.Context, book entity.Book) error {
+	h.send(ctx, webhookBookAdded, webhookBook{Username: library.UploaderFrom(ctx), Book: extensionBook(book)})
+	return nil
+}
+
+func (h webhookHooks) BookDeleted(ctx context.Context, book entity.Book) error {
+	h.send(ctx, webhookBookDeleted, webhookBook{Book: extensionBook(book)})
+	return nil
+}
+
+func (h webhookHooks) BookFinished(ctx context.Context, username string, book entity.Book) error {
+	h.send(ctx, webhookBookFinished, webhookBook{Username: username, Book: extensionBook(book)})
+	return nil
+}
+
+func (h webhookHooks) BookLent(ctx context.Context, loan entity.Loan, book entity.Book) error {
+	h.send(ctx, webhookLoanStarted, webhookLoan{Lender: loan.Lender, Borrower: loan.Borrower, ExpiresAt: loan.ExpiresAt, Book: extensionBook(book)})
+	return nil
+}
+
+func (h webhookHooks) LoanEnded(ctx context.Context, loan entity.Loan, book entity.Book) error {
+	h.send(ctx, webhookLoanEnded, webhookLoan{Lender: loan.Lender, Borrower: loan.Borrower, ExpiresAt: loan.ExpiresAt, Book: extensionBook(book)})
+	return nil
+}
+
+func (h webhookHooks) ProgressUpdated(ctx context.Context, doc entity.Progress) error {
+	h.send(ctx, webhookProgressUpdated, webhookProgress{
+		Device:     doc.AuthDeviceName,
+		Document:   doc.Document,
+		Percentage: doc.Percentage,
+		Progress:   doc.Progress,
+		Timestamp:  doc.Timestamp,
+	})
+	return nil
+}
+
+func (h webhookHooks) send(ctx context.Context, event string, data interface{}) {
+	if !h.events[event] {
+		return
+	}
+	ctx = context.WithoutCancel(ctx)
+	go func() {
+		if err := h.sender.Send(ctx, event, data); err != nil {
+			h.l.Error("app - webhooks - %s: %s", event, err)
+		}
+	}()
+}
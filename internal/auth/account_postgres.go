@@ -0,0 +1,346 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/banjuer/kompanion/pkg/postgres"
+	"github.com/banjuer/kompanion/pkg/sqlite"
+)
+
+type AccountDataDatabaseRepo struct {
+	*postgres.Postgres
+	// library runs the steps on tables of the library, Postgres unless
+	// the library is kept in SQLite
+	library accountDB
+}
+
+func NewAccountDataDatabaseRepo(pg *postgres.Postgres) *AccountDataDatabaseRepo {
+	return &AccountDataDatabaseRepo{Postgres: pg, library: postgresAccountDB{pg}}
+}
+
+// SetLibraryDB moves and deletes the reading status, reviews, downloads,
+// share links, loans and collections of accounts in the SQLite library.
+func (r *AccountDataDatabaseRepo) SetLibraryDB(db *sqlite.SQLite) {
+	r.library = sqliteAccountDB{db}
+}
+
+// accountDB runs the statements of a step, parameters are numbered like in
+// Postgres.
+type accountDB interface {
+	withTx(ctx context.Context, fn func(ctx context.Context) error) error
+	exec(ctx context.Context, sql string, args ...any) (int64, error)
+	count(ctx context.Context, sql string, args ...any) (int64, error)
+}
+
+type postgresAccountDB struct {
+	*postgres.Postgres
+}
+
+func (db postgresAccountDB) withTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return db.WithTx(ctx, fn)
+}
+
+func (db postgresAccountDB) exec(ctx context.Context, sql string, args ...any) (int64, error) {
+	tag, err := db.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, fmt.Errorf("r.Conn.Exec: %w", err)
+	}
+	return tag.RowsAffected(), nil
+}
+
+func (db postgresAccountDB) count(ctx context.Context, sql string, args ...any) (int64, error) {
+	var n int64
+	if err := db.Conn(ctx).QueryRow(ctx, sql, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("row.Scan: %w", err)
+	}
+	return n, nil
+}
+
+type sqliteAccountDB struct {
+	*sqlite.SQLite
+}
+
+func (db sqliteAccountDB) withTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return db.WithTx(ctx, fn)
+}
+
+func (db sqliteAccountDB) exec(ctx context.Context, sql string, args ...any) (int64, error) {
+	result, err := db.Conn(ctx).Exec(ctx, sql, args...)
+	if err != nil {
+		return 0, fmt.Errorf("r.DB.Exec: %w", err)
+	}
+	return result.RowsAffected()
+}
+
+func (db sqliteAccountDB) count(ctx context.Context, sql string, args ...any) (int64, error) {
+	var n int64
+	if err := db.Conn(ctx).QueryRow(ctx, sql, args...).Scan(&n); err != nil {
+		return 0, fmt.Errorf("row.Scan: %w", err)
+	}
+	return n, nil
+}
+
+// db is where the rows of a step are, library tables are in r.library.
+func (r *AccountDataDatabaseRepo) db(library bool) accountDB {
+	if library {
+		return r.library
+	}
+	return postgresAccountDB{r.Postgres}
+}
+
+// withTx runs fn in a transaction of Postgres and one of the library, which
+// is the same unless the library is kept in SQLite. A failing step leaves
+// the accounts as they were; the library is committed first, so only a
+// failing commit of Postgres after it can split them.
+func (r *AccountDataDatabaseRepo) withTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	return r.WithTx(ctx, func(ctx context.Context) error {
+		return r.library.withTx(ctx, fn)
+	})
+}
+
+// refreshRatingsSQL counts the ratings of the books reviewed by the account
+// $1 again without its reviews, before they are moved or deleted.
+const refreshRatingsSQL = `
+	UPDATE library_book
+	SET rating_avg = r.avg,
+		rating_count = r.count
+	FROM (
+		SELECT b.book_id, ROUND(AVG(o.rating), 2) AS avg, COUNT(o.rating) AS count
+		FROM (SELECT DISTINCT book_id FROM book_review WHERE username = $1) b
+		LEFT JOIN book_review o ON o.book_id = b.book_id AND o.username <> $1
+		GROUP BY b.book_id
+	) r
+	WHERE library_book.id = r.book_id
+`
+
+type mergeStep struct {
+	name    string
+	sql     string
+	count   *int64
+	library bool
+}
+
+// MergeDevices renames the device in progress, annotations and downloads.
+// Statistics of a book read on both devices are joined: page data of both
+// is kept and the totals are counted again from it. Sync conflicts of
+// the device are dropped.
+func (r *AccountDataDatabaseRepo) MergeDevices(ctx context.Context, from, into string) (MergeResult, error) {
+	var result MergeResult
+	steps := []mergeStep{
+		{"progress", `UPDATE sync_progress SET auth_device_name = $2 WHERE auth_device_name = $1`, &result.Progress, false},
+		{"sync conflicts", `DELETE FROM sync_conflict WHERE ahead_auth_device_name = $1 OR behind_auth_device_name = $1`, nil, false},
+		{"annotations", `UPDATE annotation_entry SET auth_device_name = $2 WHERE auth_device_name = $1`, &result.Annotations, false},
+		{"stats books", `
+			INSERT INTO stats_book (koreader_partial_md5, title, authors, notes, last_open, highlights, pages, series, language, total_read_time, total_read_pages, auth_device_name)
+			SELECT koreader_partial_md5, title, authors, notes, last_open, highlights, pages, series, language, total_read_time, total_read_pages, $2
+			FROM stats_book
+			WHERE auth_device_name = $1
+			ON CONFLICT (koreader_partial_md5, auth_device_name) DO UPDATE
+			SET notes = GREATEST(stats_book.notes, EXCLUDED.notes),
+				highlights = GREATEST(stats_book.highlights, EXCLUDED.highlights),
+				last_open = GREATEST(stats_book.last_open, EXCLUDED.last_open),
+				pages = COALESCE(stats_book.pages, EXCLUDED.pages)
+		`, &result.StatsBooks, false},
+		{"stats pages", `
+			INSERT INTO stats_page_stat_data (koreader_partial_md5, page, start_time, duration, total_pages, auth_device_name)
+			SELECT koreader_partial_md5, page, start_time, duration, total_pages, $2
+			FROM stats_page_stat_data
+			WHERE auth_device_name = $1
+			ON CONFLICT (koreader_partial_md5, page, start_time, auth_device_name) DO NOTHING
+		`, nil, false},
+		{"stats totals", `
+			UPDATE stats_book
+			SET total_read_time = s.read_time,
+				total_read_pages = s.read_pages
+			FROM (
+				SELECT koreader_partial_md5, SUM(duration) AS read_time, COUNT(DISTINCT page) AS read_pages
+				FROM stats_page_stat_data
+				WHERE auth_device_name = $2
+					AND koreader_partial_md5 IN (SELECT koreader_partial_md5 FROM stats_book WHERE auth_device_name = $1)
+				GROUP BY koreader_partial_md5
+			) s
+			WHERE stats_book.auth_device_name = $2 AND stats_book.koreader_partial_md5 = s.koreader_partial_md5
+		`, nil, false},
+		{"stats pages cleanup", `DELETE FROM stats_page_stat_data WHERE auth_device_name = $1`, nil, false},
+		{"stats books cleanup", `DELETE FROM stats_book WHERE auth_device_name = $1`, nil, false},
+		{"downloads", `UPDATE book_download SET device_name = $2 WHERE device_name = $1`, &result.Downloads, true},
+	}
+	if err := r.run(ctx, steps, from, into); err != nil {
+		return result, fmt.Errorf("AccountDataDatabaseRepo - MergeDevices - %w", err)
+	}
+	return result, nil
+}
+
+// MergeUsers moves shelves, reviews, downloads, share links, loans and
+// collections to the account into. A book finished on either account stays finished, otherwise the newer
+// status wins; of two reviews of a book the newer one is kept.
+func (r *AccountDataDatabaseRepo) MergeUsers(ctx context.Context, from, into string) (MergeResult, error) {
+	var result MergeResult
+	steps := []mergeStep{
+		{"reading status", `
+			INSERT INTO reading_status (username, book_id, status, finished_at, updated_at)
+			SELECT $2, book_id, status, finished_at, updated_at
+			FROM reading_status
+			WHERE username = $1
+			ON CONFLICT (username, book_id) DO UPDATE
+			SET status = EXCLUDED.status,
+				finished_at = EXCLUDED.finished_at,
+				updated_at = EXCLUDED.updated_at
+			WHERE reading_status.status <> 'finished'
+				AND (EXCLUDED.status = 'finished' OR EXCLUDED.updated_at > reading_status.updated_at)
+		`, &result.ReadingStatus, true},
+		{"reading status cleanup", `DELETE FROM reading_status WHERE username = $1`, nil, true},
+		{"reviews", `
+			INSERT INTO book_review (username, book_id, rating, review, created_at, updated_at)
+			SELECT $2, book_id, rating, review, created_at, updated_at
+			FROM book_review
+			WHERE username = $1
+			ON CONFLICT (username, book_id) DO UPDATE
+			SET rating = EXCLUDED.rating,
+				review = EXCLUDED.review,
+				created_at = EXCLUDED.created_at,
+				updated_at = EXCLUDED.updated_at
+			WHERE EXCLUDED.updated_at > book_review.updated_at
+		`, &result.Reviews, true},
+		{"ratings", refreshRatingsSQL, nil, true},
+		{"reviews cleanup", `DELETE FROM book_review WHERE username = $1`, nil, true},
+		{"downloads", `UPDATE book_download SET username = $2 WHERE username = $1`, &result.Downloads, true},
+		{"share links", `UPDATE book_share_link SET username = $2 WHERE username = $1`, nil, true},
+		{"loans lent", `UPDATE library_book_loan SET lender = $2 WHERE lender = $1`, nil, true},
+		{"loans borrowed", `UPDATE library_book_loan SET borrower = $2 WHERE borrower = $1`, nil, true},
+		{"collections", `UPDATE library_collection SET owner = $2 WHERE owner = $1`, nil, true},
+		{"sessions", `UPDATE auth_session SET is_active = false, deactivated_at = NOW() WHERE username = $1 AND is_active`, nil, false},
+		{"identities", `UPDATE auth_identity SET username = $2 WHERE username = $1`, nil, false},
+		{"user", `UPDATE auth_user SET merged_into = $2, merged_at = NOW(), updated_at = NOW() WHERE username = $1`, nil, false},
+	}
+	if err := r.run(ctx, steps, from, into); err != nil {
+		return result, fmt.Errorf("AccountDataDatabaseRepo - MergeUsers - %w", err)
+	}
+	return result, nil
+}
+
+// run runs the steps of a merge in one transaction.
+func (r *AccountDataDatabaseRepo) run(ctx context.Context, steps []mergeStep, from, into string) error {
+	return r.withTx(ctx, func(ctx context.Context) error {
+		return r.runSteps(ctx, steps, from, into)
+	})
+}
+
+func (r *AccountDataDatabaseRepo) runSteps(ctx context.Context, steps []mergeStep, from, into string) error {
+	for _, step := range steps {
+		// SQLite wants as many arguments as the statement has parameters
+		args := []any{from, into}
+		if !strings.Contains(step.sql, "$2") {
+			args = args[:1]
+		}
+		n, err := r.db(step.library).exec(ctx, step.sql, args...)
+		if err != nil {
+			return fmt.Errorf("%s: %w", step.name, err)
+		}
+		if step.count != nil {
+			*step.count = n
+		}
+	}
+	return nil
+}
+
+// deletionStep deletes the rows of a table matching where, or updates them
+// with set to anonymize them. A step with only sql runs on real runs.
+type deletionStep struct {
+	table   string
+	where   string
+	set     string
+	count   *int64
+	sql     string
+	library bool
+}
+
+// DeleteDeviceData deletes everything KOReader synced with the device.
+// Statistics pages go before their books because of the foreign key.
+func (r *AccountDataDatabaseRepo) DeleteDeviceData(ctx context.Context, name string, dryRun bool) (DeletionReport, error) {
+	report := DeletionReport{DryRun: dryRun}
+	var accounts int64
+	steps := []deletionStep{
+		{table: "sync_progress", where: "auth_device_name = $1", count: &report.Progress},
+		{sql: `DELETE FROM sync_conflict WHERE ahead_auth_device_name = $1 OR behind_auth_device_name = $1`},
+		{table: "annotation_entry", where: "auth_device_name = $1", count: &report.Annotations},
+		{table: "stats_page_stat_data", where: "auth_device_name = $1", count: &report.StatsPages},
+		{table: "stats_book", where: "auth_device_name = $1", count: &report.StatsBooks},
+		{table: "book_download", where: "device_name = $1", set: "device_name = ''", count: &report.Downloads, library: true},
+		{table: "auth_device", where: "device_name = $1", count: &accounts},
+	}
+	if err := r.delete(ctx, steps, name, dryRun); err != nil {
+		return report, fmt.Errorf("AccountDataDatabaseRepo - DeleteDeviceData - %w", err)
+	}
+	report.Account = accounts > 0
+	return report, nil
+}
+
+// DeleteUserData deletes the web account and its reviews from the ratings.
+func (r *AccountDataDatabaseRepo) DeleteUserData(ctx context.Context, username string, dryRun bool) (DeletionReport, error) {
+	report := DeletionReport{DryRun: dryRun}
+	var accounts int64
+	steps := []deletionStep{
+		{table: "reading_status", where: "username = $1", count: &report.ReadingStatus, library: true},
+		{sql: refreshRatingsSQL, library: true},
+		{table: "book_review", where: "username = $1", count: &report.Reviews, library: true},
+		{table: "book_download", where: "username = $1", set: "username = ''", count: &report.Downloads, library: true},
+		{table: "book_share_link", where: "username = $1", count: &report.ShareLinks, library: true},
+		{table: "library_book_loan", where: "lender = $1 OR borrower = $1", count: &report.Loans, library: true},
+		{table: "library_collection", where: "owner = $1", set: "owner = ''", count: &report.Collections, library: true},
+		{table: "auth_session", where: "username = $1", count: &report.Sessions},
+		{table: "auth_user", where: "username = $1", count: &accounts},
+	}
+	if err := r.delete(ctx, steps, username, dryRun); err != nil {
+		return report, fmt.Errorf("AccountDataDatabaseRepo - DeleteUserData - %w", err)
+	}
+	report.Account = accounts > 0
+	return report, nil
+}
+
+// delete runs the steps of a deletion in one transaction, a dry run only
+// counts the rows.
+func (r *AccountDataDatabaseRepo) delete(ctx context.Context, steps []deletionStep, account string, dryRun bool) error {
+	if dryRun {
+		return r.deleteSteps(ctx, steps, account, true)
+	}
+	return r.withTx(ctx, func(ctx context.Context) error {
+		return r.deleteSteps(ctx, steps, account, false)
+	})
+}
+
+func (r *AccountDataDatabaseRepo) deleteSteps(ctx context.Context, steps []deletionStep, account string, dryRun bool) error {
+	for _, step := range steps {
+		db := r.db(step.library)
+		if step.table == "" {
+			if dryRun {
+				continue
+			}
+			if _, err := db.exec(ctx, step.sql, account); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if dryRun {
+			n, err := db.count(ctx, "SELECT COUNT(*) FROM "+step.table+" WHERE "+step.where, account)
+			if err != nil {
+				return fmt.Errorf("%s: %w", step.table, err)
+			}
+			*step.count = n
+			continue
+		}
+		sql := "DELETE FROM " + step.table + " WHERE " + step.where
+		if step.set != "" {
+			sql = "UPDATE " + step.table + " SET " + step.set + " WHERE " + step.where
+		}
+		n, err := db.exec(ctx, sql, account)
+		if err != nil {
+			return fmt.Errorf("%s: %w", step.table, err)
+		}
+		*step.count = n
+	}
+	return nil
+}
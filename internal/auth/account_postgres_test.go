@@ -0,0 +1,209 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v4"
+
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/pkg/postgres"
+	"github.com/banjuer/kompanion/pkg/sqlite"
+)
+
+func TestDeleteDeviceDataDryRun(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	repo := auth.NewAccountDataDatabaseRepo(postgres.Mock(mock))
+
+	for _, table := range []string{"sync_progress", "annotation_entry", "stats_page_stat_data", "stats_book", "book_download", "auth_device"} {
+		mock.ExpectQuery("SELECT COUNT\\(\\*\\) FROM " + table).
+			WithArgs("kindle").
+			WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(int64(2)))
+	}
+
+	report, err := repo.DeleteDeviceData(context.Background(), "kindle", true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !report.DryRun || !report.Account || report.Progress != 2 || report.StatsPages != 2 || report.Downloads != 2 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestDeleteUserData(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	repo := auth.NewAccountDataDatabaseRepo(postgres.Mock(mock))
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM reading_status").WithArgs("reader").WillReturnResult(pgxmock.NewResult("DELETE", 3))
+	mock.ExpectExec("UPDATE library_book").WithArgs("reader").WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec("DELETE FROM book_review").WithArgs("reader").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectExec("UPDATE book_download SET username = ''").WithArgs("reader").WillReturnResult(pgxmock.NewResult("UPDATE", 4))
+	mock.ExpectExec("DELETE FROM book_share_link").WithArgs("reader").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectExec("DELETE FROM library_book_loan").WithArgs("reader").WillReturnResult(pgxmock.NewResult("DELETE", 2))
+	mock.ExpectExec("UPDATE library_collection SET owner = ''").WithArgs("reader").WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec("DELETE FROM auth_session").WithArgs("reader").WillReturnResult(pgxmock.NewResult("DELETE", 2))
+	mock.ExpectExec("DELETE FROM auth_user").WithArgs("reader").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectCommit()
+
+	report, err := repo.DeleteUserData(context.Background(), "reader", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := auth.DeletionReport{Account: true, Sessions: 2, ReadingStatus: 3, Reviews: 1, Downloads: 4, ShareLinks: 1, Loans: 2, Collections: 1}
+	if report != want {
+		t.Errorf("expected %+v, got %+v", want, report)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func newSQLiteLibrary(t *testing.T) *sqlite.SQLite {
+	t.Helper()
+	db, err := sqlite.New(filepath.Join(t.TempDir(), "library.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	migrations, err := filepath.Glob("../../migrations/sqlite/*.up.sql")
+	if err != nil || len(migrations) == 0 {
+		t.Fatalf("no migrations: %v", err)
+	}
+	for _, path := range migrations {
+		schema, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.DB.Exec(string(schema)); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return db
+}
+
+func TestAccountDataInSQLiteLibrary(t *testing.T) {
+	ctx := context.Background()
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	library := newSQLiteLibrary(t)
+	repo := auth.NewAccountDataDatabaseRepo(postgres.Mock(mock))
+	repo.SetLibraryDB(library)
+
+	_, err = library.DB.Exec(`
+		INSERT INTO library_book (id, storage_file_path, koreader_partial_md5, title, rating_avg, rating_count, created_at, updated_at)
+		VALUES ('book-1', 'book-1.epub', 'md5-1', 'One', 3, 2, '2024-01-01', '2024-01-01');
+		INSERT INTO reading_status (username, book_id, status, updated_at) VALUES
+			('old', 'book-1', 'finished', '2024-01-02'),
+			('new', 'book-1', 'reading', '2024-01-03');
+		INSERT INTO book_review (username, book_id, rating, created_at, updated_at) VALUES
+			('old', 'book-1', 2, '2024-01-02', '2024-01-02'),
+			('other', 'book-1', 4, '2024-01-02', '2024-01-02');
+		INSERT INTO book_download (book_id, username, client, downloaded_at) VALUES ('book-1', 'old', 'web', '2024-01-02');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE auth_session").WithArgs("old").WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	for _, table := range []string{"auth_identity", "auth_user"} {
+		mock.ExpectExec("UPDATE "+table).WithArgs("old", "new").WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	}
+	mock.ExpectCommit()
+	result, err := repo.MergeUsers(ctx, "old", "new")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.ReadingStatus != 1 || result.Reviews != 1 || result.Downloads != 1 {
+		t.Errorf("unexpected result %+v", result)
+	}
+	var status string
+	if err = library.DB.QueryRow(`SELECT status FROM reading_status WHERE username = 'new'`).Scan(&status); err != nil || status != "finished" {
+		t.Errorf("expected the finished status moved, got %q %v", status, err)
+	}
+	var left int
+	library.DB.QueryRow(`SELECT COUNT(*) FROM reading_status WHERE username = 'old'`).Scan(&left)
+	if left != 0 {
+		t.Errorf("expected no status left on the merged account, got %d", left)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("DELETE FROM auth_session").WithArgs("new").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectExec("DELETE FROM auth_user").WithArgs("new").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectCommit()
+	report, err := repo.DeleteUserData(ctx, "new", false)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !report.Account || report.ReadingStatus != 1 || report.Reviews != 1 || report.Downloads != 1 {
+		t.Errorf("unexpected report %+v", report)
+	}
+	var avg float64
+	var count int
+	if err = library.DB.QueryRow(`SELECT rating_avg, rating_count FROM library_book WHERE id = 'book-1'`).Scan(&avg, &count); err != nil || avg != 4 || count != 1 {
+		t.Errorf("expected the rating of the other review only, got %v %d %v", avg, count, err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMergeUsersFailingStepChangesNothing(t *testing.T) {
+	ctx := context.Background()
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	library := newSQLiteLibrary(t)
+	repo := auth.NewAccountDataDatabaseRepo(postgres.Mock(mock))
+	repo.SetLibraryDB(library)
+
+	_, err = library.DB.Exec(`
+		INSERT INTO library_book (id, storage_file_path, koreader_partial_md5, title, created_at, updated_at)
+		VALUES ('book-1', 'book-1.epub', 'md5-1', 'One', '2024-01-01', '2024-01-01');
+		INSERT INTO reading_status (username, book_id, status, updated_at) VALUES ('old', 'book-1', 'finished', '2024-01-02');
+		INSERT INTO book_download (book_id, username, client, downloaded_at) VALUES ('book-1', 'old', 'web', '2024-01-02');
+	`)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE auth_session").WithArgs("old").WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec("UPDATE auth_identity").WithArgs("old", "new").WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec("UPDATE auth_user").WithArgs("old", "new").WillReturnError(errors.New("connection reset"))
+	mock.ExpectRollback()
+	if _, err = repo.MergeUsers(ctx, "old", "new"); err == nil {
+		t.Fatal("expected the failing step to fail the merge")
+	}
+
+	for _, table := range []string{"reading_status", "book_download"} {
+		var left int
+		library.DB.QueryRow(`SELECT COUNT(*) FROM ` + table + ` WHERE username = 'old'`).Scan(&left)
+		if left != 1 {
+			t.Errorf("expected %s left on the old account, got %d", table, left)
+		}
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
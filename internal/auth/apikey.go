@@ -0,0 +1,153 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// ScopeRead keys only read, the API answers them for GET and HEAD.
+	ScopeRead = "read"
+	// ScopeWrite keys may do everything the account may.
+	ScopeWrite = "write"
+)
+
+// apiKeyPrefix starts every key, so a password is not looked up as one.
+const apiKeyPrefix = "kpn_"
+
+// APIKey signs scripts and devices in without the password of the account.
+// Only a hash of the key is stored, the key itself is shown once.
+type APIKey struct {
+	ID string
+	// Username created the key, a key without device signs in as it.
+	Username string
+	// Device the key signs in as, empty for keys of the user.
+	Device string
+	Name   string
+	// Prefix is the start of the key to tell keys apart.
+	Prefix     string
+	Scope      string
+	CreatedAt  time.Time
+	LastUsedAt *time.Time
+}
+
+// CanWrite reports whether the key may change data.
+func (k APIKey) CanWrite() bool {
+	return k.Scope == ScopeWrite
+}
+
+// SignsInAs reports whether the key belongs to the account name, the device
+// for device keys and the user otherwise.
+func (k APIKey) SignsInAs(name string) bool {
+	if k.Device != "" {
+		return k.Device == name
+	}
+	return k.Username == name
+}
+
+// IsAPIKey reports whether s looks like an API key rather than a password.
+func IsAPIKey(s string) bool {
+	return strings.HasPrefix(s, apiKeyPrefix)
+}
+
+// SetAPIKeyRepo enables API keys and device tokens.
+func (a *AuthService) SetAPIKeyRepo(keys APIKeyRepo) {
+	a.keys = keys
+}
+
+// CreateAPIKey creates a key of username for scripts, or a token for the
+// device when device is set. The key is returned once and can not be shown
+// again.
+func (a *AuthService) CreateAPIKey(ctx context.Context, username, device, name, scope string) (APIKey, string, error) {
+	if a.keys == nil {
+		return APIKey{}, "", APIKeysNotConfigured
+	}
+	if scope != ScopeRead && scope != ScopeWrite {
+		return APIKey{}, "", InvalidScope
+	}
+	if _, err := a.repo.GetUserByUsername(ctx, username); err != nil {
+		return APIKey{}, "", fmt.Errorf("AuthService - CreateAPIKey - %s: %w", username, UserNotFound)
+	}
+	if device != "" {
+		if _, err := a.repo.GetDeviceByName(ctx, device); err != nil {
+			return APIKey{}, "", fmt.Errorf("AuthService - CreateAPIKey - %s: %w", device, DeviceNotFound)
+		}
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return APIKey{}, "", fmt.Errorf("AuthService - CreateAPIKey - rand.Read: %w", err)
+	}
+	plain := apiKeyPrefix + base64.RawURLEncoding.EncodeToString(secret)
+
+	key := APIKey{
+		Username: username,
+		Device:   device,
+		Name:     strings.TrimSpace(name),
+		Prefix:   plain[:len(apiKeyPrefix)+6],
+		Scope:    scope,
+	}
+	key, err := a.keys.CreateAPIKey(ctx, key, hashAPIKey(plain))
+	if err != nil {
+		return APIKey{}, "", fmt.Errorf("AuthService - CreateAPIKey - a.keys.CreateAPIKey: %w", err)
+	}
+	return key, plain, nil
+}
+
+// ListAPIKeys lists the keys username created that are not revoked.
+func (a *AuthService) ListAPIKeys(ctx context.Context, username string) ([]APIKey, error) {
+	if a.keys == nil {
+		return nil, APIKeysNotConfigured
+	}
+	keys, err := a.keys.ListAPIKeys(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("AuthService - ListAPIKeys - a.keys.ListAPIKeys: %w", err)
+	}
+	return keys, nil
+}
+
+// RevokeAPIKey revokes a key username created.
+func (a *AuthService) RevokeAPIKey(ctx context.Context, username, id string) error {
+	if a.keys == nil {
+		return APIKeysNotConfigured
+	}
+	if err := a.keys.RevokeAPIKey(ctx, username, id); err != nil {
+		return fmt.Errorf("AuthService - RevokeAPIKey - a.keys.RevokeAPIKey: %w", err)
+	}
+	return nil
+}
+
+// CheckAPIKey returns the key when it is valid and its account still
+// active, and notes its use.
+func (a *AuthService) CheckAPIKey(ctx context.Context, plain string) (APIKey, error) {
+	if a.keys == nil || !IsAPIKey(plain) {
+		return APIKey{}, APIKeyNotFound
+	}
+	key, err := a.keys.GetAPIKeyByHash(ctx, hashAPIKey(plain))
+	if err != nil {
+		return APIKey{}, fmt.Errorf("AuthService - CheckAPIKey - a.keys.GetAPIKeyByHash: %w", err)
+	}
+	if _, err = a.repo.GetUserByUsername(ctx, key.Username); err != nil {
+		return APIKey{}, fmt.Errorf("AuthService - CheckAPIKey - %s: %w", key.Username, UserNotFound)
+	}
+	if key.Device != "" {
+		if _, err = a.repo.GetDeviceByName(ctx, key.Device); err != nil {
+			return APIKey{}, fmt.Errorf("AuthService - CheckAPIKey - %s: %w", key.Device, DeviceNotFound)
+		}
+	}
+	// the time of last use is only informational, the key stays valid
+	// when it is not recorded
+	_ = a.keys.TouchAPIKey(ctx, key.ID)
+	return key, nil
+}
+
+func hashAPIKey(plain string) string {
+	hash := sha256.Sum256([]byte(plain))
+	return hex.EncodeToString(hash[:])
+}
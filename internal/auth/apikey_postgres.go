@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+type APIKeyDatabaseRepo struct {
+	*postgres.Postgres
+}
+
+func NewAPIKeyDatabaseRepo(pg *postgres.Postgres) *APIKeyDatabaseRepo {
+	return &APIKeyDatabaseRepo{pg}
+}
+
+const apiKeyColumns = `id, username, COALESCE(device_name, ''), name, key_prefix, scope, created_at, last_used_at`
+
+func scanAPIKey(row pgx.Row) (APIKey, error) {
+	var key APIKey
+	err := row.Scan(&key.ID, &key.Username, &key.Device, &key.Name, &key.Prefix, &key.Scope, &key.CreatedAt, &key.LastUsedAt)
+	return key, err
+}
+
+func (r *APIKeyDatabaseRepo) CreateAPIKey(ctx context.Context, key APIKey, hash string) (APIKey, error) {
+	sql := `
+		INSERT INTO auth_api_key (username, device_name, name, key_prefix, key_hash, scope)
+		VALUES ($1, NULLIF($2, ''), $3, $4, $5, $6)
+		RETURNING ` + apiKeyColumns
+	args := []interface{}{key.Username, key.Device, key.Name, key.Prefix, hash, key.Scope}
+
+	key, err := scanAPIKey(r.Pool.QueryRow(ctx, sql, args...))
+	if err != nil {
+		return APIKey{}, fmt.Errorf("APIKeyDatabaseRepo - CreateAPIKey - row.Scan: %w", err)
+	}
+	return key, nil
+}
+
+func (r *APIKeyDatabaseRepo) GetAPIKeyByHash(ctx context.Context, hash string) (APIKey, error) {
+	sql := `
+		SELECT ` + apiKeyColumns + `
+		FROM auth_api_key
+		WHERE key_hash = $1 AND revoked_at IS NULL
+	`
+
+	key, err := scanAPIKey(r.Pool.QueryRow(ctx, sql, hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return APIKey{}, APIKeyNotFound
+	}
+	if err != nil {
+		return APIKey{}, fmt.Errorf("APIKeyDatabaseRepo - GetAPIKeyByHash - row.Scan: %w", err)
+	}
+	return key, nil
+}
+
+func (r *APIKeyDatabaseRepo) ListAPIKeys(ctx context.Context, username string) ([]APIKey, error) {
+	sql := `
+		SELECT ` + apiKeyColumns + `
+		FROM auth_api_key
+		WHERE username = $1 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`
+
+	rows, err := r.Pool.Query(ctx, sql, username)
+	if err != nil {
+		return nil, fmt.Errorf("APIKeyDatabaseRepo - ListAPIKeys - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []APIKey
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("APIKeyDatabaseRepo - ListAPIKeys - rows.Scan: %w", err)
+		}
+		keys = append(keys, key)
+	}
+	return keys, rows.Err()
+}
+
+func (r *APIKeyDatabaseRepo) RevokeAPIKey(ctx context.Context, username, id string) error {
+	sql := `
+		UPDATE auth_api_key
+		SET revoked_at = NOW()
+		WHERE id::text = $2 AND username = $1 AND revoked_at IS NULL
+	`
+
+	rows, err := r.Pool.Exec(ctx, sql, username, id)
+	if err != nil {
+		return fmt.Errorf("APIKeyDatabaseRepo - RevokeAPIKey - r.Pool.Exec: %w", err)
+	}
+	if rows.RowsAffected() == 0 {
+		return APIKeyNotFound
+	}
+	return nil
+}
+
+func (r *APIKeyDatabaseRepo) RevokeDeviceKeys(ctx context.Context, deviceName string) error {
+	sql := `
+		UPDATE auth_api_key
+		SET revoked_at = NOW()
+		WHERE device_name = $1 AND revoked_at IS NULL
+	`
+
+	_, err := r.Pool.Exec(ctx, sql, deviceName)
+	if err != nil {
+		return fmt.Errorf("APIKeyDatabaseRepo - RevokeDeviceKeys - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (r *APIKeyDatabaseRepo) TouchAPIKey(ctx context.Context, id string) error {
+	sql := `UPDATE auth_api_key SET last_used_at = NOW() WHERE id = $1`
+
+	_, err := r.Pool.Exec(ctx, sql, id)
+	if err != nil {
+		return fmt.Errorf("APIKeyDatabaseRepo - TouchAPIKey - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v4"
+
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+var apiKeyColumns = []string{"id", "username", "device_name", "name", "key_prefix", "scope", "created_at", "last_used_at"}
+
+func TestAPIKeyRepoGetByHash(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	repo := auth.NewAPIKeyDatabaseRepo(postgres.Mock(mock))
+
+	created := time.Date(2026, 3, 22, 9, 0, 0, 0, time.UTC)
+	mock.ExpectQuery("SELECT (.+) FROM auth_api_key WHERE key_hash = \\$1 AND revoked_at IS NULL").
+		WithArgs("hash").
+		WillReturnRows(pgxmock.NewRows(apiKeyColumns).
+			AddRow("0195b6a0-0000-7000-8000-000000000001", "user", "kindle", "opds", "kpn_abcdef", "read", created, (*time.Time)(nil)))
+	mock.ExpectQuery("SELECT (.+) FROM auth_api_key").
+		WithArgs("unknown").
+		WillReturnError(pgx.ErrNoRows)
+
+	key, err := repo.GetAPIKeyByHash(context.Background(), "hash")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if key.Device != "kindle" || key.Scope != auth.ScopeRead || key.LastUsedAt != nil || !key.CreatedAt.Equal(created) {
+		t.Errorf("unexpected key %+v", key)
+	}
+	if _, err = repo.GetAPIKeyByHash(context.Background(), "unknown"); !errors.Is(err, auth.APIKeyNotFound) {
+		t.Errorf("expected APIKeyNotFound, got %v", err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestAPIKeyRepoRevoke(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	repo := auth.NewAPIKeyDatabaseRepo(postgres.Mock(mock))
+
+	mock.ExpectExec("UPDATE auth_api_key SET revoked_at = NOW\\(\\)").
+		WithArgs("user", "1").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec("UPDATE auth_api_key SET revoked_at = NOW\\(\\)").
+		WithArgs("other", "1").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+	if err = repo.RevokeAPIKey(context.Background(), "user", "1"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = repo.RevokeAPIKey(context.Background(), "other", "1"); !errors.Is(err, auth.APIKeyNotFound) {
+		t.Errorf("expected APIKeyNotFound, got %v", err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,147 @@
+package auth_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/auth"
+)
+
+type fakeAPIKeyRepo struct {
+	keys    map[string]auth.APIKey // by hash
+	revoked map[string]bool
+	touched int
+}
+
+func newFakeAPIKeyRepo() *fakeAPIKeyRepo {
+	return &fakeAPIKeyRepo{keys: make(map[string]auth.APIKey), revoked: make(map[string]bool)}
+}
+
+func (f *fakeAPIKeyRepo) CreateAPIKey(ctx context.Context, key auth.APIKey, hash string) (auth.APIKey, error) {
+	key.ID = strconv.Itoa(len(f.keys) + 1)
+	f.keys[hash] = key
+	return key, nil
+}
+
+func (f *fakeAPIKeyRepo) GetAPIKeyByHash(ctx context.Context, hash string) (auth.APIKey, error) {
+	key, ok := f.keys[hash]
+	if !ok || f.revoked[key.ID] {
+		return auth.APIKey{}, auth.APIKeyNotFound
+	}
+	return key, nil
+}
+
+func (f *fakeAPIKeyRepo) ListAPIKeys(ctx context.Context, username string) ([]auth.APIKey, error) {
+	var keys []auth.APIKey
+	for _, key := range f.keys {
+		if key.Username == username && !f.revoked[key.ID] {
+			keys = append(keys, key)
+		}
+	}
+	return keys, nil
+}
+
+func (f *fakeAPIKeyRepo) RevokeAPIKey(ctx context.Context, username, id string) error {
+	for _, key := range f.keys {
+		if key.ID == id && key.Username == username && !f.revoked[id] {
+			f.revoked[id] = true
+			return nil
+		}
+	}
+	return auth.APIKeyNotFound
+}
+
+func (f *fakeAPIKeyRepo) RevokeDeviceKeys(ctx context.Context, deviceName string) error {
+	for _, key := range f.keys {
+		if key.Device == deviceName {
+			f.revoked[key.ID] = true
+		}
+	}
+	return nil
+}
+
+func (f *fakeAPIKeyRepo) TouchAPIKey(ctx context.Context, id string) error {
+	f.touched++
+	return nil
+}
+
+func TestAuthServiceAPIKeys(t *testing.T) {
+	ctx := context.Background()
+
+	a := auth.InitAuthService(auth.NewMemoryUserRepo(), "user", "password")
+	if _, _, err := a.CreateAPIKey(ctx, "user", "", "script", auth.ScopeRead); !errors.Is(err, auth.APIKeysNotConfigured) {
+		t.Fatalf("expected APIKeysNotConfigured, got %v", err)
+	}
+	keys := newFakeAPIKeyRepo()
+	a.SetAPIKeyRepo(keys)
+
+	if _, _, err := a.CreateAPIKey(ctx, "user", "", "script", "admin"); !errors.Is(err, auth.InvalidScope) {
+		t.Errorf("expected InvalidScope, got %v", err)
+	}
+	if _, _, err := a.CreateAPIKey(ctx, "user", "kobo", "reader", auth.ScopeRead); !errors.Is(err, auth.DeviceNotFound) {
+		t.Errorf("expected DeviceNotFound, got %v", err)
+	}
+
+	created, plain, err := a.CreateAPIKey(ctx, "user", "", " script ", auth.ScopeRead)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !auth.IsAPIKey(plain) || created.Name != "script" || plain[:len(created.Prefix)] != created.Prefix {
+		t.Errorf("unexpected key %q for %+v", plain, created)
+	}
+	if auth.IsAPIKey("password") {
+		t.Error("password taken for an API key")
+	}
+
+	key, err := a.CheckAPIKey(ctx, plain)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !key.SignsInAs("user") || key.CanWrite() || keys.touched != 1 {
+		t.Errorf("unexpected key %+v, touched %d", key, keys.touched)
+	}
+	if _, err = a.CheckAPIKey(ctx, plain+"x"); !errors.Is(err, auth.APIKeyNotFound) {
+		t.Errorf("expected APIKeyNotFound, got %v", err)
+	}
+
+	if err = a.RevokeAPIKey(ctx, "other", created.ID); !errors.Is(err, auth.APIKeyNotFound) {
+		t.Errorf("expected APIKeyNotFound for another user, got %v", err)
+	}
+	if err = a.RevokeAPIKey(ctx, "user", created.ID); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = a.CheckAPIKey(ctx, plain); !errors.Is(err, auth.APIKeyNotFound) {
+		t.Errorf("revoked key accepted: %v", err)
+	}
+}
+
+func TestAuthServiceDeviceTokens(t *testing.T) {
+	ctx := context.Background()
+
+	a := auth.InitAuthService(auth.NewMemoryUserRepo(), "user", "password")
+	a.SetAPIKeyRepo(newFakeAPIKeyRepo())
+	_ = a.AddUserDevice(ctx, "kindle", "secret")
+
+	_, plain, err := a.CreateAPIKey(ctx, "user", "kindle", "opds", auth.ScopeWrite)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	key, err := a.CheckAPIKey(ctx, plain)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !key.SignsInAs("kindle") || key.SignsInAs("user") || !key.CanWrite() {
+		t.Errorf("unexpected key %+v", key)
+	}
+
+	// a device added again does not get the tokens of the old one
+	if err = a.DeactivateUserDevice(ctx, "kindle"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_ = a.AddUserDevice(ctx, "kindle", "secret")
+	if _, err = a.CheckAPIKey(ctx, plain); err == nil {
+		t.Error("token of a deactivated device accepted")
+	}
+}
@@ -5,23 +5,45 @@ import (
 	"crypto/md5"
 	"crypto/subtle"
 	"encoding/hex"
+	"fmt"
 	"net"
+	"strings"
 
 	"github.com/moroz/uuidv7-go"
 	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/language"
+
+	"github.com/banjuer/kompanion/internal/entity"
 )
 
 type AuthService struct {
-	repo UserRepo
+	repo     UserRepo
+	accounts AccountDataRepo
+	keys     APIKeyRepo
+
+	identities    IdentityRepo
+	autoProvision bool
 }
 
+// InitAuthService registers the configured account. It is made an admin
+// again on every start, so an instance does not lose its last admin.
 func InitAuthService(repo UserRepo, username, password string) *AuthService {
 	auth := &AuthService{repo: repo}
-	auth.RegisterUser(context.Background(), username, password)
+	ctx := context.Background()
+	auth.createUser(ctx, username, password, entity.RoleAdmin)
+	repo.UpdateUserRole(ctx, username, entity.RoleAdmin)
 	return auth
 }
 
+// RegisterUser creates a reader account, admins may give it another role.
 func (a *AuthService) RegisterUser(ctx context.Context, username, password string) error {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return err
+	}
+	return a.createUser(ctx, username, password, entity.RoleReader)
+}
+
+func (a *AuthService) createUser(ctx context.Context, username, password string, role entity.Role) error {
 	hashedPassword, err := hashPassword(password)
 	if err != nil {
 		return err
@@ -30,10 +52,57 @@ func (a *AuthService) RegisterUser(ctx context.Context, username, password strin
 	newUser := User{
 		Username:       username,
 		HashedPassword: hashedPassword,
+		Role:           role,
 	}
 	return a.repo.CreateUser(ctx, newUser)
 }
 
+// UserRole returns the role of an account.
+func (a *AuthService) UserRole(ctx context.Context, username string) (entity.Role, error) {
+	user, err := a.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return "", err
+	}
+	return user.Role, nil
+}
+
+// ListUsers returns the accounts with their roles.
+func (a *AuthService) ListUsers(ctx context.Context) ([]User, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return nil, err
+	}
+	return a.repo.ListUsers(ctx)
+}
+
+// SetUserRole changes the role of an account, it applies to its next
+// request.
+func (a *AuthService) SetUserRole(ctx context.Context, username, role string) error {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return err
+	}
+	parsed, err := entity.ParseRole(role)
+	if err != nil {
+		return err
+	}
+	return a.repo.UpdateUserRole(ctx, username, parsed)
+}
+
+// SetPrivateUploads sets whether the books an account uploads are private
+// when the upload does not say.
+func (a *AuthService) SetPrivateUploads(ctx context.Context, username string, private bool) error {
+	return a.repo.UpdateUserPrivateUploads(ctx, username, private)
+}
+
+// PrivateUploads reports whether the books the account uploads are private
+// by default, false for unknown accounts.
+func (a *AuthService) PrivateUploads(ctx context.Context, username string) bool {
+	user, err := a.repo.GetUserByUsername(ctx, username)
+	if err != nil {
+		return false
+	}
+	return user.PrivateUploads
+}
+
 func (a *AuthService) CheckPassword(ctx context.Context, username string, password string) bool {
 	user, err := a.repo.GetUserByUsername(ctx, username)
 	if err != nil {
@@ -70,6 +139,15 @@ func (a *AuthService) IsAuthenticated(ctx context.Context, sessionKey string) bo
 	return err == nil
 }
 
+// SessionUser returns the username of an active session.
+func (a *AuthService) SessionUser(ctx context.Context, sessionKey string) (string, error) {
+	user, err := a.repo.GetUserBySession(ctx, sessionKey)
+	if err != nil {
+		return "", err
+	}
+	return user.Username, nil
+}
+
 func (a *AuthService) AddUserDevice(ctx context.Context, device_name, password string) error {
 	hashedPassword := hashSyncPassword(password)
 
@@ -81,7 +159,14 @@ func (a *AuthService) AddUserDevice(ctx context.Context, device_name, password s
 }
 
 func (a *AuthService) DeactivateUserDevice(ctx context.Context, device_name string) error {
-	return a.repo.DeleteDevice(ctx, device_name)
+	if err := a.repo.DeleteDevice(ctx, device_name); err != nil {
+		return err
+	}
+	// a device added again under the name does not get the old tokens
+	if a.keys != nil {
+		return a.keys.RevokeDeviceKeys(ctx, device_name)
+	}
+	return nil
 }
 
 func (a *AuthService) CheckDevicePassword(ctx context.Context, device_name, password string, plain bool) bool {
@@ -100,6 +185,74 @@ func (a *AuthService) ListDevices(ctx context.Context) ([]Device, error) {
 	return a.repo.ListDevices(ctx)
 }
 
+// SetDeviceLanguage sets the language of the OPDS feeds the device gets,
+// for readers that send no Accept-Language. An empty language clears it.
+func (a *AuthService) SetDeviceLanguage(ctx context.Context, device_name, language string) error {
+	if language != "" {
+		base, err := languageBase(language)
+		if err != nil {
+			return err
+		}
+		language = base
+	}
+	return a.repo.UpdateDeviceLanguage(ctx, device_name, language)
+}
+
+// DeviceLanguage returns the language set for the device, empty when
+// there is none.
+func (a *AuthService) DeviceLanguage(ctx context.Context, device_name string) string {
+	device, err := a.repo.GetDeviceByName(ctx, device_name)
+	if err != nil {
+		return ""
+	}
+	return device.Language
+}
+
+// SetDeviceFormats sets the file formats the device reads well, like
+// "epub" or ".FB2". No formats clear them.
+func (a *AuthService) SetDeviceFormats(ctx context.Context, device_name string, formats []string) error {
+	cleaned := make([]string, 0, len(formats))
+	seen := make(map[string]bool)
+	for _, format := range formats {
+		format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), "."))
+		if format == "" || seen[format] {
+			continue
+		}
+		if !validFormat(format) {
+			return fmt.Errorf("%w: %q", InvalidFormat, format)
+		}
+		seen[format] = true
+		cleaned = append(cleaned, format)
+	}
+	return a.repo.UpdateDeviceFormats(ctx, device_name, cleaned)
+}
+
+// validFormat accepts file extensions, letters and digits.
+func validFormat(format string) bool {
+	if len(format) > 8 {
+		return false
+	}
+	for _, r := range format {
+		if (r < 'a' || r > 'z') && (r < '0' || r > '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// languageBase reduces a language tag to its language subtag, "de-AT" is "de".
+func languageBase(tag string) (string, error) {
+	parsed, err := language.Parse(strings.ReplaceAll(strings.TrimSpace(tag), "_", "-"))
+	if err != nil {
+		return "", InvalidLanguage
+	}
+	base, confidence := parsed.Base()
+	if confidence == language.No || base.String() == "und" {
+		return "", InvalidLanguage
+	}
+	return base.String(), nil
+}
+
 func hashPassword(password string) (string, error) {
 	bytes, err := bcrypt.GenerateFromPassword([]byte(password), 14)
 	if err != nil {
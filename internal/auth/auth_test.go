@@ -2,9 +2,12 @@ package auth_test
 
 import (
 	"context"
+	"errors"
+	"strings"
 	"testing"
 
 	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/entity"
 )
 
 func TestAuthServiceUserOnInit(t *testing.T) {
@@ -50,4 +53,272 @@ func TestAuthServiceUserLogin(t *testing.T) {
 	if !auth.IsAuthenticated(ctx, sessionKey) {
 		t.Error("IsAuthenticated failed")
 	}
+
+	if username, err := auth.SessionUser(ctx, sessionKey); err != nil || username != "user" {
+		t.Errorf("SessionUser failed: %q, %v", username, err)
+	}
+}
+
+type fakeAccountDataRepo struct {
+	merged [][2]string
+}
+
+func (f *fakeAccountDataRepo) MergeDevices(ctx context.Context, from, into string) (auth.MergeResult, error) {
+	f.merged = append(f.merged, [2]string{from, into})
+	return auth.MergeResult{Progress: 3}, nil
+}
+
+func (f *fakeAccountDataRepo) MergeUsers(ctx context.Context, from, into string) (auth.MergeResult, error) {
+	f.merged = append(f.merged, [2]string{from, into})
+	return auth.MergeResult{Reviews: 1}, nil
+}
+
+func (f *fakeAccountDataRepo) DeleteDeviceData(ctx context.Context, name string, dryRun bool) (auth.DeletionReport, error) {
+	return auth.DeletionReport{DryRun: dryRun, Account: true}, nil
+}
+
+func (f *fakeAccountDataRepo) DeleteUserData(ctx context.Context, username string, dryRun bool) (auth.DeletionReport, error) {
+	return auth.DeletionReport{DryRun: dryRun}, nil
+}
+
+func TestAuthServiceMergeDevices(t *testing.T) {
+	ctx := context.Background()
+
+	a := auth.InitAuthService(auth.NewMemoryUserRepo(), "user", "password")
+	if _, err := a.MergeDevices(ctx, "kindle", "kobo"); !errors.Is(err, auth.AccountDataNotConfigured) {
+		t.Fatalf("expected AccountDataNotConfigured, got %v", err)
+	}
+
+	merges := &fakeAccountDataRepo{}
+	a.SetAccountDataRepo(merges)
+	_ = a.AddUserDevice(ctx, "kindle", "secret")
+	_ = a.AddUserDevice(ctx, "kindle2", "secret")
+
+	if _, err := a.MergeDevices(ctx, "kindle", "kindle"); !errors.Is(err, auth.SameAccount) {
+		t.Errorf("expected SameAccount, got %v", err)
+	}
+	if _, err := a.MergeDevices(ctx, "kindle2", "kobo"); !errors.Is(err, auth.DeviceNotFound) {
+		t.Errorf("expected DeviceNotFound, got %v", err)
+	}
+	if len(merges.merged) != 0 {
+		t.Fatalf("expected no merge, got %v", merges.merged)
+	}
+
+	result, err := a.MergeDevices(ctx, "kindle2", "kindle")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if result.Progress != 3 || len(merges.merged) != 1 || merges.merged[0] != [2]string{"kindle2", "kindle"} {
+		t.Errorf("unexpected merge %v: %+v", merges.merged, result)
+	}
+	if a.CheckDevicePassword(ctx, "kindle2", "secret", true) {
+		t.Error("merged device is still active")
+	}
+	if !a.CheckDevicePassword(ctx, "kindle", "secret", true) {
+		t.Error("remaining device was deactivated")
+	}
+}
+
+func TestAuthServiceMergeUsers(t *testing.T) {
+	ctx := context.Background()
+
+	a := auth.InitAuthService(auth.NewMemoryUserRepo(), "user", "password")
+	merges := &fakeAccountDataRepo{}
+	a.SetAccountDataRepo(merges)
+
+	if _, err := a.MergeUsers(ctx, "user", "user"); !errors.Is(err, auth.SameAccount) {
+		t.Errorf("expected SameAccount, got %v", err)
+	}
+	// memory repo keeps only one user
+	if _, err := a.MergeUsers(ctx, "other", "user"); !errors.Is(err, auth.UserNotFound) {
+		t.Errorf("expected UserNotFound, got %v", err)
+	}
+	if len(merges.merged) != 0 {
+		t.Errorf("expected no merge, got %v", merges.merged)
+	}
+}
+
+func TestAuthServiceDeleteDeviceData(t *testing.T) {
+	ctx := context.Background()
+
+	a := auth.InitAuthService(auth.NewMemoryUserRepo(), "user", "password")
+	a.SetAccountDataRepo(&fakeAccountDataRepo{})
+	_ = a.AddUserDevice(ctx, "kindle", "secret")
+
+	if _, err := a.DeleteDeviceData(ctx, "kindle", true); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !a.CheckDevicePassword(ctx, "kindle", "secret", true) {
+		t.Error("device was removed on a dry run")
+	}
+	if _, err := a.DeleteDeviceData(ctx, "kindle", false); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.CheckDevicePassword(ctx, "kindle", "secret", true) {
+		t.Error("deleted device can still log in")
+	}
+}
+
+func TestAuthServiceDeviceLanguage(t *testing.T) {
+	ctx := context.Background()
+
+	a := auth.InitAuthService(auth.NewMemoryUserRepo(), "user", "password")
+	_ = a.AddUserDevice(ctx, "kobo", "secret")
+
+	if err := a.SetDeviceLanguage(ctx, "kobo", "de_AT"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if language := a.DeviceLanguage(ctx, "kobo"); language != "de" {
+		t.Errorf("expected de, got %q", language)
+	}
+	if err := a.SetDeviceLanguage(ctx, "kobo", "not a language"); !errors.Is(err, auth.InvalidLanguage) {
+		t.Errorf("expected InvalidLanguage, got %v", err)
+	}
+	if err := a.SetDeviceLanguage(ctx, "kindle", "fr"); !errors.Is(err, auth.DeviceNotFound) {
+		t.Errorf("expected DeviceNotFound, got %v", err)
+	}
+	if err := a.SetDeviceLanguage(ctx, "kobo", ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if language := a.DeviceLanguage(ctx, "kobo"); language != "" {
+		t.Errorf("expected the language cleared, got %q", language)
+	}
+}
+
+func TestAuthServiceDeviceFormats(t *testing.T) {
+	ctx := context.Background()
+
+	a := auth.InitAuthService(auth.NewMemoryUserRepo(), "user", "password")
+	_ = a.AddUserDevice(ctx, "kobo", "secret")
+
+	if err := a.SetDeviceFormats(ctx, "kobo", []string{" EPUB", ".fb2", "epub", ""}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	devices, _ := a.ListDevices(ctx)
+	if len(devices) != 1 || strings.Join(devices[0].Formats, ",") != "epub,fb2" {
+		t.Errorf("expected epub and fb2, got %v", devices)
+	}
+	if err := a.SetDeviceFormats(ctx, "kobo", []string{"e pub"}); !errors.Is(err, auth.InvalidFormat) {
+		t.Errorf("expected InvalidFormat, got %v", err)
+	}
+	if err := a.SetDeviceFormats(ctx, "kindle", []string{"azw3"}); !errors.Is(err, auth.DeviceNotFound) {
+		t.Errorf("expected DeviceNotFound, got %v", err)
+	}
+}
+
+type fakeIdentityRepo struct {
+	users map[string]string // by subject
+}
+
+func (f *fakeIdentityRepo) GetIdentityUser(ctx context.Context, issuer, subject string) (string, error) {
+	username, ok := f.users[issuer+" "+subject]
+	if !ok {
+		return "", auth.IdentityNotFound
+	}
+	return username, nil
+}
+
+func (f *fakeIdentityRepo) LinkIdentity(ctx context.Context, identity auth.ExternalIdentity) error {
+	f.users[identity.Issuer+" "+identity.Subject] = identity.Username
+	return nil
+}
+
+func TestAuthServiceLoginExternal(t *testing.T) {
+	ctx := context.Background()
+
+	a := auth.InitAuthService(auth.NewMemoryUserRepo(), "user", "password")
+	identity := auth.ExternalIdentity{Issuer: "https://sso.example.com", Subject: "42", Username: "user"}
+	if _, err := a.LoginExternal(ctx, identity, "user-agent", nil); !errors.Is(err, auth.ExternalLoginNotConfigured) {
+		t.Fatalf("expected ExternalLoginNotConfigured, got %v", err)
+	}
+	identities := &fakeIdentityRepo{users: make(map[string]string)}
+	a.SetIdentityRepo(identities, false)
+
+	other := auth.ExternalIdentity{Issuer: "https://sso.example.com", Subject: "43", Username: "other"}
+	if _, err := a.LoginExternal(ctx, other, "user-agent", nil); !errors.Is(err, auth.UserNotFound) {
+		t.Errorf("expected UserNotFound without auto provisioning, got %v", err)
+	}
+
+	// an existing account is never linked by name
+	if _, err := a.LoginExternal(ctx, identity, "user-agent", nil); !errors.Is(err, auth.IdentityNotLinked) {
+		t.Fatalf("expected IdentityNotLinked for an existing account, got %v", err)
+	}
+	if err := a.LinkExternal(ctx, "user", identity); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sessionKey, err := a.LoginExternal(ctx, identity, "user-agent", nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if username, err := a.SessionUser(ctx, sessionKey); err != nil || username != "user" {
+		t.Errorf("SessionUser failed: %q, %v", username, err)
+	}
+
+	// the link holds when the name changes at the provider
+	identity.Username = "renamed"
+	if _, err = a.LoginExternal(ctx, identity, "user-agent", nil); err != nil {
+		t.Errorf("linked identity rejected: %v", err)
+	}
+}
+
+func TestAuthServiceLoginExternalProvisions(t *testing.T) {
+	ctx := context.Background()
+
+	// the memory repo keeps one user, none is registered here
+	a := auth.InitAuthService(auth.NewMemoryUserRepo(), "", "")
+	a.SetIdentityRepo(&fakeIdentityRepo{users: make(map[string]string)}, true)
+
+	identity := auth.ExternalIdentity{Issuer: "https://sso.example.com", Subject: "42", Username: "alice"}
+	if _, err := a.LoginExternal(ctx, identity, "user-agent", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if a.CheckPassword(ctx, "alice", "") {
+		t.Error("provisioned user can log in without password")
+	}
+
+	// another identity with the same name does not get alice
+	other := auth.ExternalIdentity{Issuer: "https://sso.example.com", Subject: "43", Username: "alice"}
+	if _, err := a.LoginExternal(ctx, other, "user-agent", nil); !errors.Is(err, auth.IdentityNotLinked) {
+		t.Errorf("expected IdentityNotLinked for a taken name, got %v", err)
+	}
+	if err := a.LinkExternal(ctx, "bob", identity); !errors.Is(err, auth.UserNotFound) {
+		t.Errorf("expected UserNotFound linking to a missing user, got %v", err)
+	}
+}
+
+func TestUserRoles(t *testing.T) {
+	ctx := context.Background()
+	a := auth.InitAuthService(auth.NewMemoryUserRepo(), "user", "password")
+
+	role, err := a.UserRole(ctx, "user")
+	if err != nil || role != entity.RoleAdmin {
+		t.Fatalf("expected the configured user to be an admin, got %q, %v", role, err)
+	}
+
+	readerCtx := entity.WithRole(ctx, entity.RoleReader)
+	if err = a.SetUserRole(readerCtx, "user", "reader"); !errors.Is(err, entity.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for readers, got %v", err)
+	}
+	if _, err = a.ListUsers(readerCtx); !errors.Is(err, entity.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for readers, got %v", err)
+	}
+	if err = a.RegisterUser(readerCtx, "other", "password"); !errors.Is(err, entity.ErrForbidden) {
+		t.Fatalf("expected ErrForbidden for readers, got %v", err)
+	}
+
+	adminCtx := entity.WithRole(ctx, entity.RoleAdmin)
+	if err = a.SetUserRole(adminCtx, "user", "owner"); !errors.Is(err, entity.ErrInvalidRole) {
+		t.Fatalf("expected ErrInvalidRole, got %v", err)
+	}
+	if err = a.SetUserRole(adminCtx, "user", "editor"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	users, err := a.ListUsers(adminCtx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(users) != 1 || users[0].Role != entity.RoleEditor {
+		t.Fatalf("expected the user to be an editor, got %+v", users)
+	}
 }
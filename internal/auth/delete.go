@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// DeletionReport counts the rows an account deletion removes, or would
+// remove on a dry run. Downloads are kept for the library history and
+// collections for their readers, without the account name.
+type DeletionReport struct {
+	DryRun        bool  `json:"dry_run"`
+	Account       bool  `json:"account"`
+	Sessions      int64 `json:"sessions"`
+	Progress      int64 `json:"progress"`
+	Annotations   int64 `json:"annotations"`
+	StatsBooks    int64 `json:"stats_books"`
+	StatsPages    int64 `json:"stats_pages"`
+	ReadingStatus int64 `json:"reading_status"`
+	Reviews       int64 `json:"reviews"`
+	Downloads     int64 `json:"downloads_anonymized"`
+	ShareLinks    int64 `json:"share_links"`
+	Loans         int64 `json:"loans"`
+	Collections   int64 `json:"collections_anonymized"`
+}
+
+// Empty is true when nothing of the account was found.
+func (r DeletionReport) Empty() bool {
+	return !r.Account && r.Sessions+r.Progress+r.Annotations+r.StatsBooks+r.StatsPages+r.ReadingStatus+r.Reviews+r.Downloads+r.ShareLinks+r.Loans+r.Collections == 0
+}
+
+// DeleteDeviceData removes a device with its credentials, reading progress,
+// annotations and statistics. Deactivated devices can be deleted too.
+// Books belong to the library, not to a device, and stay.
+func (a *AuthService) DeleteDeviceData(ctx context.Context, name string, dryRun bool) (DeletionReport, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return DeletionReport{}, err
+	}
+	if a.accounts == nil {
+		return DeletionReport{}, AccountDataNotConfigured
+	}
+	report, err := a.accounts.DeleteDeviceData(ctx, name, dryRun)
+	if err != nil {
+		return report, fmt.Errorf("AuthService - DeleteDeviceData - a.accounts.DeleteDeviceData: %w", err)
+	}
+	if !dryRun {
+		// memory repo keeps devices apart from the database
+		_ = a.repo.DeleteDevice(ctx, name)
+	}
+	return report, nil
+}
+
+// DeleteUserData removes a web account with its sessions, shelves, reviews
+// and share links. The account from the configuration is created again on
+// the next start, empty.
+func (a *AuthService) DeleteUserData(ctx context.Context, username string, dryRun bool) (DeletionReport, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return DeletionReport{}, err
+	}
+	if a.accounts == nil {
+		return DeletionReport{}, AccountDataNotConfigured
+	}
+	report, err := a.accounts.DeleteUserData(ctx, username, dryRun)
+	if err != nil {
+		return report, fmt.Errorf("AuthService - DeleteUserData - a.accounts.DeleteUserData: %w", err)
+	}
+	return report, nil
+}
@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/moroz/uuidv7-go"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// ExternalIdentity is an account at a single sign-on provider, Username is
+// the one the provider gives it.
+type ExternalIdentity struct {
+	Issuer   string
+	Subject  string
+	Username string
+}
+
+// SetIdentityRepo enables single sign-on. With autoProvision users the
+// provider knows and kompanion does not are created on first login.
+func (a *AuthService) SetIdentityRepo(identities IdentityRepo, autoProvision bool) {
+	a.identities = identities
+	a.autoProvision = autoProvision
+}
+
+// LoginExternal starts a session for an identity the provider vouched for.
+// Only an identity linked to a user signs in, the link holds when the name
+// changes at the provider. An identity seen for the first time gets a new
+// user when auto provisioning is on and its name is not taken; an existing
+// account is linked by its user with LinkExternal, never by name.
+func (a *AuthService) LoginExternal(ctx context.Context, identity ExternalIdentity, userAgent string, clientIP net.IP) (string, error) {
+	if a.identities == nil {
+		return "", ExternalLoginNotConfigured
+	}
+
+	username, err := a.identities.GetIdentityUser(ctx, identity.Issuer, identity.Subject)
+	switch {
+	case errors.Is(err, IdentityNotFound):
+		username, err = a.provisionIdentity(ctx, identity)
+		if err != nil {
+			return "", err
+		}
+	case err != nil:
+		return "", fmt.Errorf("AuthService - LoginExternal - a.identities.GetIdentityUser: %w", err)
+	}
+	if _, err = a.repo.GetUserByUsername(ctx, username); err != nil {
+		return "", fmt.Errorf("AuthService - LoginExternal - %s: %w", username, UserNotFound)
+	}
+
+	sessionKey := uuidv7.Generate().String()
+	if err = a.repo.StoreSession(ctx, username, sessionKey, userAgent, clientIP); err != nil {
+		return "", fmt.Errorf("AuthService - LoginExternal - a.repo.StoreSession: %w", err)
+	}
+	return sessionKey, nil
+}
+
+// LinkExternal links an identity to the signed in user, username, so it
+// signs in as that user from now on.
+func (a *AuthService) LinkExternal(ctx context.Context, username string, identity ExternalIdentity) error {
+	if a.identities == nil {
+		return ExternalLoginNotConfigured
+	}
+	if _, err := a.repo.GetUserByUsername(ctx, username); err != nil {
+		return fmt.Errorf("AuthService - LinkExternal - %s: %w", username, UserNotFound)
+	}
+
+	linked, err := a.identities.GetIdentityUser(ctx, identity.Issuer, identity.Subject)
+	switch {
+	case err == nil && linked == username:
+		return nil
+	case err == nil:
+		return fmt.Errorf("AuthService - LinkExternal - %w", IdentityLinked)
+	case !errors.Is(err, IdentityNotFound):
+		return fmt.Errorf("AuthService - LinkExternal - a.identities.GetIdentityUser: %w", err)
+	}
+
+	identity.Username = username
+	if err = a.identities.LinkIdentity(ctx, identity); err != nil {
+		return fmt.Errorf("AuthService - LinkExternal - a.identities.LinkIdentity: %w", err)
+	}
+	return nil
+}
+
+// provisionIdentity creates the user of an identity seen for the first
+// time. A taken name is refused: whoever controls the name at the provider
+// must not get the local account of that name.
+func (a *AuthService) provisionIdentity(ctx context.Context, identity ExternalIdentity) (string, error) {
+	username := strings.TrimSpace(identity.Username)
+	if username == "" {
+		return "", fmt.Errorf("AuthService - LoginExternal - identity has no username: %w", UserNotFound)
+	}
+
+	if _, err := a.repo.GetUserByUsername(ctx, username); err == nil {
+		return "", fmt.Errorf("AuthService - LoginExternal - %s: %w", username, IdentityNotLinked)
+	}
+	if !a.autoProvision {
+		return "", fmt.Errorf("AuthService - LoginExternal - %s: %w", username, UserNotFound)
+	}
+	// the account only signs in through the provider, its password is
+	// never told
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("AuthService - LoginExternal - rand.Read: %w", err)
+	}
+	if err := a.createUser(ctx, username, base64.RawURLEncoding.EncodeToString(secret), entity.RoleReader); err != nil {
+		return "", fmt.Errorf("AuthService - LoginExternal - a.createUser: %w", err)
+	}
+
+	identity.Username = username
+	if err := a.identities.LinkIdentity(ctx, identity); err != nil {
+		return "", fmt.Errorf("AuthService - LoginExternal - a.identities.LinkIdentity: %w", err)
+	}
+	return username, nil
+}
@@ -0,0 +1,53 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+type IdentityDatabaseRepo struct {
+	*postgres.Postgres
+}
+
+func NewIdentityDatabaseRepo(pg *postgres.Postgres) *IdentityDatabaseRepo {
+	return &IdentityDatabaseRepo{pg}
+}
+
+// GetIdentityUser returns the user of the identity and notes the login.
+func (r *IdentityDatabaseRepo) GetIdentityUser(ctx context.Context, issuer, subject string) (string, error) {
+	sql := `
+		UPDATE auth_identity
+		SET last_login_at = NOW()
+		WHERE issuer = $1 AND subject = $2
+		RETURNING username
+	`
+
+	var username string
+	err := r.Pool.QueryRow(ctx, sql, issuer, subject).Scan(&username)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", IdentityNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("IdentityDatabaseRepo - GetIdentityUser - row.Scan: %w", err)
+	}
+	return username, nil
+}
+
+func (r *IdentityDatabaseRepo) LinkIdentity(ctx context.Context, identity ExternalIdentity) error {
+	sql := `
+		INSERT INTO auth_identity (issuer, subject, username)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (issuer, subject) DO NOTHING
+	`
+
+	_, err := r.Pool.Exec(ctx, sql, identity.Issuer, identity.Subject, identity.Username)
+	if err != nil {
+		return fmt.Errorf("IdentityDatabaseRepo - LinkIdentity - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
@@ -4,16 +4,27 @@ import (
 	"context"
 	"errors"
 	"net"
+
+	"github.com/banjuer/kompanion/internal/entity"
 )
 
 type User struct {
 	Username       string
 	HashedPassword string
+	Role           entity.Role
+	// PrivateUploads makes the books the account uploads private unless
+	// an upload asks otherwise.
+	PrivateUploads bool
 }
 
 type Device struct {
 	Name           string
 	HashedPassword string
+	// Language of the OPDS feeds, empty follows the client.
+	Language string
+	// Formats the device reads well, file extensions like "epub". Empty
+	// leaves it to library.DefaultReaderFormats.
+	Formats []string
 }
 
 // TODO: move session key to separate type
@@ -23,13 +34,35 @@ type AuthInterface interface {
 	CheckPassword(ctx context.Context, username string, password string) bool
 	Login(ctx context.Context, username string, password string, userAgent string, clientIP net.IP) (string, error)
 	IsAuthenticated(ctx context.Context, sessionKey string) bool
+	SessionUser(ctx context.Context, sessionKey string) (string, error)
 	Logout(ctx context.Context, sessionKey string) error
 	RegisterUser(ctx context.Context, username, password string) error
+	UserRole(ctx context.Context, username string) (entity.Role, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	SetUserRole(ctx context.Context, username, role string) error
+	SetPrivateUploads(ctx context.Context, username string, private bool) error
+	PrivateUploads(ctx context.Context, username string) bool
 
 	AddUserDevice(ctx context.Context, device_name, password string) error
 	DeactivateUserDevice(ctx context.Context, device_name string) error
 	CheckDevicePassword(ctx context.Context, device_name, password string, plain bool) bool
 	ListDevices(ctx context.Context) ([]Device, error)
+	SetDeviceLanguage(ctx context.Context, device_name, language string) error
+	DeviceLanguage(ctx context.Context, device_name string) string
+	SetDeviceFormats(ctx context.Context, device_name string, formats []string) error
+
+	MergeDevices(ctx context.Context, from, into string) (MergeResult, error)
+	MergeUsers(ctx context.Context, from, into string) (MergeResult, error)
+	DeleteDeviceData(ctx context.Context, name string, dryRun bool) (DeletionReport, error)
+	DeleteUserData(ctx context.Context, username string, dryRun bool) (DeletionReport, error)
+
+	CreateAPIKey(ctx context.Context, username, device, name, scope string) (APIKey, string, error)
+	ListAPIKeys(ctx context.Context, username string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, username, id string) error
+	CheckAPIKey(ctx context.Context, key string) (APIKey, error)
+
+	LoginExternal(ctx context.Context, identity ExternalIdentity, userAgent string, clientIP net.IP) (string, error)
+	LinkExternal(ctx context.Context, username string, identity ExternalIdentity) error
 }
 
 var ErrAuth = errors.New("auth error")
@@ -39,6 +72,9 @@ type UserRepo interface {
 	CreateUser(ctx context.Context, user User) error
 	GetUserByUsername(ctx context.Context, username string) (User, error)
 	GetUserBySession(ctx context.Context, sessionKey string) (User, error)
+	ListUsers(ctx context.Context) ([]User, error)
+	UpdateUserRole(ctx context.Context, username string, role entity.Role) error
+	UpdateUserPrivateUploads(ctx context.Context, username string, private bool) error
 
 	StoreSession(ctx context.Context, username string, sessionKey string, userAgent string, clientIP net.IP) error
 	DeleteSession(ctx context.Context, sessionKey string) error
@@ -47,6 +83,35 @@ type UserRepo interface {
 	GetDeviceByName(ctx context.Context, device_name string) (Device, error)
 	DeleteDevice(ctx context.Context, device_name string) error
 	ListDevices(ctx context.Context) ([]Device, error)
+	UpdateDeviceLanguage(ctx context.Context, device_name, language string) error
+	UpdateDeviceFormats(ctx context.Context, device_name string, formats []string) error
+}
+
+// AccountDataRepo moves everything recorded for one account to another or
+// deletes it. Every step can be repeated, so a merge or a deletion that
+// failed half way is run again.
+type AccountDataRepo interface {
+	MergeDevices(ctx context.Context, from, into string) (MergeResult, error)
+	MergeUsers(ctx context.Context, from, into string) (MergeResult, error)
+	DeleteDeviceData(ctx context.Context, name string, dryRun bool) (DeletionReport, error)
+	DeleteUserData(ctx context.Context, username string, dryRun bool) (DeletionReport, error)
+}
+
+// APIKeyRepo stores hashes of API keys. Revoked keys are kept but never
+// returned.
+type APIKeyRepo interface {
+	CreateAPIKey(ctx context.Context, key APIKey, hash string) (APIKey, error)
+	GetAPIKeyByHash(ctx context.Context, hash string) (APIKey, error)
+	ListAPIKeys(ctx context.Context, username string) ([]APIKey, error)
+	RevokeAPIKey(ctx context.Context, username, id string) error
+	RevokeDeviceKeys(ctx context.Context, device_name string) error
+	TouchAPIKey(ctx context.Context, id string) error
+}
+
+// IdentityRepo links identities of single sign-on providers to users.
+type IdentityRepo interface {
+	GetIdentityUser(ctx context.Context, issuer, subject string) (string, error)
+	LinkIdentity(ctx context.Context, identity ExternalIdentity) error
 }
 
 var UserAlreadyCreated = errors.New("user already created")
@@ -54,3 +119,14 @@ var UserNotFound = errors.New("user not found")
 var SessionNotFound = errors.New("session not found")
 var DeviceAlreadyCreated = errors.New("device already created")
 var DeviceNotFound = errors.New("device not found")
+var InvalidLanguage = errors.New("unknown language")
+var InvalidFormat = errors.New("invalid file format")
+var SameAccount = errors.New("account can not be merged into itself")
+var AccountDataNotConfigured = errors.New("account data management is not configured")
+var APIKeysNotConfigured = errors.New("api keys are not configured")
+var APIKeyNotFound = errors.New("api key not found")
+var InvalidScope = errors.New("scope must be read or write")
+var ExternalLoginNotConfigured = errors.New("single sign-on is not configured")
+var IdentityNotFound = errors.New("identity not found")
+var IdentityNotLinked = errors.New("account exists, sign in with its password to link the identity")
+var IdentityLinked = errors.New("identity is linked to another user")
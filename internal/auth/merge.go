@@ -0,0 +1,78 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// MergeResult counts the rows moved to the remaining account.
+type MergeResult struct {
+	Progress      int64 `json:"progress"`
+	Annotations   int64 `json:"annotations"`
+	StatsBooks    int64 `json:"stats_books"`
+	Downloads     int64 `json:"downloads"`
+	ReadingStatus int64 `json:"reading_status"`
+	Reviews       int64 `json:"reviews"`
+}
+
+// SetAccountDataRepo enables merging and deletion of accounts.
+func (a *AuthService) SetAccountDataRepo(accounts AccountDataRepo) {
+	a.accounts = accounts
+}
+
+// MergeDevices moves progress, annotations, reading statistics and downloads
+// of the device from to the device into and deactivates from. KOReader logs
+// in to sync with a device, so a duplicate KOReader account is a device.
+func (a *AuthService) MergeDevices(ctx context.Context, from, into string) (MergeResult, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return MergeResult{}, err
+	}
+	if a.accounts == nil {
+		return MergeResult{}, AccountDataNotConfigured
+	}
+	if from == into {
+		return MergeResult{}, SameAccount
+	}
+	for _, name := range []string{from, into} {
+		if _, err := a.repo.GetDeviceByName(ctx, name); err != nil {
+			return MergeResult{}, fmt.Errorf("AuthService - MergeDevices - %s: %w", name, DeviceNotFound)
+		}
+	}
+
+	result, err := a.accounts.MergeDevices(ctx, from, into)
+	if err != nil {
+		return result, fmt.Errorf("AuthService - MergeDevices - a.accounts.MergeDevices: %w", err)
+	}
+	if err = a.repo.DeleteDevice(ctx, from); err != nil {
+		return result, fmt.Errorf("AuthService - MergeDevices - a.repo.DeleteDevice: %w", err)
+	}
+	return result, nil
+}
+
+// MergeUsers moves reading shelves, reviews and download history of the
+// account from to the account into. The account from is kept for reference
+// but can not log in any more.
+func (a *AuthService) MergeUsers(ctx context.Context, from, into string) (MergeResult, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return MergeResult{}, err
+	}
+	if a.accounts == nil {
+		return MergeResult{}, AccountDataNotConfigured
+	}
+	if from == into {
+		return MergeResult{}, SameAccount
+	}
+	for _, username := range []string{from, into} {
+		if _, err := a.repo.GetUserByUsername(ctx, username); err != nil {
+			return MergeResult{}, fmt.Errorf("AuthService - MergeUsers - %s: %w", username, UserNotFound)
+		}
+	}
+
+	result, err := a.accounts.MergeUsers(ctx, from, into)
+	if err != nil {
+		return result, fmt.Errorf("AuthService - MergeUsers - a.accounts.MergeUsers: %w", err)
+	}
+	return result, nil
+}
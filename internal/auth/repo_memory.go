@@ -5,6 +5,8 @@ import (
 	"errors"
 	"net"
 	"sync"
+
+	"github.com/banjuer/kompanion/internal/entity"
 )
 
 type MemoryRepo struct {
@@ -52,6 +54,38 @@ func (mr *MemoryRepo) GetUserBySession(ctx context.Context, sessionKey string) (
 	return mr.user, nil
 }
 
+func (mr *MemoryRepo) ListUsers(ctx context.Context) ([]User, error) {
+	mr.mu.RLock()
+	defer mr.mu.RUnlock()
+
+	if mr.user.Username == "" {
+		return nil, nil
+	}
+	return []User{{Username: mr.user.Username, Role: mr.user.Role}}, nil
+}
+
+func (mr *MemoryRepo) UpdateUserRole(ctx context.Context, username string, role entity.Role) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.user.Username != username {
+		return UserNotFound
+	}
+	mr.user.Role = role
+	return nil
+}
+
+func (mr *MemoryRepo) UpdateUserPrivateUploads(ctx context.Context, username string, private bool) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	if mr.user.Username != username {
+		return UserNotFound
+	}
+	mr.user.PrivateUploads = private
+	return nil
+}
+
 func (mr *MemoryRepo) StoreSession(ctx context.Context, username, sessionKey, userAgent string, clientIP net.IP) error {
 	mr.mu.Lock()
 	defer mr.mu.Unlock()
@@ -118,3 +152,29 @@ func (mr *MemoryRepo) ListDevices(ctx context.Context) ([]Device, error) {
 	}
 	return devices, nil
 }
+
+func (mr *MemoryRepo) UpdateDeviceLanguage(ctx context.Context, deviceName, language string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	device, ok := mr.devices[deviceName]
+	if !ok {
+		return DeviceNotFound
+	}
+	device.Language = language
+	mr.devices[deviceName] = device
+	return nil
+}
+
+func (mr *MemoryRepo) UpdateDeviceFormats(ctx context.Context, deviceName string, formats []string) error {
+	mr.mu.Lock()
+	defer mr.mu.Unlock()
+
+	device, ok := mr.devices[deviceName]
+	if !ok {
+		return DeviceNotFound
+	}
+	device.Formats = formats
+	mr.devices[deviceName] = device
+	return nil
+}
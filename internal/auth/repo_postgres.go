@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"net"
 
+	"github.com/banjuer/kompanion/internal/entity"
 	"github.com/banjuer/kompanion/pkg/postgres"
 )
 
@@ -18,15 +19,15 @@ func NewUserDatabaseRepo(pg *postgres.Postgres) *UserDatabaseRepo {
 
 func (r *UserDatabaseRepo) GetUserByUsername(ctx context.Context, username string) (User, error) {
 	sql := `
-		SELECT username, hashed_password
+		SELECT username, hashed_password, role, is_private_by_default
 		FROM auth_user
-		WHERE username = $1
+		WHERE username = $1 AND merged_into IS NULL
 	`
 	args := []interface{}{username}
 
 	row := r.Pool.QueryRow(ctx, sql, args...)
 	var user User
-	err := row.Scan(&user.Username, &user.HashedPassword)
+	err := row.Scan(&user.Username, &user.HashedPassword, &user.Role, &user.PrivateUploads)
 	if err != nil {
 		return User{}, fmt.Errorf("UserDatabaseRepo - GetUser - row.Scan: %w", err)
 	}
@@ -36,15 +37,86 @@ func (r *UserDatabaseRepo) GetUserByUsername(ctx context.Context, username strin
 
 func (r *UserDatabaseRepo) CreateUser(ctx context.Context, user User) error {
 	sql := `
-		INSERT INTO auth_user (username, hashed_password)
-		VALUES ($1, $2)
+		INSERT INTO auth_user (username, hashed_password, role)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (username) DO NOTHING
 	`
-	args := []interface{}{user.Username, user.HashedPassword}
+	args := []interface{}{user.Username, user.HashedPassword, user.Role}
 
-	_, err := r.Pool.Exec(ctx, sql, args...)
+	rows, err := r.Pool.Exec(ctx, sql, args...)
 	if err != nil {
 		return fmt.Errorf("UserDatabaseRepo - CreateUser - r.Pool.Exec: %w", err)
 	}
+	if rows.RowsAffected() == 0 {
+		return fmt.Errorf("UserDatabaseRepo - CreateUser - r.Pool.Exec: %w", UserAlreadyCreated)
+	}
+
+	return nil
+}
+
+// ListUsers returns the accounts that were not merged into others, without
+// their passwords.
+func (r *UserDatabaseRepo) ListUsers(ctx context.Context) ([]User, error) {
+	sql := `
+		SELECT username, role
+		FROM auth_user
+		WHERE merged_into IS NULL
+		ORDER BY username
+	`
+
+	rows, err := r.Pool.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("UserDatabaseRepo - ListUsers - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	var users []User
+	for rows.Next() {
+		var user User
+		if err = rows.Scan(&user.Username, &user.Role); err != nil {
+			return nil, fmt.Errorf("UserDatabaseRepo - ListUsers - rows.Scan: %w", err)
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func (r *UserDatabaseRepo) UpdateUserRole(ctx context.Context, username string, role entity.Role) error {
+	sql := `
+		UPDATE auth_user
+		SET role = $2,
+			updated_at = NOW()
+		WHERE username = $1 AND merged_into IS NULL
+	`
+	args := []interface{}{username, role}
+
+	rows, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("UserDatabaseRepo - UpdateUserRole - r.Pool.Exec: %w", err)
+	}
+	if rows.RowsAffected() == 0 {
+		return fmt.Errorf("UserDatabaseRepo - UpdateUserRole - r.Pool.Exec: %w", UserNotFound)
+	}
+
+	return nil
+}
+
+func (r *UserDatabaseRepo) UpdateUserPrivateUploads(ctx context.Context, username string, private bool) error {
+	sql := `
+		UPDATE auth_user
+		SET is_private_by_default = $2,
+			updated_at = NOW()
+		WHERE username = $1 AND merged_into IS NULL
+	`
+	args := []interface{}{username, private}
+
+	rows, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("UserDatabaseRepo - UpdateUserPrivateUploads - r.Pool.Exec: %w", err)
+	}
+	if rows.RowsAffected() == 0 {
+		return fmt.Errorf("UserDatabaseRepo - UpdateUserPrivateUploads - r.Pool.Exec: %w", UserNotFound)
+	}
 
 	return nil
 }
@@ -72,16 +144,16 @@ func (r *UserDatabaseRepo) StoreSession(
 
 func (r *UserDatabaseRepo) GetUserBySession(ctx context.Context, sessionKey string) (User, error) {
 	sql := `
-		SELECT auth_user.username, auth_user.hashed_password
+		SELECT auth_user.username, auth_user.hashed_password, auth_user.role, auth_user.is_private_by_default
 		FROM auth_user
 		JOIN auth_session ON auth_user.username = auth_session.username
-		WHERE session_key = $1 AND auth_session.is_active
+		WHERE session_key = $1 AND auth_session.is_active AND auth_user.merged_into IS NULL
 	`
 	args := []interface{}{sessionKey}
 
 	row := r.Pool.QueryRow(ctx, sql, args...)
 	var user User
-	err := row.Scan(&user.Username, &user.HashedPassword)
+	err := row.Scan(&user.Username, &user.HashedPassword, &user.Role, &user.PrivateUploads)
 	if err != nil {
 		return User{}, fmt.Errorf("UserDatabaseRepo - GetUserBySession - row.Scan: %w", err)
 	}
@@ -127,7 +199,7 @@ func (r *UserDatabaseRepo) CreateDevice(ctx context.Context, device Device) erro
 
 func (r *UserDatabaseRepo) GetDeviceByName(ctx context.Context, deviceName string) (Device, error) {
 	sql := `
-		SELECT device_name, hashed_password
+		SELECT device_name, hashed_password, language, formats
 		FROM auth_device
 		WHERE device_name = $1 AND is_active = true
 	`
@@ -135,7 +207,7 @@ func (r *UserDatabaseRepo) GetDeviceByName(ctx context.Context, deviceName strin
 
 	row := r.Pool.QueryRow(ctx, sql, args...)
 	var device Device
-	err := row.Scan(&device.Name, &device.HashedPassword)
+	err := row.Scan(&device.Name, &device.HashedPassword, &device.Language, &device.Formats)
 	if err != nil {
 		return Device{}, fmt.Errorf("UserDatabaseRepo - GetDeviceByName - row.Scan: %w", err)
 	}
@@ -160,9 +232,52 @@ func (r *UserDatabaseRepo) DeleteDevice(ctx context.Context, deviceName string)
 	return nil
 }
 
+func (r *UserDatabaseRepo) UpdateDeviceLanguage(ctx context.Context, deviceName, language string) error {
+	sql := `
+		UPDATE auth_device
+		SET language = $2,
+			updated_at = NOW()
+		WHERE device_name = $1 AND is_active = true
+	`
+	args := []interface{}{deviceName, language}
+
+	rows, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("UserDatabaseRepo - UpdateDeviceLanguage - r.Pool.Exec: %w", err)
+	}
+	if rows.RowsAffected() == 0 {
+		return fmt.Errorf("UserDatabaseRepo - UpdateDeviceLanguage - r.Pool.Exec: %w", DeviceNotFound)
+	}
+
+	return nil
+}
+
+func (r *UserDatabaseRepo) UpdateDeviceFormats(ctx context.Context, deviceName string, formats []string) error {
+	sql := `
+		UPDATE auth_device
+		SET formats = $2,
+			updated_at = NOW()
+		WHERE device_name = $1 AND is_active = true
+	`
+	if formats == nil {
+		formats = []string{}
+	}
+	args := []interface{}{deviceName, formats}
+
+	rows, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("UserDatabaseRepo - UpdateDeviceFormats - r.Pool.Exec: %w", err)
+	}
+	if rows.RowsAffected() == 0 {
+		return fmt.Errorf("UserDatabaseRepo - UpdateDeviceFormats - r.Pool.Exec: %w", DeviceNotFound)
+	}
+
+	return nil
+}
+
 func (r *UserDatabaseRepo) ListDevices(ctx context.Context) ([]Device, error) {
 	sql := `
-		SELECT device_name, hashed_password
+		SELECT device_name, hashed_password, language, formats
 		FROM auth_device
 		WHERE is_active = true
 		ORDER BY device_name
@@ -177,7 +292,7 @@ func (r *UserDatabaseRepo) ListDevices(ctx context.Context) ([]Device, error) {
 	var devices []Device
 	for rows.Next() {
 		var device Device
-		err = rows.Scan(&device.Name, &device.HashedPassword)
+		err = rows.Scan(&device.Name, &device.HashedPassword, &device.Language, &device.Formats)
 		if err != nil {
 			return nil, fmt.Errorf("UserDatabaseRepo - ListDevices - rows.Scan: %w", err)
 		}
@@ -0,0 +1,349 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/moroz/uuidv7-go"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/cron"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+const manifestPageSize = 100
+
+// Manifest lists every book file a database dump refers to, so a restore
+// can check the book storage is complete.
+type Manifest struct {
+	CreatedAt time.Time       `json:"created_at"`
+	Books     []ManifestEntry `json:"books"`
+}
+
+type ManifestEntry struct {
+	ID         string `json:"id"`
+	Title      string `json:"title"`
+	DocumentID string `json:"document_id"`
+	FilePath   string `json:"file_path"`
+	CoverPath  string `json:"cover_path,omitempty"`
+}
+
+// BackupService dumps the database and writes a manifest into backup storage,
+// optionally copies both to a second storage and rotates old runs.
+type BackupService struct {
+	repo      RunRepo
+	dumper    Dumper
+	books     BookLister
+	storage   storage.Storage
+	upload    storage.Storage
+	retention int
+	running   atomic.Bool
+	jobs      JobLock
+	l         logger.Interface
+
+	// set by EnableVerification and EnableSnapshots
+	restorer    Restorer
+	scratch     ScratchRepo
+	bookStorage storage.Storage
+	samples     int
+	snapshots   bool
+}
+
+// NewBackupService - backupStorage nil disables backups, upload may be nil.
+func NewBackupService(
+	repo RunRepo,
+	dumper Dumper,
+	books BookLister,
+	backupStorage storage.Storage,
+	upload storage.Storage,
+	retention int,
+	l logger.Interface,
+) *BackupService {
+	return &BackupService{
+		repo:      repo,
+		dumper:    dumper,
+		books:     books,
+		storage:   backupStorage,
+		upload:    upload,
+		retention: retention,
+		l:         l,
+	}
+}
+
+func (s *BackupService) List(ctx context.Context, limit int) ([]Run, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return nil, fmt.Errorf("BackupService - List - %w", err)
+	}
+	runs, err := s.repo.List(ctx, limit)
+	if err != nil {
+		return nil, fmt.Errorf("BackupService - List - s.repo.List: %w", err)
+	}
+	return runs, nil
+}
+
+// Start runs a backup in background, the request context is not awaited.
+func (s *BackupService) Start(ctx context.Context) error {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return err
+	}
+	if s.storage == nil {
+		return ErrNotConfigured
+	}
+	if s.running.Load() {
+		return ErrAlreadyRunning
+	}
+	go func() {
+		_, err := s.Run(context.WithoutCancel(ctx))
+		if err != nil {
+			s.l.Error("BackupService - Start - s.Run: %s", err)
+		}
+	}()
+	return nil
+}
+
+// SetJobLock makes backups and verifications wait for the ones running on
+// other servers sharing the database.
+func (s *BackupService) SetJobLock(jobs JobLock) {
+	s.jobs = jobs
+}
+
+// claim takes the job lock shared by backups and verifications,
+// ErrAlreadyRunning when another server holds it.
+func (s *BackupService) claim(ctx context.Context) (func(), error) {
+	if s.jobs == nil {
+		return func() {}, nil
+	}
+	release, err := s.jobs.TryLock(ctx, "backup")
+	if errors.Is(err, postgres.ErrLocked) {
+		return nil, ErrAlreadyRunning
+	}
+	if err != nil {
+		return nil, fmt.Errorf("s.jobs.TryLock: %w", err)
+	}
+	return release, nil
+}
+
+// Schedule runs a backup every interval until ctx is done.
+func (s *BackupService) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			_, err := s.Run(ctx)
+			if err != nil {
+				s.l.Error("BackupService - Schedule - s.Run: %s", err)
+			}
+		}
+	}
+}
+
+// ScheduleCron runs a backup at every time of schedule until ctx is done.
+func (s *BackupService) ScheduleCron(ctx context.Context, schedule cron.Schedule) {
+	for {
+		next := schedule.Next(time.Now())
+		if next.IsZero() {
+			s.l.Warn("BackupService - ScheduleCron - schedule never runs")
+			return
+		}
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+			_, err := s.Run(ctx)
+			if err != nil {
+				s.l.Error("BackupService - ScheduleCron - s.Run: %s", err)
+			}
+		}
+	}
+}
+
+func (s *BackupService) Run(ctx context.Context) (Run, error) {
+	if s.storage == nil {
+		return Run{}, ErrNotConfigured
+	}
+	if !s.running.CompareAndSwap(false, true) {
+		return Run{}, ErrAlreadyRunning
+	}
+	defer s.running.Store(false)
+	release, err := s.claim(ctx)
+	if err != nil {
+		return Run{}, fmt.Errorf("BackupService - Run - %w", err)
+	}
+	defer release()
+
+	startedAt := time.Now().UTC()
+	id := uuidv7.Generate().String()
+	dir := fmt.Sprintf("backups/%s_%s", startedAt.Format("20060102T150405Z"), id)
+	run := Run{
+		ID:           id,
+		StartedAt:    startedAt,
+		Status:       StatusRunning,
+		DatabasePath: dir + "/database.dump",
+		ManifestPath: dir + "/manifest.json",
+	}
+	if err := s.repo.Create(ctx, run); err != nil {
+		return Run{}, fmt.Errorf("BackupService - Run - s.repo.Create: %w", err)
+	}
+
+	err = s.write(ctx, &run)
+	run.FinishedAt = time.Now().UTC()
+	run.Status = StatusSuccess
+	if err != nil {
+		run.Status = StatusFailed
+		run.Error = err.Error()
+	}
+	if ferr := s.repo.Finish(ctx, run); ferr != nil {
+		return run, fmt.Errorf("BackupService - Run - s.repo.Finish: %w", ferr)
+	}
+	if err != nil {
+		return run, fmt.Errorf("BackupService - Run - s.write: %w", err)
+	}
+	s.l.Info("BackupService - Run - backup %s written, %d books", run.ID, run.BookCount)
+
+	s.prune(ctx)
+
+	return run, nil
+}
+
+func (s *BackupService) write(ctx context.Context, run *Run) error {
+	dump, err := os.CreateTemp("", "backup-*.dump")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp: %w", err)
+	}
+	dump.Close()
+	defer os.Remove(dump.Name())
+
+	if err = s.dumper.Dump(ctx, dump.Name()); err != nil {
+		return fmt.Errorf("s.dumper.Dump: %w", err)
+	}
+	info, err := os.Stat(dump.Name())
+	if err != nil {
+		return fmt.Errorf("os.Stat: %w", err)
+	}
+	run.Size = info.Size()
+
+	manifest, err := s.manifest(ctx)
+	if err != nil {
+		return fmt.Errorf("s.manifest: %w", err)
+	}
+	run.BookCount = len(manifest.Books)
+
+	if s.snapshots {
+		copied, err := s.snapshot(ctx, s.storage, manifest)
+		if err != nil {
+			return fmt.Errorf("s.snapshot: %w", err)
+		}
+		s.l.Info("BackupService - Run - %d book files copied", copied)
+	}
+
+	manifestFile, err := os.CreateTemp("", "backup-*.json")
+	if err != nil {
+		return fmt.Errorf("os.CreateTemp: %w", err)
+	}
+	defer os.Remove(manifestFile.Name())
+	err = json.NewEncoder(manifestFile).Encode(manifest)
+	manifestFile.Close()
+	if err != nil {
+		return fmt.Errorf("json.Encode: %w", err)
+	}
+
+	files := map[string]string{
+		run.DatabasePath: dump.Name(),
+		run.ManifestPath: manifestFile.Name(),
+	}
+	for dest, src := range files {
+		if err = s.storage.Write(ctx, src, dest); err != nil {
+			return fmt.Errorf("s.storage.Write: %w", err)
+		}
+	}
+
+	if s.upload == nil {
+		return nil
+	}
+	// a failed upload leaves a usable local backup, so it is only logged
+	for dest, src := range files {
+		if err = s.upload.Write(ctx, src, dest); err != nil {
+			s.l.Error("BackupService - Run - s.upload.Write: %s", err)
+			return nil
+		}
+	}
+	if s.snapshots {
+		if _, err = s.snapshot(ctx, s.upload, manifest); err != nil {
+			s.l.Error("BackupService - Run - upload s.snapshot: %s", err)
+			return nil
+		}
+	}
+	run.Uploaded = true
+
+	return nil
+}
+
+// manifest lists every book, private and scheduled ones too, whoever
+// started the backup.
+func (s *BackupService) manifest(ctx context.Context) (Manifest, error) {
+	ctx = library.WithoutReader(ctx)
+	manifest := Manifest{CreatedAt: time.Now().UTC(), Books: make([]ManifestEntry, 0)}
+	cursor := ""
+	for {
+		list, err := s.books.ListBooksByCursor(ctx, library.BookFilter{}, "created_at", "asc", cursor, manifestPageSize)
+		if err != nil {
+			return Manifest{}, err
+		}
+		for _, book := range list.Books {
+			manifest.Books = append(manifest.Books, ManifestEntry{
+				ID:         book.ID,
+				Title:      book.Title,
+				DocumentID: book.DocumentID,
+				FilePath:   book.FilePath,
+				CoverPath:  book.CoverPath,
+			})
+		}
+		if list.NextCursor == "" {
+			return manifest, nil
+		}
+		cursor = list.NextCursor
+	}
+}
+
+func (s *BackupService) prune(ctx context.Context) {
+	if s.retention <= 0 {
+		return
+	}
+	expired, err := s.repo.ListExpired(ctx, s.retention)
+	if err != nil {
+		s.l.Error("BackupService - prune - s.repo.ListExpired: %s", err)
+		return
+	}
+	if s.snapshots {
+		s.pruneFiles(ctx, expired)
+	}
+	for _, run := range expired {
+		for _, path := range []string{run.DatabasePath, run.ManifestPath} {
+			if err = s.storage.Delete(ctx, path); err != nil {
+				s.l.Warn("BackupService - prune - s.storage.Delete: %s", err)
+			}
+			if run.Uploaded && s.upload != nil {
+				if err = s.upload.Delete(ctx, path); err != nil {
+					s.l.Warn("BackupService - prune - s.upload.Delete: %s", err)
+				}
+			}
+		}
+		if err = s.repo.MarkPruned(ctx, run.ID); err != nil {
+			s.l.Error("BackupService - prune - s.repo.MarkPruned: %s", err)
+		}
+	}
+}
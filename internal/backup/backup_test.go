@@ -0,0 +1,386 @@
+package backup_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/backup"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+func TestRunWritesDumpAndManifest(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeRunRepo{}
+	local := storage.NewMemoryStorage()
+	upload := storage.NewMemoryStorage()
+	books := fakeBookLister{books: []entity.Book{
+		{ID: "1", Title: "first", FilePath: "2024/01/01/1.epub", DocumentID: "doc1"},
+		{ID: "2", Title: "second", FilePath: "2024/01/01/2.pdf", DocumentID: "doc2"},
+	}}
+	s := backup.NewBackupService(repo, fakeDumper{}, books, local, upload, 7, logger.New("error"))
+
+	run, err := s.Run(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if run.Status != backup.StatusSuccess || run.BookCount != 2 || !run.Uploaded {
+		t.Fatalf("unexpected run %+v", run)
+	}
+
+	f, err := upload.Read(ctx, run.ManifestPath)
+	if err != nil {
+		t.Fatalf("expected manifest to be uploaded: %v", err)
+	}
+	data, err := os.ReadFile(f.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var manifest backup.Manifest
+	if err = json.Unmarshal(data, &manifest); err != nil {
+		t.Fatal(err)
+	}
+	if len(manifest.Books) != 2 || manifest.Books[1].FilePath != "2024/01/01/2.pdf" {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	if _, err = local.Read(ctx, run.DatabasePath); err != nil {
+		t.Fatalf("expected dump to be written: %v", err)
+	}
+}
+
+func TestRunStartedByAdminListsEveryBook(t *testing.T) {
+	repo := library.NewMemoryBookRepo()
+	now := time.Now()
+	for _, book := range []entity.Book{
+		{ID: "shared", FilePath: "shared.epub", CreatedAt: now},
+		{ID: "private", FilePath: "private.epub", UploadedBy: "other", Private: true, CreatedAt: now},
+	} {
+		if err := repo.Store(context.Background(), book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	books := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	s := backup.NewBackupService(&fakeRunRepo{}, fakeDumper{}, books, storage.NewMemoryStorage(), nil, 7, logger.New("error"))
+	admin := entity.WithRole(library.WithReader(context.Background(), "admin"), entity.RoleAdmin)
+
+	run, err := s.Run(admin)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if run.BookCount != 2 {
+		t.Errorf("expected the private book of another account in the manifest, got %d books", run.BookCount)
+	}
+}
+
+func TestRunPrunesExpired(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeRunRepo{}
+	local := storage.NewMemoryStorage()
+	s := backup.NewBackupService(repo, fakeDumper{}, fakeBookLister{}, local, nil, 1, logger.New("error"))
+
+	first, err := s.Run(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repo.runs[0].StartedAt = first.StartedAt.Add(-24 * time.Hour)
+
+	if _, err = s.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if !repo.runs[0].Pruned {
+		t.Fatalf("expected first run to be pruned")
+	}
+	if _, err = local.Read(ctx, first.DatabasePath); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected pruned dump to be deleted, got %v", err)
+	}
+}
+
+func TestRunNotConfigured(t *testing.T) {
+	s := backup.NewBackupService(&fakeRunRepo{}, fakeDumper{}, fakeBookLister{}, nil, nil, 7, logger.New("error"))
+
+	_, err := s.Run(context.Background())
+	if !errors.Is(err, backup.ErrNotConfigured) {
+		t.Fatalf("expected ErrNotConfigured, got %v", err)
+	}
+}
+
+func TestRunSkipsWhileAnotherServerRuns(t *testing.T) {
+	repo := &fakeRunRepo{}
+	s := backup.NewBackupService(repo, fakeDumper{}, fakeBookLister{}, storage.NewMemoryStorage(), nil, 7, logger.New("error"))
+	jobs := &fakeJobLock{held: map[string]bool{"backup": true}}
+	s.SetJobLock(jobs)
+
+	if _, err := s.Run(context.Background()); !errors.Is(err, backup.ErrAlreadyRunning) {
+		t.Fatalf("expected ErrAlreadyRunning, got %v", err)
+	}
+	if len(repo.runs) != 0 {
+		t.Fatalf("expected no run to be recorded, got %+v", repo.runs)
+	}
+
+	jobs.held["backup"] = false
+	if _, err := s.Run(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if jobs.held["backup"] {
+		t.Fatalf("expected the job lock to be released")
+	}
+}
+
+func TestVerifyChecksSampledFiles(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeRunRepo{}
+	local := storage.NewMemoryStorage()
+	books := storage.NewMemoryStorage()
+	lister := fakeBookLister{books: []entity.Book{
+		{ID: "1", FilePath: "books/1.epub", DocumentID: "wrong-hash"},
+	}}
+	s := backup.NewBackupService(repo, fakeDumper{}, lister, local, nil, 7, logger.New("error"))
+	s.EnableVerification(fakeRestorer{}, fakeScratchRepo{books: lister.books}, books, 5)
+
+	if _, err := s.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	tmp, err := os.CreateTemp(t.TempDir(), "book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmp.WriteString("book content")
+	tmp.Close()
+	if err = books.Write(ctx, tmp.Name(), "books/1.epub"); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := s.Verify(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if v.RestoredBooks != 1 || v.ManifestBooks != 1 || v.Sampled != 1 {
+		t.Fatalf("unexpected verification %+v", v)
+	}
+	if v.Restorable() || len(v.SampleFailures) != 1 {
+		t.Fatalf("expected checksum mismatch to be reported, got %+v", v)
+	}
+	if repo.runs[0].VerifiedAt.IsZero() || repo.runs[0].Restorable {
+		t.Fatalf("expected failed verification to be stored, got %+v", repo.runs[0])
+	}
+}
+
+func TestSnapshotAndRestore(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeRunRepo{}
+	local := storage.NewMemoryStorage()
+	books := storage.NewMemoryStorage()
+	writeFile(t, books, "books/1.epub", "book content")
+	writeFile(t, books, "covers/1.jpg", "cover")
+	lister := fakeBookLister{books: []entity.Book{
+		{ID: "1", FilePath: "books/1.epub", CoverPath: "covers/1.jpg", DocumentID: "doc1"},
+		{ID: "2", FilePath: "books/2.epub", DocumentID: "doc2"},
+	}}
+	s := backup.NewBackupService(repo, fakeDumper{}, lister, local, nil, 7, logger.New("error"))
+	s.EnableSnapshots(books)
+
+	if _, err := s.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := local.Open(ctx, "files/books/1.epub"); err != nil {
+		t.Fatalf("expected book file in the backup: %v", err)
+	}
+
+	// restore into an empty book storage
+	restoredBooks := storage.NewMemoryStorage()
+	s = backup.NewBackupService(repo, fakeDumper{}, lister, local, nil, 7, logger.New("error"))
+	s.EnableVerification(fakeRestorer{}, fakeScratchRepo{}, nil, 0)
+	s.EnableSnapshots(restoredBooks)
+
+	restored, err := s.Restore(ctx, "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if restored.Run.ID != repo.runs[0].ID || restored.Files != 2 {
+		t.Fatalf("unexpected restore %+v", restored)
+	}
+	if len(restored.Missing) != 1 || restored.Missing[0] != "books/2.epub" {
+		t.Fatalf("expected the unreadable book to be missing, got %v", restored.Missing)
+	}
+	if _, err = restoredBooks.Open(ctx, "covers/1.jpg"); err != nil {
+		t.Fatalf("expected cover to be restored: %v", err)
+	}
+}
+
+func TestPruneSnapshotFiles(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeRunRepo{}
+	local := storage.NewMemoryStorage()
+	books := storage.NewMemoryStorage()
+	writeFile(t, books, "books/1.epub", "first")
+	writeFile(t, books, "books/2.epub", "second")
+
+	both := fakeBookLister{books: []entity.Book{{ID: "1", FilePath: "books/1.epub"}, {ID: "2", FilePath: "books/2.epub"}}}
+	s := backup.NewBackupService(repo, fakeDumper{}, both, local, nil, 1, logger.New("error"))
+	s.EnableSnapshots(books)
+	first, err := s.Run(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repo.runs[0].StartedAt = first.StartedAt.Add(-24 * time.Hour)
+
+	// book 2 was deleted before the second backup
+	second := fakeBookLister{books: both.books[:1]}
+	s = backup.NewBackupService(repo, fakeDumper{}, second, local, nil, 1, logger.New("error"))
+	s.EnableSnapshots(books)
+	if _, err = s.Run(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if _, err = local.Open(ctx, "files/books/1.epub"); err != nil {
+		t.Fatalf("expected file of the kept backup to stay: %v", err)
+	}
+	if _, err = local.Open(ctx, "files/books/2.epub"); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected file of the pruned backup to be deleted, got %v", err)
+	}
+}
+
+func writeFile(t *testing.T, st storage.Storage, path, content string) {
+	t.Helper()
+	if err := st.Put(context.Background(), path, strings.NewReader(content)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+type fakeRunRepo struct {
+	runs []backup.Run
+}
+
+func (r *fakeRunRepo) Create(_ context.Context, run backup.Run) error {
+	r.runs = append(r.runs, run)
+	return nil
+}
+
+func (r *fakeRunRepo) Finish(_ context.Context, run backup.Run) error {
+	for i := range r.runs {
+		if r.runs[i].ID == run.ID {
+			run.StartedAt = r.runs[i].StartedAt
+			r.runs[i] = run
+		}
+	}
+	return nil
+}
+
+func (r *fakeRunRepo) List(context.Context, int) ([]backup.Run, error) {
+	return r.runs, nil
+}
+
+func (r *fakeRunRepo) ListExpired(_ context.Context, keep int) ([]backup.Run, error) {
+	runs := make([]backup.Run, 0)
+	for _, run := range r.runs {
+		if run.Status == backup.StatusSuccess && !run.Pruned {
+			runs = append(runs, run)
+		}
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].StartedAt.After(runs[j].StartedAt) })
+	if len(runs) <= keep {
+		return nil, nil
+	}
+	return runs[keep:], nil
+}
+
+func (r *fakeRunRepo) MarkPruned(_ context.Context, id string) error {
+	for i := range r.runs {
+		if r.runs[i].ID == id {
+			r.runs[i].Pruned = true
+		}
+	}
+	return nil
+}
+
+// fakeJobLock - held locks belong to another server.
+type fakeJobLock struct {
+	held map[string]bool
+}
+
+func (l *fakeJobLock) TryLock(_ context.Context, name string) (func(), error) {
+	if l.held[name] {
+		return nil, postgres.ErrLocked
+	}
+	l.held[name] = true
+	return func() { l.held[name] = false }, nil
+}
+
+type fakeDumper struct{}
+
+func (fakeDumper) Dump(_ context.Context, target string) error {
+	return os.WriteFile(target, []byte("PGDMP"), 0o600)
+}
+
+type fakeBookLister struct {
+	books []entity.Book
+}
+
+func (l fakeBookLister) ListBooksByCursor(_ context.Context, _ library.BookFilter, _, _, _ string, perPage int) (library.PaginatedBookList, error) {
+	return library.NewPaginatedBookList(l.books, perPage, 1, len(l.books)), nil
+}
+
+func (r *fakeRunRepo) Latest(context.Context) (backup.Run, error) {
+	for i := len(r.runs) - 1; i >= 0; i-- {
+		if r.runs[i].Status == backup.StatusSuccess && !r.runs[i].Pruned {
+			return r.runs[i], nil
+		}
+	}
+	return backup.Run{}, backup.ErrNoBackup
+}
+
+func (r *fakeRunRepo) Get(_ context.Context, id string) (backup.Run, error) {
+	for _, run := range r.runs {
+		if run.ID == id {
+			return run, nil
+		}
+	}
+	return backup.Run{}, backup.ErrNoBackup
+}
+
+func (r *fakeRunRepo) SetVerification(_ context.Context, id string, verifiedAt time.Time, restorable bool, verifyError string) error {
+	for i := range r.runs {
+		if r.runs[i].ID == id {
+			r.runs[i].VerifiedAt = verifiedAt
+			r.runs[i].Restorable = restorable
+			r.runs[i].VerifyError = verifyError
+		}
+	}
+	return nil
+}
+
+type fakeRestorer struct{}
+
+func (fakeRestorer) Restore(context.Context, string, string) error {
+	return nil
+}
+
+func (fakeRestorer) Replace(context.Context, string) error {
+	return nil
+}
+
+type fakeScratchRepo struct {
+	books []entity.Book
+}
+
+func (r fakeScratchRepo) CountBooks(context.Context, string) (int, error) {
+	return len(r.books), nil
+}
+
+func (r fakeScratchRepo) SampleBooks(context.Context, string, int) ([]entity.Book, error) {
+	return r.books, nil
+}
+
+func (r fakeScratchRepo) DropSchema(context.Context, string) error {
+	return nil
+}
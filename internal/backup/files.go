@@ -0,0 +1,131 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/banjuer/kompanion/internal/storage"
+)
+
+// filesDir holds the book files of all backups. Book files are never changed
+// in place, a file is copied once and shared by every backup listing it.
+const filesDir = "files/"
+
+// maxRuns bounds the backup history read when pruning files.
+const maxRuns = 1000
+
+// EnableSnapshots copies the book files of every backup from books into the
+// backup storage, a backup then restores without the original book storage.
+func (s *BackupService) EnableSnapshots(books storage.Storage) {
+	s.bookStorage = books
+	s.snapshots = true
+}
+
+func (s *BackupService) snapshot(ctx context.Context, target storage.Storage, manifest Manifest) (int, error) {
+	copied, missing, err := copyFiles(ctx, s.bookStorage, "", target, filesDir, manifest)
+	for _, path := range missing {
+		s.l.Warn("BackupService - snapshot - %s can not be read", path)
+	}
+	return copied, err
+}
+
+// pruneFiles deletes the files that only expired backups list.
+func (s *BackupService) pruneFiles(ctx context.Context, expired []Run) {
+	unused := make(map[string]bool)
+	expiredIDs := make(map[string]bool)
+	for _, run := range expired {
+		expiredIDs[run.ID] = true
+		manifest, err := s.readManifest(ctx, run.ManifestPath)
+		if err != nil {
+			// without the manifest the files are kept, they may still be needed
+			s.l.Warn("BackupService - pruneFiles - s.readManifest: %s", err)
+			return
+		}
+		for _, path := range manifestPaths(manifest) {
+			unused[path] = true
+		}
+	}
+
+	runs, err := s.repo.List(ctx, maxRuns)
+	if err != nil {
+		s.l.Warn("BackupService - pruneFiles - s.repo.List: %s", err)
+		return
+	}
+	for _, run := range runs {
+		if run.Status != StatusSuccess || run.Pruned || expiredIDs[run.ID] {
+			continue
+		}
+		manifest, err := s.readManifest(ctx, run.ManifestPath)
+		if err != nil {
+			s.l.Warn("BackupService - pruneFiles - s.readManifest: %s", err)
+			return
+		}
+		for _, path := range manifestPaths(manifest) {
+			delete(unused, path)
+		}
+	}
+
+	for path := range unused {
+		if err = s.storage.Delete(ctx, filesDir+path); err != nil {
+			s.l.Warn("BackupService - pruneFiles - s.storage.Delete: %s", err)
+		}
+		if s.upload != nil {
+			if err = s.upload.Delete(ctx, filesDir+path); err != nil {
+				s.l.Warn("BackupService - pruneFiles - s.upload.Delete: %s", err)
+			}
+		}
+	}
+}
+
+func manifestPaths(manifest Manifest) []string {
+	paths := make([]string, 0, 2*len(manifest.Books))
+	for _, book := range manifest.Books {
+		if book.FilePath != "" {
+			paths = append(paths, book.FilePath)
+		}
+		if book.CoverPath != "" {
+			paths = append(paths, book.CoverPath)
+		}
+	}
+	return paths
+}
+
+// copyFiles copies the book files and covers of manifest between storages.
+// Book files already in to are skipped, covers are replaced in place and
+// always copied. Files that can not be read are returned as missing.
+func copyFiles(ctx context.Context, from storage.Storage, fromPrefix string, to storage.Storage, toPrefix string, manifest Manifest) (int, []string, error) {
+	copied := 0
+	var missing []string
+	for _, book := range manifest.Books {
+		for _, path := range []string{book.FilePath, book.CoverPath} {
+			if path == "" {
+				continue
+			}
+			if path == book.FilePath && exists(ctx, to, toPrefix+path) {
+				continue
+			}
+
+			file, err := from.Open(ctx, fromPrefix+path)
+			if err != nil {
+				missing = append(missing, path)
+				continue
+			}
+			err = to.Put(ctx, toPrefix+path, file)
+			file.Close()
+			if err != nil {
+				return copied, missing, fmt.Errorf("copy %s: %w", path, err)
+			}
+			copied++
+		}
+	}
+	return copied, missing, nil
+}
+
+func exists(ctx context.Context, st storage.Storage, path string) bool {
+	file, err := st.Open(ctx, path)
+	if err != nil {
+		return false
+	}
+	file.Close()
+	return true
+}
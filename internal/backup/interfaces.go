@@ -0,0 +1,110 @@
+package backup
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+)
+
+var (
+	ErrNotConfigured  = errors.New("backups are not configured")
+	ErrAlreadyRunning = errors.New("backup is already running")
+	ErrNoBackup       = errors.New("no backup to verify")
+)
+
+const (
+	StatusRunning = "running"
+	StatusSuccess = "success"
+	StatusFailed  = "failed"
+)
+
+// Run is one backup: a database dump and a manifest of stored book files.
+type Run struct {
+	ID           string
+	StartedAt    time.Time
+	FinishedAt   time.Time
+	Status       string
+	Error        string
+	DatabasePath string
+	ManifestPath string
+	Size         int64
+	BookCount    int
+	Uploaded     bool
+	Pruned       bool
+	// zero until the backup was restored by the verification job
+	VerifiedAt  time.Time
+	Restorable  bool
+	VerifyError string
+}
+
+// Verification is the outcome of restoring a backup into a scratch schema.
+type Verification struct {
+	RunID          string
+	RestoredBooks  int
+	ManifestBooks  int
+	Sampled        int
+	SampleFailures []string
+	Error          string
+}
+
+// Restorable reports whether every check passed.
+func (v Verification) Restorable() bool {
+	return v.Error == "" && len(v.SampleFailures) == 0 && v.RestoredBooks == v.ManifestBooks
+}
+
+type (
+	// Backups -
+	Backups interface {
+		Run(ctx context.Context) (Run, error)
+		Start(ctx context.Context) error
+		List(ctx context.Context, limit int) ([]Run, error)
+		Verify(ctx context.Context) (Verification, error)
+		StartVerify(ctx context.Context) error
+	}
+
+	// RunRepo -
+	RunRepo interface {
+		Create(ctx context.Context, run Run) error
+		Finish(ctx context.Context, run Run) error
+		List(ctx context.Context, limit int) ([]Run, error)
+		// ListExpired returns successful, not pruned runs older than the newest keep ones.
+		ListExpired(ctx context.Context, keep int) ([]Run, error)
+		MarkPruned(ctx context.Context, id string) error
+		// Latest returns the newest successful, not pruned run.
+		Latest(ctx context.Context) (Run, error)
+		Get(ctx context.Context, id string) (Run, error)
+		SetVerification(ctx context.Context, id string, verifiedAt time.Time, restorable bool, verifyError string) error
+	}
+
+	// Restorer loads a dump into an empty schema, or with Replace over the
+	// live database.
+	Restorer interface {
+		Restore(ctx context.Context, dump, schema string) error
+		Replace(ctx context.Context, dump string) error
+	}
+
+	// ScratchRepo inspects and drops the schema a backup was restored into.
+	ScratchRepo interface {
+		CountBooks(ctx context.Context, schema string) (int, error)
+		SampleBooks(ctx context.Context, schema string, n int) ([]entity.Book, error)
+		DropSchema(ctx context.Context, schema string) error
+	}
+
+	// Dumper writes a database dump to target.
+	Dumper interface {
+		Dump(ctx context.Context, target string) error
+	}
+
+	// JobLock keeps a job from running on two servers sharing the database.
+	JobLock interface {
+		TryLock(ctx context.Context, name string) (release func(), err error)
+	}
+
+	// BookLister is the part of library.Shelf needed for the manifest.
+	BookLister interface {
+		ListBooksByCursor(ctx context.Context, filter library.BookFilter, sortBy, sortOrder, cursor string, perPage int) (library.PaginatedBookList, error)
+	}
+)
@@ -0,0 +1,57 @@
+package backup
+
+import (
+	"context"
+	"net/url"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// dsnPassword matches the password of a keyword/value connection string,
+// quoted or not.
+var dsnPassword = regexp.MustCompile(`(?:^|\s)password\s*=\s*('(?:[^'\\]|\\.)*'|\S*)`)
+
+// pgConnection splits the password off a connection URL or keyword/value
+// string, the rest is safe to pass as --dbname.
+func pgConnection(conn string) (dbname, password string) {
+	if strings.HasPrefix(conn, "postgres://") || strings.HasPrefix(conn, "postgresql://") {
+		u, err := url.Parse(conn)
+		if err != nil {
+			return conn, ""
+		}
+		if pw, ok := u.User.Password(); ok {
+			password = pw
+			u.User = url.User(u.User.Username())
+		}
+		q := u.Query()
+		if pw := q.Get("password"); pw != "" {
+			password = pw
+			q.Del("password")
+			u.RawQuery = q.Encode()
+		}
+		return u.String(), password
+	}
+
+	m := dsnPassword.FindStringSubmatchIndex(conn)
+	if m == nil {
+		return conn, ""
+	}
+	password = conn[m[2]:m[3]]
+	if strings.HasPrefix(password, "'") {
+		password = strings.NewReplacer(`\'`, `'`, `\\`, `\`).Replace(password[1 : len(password)-1])
+	}
+	return strings.TrimSpace(conn[:m[0]] + conn[m[1]:]), password
+}
+
+// pgCommand runs a libpq tool on the database of conn. The password goes
+// in PGPASSWORD, on the command line everyone listing processes sees it.
+func pgCommand(ctx context.Context, binary, conn string, args ...string) *exec.Cmd {
+	dbname, password := pgConnection(conn)
+	cmd := exec.CommandContext(ctx, binary, append([]string{"--dbname=" + dbname}, args...)...)
+	if password != "" {
+		cmd.Env = append(os.Environ(), "PGPASSWORD="+password)
+	}
+	return cmd
+}
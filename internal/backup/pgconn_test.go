@@ -0,0 +1,53 @@
+package backup
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestPGConnectionSplitsPassword(t *testing.T) {
+	tests := []struct {
+		conn, dbname, password string
+	}{
+		{"postgres://kompanion:s3cret@db:5432/kompanion?sslmode=disable", "postgres://kompanion@db:5432/kompanion?sslmode=disable", "s3cret"},
+		{"postgresql://db/kompanion?password=s3cret&user=kompanion", "postgresql://db/kompanion?user=kompanion", "s3cret"},
+		{"postgres://kompanion@db/kompanion", "postgres://kompanion@db/kompanion", ""},
+		{`host=db password='it\'s quoted' dbname=kompanion`, "host=db dbname=kompanion", "it's quoted"},
+		{"host=db user=kompanion password=s3cret", "host=db user=kompanion", "s3cret"},
+	}
+	for _, tt := range tests {
+		dbname, password := pgConnection(tt.conn)
+		if dbname != tt.dbname || password != tt.password {
+			t.Errorf("%q: expected %q %q, got %q %q", tt.conn, tt.dbname, tt.password, dbname, password)
+		}
+	}
+}
+
+func TestPGDumpPassesPasswordInEnvironment(t *testing.T) {
+	dir := t.TempDir()
+	record := filepath.Join(dir, "record")
+	binary := filepath.Join(dir, "pg_dump")
+	script := "#!/bin/sh\necho \"$@\" > " + record + "\necho \"$PGPASSWORD\" >> " + record + "\n"
+	if err := os.WriteFile(binary, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+
+	dump := NewPGDump(binary, "postgres://kompanion:s3cret@db/kompanion")
+	if err := dump.Dump(context.Background(), filepath.Join(dir, "dump")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	out, err := os.ReadFile(record)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, env, _ := strings.Cut(strings.TrimSpace(string(out)), "\n")
+	if strings.Contains(args, "s3cret") || !strings.Contains(args, "--dbname=postgres://kompanion@db/kompanion") {
+		t.Errorf("expected the password off the command line, got %q", args)
+	}
+	if env != "s3cret" {
+		t.Errorf("expected the password in PGPASSWORD, got %q", env)
+	}
+}
@@ -0,0 +1,34 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// PGDump runs pg_dump in custom format, restorable with pg_restore.
+type PGDump struct {
+	binary string
+	url    string
+}
+
+// NewPGDump - binary is looked up in PATH when empty.
+func NewPGDump(binary, url string) *PGDump {
+	if binary == "" {
+		binary = "pg_dump"
+	}
+	return &PGDump{binary: binary, url: url}
+}
+
+func (d *PGDump) Dump(ctx context.Context, target string) error {
+	cmd := pgCommand(ctx, d.binary, d.url,
+		"--format=custom",
+		"--no-owner",
+		"--file="+target,
+	)
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("PGDump - Dump - %s: %w: %s", d.binary, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
@@ -0,0 +1,152 @@
+package backup
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+// PGRestore restores a custom format dump into another schema of the same
+// database. pg_restore can not rename schemas, so the dump is converted to
+// SQL, public is rewritten to the scratch schema and the script runs in psql.
+// Objects of extensions, like unaccent, are not in the dump and references
+// to them keep pointing to public.
+type PGRestore struct {
+	pgRestore string
+	psql      string
+	url       string
+}
+
+// NewPGRestore - binaries are looked up in PATH when empty.
+func NewPGRestore(pgRestore, psql, url string) *PGRestore {
+	if pgRestore == "" {
+		pgRestore = "pg_restore"
+	}
+	if psql == "" {
+		psql = "psql"
+	}
+	return &PGRestore{pgRestore: pgRestore, psql: psql, url: url}
+}
+
+// extensionObjectsSQL lists the objects extensions created in public, as
+// public.unaccent(regdictionary,text) and public.unaccent.
+const extensionObjectsSQL = `SELECT DISTINCT (pg_identify_object(classid, objid, 0)).identity
+	FROM pg_depend
+	WHERE deptype = 'e' AND (pg_identify_object(classid, objid, 0)).schema = 'public'`
+
+func (r *PGRestore) Restore(ctx context.Context, dump, schema string) error {
+	keep, err := r.extensionObjects(ctx)
+	if err != nil {
+		return fmt.Errorf("PGRestore - Restore - %w", err)
+	}
+
+	script, err := os.CreateTemp("", "restore-*.sql")
+	if err != nil {
+		return fmt.Errorf("PGRestore - Restore - os.CreateTemp: %w", err)
+	}
+	defer os.Remove(script.Name())
+	defer script.Close()
+
+	cmd := exec.CommandContext(ctx, r.pgRestore, "--schema=public", "--no-owner", "--no-privileges", "--file=-", dump)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("PGRestore - Restore - StdoutPipe: %w", err)
+	}
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	if err = cmd.Start(); err != nil {
+		return fmt.Errorf("PGRestore - Restore - %s: %w", r.pgRestore, err)
+	}
+	fmt.Fprintf(script, "DROP SCHEMA IF EXISTS %[1]s CASCADE;\nCREATE SCHEMA %[1]s;\n", schema)
+	rewriteErr := rewriteSchema(stdout, script, "public", schema, keep)
+	if err = cmd.Wait(); err != nil {
+		return fmt.Errorf("PGRestore - Restore - %s: %w: %s", r.pgRestore, err, strings.TrimSpace(stderr.String()))
+	}
+	if rewriteErr != nil {
+		return fmt.Errorf("PGRestore - Restore - rewriteSchema: %w", rewriteErr)
+	}
+	if err = script.Close(); err != nil {
+		return fmt.Errorf("PGRestore - Restore - script.Close: %w", err)
+	}
+
+	out, err := pgCommand(ctx, r.psql, r.url, "--quiet", "--no-psqlrc", "-v", "ON_ERROR_STOP=1",
+		"--file="+script.Name()).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("PGRestore - Restore - %s: %w: %s", r.psql, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// Replace restores dump over the live database, objects in the dump are
+// dropped and recreated in one transaction.
+func (r *PGRestore) Replace(ctx context.Context, dump string) error {
+	out, err := pgCommand(ctx, r.pgRestore, r.url, "--clean", "--if-exists", "--no-owner", "--no-privileges",
+		"--single-transaction", dump).CombinedOutput()
+	if err != nil {
+		return fmt.Errorf("PGRestore - Replace - %s: %w: %s", r.pgRestore, err, strings.TrimSpace(string(out)))
+	}
+	return nil
+}
+
+// extensionObjects returns the names of the extension objects in public.
+func (r *PGRestore) extensionObjects(ctx context.Context) (map[string]bool, error) {
+	out, err := pgCommand(ctx, r.psql, r.url, "--quiet", "--no-psqlrc", "--no-align", "--tuples-only",
+		"--command="+extensionObjectsSQL).Output()
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", r.psql, err)
+	}
+	names := map[string]bool{}
+	for _, identity := range strings.Split(string(out), "\n") {
+		name, _, _ := strings.Cut(strings.TrimPrefix(strings.TrimSpace(identity), "public."), "(")
+		if name != "" {
+			names[name] = true
+		}
+	}
+	return names, nil
+}
+
+// qualifiedName matches a schema qualified name, quoted or not.
+var qualifiedName = regexp.MustCompile(`\b([a-z_][a-z0-9_]*)\.("[^"]+"|[A-Za-z_][A-Za-z0-9_$]*)`)
+
+// rewriteSchema qualifies objects with schema instead of from, except the
+// names in keep. COPY data is passed through untouched, book titles may
+// well contain "public.".
+func rewriteSchema(src io.Reader, dst io.Writer, from, schema string, keep map[string]bool) error {
+	scanner := bufio.NewScanner(src)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	w := bufio.NewWriter(dst)
+
+	inCopy := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case inCopy:
+			inCopy = line != `\.`
+		case strings.HasPrefix(line, "CREATE SCHEMA "+from),
+			strings.HasPrefix(line, "ALTER SCHEMA "+from),
+			strings.HasPrefix(line, "COMMENT ON SCHEMA "+from):
+			continue
+		default:
+			inCopy = strings.HasPrefix(line, "COPY ") && strings.HasSuffix(line, "FROM stdin;")
+			line = qualifiedName.ReplaceAllStringFunc(line, func(name string) string {
+				prefix, object, _ := strings.Cut(name, ".")
+				if prefix != from || keep[object] {
+					return name
+				}
+				return schema + "." + object
+			})
+		}
+		if _, err := w.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return w.Flush()
+}
@@ -0,0 +1,67 @@
+package backup
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRewriteSchemaKeepsCopyData(t *testing.T) {
+	dump := strings.Join([]string{
+		"CREATE SCHEMA public;",
+		"CREATE TABLE public.library_book (id uuid NOT NULL, title text NOT NULL);",
+		"COPY public.library_book (id, title) FROM stdin;",
+		"0190a0c4-0000-7000-8000-000000000001\tpublic.speaking for beginners",
+		`\.`,
+		"ALTER TABLE ONLY public.library_book ADD CONSTRAINT library_book_pkey PRIMARY KEY (id);",
+		"",
+	}, "\n")
+
+	var out bytes.Buffer
+	if err := rewriteSchema(strings.NewReader(dump), &out, "public", "backup_verify", nil); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"CREATE TABLE backup_verify.library_book (id uuid NOT NULL, title text NOT NULL);",
+		"COPY backup_verify.library_book (id, title) FROM stdin;",
+		"0190a0c4-0000-7000-8000-000000000001\tpublic.speaking for beginners",
+		`\.`,
+		"ALTER TABLE ONLY backup_verify.library_book ADD CONSTRAINT library_book_pkey PRIMARY KEY (id);",
+		"",
+	}, "\n")
+	if out.String() != want {
+		t.Fatalf("unexpected script:\n%s", out.String())
+	}
+}
+
+// a dump taken after the unaccent migration calls the extension in public,
+// which a --schema=public restore does not create in the scratch schema
+func TestRewriteSchemaKeepsExtensionObjects(t *testing.T) {
+	dump := strings.Join([]string{
+		"CREATE FUNCTION public.library_fold(text) RETURNS text",
+		"    LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT",
+		"    AS $$ SELECT lower(public.unaccent('public.unaccent'::regdictionary, $1)) $$;",
+		"CREATE INDEX library_book_author_fold_idx ON public.library_book USING btree (public.library_fold(author));",
+		"COMMENT ON FUNCTION public.library_fold(text) IS 'lower case without accents';",
+		"",
+	}, "\n")
+
+	var out bytes.Buffer
+	keep := map[string]bool{"unaccent": true}
+	if err := rewriteSchema(strings.NewReader(dump), &out, "public", "backup_verify", keep); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	want := strings.Join([]string{
+		"CREATE FUNCTION backup_verify.library_fold(text) RETURNS text",
+		"    LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT",
+		"    AS $$ SELECT lower(public.unaccent('public.unaccent'::regdictionary, $1)) $$;",
+		"CREATE INDEX library_book_author_fold_idx ON backup_verify.library_book USING btree (backup_verify.library_fold(author));",
+		"COMMENT ON FUNCTION backup_verify.library_fold(text) IS 'lower case without accents';",
+		"",
+	}, "\n")
+	if out.String() != want {
+		t.Fatalf("unexpected script:\n%s", out.String())
+	}
+}
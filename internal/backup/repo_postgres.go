@@ -0,0 +1,173 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+// RunDatabaseRepo -.
+type RunDatabaseRepo struct {
+	*postgres.Postgres
+}
+
+// NewRunDatabaseRepo -.
+func NewRunDatabaseRepo(pg *postgres.Postgres) *RunDatabaseRepo {
+	return &RunDatabaseRepo{pg}
+}
+
+func (r *RunDatabaseRepo) Create(ctx context.Context, run Run) error {
+	sql := `
+		INSERT INTO backup_run (id, started_at, status, database_path, manifest_path)
+		VALUES ($1, $2, $3, $4, $5)
+	`
+	_, err := r.Pool.Exec(ctx, sql, run.ID, run.StartedAt, run.Status, run.DatabasePath, run.ManifestPath)
+	if err != nil {
+		return fmt.Errorf("RunDatabaseRepo - Create - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (r *RunDatabaseRepo) Finish(ctx context.Context, run Run) error {
+	sql := `
+		UPDATE backup_run
+		SET finished_at = $1, status = $2, error = $3, size_bytes = $4, book_count = $5, is_uploaded = $6
+		WHERE id = $7
+	`
+	_, err := r.Pool.Exec(ctx, sql, run.FinishedAt, run.Status, run.Error, run.Size, run.BookCount, run.Uploaded, run.ID)
+	if err != nil {
+		return fmt.Errorf("RunDatabaseRepo - Finish - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (r *RunDatabaseRepo) List(ctx context.Context, limit int) ([]Run, error) {
+	query := `
+		SELECT ` + runColumns + `
+		FROM backup_run
+		ORDER BY started_at DESC
+		LIMIT $1
+	`
+	rows, err := r.Pool.Query(ctx, query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("RunDatabaseRepo - List - r.Pool.Query: %w", err)
+	}
+	runs, err := scanRuns(rows)
+	if err != nil {
+		return nil, fmt.Errorf("RunDatabaseRepo - List - scanRuns: %w", err)
+	}
+	return runs, nil
+}
+
+func (r *RunDatabaseRepo) ListExpired(ctx context.Context, keep int) ([]Run, error) {
+	query := `
+		SELECT ` + runColumns + `
+		FROM backup_run
+		WHERE status = $1 AND NOT is_pruned
+		ORDER BY started_at DESC
+		OFFSET $2
+	`
+	rows, err := r.Pool.Query(ctx, query, StatusSuccess, keep)
+	if err != nil {
+		return nil, fmt.Errorf("RunDatabaseRepo - ListExpired - r.Pool.Query: %w", err)
+	}
+	runs, err := scanRuns(rows)
+	if err != nil {
+		return nil, fmt.Errorf("RunDatabaseRepo - ListExpired - scanRuns: %w", err)
+	}
+	return runs, nil
+}
+
+func (r *RunDatabaseRepo) MarkPruned(ctx context.Context, id string) error {
+	_, err := r.Pool.Exec(ctx, `UPDATE backup_run SET is_pruned = TRUE WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("RunDatabaseRepo - MarkPruned - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (r *RunDatabaseRepo) Latest(ctx context.Context) (Run, error) {
+	query := `
+		SELECT ` + runColumns + `
+		FROM backup_run
+		WHERE status = $1 AND NOT is_pruned
+		ORDER BY started_at DESC
+		LIMIT 1
+	`
+	rows, err := r.Pool.Query(ctx, query, StatusSuccess)
+	if err != nil {
+		return Run{}, fmt.Errorf("RunDatabaseRepo - Latest - r.Pool.Query: %w", err)
+	}
+	runs, err := scanRuns(rows)
+	if err != nil {
+		return Run{}, fmt.Errorf("RunDatabaseRepo - Latest - scanRuns: %w", err)
+	}
+	if len(runs) == 0 {
+		return Run{}, ErrNoBackup
+	}
+	return runs[0], nil
+}
+
+func (r *RunDatabaseRepo) Get(ctx context.Context, id string) (Run, error) {
+	query := `
+		SELECT ` + runColumns + `
+		FROM backup_run
+		WHERE id = $1
+	`
+	rows, err := r.Pool.Query(ctx, query, id)
+	if err != nil {
+		return Run{}, fmt.Errorf("RunDatabaseRepo - Get - r.Pool.Query: %w", err)
+	}
+	runs, err := scanRuns(rows)
+	if err != nil {
+		return Run{}, fmt.Errorf("RunDatabaseRepo - Get - scanRuns: %w", err)
+	}
+	if len(runs) == 0 {
+		return Run{}, ErrNoBackup
+	}
+	return runs[0], nil
+}
+
+func (r *RunDatabaseRepo) SetVerification(ctx context.Context, id string, verifiedAt time.Time, restorable bool, verifyError string) error {
+	sql := `
+		UPDATE backup_run
+		SET verified_at = $1, is_restorable = $2, verify_error = $3
+		WHERE id = $4
+	`
+	_, err := r.Pool.Exec(ctx, sql, verifiedAt, restorable, verifyError, id)
+	if err != nil {
+		return fmt.Errorf("RunDatabaseRepo - SetVerification - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+const runColumns = `id, started_at, finished_at, status, error, database_path, manifest_path, size_bytes, book_count,
+		is_uploaded, is_pruned, verified_at, is_restorable, verify_error`
+
+func scanRuns(rows pgx.Rows) ([]Run, error) {
+	defer rows.Close()
+
+	runs := make([]Run, 0)
+	for rows.Next() {
+		var run Run
+		var finishedAt, verifiedAt *time.Time
+		err := rows.Scan(&run.ID, &run.StartedAt, &finishedAt, &run.Status, &run.Error, &run.DatabasePath,
+			&run.ManifestPath, &run.Size, &run.BookCount, &run.Uploaded, &run.Pruned,
+			&verifiedAt, &run.Restorable, &run.VerifyError)
+		if err != nil {
+			return nil, err
+		}
+		if finishedAt != nil {
+			run.FinishedAt = *finishedAt
+		}
+		if verifiedAt != nil {
+			run.VerifiedAt = *verifiedAt
+		}
+		runs = append(runs, run)
+	}
+	return runs, rows.Err()
+}
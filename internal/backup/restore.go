@@ -0,0 +1,72 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// Restored is the outcome of Restore.
+type Restored struct {
+	Run   Run
+	Files int
+	// book files and covers neither in the backup nor in the book storage
+	Missing []string
+}
+
+// Restore replaces the database with the backup runID, the latest one when
+// runID is empty, and copies its book files back into the book storage.
+// Nothing else may use the database meanwhile.
+func (s *BackupService) Restore(ctx context.Context, runID string) (Restored, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return Restored{}, fmt.Errorf("BackupService - Restore - %w", err)
+	}
+	if s.storage == nil || s.restorer == nil {
+		return Restored{}, ErrNotConfigured
+	}
+
+	var run Run
+	var err error
+	if runID == "" {
+		run, err = s.repo.Latest(ctx)
+	} else {
+		run, err = s.repo.Get(ctx, runID)
+	}
+	if err != nil {
+		return Restored{}, fmt.Errorf("BackupService - Restore - s.repo: %w", err)
+	}
+
+	// the manifest is read first, a backup without one is not restored half
+	manifest, err := s.readManifest(ctx, run.ManifestPath)
+	if err != nil {
+		return Restored{}, fmt.Errorf("BackupService - Restore - s.readManifest: %w", err)
+	}
+	dump, err := s.storage.Read(ctx, run.DatabasePath)
+	if err != nil {
+		return Restored{}, fmt.Errorf("BackupService - Restore - s.storage.Read: %w", err)
+	}
+	dump.Close()
+
+	if err = s.restorer.Replace(ctx, dump.Name()); err != nil {
+		return Restored{}, fmt.Errorf("BackupService - Restore - s.restorer.Replace: %w", err)
+	}
+	restored := Restored{Run: run}
+	s.l.Info("BackupService - Restore - database restored from backup %s", run.ID)
+
+	if s.bookStorage == nil {
+		return restored, nil
+	}
+	copied, missing, err := copyFiles(ctx, s.storage, filesDir, s.bookStorage, "", manifest)
+	restored.Files = copied
+	if err != nil {
+		return restored, fmt.Errorf("BackupService - Restore - copyFiles: %w", err)
+	}
+	// files missing from the snapshot may still be in the book storage
+	for _, path := range missing {
+		if !exists(ctx, s.bookStorage, path) {
+			restored.Missing = append(restored.Missing, path)
+		}
+	}
+	return restored, nil
+}
@@ -0,0 +1,66 @@
+package backup
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+// ScratchDatabaseRepo reads a restored copy of library_book. The schema
+// name comes from configuration, never from a request.
+type ScratchDatabaseRepo struct {
+	*postgres.Postgres
+}
+
+// NewScratchDatabaseRepo -.
+func NewScratchDatabaseRepo(pg *postgres.Postgres) *ScratchDatabaseRepo {
+	return &ScratchDatabaseRepo{pg}
+}
+
+func (r *ScratchDatabaseRepo) CountBooks(ctx context.Context, schema string) (int, error) {
+	query := fmt.Sprintf(`SELECT count(*) FROM %s.library_book`, pgx.Identifier{schema}.Sanitize())
+
+	var count int
+	err := r.Pool.QueryRow(ctx, query).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("ScratchDatabaseRepo - CountBooks - r.Pool.QueryRow: %w", err)
+	}
+	return count, nil
+}
+
+func (r *ScratchDatabaseRepo) SampleBooks(ctx context.Context, schema string, n int) ([]entity.Book, error) {
+	query := fmt.Sprintf(`
+		SELECT id, title, storage_file_path, koreader_partial_md5
+		FROM %s.library_book
+		ORDER BY random()
+		LIMIT $1
+	`, pgx.Identifier{schema}.Sanitize())
+
+	rows, err := r.Pool.Query(ctx, query, n)
+	if err != nil {
+		return nil, fmt.Errorf("ScratchDatabaseRepo - SampleBooks - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	books := make([]entity.Book, 0, n)
+	for rows.Next() {
+		var book entity.Book
+		if err = rows.Scan(&book.ID, &book.Title, &book.FilePath, &book.DocumentID); err != nil {
+			return nil, fmt.Errorf("ScratchDatabaseRepo - SampleBooks - rows.Scan: %w", err)
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+func (r *ScratchDatabaseRepo) DropSchema(ctx context.Context, schema string) error {
+	_, err := r.Pool.Exec(ctx, fmt.Sprintf(`DROP SCHEMA IF EXISTS %s CASCADE`, pgx.Identifier{schema}.Sanitize()))
+	if err != nil {
+		return fmt.Errorf("ScratchDatabaseRepo - DropSchema - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,187 @@
+package backup
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/utils"
+)
+
+// scratchSchema receives the restored backup, it is dropped after the checks.
+const scratchSchema = "backup_verify"
+
+// EnableVerification sets up Verify: restores go through restorer, sampled
+// book files are read from books.
+func (s *BackupService) EnableVerification(restorer Restorer, scratch ScratchRepo, books storage.Storage, samples int) {
+	s.restorer = restorer
+	s.scratch = scratch
+	s.bookStorage = books
+	s.samples = samples
+}
+
+// StartVerify runs Verify in background.
+func (s *BackupService) StartVerify(ctx context.Context) error {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return err
+	}
+	if s.storage == nil || s.restorer == nil {
+		return ErrNotConfigured
+	}
+	if s.running.Load() {
+		return ErrAlreadyRunning
+	}
+	go func() {
+		_, err := s.Verify(context.WithoutCancel(ctx))
+		if err != nil {
+			s.l.Error("BackupService - StartVerify - s.Verify: %s", err)
+		}
+	}()
+	return nil
+}
+
+// ScheduleVerify verifies the latest backup every interval until ctx is done.
+func (s *BackupService) ScheduleVerify(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			v, err := s.Verify(ctx)
+			if err != nil {
+				s.l.Error("BackupService - ScheduleVerify - s.Verify: %s", err)
+				continue
+			}
+			if !v.Restorable() {
+				s.l.Warn("BackupService - ScheduleVerify - backup %s is not restorable: %s", v.RunID, v.summary())
+			}
+		}
+	}
+}
+
+// Verify restores the latest backup into a scratch schema, compares the book
+// count with its manifest and checks partial md5 of a few random book files.
+func (s *BackupService) Verify(ctx context.Context) (Verification, error) {
+	if s.storage == nil || s.restorer == nil {
+		return Verification{}, ErrNotConfigured
+	}
+	if !s.running.CompareAndSwap(false, true) {
+		return Verification{}, ErrAlreadyRunning
+	}
+	defer s.running.Store(false)
+	release, err := s.claim(ctx)
+	if err != nil {
+		return Verification{}, fmt.Errorf("BackupService - Verify - %w", err)
+	}
+	defer release()
+
+	run, err := s.repo.Latest(ctx)
+	if err != nil {
+		return Verification{}, fmt.Errorf("BackupService - Verify - s.repo.Latest: %w", err)
+	}
+
+	v := Verification{RunID: run.ID}
+	if err = s.verify(ctx, run, &v); err != nil {
+		v.Error = err.Error()
+	}
+	if err = s.scratch.DropSchema(ctx, scratchSchema); err != nil {
+		s.l.Warn("BackupService - Verify - s.scratch.DropSchema: %s", err)
+	}
+
+	err = s.repo.SetVerification(ctx, run.ID, time.Now().UTC(), v.Restorable(), v.summary())
+	if err != nil {
+		return v, fmt.Errorf("BackupService - Verify - s.repo.SetVerification: %w", err)
+	}
+	s.l.Info("BackupService - Verify - backup %s restorable: %t", run.ID, v.Restorable())
+
+	return v, nil
+}
+
+func (s *BackupService) verify(ctx context.Context, run Run, v *Verification) error {
+	dump, err := s.storage.Read(ctx, run.DatabasePath)
+	if err != nil {
+		return fmt.Errorf("read dump: %w", err)
+	}
+	dump.Close()
+
+	if err = s.restorer.Restore(ctx, dump.Name(), scratchSchema); err != nil {
+		return fmt.Errorf("restore: %w", err)
+	}
+
+	manifest, err := s.readManifest(ctx, run.ManifestPath)
+	if err != nil {
+		return fmt.Errorf("read manifest: %w", err)
+	}
+	v.ManifestBooks = len(manifest.Books)
+
+	v.RestoredBooks, err = s.scratch.CountBooks(ctx, scratchSchema)
+	if err != nil {
+		return fmt.Errorf("count books: %w", err)
+	}
+
+	if s.bookStorage == nil || s.samples <= 0 {
+		return nil
+	}
+	sample, err := s.scratch.SampleBooks(ctx, scratchSchema, s.samples)
+	if err != nil {
+		return fmt.Errorf("sample books: %w", err)
+	}
+	for _, book := range sample {
+		v.Sampled++
+		if err = s.checkBookFile(ctx, book.FilePath, book.DocumentID); err != nil {
+			v.SampleFailures = append(v.SampleFailures, fmt.Sprintf("%s: %s", book.FilePath, err))
+		}
+	}
+	return nil
+}
+
+func (s *BackupService) readManifest(ctx context.Context, path string) (Manifest, error) {
+	f, err := s.storage.Open(ctx, path)
+	if err != nil {
+		return Manifest{}, err
+	}
+	defer f.Close()
+
+	var manifest Manifest
+	err = json.NewDecoder(f).Decode(&manifest)
+	return manifest, err
+}
+
+func (s *BackupService) checkBookFile(ctx context.Context, path, documentID string) error {
+	f, err := s.bookStorage.Open(ctx, path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash, err := utils.PartialMD5Reader(f)
+	if err != nil {
+		return err
+	}
+	if hash != documentID {
+		return fmt.Errorf("checksum mismatch")
+	}
+	return nil
+}
+
+func (v Verification) summary() string {
+	var problems []string
+	if v.Error != "" {
+		problems = append(problems, v.Error)
+	}
+	if v.Error == "" && v.RestoredBooks != v.ManifestBooks {
+		problems = append(problems, fmt.Sprintf("restored %d books, manifest lists %d", v.RestoredBooks, v.ManifestBooks))
+	}
+	if len(v.SampleFailures) > 0 {
+		problems = append(problems, fmt.Sprintf("%d of %d sampled files failed: %s",
+			len(v.SampleFailures), v.Sampled, strings.Join(v.SampleFailures, "; ")))
+	}
+	return strings.Join(problems, ", ")
+}
@@ -0,0 +1,191 @@
+package bookmeta
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// FileSource names the metadata embedded in the book file in a chain.
+const FileSource = "file"
+
+// Fields the precedence of a chain can be set for.
+var Fields = []string{"title", "author", "description", "publisher", "year", "isbn", "doi", "series", "language", "cover"}
+
+// Source is a named metadata provider, looking up books by ISBN, DOI or
+// both. The file source has neither.
+type Source struct {
+	Name string
+	ISBN Provider
+	DOI  DOIProvider
+}
+
+// FieldRules give the sources to take a field from first, in order. The
+// sources not listed follow in the order of the chain.
+type FieldRules map[string][]string
+
+// Chain asks several metadata providers and takes each field from the
+// first source in order that has it.
+type Chain struct {
+	sources []Source
+	rules   FieldRules
+}
+
+// NewChain orders sources, the FileSource among them ranks the metadata
+// of the file and it ranks last when it is missing. Rules must only name
+// fields of Fields and the sources.
+func NewChain(sources []Source, rules FieldRules) (*Chain, error) {
+	names := make(map[string]bool, len(sources)+1)
+	for _, source := range sources {
+		if names[source.Name] {
+			return nil, fmt.Errorf("metadata source %s is listed twice", source.Name)
+		}
+		names[source.Name] = true
+	}
+	if !names[FileSource] {
+		sources = append(sources, Source{Name: FileSource})
+		names[FileSource] = true
+	}
+	for field, order := range rules {
+		if !knownField(field) {
+			return nil, fmt.Errorf("unknown metadata field %q, known are %s", field, strings.Join(Fields, ", "))
+		}
+		for _, name := range order {
+			if !names[name] {
+				return nil, fmt.Errorf("metadata field %s: source %q is not enabled", field, name)
+			}
+		}
+	}
+	return &Chain{sources: sources, rules: rules}, nil
+}
+
+// ParseFieldRules parses rules like "title=crossref,file;cover=openlibrary".
+func ParseFieldRules(s string) (FieldRules, error) {
+	rules := make(FieldRules)
+	for _, rule := range strings.Split(s, ";") {
+		if strings.TrimSpace(rule) == "" {
+			continue
+		}
+		field, list, found := strings.Cut(rule, "=")
+		field = strings.ToLower(strings.TrimSpace(field))
+		if !found || field == "" {
+			return nil, fmt.Errorf("metadata rule %q is not field=source,source", rule)
+		}
+		var order []string
+		for _, name := range strings.Split(list, ",") {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				order = append(order, name)
+			}
+		}
+		rules[field] = order
+	}
+	return rules, nil
+}
+
+func knownField(field string) bool {
+	for _, known := range Fields {
+		if field == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Sources lists the names of the sources in order.
+func (c *Chain) Sources() []string {
+	names := make([]string, len(c.sources))
+	for i, source := range c.sources {
+		names[i] = source.Name
+	}
+	return names
+}
+
+// Lookup asks the sources in order, by DOI where a source knows DOIs and
+// the book has one, by ISBN otherwise. An ISBN or DOI found on the way is
+// used for the sources after. Errors of single sources are joined, the
+// results of the others are returned with them.
+func (c *Chain) Lookup(ctx context.Context, book entity.Book) (map[string]LookupResult, error) {
+	found := make(map[string]LookupResult)
+	var errs []error
+	for _, source := range c.sources {
+		var result LookupResult
+		var err error
+		switch {
+		case source.DOI != nil && book.DOI != "":
+			result, err = source.DOI.LookupByDOI(ctx, book.DOI)
+		case source.ISBN != nil && book.ISBN != "":
+			result, err = source.ISBN.LookupByISBN(ctx, book.ISBN)
+		default:
+			continue
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", source.Name, err))
+			continue
+		}
+		found[source.Name] = result
+		if book.ISBN == "" {
+			book.ISBN = result.Book.ISBN
+		}
+		if book.DOI == "" {
+			book.DOI = result.Book.DOI
+		}
+	}
+	return found, errors.Join(errs...)
+}
+
+// Merge takes each field from the first source with a value, the file
+// source being book and cover.
+func (c *Chain) Merge(book entity.Book, cover []byte, found map[string]LookupResult) (entity.Book, []byte) {
+	results := make(map[string]LookupResult, len(found)+1)
+	for name, result := range found {
+		results[name] = result
+	}
+	results[FileSource] = LookupResult{Book: book, Cover: cover}
+
+	first := func(field string, has func(LookupResult) bool) LookupResult {
+		for _, name := range c.order(field) {
+			if result, ok := results[name]; ok && has(result) {
+				return result
+			}
+		}
+		return LookupResult{}
+	}
+	text := func(field string, value func(entity.Book) string) string {
+		result := first(field, func(r LookupResult) bool { return value(r.Book) != "" })
+		return value(result.Book)
+	}
+
+	merged := book
+	merged.Title = text("title", func(b entity.Book) string { return b.Title })
+	merged.Author = text("author", func(b entity.Book) string { return b.Author })
+	merged.Description = text("description", func(b entity.Book) string { return b.Description })
+	merged.Publisher = text("publisher", func(b entity.Book) string { return b.Publisher })
+	merged.ISBN = text("isbn", func(b entity.Book) string { return b.ISBN })
+	merged.DOI = text("doi", func(b entity.Book) string { return b.DOI })
+	merged.Language = text("language", func(b entity.Book) string { return b.Language })
+	result := first("year", func(r LookupResult) bool { return r.Book.Year != 0 })
+	merged.Year = result.Book.Year
+	// the position belongs to the series it was found with
+	result = first("series", func(r LookupResult) bool { return r.Book.Series != "" })
+	merged.Series, merged.SeriesIndex = result.Book.Series, result.Book.SeriesIndex
+	result = first("cover", func(r LookupResult) bool { return len(r.Cover) > 0 })
+	return merged, result.Cover
+}
+
+// order returns the sources to take field from, the ones of its rule first.
+func (c *Chain) order(field string) []string {
+	order := append([]string(nil), c.rules[field]...)
+	for _, name := range c.Sources() {
+		listed := false
+		for _, first := range c.rules[field] {
+			listed = listed || first == name
+		}
+		if !listed {
+			order = append(order, name)
+		}
+	}
+	return order
+}
@@ -0,0 +1,85 @@
+package bookmeta
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+type stubProvider struct {
+	result LookupResult
+	err    error
+	isbns  *[]string
+}
+
+func (p stubProvider) LookupByISBN(_ context.Context, isbn string) (LookupResult, error) {
+	if p.isbns != nil {
+		*p.isbns = append(*p.isbns, isbn)
+	}
+	return p.result, p.err
+}
+
+func (p stubProvider) LookupByDOI(context.Context, string) (LookupResult, error) {
+	return p.result, p.err
+}
+
+func TestChainMergesFieldsInOrder(t *testing.T) {
+	var isbns []string
+	rules, err := ParseFieldRules("cover=openlibrary, file; description = douban")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	chain, err := NewChain([]Source{
+		{Name: "crossref", DOI: stubProvider{result: LookupResult{Book: entity.Book{Title: "Registered title", ISBN: "9780261102217"}}}},
+		{Name: FileSource},
+		{Name: "openlibrary", ISBN: stubProvider{result: LookupResult{Book: entity.Book{Publisher: "Allen & Unwin", Year: 1954}, Cover: []byte("openlibrary")}, isbns: &isbns}},
+		{Name: "douban", ISBN: stubProvider{err: ErrNoCookie}},
+	}, rules)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	file := entity.Book{ID: "book-id", Title: "untitled", Author: "J.R.R. Tolkien", Description: "From the file", Year: 2001, DOI: "10.1000/xyz"}
+	found, err := chain.Lookup(context.Background(), file)
+	if !errors.Is(err, ErrNoCookie) {
+		t.Fatalf("expected the douban error to be returned, got %v", err)
+	}
+	if len(found) != 2 {
+		t.Fatalf("expected the results of crossref and openlibrary, got %+v", found)
+	}
+	if len(isbns) != 1 || isbns[0] != "9780261102217" {
+		t.Fatalf("expected the ISBN found by crossref to be looked up, got %v", isbns)
+	}
+
+	book, cover := chain.Merge(file, []byte("file"), found)
+	if book.ID != "book-id" || book.Title != "Registered title" || book.Author != "J.R.R. Tolkien" {
+		t.Fatalf("expected title of crossref and author of the file, got %+v", book)
+	}
+	if book.Year != 2001 || book.Publisher != "Allen & Unwin" {
+		t.Fatalf("expected year of the file and publisher of openlibrary, got %+v", book)
+	}
+	if book.Description != "From the file" {
+		t.Fatalf("expected the file to follow the sources of the rule, got %q", book.Description)
+	}
+	if string(cover) != "openlibrary" {
+		t.Fatalf("expected the cover rule to prefer openlibrary, got %q", cover)
+	}
+}
+
+func TestNewChainRejectsUnknownRules(t *testing.T) {
+	if _, err := NewChain([]Source{{Name: "douban"}}, FieldRules{"title": {"openlibrary"}}); err == nil {
+		t.Fatal("expected a rule naming a disabled source to be rejected")
+	}
+	if _, err := NewChain([]Source{{Name: "douban"}}, FieldRules{"blurb": {"douban"}}); err == nil {
+		t.Fatal("expected a rule for an unknown field to be rejected")
+	}
+	chain, err := NewChain([]Source{{Name: "douban"}}, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sources := chain.Sources(); len(sources) != 2 || sources[1] != FileSource {
+		t.Fatalf("expected the file to rank last when not listed, got %v", sources)
+	}
+}
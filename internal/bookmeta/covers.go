@@ -0,0 +1,65 @@
+package bookmeta
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// OpenLibraryCovers fetches covers from the Open Library Covers API by ISBN,
+// for books whose file has no cover.
+type OpenLibraryCovers struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewOpenLibraryCovers(client *http.Client) *OpenLibraryCovers {
+	if client == nil {
+		client = &http.Client{Timeout: 8 * time.Second}
+	}
+	return &OpenLibraryCovers{baseURL: "https://covers.openlibrary.org", client: client}
+}
+
+func NewOpenLibraryCoversWithBaseURL(baseURL string, client *http.Client) *OpenLibraryCovers {
+	covers := NewOpenLibraryCovers(client)
+	covers.baseURL = strings.TrimRight(baseURL, "/")
+	return covers
+}
+
+// Cover returns the large cover of the ISBN, ErrBookNotFound when Open
+// Library has none.
+func (c *OpenLibraryCovers) Cover(ctx context.Context, isbn string) ([]byte, error) {
+	isbn = normalizeISBN(isbn)
+	if isbn == "" {
+		return nil, ErrBookNotFound
+	}
+	// without default=false a missing cover is a blank image
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.baseURL+"/b/isbn/"+isbn+"-L.jpg?default=false", nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, ErrBookNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, fmt.Errorf("openlibrary covers request failed: %s", resp.Status)
+	}
+	cover, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return nil, fmt.Errorf("openlibrary covers response: %w", err)
+	}
+	if !strings.HasPrefix(http.DetectContentType(cover), "image/") {
+		return nil, ErrBookNotFound
+	}
+	return cover, nil
+}
@@ -0,0 +1,37 @@
+package bookmeta
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenLibraryCovers(t *testing.T) {
+	jpeg := []byte("\xff\xd8\xff\xe0 cover bytes")
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Query().Get("default") != "false":
+			w.Write([]byte("GIF89a blank"))
+		case r.URL.Path == "/b/isbn/9780261102217-L.jpg":
+			w.Write(jpeg)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	covers := NewOpenLibraryCoversWithBaseURL(server.URL, server.Client())
+	cover, err := covers.Cover(context.Background(), "978-0-261-10221-7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(cover) != string(jpeg) {
+		t.Fatalf("expected the large cover, got %q", cover)
+	}
+
+	if _, err = covers.Cover(context.Background(), "9780000000000"); !errors.Is(err, ErrBookNotFound) {
+		t.Fatalf("expected ErrBookNotFound, got %v", err)
+	}
+}
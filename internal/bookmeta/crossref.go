@@ -0,0 +1,168 @@
+package bookmeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// DOIProvider looks up articles and papers by their DOI.
+type DOIProvider interface {
+	LookupByDOI(ctx context.Context, doi string) (LookupResult, error)
+}
+
+// CrossrefProvider reads the metadata publishers registered for a DOI
+// from the Crossref REST API. It needs no account, a contact address puts
+// requests in the faster "polite" pool.
+type CrossrefProvider struct {
+	baseURL string
+	mailto  string
+	client  *http.Client
+}
+
+func NewCrossrefProvider(mailto string, client *http.Client) *CrossrefProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 8 * time.Second}
+	}
+	return &CrossrefProvider{
+		baseURL: "https://api.crossref.org",
+		mailto:  strings.TrimSpace(mailto),
+		client:  client,
+	}
+}
+
+func NewCrossrefProviderWithBaseURL(baseURL, mailto string, client *http.Client) *CrossrefProvider {
+	provider := NewCrossrefProvider(mailto, client)
+	provider.baseURL = strings.TrimRight(baseURL, "/")
+	return provider
+}
+
+func (p *CrossrefProvider) LookupByDOI(ctx context.Context, doi string) (LookupResult, error) {
+	doi = strings.TrimSpace(doi)
+	if doi == "" {
+		return LookupResult{}, ErrBookNotFound
+	}
+
+	endpoint := fmt.Sprintf("%s/works/%s", p.baseURL, url.PathEscape(doi))
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	agent := userAgent
+	if p.mailto != "" {
+		agent = fmt.Sprintf("%s; mailto:%s)", strings.TrimSuffix(userAgent, ")"), p.mailto)
+	}
+	req.Header.Set("User-Agent", agent)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return LookupResult{}, ErrBookNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return LookupResult{}, fmt.Errorf("crossref request failed: %s", resp.Status)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 4<<20))
+	if err != nil {
+		return LookupResult{}, err
+	}
+	return parseCrossrefWork(body)
+}
+
+type crossrefWork struct {
+	Message struct {
+		DOI            string   `json:"DOI"`
+		Title          []string `json:"title"`
+		ContainerTitle []string `json:"container-title"`
+		Publisher      string   `json:"publisher"`
+		Volume         string   `json:"volume"`
+		Abstract       string   `json:"abstract"`
+		Language       string   `json:"language"`
+		ISBN           []string `json:"ISBN"`
+		Author         []struct {
+			Given  string `json:"given"`
+			Family string `json:"family"`
+			Name   string `json:"name"`
+		} `json:"author"`
+		Issued          crossrefDate `json:"issued"`
+		PublishedPrint  crossrefDate `json:"published-print"`
+		PublishedOnline crossrefDate `json:"published-online"`
+	} `json:"message"`
+}
+
+type crossrefDate struct {
+	DateParts [][]int `json:"date-parts"`
+}
+
+func (d crossrefDate) year() int {
+	if len(d.DateParts) == 0 || len(d.DateParts[0]) == 0 {
+		return 0
+	}
+	return d.DateParts[0][0]
+}
+
+// parseCrossrefWork maps a work to a book, the journal becomes the series
+// and its volume the position in the series.
+func parseCrossrefWork(body []byte) (LookupResult, error) {
+	var work crossrefWork
+	if err := json.Unmarshal(body, &work); err != nil {
+		return LookupResult{}, fmt.Errorf("crossref response: %w", err)
+	}
+	m := work.Message
+
+	var book entity.Book
+	if len(m.Title) > 0 {
+		book.Title = cleanText(stripTags(m.Title[0]))
+	}
+	authors := make([]string, 0, len(m.Author))
+	for _, author := range m.Author {
+		name := cleanText(author.Given + " " + author.Family)
+		if name == "" {
+			name = cleanText(author.Name)
+		}
+		if name != "" {
+			authors = append(authors, name)
+		}
+	}
+	book.Author = strings.Join(authors, ", ")
+	book.Publisher = cleanText(m.Publisher)
+	if len(m.ContainerTitle) > 0 {
+		book.Series = cleanText(stripTags(m.ContainerTitle[0]))
+	}
+	if volume, err := strconv.Atoi(strings.TrimSpace(m.Volume)); err == nil && book.Series != "" {
+		book.SeriesIndex = &decimal.NullDecimal{Decimal: decimal.NewFromInt(int64(volume)), Valid: true}
+	}
+	for _, date := range []crossrefDate{m.Issued, m.PublishedPrint, m.PublishedOnline} {
+		if book.Year = date.year(); book.Year > 0 {
+			break
+		}
+	}
+	// abstracts are JATS XML, <jats:p> and friends
+	book.Description = cleanText(stripTags(m.Abstract))
+	book.Language = m.Language
+	if len(m.ISBN) > 0 {
+		book.ISBN = normalizeISBN(m.ISBN[0])
+	}
+	book.DOI = strings.ToLower(m.DOI)
+
+	if book.Title == "" {
+		return LookupResult{}, ErrBookNotFound
+	}
+	return LookupResult{Book: book}, nil
+}
@@ -0,0 +1,64 @@
+package bookmeta
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCrossrefProviderLookupByDOI(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.EscapedPath() != "/works/10.1016%2Fj.cell.2009.01.042" {
+			http.NotFound(w, r)
+			return
+		}
+		if !strings.Contains(r.Header.Get("User-Agent"), "mailto:library@example.com") {
+			t.Errorf("expected the contact address in the user agent, got %q", r.Header.Get("User-Agent"))
+		}
+		w.Write([]byte(`{"status":"ok","message":{
+			"DOI":"10.1016/J.CELL.2009.01.042",
+			"title":["MicroRNAs: Target Recognition and Regulatory Functions"],
+			"container-title":["Cell"],
+			"publisher":"Elsevier BV",
+			"volume":"136",
+			"language":"en",
+			"abstract":"<jats:p>MicroRNAs are <jats:italic>endogenous</jats:italic> RNAs.</jats:p>",
+			"author":[{"given":"David P.","family":"Bartel"},{"name":"The RNA Consortium"}],
+			"issued":{"date-parts":[[2009,1]]}
+		}}`))
+	}))
+	defer server.Close()
+
+	provider := NewCrossrefProviderWithBaseURL(server.URL, "library@example.com", server.Client())
+	result, err := provider.LookupByDOI(context.Background(), "10.1016/j.cell.2009.01.042")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	book := result.Book
+	if book.Title != "MicroRNAs: Target Recognition and Regulatory Functions" {
+		t.Fatalf("expected title, got %q", book.Title)
+	}
+	if book.Author != "David P. Bartel, The RNA Consortium" {
+		t.Fatalf("expected authors, got %q", book.Author)
+	}
+	if book.Series != "Cell" || book.SeriesIndex == nil || book.SeriesIndex.Decimal.String() != "136" {
+		t.Fatalf("expected the journal and volume as series, got %q %v", book.Series, book.SeriesIndex)
+	}
+	if book.Year != 2009 || book.Publisher != "Elsevier BV" || book.Language != "en" {
+		t.Fatalf("expected year, publisher and language, got %+v", book)
+	}
+	if book.Description != "MicroRNAs are endogenous RNAs." {
+		t.Fatalf("expected the abstract without JATS tags, got %q", book.Description)
+	}
+	if book.DOI != "10.1016/j.cell.2009.01.042" {
+		t.Fatalf("expected the DOI in lower case, got %q", book.DOI)
+	}
+
+	if _, err = provider.LookupByDOI(context.Background(), "10.1000/missing"); !errors.Is(err, ErrBookNotFound) {
+		t.Fatalf("expected ErrBookNotFound, got %v", err)
+	}
+}
@@ -22,8 +22,13 @@ type DoubanProvider struct {
 	cookieDomain string
 	cookieSource CookieSource
 	client       *http.Client
+	throttle     *throttle
 }
 
+// defaultDoubanInterval keeps lookups of a batch upload from being taken
+// for a scraper and answered with a verification page.
+const defaultDoubanInterval = 2 * time.Second
+
 func NewDoubanProvider(cookieSource CookieSource, client *http.Client) *DoubanProvider {
 	if client == nil {
 		client = &http.Client{Timeout: 8 * time.Second}
@@ -33,6 +38,7 @@ func NewDoubanProvider(cookieSource CookieSource, client *http.Client) *DoubanPr
 		cookieDomain: "douban.com",
 		cookieSource: cookieSource,
 		client:       client,
+		throttle:     newThrottle(defaultDoubanInterval),
 	}
 }
 
@@ -48,6 +54,14 @@ func (p *DoubanProvider) SetCookieDomain(domain string) {
 	}
 }
 
+// SetRequestInterval spaces the book page requests to Douban, covers come
+// from its image CDN and are not held back. Zero sends them as they come.
+func (p *DoubanProvider) SetRequestInterval(interval time.Duration) {
+	if interval >= 0 {
+		p.throttle = newThrottle(interval)
+	}
+}
+
 func (p *DoubanProvider) LookupByISBN(ctx context.Context, isbn string) (LookupResult, error) {
 	isbn = normalizeISBN(isbn)
 	if isbn == "" {
@@ -94,6 +108,9 @@ func (p *DoubanProvider) fetchBookPage(ctx context.Context, isbn, cookie string)
 	req.Header.Set("Accept", "text/html,application/xhtml+xml,application/xml;q=0.9,*/*;q=0.8")
 	req.Header.Set("Accept-Language", "zh-CN,zh;q=0.9,en;q=0.8")
 
+	if err = p.throttle.wait(ctx); err != nil {
+		return nil, err
+	}
 	resp, err := p.client.Do(req)
 	if err != nil {
 		return nil, err
@@ -103,6 +120,10 @@ func (p *DoubanProvider) fetchBookPage(ctx context.Context, isbn, cookie string)
 	if resp.StatusCode == http.StatusNotFound {
 		return nil, ErrBookNotFound
 	}
+	if resp.StatusCode == http.StatusTooManyRequests {
+		p.throttle.pause(resp)
+		return nil, ErrRateLimited
+	}
 	if resp.StatusCode == http.StatusForbidden || resp.StatusCode == http.StatusUnauthorized {
 		return nil, fmt.Errorf("douban rejected request: %s", resp.Status)
 	}
@@ -115,7 +136,9 @@ func (p *DoubanProvider) fetchBookPage(ctx context.Context, isbn, cookie string)
 		return nil, err
 	}
 	if bytes.Contains(body, []byte("检测到有异常请求")) || bytes.Contains(body, []byte("sec.douban.com")) {
-		return nil, fmt.Errorf("douban requires verification")
+		// asking again right away keeps the verification up
+		p.throttle.pause(nil)
+		return nil, fmt.Errorf("douban requires verification: %w", ErrRateLimited)
 	}
 	return body, nil
 }
@@ -1,6 +1,13 @@
 package bookmeta
 
-import "testing"
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
 
 func TestParseDoubanBookPage(t *testing.T) {
 	page := []byte(`
@@ -81,3 +88,47 @@ func TestParseDoubanBookPageFallsBackToInfoBlock(t *testing.T) {
 		t.Fatalf("expected cleaned summary, got %q", book.Description)
 	}
 }
+
+func TestDoubanProviderSpacesRequests(t *testing.T) {
+	var calls []time.Time
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls = append(calls, time.Now())
+		w.Write([]byte(`<h1><span>悉达多</span></h1>`))
+	}))
+	defer server.Close()
+
+	provider := NewDoubanProviderWithBaseURL(server.URL, NewStaticCookieSource("bid=1"), server.Client())
+	provider.SetRequestInterval(50 * time.Millisecond)
+	for _, isbn := range []string{"9787208106087", "9787108041531"} {
+		if _, err := provider.LookupByISBN(context.Background(), isbn); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(calls) != 2 || calls[1].Sub(calls[0]) < 50*time.Millisecond {
+		t.Fatalf("requests were not spaced: %v", calls)
+	}
+}
+
+func TestDoubanProviderPausesWhenRateLimited(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.Header().Set("Retry-After", "120")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	provider := NewDoubanProviderWithBaseURL(server.URL, NewStaticCookieSource("bid=1"), server.Client())
+	provider.SetRequestInterval(0)
+	if _, err := provider.LookupByISBN(context.Background(), "9787208106087"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+
+	// during the pause lookups give up without asking
+	if _, err := provider.LookupByISBN(context.Background(), "9787208106087"); !errors.Is(err, ErrRateLimited) {
+		t.Fatalf("expected ErrRateLimited, got %v", err)
+	}
+	if calls != 1 {
+		t.Fatalf("expected one request, got %d", calls)
+	}
+}
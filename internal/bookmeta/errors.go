@@ -6,4 +6,5 @@ var (
 	ErrProviderDisabled = errors.New("metadata provider disabled")
 	ErrNoCookie         = errors.New("metadata provider cookie is empty")
 	ErrBookNotFound     = errors.New("book metadata not found")
+	ErrRateLimited      = errors.New("metadata provider rate limit reached")
 )
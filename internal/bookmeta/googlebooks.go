@@ -0,0 +1,110 @@
+package bookmeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// GoogleBooksProvider looks up books with the Google Books API. It works
+// without an API key at a low daily quota.
+type GoogleBooksProvider struct {
+	baseURL string
+	apiKey  string
+	client  *http.Client
+}
+
+func NewGoogleBooksProvider(apiKey string, client *http.Client) *GoogleBooksProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 8 * time.Second}
+	}
+	return &GoogleBooksProvider{
+		baseURL: "https://www.googleapis.com",
+		apiKey:  strings.TrimSpace(apiKey),
+		client:  client,
+	}
+}
+
+func NewGoogleBooksProviderWithBaseURL(baseURL, apiKey string, client *http.Client) *GoogleBooksProvider {
+	provider := NewGoogleBooksProvider(apiKey, client)
+	provider.baseURL = strings.TrimRight(baseURL, "/")
+	return provider
+}
+
+type googleBooksVolumes struct {
+	Items []struct {
+		VolumeInfo struct {
+			Title         string   `json:"title"`
+			Authors       []string `json:"authors"`
+			Publisher     string   `json:"publisher"`
+			PublishedDate string   `json:"publishedDate"`
+			Description   string   `json:"description"`
+			Language      string   `json:"language"`
+			ImageLinks    struct {
+				Thumbnail string `json:"thumbnail"`
+			} `json:"imageLinks"`
+		} `json:"volumeInfo"`
+	} `json:"items"`
+}
+
+func (p *GoogleBooksProvider) LookupByISBN(ctx context.Context, isbn string) (LookupResult, error) {
+	isbn = normalizeISBN(isbn)
+	if isbn == "" {
+		return LookupResult{}, ErrBookNotFound
+	}
+
+	query := url.Values{}
+	query.Set("q", "isbn:"+isbn)
+	if p.apiKey != "" {
+		query.Set("key", p.apiKey)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/books/v1/volumes?"+query.Encode(), nil)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return LookupResult{}, fmt.Errorf("google books request failed: %s", resp.Status)
+	}
+
+	var volumes googleBooksVolumes
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&volumes); err != nil {
+		return LookupResult{}, fmt.Errorf("google books response: %w", err)
+	}
+	if len(volumes.Items) == 0 || volumes.Items[0].VolumeInfo.Title == "" {
+		return LookupResult{}, ErrBookNotFound
+	}
+
+	info := volumes.Items[0].VolumeInfo
+	book := entity.Book{
+		Title:       cleanText(info.Title),
+		Author:      strings.Join(info.Authors, ", "),
+		Publisher:   cleanText(info.Publisher),
+		Year:        parseYear(info.PublishedDate),
+		Description: info.Description,
+		Language:    info.Language,
+		ISBN:        isbn,
+	}
+
+	result := LookupResult{Book: book}
+	if thumbnail := info.ImageLinks.Thumbnail; thumbnail != "" {
+		// the thumbnail links come without TLS and with a page curl
+		thumbnail = strings.Replace(thumbnail, "http://", "https://", 1)
+		thumbnail = strings.Replace(thumbnail, "&edge=curl", "", 1)
+		result.Cover = fetchCoverImage(ctx, p.client, thumbnail)
+	}
+	return result, nil
+}
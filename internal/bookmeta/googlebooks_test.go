@@ -0,0 +1,47 @@
+package bookmeta
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGoogleBooksProviderLookupByISBN(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("key") != "secret" {
+			t.Errorf("expected the API key, got %q", r.URL.RawQuery)
+		}
+		if r.URL.Query().Get("q") != "isbn:9780261102217" {
+			w.Write([]byte(`{"kind":"books#volumes","totalItems":0}`))
+			return
+		}
+		w.Write([]byte(`{"totalItems":1,"items":[{"volumeInfo":{
+			"title":"The Fellowship of the Ring",
+			"authors":["J.R.R. Tolkien"],
+			"publisher":"HarperCollins",
+			"publishedDate":"1999-07",
+			"description":"<p>The first part.</p>",
+			"language":"en"
+		}}]}`))
+	}))
+	defer server.Close()
+
+	provider := NewGoogleBooksProviderWithBaseURL(server.URL, "secret", server.Client())
+	result, err := provider.LookupByISBN(context.Background(), "9780261102217")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	book := result.Book
+	if book.Title != "The Fellowship of the Ring" || book.Author != "J.R.R. Tolkien" || book.Year != 1999 {
+		t.Fatalf("expected title, author and year, got %+v", book)
+	}
+	if book.Description != "<p>The first part.</p>" || book.Language != "en" {
+		t.Fatalf("expected description and language, got %+v", book)
+	}
+
+	if _, err = provider.LookupByISBN(context.Background(), "9780000000000"); !errors.Is(err, ErrBookNotFound) {
+		t.Fatalf("expected ErrBookNotFound, got %v", err)
+	}
+}
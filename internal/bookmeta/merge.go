@@ -21,6 +21,9 @@ func MergeMissingBookMetadata(book entity.Book, metadata entity.Book) entity.Boo
 	if book.ISBN == "" {
 		book.ISBN = metadata.ISBN
 	}
+	if book.DOI == "" {
+		book.DOI = metadata.DOI
+	}
 	if book.Series == "" {
 		book.Series = metadata.Series
 	}
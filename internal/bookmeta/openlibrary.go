@@ -0,0 +1,106 @@
+package bookmeta
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// OpenLibraryProvider looks up books in the catalog of the Internet
+// Archive's Open Library, free and without an account.
+type OpenLibraryProvider struct {
+	baseURL string
+	client  *http.Client
+}
+
+func NewOpenLibraryProvider(client *http.Client) *OpenLibraryProvider {
+	if client == nil {
+		client = &http.Client{Timeout: 8 * time.Second}
+	}
+	return &OpenLibraryProvider{baseURL: "https://openlibrary.org", client: client}
+}
+
+func NewOpenLibraryProviderWithBaseURL(baseURL string, client *http.Client) *OpenLibraryProvider {
+	provider := NewOpenLibraryProvider(client)
+	provider.baseURL = strings.TrimRight(baseURL, "/")
+	return provider
+}
+
+type openLibraryBook struct {
+	Title       string `json:"title"`
+	PublishDate string `json:"publish_date"`
+	Authors     []struct {
+		Name string `json:"name"`
+	} `json:"authors"`
+	Publishers []struct {
+		Name string `json:"name"`
+	} `json:"publishers"`
+	Cover struct {
+		Large  string `json:"large"`
+		Medium string `json:"medium"`
+	} `json:"cover"`
+}
+
+func (p *OpenLibraryProvider) LookupByISBN(ctx context.Context, isbn string) (LookupResult, error) {
+	isbn = normalizeISBN(isbn)
+	if isbn == "" {
+		return LookupResult{}, ErrBookNotFound
+	}
+
+	query := url.Values{}
+	query.Set("bibkeys", "ISBN:"+isbn)
+	query.Set("format", "json")
+	query.Set("jscmd", "data")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/api/books?"+query.Encode(), nil)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	req.Header.Set("User-Agent", userAgent)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return LookupResult{}, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return LookupResult{}, fmt.Errorf("openlibrary request failed: %s", resp.Status)
+	}
+
+	var books map[string]openLibraryBook
+	if err = json.NewDecoder(io.LimitReader(resp.Body, 4<<20)).Decode(&books); err != nil {
+		return LookupResult{}, fmt.Errorf("openlibrary response: %w", err)
+	}
+	data, ok := books["ISBN:"+isbn]
+	if !ok || data.Title == "" {
+		return LookupResult{}, ErrBookNotFound
+	}
+
+	authors := make([]string, 0, len(data.Authors))
+	for _, author := range data.Authors {
+		authors = append(authors, cleanText(author.Name))
+	}
+	book := entity.Book{
+		Title:  cleanText(data.Title),
+		Author: strings.Join(authors, ", "),
+		Year:   parseYear(data.PublishDate),
+		ISBN:   isbn,
+	}
+	if len(data.Publishers) > 0 {
+		book.Publisher = cleanText(data.Publishers[0].Name)
+	}
+
+	result := LookupResult{Book: book}
+	if coverURL := data.Cover.Large; coverURL != "" {
+		result.Cover = fetchCoverImage(ctx, p.client, coverURL)
+	} else if coverURL = data.Cover.Medium; coverURL != "" {
+		result.Cover = fetchCoverImage(ctx, p.client, coverURL)
+	}
+	return result, nil
+}
@@ -0,0 +1,50 @@
+package bookmeta
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestOpenLibraryProviderLookupByISBN(t *testing.T) {
+	var server *httptest.Server
+	server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/covers/L.jpg":
+			w.Write([]byte("cover bytes"))
+		case r.URL.Query().Get("bibkeys") == "ISBN:9780261102217":
+			w.Write([]byte(`{"ISBN:9780261102217":{
+				"title":"The Fellowship of the Ring",
+				"authors":[{"name":"J.R.R. Tolkien"}],
+				"publishers":[{"name":"HarperCollins"}],
+				"publish_date":"July 1, 1999",
+				"cover":{"large":"` + server.URL + `/covers/L.jpg"}
+			}}`))
+		default:
+			w.Write([]byte(`{}`))
+		}
+	}))
+	defer server.Close()
+
+	provider := NewOpenLibraryProviderWithBaseURL(server.URL, server.Client())
+	result, err := provider.LookupByISBN(context.Background(), "978-0-261-10221-7")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	book := result.Book
+	if book.Title != "The Fellowship of the Ring" || book.Author != "J.R.R. Tolkien" || book.Publisher != "HarperCollins" {
+		t.Fatalf("expected title, author and publisher, got %+v", book)
+	}
+	if book.Year != 1999 || book.ISBN != "9780261102217" {
+		t.Fatalf("expected year and isbn, got %+v", book)
+	}
+	if string(result.Cover) != "cover bytes" {
+		t.Fatalf("expected the large cover, got %q", result.Cover)
+	}
+
+	if _, err = provider.LookupByISBN(context.Background(), "9780000000000"); !errors.Is(err, ErrBookNotFound) {
+		t.Fatalf("expected ErrBookNotFound, got %v", err)
+	}
+}
@@ -2,10 +2,15 @@ package bookmeta
 
 import (
 	"context"
+	"io"
+	"net/http"
 
 	"github.com/banjuer/kompanion/internal/entity"
 )
 
+// userAgent identifies requests to the public APIs, which ask for it.
+const userAgent = "kompanion (https://github.com/banjuer/kompanion)"
+
 type LookupResult struct {
 	Book  entity.Book
 	Cover []byte
@@ -36,3 +41,25 @@ type DisabledProvider struct{}
 func (DisabledProvider) LookupByISBN(context.Context, string) (LookupResult, error) {
 	return LookupResult{}, ErrProviderDisabled
 }
+
+// fetchCoverImage downloads a cover, nil when it can not be fetched.
+func fetchCoverImage(ctx context.Context, client *http.Client, coverURL string) []byte {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, coverURL, nil)
+	if err != nil {
+		return nil
+	}
+	req.Header.Set("User-Agent", userAgent)
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 8<<20))
+	if err != nil {
+		return nil
+	}
+	return data
+}
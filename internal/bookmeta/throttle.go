@@ -0,0 +1,69 @@
+package bookmeta
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// throttle spaces the requests to a site, callers wait for their turn in
+// the order they came. Sites that answer too many requests pause it.
+type throttle struct {
+	mu       sync.Mutex
+	interval time.Duration
+	next     time.Time
+	paused   time.Time
+}
+
+// defaultBackoff pauses requests after a rate limit answer without
+// Retry-After.
+const defaultBackoff = time.Minute
+
+func newThrottle(interval time.Duration) *throttle {
+	return &throttle{interval: interval}
+}
+
+// wait blocks until the next request may go out. During a pause it fails
+// with ErrRateLimited right away, an upload is not held for minutes.
+func (t *throttle) wait(ctx context.Context) error {
+	t.mu.Lock()
+	now := time.Now()
+	if now.Before(t.paused) {
+		t.mu.Unlock()
+		return ErrRateLimited
+	}
+	at := t.next
+	if at.Before(now) {
+		at = now
+	}
+	t.next = at.Add(t.interval)
+	t.mu.Unlock()
+
+	delay := time.Until(at)
+	if delay <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-timer.C:
+		return nil
+	}
+}
+
+// pause holds requests back for d, the Retry-After of resp when it has one.
+func (t *throttle) pause(resp *http.Response) {
+	d := defaultBackoff
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil && seconds > 0 {
+			d = time.Duration(seconds) * time.Second
+		}
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.paused = time.Now().Add(d)
+}
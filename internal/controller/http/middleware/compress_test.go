@@ -0,0 +1,119 @@
+package middleware_test
+
+import (
+	"compress/gzip"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/controller/http/middleware"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := map[string]string{
+		"":                   "",
+		"identity":           "",
+		"gzip, deflate":      "gzip",
+		"gzip, deflate, br":  "br",
+		"br;q=0.5, gzip":     "gzip",
+		"br;q=0, gzip;q=0.1": "gzip",
+		"*":                  "gzip",
+	}
+	for header, want := range tests {
+		if got := middleware.NegotiateEncoding(header); got != want {
+			t.Errorf("NegotiateEncoding(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
+
+func compressRouter() *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	handler := gin.New()
+	handler.Use(middleware.Compress())
+	handler.GET("/json", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"title": strings.Repeat("Dune ", 100)})
+	})
+	handler.GET("/download", func(c *gin.Context) {
+		c.Header("Content-Disposition", "attachment; filename=dune.epub")
+		c.Data(http.StatusOK, "application/epub+zip", []byte("PK"))
+	})
+	handler.GET("/stream", func(c *gin.Context) {
+		c.Header("Content-Type", "application/x-ndjson")
+		c.String(http.StatusOK, "{\"id\":1}\n")
+		c.Writer.Flush()
+		c.String(http.StatusOK, "{\"id\":2}\n")
+	})
+	return handler
+}
+
+func get(handler http.Handler, path, acceptEncoding string) *httptest.ResponseRecorder {
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("Accept-Encoding", acceptEncoding)
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestCompressJSON(t *testing.T) {
+	handler := compressRouter()
+	for encoding, reader := range map[string]func(io.Reader) (io.Reader, error){
+		"gzip": func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) },
+		"br":   func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil },
+	} {
+		w := get(handler, "/json", encoding)
+		if ce := w.Header().Get("Content-Encoding"); ce != encoding {
+			t.Fatalf("expected %s encoding, got %q", encoding, ce)
+		}
+		r, err := reader(w.Body)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, err := io.ReadAll(r)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if !strings.Contains(string(body), `"title":"Dune Dune`) {
+			t.Errorf("unexpected body %q", body)
+		}
+	}
+}
+
+func TestCompressSkipsDownloads(t *testing.T) {
+	w := get(compressRouter(), "/download", "gzip, br")
+	if ce := w.Header().Get("Content-Encoding"); ce != "" {
+		t.Errorf("expected no encoding, got %q", ce)
+	}
+	if w.Body.String() != "PK" {
+		t.Errorf("unexpected body %q", w.Body.String())
+	}
+}
+
+func TestCompressFlushesStream(t *testing.T) {
+	w := get(compressRouter(), "/stream", "gzip")
+	if !w.Flushed {
+		t.Fatal("expected stream to be flushed")
+	}
+	r, err := gzip.NewReader(w.Body)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(body) != "{\"id\":1}\n{\"id\":2}\n" {
+		t.Errorf("unexpected body %q", body)
+	}
+}
+
+func TestCompressVaries(t *testing.T) {
+	w := get(compressRouter(), "/json", "")
+	if w.Header().Get("Vary") != "Accept-Encoding" || w.Header().Get("Content-Encoding") != "" {
+		t.Errorf("unexpected headers %v", w.Header())
+	}
+}
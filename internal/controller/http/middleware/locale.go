@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/library"
+)
+
+// Locale picks the sort and search locale of a request from the locale
+// query parameter or the Accept-Language header, so people sharing one
+// instance each get titles ordered for their language.
+func Locale() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		locale := c.Query("locale")
+		if locale == "" {
+			locale = PreferredLanguage(c.GetHeader("Accept-Language"))
+		}
+		if locale != "" {
+			c.Request = c.Request.WithContext(library.WithLocale(c.Request.Context(), locale))
+		}
+		c.Next()
+	}
+}
+
+// PreferredLanguage returns the tag with the highest quality in an
+// Accept-Language header, the first one wins a tie.
+func PreferredLanguage(header string) string {
+	best, bestQ := "", 0.0
+	for _, part := range strings.Split(header, ",") {
+		tag, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		tag = strings.TrimSpace(tag)
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			parsed, err := strconv.ParseFloat(value, 64)
+			if err != nil {
+				continue
+			}
+			q = parsed
+		}
+		if q > bestQ {
+			best, bestQ = tag, q
+		}
+	}
+	return best
+}
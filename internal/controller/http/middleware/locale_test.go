@@ -0,0 +1,23 @@
+package middleware_test
+
+import (
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/controller/http/middleware"
+)
+
+func TestPreferredLanguage(t *testing.T) {
+	tests := map[string]string{
+		"":                        "",
+		"de-DE,de;q=0.9,en;q=0.8": "de-DE",
+		"en;q=0.5, fr;q=0.9":      "fr",
+		"*;q=1, nl":               "nl",
+		"es;q=0, it;q=0.1":        "it",
+		"pt-BR;q=bad, ru;q=0.3":   "ru",
+	}
+	for header, want := range tests {
+		if got := middleware.PreferredLanguage(header); got != want {
+			t.Errorf("PreferredLanguage(%q) = %q, want %q", header, got, want)
+		}
+	}
+}
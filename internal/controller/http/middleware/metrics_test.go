@@ -0,0 +1,44 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus"
+
+	"github.com/banjuer/kompanion/internal/controller/http/middleware"
+)
+
+func TestMetricsLabelsRequestsByRoute(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	handler := gin.New()
+	handler.Use(middleware.Metrics())
+	handler.GET("/syncs/progress/:document", func(c *gin.Context) { c.Status(http.StatusNotFound) })
+
+	for _, path := range []string{"/syncs/progress/a", "/syncs/progress/b", "/nowhere"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "kompanion_http_requests_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			labels := map[string]string{}
+			for _, label := range m.GetLabel() {
+				labels[label.GetName()] = label.GetValue()
+			}
+			counts[labels["route"]+" "+labels["code"]] = m.GetCounter().GetValue()
+		}
+	}
+	if counts["/syncs/progress/:document 404"] != 2 || counts["unmatched 404"] != 1 {
+		t.Fatalf("unexpected request counts %v", counts)
+	}
+}
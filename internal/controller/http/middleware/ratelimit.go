@@ -0,0 +1,119 @@
+package middleware
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/ratelimit"
+)
+
+// RateScope limits the requests Match picks, each client on its own.
+type RateScope struct {
+	Name  string
+	Limit ratelimit.Limit
+	Match func(c *gin.Context) bool
+}
+
+const rateLimiterKey = "rateLimiter"
+
+// rateLimiter takes from the buckets of the scopes a request falls in.
+type rateLimiter struct {
+	store  ratelimit.Store
+	l      logger.Interface
+	scopes []RateScope
+	taken  bool
+}
+
+// RateLimit refuses requests over the limit of a scope they fall in with
+// 429 Too Many Requests and Retry-After. Requests without credentials are
+// limited per IP. Requests sending them, an Authorization header, the
+// KOReader sync headers or a session cookie, are limited per account by
+// the auth middleware once it checked them, see RateLimitAccount; made up
+// credentials would give a client a fresh bucket for every request. When
+// the store fails requests go through.
+func RateLimit(store ratelimit.Store, l logger.Interface, scopes ...RateScope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		limiter := &rateLimiter{store: store, l: l}
+		for _, scope := range scopes {
+			if scope.Match(c) {
+				limiter.scopes = append(limiter.scopes, scope)
+			}
+		}
+		if len(limiter.scopes) == 0 {
+			c.Next()
+			return
+		}
+		if !hasCredentials(c) {
+			if limiter.take(c, "ip:"+c.ClientIP()) {
+				c.Next()
+			}
+			return
+		}
+		c.Set(rateLimiterKey, limiter)
+		c.Next()
+		if !limiter.taken {
+			// no auth middleware checked the credentials, the request
+			// counts for its IP
+			limiter.takeAll(c, "ip:"+c.ClientIP())
+		}
+	}
+}
+
+// RateLimitAccount limits a request sending credentials by the account
+// they were checked for, by IP when account is empty because they were
+// wrong. It is false when the request was refused.
+func RateLimitAccount(c *gin.Context, account string) bool {
+	value, ok := c.Get(rateLimiterKey)
+	if !ok {
+		return true
+	}
+	limiter := value.(*rateLimiter)
+	if limiter.taken {
+		return true
+	}
+	if account == "" {
+		return limiter.take(c, "ip:"+c.ClientIP())
+	}
+	return limiter.take(c, "account:"+account)
+}
+
+// take refuses the request when the client is over a limit.
+func (rl *rateLimiter) take(c *gin.Context, client string) bool {
+	retryAfter, ok := rl.takeAll(c, client)
+	if !ok {
+		c.Header("Retry-After", strconv.Itoa(max(int(math.Ceil(retryAfter.Seconds())), 1)))
+		c.AbortWithStatusJSON(http.StatusTooManyRequests, gin.H{"error": "too many requests"})
+	}
+	return ok
+}
+
+// takeAll takes from the bucket of the client in every scope, false with
+// the wait when one is empty.
+func (rl *rateLimiter) takeAll(c *gin.Context, client string) (time.Duration, bool) {
+	rl.taken = true
+	for _, scope := range rl.scopes {
+		ok, retryAfter, err := rl.store.Take(c.Request.Context(), scope.Name+":"+client, scope.Limit)
+		if err != nil {
+			rl.l.Error(err, "http - middleware - RateLimit")
+			continue
+		}
+		if !ok {
+			return retryAfter, false
+		}
+	}
+	return 0, true
+}
+
+// hasCredentials tells whether the request is left to an auth middleware.
+func hasCredentials(c *gin.Context) bool {
+	if c.GetHeader("Authorization") != "" || c.GetHeader("x-auth-user") != "" {
+		return true
+	}
+	_, err := c.Cookie("session")
+	return err == nil
+}
@@ -0,0 +1,103 @@
+package middleware_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/controller/http/middleware"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/ratelimit"
+)
+
+// newRateLimited serves /syncs/ behind an auth middleware taking the key
+// "key", limited to one request a minute, and /books without limit.
+func newRateLimited(t *testing.T, proxies []string) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	handler := gin.New()
+	if err := handler.SetTrustedProxies(proxies); err != nil {
+		t.Fatal(err)
+	}
+	handler.Use(middleware.RateLimit(ratelimit.NewMemory(), logger.New("error"), middleware.RateScope{
+		Name:  "sync",
+		Limit: ratelimit.Limit{Rate: 1.0 / 60, Burst: 1},
+		Match: func(c *gin.Context) bool { return strings.HasPrefix(c.Request.URL.Path, "/syncs/") },
+	}))
+	syncs := handler.Group("/syncs")
+	syncs.Use(func(c *gin.Context) {
+		user := c.GetHeader("x-auth-user")
+		if user != "" && c.GetHeader("x-auth-key") == "key" {
+			if middleware.RateLimitAccount(c, "device:"+user) {
+				c.Next()
+			}
+			return
+		}
+		if middleware.RateLimitAccount(c, "") {
+			c.AbortWithStatus(http.StatusUnauthorized)
+		}
+	})
+	syncs.GET("/progress/:document", func(c *gin.Context) { c.Status(http.StatusOK) })
+	handler.GET("/books", func(c *gin.Context) { c.Status(http.StatusOK) })
+	return handler
+}
+
+func serve(handler http.Handler, path, ip string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.RemoteAddr = ip + ":1234"
+	for name, value := range header {
+		req.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, req)
+	return w
+}
+
+func TestRateLimit(t *testing.T) {
+	handler := newRateLimited(t, nil)
+	device := func(user, key string) map[string]string {
+		return map[string]string{"x-auth-user": user, "x-auth-key": key}
+	}
+
+	if w := serve(handler, "/syncs/progress/doc", "192.0.2.1", device("kindle", "key")); w.Code != http.StatusOK {
+		t.Fatalf("expected the first sync through, got %d", w.Code)
+	}
+	w := serve(handler, "/syncs/progress/doc", "192.0.2.1", device("kindle", "key"))
+	if w.Code != http.StatusTooManyRequests || w.Header().Get("Retry-After") != "60" {
+		t.Fatalf("expected 429 with Retry-After 60, got %d %q", w.Code, w.Header().Get("Retry-After"))
+	}
+	if w = serve(handler, "/syncs/progress/doc", "192.0.2.1", device("kobo", "key")); w.Code != http.StatusOK {
+		t.Errorf("expected another device behind the address limited on its own, got %d", w.Code)
+	}
+
+	// wrong credentials do not get a bucket of their own
+	if w = serve(handler, "/syncs/progress/doc", "192.0.2.1", device("made-up", "wrong")); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected the first wrong credentials refused, got %d", w.Code)
+	}
+	if w = serve(handler, "/syncs/progress/doc", "192.0.2.1", device("made-up-2", "wrong")); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected other wrong credentials limited with the address, got %d", w.Code)
+	}
+	for i := 0; i < 3; i++ {
+		if w = serve(handler, "/books", "192.0.2.1", nil); w.Code != http.StatusOK {
+			t.Errorf("expected requests outside the scope through, got %d", w.Code)
+		}
+	}
+}
+
+func TestRateLimitForwardedFor(t *testing.T) {
+	spoofed := func(ip string) map[string]string { return map[string]string{"X-Forwarded-For": ip} }
+
+	handler := newRateLimited(t, nil)
+	serve(handler, "/syncs/progress/doc", "192.0.2.1", spoofed("198.51.100.1"))
+	if w := serve(handler, "/syncs/progress/doc", "192.0.2.1", spoofed("198.51.100.2")); w.Code != http.StatusTooManyRequests {
+		t.Errorf("expected X-Forwarded-For of a client not to pick a bucket, got %d", w.Code)
+	}
+
+	handler = newRateLimited(t, []string{"192.0.2.1"})
+	serve(handler, "/syncs/progress/doc", "192.0.2.1", spoofed("198.51.100.1"))
+	if w := serve(handler, "/syncs/progress/doc", "192.0.2.1", spoofed("198.51.100.2")); w.Code != http.StatusUnauthorized {
+		t.Errorf("expected clients behind a trusted proxy limited on their own, got %d", w.Code)
+	}
+}
@@ -0,0 +1,176 @@
+// Package middleware holds gin middlewares shared by every router.
+package middleware
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"runtime"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/sentry"
+)
+
+const (
+	_reportTimeout = 10 * time.Second
+	_maxFrames     = 64
+)
+
+// Reporter forwards recovered panics to an error tracker, see pkg/sentry.
+type Reporter interface {
+	Capture(ctx context.Context, e sentry.Event) (string, error)
+}
+
+// Problem is an RFC 7807 error body.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+}
+
+// headers never sent to the error tracker
+var sensitiveHeaders = map[string]bool{
+	"Authorization": true,
+	"Cookie":        true,
+	"X-Auth-Key":    true,
+	"X-Auth-User":   true,
+}
+
+// Recovery turns a panic in any handler, e.g. a parser choking on a malformed
+// book, into a 500 problem response instead of a dropped connection.
+// Reporter is optional, panics are reported in the background.
+func Recovery(l logger.Interface, r Reporter) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			rec := recover()
+			if rec == nil {
+				return
+			}
+			if brokenPipe(rec) {
+				l.Warn("http - recovery - client went away: %v", rec)
+				c.Abort()
+				return
+			}
+
+			frames := stackFrames()
+			l.Error(fmt.Errorf("http - recovery - %s %s: %v", c.Request.Method, c.Request.URL.Path, rec))
+			if r != nil {
+				event := sentry.Event{
+					Type:    "panic",
+					Message: fmt.Sprint(rec),
+					Frames:  frames,
+					Request: requestInfo(c),
+					Tags:    map[string]string{"route": c.FullPath()},
+				}
+				go func() {
+					ctx, cancel := context.WithTimeout(context.Background(), _reportTimeout)
+					defer cancel()
+					if _, err := r.Capture(ctx, event); err != nil {
+						l.Error(err, "http - recovery - report")
+					}
+				}()
+			}
+
+			if c.Writer.Written() {
+				c.Abort()
+				return
+			}
+			c.Header("Content-Type", "application/problem+json")
+			c.AbortWithStatusJSON(http.StatusInternalServerError, Problem{
+				Type:     "about:blank",
+				Title:    http.StatusText(http.StatusInternalServerError),
+				Status:   http.StatusInternalServerError,
+				Instance: c.Request.URL.Path,
+			})
+		}()
+		c.Next()
+	}
+}
+
+func brokenPipe(rec interface{}) bool {
+	err, ok := rec.(error)
+	if !ok {
+		return false
+	}
+	return errors.Is(err, syscall.EPIPE) || errors.Is(err, syscall.ECONNRESET) || errors.Is(err, http.ErrAbortHandler)
+}
+
+// stackFrames captures the panicking goroutine, oldest call first as Sentry expects.
+func stackFrames() []sentry.Frame {
+	pcs := make([]uintptr, _maxFrames)
+	n := runtime.Callers(3, pcs)
+	frames := runtime.CallersFrames(pcs[:n])
+
+	var result []sentry.Frame
+	for {
+		frame, more := frames.Next()
+		module, function := splitFunction(frame.Function)
+		result = append(result, sentry.Frame{
+			Function: function,
+			Module:   module,
+			Filename: frame.File,
+			AbsPath:  frame.File,
+			Lineno:   frame.Line,
+			InApp:    strings.HasPrefix(module, "github.com/banjuer/kompanion"),
+		})
+		if !more {
+			break
+		}
+	}
+	for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+		result[i], result[j] = result[j], result[i]
+	}
+	return result
+}
+
+// splitFunction splits "github.com/a/b.(*T).M" into package and function.
+func splitFunction(name string) (string, string) {
+	slash := strings.LastIndex(name, "/")
+	dot := strings.Index(name[slash+1:], ".")
+	if dot < 0 {
+		return "", name
+	}
+	return name[:slash+1+dot], name[slash+1+dot+1:]
+}
+
+// filtered replaces query values, which carry signatures, tokens and keys.
+const filtered = "[Filtered]"
+
+// requestInfo describes the request without credentials: the route stands
+// in for the path, paths of share links hold their token, and only the
+// names of query parameters are kept.
+func requestInfo(c *gin.Context) *sentry.Request {
+	r := c.Request
+	headers := make(map[string]string, len(r.Header))
+	for name := range r.Header {
+		if sensitiveHeaders[name] {
+			continue
+		}
+		headers[name] = r.Header.Get(name)
+	}
+	route := c.FullPath()
+	if route == "" {
+		route = "unmatched"
+	}
+	query := r.URL.Query()
+	for name, values := range query {
+		for i := range values {
+			values[i] = filtered
+		}
+		query[name] = values
+	}
+	return &sentry.Request{
+		Method:      r.Method,
+		URL:         route,
+		QueryString: query.Encode(),
+		Headers:     headers,
+	}
+}
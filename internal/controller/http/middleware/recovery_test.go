@@ -0,0 +1,73 @@
+package middleware_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/controller/http/middleware"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/sentry"
+)
+
+type fakeReporter struct {
+	events chan sentry.Event
+}
+
+func (r fakeReporter) Capture(_ context.Context, e sentry.Event) (string, error) {
+	r.events <- e
+	return "id", nil
+}
+
+func TestRecoveryRespondsWithProblem(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	reporter := fakeReporter{events: make(chan sentry.Event, 1)}
+	handler := gin.New()
+	handler.Use(middleware.Recovery(logger.New("error"), reporter))
+	handler.GET("/books/:bookID", func(c *gin.Context) {
+		panic("malformed epub")
+	})
+
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/books/1?page=2&sig=secret", nil)
+	req.Header.Set("Authorization", "Basic secret")
+	handler.ServeHTTP(w, req)
+
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected 500, got %d", w.Code)
+	}
+	if ct := w.Header().Get("Content-Type"); ct != "application/problem+json" {
+		t.Errorf("expected problem content type, got %q", ct)
+	}
+	var problem middleware.Problem
+	if err := json.Unmarshal(w.Body.Bytes(), &problem); err != nil {
+		t.Fatalf("unexpected body %q: %v", w.Body.String(), err)
+	}
+	if problem.Status != http.StatusInternalServerError || problem.Instance != "/books/1" {
+		t.Errorf("unexpected problem %+v", problem)
+	}
+
+	select {
+	case e := <-reporter.events:
+		if e.Message != "malformed epub" || e.Tags["route"] != "/books/:bookID" {
+			t.Errorf("unexpected event %+v", e)
+		}
+		if _, ok := e.Request.Headers["Authorization"]; ok {
+			t.Errorf("expected authorization header to be dropped")
+		}
+		if e.Request.URL != "/books/:bookID" || strings.Contains(e.Request.QueryString, "secret") || !strings.Contains(e.Request.QueryString, "sig=") {
+			t.Errorf("expected the route and filtered query values, got %q %q", e.Request.URL, e.Request.QueryString)
+		}
+		if len(e.Frames) == 0 {
+			t.Errorf("expected stack frames")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("expected panic to be reported")
+	}
+}
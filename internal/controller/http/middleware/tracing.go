@@ -0,0 +1,39 @@
+package middleware
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/pkg/tracing"
+)
+
+// Tracing starts a span for every request, named after the route it
+// matched, and continues the trace of its traceparent header. The use
+// cases, queries and storage operations of the request add spans below it.
+func Tracing(t *tracing.Tracer) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		route := c.FullPath()
+		if route == "" {
+			route = "unmatched"
+		}
+		ctx, span := t.StartRequest(c.Request.Context(), c.Request.Method+" "+route, c.GetHeader("traceparent"))
+		if span == nil {
+			c.Next()
+			return
+		}
+		defer span.End()
+		c.Request = c.Request.WithContext(ctx)
+		c.Next()
+
+		status := c.Writer.Status()
+		span.SetAttribute("http.request.method", c.Request.Method)
+		span.SetAttribute("http.route", route)
+		span.SetAttribute("url.path", c.Request.URL.Path)
+		span.SetAttribute("http.response.status_code", status)
+		if status >= http.StatusInternalServerError {
+			span.RecordError(errors.New(http.StatusText(status)))
+		}
+	}
+}
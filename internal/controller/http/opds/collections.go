@@ -0,0 +1,85 @@
+package opds
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/pkg/httpfile"
+	"github.com/banjuer/kompanion/pkg/thumbnail"
+)
+
+// sharedCollection is the feed of a shared collection, its books in
+// reading order. Its links stay below the token, the reader app of a
+// visitor has no account for the rest of the catalog.
+func (r *OPDSRouter) sharedCollection(c *gin.Context) {
+	token := c.Param("token")
+	collection, err := r.books.SharedCollection(c.Request.Context(), token)
+	if errors.Is(err, entity.ErrCollectionNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Collection not found", "code": 1003})
+		return
+	}
+	if err != nil {
+		r.logger.Error(err, "http - opds - sharedCollection")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error", "code": 1001})
+		return
+	}
+
+	base := "/opds/shared/" + token + "/"
+	books := make([]entity.Book, 0, len(collection.Entries))
+	for _, entry := range collection.Entries {
+		books = append(books, entry.Book)
+	}
+	feed := BuildFeed("urn:kompanion:collection:"+collection.ID, collection.Name, base, bookEntries(books, base+"book/", base+"covers/"), nil)
+	feed.Link = []Link{
+		{Href: base, Type: DirMime, Rel: "start"},
+		{Href: base, Type: DirMime, Rel: "self"},
+	}
+	c.XML(http.StatusOK, feed)
+}
+
+func (r *OPDSRouter) downloadSharedBook(c *gin.Context) {
+	bookID := c.Param("bookID")
+	book, file, err := r.books.DownloadSharedCollectionBook(c.Request.Context(), c.Param("token"), bookID, c.Query("format"))
+	switch {
+	case errors.Is(err, entity.ErrCollectionNotFound), errors.Is(err, entity.ErrBookNotFound):
+		c.JSON(http.StatusNotFound, gin.H{"message": "Book not found", "code": 1003})
+		return
+	case errors.Is(err, library.ErrDownloadRefused):
+		c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	case err != nil:
+		r.logger.Error(err, "http - opds - downloadSharedBook")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error", "code": 1001})
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Disposition", "attachment; filename="+book.Filename())
+	c.Header("Content-Type", "application/octet-stream")
+	httpfile.Serve(c.Writer, c.Request, book.ETag(), book.UpdatedAt, file)
+
+	if !httpfile.Resumed(c.Request) {
+		download := entity.Download{BookID: bookID, Client: entity.ClientOPDS}
+		if err := r.books.RecordDownload(c.Request.Context(), download); err != nil {
+			r.logger.Error(err, "http - opds - downloadSharedBook")
+		}
+	}
+}
+
+func (r *OPDSRouter) viewSharedCover(c *gin.Context) {
+	opts, err := thumbnail.ParseOptions(c.Query("w"), c.Query("h"), c.Query("fit"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error(), "code": 1004})
+		return
+	}
+	opts.Format = r.books.CoverFormat(c.GetHeader("Accept"))
+	file, err := r.books.ViewSharedCollectionCover(c.Request.Context(), c.Param("token"), c.Param("bookID"), opts)
+	if errors.Is(err, entity.ErrCollectionNotFound) {
+		err = entity.ErrBookNotFound
+	}
+	r.serveCover(c, file, err)
+}
@@ -0,0 +1,53 @@
+package opds
+
+import (
+	"context"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/language/display"
+
+	"github.com/banjuer/kompanion/internal/library"
+)
+
+// labels are the navigation titles of the catalog in the languages a
+// feed can be sent in, English for every other.
+var labels = map[string]map[string]string{
+	"en": {"newest": "By Newest", "random": "Surprise Me", "languages": "By Language", "library": "%s library"},
+	"de": {"newest": "Neueste", "random": "Überrasch mich", "languages": "Nach Sprache", "library": "%s Bibliothek"},
+	"fr": {"newest": "Nouveautés", "random": "Surprenez-moi", "languages": "Par langue", "library": "Bibliothèque %s"},
+	"es": {"newest": "Novedades", "random": "Sorpréndeme", "languages": "Por idioma", "library": "Biblioteca %s"},
+	"it": {"newest": "Novità", "random": "Sorprendimi", "languages": "Per lingua", "library": "Biblioteca %s"},
+	"pt": {"newest": "Novidades", "random": "Surpreenda-me", "languages": "Por idioma", "library": "Biblioteca %s"},
+	"nl": {"newest": "Nieuwste", "random": "Verras me", "languages": "Per taal", "library": "%s bibliotheek"},
+	"ru": {"newest": "Новые", "random": "Случайные", "languages": "По языку", "library": "Библиотека %s"},
+	"uk": {"newest": "Нові", "random": "Випадкові", "languages": "За мовою", "library": "Бібліотека %s"},
+	"zh": {"newest": "最新", "random": "随便看看", "languages": "按语言", "library": "%s 书库"},
+	"ja": {"newest": "新着", "random": "おまかせ", "languages": "言語別", "library": "%s ライブラリ"},
+}
+
+// label returns the navigation title key in the language of the request.
+func label(ctx context.Context, key string) string {
+	if text, ok := labels[library.LocaleFrom(ctx)][key]; ok {
+		return text
+	}
+	return labels["en"][key]
+}
+
+// languageName names the language code of the books in the language of
+// the request, the code itself when it has no name.
+func languageName(ctx context.Context, code string) string {
+	tag, err := language.Parse(code)
+	if err != nil {
+		return code
+	}
+	in := language.English
+	if locale := library.LocaleFrom(ctx); locale != "" {
+		if t, err := language.Parse(locale); err == nil {
+			in = t
+		}
+	}
+	if name := display.Tags(in).Name(tag); name != "" {
+		return name
+	}
+	return code
+}
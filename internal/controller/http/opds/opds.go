@@ -3,10 +3,12 @@ package opds
 import (
 	"encoding/xml"
 	"fmt"
+	"net/url"
 	"time"
 
 	"github.com/banjuer/kompanion/internal/entity"
 	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/pkg/richtext"
 )
 
 const (
@@ -15,8 +17,12 @@ const (
 	DirRel   = "subsection"
 	FileRel  = "http://opds-spec.org/acquisition"
 	CoverRel = "http://opds-spec.org/cover"
+	ThumbRel = "http://opds-spec.org/image/thumbnail"
 )
 
+// ThumbnailQuery is the size linked as OPDS thumbnail, clients may ask /covers for any other.
+const ThumbnailQuery = "?w=200&h=300&fit=cover"
+
 // Feed is a main frame of OPDS.
 type Feed struct {
 	XMLName xml.Name `xml:"feed"`
@@ -37,18 +43,25 @@ type Link struct {
 
 // Entry is a struct of OPDS entry properties.
 type Entry struct {
-	ID      string  `xml:"id"`
-	Updated string  `xml:"updated"`
-	Title   string  `xml:"title"`
-	Author  Author  `xml:"author,ommitempty"`
-	Summary Summary `xml:"summary,ommitempty"`
-	Link    []Link  `xml:"link"`
+	ID      string   `xml:"id"`
+	Updated string   `xml:"updated"`
+	Title   string   `xml:"title"`
+	Author  Author   `xml:"author,ommitempty"`
+	Summary Summary  `xml:"summary,ommitempty"`
+	Content *Content `xml:"content,omitempty"`
+	Link    []Link   `xml:"link"`
 }
 
 type Author struct {
 	Name string `xml:"name"`
 }
 
+// Content carries the full description as escaped HTML.
+type Content struct {
+	Type string `xml:"type,attr"`
+	Text string `xml:",chardata"`
+}
+
 type Summary struct {
 	Type string `xml:"type,attr"`
 	Text string `xml:",chardata"`
@@ -84,8 +97,28 @@ func BuildFeed(id, title, href string, entries []Entry, additionalLinks []Link)
 }
 
 func translateBooksToEntries(books []entity.Book) []Entry {
+	return bookEntries(books, "/opds/book/", "/covers/")
+}
+
+// bookEntries links the files of the books below booksPath and their covers
+// below coversPath.
+func bookEntries(books []entity.Book, booksPath, coversPath string) []Entry {
 	entries := make([]Entry, 0, len(books))
 	for _, book := range books {
+		links := []Link{
+			{
+				Href: booksPath + book.ID + "/download",
+				Type: book.MimeType(),
+				Rel:  FileRel,
+				// Mtime: book.UpdatedAt.Format(AtomTime),
+			},
+		}
+		if book.CoverPath != "" {
+			links = append(links,
+				Link{Href: coversPath + book.ID, Type: "image/jpeg", Rel: CoverRel},
+				Link{Href: coversPath + book.ID + ThumbnailQuery, Type: "image/jpeg", Rel: ThumbRel},
+			)
+		}
 		entries = append(entries, Entry{
 			ID:      book.ID,
 			Updated: book.UpdatedAt.Format(AtomTime),
@@ -95,21 +128,22 @@ func translateBooksToEntries(books []entity.Book) []Entry {
 			},
 			Summary: Summary{
 				Type: "text",
-				Text: truncateText(book.Description, 300),
-			},
-			Link: []Link{
-				{
-					Href: fmt.Sprintf("/opds/book/%s/download", book.ID),
-					Type: book.MimeType(),
-					Rel:  FileRel,
-					// Mtime: book.UpdatedAt.Format(AtomTime),
-				},
+				Text: truncateText(richtext.PlainText(book.Description), 300),
 			},
+			Content: descriptionContent(book.Description),
+			Link:    links,
 		})
 	}
 	return entries
 }
 
+func descriptionContent(description string) *Content {
+	if description == "" {
+		return nil
+	}
+	return &Content{Type: "html", Text: richtext.Sanitize(description)}
+}
+
 func truncateText(text string, maxLen int) string {
 	if len(text) <= maxLen {
 		return text
@@ -121,6 +155,9 @@ func truncateText(text string, maxLen int) string {
 }
 
 func formNavLinks(baseURL string, books library.PaginatedBookList) []Link {
+	if books.NextCursor != "" || books.PrevCursor != "" || books.TotalPages() == 0 {
+		return formCursorNavLinks(baseURL, books)
+	}
 	links := []Link{
 		{
 			Href: baseURL,
@@ -149,3 +186,29 @@ func formNavLinks(baseURL string, books library.PaginatedBookList) []Link {
 	}
 	return links
 }
+
+// formCursorNavLinks - the total is unknown in cursor mode, so there is no "last" link.
+func formCursorNavLinks(baseURL string, books library.PaginatedBookList) []Link {
+	links := []Link{
+		{
+			Href: baseURL,
+			Type: DirMime,
+			Rel:  "start",
+		},
+	}
+	if books.NextCursor != "" {
+		links = append(links, Link{
+			Href: baseURL + "?cursor=" + url.QueryEscape(books.NextCursor),
+			Type: DirMime,
+			Rel:  "next",
+		})
+	}
+	if books.PrevCursor != "" {
+		links = append(links, Link{
+			Href: baseURL + "?cursor=" + url.QueryEscape(books.PrevCursor),
+			Type: DirMime,
+			Rel:  "prev",
+		})
+	}
+	return links
+}
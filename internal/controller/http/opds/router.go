@@ -1,20 +1,30 @@
 package opds
 
 import (
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/url"
+	"os"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/entity"
 	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
 	"github.com/banjuer/kompanion/internal/sync"
+	"github.com/banjuer/kompanion/pkg/httpfile"
 	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/thumbnail"
 )
 
 type OPDSRouter struct {
-	books  library.Shelf
-	logger logger.Interface
+	books    library.Shelf
+	settings settings.Settings
+	logger   logger.Interface
 }
 
 func NewRouter(
@@ -22,17 +32,33 @@ func NewRouter(
 	l logger.Interface,
 	a auth.AuthInterface,
 	p sync.Progress,
-	shelf library.Shelf) {
-	sh := &OPDSRouter{shelf, l}
+	shelf library.Shelf,
+	st settings.Settings) {
+	sh := &OPDSRouter{shelf, st, l}
 
 	h := handler.Group("/opds")
 	h.Use(basicAuth(a))
 	{
 		h.GET("/", sh.listShelves)
 		h.GET("/newest/", sh.listNewest)
+		h.GET("/random/", sh.listRandom)
+		h.GET("/languages/", sh.listLanguages)
+		h.GET("/languages/:lang/", sh.listByLanguage)
 		h.GET("/book/:bookID/download", sh.downloadBook)
 		// TODO: search
 	}
+
+	covers := handler.Group("/covers")
+	covers.Use(basicAuth(a))
+	covers.GET("/:bookID", sh.viewCover)
+
+	// the token of a shared collection stands in for an account
+	shared := handler.Group("/opds/shared/:token")
+	{
+		shared.GET("/", sh.sharedCollection)
+		shared.GET("/book/:bookID/download", sh.downloadSharedBook)
+		shared.GET("/covers/:bookID", sh.viewSharedCover)
+	}
 }
 
 func (r *OPDSRouter) listShelves(c *gin.Context) {
@@ -40,7 +66,7 @@ func (r *OPDSRouter) listShelves(c *gin.Context) {
 		{
 			ID:      "urn:kompanion:newest",
 			Updated: time.Now().UTC().Format(AtomTime),
-			Title:   "By Newest",
+			Title:   label(c.Request.Context(), "newest"),
 			Link: []Link{
 				{
 					Href: "/opds/newest/",
@@ -48,45 +74,221 @@ func (r *OPDSRouter) listShelves(c *gin.Context) {
 				},
 			},
 		},
+		{
+			ID:      "urn:kompanion:random",
+			Updated: time.Now().UTC().Format(AtomTime),
+			Title:   label(c.Request.Context(), "random"),
+			Link: []Link{
+				{
+					Href: "/opds/random/",
+					Type: "application/atom+xml;type=feed;profile=opds-catalog",
+				},
+			},
+		},
+		{
+			ID:      "urn:kompanion:languages",
+			Updated: time.Now().UTC().Format(AtomTime),
+			Title:   label(c.Request.Context(), "languages"),
+			Link: []Link{
+				{
+					Href: "/opds/languages/",
+					Type: "application/atom+xml;type=feed;profile=opds-catalog",
+				},
+			},
+		},
 	}
 	links := []Link{}
-	feed := BuildFeed("urn:kompanion:main", "KOmpanion library", "/opds", shelves, links)
+	feed := BuildFeed("urn:kompanion:main", r.feedTitle(c), "/opds", shelves, links)
 	c.XML(http.StatusOK, feed)
 }
 
 func (r *OPDSRouter) listNewest(c *gin.Context) {
-	pageStr := c.Query("page")
-	page, err := strconv.Atoi(pageStr)
+	r.listBooks(c, "urn:kompanion:newest", "/opds/newest/", library.BookFilter{})
+}
+
+// randomFeedSize is the number of books of the surprise feed, each request
+// draws new ones.
+const randomFeedSize = 10
+
+func (r *OPDSRouter) listRandom(c *gin.Context) {
+	books, err := r.books.RandomBooks(c.Request.Context(), randomFeedSize, library.BookFilter{})
 	if err != nil {
-		page = 1
+		r.logger.Error(err, "http - opds - listRandom")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error", "code": 1001})
+		return
+	}
+	feed := BuildFeed("urn:kompanion:random", r.feedTitle(c), "/opds/random/", translateBooksToEntries(books), []Link{})
+	c.XML(http.StatusOK, feed)
+}
+
+func (r *OPDSRouter) listLanguages(c *gin.Context) {
+	languages, err := r.books.Languages(c.Request.Context())
+	if err != nil {
+		r.logger.Error("failed to list languages", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error", "code": 1001})
+		return
+	}
+	entries := make([]Entry, 0, len(languages))
+	for _, language := range languages {
+		entries = append(entries, Entry{
+			ID:      "urn:kompanion:languages:" + language,
+			Updated: time.Now().UTC().Format(AtomTime),
+			Title:   languageName(c.Request.Context(), language),
+			Link: []Link{
+				{
+					Href: "/opds/languages/" + url.PathEscape(language) + "/",
+					Type: "application/atom+xml;type=feed;profile=opds-catalog",
+				},
+			},
+		})
+	}
+	feed := BuildFeed("urn:kompanion:languages", r.feedTitle(c), "/opds/languages/", entries, []Link{})
+	c.XML(http.StatusOK, feed)
+}
+
+func (r *OPDSRouter) listByLanguage(c *gin.Context) {
+	filter := library.NewBookFilter(c.Param("lang"))
+	if filter.Language == "" {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Unknown language", "code": 1003})
+		return
+	}
+	r.listBooks(c, "urn:kompanion:languages:"+filter.Language, "/opds/languages/"+url.PathEscape(filter.Language)+"/", filter)
+}
+
+func (r *OPDSRouter) listBooks(c *gin.Context, id, baseUrl string, filter library.BookFilter) {
+	var books library.PaginatedBookList
+	var err error
+	// page links from older feeds keep working in offset mode
+	if pageStr := c.Query("page"); pageStr != "" {
+		page, perr := strconv.Atoi(pageStr)
+		if perr != nil {
+			page = 1
+		}
+		books, err = r.books.ListBooks(library.WithApproximateCount(c.Request.Context()), filter, "created_at", "desc", page, 10)
+	} else {
+		books, err = r.books.ListBooksByCursor(c.Request.Context(), filter, "created_at", "desc", c.Query("cursor"), 10)
+	}
+	if errors.Is(err, library.ErrInvalidCursor) {
+		c.JSON(http.StatusBadRequest, gin.H{"message": "Invalid cursor", "code": 1002})
+		return
 	}
-	books, err := r.books.ListBooks(c.Request.Context(), "created_at", "desc", page, 10)
 	if err != nil {
 		r.logger.Error("failed to list newest books", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error", "code": 1001})
 		return
 	}
-	baseUrl := "/opds/newest/"
 	entries := translateBooksToEntries(books.Books)
 	navLinks := formNavLinks(baseUrl, books)
-	feed := BuildFeed("urn:kompanion:newest", "KOmpanion library", baseUrl, entries, navLinks)
+	feed := BuildFeed(id, r.feedTitle(c), baseUrl, entries, navLinks)
 	c.XML(http.StatusOK, feed)
 }
 
 func (r *OPDSRouter) downloadBook(c *gin.Context) {
 	bookID := c.Param("bookID")
+	format := c.Query("format")
+
+	// answer revalidation before reading the file from storage
+	if book, err := r.books.BookFormat(c.Request.Context(), bookID, format); err == nil && httpfile.NotModified(c.Request, book.ETag(), book.UpdatedAt) {
+		httpfile.WriteNotModified(c.Writer, book.ETag(), book.UpdatedAt)
+		return
+	}
 
-	book, file, err := r.books.DownloadBook(c.Request.Context(), bookID)
+	link, err := r.books.BookFileURL(c.Request.Context(), bookID, format)
+	if errors.Is(err, library.ErrDownloadRefused) {
+		c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+		return
+	}
 	if err != nil {
 		r.logger.Error(err, "http - v1 - shelf - downloadBook")
 		c.JSON(500, gin.H{"message": "internal server error"})
 		return
 	}
+	if link != "" {
+		c.Redirect(http.StatusFound, link)
+	} else {
+		book, file, err := r.books.DownloadBook(c.Request.Context(), bookID, format)
+		if errors.Is(err, library.ErrDownloadRefused) {
+			c.JSON(http.StatusForbidden, gin.H{"message": err.Error()})
+			return
+		}
+		if err != nil {
+			r.logger.Error(err, "http - v1 - shelf - downloadBook")
+			c.JSON(500, gin.H{"message": "internal server error"})
+			return
+		}
+		defer file.Close()
+
+		c.Header("Content-Disposition", "attachment; filename="+book.Filename())
+		c.Header("Content-Type", "application/octet-stream")
+		httpfile.Serve(c.Writer, c.Request, book.ETag(), book.UpdatedAt, file)
+	}
+
+	if !httpfile.Resumed(c.Request) {
+		download := entity.Download{BookID: bookID, Username: c.GetString("username"), DeviceName: c.GetString("device_name"), Client: entity.ClientOPDS}
+		if err := r.books.RecordDownload(c.Request.Context(), download); err != nil {
+			r.logger.Error(err, "http - opds - downloadBook")
+		}
+	}
+}
+
+// viewCover serves the cover scaled to ?w=&h=&fit=contain|cover|fill,
+// in WebP or AVIF when the client accepts them, the original when no size
+// is given and the format stays, from the CDN when there is one.
+func (r *OPDSRouter) viewCover(c *gin.Context) {
+	opts, err := thumbnail.ParseOptions(c.Query("w"), c.Query("h"), c.Query("fit"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"message": err.Error(), "code": 1004})
+		return
+	}
+	opts.Format = r.books.CoverFormat(c.GetHeader("Accept"))
+	if opts.IsOriginal() {
+		if link, err := r.books.CoverURL(c.Request.Context(), c.Param("bookID")); err == nil && link != "" {
+			c.Redirect(http.StatusFound, link)
+			return
+		}
+	}
+
+	file, err := r.books.ViewCoverSized(c.Request.Context(), c.Param("bookID"), opts)
+	r.serveCover(c, file, err)
+}
+
+// serveCover answers a cover opened by ViewCoverSized.
+func (r *OPDSRouter) serveCover(c *gin.Context, file *os.File, err error) {
+	if errors.Is(err, entity.ErrBookNotFound) || errors.Is(err, library.ErrNoCover) {
+		c.JSON(http.StatusNotFound, gin.H{"message": "Cover not found", "code": 1003})
+		return
+	}
+	if err != nil {
+		r.logger.Error(err, "http - opds - serveCover")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error", "code": 1001})
+		return
+	}
 	defer file.Close()
 
-	c.Header("Content-Disposition", "attachment; filename="+book.Filename())
-	c.Header("Content-Type", "application/octet-stream")
-	c.File(file.Name())
+	modTime := time.Time{}
+	if info, err := file.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+	// ServeContent sniffs no AVIF
+	head := make([]byte, 512)
+	n, _ := file.Read(head)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		r.logger.Error(err, "http - opds - serveCover")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "Internal server error", "code": 1001})
+		return
+	}
+	c.Header("Content-Type", thumbnail.DetectContentType(head[:n]))
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Header("Vary", "Accept")
+	http.ServeContent(c.Writer, c.Request, "", modTime, file)
+}
+
+func (r *OPDSRouter) feedTitle(c *gin.Context) string {
+	branding, err := r.settings.Branding(c.Request.Context())
+	if err != nil {
+		r.logger.Error(err, "http - opds - feedTitle")
+	}
+	return fmt.Sprintf(label(c.Request.Context(), "library"), branding.InstanceName)
 }
 
 func basicAuth(auth auth.AuthInterface) gin.HandlerFunc {
@@ -98,13 +300,29 @@ func basicAuth(auth auth.AuthInterface) gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		if !auth.CheckDevicePassword(c.Request.Context(), username, password, true) {
-			if !auth.CheckPassword(c.Request.Context(), username, password) {
-				c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized", "code": 2001})
-				c.Abort()
-				return
+		// API keys of the user and device tokens stand in for passwords
+		key, err := auth.CheckAPIKey(c.Request.Context(), password)
+		keyValid := err == nil && key.SignsInAs(username)
+		// devices are shared, they see the books nobody keeps private
+		reader := username
+		if keyValid && key.Device == "" {
+			c.Set("username", username)
+		} else if keyValid || auth.CheckDevicePassword(c.Request.Context(), username, password, true) {
+			c.Set("device_name", username)
+			reader = ""
+			// readers rarely send Accept-Language, the device's language
+			// takes its place unless the feed URL asks for another
+			if language := auth.DeviceLanguage(c.Request.Context(), username); language != "" && c.Query("locale") == "" {
+				c.Request = c.Request.WithContext(library.WithLocale(c.Request.Context(), language))
 			}
+		} else if auth.CheckPassword(c.Request.Context(), username, password) {
+			c.Set("username", username)
+		} else {
+			c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized", "code": 2001})
+			c.Abort()
+			return
 		}
+		c.Request = c.Request.WithContext(library.WithReader(c.Request.Context(), reader))
 		c.Next()
 	}
 }
@@ -0,0 +1,489 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+type accountRoutes struct {
+	auth  auth.AuthInterface
+	shelf library.Shelf
+	l     logger.Interface
+}
+
+type storageUsageResponse struct {
+	Username    string `json:"username"`
+	Books       int    `json:"books"`
+	Bytes       int64  `json:"bytes"`
+	FileBytes   int64  `json:"file_bytes"`
+	FormatBytes int64  `json:"format_bytes"`
+	CoverBytes  int64  `json:"cover_bytes"`
+	// Quota and BytesLeft are left out without a quota
+	Quota     int64  `json:"quota,omitempty"`
+	BytesLeft *int64 `json:"bytes_left,omitempty"`
+}
+
+type deviceLanguageRequest struct {
+	// Language is a language tag such as "de", empty follows the reader.
+	Language string `json:"language"`
+}
+
+type deviceFormatsRequest struct {
+	// Formats the device reads well, empty uses the defaults.
+	Formats []string `json:"formats"`
+}
+
+type apiKeyRequest struct {
+	Name string `json:"name" binding:"required"`
+	// Scope is "read" or "write".
+	Scope string `json:"scope" binding:"required,oneof=read write"`
+	// Device makes the key a token of the device, it signs in to OPDS and
+	// WebDAV as the device.
+	Device string `json:"device"`
+}
+
+type apiKeyResponse struct {
+	ID         string     `json:"id"`
+	Name       string     `json:"name"`
+	Device     string     `json:"device,omitempty"`
+	Prefix     string     `json:"prefix"`
+	Scope      string     `json:"scope"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	// Key is only sent when the key is created.
+	Key string `json:"key,omitempty"`
+}
+
+func newAPIKeyResponse(key auth.APIKey) apiKeyResponse {
+	return apiKeyResponse{
+		ID:         key.ID,
+		Name:       key.Name,
+		Device:     key.Device,
+		Prefix:     key.Prefix,
+		Scope:      key.Scope,
+		CreatedAt:  key.CreatedAt,
+		LastUsedAt: key.LastUsedAt,
+	}
+}
+
+type userRequest struct {
+	Username string `json:"username" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+type userResponse struct {
+	Username string      `json:"username"`
+	Role     entity.Role `json:"role"`
+}
+
+type roleRequest struct {
+	// Role is "admin", "editor" or "reader".
+	Role string `json:"role" binding:"required"`
+}
+
+type mergeRequest struct {
+	// Kind is "device" for KOReader sync accounts or "user" for web accounts.
+	Kind string `json:"kind" binding:"required,oneof=device user"`
+	From string `json:"from" binding:"required"`
+	Into string `json:"into" binding:"required"`
+}
+
+func newAccountRoutes(handler *gin.RouterGroup, a auth.AuthInterface, shelf library.Shelf, l logger.Interface) {
+	r := &accountRoutes{a, shelf, l}
+
+	h := handler.Group("/accounts")
+	h.Use(authUserMiddleware(a, l))
+	{
+		h.GET("/usage", r.usage)
+		h.GET("/uploads", r.uploadPreferences)
+		h.PUT("/uploads", r.setUploadPreferences)
+		h.POST("/reading-history", r.importReadingHistory)
+		h.GET("/loans", r.listLoans)
+		h.POST("/loans", r.lendBook)
+		h.DELETE("/loans/:id", r.endLoan)
+		h.GET("/keys", r.listKeys)
+		h.POST("/keys", r.createKey)
+		h.DELETE("/keys/:id", r.revokeKey)
+		h.POST("/merge", r.merge)
+		h.DELETE("/devices/:name", r.deleteDevice)
+		h.PUT("/devices/:name/language", r.setDeviceLanguage)
+		h.PUT("/devices/:name/formats", r.setDeviceFormats)
+		h.GET("/users", r.listUsers)
+		h.POST("/users", r.registerUser)
+		h.PUT("/users/:username/role", r.setUserRole)
+		h.DELETE("/users/:username", r.deleteUser)
+	}
+}
+
+func (r *accountRoutes) merge(c *gin.Context) {
+	var req mergeRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if req.Kind == "user" && req.From == c.GetString("username") {
+		errorResponse(c, http.StatusBadRequest, "the account in use can not be merged away")
+		return
+	}
+
+	var result auth.MergeResult
+	var err error
+	if req.Kind == "device" {
+		result, err = r.auth.MergeDevices(c.Request.Context(), req.From, req.Into)
+	} else {
+		result, err = r.auth.MergeUsers(c.Request.Context(), req.From, req.Into)
+	}
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, auth.SameAccount):
+		errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, auth.DeviceNotFound), errors.Is(err, auth.UserNotFound):
+		errorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, auth.AccountDataNotConfigured):
+		errorResponse(c, http.StatusNotImplemented, err.Error())
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.JSON(http.StatusOK, result)
+	}
+}
+
+// setDeviceLanguage sets the language of the OPDS feeds of a device.
+func (r *accountRoutes) setDeviceLanguage(c *gin.Context) {
+	var req deviceLanguageRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	err := r.auth.SetDeviceLanguage(c.Request.Context(), c.Param("name"), req.Language)
+	switch {
+	case errors.Is(err, auth.InvalidLanguage):
+		errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, auth.DeviceNotFound):
+		errorResponse(c, http.StatusNotFound, err.Error())
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// setDeviceFormats sets the formats a device reads well, for the format
+// advisor.
+func (r *accountRoutes) setDeviceFormats(c *gin.Context) {
+	var req deviceFormatsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	err := r.auth.SetDeviceFormats(c.Request.Context(), c.Param("name"), req.Formats)
+	switch {
+	case errors.Is(err, auth.InvalidFormat):
+		errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, auth.DeviceNotFound):
+		errorResponse(c, http.StatusNotFound, err.Error())
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// deleteDevice removes the device with everything synced from it,
+// ?dry_run=true only reports what would be removed.
+func (r *accountRoutes) deleteDevice(c *gin.Context) {
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	report, err := r.auth.DeleteDeviceData(c.Request.Context(), c.Param("name"), dryRun)
+	r.respondDeletion(c, report, err)
+}
+
+func (r *accountRoutes) deleteUser(c *gin.Context) {
+	username := c.Param("username")
+	if username == c.GetString("username") {
+		errorResponse(c, http.StatusBadRequest, "the account in use can not be deleted")
+		return
+	}
+	dryRun, _ := strconv.ParseBool(c.Query("dry_run"))
+	report, err := r.auth.DeleteUserData(c.Request.Context(), username, dryRun)
+	r.respondDeletion(c, report, err)
+}
+
+func (r *accountRoutes) respondDeletion(c *gin.Context, report auth.DeletionReport, err error) {
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, auth.AccountDataNotConfigured):
+		errorResponse(c, http.StatusNotImplemented, err.Error())
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	case report.Empty():
+		errorResponse(c, http.StatusNotFound, "account not found")
+	default:
+		c.JSON(http.StatusOK, report)
+	}
+}
+
+// usage reports the storage the books uploaded by the signed in account
+// take.
+func (r *accountRoutes) usage(c *gin.Context) {
+	username := c.GetString("username")
+	usage, err := r.shelf.StorageUsage(c.Request.Context(), username)
+	if err != nil {
+		r.l.Error(err, "http - v1 - accounts - usage")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := storageUsageResponse{
+		Username:    username,
+		Books:       usage.Books,
+		Bytes:       usage.Bytes(),
+		FileBytes:   usage.FileBytes,
+		FormatBytes: usage.FormatBytes,
+		CoverBytes:  usage.CoverBytes,
+		Quota:       usage.Quota,
+	}
+	if left := usage.Left(); left >= 0 {
+		resp.BytesLeft = &left
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+type uploadPreferencesResponse struct {
+	// Private uploads are seen only by the signed in account until shared.
+	Private bool `json:"private"`
+}
+
+// uploadPreferences reports whether the uploads of the signed in account
+// are private by default.
+func (r *accountRoutes) uploadPreferences(c *gin.Context) {
+	private := r.auth.PrivateUploads(c.Request.Context(), c.GetString("username"))
+	c.JSON(http.StatusOK, uploadPreferencesResponse{Private: private})
+}
+
+func (r *accountRoutes) setUploadPreferences(c *gin.Context) {
+	var req privateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "private is required")
+		return
+	}
+	err := r.auth.SetPrivateUploads(c.Request.Context(), c.GetString("username"), *req.Private)
+	if err != nil {
+		r.l.Error(err, "http - v1 - accounts - setUploadPreferences")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, uploadPreferencesResponse{Private: *req.Private})
+}
+
+// importReadingHistory reads a Goodreads or StoryGraph CSV export in the
+// body into the reading status and reviews of the signed in account.
+func (r *accountRoutes) importReadingHistory(c *gin.Context) {
+	report, err := r.shelf.ImportReadingHistory(c.Request.Context(), c.GetString("username"), c.Request.Body)
+	switch {
+	case errors.Is(err, library.ErrInvalidHistoryImport):
+		errorResponse(c, http.StatusBadRequest, library.ErrInvalidHistoryImport.Error())
+		return
+	case err != nil:
+		r.l.Error(err, "http - v1 - accounts - importReadingHistory")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := metadataImportResponse{Rows: report.Books, Changed: report.Changed, Problems: make([]metadataProblemResponse, 0, len(report.Problems))}
+	for _, p := range report.Problems {
+		resp.Problems = append(resp.Problems, metadataProblemResponse{BookID: p.BookID, Title: p.Title, Problem: p.Problem})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+type loanRequest struct {
+	BookID   string `json:"book_id" binding:"required"`
+	Borrower string `json:"borrower" binding:"required"`
+	Days     int    `json:"days" binding:"required"`
+}
+
+// listLoans returns the books the signed in account lent or borrowed that
+// are still lent.
+func (r *accountRoutes) listLoans(c *gin.Context) {
+	loans, err := r.shelf.Loans(c.Request.Context(), c.GetString("username"))
+	if err != nil {
+		r.l.Error(err, "http - v1 - accounts - listLoans")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"loans": loans})
+}
+
+// lendBook lends a private book of the signed in account to another user
+// account for some days.
+func (r *accountRoutes) lendBook(c *gin.Context) {
+	var req loanRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if _, err := r.auth.UserRole(c.Request.Context(), req.Borrower); err != nil {
+		errorResponse(c, http.StatusNotFound, "account not found")
+		return
+	}
+
+	loan, err := r.shelf.LendBook(c.Request.Context(), c.GetString("username"), req.BookID, req.Borrower, req.Days)
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, entity.ErrInvalidLoan):
+		errorResponse(c, http.StatusBadRequest, entity.ErrInvalidLoan.Error())
+	case errors.Is(err, entity.ErrLoanShared):
+		errorResponse(c, http.StatusConflict, entity.ErrLoanShared.Error())
+	case errors.Is(err, entity.ErrBookNotFound):
+		errorResponse(c, http.StatusNotFound, "book not found")
+	case err != nil:
+		r.l.Error(err, "http - v1 - accounts - lendBook")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.JSON(http.StatusCreated, loan)
+	}
+}
+
+// endLoan hands a book back, the lender and the borrower may end a loan.
+func (r *accountRoutes) endLoan(c *gin.Context) {
+	err := r.shelf.EndLoan(c.Request.Context(), c.GetString("username"), c.Param("id"))
+	switch {
+	case errors.Is(err, entity.ErrLoanNotFound):
+		errorResponse(c, http.StatusNotFound, entity.ErrLoanNotFound.Error())
+	case err != nil:
+		r.l.Error(err, "http - v1 - accounts - endLoan")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (r *accountRoutes) listKeys(c *gin.Context) {
+	keys, err := r.auth.ListAPIKeys(c.Request.Context(), c.GetString("username"))
+	if errors.Is(err, auth.APIKeysNotConfigured) {
+		errorResponse(c, http.StatusNotImplemented, err.Error())
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - accounts - listKeys")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	resp := make([]apiKeyResponse, 0, len(keys))
+	for _, key := range keys {
+		resp = append(resp, newAPIKeyResponse(key))
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// createKey creates an API key of the signed in account, the key is in the
+// response only.
+func (r *accountRoutes) createKey(c *gin.Context) {
+	var req apiKeyRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	key, plain, err := r.auth.CreateAPIKey(c.Request.Context(), c.GetString("username"), req.Device, req.Name, req.Scope)
+	switch {
+	case errors.Is(err, auth.InvalidScope):
+		errorResponse(c, http.StatusBadRequest, err.Error())
+	case errors.Is(err, auth.DeviceNotFound):
+		errorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, auth.APIKeysNotConfigured):
+		errorResponse(c, http.StatusNotImplemented, err.Error())
+	case err != nil:
+		r.l.Error(err, "http - v1 - accounts - createKey")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		resp := newAPIKeyResponse(key)
+		resp.Key = plain
+		c.JSON(http.StatusCreated, resp)
+	}
+}
+
+func (r *accountRoutes) revokeKey(c *gin.Context) {
+	err := r.auth.RevokeAPIKey(c.Request.Context(), c.GetString("username"), c.Param("id"))
+	switch {
+	case errors.Is(err, auth.APIKeyNotFound):
+		errorResponse(c, http.StatusNotFound, err.Error())
+	case errors.Is(err, auth.APIKeysNotConfigured):
+		errorResponse(c, http.StatusNotImplemented, err.Error())
+	case err != nil:
+		r.l.Error(err, "http - v1 - accounts - revokeKey")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func (r *accountRoutes) listUsers(c *gin.Context) {
+	users, err := r.auth.ListUsers(c.Request.Context())
+	if forbidden(c, err) {
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - accounts - listUsers")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	resp := make([]userResponse, 0, len(users))
+	for _, user := range users {
+		resp = append(resp, userResponse{Username: user.Username, Role: user.Role})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// registerUser creates a reader account.
+func (r *accountRoutes) registerUser(c *gin.Context) {
+	var req userRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	err := r.auth.RegisterUser(c.Request.Context(), req.Username, req.Password)
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, auth.UserAlreadyCreated):
+		errorResponse(c, http.StatusConflict, err.Error())
+	case err != nil:
+		r.l.Error(err, "http - v1 - accounts - registerUser")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.JSON(http.StatusCreated, userResponse{Username: req.Username, Role: entity.RoleReader})
+	}
+}
+
+func (r *accountRoutes) setUserRole(c *gin.Context) {
+	var req roleRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	err := r.auth.SetUserRole(c.Request.Context(), c.Param("username"), req.Role)
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, entity.ErrInvalidRole):
+		errorResponse(c, http.StatusBadRequest, entity.ErrInvalidRole.Error())
+	case errors.Is(err, auth.UserNotFound):
+		errorResponse(c, http.StatusNotFound, err.Error())
+	case err != nil:
+		r.l.Error(err, "http - v1 - accounts - setUserRole")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.JSON(http.StatusOK, userResponse{Username: c.Param("username"), Role: entity.Role(req.Role)})
+	}
+}
@@ -0,0 +1,59 @@
+package v1
+
+import (
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/annotations"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+type annotationRoutes struct {
+	annotations annotations.Annotations
+	l           logger.Interface
+}
+
+type annotationsRequest struct {
+	Annotations []entity.Annotation `json:"annotations"`
+}
+
+func newAnnotationRoutes(handler *gin.RouterGroup, a annotations.Annotations, l logger.Interface) {
+	r := &annotationRoutes{a, l}
+
+	h := handler.Group("/")
+	{
+		h.PUT("/:document", r.importAnnotations)
+		h.GET("/:document", r.listAnnotations)
+	}
+}
+
+func (r *annotationRoutes) importAnnotations(c *gin.Context) {
+	var req annotationsRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		r.l.Error(err)
+		c.AsciiJSON(http.StatusBadRequest, gin.H{"message": "Bad request", "code": 4000})
+		return
+	}
+
+	imported, err := r.annotations.Import(c.Request.Context(), c.Param("document"), c.GetString("device_name"), req.Annotations)
+	if err != nil {
+		r.l.Error(err)
+		c.AsciiJSON(http.StatusInternalServerError, gin.H{"message": "Internal server error", "code": 5000})
+		return
+	}
+
+	c.AsciiJSON(http.StatusOK, gin.H{"imported": imported})
+}
+
+func (r *annotationRoutes) listAnnotations(c *gin.Context) {
+	list, err := r.annotations.List(c.Request.Context(), c.Param("document"))
+	if err != nil {
+		r.l.Error(err)
+		c.AsciiJSON(http.StatusInternalServerError, gin.H{"message": "Internal server error", "code": 5000})
+		return
+	}
+
+	c.AsciiJSON(http.StatusOK, annotationsRequest{Annotations: list})
+}
@@ -0,0 +1,110 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/backup"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+type backupRoutes struct {
+	backups backup.Backups
+	l       logger.Interface
+}
+
+type backupRunResponse struct {
+	ID           string     `json:"id"`
+	StartedAt    time.Time  `json:"started_at"`
+	FinishedAt   *time.Time `json:"finished_at,omitempty"`
+	Status       string     `json:"status"`
+	Error        string     `json:"error,omitempty"`
+	DatabasePath string     `json:"database_path"`
+	ManifestPath string     `json:"manifest_path"`
+	Size         int64      `json:"size"`
+	BookCount    int        `json:"book_count"`
+	Uploaded     bool       `json:"uploaded"`
+	Pruned       bool       `json:"pruned"`
+	VerifiedAt   *time.Time `json:"verified_at,omitempty"`
+	Restorable   bool       `json:"restorable"`
+	VerifyError  string     `json:"verify_error,omitempty"`
+}
+
+func newBackupRoutes(handler *gin.RouterGroup, b backup.Backups, a auth.AuthInterface, l logger.Interface) {
+	r := &backupRoutes{b, l}
+
+	h := handler.Group("/backups")
+	h.Use(authUserMiddleware(a, l))
+	{
+		h.GET("", r.listBackups)
+		h.POST("", r.startBackup)
+		h.POST("/verify", r.startVerify)
+	}
+}
+
+func (r *backupRoutes) listBackups(c *gin.Context) {
+	runs, err := r.backups.List(c.Request.Context(), 50)
+	if forbidden(c, err) {
+		return
+	}
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := make([]backupRunResponse, 0, len(runs))
+	for _, run := range runs {
+		item := backupRunResponse{
+			ID:           run.ID,
+			StartedAt:    run.StartedAt,
+			Status:       run.Status,
+			Error:        run.Error,
+			DatabasePath: run.DatabasePath,
+			ManifestPath: run.ManifestPath,
+			Size:         run.Size,
+			BookCount:    run.BookCount,
+			Uploaded:     run.Uploaded,
+			Pruned:       run.Pruned,
+			Restorable:   run.Restorable,
+			VerifyError:  run.VerifyError,
+		}
+		if !run.FinishedAt.IsZero() {
+			finishedAt := run.FinishedAt
+			item.FinishedAt = &finishedAt
+		}
+		if !run.VerifiedAt.IsZero() {
+			verifiedAt := run.VerifiedAt
+			item.VerifiedAt = &verifiedAt
+		}
+		resp = append(resp, item)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (r *backupRoutes) startBackup(c *gin.Context) {
+	r.respondStarted(c, r.backups.Start(c.Request.Context()))
+}
+
+func (r *backupRoutes) startVerify(c *gin.Context) {
+	r.respondStarted(c, r.backups.StartVerify(c.Request.Context()))
+}
+
+func (r *backupRoutes) respondStarted(c *gin.Context, err error) {
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, backup.ErrNotConfigured):
+		errorResponse(c, http.StatusNotImplemented, "backups are not configured")
+	case errors.Is(err, backup.ErrAlreadyRunning):
+		errorResponse(c, http.StatusConflict, "backup is already running")
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.Status(http.StatusAccepted)
+	}
+}
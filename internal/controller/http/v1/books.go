@@ -0,0 +1,1121 @@
+package v1
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/internal/sync"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/position"
+	"github.com/banjuer/kompanion/pkg/qr"
+	"github.com/banjuer/kompanion/pkg/richtext"
+	"github.com/banjuer/kompanion/pkg/signedurl"
+)
+
+type bookRoutes struct {
+	shelf    library.Shelf
+	progress sync.Progress
+	links    *signedurl.Signer
+	l        logger.Interface
+}
+
+type bookResponse struct {
+	ID              string     `json:"id"`
+	Title           string     `json:"title"`
+	Author          string     `json:"author"`
+	Publisher       string     `json:"publisher,omitempty"`
+	Year            int        `json:"year,omitempty"`
+	ISBN            string     `json:"isbn,omitempty"`
+	DOI             string     `json:"doi,omitempty"`
+	Series          string     `json:"series,omitempty"`
+	SeriesIndex     string     `json:"series_index,omitempty"`
+	Language        string     `json:"language,omitempty"`
+	Description     string     `json:"description,omitempty"`
+	DescriptionHTML string     `json:"description_html,omitempty"`
+	Format          string     `json:"format"`
+	FileSize        int64      `json:"file_size,omitempty"`
+	Pages           int        `json:"pages,omitempty"`
+	Rating          float64    `json:"rating,omitempty"`
+	RatingCount     int        `json:"rating_count,omitempty"`
+	DocumentID      string     `json:"document_id"`
+	CreatedAt       time.Time  `json:"created_at"`
+	UpdatedAt       time.Time  `json:"updated_at"`
+	ArchivedAt      *time.Time `json:"archived_at,omitempty"`
+	Private         bool       `json:"private,omitempty"`
+	VisibleFrom     *time.Time `json:"visible_from,omitempty"`
+	MediaType       string     `json:"media_type"`
+	Duration        int        `json:"duration,omitempty"`
+	Genres          []string   `json:"genres,omitempty"`
+	// other editions, in lists grouped by work
+	Editions []editionResponse `json:"editions,omitempty"`
+}
+
+type editionResponse struct {
+	ID        string                 `json:"id"`
+	Title     string                 `json:"title"`
+	Author    string                 `json:"author"`
+	Year      int                    `json:"year,omitempty"`
+	Language  string                 `json:"language,omitempty"`
+	Format    string                 `json:"format"`
+	MediaType string                 `json:"media_type"`
+	Relation  entity.EditionRelation `json:"relation"`
+}
+
+type editionRequest struct {
+	BookID   string `json:"book_id" binding:"required"`
+	Relation string `json:"relation"`
+}
+
+// batchUpdateRequest changes the books with ids, empty fields are left alone.
+type batchUpdateRequest struct {
+	IDs          []string `json:"ids" binding:"required"`
+	Publisher    string   `json:"publisher"`
+	Series       string   `json:"series"`
+	Language     string   `json:"language"`
+	Year         int      `json:"year"`
+	RenameAuthor struct {
+		From string `json:"from"`
+		To   string `json:"to"`
+	} `json:"rename_author"`
+	AddGenres    []string `json:"add_genres"`
+	RemoveGenres []string `json:"remove_genres"`
+}
+
+type metadataProblemResponse struct {
+	BookID  string `json:"book_id,omitempty"`
+	Title   string `json:"title,omitempty"`
+	Problem string `json:"problem"`
+}
+
+type metadataImportResponse struct {
+	Rows     int                       `json:"rows"`
+	Changed  int                       `json:"changed"`
+	Problems []metadataProblemResponse `json:"problems"`
+}
+
+type chapterResponse struct {
+	Title string  `json:"title"`
+	Start float64 `json:"start"`
+}
+
+type bookFileResponse struct {
+	Format     string    `json:"format"`
+	FileSize   int64     `json:"file_size,omitempty"`
+	DocumentID string    `json:"document_id"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+type readingStatusResponse struct {
+	Status     entity.ReadingStatus `json:"status"`
+	FinishedAt *time.Time           `json:"finished_at,omitempty"`
+	UpdatedAt  *time.Time           `json:"updated_at,omitempty"`
+}
+
+type readingStatusRequest struct {
+	Status entity.ReadingStatus `json:"status" binding:"required"`
+}
+
+type reviewRequest struct {
+	Rating int    `json:"rating"`
+	Review string `json:"review"`
+}
+
+type bookListResponse struct {
+	Books      []interface{} `json:"books"`
+	Page       int           `json:"page"`
+	PerPage    int           `json:"per_page"`
+	TotalPages int           `json:"total_pages"`
+	// total_pages is estimated or cached, ?exact=true counts
+	Approximate bool `json:"approximate,omitempty"`
+	// with ?facets=true
+	Facets *facetsResponse `json:"facets,omitempty"`
+}
+
+type facetResponse struct {
+	Value string `json:"value"`
+	Count int    `json:"count"`
+}
+
+type facetsResponse struct {
+	Authors []facetResponse `json:"authors"`
+	Formats []facetResponse `json:"formats"`
+	Genres  []facetResponse `json:"genres"`
+	Decades []facetResponse `json:"decades"`
+}
+
+func newFacetsResponse(facets *library.BookFacets) *facetsResponse {
+	if facets == nil {
+		return nil
+	}
+	return &facetsResponse{
+		Authors: facetCounts(facets.Authors),
+		Formats: facetCounts(facets.Formats),
+		Genres:  facetCounts(facets.Genres),
+		Decades: facetCounts(facets.Decades),
+	}
+}
+
+func facetCounts(values []library.FacetCount) []facetResponse {
+	resp := make([]facetResponse, 0, len(values))
+	for _, v := range values {
+		resp = append(resp, facetResponse{Value: v.Value, Count: v.Count})
+	}
+	return resp
+}
+
+func newBookRoutes(handler *gin.RouterGroup, shelf library.Shelf, p sync.Progress, links *signedurl.Signer, a auth.AuthInterface, l logger.Interface) {
+	r := &bookRoutes{shelf, p, links, l}
+
+	h := handler.Group("/books")
+	h.Use(authUserMiddleware(a, l))
+	{
+		h.GET("", r.listBooks)
+		h.PATCH("", r.batchUpdateBooks)
+		h.GET("/export", r.exportBooks)
+		h.GET("/metadata", r.exportMetadata)
+		h.POST("/metadata", r.importMetadata)
+		h.GET("/citations", r.citeBooks)
+		h.GET("/random", r.randomBooks)
+		h.GET("/:bookID", r.viewBook)
+		h.GET("/:bookID/jsonld", r.viewBookJSONLD)
+		h.GET("/:bookID/citation", r.citeBook)
+		h.POST("/:bookID/link", r.createDownloadLink)
+		h.GET("/:bookID/link/qr", r.downloadLinkQR)
+		h.GET("/:bookID/position", r.readingPosition)
+		h.GET("/:bookID/share-links", r.listShareLinks)
+		h.POST("/:bookID/share-links", r.createShareLink)
+		h.DELETE("/:bookID/share-links/:linkID", r.revokeShareLink)
+		h.PUT("/:bookID/archive", r.archiveBook)
+		h.PUT("/:bookID/private", r.setBookPrivate)
+		h.PUT("/:bookID/visible-from", r.setBookVisibleFrom)
+		h.GET("/:bookID/chapters", r.listChapters)
+		h.GET("/:bookID/files", r.listFiles)
+		h.DELETE("/:bookID/files/:format", r.deleteFile)
+		h.GET("/:bookID/same-cover", r.listSameCover)
+		h.GET("/:bookID/similar", r.listSimilar)
+		h.GET("/:bookID/editions", r.listEditions)
+		h.PUT("/:bookID/edition", r.linkEdition)
+		h.DELETE("/:bookID/edition", r.unlinkEdition)
+		h.GET("/:bookID/status", r.readingStatus)
+		h.PUT("/:bookID/status", r.setReadingStatus)
+		h.DELETE("/:bookID/status", r.clearReadingStatus)
+		h.GET("/:bookID/reviews", r.listReviews)
+		h.PUT("/:bookID/review", r.saveReview)
+		h.DELETE("/:bookID/review", r.deleteReview)
+	}
+
+	d := handler.Group("/downloads")
+	d.Use(authUserMiddleware(a, l))
+	d.GET("", r.listDownloads)
+}
+
+func (r *bookRoutes) listBooks(c *gin.Context) {
+	fields, err := parseFields(c, bookResponse{})
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	page, _ := strconv.Atoi(c.DefaultQuery("page", "1"))
+	perPage, _ := strconv.Atoi(c.DefaultQuery("perPage", "25"))
+	if page <= 0 {
+		page = 1
+	}
+	filter := library.NewBookFilter(c.Query("lang")).WithStatus(c.GetString("username"), c.Query("status")).WithMediaType(c.Query("media"))
+	filter.GroupEditions = c.Query("group") == "editions"
+	filter.Facets, _ = strconv.ParseBool(c.Query("facets"))
+	sortBy := c.DefaultQuery("sort", "created_at")
+	sortOrder := c.DefaultQuery("order", "desc")
+
+	ctx := c.Request.Context()
+	if exact, _ := strconv.ParseBool(c.Query("exact")); !exact {
+		ctx = library.WithApproximateCount(ctx)
+	}
+
+	var books library.PaginatedBookList
+	if query := c.Query("q"); query != "" {
+		books, err = r.shelf.SearchBooks(ctx, query, filter, sortBy, sortOrder, page, perPage)
+	} else {
+		books, err = r.shelf.ListBooks(ctx, filter, sortBy, sortOrder, page, perPage)
+	}
+	if errors.Is(err, library.ErrInvalidQuery) {
+		errorResponse(c, http.StatusBadRequest, errors.Unwrap(err).Error())
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - listBooks")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := bookListResponse{
+		Books:       make([]interface{}, 0, len(books.Books)),
+		Page:        page,
+		PerPage:     books.PerPage(),
+		TotalPages:  books.TotalPages(),
+		Approximate: books.ApproximateTotal,
+		Facets:      newFacetsResponse(books.Facets),
+	}
+	for _, book := range books.Books {
+		entry := newBookResponse(book)
+		entry.Editions = newEditionResponses(books.Editions[book.ID])
+		item, err := fields.apply(entry)
+		if err != nil {
+			r.l.Error(err, "http - v1 - books - listBooks")
+			errorResponse(c, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		resp.Books = append(resp.Books, item)
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// randomBooks draws ?n= books (default 10, at most 50) at random, with
+// the filters of listBooks.
+func (r *bookRoutes) randomBooks(c *gin.Context) {
+	fields, err := parseFields(c, bookResponse{})
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	n, _ := strconv.Atoi(c.DefaultQuery("n", "10"))
+	filter := library.NewBookFilter(c.Query("lang")).WithStatus(c.GetString("username"), c.Query("status")).WithMediaType(c.Query("media"))
+	books, err := r.shelf.RandomBooks(c.Request.Context(), n, filter)
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - randomBooks")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := make([]interface{}, 0, len(books))
+	for _, book := range books {
+		item, err := fields.apply(newBookResponse(book))
+		if err != nil {
+			r.l.Error(err, "http - v1 - books - randomBooks")
+			errorResponse(c, http.StatusInternalServerError, "internal server error")
+			return
+		}
+		resp = append(resp, item)
+	}
+	c.JSON(http.StatusOK, gin.H{"books": resp})
+}
+
+func (r *bookRoutes) viewBook(c *gin.Context) {
+	fields, err := parseFields(c, bookResponse{})
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	book, err := r.shelf.ViewBook(c.Request.Context(), c.Param("bookID"))
+	if errors.Is(err, entity.ErrBookNotFound) {
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - viewBook")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	resp, err := fields.apply(newBookResponse(book))
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - viewBook")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// viewBookJSONLD describes the book as a schema.org Book (or Audiobook)
+// linking its web page.
+func (r *bookRoutes) viewBookJSONLD(c *gin.Context) {
+	book, err := r.shelf.ViewBook(c.Request.Context(), c.Param("bookID"))
+	if errors.Is(err, entity.ErrBookNotFound) {
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - viewBookJSONLD")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// served by the web router
+	cover := ""
+	if book.CoverPath != "" {
+		cover = absoluteURL(c.Request, "/books/"+book.ID+"/cover")
+	}
+	data, err := json.Marshal(library.NewSchemaBook(book, absoluteURL(c.Request, "/books/"+book.ID), cover))
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - viewBookJSONLD")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.Data(http.StatusOK, "application/ld+json", data)
+}
+
+func (r *bookRoutes) readingStatus(c *gin.Context) {
+	status, err := r.shelf.ReadingStatus(c.Request.Context(), c.GetString("username"), c.Param("bookID"))
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - readingStatus")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, newReadingStatusResponse(status))
+}
+
+func (r *bookRoutes) setReadingStatus(c *gin.Context) {
+	var req readingStatusRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	status, err := r.shelf.SetReadingStatus(c.Request.Context(), c.GetString("username"), c.Param("bookID"), req.Status)
+	switch {
+	case errors.Is(err, entity.ErrInvalidReadingStatus):
+		errorResponse(c, http.StatusBadRequest, "status must be to_read, reading or finished")
+		return
+	case errors.Is(err, entity.ErrBookNotFound):
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	case err != nil:
+		r.l.Error(err, "http - v1 - books - setReadingStatus")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, newReadingStatusResponse(status))
+}
+
+func (r *bookRoutes) clearReadingStatus(c *gin.Context) {
+	err := r.shelf.ClearReadingStatus(c.Request.Context(), c.GetString("username"), c.Param("bookID"))
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - clearReadingStatus")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// archiveBook keeps the book exactly as stored. There is no way back over
+// the API, `kompanion book unarchive` lifts the flag.
+func (r *bookRoutes) archiveBook(c *gin.Context) {
+	book, err := r.shelf.ArchiveBook(c.Request.Context(), c.Param("bookID"))
+	if forbidden(c, err) {
+		return
+	}
+	if errors.Is(err, entity.ErrBookNotFound) {
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - archiveBook")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, newBookResponse(book))
+}
+
+type privateRequest struct {
+	Private *bool `json:"private" binding:"required"`
+}
+
+// setBookPrivate hides the book from every account but its uploader, or
+// shares it again with {"private": false}.
+func (r *bookRoutes) setBookPrivate(c *gin.Context) {
+	var req privateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "private is required")
+		return
+	}
+	book, err := r.shelf.SetBookPrivate(c.Request.Context(), c.Param("bookID"), *req.Private)
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, entity.ErrBookNotFound):
+		errorResponse(c, http.StatusNotFound, "book not found")
+	case errors.Is(err, library.ErrNoUploader):
+		errorResponse(c, http.StatusConflict, "book has no uploader to keep it private")
+	case err != nil:
+		r.l.Error(err, "http - v1 - books - setBookPrivate")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.JSON(http.StatusOK, newBookResponse(book))
+	}
+}
+
+type visibleFromRequest struct {
+	VisibleFrom *time.Time `json:"visible_from"`
+}
+
+// setBookVisibleFrom hides the book from all but admins until
+// {"visible_from": "2026-11-01T18:00:00Z"}, null shows it right away.
+func (r *bookRoutes) setBookVisibleFrom(c *gin.Context) {
+	var req visibleFromRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "visible_from must be a RFC 3339 time or null")
+		return
+	}
+	var from time.Time
+	if req.VisibleFrom != nil {
+		from = *req.VisibleFrom
+	}
+	book, err := r.shelf.SetBookVisibleFrom(c.Request.Context(), c.Param("bookID"), from)
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, entity.ErrBookNotFound):
+		errorResponse(c, http.StatusNotFound, "book not found")
+	case err != nil:
+		r.l.Error(err, "http - v1 - books - setBookVisibleFrom")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.JSON(http.StatusOK, newBookResponse(book))
+	}
+}
+
+// listFiles returns the formats of a book, the file it was uploaded as
+// first. Downloads take the format as ?format=.
+func (r *bookRoutes) listFiles(c *gin.Context) {
+	files, err := r.shelf.BookFiles(c.Request.Context(), c.Param("bookID"))
+	if errors.Is(err, entity.ErrBookNotFound) {
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - listFiles")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := make([]bookFileResponse, 0, len(files))
+	for _, file := range files {
+		resp = append(resp, bookFileResponse{Format: file.Format, FileSize: file.FileSize, DocumentID: file.DocumentID, CreatedAt: file.CreatedAt})
+	}
+	c.JSON(http.StatusOK, gin.H{"files": resp})
+}
+
+func (r *bookRoutes) deleteFile(c *gin.Context) {
+	err := r.shelf.DeleteBookFile(c.Request.Context(), c.Param("bookID"), c.Param("format"))
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, entity.ErrBookNotFound):
+		errorResponse(c, http.StatusNotFound, "file not found")
+	case errors.Is(err, library.ErrPrimaryFile):
+		errorResponse(c, http.StatusConflict, "the file the book was uploaded as can not be removed")
+	case errors.Is(err, entity.ErrBookArchived):
+		errorResponse(c, http.StatusConflict, "book is archived")
+	case err != nil:
+		r.l.Error(err, "http - v1 - books - deleteFile")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+// listChapters returns the chapters of an audiobook with their start in
+// seconds, books have none.
+func (r *bookRoutes) listChapters(c *gin.Context) {
+	chapters, err := r.shelf.Chapters(c.Request.Context(), c.Param("bookID"))
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - listChapters")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := make([]chapterResponse, 0, len(chapters))
+	for _, chapter := range chapters {
+		resp = append(resp, chapterResponse{Title: chapter.Title, Start: chapter.Start.Seconds()})
+	}
+	c.JSON(http.StatusOK, gin.H{"chapters": resp})
+}
+
+// listSimilar returns up to n (default 10) books sharing the series,
+// author, genres or a similar title with a book, the most alike first.
+func (r *bookRoutes) listSimilar(c *gin.Context) {
+	n, _ := strconv.Atoi(c.DefaultQuery("n", "10"))
+	books, err := r.shelf.SimilarBooks(c.Request.Context(), c.Param("bookID"), n)
+	if errors.Is(err, entity.ErrBookNotFound) {
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - listSimilar")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := make([]bookResponse, 0, len(books))
+	for _, book := range books {
+		resp = append(resp, newBookResponse(book))
+	}
+	c.JSON(http.StatusOK, gin.H{"books": resp})
+}
+
+// listSameCover returns other books sharing the cover of a book, a hint at
+// editions of the same work or duplicates.
+func (r *bookRoutes) listSameCover(c *gin.Context) {
+	books, err := r.shelf.SameCoverBooks(c.Request.Context(), c.Param("bookID"))
+	if errors.Is(err, entity.ErrBookNotFound) {
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - listSameCover")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := make([]bookResponse, 0, len(books))
+	for _, book := range books {
+		resp = append(resp, newBookResponse(book))
+	}
+	c.JSON(http.StatusOK, gin.H{"books": resp})
+}
+
+// listEditions returns the other editions, translations and formats of
+// the work of a book.
+func (r *bookRoutes) listEditions(c *gin.Context) {
+	editions, err := r.shelf.Editions(c.Request.Context(), c.Param("bookID"))
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - listEditions")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	resp := newEditionResponses(editions)
+	if resp == nil {
+		resp = []editionResponse{}
+	}
+	c.JSON(http.StatusOK, gin.H{"editions": resp})
+}
+
+// linkEdition adds the book to the work of book_id as an edition,
+// translation or format.
+func (r *bookRoutes) linkEdition(c *gin.Context) {
+	var req editionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	err := r.shelf.LinkEdition(c.Request.Context(), c.Param("bookID"), req.BookID, req.Relation)
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, entity.ErrInvalidEditionRelation):
+		errorResponse(c, http.StatusBadRequest, "relation must be edition, translation or format")
+		return
+	case errors.Is(err, entity.ErrSameEdition):
+		errorResponse(c, http.StatusBadRequest, "a book can not be linked to itself")
+		return
+	case errors.Is(err, entity.ErrBookNotFound):
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	case err != nil:
+		r.l.Error(err, "http - v1 - books - linkEdition")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// batchUpdateBooks applies one metadata change to many books, all of them
+// or, when one is missing or archived, none.
+func (r *bookRoutes) batchUpdateBooks(c *gin.Context) {
+	var req batchUpdateRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	patch := library.MetadataPatch{
+		Publisher:    req.Publisher,
+		Series:       req.Series,
+		Language:     req.Language,
+		Year:         req.Year,
+		AddGenres:    req.AddGenres,
+		RemoveGenres: req.RemoveGenres,
+	}
+	patch.RenameAuthor.From, patch.RenameAuthor.To = req.RenameAuthor.From, req.RenameAuthor.To
+
+	books, err := r.shelf.BatchUpdateMetadata(c.Request.Context(), req.IDs, patch)
+	switch {
+	case forbidden(c, err):
+		return
+	case errors.Is(err, library.ErrInvalidPatch):
+		errorResponse(c, http.StatusBadRequest, library.ErrInvalidPatch.Error())
+		return
+	case errors.Is(err, entity.ErrBookNotFound):
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	case errors.Is(err, entity.ErrBookArchived):
+		errorResponse(c, http.StatusConflict, "book is archived")
+		return
+	case err != nil:
+		r.l.Error(err, "http - v1 - books - batchUpdateBooks")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := make([]bookResponse, 0, len(books))
+	for _, book := range books {
+		resp = append(resp, newBookResponse(book))
+	}
+	c.JSON(http.StatusOK, gin.H{"books": resp})
+}
+
+func (r *bookRoutes) unlinkEdition(c *gin.Context) {
+	if err := r.shelf.UnlinkEdition(c.Request.Context(), c.Param("bookID")); err != nil {
+		if forbidden(c, err) {
+			return
+		}
+		r.l.Error(err, "http - v1 - books - unlinkEdition")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func newEditionResponses(editions []entity.Edition) []editionResponse {
+	if len(editions) == 0 {
+		return nil
+	}
+	resp := make([]editionResponse, 0, len(editions))
+	for _, edition := range editions {
+		resp = append(resp, editionResponse{
+			ID:        edition.Book.ID,
+			Title:     edition.Book.Title,
+			Author:    edition.Book.Author,
+			Year:      edition.Book.Year,
+			Language:  edition.Book.Language,
+			Format:    edition.Book.Extension(),
+			MediaType: edition.Book.MediaType,
+			Relation:  edition.Relation,
+		})
+	}
+	return resp
+}
+
+type downloadLinkResponse struct {
+	URL       string    `json:"url"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// createDownloadLink hands out a link to the book file that works without
+// credentials until it expires, e.g. for wget on a device.
+func (r *bookRoutes) createDownloadLink(c *gin.Context) {
+	book, err := r.shelf.ViewBook(c.Request.Context(), c.Param("bookID"))
+	if errors.Is(err, entity.ErrBookNotFound) {
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - createDownloadLink")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// served by the web router
+	link, expires := r.links.Sign("/dl/" + book.ID)
+	c.JSON(http.StatusCreated, downloadLinkResponse{URL: absoluteURL(c.Request, link), ExpiresAt: expires})
+}
+
+// downloadLinkQR draws a new link of createDownloadLink as a QR code PNG
+// of size pixels, for a device with a camera. Expires tells when the link
+// stops working.
+func (r *bookRoutes) downloadLinkQR(c *gin.Context) {
+	size, err := strconv.Atoi(c.DefaultQuery("size", strconv.Itoa(qr.DefaultSize)))
+	if err != nil || size < qr.MinSize || size > qr.MaxSize {
+		errorResponse(c, http.StatusBadRequest, "size must be between "+strconv.Itoa(qr.MinSize)+" and "+strconv.Itoa(qr.MaxSize))
+		return
+	}
+	book, err := r.shelf.ViewBook(c.Request.Context(), c.Param("bookID"))
+	if errors.Is(err, entity.ErrBookNotFound) {
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - downloadLinkQR")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	// served by the web router
+	link, expires := r.links.Sign("/dl/" + book.ID)
+	png, err := qr.PNG(absoluteURL(c.Request, link), size)
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - downloadLinkQR")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.Header("Cache-Control", "no-store")
+	c.Header("Expires", expires.UTC().Format(http.TimeFormat))
+	c.Data(http.StatusOK, "image/png", png)
+}
+
+type positionResponse struct {
+	position.Position
+	// URL is the position URL, DeepLink opens the book file there.
+	URL      string    `json:"url"`
+	DeepLink string    `json:"deep_link"`
+	Expires  time.Time `json:"deep_link_expires_at"`
+}
+
+// readingPosition returns where KOReader last synced the book, 404 when
+// its file never synced progress.
+func (r *bookRoutes) readingPosition(c *gin.Context) {
+	book, err := r.shelf.ViewBook(c.Request.Context(), c.Param("bookID"))
+	if errors.Is(err, entity.ErrBookNotFound) {
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - readingPosition")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	progress, err := r.progress.Fetch(c.Request.Context(), book.DocumentID)
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - readingPosition")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	if progress.Document == "" {
+		errorResponse(c, http.StatusNotFound, "no synced position")
+		return
+	}
+
+	p := position.FromProgress(book.ID, book.DocumentID, progress.Progress, progress.Percentage, progress.Device, progress.Timestamp)
+	// served by the web router
+	link, expires := r.links.Sign("/dl/" + book.ID)
+	c.JSON(http.StatusOK, positionResponse{
+		Position: p,
+		URL:      absoluteURL(c.Request, p.Path()),
+		DeepLink: absoluteURL(c.Request, p.DeepLink(link, book.Extension())),
+		Expires:  expires,
+	})
+}
+
+type shareLinkRequest struct {
+	// TTL is how long the link works, like "72h", 30 days at most.
+	TTL string `json:"ttl" binding:"required"`
+	// MaxDownloads limits the downloads with the link, 0 does not.
+	MaxDownloads int `json:"max_downloads"`
+}
+
+type shareLinkResponse struct {
+	entity.ShareLink
+	// URL is only sent when the link is created.
+	URL string `json:"url,omitempty"`
+}
+
+func (r *bookRoutes) listShareLinks(c *gin.Context) {
+	links, err := r.shelf.ShareLinks(c.Request.Context(), c.GetString("username"), c.Param("bookID"))
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - listShareLinks")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	resp := make([]shareLinkResponse, 0, len(links))
+	for _, link := range links {
+		resp = append(resp, shareLinkResponse{ShareLink: link})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// createShareLink creates a link anyone may download the book with, without
+// an account, until it expires or is used up.
+func (r *bookRoutes) createShareLink(c *gin.Context) {
+	if features, ok := c.Value("features").(settings.Features); ok && !features.Enabled(settings.FeatureSharing) {
+		errorResponse(c, http.StatusNotFound, settings.FeatureSharing+" is disabled on this server")
+		return
+	}
+	var req shareLinkRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	ttl, err := time.ParseDuration(req.TTL)
+	if err != nil {
+		errorResponse(c, http.StatusBadRequest, "ttl is not a duration")
+		return
+	}
+
+	link, token, err := r.shelf.CreateShareLink(c.Request.Context(), c.GetString("username"), c.Param("bookID"), ttl, req.MaxDownloads)
+	switch {
+	case errors.Is(err, entity.ErrInvalidShareLink):
+		errorResponse(c, http.StatusBadRequest, entity.ErrInvalidShareLink.Error())
+	case errors.Is(err, entity.ErrBookNotFound):
+		errorResponse(c, http.StatusNotFound, "book not found")
+	case err != nil:
+		r.l.Error(err, "http - v1 - books - createShareLink")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		// served by the web router
+		c.JSON(http.StatusCreated, shareLinkResponse{ShareLink: link, URL: absoluteURL(c.Request, "/s/"+token)})
+	}
+}
+
+func (r *bookRoutes) revokeShareLink(c *gin.Context) {
+	err := r.shelf.RevokeShareLink(c.Request.Context(), c.GetString("username"), c.Param("linkID"))
+	switch {
+	case errors.Is(err, entity.ErrShareLinkNotFound):
+		errorResponse(c, http.StatusNotFound, entity.ErrShareLinkNotFound.Error())
+	case err != nil:
+		r.l.Error(err, "http - v1 - books - revokeShareLink")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.Status(http.StatusNoContent)
+	}
+}
+
+func absoluteURL(req *http.Request, path string) string {
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + path
+}
+
+type downloadResponse struct {
+	Book             bookResponse `json:"book"`
+	Downloads        int          `json:"downloads"`
+	LastDownloadedAt time.Time    `json:"last_downloaded_at"`
+	Opened           bool         `json:"opened"`
+}
+
+// listDownloads is the download history of the account and its devices,
+// ?unopened=true keeps books that were never opened in KOReader.
+func (r *bookRoutes) listDownloads(c *gin.Context) {
+	unopened, _ := strconv.ParseBool(c.Query("unopened"))
+	downloads, err := r.shelf.Downloads(c.Request.Context(), c.GetString("username"), unopened)
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - listDownloads")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := make([]downloadResponse, 0, len(downloads))
+	for _, d := range downloads {
+		resp = append(resp, downloadResponse{
+			Book:             newBookResponse(d.Book),
+			Downloads:        d.Downloads,
+			LastDownloadedAt: d.LastDownloadedAt,
+			Opened:           d.Opened,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"downloads": resp})
+}
+
+func (r *bookRoutes) listReviews(c *gin.Context) {
+	reviews, err := r.shelf.Reviews(c.Request.Context(), c.Param("bookID"))
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - listReviews")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"reviews": reviews})
+}
+
+func (r *bookRoutes) saveReview(c *gin.Context) {
+	var req reviewRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	review, err := r.shelf.SaveReview(c.Request.Context(), c.GetString("username"), c.Param("bookID"), req.Rating, req.Review)
+	switch {
+	case errors.Is(err, entity.ErrInvalidRating):
+		errorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	case errors.Is(err, entity.ErrBookNotFound):
+		errorResponse(c, http.StatusNotFound, "book not found")
+		return
+	case err != nil:
+		r.l.Error(err, "http - v1 - books - saveReview")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, review)
+}
+
+func (r *bookRoutes) deleteReview(c *gin.Context) {
+	err := r.shelf.DeleteReview(c.Request.Context(), c.GetString("username"), c.Param("bookID"))
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - deleteReview")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+func newReadingStatusResponse(status entity.BookStatus) readingStatusResponse {
+	resp := readingStatusResponse{Status: status.Status, FinishedAt: status.FinishedAt}
+	if status.Status != "" {
+		resp.UpdatedAt = &status.UpdatedAt
+	}
+	return resp
+}
+
+// newBookResponse returns the description both as plain text and as sanitized HTML.
+func newBookResponse(book entity.Book) bookResponse {
+	resp := bookResponse{
+		ID:          book.ID,
+		Title:       book.Title,
+		Author:      book.Author,
+		Publisher:   book.Publisher,
+		Year:        book.Year,
+		ISBN:        book.ISBN,
+		DOI:         book.DOI,
+		Series:      book.Series,
+		Language:    book.Language,
+		Description: richtext.PlainText(book.Description),
+		Format:      book.Extension(),
+		FileSize:    book.FileSize,
+		Pages:       book.Pages,
+		Rating:      book.Rating,
+		RatingCount: book.RatingCount,
+		DocumentID:  book.DocumentID,
+		CreatedAt:   book.CreatedAt,
+		UpdatedAt:   book.UpdatedAt,
+		MediaType:   book.MediaType,
+		Duration:    int(book.Duration.Seconds()),
+		Genres:      book.Genres,
+		Private:     book.Private,
+	}
+	if book.Description != "" {
+		resp.DescriptionHTML = richtext.Sanitize(book.Description)
+	}
+	if book.SeriesIndex != nil && book.SeriesIndex.Valid {
+		resp.SeriesIndex = book.SeriesIndex.Decimal.String()
+	}
+	if book.Archived() {
+		archivedAt := book.ArchivedAt
+		resp.ArchivedAt = &archivedAt
+	}
+	if !book.VisibleFrom.IsZero() {
+		visibleFrom := book.VisibleFrom
+		resp.VisibleFrom = &visibleFrom
+	}
+	return resp
+}
+
+// exportBooks streams ?ids=a,b,c, or the whole library, as a zip with
+// manifest.json or a calibre metadata.opf per book (?manifest=opf).
+// citeBooks returns BibTeX (format=bibtex, the default) or RIS references
+// to the books of ids=a,b,c, the whole library without ids.
+func (r *bookRoutes) citeBooks(c *gin.Context) {
+	var ids []string
+	for _, id := range strings.Split(c.Query("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+	r.sendCitations(c, ids)
+}
+
+func (r *bookRoutes) citeBook(c *gin.Context) {
+	r.sendCitations(c, []string{c.Param("bookID")})
+}
+
+func (r *bookRoutes) sendCitations(c *gin.Context, ids []string) {
+	format := c.DefaultQuery("format", library.CitationBibTeX)
+	data, err := r.shelf.Citations(c.Request.Context(), ids, format)
+	if errors.Is(err, library.ErrUnknownCitationFormat) {
+		errorResponse(c, http.StatusBadRequest, library.ErrUnknownCitationFormat.Error())
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - v1 - books - sendCitations")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	contentType := "application/x-bibtex; charset=utf-8"
+	if format == library.CitationRIS {
+		contentType = "application/x-research-info-systems; charset=utf-8"
+	}
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func (r *bookRoutes) exportBooks(c *gin.Context) {
+	manifest := c.DefaultQuery("manifest", library.ManifestJSON)
+	if manifest != library.ManifestJSON && manifest != library.ManifestOPF {
+		errorResponse(c, http.StatusBadRequest, library.ErrInvalidManifest.Error())
+		return
+	}
+
+	var ids []string
+	for _, id := range strings.Split(c.Query("ids"), ",") {
+		if id = strings.TrimSpace(id); id != "" {
+			ids = append(ids, id)
+		}
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", "attachment; filename=kompanion-"+time.Now().Format("2006-01-02")+".zip")
+	c.Status(http.StatusOK)
+	if err := r.shelf.ExportBooks(c.Request.Context(), c.Writer, ids, manifest); err != nil {
+		r.l.Error(err, "http - v1 - books - exportBooks")
+	}
+}
+
+// exportMetadata downloads the metadata of all books as ?format=csv
+// (default) or json, to edit and send back to importMetadata.
+func (r *bookRoutes) exportMetadata(c *gin.Context) {
+	format := c.DefaultQuery("format", library.MetadataCSV)
+	contentType := map[string]string{library.MetadataCSV: "text/csv; charset=utf-8", library.MetadataJSON: "application/json"}[format]
+	if contentType == "" {
+		errorResponse(c, http.StatusBadRequest, library.ErrInvalidMetadataFormat.Error())
+		return
+	}
+
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "attachment; filename=kompanion-metadata-"+time.Now().Format("2006-01-02")+"."+format)
+	c.Status(http.StatusOK)
+	if err := r.shelf.ExportMetadata(c.Request.Context(), c.Writer, format); err != nil {
+		r.l.Error(err, "http - v1 - books - exportMetadata")
+	}
+}
+
+// importMetadata applies edited metadata in the body, CSV or with a JSON
+// content type or ?format=json JSON.
+func (r *bookRoutes) importMetadata(c *gin.Context) {
+	format := c.Query("format")
+	if format == "" {
+		format = library.MetadataCSV
+		if strings.Contains(c.ContentType(), "json") {
+			format = library.MetadataJSON
+		}
+	}
+
+	report, err := r.shelf.ImportMetadata(c.Request.Context(), c.Request.Body, format)
+	switch {
+	case forbidden(c, err):
+		return
+	case errors.Is(err, library.ErrInvalidMetadataFormat):
+		errorResponse(c, http.StatusBadRequest, library.ErrInvalidMetadataFormat.Error())
+		return
+	case errors.Is(err, library.ErrInvalidMetadataImport):
+		errorResponse(c, http.StatusBadRequest, library.ErrInvalidMetadataImport.Error())
+		return
+	case err != nil:
+		r.l.Error(err, "http - v1 - books - importMetadata")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := metadataImportResponse{Rows: report.Books, Changed: report.Changed, Problems: make([]metadataProblemResponse, 0, len(report.Problems))}
+	for _, p := range report.Problems {
+		resp.Problems = append(resp.Problems, metadataProblemResponse{BookID: p.BookID, Title: p.Title, Problem: p.Problem})
+	}
+	c.JSON(http.StatusOK, resp)
+}
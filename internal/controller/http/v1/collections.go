@@ -0,0 +1,237 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+type collectionRoutes struct {
+	shelf library.Shelf
+	l     logger.Interface
+}
+
+type collectionEntryRequest struct {
+	BookID        string   `json:"book_id" binding:"required"`
+	Note          string   `json:"note"`
+	Prerequisites []string `json:"prerequisites"`
+}
+
+type collectionRequest struct {
+	Name        string                   `json:"name" binding:"required"`
+	Description string                   `json:"description"`
+	Entries     []collectionEntryRequest `json:"entries"`
+}
+
+func (req collectionRequest) toCollection() entity.Collection {
+	collection := entity.Collection{Name: req.Name, Description: req.Description}
+	for _, entry := range req.Entries {
+		collection.Entries = append(collection.Entries, entity.CollectionEntry{
+			Book:          entity.Book{ID: entry.BookID},
+			Note:          entry.Note,
+			Prerequisites: entry.Prerequisites,
+		})
+	}
+	return collection
+}
+
+type collectionEntryResponse struct {
+	Position      int                  `json:"position"`
+	Book          bookResponse         `json:"book"`
+	Note          string               `json:"note,omitempty"`
+	Prerequisites []string             `json:"prerequisites"`
+	Status        entity.ReadingStatus `json:"status,omitempty"`
+	// Available is true once the prerequisites are finished.
+	Available bool `json:"available"`
+}
+
+type collectionResponse struct {
+	ID          string                    `json:"id"`
+	Name        string                    `json:"name"`
+	Description string                    `json:"description,omitempty"`
+	Owner       string                    `json:"owner,omitempty"`
+	CreatedAt   time.Time                 `json:"created_at"`
+	UpdatedAt   time.Time                 `json:"updated_at"`
+	FeedPrefix  string                    `json:"feed_prefix,omitempty"`
+	Entries     []collectionEntryResponse `json:"entries,omitempty"`
+}
+
+func newCollectionEntryResponse(collection entity.Collection, position int, entry entity.CollectionEntry) collectionEntryResponse {
+	prerequisites := entry.Prerequisites
+	if prerequisites == nil {
+		prerequisites = []string{}
+	}
+	return collectionEntryResponse{
+		Position:      position,
+		Book:          newBookResponse(entry.Book),
+		Note:          entry.Note,
+		Prerequisites: prerequisites,
+		Status:        entry.Status,
+		Available:     collection.Available(entry),
+	}
+}
+
+func newCollectionResponse(collection entity.Collection) collectionResponse {
+	resp := collectionResponse{
+		ID:          collection.ID,
+		Name:        collection.Name,
+		Description: collection.Description,
+		Owner:       collection.Owner,
+		CreatedAt:   collection.CreatedAt,
+		UpdatedAt:   collection.UpdatedAt,
+		FeedPrefix:  collection.FeedPrefix,
+	}
+	for i, entry := range collection.Entries {
+		resp.Entries = append(resp.Entries, newCollectionEntryResponse(collection, i, entry))
+	}
+	return resp
+}
+
+func newCollectionRoutes(handler *gin.RouterGroup, shelf library.Shelf, a auth.AuthInterface, l logger.Interface) {
+	r := &collectionRoutes{shelf, l}
+
+	h := handler.Group("/collections")
+	h.Use(authUserMiddleware(a, l))
+	{
+		h.GET("", r.listCollections)
+		h.POST("", r.createCollection)
+		h.GET("/:id", r.getCollection)
+		h.GET("/:id/next", r.nextInCollection)
+		h.PUT("/:id", r.updateCollection)
+		h.DELETE("/:id", r.deleteCollection)
+		h.POST("/:id/feed", r.shareCollectionFeed)
+		h.DELETE("/:id/feed", r.revokeCollectionFeed)
+	}
+}
+
+func (r *collectionRoutes) listCollections(c *gin.Context) {
+	collections, err := r.shelf.Collections(c.Request.Context())
+	if err != nil {
+		r.l.Error(err, "http - v1 - collections - listCollections")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	resp := make([]collectionResponse, 0, len(collections))
+	for _, collection := range collections {
+		resp = append(resp, newCollectionResponse(collection))
+	}
+	c.JSON(http.StatusOK, gin.H{"collections": resp})
+}
+
+func (r *collectionRoutes) getCollection(c *gin.Context) {
+	collection, err := r.shelf.Collection(c.Request.Context(), c.GetString("username"), c.Param("id"))
+	if r.collectionError(c, err, "getCollection") {
+		return
+	}
+	c.JSON(http.StatusOK, newCollectionResponse(collection))
+}
+
+// nextInCollection returns the first book of the collection the signed in
+// account has not finished, 204 once all are finished.
+func (r *collectionRoutes) nextInCollection(c *gin.Context) {
+	username := c.GetString("username")
+	collection, err := r.shelf.Collection(c.Request.Context(), username, c.Param("id"))
+	if r.collectionError(c, err, "nextInCollection") {
+		return
+	}
+	entry, ok := collection.Next()
+	if !ok {
+		c.Status(http.StatusNoContent)
+		return
+	}
+	position := 0
+	for i := range collection.Entries {
+		if collection.Entries[i].Book.ID == entry.Book.ID {
+			position = i
+		}
+	}
+	c.JSON(http.StatusOK, newCollectionEntryResponse(collection, position, entry))
+}
+
+func (r *collectionRoutes) createCollection(c *gin.Context) {
+	var req collectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	collection, err := r.shelf.CreateCollection(c.Request.Context(), c.GetString("username"), req.toCollection())
+	if r.collectionError(c, err, "createCollection") {
+		return
+	}
+	c.JSON(http.StatusCreated, newCollectionResponse(collection))
+}
+
+// updateCollection replaces the collection with the body, entries in the
+// new reading order.
+func (r *collectionRoutes) updateCollection(c *gin.Context) {
+	var req collectionRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	collection, err := r.shelf.UpdateCollection(c.Request.Context(), c.Param("id"), req.toCollection())
+	if r.collectionError(c, err, "updateCollection") {
+		return
+	}
+	c.JSON(http.StatusOK, newCollectionResponse(collection))
+}
+
+func (r *collectionRoutes) deleteCollection(c *gin.Context) {
+	err := r.shelf.DeleteCollection(c.Request.Context(), c.Param("id"))
+	if r.collectionError(c, err, "deleteCollection") {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// shareCollectionFeed returns the URL of an OPDS feed of the collection
+// anyone may add to their reader app without an account. Sharing again
+// replaces the URL given before.
+func (r *collectionRoutes) shareCollectionFeed(c *gin.Context) {
+	if features, ok := c.Value("features").(settings.Features); ok && !features.Enabled(settings.FeatureSharing) {
+		errorResponse(c, http.StatusNotFound, settings.FeatureSharing+" is disabled on this server")
+		return
+	}
+	token, err := r.shelf.ShareCollectionFeed(c.Request.Context(), c.Param("id"))
+	if r.collectionError(c, err, "shareCollectionFeed") {
+		return
+	}
+	// served by the opds router
+	c.JSON(http.StatusCreated, gin.H{"url": absoluteURL(c.Request, "/opds/shared/"+token+"/"), "feed_prefix": token[:6]})
+}
+
+func (r *collectionRoutes) revokeCollectionFeed(c *gin.Context) {
+	err := r.shelf.RevokeCollectionFeed(c.Request.Context(), c.Param("id"))
+	if r.collectionError(c, err, "revokeCollectionFeed") {
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
+
+// collectionError answers the error of a collection call, it reports
+// whether there was one.
+func (r *collectionRoutes) collectionError(c *gin.Context, err error, handler string) bool {
+	switch {
+	case err == nil:
+		return false
+	case forbidden(c, err):
+	case errors.Is(err, entity.ErrInvalidCollection):
+		errorResponse(c, http.StatusBadRequest, entity.ErrInvalidCollection.Error())
+	case errors.Is(err, entity.ErrCollectionNotFound):
+		errorResponse(c, http.StatusNotFound, entity.ErrCollectionNotFound.Error())
+	case errors.Is(err, entity.ErrBookNotFound):
+		errorResponse(c, http.StatusBadRequest, "book not found")
+	default:
+		r.l.Error(err, "http - v1 - collections - "+handler)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	}
+	return true
+}
@@ -0,0 +1,106 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/sync"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+type conflictRoutes struct {
+	progress sync.Progress
+	l        logger.Interface
+}
+
+type conflictPositionResponse struct {
+	Percentage float64   `json:"percentage"`
+	Progress   string    `json:"progress"`
+	Device     string    `json:"device"`
+	SyncedAt   time.Time `json:"synced_at"`
+}
+
+type conflictResponse struct {
+	ID        int64                    `json:"id"`
+	Document  string                   `json:"document"`
+	Ahead     conflictPositionResponse `json:"ahead"`
+	Behind    conflictPositionResponse `json:"behind"`
+	CreatedAt time.Time                `json:"created_at"`
+}
+
+type resolveConflictRequest struct {
+	Keep string `json:"keep" binding:"required,oneof=ahead behind"`
+}
+
+func newConflictPosition(p entity.Progress) conflictPositionResponse {
+	return conflictPositionResponse{
+		Percentage: p.Percentage,
+		Progress:   p.Progress,
+		Device:     p.AuthDeviceName,
+		SyncedAt:   time.Unix(p.Timestamp, 0).UTC(),
+	}
+}
+
+func newConflictRoutes(handler *gin.RouterGroup, p sync.Progress, a auth.AuthInterface, l logger.Interface) {
+	r := &conflictRoutes{p, l}
+
+	h := handler.Group("/sync/conflicts")
+	h.Use(authUserMiddleware(a, l))
+	{
+		h.GET("", r.listConflicts)
+		h.POST("/:id/resolve", r.resolveConflict)
+	}
+}
+
+func (r *conflictRoutes) listConflicts(c *gin.Context) {
+	conflicts, err := r.progress.Conflicts(c.Request.Context())
+	if err != nil {
+		r.l.Error(err, "http - v1 - conflicts - listConflicts")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := make([]conflictResponse, 0, len(conflicts))
+	for _, conflict := range conflicts {
+		resp = append(resp, conflictResponse{
+			ID:        conflict.ID,
+			Document:  conflict.Document,
+			Ahead:     newConflictPosition(conflict.Ahead),
+			Behind:    newConflictPosition(conflict.Behind),
+			CreatedAt: conflict.CreatedAt,
+		})
+	}
+	c.JSON(http.StatusOK, gin.H{"conflicts": resp})
+}
+
+// resolveConflict keeps {"keep": "ahead"} or {"keep": "behind"}, devices
+// get the kept position on their next pull.
+func (r *conflictRoutes) resolveConflict(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err != nil {
+		errorResponse(c, http.StatusNotFound, "conflict not found")
+		return
+	}
+	var req resolveConflictRequest
+	if err = c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "keep must be ahead or behind")
+		return
+	}
+
+	kept, err := r.progress.ResolveConflict(c.Request.Context(), id, req.Keep == "behind")
+	switch {
+	case errors.Is(err, entity.ErrProgressConflictNotFound):
+		errorResponse(c, http.StatusNotFound, "conflict not found")
+	case err != nil:
+		r.l.Error(err, "http - v1 - conflicts - resolveConflict")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.JSON(http.StatusOK, newConflictPosition(kept))
+	}
+}
@@ -1,7 +1,12 @@
 package v1
 
 import (
+	"errors"
+	"net/http"
+
 	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/entity"
 )
 
 type response struct {
@@ -11,3 +16,13 @@ type response struct {
 func errorResponse(c *gin.Context, code int, msg string) {
 	c.AbortWithStatusJSON(code, response{msg})
 }
+
+// forbidden answers 403 when the role of the account does not allow the
+// change, it reports whether it did.
+func forbidden(c *gin.Context, err error) bool {
+	if !errors.Is(err, entity.ErrForbidden) {
+		return false
+	}
+	errorResponse(c, http.StatusForbidden, entity.ErrForbidden.Error())
+	return true
+}
@@ -0,0 +1,67 @@
+package v1
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fieldSet is a sparse fieldset from ?fields=id,title. A nil set keeps every
+// field, id is always kept so list items can still be told apart.
+type fieldSet map[string]bool
+
+// parseFields validates ?fields= against the json names of the response type.
+func parseFields(c *gin.Context, response interface{}) (fieldSet, error) {
+	raw := c.Query("fields")
+	if raw == "" {
+		return nil, nil
+	}
+	known := jsonFields(reflect.TypeOf(response))
+	fields := fieldSet{"id": true}
+	for _, name := range strings.Split(raw, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !known[name] {
+			return nil, fmt.Errorf("unknown field %q", name)
+		}
+		fields[name] = true
+	}
+	return fields, nil
+}
+
+// apply keeps only the selected fields of v.
+func (f fieldSet) apply(v interface{}) (interface{}, error) {
+	if f == nil {
+		return v, nil
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var all map[string]json.RawMessage
+	if err = json.Unmarshal(data, &all); err != nil {
+		return nil, err
+	}
+	for name := range all {
+		if !f[name] {
+			delete(all, name)
+		}
+	}
+	return all, nil
+}
+
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
@@ -0,0 +1,37 @@
+package v1
+
+import (
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestFieldSetKeepsSelectedFields(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/books?fields=title,%20pages", nil)
+
+	fields, err := parseFields(c, bookResponse{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	v, err := fields.apply(bookResponse{ID: "1", Title: "title", Description: "long text", Pages: 10})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	got := v.(map[string]json.RawMessage)
+	if len(got) != 3 || string(got["title"]) != `"title"` || string(got["pages"]) != "10" || string(got["id"]) != `"1"` {
+		t.Errorf("unexpected fields %v", got)
+	}
+}
+
+func TestParseFieldsRejectsUnknownField(t *testing.T) {
+	c, _ := gin.CreateTestContext(httptest.NewRecorder())
+	c.Request = httptest.NewRequest("GET", "/api/books?fields=title,password", nil)
+
+	if _, err := parseFields(c, bookResponse{}); err == nil {
+		t.Fatal("expected error for unknown field")
+	}
+}
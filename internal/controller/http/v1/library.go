@@ -0,0 +1,310 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/pkg/converter"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+type libraryRoutes struct {
+	shelf    library.Shelf
+	settings settings.Settings
+	auth     auth.AuthInterface
+	l        logger.Interface
+}
+
+type libraryCheckResponse struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Books      int       `json:"books"`
+	Issues     int       `json:"issues"`
+}
+
+type bookIssueResponse struct {
+	BookID     string    `json:"book_id"`
+	Title      string    `json:"title"`
+	Kind       string    `json:"kind"`
+	Detail     string    `json:"detail"`
+	DetectedAt time.Time `json:"detected_at"`
+	CheckedAt  time.Time `json:"checked_at"`
+}
+
+type libraryIssuesResponse struct {
+	LastCheck *libraryCheckResponse `json:"last_check"`
+	Issues    []bookIssueResponse   `json:"issues"`
+}
+
+type libraryStatsResponse struct {
+	Books       int             `json:"books"`
+	Bytes       int64           `json:"bytes"`
+	FileBytes   int64           `json:"file_bytes"`
+	FormatBytes int64           `json:"format_bytes"`
+	CoverBytes  int64           `json:"cover_bytes"`
+	Authors     []facetResponse `json:"authors"`
+	Publishers  []facetResponse `json:"publishers"`
+	Formats     []facetResponse `json:"formats"`
+	Languages   []facetResponse `json:"languages"`
+	Years       []facetResponse `json:"years"`
+	Months      []facetResponse `json:"months"`
+}
+
+type formatAdviceResponse struct {
+	Book    bookResponse `json:"book"`
+	Formats []string     `json:"formats"`
+	Devices []string     `json:"devices"`
+	Target  string       `json:"target,omitempty"`
+}
+
+type formatReportResponse struct {
+	Formats []facetResponse        `json:"formats"`
+	Books   []formatAdviceResponse `json:"books"`
+}
+
+type pruneRequest struct {
+	Keep    []string `json:"keep"`
+	Drop    []string `json:"drop"`
+	Exclude []string `json:"exclude"`
+	DryRun  bool     `json:"dry_run"`
+}
+
+type prunedFileResponse struct {
+	Book     bookResponse `json:"book"`
+	Format   string       `json:"format"`
+	FileSize int64        `json:"file_size"`
+}
+
+type pruneResponse struct {
+	Books    int                  `json:"books"`
+	Files    []prunedFileResponse `json:"files"`
+	Bytes    int64                `json:"bytes"`
+	DryRun   bool                 `json:"dry_run"`
+	Problems []string             `json:"problems"`
+}
+
+func newLibraryRoutes(handler *gin.RouterGroup, shelf library.Shelf, st settings.Settings, a auth.AuthInterface, l logger.Interface) {
+	r := &libraryRoutes{shelf, st, a, l}
+
+	h := handler.Group("/library")
+	h.Use(authUserMiddleware(a, l))
+	{
+		h.GET("/stats", r.stats)
+		h.GET("/catalog", r.catalog)
+		h.GET("/issues", r.listIssues)
+		h.POST("/verify", r.startVerify)
+		h.GET("/formats", r.formats)
+		h.POST("/formats/convert", r.convertFormats)
+		h.POST("/formats/prune", r.pruneFormats)
+	}
+}
+
+func (r *libraryRoutes) stats(c *gin.Context) {
+	stats, err := r.shelf.LibraryStats(c.Request.Context())
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.JSON(http.StatusOK, libraryStatsResponse{
+		Books:       stats.Usage.Books,
+		Bytes:       stats.Usage.Bytes(),
+		FileBytes:   stats.Usage.FileBytes,
+		FormatBytes: stats.Usage.FormatBytes,
+		CoverBytes:  stats.Usage.CoverBytes,
+		Authors:     facetCounts(stats.Authors),
+		Publishers:  facetCounts(stats.Publishers),
+		Formats:     facetCounts(stats.Formats),
+		Languages:   facetCounts(stats.Languages),
+		Years:       facetCounts(stats.Years),
+		Months:      facetCounts(stats.Months),
+	})
+}
+
+// catalog renders the library, or ?collection=<id>, as a catalog to print,
+// format=html (default) or pdf.
+func (r *libraryRoutes) catalog(c *gin.Context) {
+	format := c.DefaultQuery("format", library.CatalogHTML)
+	branding, err := r.settings.Branding(c.Request.Context())
+	if err != nil {
+		r.l.Error(err, "http - v1 - library - catalog")
+	}
+
+	contentType := "text/html; charset=utf-8"
+	if format == library.CatalogPDF {
+		contentType = "application/pdf"
+	}
+	c.Header("Content-Type", contentType)
+	c.Header("Content-Disposition", "inline; filename=catalog-"+time.Now().Format("2006-01-02")+"."+strings.ToLower(format))
+	err = r.shelf.Catalog(c.Request.Context(), c.Writer, branding.InstanceName, c.Query("collection"), format)
+	if err != nil && !c.Writer.Written() {
+		// nothing was sent, the error goes out as JSON
+		c.Writer.Header().Del("Content-Type")
+		c.Writer.Header().Del("Content-Disposition")
+	}
+	switch {
+	case err == nil:
+	case c.Writer.Written():
+		// the response has started, the client gets a truncated catalog
+		r.l.Error(err, "http - v1 - library - catalog")
+	case errors.Is(err, library.ErrUnknownCatalogFormat):
+		errorResponse(c, http.StatusBadRequest, library.ErrUnknownCatalogFormat.Error())
+	case errors.Is(err, entity.ErrCollectionNotFound):
+		errorResponse(c, http.StatusNotFound, "collection not found")
+	case errors.Is(err, library.ErrNoConverter), errors.Is(err, converter.ErrNotInstalled), errors.Is(err, settings.ErrFeatureDisabled):
+		errorResponse(c, http.StatusNotImplemented, "pdf catalogs need the converter")
+	default:
+		r.l.Error(err, "http - v1 - library - catalog")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	}
+}
+
+func (r *libraryRoutes) listIssues(c *gin.Context) {
+	check, issues, err := r.shelf.LibraryIssues(c.Request.Context())
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := libraryIssuesResponse{Issues: make([]bookIssueResponse, 0, len(issues))}
+	if !check.StartedAt.IsZero() {
+		resp.LastCheck = &libraryCheckResponse{
+			StartedAt:  check.StartedAt,
+			FinishedAt: check.FinishedAt,
+			Books:      check.Books,
+			Issues:     check.Issues,
+		}
+	}
+	for _, issue := range issues {
+		resp.Issues = append(resp.Issues, bookIssueResponse{
+			BookID:     issue.BookID,
+			Title:      issue.Title,
+			Kind:       issue.Kind,
+			Detail:     issue.Detail,
+			DetectedAt: issue.DetectedAt,
+			CheckedAt:  issue.CheckedAt,
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func (r *libraryRoutes) startVerify(c *gin.Context) {
+	err := r.shelf.StartVerifyLibrary(c.Request.Context())
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, library.ErrVerifyRunning):
+		errorResponse(c, http.StatusConflict, "library check is already running")
+	case err != nil:
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.Status(http.StatusAccepted)
+	}
+}
+
+// formatReport advises conversions for the registered devices.
+func (r *libraryRoutes) formatReport(c *gin.Context) (library.FormatReport, error) {
+	devices, err := r.auth.ListDevices(c.Request.Context())
+	if err != nil {
+		return library.FormatReport{}, err
+	}
+	formats := make(map[string][]string, len(devices))
+	for _, device := range devices {
+		formats[device.Name] = device.Formats
+	}
+	return r.shelf.FormatReport(c.Request.Context(), formats)
+}
+
+func (r *libraryRoutes) formats(c *gin.Context) {
+	report, err := r.formatReport(c)
+	if err != nil {
+		r.l.Error(err, "http - v1 - library - formats")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := formatReportResponse{Formats: facetCounts(report.Formats), Books: make([]formatAdviceResponse, 0, len(report.Books))}
+	for _, advice := range report.Books {
+		resp.Books = append(resp.Books, formatAdviceResponse{
+			Book:    newBookResponse(advice.Book),
+			Formats: advice.Formats,
+			Devices: advice.Devices,
+			Target:  advice.Target,
+		})
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+// convertFormats queues the conversions the format report advises.
+func (r *libraryRoutes) convertFormats(c *gin.Context) {
+	report, err := r.formatReport(c)
+	if err != nil {
+		r.l.Error(err, "http - v1 - library - convertFormats")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	queued, err := r.shelf.StartConversions(c.Request.Context(), report)
+	switch {
+	case forbidden(c, err):
+	case errors.Is(err, library.ErrConversionsRunning):
+		errorResponse(c, http.StatusConflict, err.Error())
+	case errors.Is(err, library.ErrNoConverter):
+		errorResponse(c, http.StatusNotImplemented, "conversions need the converter")
+	case err != nil:
+		r.l.Error(err, "http - v1 - library - convertFormats")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+	default:
+		c.JSON(http.StatusAccepted, gin.H{"queued": queued})
+	}
+}
+
+// pruneFormats deletes redundant formats of books with several files,
+// {"keep": ["epub", "azw3"], "drop": ["mobi"], "exclude": [book ids],
+// "dry_run": true} only lists them.
+func (r *libraryRoutes) pruneFormats(c *gin.Context) {
+	var req pruneRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if len(req.Keep) == 0 && len(req.Drop) == 0 {
+		errorResponse(c, http.StatusBadRequest, "keep or drop is required")
+		return
+	}
+
+	policy := library.PrunePolicy{Keep: req.Keep, Drop: req.Drop, Exclude: req.Exclude}
+	report, err := r.shelf.PruneFormats(c.Request.Context(), policy, req.DryRun)
+	switch {
+	case forbidden(c, err):
+		return
+	case err != nil:
+		r.l.Error(err, "http - v1 - library - pruneFormats")
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	resp := pruneResponse{
+		Books:    report.Books,
+		Files:    make([]prunedFileResponse, 0, len(report.Files)),
+		Bytes:    report.Bytes,
+		DryRun:   req.DryRun,
+		Problems: make([]string, 0, len(report.Problems)),
+	}
+	for _, f := range report.Files {
+		resp.Files = append(resp.Files, prunedFileResponse{Book: newBookResponse(f.Book), Format: f.File.Format, FileSize: f.File.FileSize})
+	}
+	for _, p := range report.Problems {
+		resp.Problems = append(resp.Problems, p.BookID+": "+p.Problem)
+	}
+	c.JSON(http.StatusOK, resp)
+}
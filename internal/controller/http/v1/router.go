@@ -7,17 +7,20 @@ import (
 	"github.com/gin-gonic/gin"
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 
+	"github.com/banjuer/kompanion/internal/annotations"
 	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/backup"
 	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
 	"github.com/banjuer/kompanion/internal/sync"
 	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/signedurl"
 )
 
 // NewRouter -.
-func NewRouter(handler *gin.Engine, l logger.Interface, a auth.AuthInterface, p sync.Progress, shelf library.Shelf) {
+func NewRouter(handler *gin.Engine, l logger.Interface, a auth.AuthInterface, p sync.Progress, shelf library.Shelf, an annotations.Annotations, st settings.Settings, b backup.Backups, links *signedurl.Signer) {
 	// Options
 	handler.Use(gin.Logger())
-	handler.Use(gin.Recovery())
 
 	// K8s probe
 	handler.GET("/healthcheck", func(c *gin.Context) { c.Status(http.StatusOK) })
@@ -31,4 +34,17 @@ func NewRouter(handler *gin.Engine, l logger.Interface, a auth.AuthInterface, p
 	syncRoutes := handler.Group("/syncs")
 	syncRoutes.Use(authDeviceMiddleware(a, l))
 	newSyncRoutes(syncRoutes, p, l)
+
+	annotationGroup := handler.Group("/annotations")
+	annotationGroup.Use(authDeviceMiddleware(a, l))
+	newAnnotationRoutes(annotationGroup, an, l)
+
+	apiGroup := handler.Group("/api")
+	newSettingsRoutes(apiGroup, st, a, l)
+	newBackupRoutes(apiGroup, b, a, l)
+	newAccountRoutes(apiGroup, a, shelf, l)
+	newBookRoutes(apiGroup, shelf, p, links, a, l)
+	newLibraryRoutes(apiGroup, shelf, st, a, l)
+	newCollectionRoutes(apiGroup, shelf, a, l)
+	newConflictRoutes(apiGroup, p, a, l)
 }
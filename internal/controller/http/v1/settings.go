@@ -0,0 +1,225 @@
+package v1
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+type settingsRoutes struct {
+	settings settings.Settings
+	l        logger.Interface
+}
+
+func newSettingsRoutes(handler *gin.RouterGroup, s settings.Settings, a auth.AuthInterface, l logger.Interface) {
+	r := &settingsRoutes{s, l}
+
+	h := handler.Group("/settings")
+	{
+		// branding is public: login page and OPDS clients render it before auth
+		h.GET("/branding", r.getBranding)
+		h.PUT("/branding", authUserMiddleware(a, l), r.updateBranding)
+		h.GET("/maintenance", authUserMiddleware(a, l), r.getMaintenance)
+		h.PUT("/maintenance", authUserMiddleware(a, l), r.updateMaintenance)
+		h.GET("/sharing", authUserMiddleware(a, l), r.getSharing)
+		h.PUT("/sharing", authUserMiddleware(a, l), r.updateSharing)
+		h.GET("/features", authUserMiddleware(a, l), r.getFeatures)
+		h.PUT("/features", authUserMiddleware(a, l), r.updateFeatures)
+		h.GET("/metadata-rules", authUserMiddleware(a, l), r.getMetadataRules)
+		h.PUT("/metadata-rules", authUserMiddleware(a, l), r.updateMetadataRules)
+	}
+}
+
+func (r *settingsRoutes) getBranding(c *gin.Context) {
+	branding, err := r.settings.Branding(c.Request.Context())
+	if err != nil {
+		r.l.Error(err)
+	}
+	c.JSON(http.StatusOK, branding)
+}
+
+func (r *settingsRoutes) updateBranding(c *gin.Context) {
+	var branding settings.Branding
+	if err := c.ShouldBindJSON(&branding); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	updated, err := r.settings.UpdateBranding(c.Request.Context(), branding)
+	if forbidden(c, err) {
+		return
+	}
+	if errors.Is(err, settings.ErrInvalidValue) {
+		errorResponse(c, http.StatusBadRequest, "invalid branding value")
+		return
+	}
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+type maintenanceRequest struct {
+	ReadOnly bool   `json:"read_only"`
+	Message  string `json:"message"`
+	// seconds, sent back to clients in Retry-After
+	RetryAfter int `json:"retry_after"`
+}
+
+func newMaintenanceRequest(m settings.Maintenance) maintenanceRequest {
+	return maintenanceRequest{
+		ReadOnly:   m.ReadOnly,
+		Message:    m.Message,
+		RetryAfter: int(m.RetryAfter.Seconds()),
+	}
+}
+
+func (r *settingsRoutes) getMaintenance(c *gin.Context) {
+	maintenance, err := r.settings.Maintenance(c.Request.Context())
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, newMaintenanceRequest(maintenance))
+}
+
+func (r *settingsRoutes) updateMaintenance(c *gin.Context) {
+	var req maintenanceRequest
+	if err := c.ShouldBindJSON(&req); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	updated, err := r.settings.SetMaintenance(c.Request.Context(), settings.Maintenance{
+		ReadOnly:   req.ReadOnly,
+		Message:    req.Message,
+		RetryAfter: time.Duration(req.RetryAfter) * time.Second,
+	})
+	if forbidden(c, err) {
+		return
+	}
+	if errors.Is(err, settings.ErrInvalidValue) {
+		errorResponse(c, http.StatusBadRequest, "invalid maintenance value")
+		return
+	}
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.JSON(http.StatusOK, newMaintenanceRequest(updated))
+}
+
+func (r *settingsRoutes) getSharing(c *gin.Context) {
+	sharing, err := r.settings.Sharing(c.Request.Context())
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, sharing)
+}
+
+func (r *settingsRoutes) updateSharing(c *gin.Context) {
+	var sharing settings.Sharing
+	if err := c.ShouldBindJSON(&sharing); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	updated, err := r.settings.SetSharing(c.Request.Context(), sharing)
+	if forbidden(c, err) {
+		return
+	}
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+func (r *settingsRoutes) getFeatures(c *gin.Context) {
+	features, err := r.settings.Features(c.Request.Context())
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, features)
+}
+
+// updateFeatures switches the features in the body, e.g. {"opds": false},
+// the others stay as they are.
+func (r *settingsRoutes) updateFeatures(c *gin.Context) {
+	var changes settings.Features
+	if err := c.ShouldBindJSON(&changes); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	updated, err := r.settings.SetFeatures(c.Request.Context(), changes)
+	if forbidden(c, err) {
+		return
+	}
+	if errors.Is(err, settings.ErrInvalidValue) {
+		errorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
+
+func (r *settingsRoutes) getMetadataRules(c *gin.Context) {
+	rules, err := r.settings.MetadataRules(c.Request.Context())
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+	c.JSON(http.StatusOK, rules)
+}
+
+// updateMetadataRules replaces the rules with the list in the body, they
+// are applied to uploads in order.
+func (r *settingsRoutes) updateMetadataRules(c *gin.Context) {
+	var rules []entity.MetadataRule
+	if err := c.ShouldBindJSON(&rules); err != nil {
+		errorResponse(c, http.StatusBadRequest, "invalid request body")
+		return
+	}
+
+	updated, err := r.settings.SetMetadataRules(c.Request.Context(), rules)
+	if forbidden(c, err) {
+		return
+	}
+	if errors.Is(err, settings.ErrInvalidValue) {
+		errorResponse(c, http.StatusBadRequest, err.Error())
+		return
+	}
+	if err != nil {
+		r.l.Error(err)
+		errorResponse(c, http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	c.JSON(http.StatusOK, updated)
+}
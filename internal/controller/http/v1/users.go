@@ -2,9 +2,13 @@ package v1
 
 import (
 	"net/http"
+	"strings"
 
 	"github.com/gin-gonic/gin"
 	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/controller/http/middleware"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
 	"github.com/banjuer/kompanion/pkg/logger"
 )
 
@@ -33,16 +37,88 @@ func authDeviceMiddleware(auth auth.AuthInterface, l logger.Interface) gin.Handl
 		username := c.GetHeader("x-auth-user")
 		hashed_password := c.GetHeader("x-auth-key")
 		if username == "" || hashed_password == "" {
+			if !middleware.RateLimitAccount(c, "") {
+				return
+			}
 			c.AsciiJSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized", "code": 2001})
 			c.Abort()
 			return
 		}
 		if !auth.CheckDevicePassword(c.Request.Context(), username, hashed_password, false) {
+			if !middleware.RateLimitAccount(c, "") {
+				return
+			}
 			c.AsciiJSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized", "code": 2001})
 			c.Abort()
 			return
 		}
+		if !middleware.RateLimitAccount(c, "device:"+username) {
+			return
+		}
 		c.Set("device_name", username)
 		c.Next()
 	}
 }
+
+// authUserMiddleware authenticates the account owner with basic auth,
+// it is used for management API that devices should not reach. Scripts
+// send an API key of the user as bearer token or as the password, read-only
+// keys may only read.
+func authUserMiddleware(a auth.AuthInterface, l logger.Interface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		username, password, ok := c.Request.BasicAuth()
+		if bearer, found := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer "); found {
+			password, ok = strings.TrimSpace(bearer), true
+		}
+		if ok && auth.IsAPIKey(password) {
+			key, err := a.CheckAPIKey(c.Request.Context(), password)
+			if err != nil || key.Device != "" || (username != "" && username != key.Username) {
+				if !middleware.RateLimitAccount(c, "") {
+					return
+				}
+				c.Header("WWW-Authenticate", `Basic realm="KOmpanion API"`)
+				errorResponse(c, http.StatusUnauthorized, "unauthorized")
+				return
+			}
+			if !key.CanWrite() && c.Request.Method != http.MethodGet && c.Request.Method != http.MethodHead {
+				errorResponse(c, http.StatusForbidden, "api key is read-only")
+				return
+			}
+			if !setAccount(c, a, key.Username) {
+				return
+			}
+			c.Next()
+			return
+		}
+		if !ok || !a.CheckPassword(c.Request.Context(), username, password) {
+			if !middleware.RateLimitAccount(c, "") {
+				return
+			}
+			c.Header("WWW-Authenticate", `Basic realm="KOmpanion API"`)
+			errorResponse(c, http.StatusUnauthorized, "unauthorized")
+			return
+		}
+		if !setAccount(c, a, username) {
+			return
+		}
+		c.Next()
+	}
+}
+
+// setAccount puts the signed in account and its role in the request, the
+// use cases check the role and hide the private books of other accounts.
+// The rate limits count the request for the account.
+func setAccount(c *gin.Context, a auth.AuthInterface, username string) bool {
+	if !middleware.RateLimitAccount(c, "user:"+username) {
+		return false
+	}
+	role, err := a.UserRole(c.Request.Context(), username)
+	if err != nil {
+		errorResponse(c, http.StatusUnauthorized, "unauthorized")
+		return false
+	}
+	c.Set("username", username)
+	ctx := entity.WithRole(c.Request.Context(), role)
+	c.Request = c.Request.WithContext(library.WithReader(ctx, username))
+	return true
+}
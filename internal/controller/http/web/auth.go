@@ -3,24 +3,36 @@ package web
 import (
 	"github.com/gin-gonic/gin"
 	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/controller/http/middleware"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
 	"github.com/banjuer/kompanion/pkg/logger"
 )
 
 type authRoutes struct {
 	auth auth.AuthInterface
+	sso  SingleSignOn
 	l    logger.Interface
 }
 
-func newAuthRoutes(handler *gin.RouterGroup, a auth.AuthInterface, l logger.Interface) {
-	r := &authRoutes{a, l}
+func newAuthRoutes(handler *gin.RouterGroup, a auth.AuthInterface, sso SingleSignOn, l logger.Interface) {
+	r := &authRoutes{a, sso, l}
 
 	handler.GET("/login", r.loginForm)
 	handler.POST("/login", r.loginAction)
 	handler.GET("/logout", r.logoutAction)
+	newSSORoutes(handler, a, sso, l)
 }
 
 func (r *authRoutes) loginForm(c *gin.Context) {
-	c.HTML(200, "login", passStandartContext(c, gin.H{}))
+	data := gin.H{}
+	if r.sso.Client != nil {
+		data["sso"] = r.sso.Name
+	}
+	if msg := c.Query("sso_error"); msg != "" {
+		data["error"] = msg
+	}
+	c.HTML(200, "login", passStandartContext(c, data))
 }
 
 func (r *authRoutes) logoutAction(c *gin.Context) {
@@ -45,7 +57,11 @@ func (r *authRoutes) loginAction(c *gin.Context) {
 	)
 	if err != nil {
 		r.l.Error(err)
-		c.HTML(200, "login", passStandartContext(c, gin.H{"error": err.Error()}))
+		data := gin.H{"error": err.Error()}
+		if r.sso.Client != nil {
+			data["sso"] = r.sso.Name
+		}
+		c.HTML(200, "login", passStandartContext(c, data))
 		return
 	}
 	c.SetCookie("session", sessionKey, 0, "/", "", false, true)
@@ -61,12 +77,42 @@ func authMiddleware(a auth.AuthInterface) gin.HandlerFunc {
 			return
 		}
 
-		if !a.IsAuthenticated(c.Request.Context(), sessionKey) {
+		username, err := a.SessionUser(c.Request.Context(), sessionKey)
+		if err != nil {
+			if !middleware.RateLimitAccount(c, "") {
+				return
+			}
+			c.Redirect(302, "/auth/login")
+			c.Abort()
+			return
+		}
+		if !middleware.RateLimitAccount(c, "user:"+username) {
+			return
+		}
+		role, err := a.UserRole(c.Request.Context(), username)
+		if err != nil {
 			c.Redirect(302, "/auth/login")
 			c.Abort()
 			return
 		}
 		c.Set("isAuthenticated", true)
+		c.Set("username", username)
+		c.Set("role", role)
+		ctx := entity.WithRole(c.Request.Context(), role)
+		c.Request = c.Request.WithContext(library.WithReader(ctx, username))
+		c.Next()
+	}
+}
+
+// adminMiddleware keeps the pages that manage the instance from the other
+// roles, the use cases refuse their changes anyway.
+func adminMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if role, ok := entity.RoleFrom(c.Request.Context()); ok && !role.CanManage() {
+			c.HTML(403, "error", passStandartContext(c, gin.H{"error": entity.ErrForbidden.Error()}))
+			c.Abort()
+			return
+		}
 		c.Next()
 	}
 }
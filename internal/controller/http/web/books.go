@@ -1,26 +1,40 @@
 package web
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"net/http"
 	"net/url"
 	"os"
+	"path"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/banjuer/kompanion/internal/annotations"
 	"github.com/banjuer/kompanion/internal/entity"
 	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
 	"github.com/banjuer/kompanion/internal/stats"
 	syncpkg "github.com/banjuer/kompanion/internal/sync"
+	"github.com/banjuer/kompanion/pkg/comic"
+	"github.com/banjuer/kompanion/pkg/httpfile"
 	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/metadata"
+	"github.com/banjuer/kompanion/pkg/signedurl"
+	"github.com/banjuer/kompanion/pkg/thumbnail"
 	"github.com/gin-gonic/gin"
 	"github.com/shopspring/decimal"
 )
 
 type booksRoutes struct {
-	shelf    library.Shelf
-	stats    stats.ReadingStats
-	progress syncpkg.Progress
-	logger   logger.Interface
+	shelf       library.Shelf
+	stats       stats.ReadingStats
+	progress    syncpkg.Progress
+	annotations annotations.Annotations
+	links       *signedurl.Signer
+	logger      logger.Interface
 }
 
 type bookMetadataForm struct {
@@ -32,6 +46,8 @@ type bookMetadataForm struct {
 	Series      string `form:"series"`
 	SeriesIndex string `form:"series_index"`
 	ISBN        string `form:"isbn"`
+	DOI         string `form:"doi"`
+	Language    string `form:"language"`
 }
 
 func (f bookMetadataForm) toBook() (entity.Book, error) {
@@ -43,6 +59,8 @@ func (f bookMetadataForm) toBook() (entity.Book, error) {
 	book.Publisher = f.Publisher
 	book.Series = f.Series
 	book.ISBN = f.ISBN
+	book.DOI = f.DOI
+	book.Language = f.Language
 
 	if year := strings.TrimSpace(f.Year); year != "" {
 		parsedYear, err := strconv.Atoi(year)
@@ -64,18 +82,42 @@ func (f bookMetadataForm) toBook() (entity.Book, error) {
 	return book, nil
 }
 
-func newBooksRoutes(handler *gin.RouterGroup, shelf library.Shelf, stats stats.ReadingStats, progress syncpkg.Progress, l logger.Interface) {
-	r := &booksRoutes{shelf: shelf, stats: stats, progress: progress, logger: l}
+func newBooksRoutes(handler *gin.RouterGroup, shelf library.Shelf, stats stats.ReadingStats, progress syncpkg.Progress, an annotations.Annotations, links *signedurl.Signer, l logger.Interface) {
+	r := &booksRoutes{shelf: shelf, stats: stats, progress: progress, annotations: an, links: links, logger: l}
 
 	handler.GET("/", r.listBooks)
 	handler.POST("/upload", r.uploadBook)
+	handler.GET("/covers", r.coverBundle)
+	handler.GET("/downloads", r.listDownloads)
+	handler.GET("/export", r.exportBooks)
+	handler.GET("/catalog", r.catalog)
+	handler.GET("/cite", r.citeBooks)
+	handler.GET("/random", r.randomBook)
 	handler.GET("/:bookID", r.viewBook)
 	handler.POST("/:bookID", r.updateBookMetadata)
 	handler.POST("/:bookID/enrich", r.enrichBookMetadata)
 	handler.DELETE("/:bookID", r.deleteBook)
+	handler.POST("/:bookID/archive", r.archiveBook)
+	handler.POST("/:bookID/visible-from", r.setVisibleFrom)
 	handler.GET("/:bookID/download", r.downloadBook)
+	handler.POST("/:bookID/files", r.addBookFile)
+	handler.DELETE("/:bookID/files/:format", r.deleteBookFile)
+	handler.GET("/:bookID/stream", r.streamBook)
+	handler.GET("/:bookID/pages/:page", r.comicPage)
 	handler.GET("/:bookID/cover", r.viewBookCover)
 	handler.POST("/:bookID/cover", r.uploadBookCover)
+	handler.GET("/:bookID/annotations", r.exportAnnotations)
+	handler.GET("/:bookID/cite", r.citeBook)
+	handler.GET("/:bookID/position", r.viewPosition)
+	handler.POST("/:bookID/send", r.sendToDevice)
+	handler.POST("/:bookID/share", r.createShareLink)
+	handler.POST("/:bookID/share/:linkID/revoke", r.revokeShareLink)
+	handler.POST("/:bookID/lend", r.lendBook)
+	handler.POST("/:bookID/loans/:loanID/end", r.endLoan)
+	handler.POST("/:bookID/editions", r.linkEdition)
+	handler.POST("/:bookID/editions/unlink", r.unlinkEdition)
+	handler.POST("/:bookID/status", r.setReadingStatus)
+	handler.POST("/:bookID/review", r.saveReview)
 }
 
 func (r *booksRoutes) listBooks(c *gin.Context) {
@@ -87,38 +129,52 @@ func (r *booksRoutes) listBooks(c *gin.Context) {
 		}
 	}
 	if perPageStr := c.Query("perPage"); perPageStr != "" {
+		// the shelf caps it
 		if pp, err := strconv.Atoi(perPageStr); err == nil && pp > 0 {
-			// Limit perPage to reasonable values
-			if pp <= 100 {
-				perPage = pp
-			} else {
-				perPage = 100
-			}
+			perPage = pp
 		}
 	}
 
 	// 获取搜索查询参数
 	query := c.Query("q")
+	filter := library.NewBookFilter(c.Query("lang")).WithStatus(c.GetString("username"), c.Query("status"))
+	filter.GroupEditions = true
+	sortBy := c.DefaultQuery("sort", "created_at")
+	sortOrder := c.DefaultQuery("order", "desc")
 
 	var books library.PaginatedBookList
 	var err error
+	ctx := c.Request.Context()
+	if exact, _ := strconv.ParseBool(c.Query("exact")); !exact {
+		ctx = library.WithApproximateCount(ctx)
+	}
 
 	// 根据是否有搜索查询来决定调用哪个方法
 	if query != "" {
-		books, err = r.shelf.SearchBooks(c.Request.Context(), query, "created_at", "desc", page, perPage)
+		books, err = r.shelf.SearchBooks(ctx, query, filter, sortBy, sortOrder, page, perPage)
 	} else {
-		books, err = r.shelf.ListBooks(c.Request.Context(), "created_at", "desc", page, perPage)
+		books, err = r.shelf.ListBooks(ctx, filter, sortBy, sortOrder, page, perPage)
 	}
 
+	if errors.Is(err, library.ErrInvalidQuery) {
+		c.HTML(400, "error", passStandartContext(c, gin.H{"error": errors.Unwrap(err).Error()}))
+		return
+	}
 	if err != nil {
 		c.HTML(500, "error", passStandartContext(c, gin.H{"error": err.Error()}))
 		return
 	}
 
+	languages, err := r.shelf.Languages(c.Request.Context())
+	if err != nil {
+		r.logger.Error(err, "failed to list languages")
+	}
+
 	// Fetch progress for each book
 	type BookWithProgress struct {
 		entity.Book
 		Progress int
+		Editions []entity.Edition
 	}
 	booksWithProgress := make([]BookWithProgress, len(books.Books))
 	for i, book := range books.Books {
@@ -130,15 +186,21 @@ func (r *booksRoutes) listBooks(c *gin.Context) {
 		booksWithProgress[i] = BookWithProgress{
 			Book:     book,
 			Progress: int(progress.Percentage * 100),
+			Editions: books.Editions[book.ID],
 		}
 	}
 
 	c.HTML(200, "books", passStandartContext(c, gin.H{
-		"books": booksWithProgress,
-		"query": query, // 传递搜索查询到模板，以便在搜索框中显示
+		"books":     booksWithProgress,
+		"query":     query, // 传递搜索查询到模板，以便在搜索框中显示
+		"language":  filter.Language,
+		"status":    string(filter.Status),
+		"sort":      sortBy,
+		"order":     sortOrder,
+		"languages": languages,
 		"pagination": gin.H{
 			"currentPage": page,
-			"perPage":     perPage,
+			"perPage":     books.PerPage(),
 			"totalPages":  books.TotalPages(),
 			"hasNext":     books.HasNext(),
 			"hasPrev":     books.HasPrev(),
@@ -150,6 +212,22 @@ func (r *booksRoutes) listBooks(c *gin.Context) {
 	}))
 }
 
+// randomBook opens a book drawn at random from the ?lang= and ?status=
+// filters, the book list when none matches.
+func (r *booksRoutes) randomBook(c *gin.Context) {
+	filter := library.NewBookFilter(c.Query("lang")).WithStatus(c.GetString("username"), c.Query("status"))
+	books, err := r.shelf.RandomBooks(c.Request.Context(), 1, filter)
+	if err != nil {
+		c.HTML(500, "error", passStandartContext(c, gin.H{"error": err.Error()}))
+		return
+	}
+	if len(books) == 0 {
+		c.Redirect(302, "/books")
+		return
+	}
+	c.Redirect(302, "/books/"+books[0].ID)
+}
+
 func (r *booksRoutes) uploadBook(c *gin.Context) {
 	// single uploadedBookFile
 	uploadedBookFile, err := c.FormFile("book")
@@ -171,8 +249,37 @@ func (r *booksRoutes) uploadBook(c *gin.Context) {
 	defer tempFile.Close()
 	c.SaveUploadedFile(uploadedBookFile, filepath)
 
-	book, err := r.shelf.StoreBook(c.Request.Context(), tempFile, uploadedBookFile.Filename)
-	if err != nil && err != entity.ErrBookAlreadyExists {
+	// metadata sent with the file wins over the one read from it
+	var form bookMetadataForm
+	if err = c.ShouldBind(&form); err != nil {
+		c.JSON(400, passStandartContext(c, gin.H{"message": "invalid request"}))
+		return
+	}
+	metadata, err := form.toBook()
+	if err != nil {
+		c.JSON(400, passStandartContext(c, gin.H{"message": err.Error()}))
+		return
+	}
+	metadata.Genres = uploadTags(c.PostFormArray("tags"))
+
+	ctx := library.WithUploader(c.Request.Context(), c.GetString("username"))
+	ctx = library.WithUploadMetadata(ctx, metadata)
+	switch c.PostForm("visibility") {
+	case "private":
+		ctx = library.WithPrivateUpload(ctx, true)
+	case "shared":
+		ctx = library.WithPrivateUpload(ctx, false)
+	}
+	book, err := r.shelf.StoreBook(ctx, tempFile, uploadedBookFile.Filename)
+	if status := uploadLimitStatus(err); status != 0 {
+		c.HTML(status, "error", passStandartContext(c, gin.H{"error": errors.Unwrap(err).Error()}))
+		return
+	}
+	if errors.Is(err, entity.ErrBookAlreadyExists) {
+		r.uploadConflict(c, r.shelf.UploadConflict(ctx, book))
+		return
+	}
+	if err != nil {
 		r.logger.Error(err, "http - v1 - shelf - putBook")
 		c.JSON(500, passStandartContext(c, gin.H{"message": "internal server error"}))
 		return
@@ -180,21 +287,192 @@ func (r *booksRoutes) uploadBook(c *gin.Context) {
 	c.Redirect(302, "/books/"+book.ID)
 }
 
+// uploadConflict answers the upload of a file the library has: scripts
+// asking for JSON get 409 with the stored book, browsers are sent to it.
+func (r *booksRoutes) uploadConflict(c *gin.Context, conflict library.UploadConflict) {
+	if c.NegotiateFormat(gin.MIMEHTML, gin.MIMEJSON) == gin.MIMEJSON {
+		body := gin.H{"message": entity.ErrBookAlreadyExists.Error()}
+		if !conflict.Hidden {
+			body["book"] = gin.H{
+				"id":               conflict.BookID,
+				"title":            conflict.Title,
+				"author":           conflict.Author,
+				"uploaded_at":      conflict.UploadedAt,
+				"uploaded_by":      conflict.UploadedBy,
+				"metadata_differs": conflict.MetadataDiffers(),
+				"differences":      conflict.Differences,
+			}
+		}
+		c.JSON(http.StatusConflict, body)
+		return
+	}
+	if conflict.Hidden {
+		c.HTML(http.StatusConflict, "error", passStandartContext(c, gin.H{"error": "this file is already in the library"}))
+		return
+	}
+	duplicate := "file"
+	if conflict.MetadataDiffers() {
+		duplicate = strings.Join(conflict.Differences, ",")
+	}
+	c.Redirect(302, "/books/"+conflict.BookID+"?duplicate="+url.QueryEscape(duplicate))
+}
+
+// uploadDuplicate reads ?duplicate= of uploadConflict for the book page,
+// nil when the page was not reached by a duplicate upload.
+func uploadDuplicate(s string) gin.H {
+	if s == "" {
+		return nil
+	}
+	duplicate := gin.H{}
+	if s != "file" {
+		duplicate["differences"] = strings.Join(strings.Split(s, ","), ", ")
+	}
+	return duplicate
+}
+
+// uploadTags reads tags sent as several fields or comma separated.
+func uploadTags(values []string) []string {
+	var tags []string
+	for _, value := range values {
+		for _, tag := range strings.Split(value, ",") {
+			if tag = strings.TrimSpace(tag); tag != "" {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	return tags
+}
+
+// uploadLimitStatus answers uploads over the limits of the server or by
+// accounts that may not upload, 0 for other errors.
+func uploadLimitStatus(err error) int {
+	switch {
+	case errors.Is(err, entity.ErrForbidden):
+		return http.StatusForbidden
+	case errors.Is(err, entity.ErrUploadTooLarge), errors.Is(err, entity.ErrQuotaExceeded):
+		return http.StatusRequestEntityTooLarge
+	case errors.Is(err, entity.ErrFormatNotAllowed):
+		return http.StatusUnsupportedMediaType
+	case errors.Is(err, entity.ErrBookLimitReached):
+		return http.StatusForbidden
+	}
+	return 0
+}
+
 func (r *booksRoutes) downloadBook(c *gin.Context) {
 	bookID := c.Param("bookID")
-
-	book, file, err := r.shelf.DownloadBook(c.Request.Context(), bookID)
+	sent, err := serveBookFile(c, r.shelf, bookID)
+	if errors.Is(err, library.ErrDownloadRefused) {
+		c.JSON(403, passStandartContext(c, gin.H{"message": err.Error()}))
+		return
+	}
 	if err != nil {
 		c.JSON(500, passStandartContext(c, gin.H{"message": "internal server error"}))
 		return
 	}
+	if sent && !httpfile.Resumed(c.Request) {
+		download := entity.Download{BookID: bookID, Username: c.GetString("username"), Client: entity.ClientWeb}
+		if err := r.shelf.RecordDownload(c.Request.Context(), download); err != nil {
+			r.logger.Error(err, "http - web - books - downloadBook")
+		}
+	}
+}
+
+// streamBook plays an audiobook in the browser, the player seeks with
+// range requests.
+func (r *booksRoutes) streamBook(c *gin.Context) {
+	book, file, err := r.shelf.DownloadBook(c.Request.Context(), c.Param("bookID"), "")
+	if err != nil {
+		r.logger.Error(err, "http - web - books - streamBook")
+		c.JSON(404, passStandartContext(c, gin.H{"message": "book not found"}))
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Type", book.MimeType())
+	httpfile.Serve(c.Writer, c.Request, book.ETag(), book.UpdatedAt, file)
+}
+
+// comicPage serves one page image of a CBZ or CBR comic for reading in
+// the browser, pages are counted from 1.
+func (r *booksRoutes) comicPage(c *gin.Context) {
+	page, err := strconv.Atoi(c.Param("page"))
+	if err != nil {
+		c.JSON(400, passStandartContext(c, gin.H{"message": "invalid page"}))
+		return
+	}
+	_, data, err := r.shelf.ComicPage(c.Request.Context(), c.Param("bookID"), page)
+	switch {
+	case errors.Is(err, entity.ErrBookNotFound), errors.Is(err, comic.ErrPageNotFound):
+		c.JSON(404, passStandartContext(c, gin.H{"message": "page not found"}))
+		return
+	case errors.Is(err, comic.ErrUnsupportedFormat):
+		c.JSON(400, passStandartContext(c, gin.H{"message": "book is not a comic"}))
+		return
+	case errors.Is(err, library.ErrDownloadRefused):
+		c.JSON(403, passStandartContext(c, gin.H{"message": err.Error()}))
+		return
+	case err != nil:
+		r.logger.Error(err, "http - web - books - comicPage")
+		c.JSON(500, passStandartContext(c, gin.H{"message": "failed to read page"}))
+		return
+	}
+
+	// the stored file never changes, neither do its pages
+	c.Header("Cache-Control", "private, max-age=86400")
+	c.Data(200, http.DetectContentType(data), data)
+}
+
+// serveBookFile sends the book file of the ?format= preferred, or redirects
+// to it on the CDN, revalidation is answered before the file is read from
+// storage. It reports whether the file was sent.
+func serveBookFile(c *gin.Context, shelf library.Shelf, bookID string) (bool, error) {
+	format := c.Query("format")
+	link, err := shelf.BookFileURL(c.Request.Context(), bookID, format)
+	if err != nil {
+		return false, err
+	}
+	if link != "" {
+		c.Redirect(302, link)
+		return true, nil
+	}
+
+	if book, err := shelf.BookFormat(c.Request.Context(), bookID, format); err == nil && httpfile.NotModified(c.Request, book.ETag(), book.UpdatedAt) {
+		httpfile.WriteNotModified(c.Writer, book.ETag(), book.UpdatedAt)
+		return false, nil
+	}
+
+	book, file, err := shelf.DownloadBook(c.Request.Context(), bookID, format)
+	if err != nil {
+		return false, err
+	}
 	defer file.Close()
 
 	c.Header("Content-Disposition", "attachment; filename="+book.Filename())
 	c.Header("Content-Type", "application/octet-stream")
-	c.File(file.Name())
+	httpfile.Serve(c.Writer, c.Request, book.ETag(), book.UpdatedAt, file)
+	return true, nil
 }
 
+// listDownloads shows what the account and its devices downloaded, with
+// ?unopened=1 only the books never opened in KOReader.
+func (r *booksRoutes) listDownloads(c *gin.Context) {
+	unopened := c.Query("unopened") != ""
+	downloads, err := r.shelf.Downloads(c.Request.Context(), c.GetString("username"), unopened)
+	if err != nil {
+		c.HTML(500, "error", passStandartContext(c, gin.H{"error": err.Error()}))
+		return
+	}
+
+	c.HTML(200, "downloads", passStandartContext(c, gin.H{
+		"downloads": downloads,
+		"unopened":  unopened,
+	}))
+}
+
+// similarBooks is how many similar books the book page suggests.
+const similarBooks = 6
+
 func (r *booksRoutes) viewBook(c *gin.Context) {
 	bookID := c.Param("bookID")
 
@@ -210,10 +488,117 @@ func (r *booksRoutes) viewBook(c *gin.Context) {
 		bookStats = &stats.BookStats{} // Use empty stats in case of error
 	}
 
+	readingStatus, err := r.shelf.ReadingStatus(c.Request.Context(), c.GetString("username"), book.ID)
+	if err != nil {
+		r.logger.Error(err, "failed to get reading status")
+	}
+
+	reviews, err := r.shelf.Reviews(c.Request.Context(), book.ID)
+	if err != nil {
+		r.logger.Error(err, "failed to list reviews")
+	}
+	var myReview entity.Review
+	for _, review := range reviews {
+		if review.Username == c.GetString("username") {
+			myReview = review
+		}
+	}
+
+	link, expires := r.links.Sign(downloadPath(book.ID))
+	downloadLink := gin.H{"url": link, "expires": expires, "qr": qrDataURL(absoluteURL(c.Request, link))}
+	resume := r.resumeLinks(c, book, link)
+
+	var chapters []gin.H
+	if book.IsAudiobook() {
+		list, err := r.shelf.Chapters(c.Request.Context(), book.ID)
+		if err != nil {
+			r.logger.Error(err, "failed to list chapters")
+		}
+		for _, chapter := range list {
+			chapters = append(chapters, gin.H{"title": chapter.Title, "start": int(chapter.Start.Seconds())})
+		}
+	}
+
+	sameCover, err := r.shelf.SameCoverBooks(c.Request.Context(), book.ID)
+	if err != nil {
+		r.logger.Error(err, "failed to list books with the same cover")
+	}
+
+	similar, err := r.shelf.SimilarBooks(c.Request.Context(), book.ID, similarBooks)
+	if err != nil {
+		r.logger.Error(err, "failed to list similar books")
+	}
+
+	formats, err := r.shelf.BookFiles(c.Request.Context(), book.ID)
+	if err != nil {
+		r.logger.Error(err, "failed to list book files")
+	}
+
+	editions, err := r.shelf.Editions(c.Request.Context(), book.ID)
+	if err != nil {
+		r.logger.Error(err, "failed to list editions")
+	}
+
+	sharing, _ := c.MustGet("sharing").(settings.Sharing)
+
+	// share links only work while sharing is on
+	var shareLinks []entity.ShareLink
+	var newShareLink string
+	features, _ := c.Value("features").(settings.Features)
+	shareEnabled := features.Enabled(settings.FeatureSharing)
+	if shareEnabled {
+		shareLinks, err = r.shelf.ShareLinks(c.Request.Context(), c.GetString("username"), book.ID)
+		if err != nil {
+			r.logger.Error(err, "failed to list share links")
+		}
+		if token := c.Query("shared"); token != "" {
+			newShareLink = absoluteURL(c.Request, sharePath(token))
+		}
+	}
+
+	// loans of the book the account lent or borrowed
+	var loans []entity.Loan
+	if book.Private {
+		all, err := r.shelf.Loans(c.Request.Context(), c.GetString("username"))
+		if err != nil {
+			r.logger.Error(err, "failed to list loans")
+		}
+		for _, loan := range all {
+			if loan.BookID == book.ID {
+				loans = append(loans, loan)
+			}
+		}
+	}
+
 	c.HTML(200, "book", passStandartContext(c, gin.H{
 		"book":          book,
+		"jsonld":        bookJSONLD(c, book, sharing.PublicPages),
 		"stats":         bookStats,
+		"readingStatus": readingStatus,
+		"reviews":       reviews,
+		"myReview":      myReview,
 		"metadataError": c.Query("metadata_error"),
+		"sendError":     c.Query("send_error"),
+		"fileError":     c.Query("file_error"),
+		"editionError":  c.Query("edition_error"),
+		"duplicate":     uploadDuplicate(c.Query("duplicate")),
+		"shareError":    c.Query("share_error"),
+		"loanError":     c.Query("loan_error"),
+		"loans":         loans,
+		"username":      c.GetString("username"),
+		"sentTo":        c.Query("sent_to"),
+		"downloadLink":  downloadLink,
+		"resume":        resume,
+		"shareEnabled":  shareEnabled,
+		"shareLinks":    shareLinks,
+		"newShareLink":  newShareLink,
+		"duration":      int(book.Duration.Seconds()),
+		"chapters":      chapters,
+		"sameCover":     sameCover,
+		"similar":       similar,
+		"formats":       formats,
+		"editions":      editions,
+		"comic":         metadata.IsComic(strings.ToLower(book.Extension())),
 	}))
 }
 
@@ -237,6 +622,18 @@ func (r *booksRoutes) updateBookMetadata(c *gin.Context) {
 	}
 
 	book, err := r.shelf.UpdateBookMetadata(c.Request.Context(), bookID, metadata)
+	if errors.Is(err, entity.ErrBookArchived) {
+		c.JSON(409, passStandartContext(c, gin.H{"message": "book is archived"}))
+		return
+	}
+	if errors.Is(err, library.ErrInvalidISBN) {
+		c.JSON(400, passStandartContext(c, gin.H{"message": library.ErrInvalidISBN.Error()}))
+		return
+	}
+	if errors.Is(err, entity.ErrForbidden) {
+		c.JSON(403, passStandartContext(c, gin.H{"message": entity.ErrForbidden.Error()}))
+		return
+	}
 	if err != nil {
 		r.logger.Error(err, "http - v1 - shelf - updateBookMetadata")
 		// TODO: move to template
@@ -275,6 +672,268 @@ func (r *booksRoutes) enrichBookMetadata(c *gin.Context) {
 	c.Redirect(302, "/books/"+bookID)
 }
 
+func (r *booksRoutes) sendToDevice(c *gin.Context) {
+	bookID := c.Param("bookID")
+	email := strings.TrimSpace(c.PostForm("email"))
+
+	err := r.shelf.SendToDevice(c.Request.Context(), bookID, email)
+	if err != nil {
+		r.logger.Error(err, "http - web - books - sendToDevice")
+		message := "failed to send book"
+		switch {
+		case errors.Is(err, library.ErrDeliveryNotConfigured):
+			message = "SMTP is not configured"
+		case errors.Is(err, library.ErrInvalidEmail):
+			message = "invalid email"
+		case errors.Is(err, library.ErrUnsupportedFormat):
+			message = "format is not supported by device, install calibre to convert it"
+		}
+		c.Redirect(303, "/books/"+bookID+"?send_error="+url.QueryEscape(message))
+		return
+	}
+
+	c.Redirect(303, "/books/"+bookID+"?sent_to="+url.QueryEscape(email))
+}
+
+// createShareLink creates a link to download the book without an account,
+// valid for the days of the form and as many downloads as it allows when
+// set. The book page shows the link once.
+func (r *booksRoutes) createShareLink(c *gin.Context) {
+	bookID := c.Param("bookID")
+	days, err := strconv.Atoi(c.DefaultPostForm("days", "7"))
+	if err != nil {
+		c.Redirect(303, "/books/"+bookID+"?share_error="+url.QueryEscape("days must be a number"))
+		return
+	}
+	downloads := 0
+	if value := strings.TrimSpace(c.PostForm("downloads")); value != "" {
+		if downloads, err = strconv.Atoi(value); err != nil {
+			c.Redirect(303, "/books/"+bookID+"?share_error="+url.QueryEscape("downloads must be a number"))
+			return
+		}
+	}
+
+	_, token, err := r.shelf.CreateShareLink(c.Request.Context(), c.GetString("username"), bookID, time.Duration(days)*24*time.Hour, downloads)
+	if err != nil {
+		message := "failed to create share link"
+		switch {
+		case errors.Is(err, entity.ErrInvalidShareLink):
+			message = entity.ErrInvalidShareLink.Error()
+		case errors.Is(err, entity.ErrBookNotFound):
+			message = "book not found"
+		default:
+			r.logger.Error(err, "http - web - books - createShareLink")
+		}
+		c.Redirect(303, "/books/"+bookID+"?share_error="+url.QueryEscape(message))
+		return
+	}
+	c.Redirect(303, "/books/"+bookID+"?shared="+url.QueryEscape(token))
+}
+
+func (r *booksRoutes) revokeShareLink(c *gin.Context) {
+	bookID := c.Param("bookID")
+	err := r.shelf.RevokeShareLink(c.Request.Context(), c.GetString("username"), c.Param("linkID"))
+	if err != nil && !errors.Is(err, entity.ErrShareLinkNotFound) {
+		r.logger.Error(err, "http - web - books - revokeShareLink")
+		c.Redirect(303, "/books/"+bookID+"?share_error="+url.QueryEscape("failed to revoke share link"))
+		return
+	}
+	c.Redirect(303, "/books/"+bookID)
+}
+
+// lendBook lends the private book to the account of the form for its
+// days.
+func (r *booksRoutes) lendBook(c *gin.Context) {
+	bookID := c.Param("bookID")
+	days, err := strconv.Atoi(c.DefaultPostForm("days", "14"))
+	if err != nil {
+		c.Redirect(303, "/books/"+bookID+"?loan_error="+url.QueryEscape("days must be a number"))
+		return
+	}
+
+	_, err = r.shelf.LendBook(c.Request.Context(), c.GetString("username"), bookID, strings.TrimSpace(c.PostForm("borrower")), days)
+	if err != nil {
+		message := "failed to lend the book"
+		switch {
+		case errors.Is(err, entity.ErrInvalidLoan):
+			message = entity.ErrInvalidLoan.Error()
+		case errors.Is(err, entity.ErrLoanShared):
+			message = entity.ErrLoanShared.Error()
+		case errors.Is(err, entity.ErrForbidden):
+			message = "only the uploader lends a book"
+		case errors.Is(err, entity.ErrBookNotFound):
+			message = "book not found"
+		default:
+			r.logger.Error(err, "http - web - books - lendBook")
+		}
+		c.Redirect(303, "/books/"+bookID+"?loan_error="+url.QueryEscape(message))
+		return
+	}
+	c.Redirect(303, "/books/"+bookID)
+}
+
+// endLoan hands the book back, the borrower does not see it afterwards
+// and goes back to the list.
+func (r *booksRoutes) endLoan(c *gin.Context) {
+	bookID := c.Param("bookID")
+	err := r.shelf.EndLoan(c.Request.Context(), c.GetString("username"), c.Param("loanID"))
+	if err != nil && !errors.Is(err, entity.ErrLoanNotFound) {
+		r.logger.Error(err, "http - web - books - endLoan")
+		c.Redirect(303, "/books/"+bookID+"?loan_error="+url.QueryEscape("failed to end the loan"))
+		return
+	}
+	if _, err = r.shelf.ViewBook(c.Request.Context(), bookID); err != nil {
+		c.Redirect(303, "/books")
+		return
+	}
+	c.Redirect(303, "/books/"+bookID)
+}
+
+// linkEdition adds the book to the work of another book, given by its id
+// or the link to its page.
+func (r *booksRoutes) linkEdition(c *gin.Context) {
+	bookID := c.Param("bookID")
+	otherID := path.Base(strings.TrimRight(strings.TrimSpace(c.PostForm("book")), "/"))
+
+	err := r.shelf.LinkEdition(c.Request.Context(), bookID, otherID, c.PostForm("relation"))
+	if err != nil {
+		message := "failed to link edition"
+		switch {
+		case errors.Is(err, entity.ErrBookNotFound):
+			message = "book not found"
+		case errors.Is(err, entity.ErrSameEdition):
+			message = "a book can not be linked to itself"
+		case errors.Is(err, entity.ErrInvalidEditionRelation):
+			message = "relation must be edition, translation or format"
+		case errors.Is(err, entity.ErrForbidden):
+			message = entity.ErrForbidden.Error()
+		default:
+			r.logger.Error(err, "http - web - books - linkEdition")
+		}
+		c.Redirect(303, "/books/"+bookID+"?edition_error="+url.QueryEscape(message))
+		return
+	}
+	c.Redirect(303, "/books/"+bookID)
+}
+
+func (r *booksRoutes) unlinkEdition(c *gin.Context) {
+	bookID := c.Param("bookID")
+	if err := r.shelf.UnlinkEdition(c.Request.Context(), bookID); err != nil {
+		r.logger.Error(err, "http - web - books - unlinkEdition")
+		c.Redirect(303, "/books/"+bookID+"?edition_error="+url.QueryEscape("failed to unlink edition"))
+		return
+	}
+	c.Redirect(303, "/books/"+bookID)
+}
+
+// addBookFile stores another format of the book, like a PDF next to the EPUB.
+func (r *booksRoutes) addBookFile(c *gin.Context) {
+	bookID := c.Param("bookID")
+
+	uploadedFile, err := c.FormFile("file")
+	if err != nil {
+		c.Redirect(303, "/books/"+bookID+"?file_error="+url.QueryEscape("book file is required"))
+		return
+	}
+	tempFile, err := os.CreateTemp("", "")
+	if err != nil {
+		r.logger.Error(err, "http - web - books - addBookFile")
+		c.JSON(500, passStandartContext(c, gin.H{"message": "internal server error"}))
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	if err = c.SaveUploadedFile(uploadedFile, tempFile.Name()); err != nil {
+		r.logger.Error(err, "http - web - books - addBookFile")
+		c.JSON(500, passStandartContext(c, gin.H{"message": "internal server error"}))
+		return
+	}
+
+	_, err = r.shelf.AddBookFile(c.Request.Context(), bookID, tempFile)
+	if err != nil {
+		message := "failed to add file"
+		switch {
+		case errors.Is(err, entity.ErrBookAlreadyExists):
+			message = "this file is already in the library"
+		case errors.Is(err, library.ErrFormatExists):
+			message = "the book already has a file of this format"
+		case errors.Is(err, entity.ErrBookArchived):
+			message = "book is archived"
+		case uploadLimitStatus(err) != 0:
+			message = errors.Unwrap(err).Error()
+		default:
+			r.logger.Error(err, "http - web - books - addBookFile")
+		}
+		c.Redirect(303, "/books/"+bookID+"?file_error="+url.QueryEscape(message))
+		return
+	}
+
+	c.Redirect(303, "/books/"+bookID)
+}
+
+func (r *booksRoutes) deleteBookFile(c *gin.Context) {
+	err := r.shelf.DeleteBookFile(c.Request.Context(), c.Param("bookID"), c.Param("format"))
+	switch {
+	case errors.Is(err, entity.ErrBookNotFound):
+		c.JSON(404, passStandartContext(c, gin.H{"message": "file not found"}))
+	case errors.Is(err, library.ErrPrimaryFile):
+		c.JSON(409, passStandartContext(c, gin.H{"message": "the file the book was uploaded as can not be removed"}))
+	case errors.Is(err, entity.ErrBookArchived):
+		c.JSON(409, passStandartContext(c, gin.H{"message": "book is archived"}))
+	case errors.Is(err, entity.ErrForbidden):
+		c.JSON(403, passStandartContext(c, gin.H{"message": entity.ErrForbidden.Error()}))
+	case err != nil:
+		r.logger.Error(err, "http - web - books - deleteBookFile")
+		c.JSON(500, passStandartContext(c, gin.H{"message": "internal server error"}))
+	default:
+		c.JSON(200, passStandartContext(c, gin.H{"message": "file removed"}))
+	}
+}
+
+func (r *booksRoutes) setReadingStatus(c *gin.Context) {
+	bookID := c.Param("bookID")
+
+	status, err := entity.ParseReadingStatus(c.PostForm("status"))
+	if err != nil {
+		c.JSON(400, passStandartContext(c, gin.H{"message": "invalid status"}))
+		return
+	}
+	_, err = r.shelf.SetReadingStatus(c.Request.Context(), c.GetString("username"), bookID, status)
+	if err != nil {
+		r.logger.Error(err, "http - web - books - setReadingStatus")
+		c.JSON(500, passStandartContext(c, gin.H{"message": "internal server error"}))
+		return
+	}
+
+	c.Redirect(303, "/books/"+bookID)
+}
+
+func (r *booksRoutes) saveReview(c *gin.Context) {
+	bookID := c.Param("bookID")
+
+	rating := 0
+	if ratingStr := c.PostForm("rating"); ratingStr != "" {
+		parsed, err := strconv.Atoi(ratingStr)
+		if err != nil {
+			c.JSON(400, passStandartContext(c, gin.H{"message": "invalid rating"}))
+			return
+		}
+		rating = parsed
+	}
+	_, err := r.shelf.SaveReview(c.Request.Context(), c.GetString("username"), bookID, rating, c.PostForm("review"))
+	if errors.Is(err, entity.ErrInvalidRating) {
+		c.JSON(400, passStandartContext(c, gin.H{"message": "invalid rating"}))
+		return
+	}
+	if err != nil {
+		r.logger.Error(err, "http - web - books - saveReview")
+		c.JSON(500, passStandartContext(c, gin.H{"message": "internal server error"}))
+		return
+	}
+
+	c.Redirect(303, "/books/"+bookID)
+}
+
 func (r *booksRoutes) viewBookCover(c *gin.Context) {
 	bookID := c.Param("bookID")
 
@@ -284,7 +943,19 @@ func (r *booksRoutes) viewBookCover(c *gin.Context) {
 		return
 	}
 
-	cover, err := r.shelf.ViewCover(c.Request.Context(), bookID)
+	opts, err := thumbnail.ParseOptions(c.Query("w"), c.Query("h"), c.Query("fit"))
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
+	}
+	opts.Format = r.shelf.CoverFormat(c.GetHeader("Accept"))
+	if opts.IsOriginal() {
+		if link, err := r.shelf.CoverURL(c.Request.Context(), bookID); err == nil && link != "" {
+			c.Redirect(302, link)
+			return
+		}
+	}
+	cover, err := r.shelf.ViewCoverSized(c.Request.Context(), bookID, opts)
 
 	if err != nil {
 		width := 600
@@ -307,7 +978,21 @@ func (r *booksRoutes) viewBookCover(c *gin.Context) {
 		c.Data(200, "image/svg+xml", []byte(svgContent))
 		return
 	}
-	c.File(cover.Name())
+	defer cover.Close()
+	modTime := time.Time{}
+	if info, err := cover.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+	// ServeContent sniffs no AVIF
+	head := make([]byte, 512)
+	n, _ := cover.Read(head)
+	if _, err := cover.Seek(0, io.SeekStart); err != nil {
+		c.JSON(500, gin.H{"message": err.Error()})
+		return
+	}
+	c.Header("Content-Type", thumbnail.DetectContentType(head[:n]))
+	c.Header("Vary", "Accept")
+	http.ServeContent(c.Writer, c.Request, "", modTime, cover)
 }
 
 func (r *booksRoutes) uploadBookCover(c *gin.Context) {
@@ -336,6 +1021,14 @@ func (r *booksRoutes) uploadBookCover(c *gin.Context) {
 	}
 
 	_, err = r.shelf.UpdateCover(c.Request.Context(), bookID, tempFile)
+	if errors.Is(err, entity.ErrBookArchived) {
+		c.JSON(409, gin.H{"message": "book is archived"})
+		return
+	}
+	if errors.Is(err, entity.ErrForbidden) {
+		c.JSON(403, gin.H{"message": entity.ErrForbidden.Error()})
+		return
+	}
 	if err != nil {
 		r.logger.Error(err, "http - web - books - uploadBookCover - UpdateCover")
 		c.JSON(500, gin.H{"message": "internal server error"})
@@ -349,6 +1042,14 @@ func (r *booksRoutes) deleteBook(c *gin.Context) {
 	bookID := c.Param("bookID")
 
 	err := r.shelf.DeleteBook(c.Request.Context(), bookID)
+	if errors.Is(err, entity.ErrBookArchived) {
+		c.JSON(409, passStandartContext(c, gin.H{"message": "book is archived"}))
+		return
+	}
+	if errors.Is(err, entity.ErrForbidden) {
+		c.JSON(403, passStandartContext(c, gin.H{"message": entity.ErrForbidden.Error()}))
+		return
+	}
 	if err != nil {
 		r.logger.Error(err, "http - v1 - shelf - deleteBook")
 		c.JSON(500, passStandartContext(c, gin.H{"message": "internal server error"}))
@@ -357,3 +1058,76 @@ func (r *booksRoutes) deleteBook(c *gin.Context) {
 
 	c.Redirect(302, "/books")
 }
+
+func (r *booksRoutes) archiveBook(c *gin.Context) {
+	bookID := c.Param("bookID")
+
+	_, err := r.shelf.ArchiveBook(c.Request.Context(), bookID)
+	if errors.Is(err, entity.ErrForbidden) {
+		c.JSON(403, passStandartContext(c, gin.H{"message": entity.ErrForbidden.Error()}))
+		return
+	}
+	if err != nil {
+		r.logger.Error(err, "http - web - books - archiveBook")
+		c.JSON(500, passStandartContext(c, gin.H{"message": "internal server error"}))
+		return
+	}
+
+	c.Redirect(303, "/books/"+bookID)
+}
+
+// setVisibleFrom schedules the book for the local time of the form, an
+// empty one shows it right away.
+func (r *booksRoutes) setVisibleFrom(c *gin.Context) {
+	bookID := c.Param("bookID")
+
+	var from time.Time
+	if value := strings.TrimSpace(c.PostForm("visible_from")); value != "" {
+		var err error
+		if from, err = time.ParseInLocation("2006-01-02T15:04", value, time.Local); err != nil {
+			c.JSON(400, passStandartContext(c, gin.H{"message": "visible from must be a date and time"}))
+			return
+		}
+	}
+	_, err := r.shelf.SetBookVisibleFrom(c.Request.Context(), bookID, from)
+	if errors.Is(err, entity.ErrForbidden) {
+		c.JSON(403, passStandartContext(c, gin.H{"message": entity.ErrForbidden.Error()}))
+		return
+	}
+	if err != nil {
+		r.logger.Error(err, "http - web - books - setVisibleFrom")
+		c.JSON(500, passStandartContext(c, gin.H{"message": "internal server error"}))
+		return
+	}
+
+	c.Redirect(303, "/books/"+bookID)
+}
+
+func (r *booksRoutes) exportAnnotations(c *gin.Context) {
+	bookID := c.Param("bookID")
+
+	book, err := r.shelf.ViewBook(c.Request.Context(), bookID)
+	if err != nil {
+		c.JSON(404, passStandartContext(c, gin.H{"message": "book not found"}))
+		return
+	}
+
+	format := c.DefaultQuery("format", annotations.FormatMarkdown)
+	data, err := r.annotations.Export(c.Request.Context(), book, format)
+	if errors.Is(err, annotations.ErrUnknownFormat) {
+		c.JSON(400, passStandartContext(c, gin.H{"message": "unknown format"}))
+		return
+	}
+	if err != nil {
+		r.logger.Error(err, "http - web - books - exportAnnotations")
+		c.JSON(500, passStandartContext(c, gin.H{"message": "internal server error"}))
+		return
+	}
+
+	contentType, extension := "text/markdown; charset=utf-8", "md"
+	if format == annotations.FormatJSON {
+		contentType, extension = "application/json", "json"
+	}
+	c.Header("Content-Disposition", "attachment; filename="+book.ID+"-annotations."+extension)
+	c.Data(200, contentType, data)
+}
@@ -0,0 +1,144 @@
+package web
+
+import (
+	"archive/zip"
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/pkg/thumbnail"
+)
+
+// maxBundleCovers is the largest page of the book list.
+const maxBundleCovers = 100
+
+var coverExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+	"image/avif": ".avif",
+}
+
+// coverBundle sends the covers of ?ids=a,b,c in one response, so a page of
+// the book list is one request instead of one per book. Covers are scaled
+// like /covers/:id. The bundle is multipart/form-data with a part per book
+// named by its id, or a zip with ?format=zip. Books without a cover are left out.
+func (r *booksRoutes) coverBundle(c *gin.Context) {
+	ids := bundleIDs(c.Query("ids"))
+	if len(ids) == 0 || len(ids) > maxBundleCovers {
+		c.JSON(400, gin.H{"message": fmt.Sprintf("ids must list 1 to %d books", maxBundleCovers)})
+		return
+	}
+	opts, err := thumbnail.ParseOptions(c.Query("w"), c.Query("h"), c.Query("fit"))
+	if err != nil {
+		c.JSON(400, gin.H{"message": err.Error()})
+		return
+	}
+	opts.Format = r.shelf.CoverFormat(c.GetHeader("Accept"))
+
+	var bundle coverWriter
+	if c.Query("format") == "zip" {
+		c.Header("Content-Type", "application/zip")
+		c.Header("Content-Disposition", "attachment; filename=covers.zip")
+		bundle = zipCovers{zip.NewWriter(c.Writer)}
+	} else {
+		mw := multipart.NewWriter(c.Writer)
+		c.Header("Content-Type", mw.FormDataContentType())
+		bundle = multipartCovers{mw}
+	}
+	c.Header("Cache-Control", "private, max-age=3600")
+	c.Header("Vary", "Accept")
+	c.Status(http.StatusOK)
+
+	for _, id := range ids {
+		if err := r.writeCover(c, bundle, id, opts); err != nil {
+			// the response has started, a broken cover only drops that book
+			r.logger.Error(err, "http - web - books - coverBundle")
+		}
+	}
+	if err := bundle.Close(); err != nil {
+		r.logger.Error(err, "http - web - books - coverBundle - Close")
+	}
+}
+
+func (r *booksRoutes) writeCover(c *gin.Context, bundle coverWriter, id string, opts thumbnail.Options) error {
+	file, err := r.shelf.ViewCoverSized(c.Request.Context(), id, opts)
+	if errors.Is(err, entity.ErrBookNotFound) || errors.Is(err, library.ErrNoCover) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	modTime := time.Now()
+	if info, err := file.Stat(); err == nil {
+		modTime = info.ModTime()
+	}
+	// sniff the type, originals are stored in whatever format they came in
+	br := bufio.NewReaderSize(file, 512)
+	head, _ := br.Peek(512)
+	contentType := thumbnail.DetectContentType(head)
+	return bundle.add(id+coverExtensions[contentType], id, contentType, modTime, br)
+}
+
+// bundleIDs splits the comma separated ids, dropping blanks and repeats.
+func bundleIDs(s string) []string {
+	seen := make(map[string]bool)
+	ids := make([]string, 0)
+	for _, id := range strings.Split(s, ",") {
+		id = strings.TrimSpace(id)
+		if id == "" || seen[id] {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+type coverWriter interface {
+	add(filename, id, contentType string, modTime time.Time, r io.Reader) error
+	Close() error
+}
+
+type multipartCovers struct {
+	*multipart.Writer
+}
+
+func (m multipartCovers) add(filename, id, contentType string, _ time.Time, r io.Reader) error {
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`, id, filename))
+	h.Set("Content-Type", contentType)
+	part, err := m.CreatePart(h)
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(part, r)
+	return err
+}
+
+type zipCovers struct {
+	*zip.Writer
+}
+
+func (z zipCovers) add(filename, _, _ string, modTime time.Time, r io.Reader) error {
+	// images are compressed already
+	w, err := z.CreateHeader(&zip.FileHeader{Name: filename, Method: zip.Store, Modified: modTime})
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(w, r)
+	return err
+}
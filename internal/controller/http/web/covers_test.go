@@ -0,0 +1,39 @@
+package web
+
+import (
+	"bytes"
+	"io"
+	"mime/multipart"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestBundleIDs(t *testing.T) {
+	got := bundleIDs(" a,b,,a , c")
+	if want := []string{"a", "b", "c"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, got %v", want, got)
+	}
+}
+
+func TestMultipartCovers(t *testing.T) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	bundle := multipartCovers{mw}
+	if err := bundle.add("b1.png", "b1", "image/png", time.Now(), strings.NewReader("png")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := bundle.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	part, err := multipart.NewReader(&buf, mw.Boundary()).NextPart()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	body, _ := io.ReadAll(part)
+	if part.FormName() != "b1" || part.FileName() != "b1.png" || part.Header.Get("Content-Type") != "image/png" || string(body) != "png" {
+		t.Errorf("unexpected part %v %q", part.Header, body)
+	}
+}
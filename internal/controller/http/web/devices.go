@@ -1,22 +1,43 @@
 package web
 
 import (
+	"errors"
+	"net/url"
+	"strconv"
+	"strings"
+
 	"github.com/gin-gonic/gin"
 	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/sync"
 	"github.com/banjuer/kompanion/pkg/logger"
 )
 
 type deviceRoutes struct {
-	auth auth.AuthInterface
-	l    logger.Interface
+	auth     auth.AuthInterface
+	shelf    library.Shelf
+	progress sync.Progress
+	l        logger.Interface
 }
 
-func newDeviceRoutes(handler *gin.RouterGroup, a auth.AuthInterface, l logger.Interface) {
-	r := &deviceRoutes{a, l}
+func newDeviceRoutes(handler *gin.RouterGroup, a auth.AuthInterface, shelf library.Shelf, p sync.Progress, l logger.Interface) {
+	r := &deviceRoutes{a, shelf, p, l}
 
 	handler.GET("/", r.listDevices)
 	handler.POST("/add", r.addDeviceAction)
 	handler.POST("/deactivate/:device_name", r.deactivateDeviceAction)
+	handler.POST("/language/:device_name", r.setDeviceLanguageAction)
+	handler.POST("/reads/:device_name", r.setDeviceFormatsAction)
+	handler.GET("/formats", r.viewFormats)
+	handler.POST("/formats/convert", r.convertFormatsAction)
+	handler.POST("/conflicts/:id", r.resolveConflictAction)
+	handler.POST("/merge", r.mergeDevicesAction)
+	handler.POST("/delete/:device_name", r.deleteDeviceAction)
+	handler.POST("/keys", r.createKeyAction)
+	handler.POST("/keys/revoke/:id", r.revokeKeyAction)
+	handler.POST("/users", r.registerUserAction)
+	handler.POST("/users/role/:username", r.setUserRoleAction)
 }
 
 func (r *deviceRoutes) listDevices(c *gin.Context) {
@@ -29,10 +50,88 @@ func (r *deviceRoutes) listDevices(c *gin.Context) {
 	}
 
 	c.HTML(200, "devices", passStandartContext(c, gin.H{
-		"devices": devices,
+		"devices":   devices,
+		"keys":      r.listKeys(c),
+		"users":     r.listUsers(c),
+		"conflicts": r.listConflicts(c),
+		"ssoLinked": c.Query("sso_linked") != "",
+		"error":     c.Query("sso_error"),
 	}))
 }
 
+// listConflicts lists the progress conflicts of the keep-both sync
+// strategy.
+func (r *deviceRoutes) listConflicts(c *gin.Context) []entity.ProgressConflict {
+	conflicts, err := r.progress.Conflicts(c.Request.Context())
+	if err != nil {
+		r.l.Error(err, "http - web - devices - listConflicts")
+	}
+	return conflicts
+}
+
+// resolveConflictAction keeps the position of the keep form field, ahead
+// or behind.
+func (r *deviceRoutes) resolveConflictAction(c *gin.Context) {
+	id, err := strconv.ParseInt(c.Param("id"), 10, 64)
+	if err == nil {
+		_, err = r.progress.ResolveConflict(c.Request.Context(), id, c.PostForm("keep") == "behind")
+	}
+	if err != nil && !errors.Is(err, entity.ErrProgressConflictNotFound) {
+		r.l.Error(err, "http - web - devices - resolveConflictAction")
+		devices, _ := r.auth.ListDevices(c.Request.Context())
+		c.HTML(500, "devices", passStandartContext(c, gin.H{
+			"devices": devices,
+			"error":   "Failed to resolve the conflict",
+		}))
+		return
+	}
+	c.Redirect(302, "/devices")
+}
+
+// listUsers lists the accounts with their roles to admins, none to the
+// other roles.
+func (r *deviceRoutes) listUsers(c *gin.Context) []auth.User {
+	users, err := r.auth.ListUsers(c.Request.Context())
+	if err != nil && !errors.Is(err, entity.ErrForbidden) {
+		r.l.Error(err, "http - web - devices - listUsers")
+	}
+	return users
+}
+
+func (r *deviceRoutes) registerUserAction(c *gin.Context) {
+	err := r.auth.RegisterUser(c.Request.Context(), c.PostForm("username"), c.PostForm("password"))
+	r.userActionDone(c, err)
+}
+
+func (r *deviceRoutes) setUserRoleAction(c *gin.Context) {
+	err := r.auth.SetUserRole(c.Request.Context(), c.Param("username"), c.PostForm("role"))
+	r.userActionDone(c, err)
+}
+
+func (r *deviceRoutes) userActionDone(c *gin.Context, err error) {
+	if err != nil {
+		devices, _ := r.auth.ListDevices(c.Request.Context())
+		c.HTML(400, "devices", passStandartContext(c, gin.H{
+			"devices": devices,
+			"keys":    r.listKeys(c),
+			"users":   r.listUsers(c),
+			"error":   err.Error(),
+		}))
+		return
+	}
+	c.Redirect(302, "/devices")
+}
+
+// listKeys lists the API keys of the signed in user, none when keys are
+// not configured.
+func (r *deviceRoutes) listKeys(c *gin.Context) []auth.APIKey {
+	keys, err := r.auth.ListAPIKeys(c.Request.Context(), c.GetString("username"))
+	if err != nil && !errors.Is(err, auth.APIKeysNotConfigured) {
+		r.l.Error(err, "http - web - devices - listKeys")
+	}
+	return keys
+}
+
 func (r *deviceRoutes) addDeviceAction(c *gin.Context) {
 	deviceName := c.PostForm("device_name")
 	password := c.PostForm("password")
@@ -67,3 +166,177 @@ func (r *deviceRoutes) deactivateDeviceAction(c *gin.Context) {
 
 	c.Redirect(302, "/devices")
 }
+
+// setDeviceLanguageAction sets the language of the OPDS feeds of a device,
+// an empty language follows the reader again.
+func (r *deviceRoutes) setDeviceLanguageAction(c *gin.Context) {
+	deviceName := c.Param("device_name")
+	err := r.auth.SetDeviceLanguage(c.Request.Context(), deviceName, c.PostForm("language"))
+	if err != nil {
+		devices, _ := r.auth.ListDevices(c.Request.Context())
+		c.HTML(400, "devices", passStandartContext(c, gin.H{
+			"devices": devices,
+			"error":   err.Error(),
+		}))
+		return
+	}
+
+	c.Redirect(302, "/devices")
+}
+
+// setDeviceFormatsAction sets the comma separated formats a device reads
+// well, none use the defaults of the format advisor.
+func (r *deviceRoutes) setDeviceFormatsAction(c *gin.Context) {
+	err := r.auth.SetDeviceFormats(c.Request.Context(), c.Param("device_name"), strings.Split(c.PostForm("formats"), ","))
+	if err != nil {
+		devices, _ := r.auth.ListDevices(c.Request.Context())
+		c.HTML(400, "devices", passStandartContext(c, gin.H{
+			"devices": devices,
+			"error":   err.Error(),
+		}))
+		return
+	}
+
+	c.Redirect(302, "/devices")
+}
+
+// formatReport advises conversions for the registered devices.
+func (r *deviceRoutes) formatReport(c *gin.Context) (library.FormatReport, error) {
+	devices, err := r.auth.ListDevices(c.Request.Context())
+	if err != nil {
+		return library.FormatReport{}, err
+	}
+	formats := make(map[string][]string, len(devices))
+	for _, device := range devices {
+		formats[device.Name] = device.Formats
+	}
+	return r.shelf.FormatReport(c.Request.Context(), formats)
+}
+
+// viewFormats shows the formats of the library and the books the devices
+// can not read well.
+func (r *deviceRoutes) viewFormats(c *gin.Context) {
+	report, err := r.formatReport(c)
+	if err != nil {
+		r.l.Error(err, "http - web - devices - viewFormats")
+		c.HTML(500, "formats", passStandartContext(c, gin.H{"error": "Failed to check the formats of the library"}))
+		return
+	}
+	convertible := 0
+	for _, advice := range report.Books {
+		if advice.Target != "" {
+			convertible++
+		}
+	}
+	c.HTML(200, "formats", passStandartContext(c, gin.H{
+		"report":      report,
+		"convertible": convertible,
+		"defaults":    strings.Join(library.DefaultReaderFormats, ", "),
+		"message":     c.Query("message"),
+	}))
+}
+
+func (r *deviceRoutes) convertFormatsAction(c *gin.Context) {
+	report, err := r.formatReport(c)
+	if err != nil {
+		r.l.Error(err, "http - web - devices - convertFormatsAction")
+		c.Redirect(303, "/devices/formats?message="+url.QueryEscape("Failed to check the formats of the library"))
+		return
+	}
+	queued, err := r.shelf.StartConversions(c.Request.Context(), report)
+	message := "Converting " + strconv.Itoa(queued) + " books, they get the new format one by one"
+	switch {
+	case errors.Is(err, entity.ErrForbidden):
+		message = entity.ErrForbidden.Error()
+	case errors.Is(err, library.ErrConversionsRunning):
+		message = "Conversions are already running"
+	case errors.Is(err, library.ErrNoConverter):
+		message = "Conversions need the converter"
+	case err != nil:
+		r.l.Error(err, "http - web - devices - convertFormatsAction")
+		message = "Failed to start the conversions"
+	}
+	c.Redirect(303, "/devices/formats?message="+url.QueryEscape(message))
+}
+
+func (r *deviceRoutes) mergeDevicesAction(c *gin.Context) {
+	from := c.PostForm("from")
+	into := c.PostForm("into")
+
+	result, err := r.auth.MergeDevices(c.Request.Context(), from, into)
+	devices, _ := r.auth.ListDevices(c.Request.Context())
+	if err != nil {
+		c.HTML(400, "devices", passStandartContext(c, gin.H{
+			"devices": devices,
+			"error":   err.Error(),
+		}))
+		return
+	}
+
+	c.HTML(200, "devices", passStandartContext(c, gin.H{
+		"devices": devices,
+		"merged":  from,
+		"into":    into,
+		"result":  result,
+	}))
+}
+
+// deleteDeviceAction shows what goes with the device first, the data is
+// deleted when the form is sent again without dry_run.
+func (r *deviceRoutes) deleteDeviceAction(c *gin.Context) {
+	deviceName := c.Param("device_name")
+	dryRun := c.PostForm("dry_run") == "1"
+
+	report, err := r.auth.DeleteDeviceData(c.Request.Context(), deviceName, dryRun)
+	devices, _ := r.auth.ListDevices(c.Request.Context())
+	if err != nil {
+		c.HTML(400, "devices", passStandartContext(c, gin.H{
+			"devices": devices,
+			"error":   err.Error(),
+		}))
+		return
+	}
+
+	c.HTML(200, "devices", passStandartContext(c, gin.H{
+		"devices":  devices,
+		"deleting": deviceName,
+		"deletion": report,
+	}))
+}
+
+// createKeyAction shows the new key once, it can not be shown again.
+func (r *deviceRoutes) createKeyAction(c *gin.Context) {
+	key, plain, err := r.auth.CreateAPIKey(c.Request.Context(), c.GetString("username"),
+		c.PostForm("device_name"), c.PostForm("name"), c.PostForm("scope"))
+	devices, _ := r.auth.ListDevices(c.Request.Context())
+	if err != nil {
+		c.HTML(400, "devices", passStandartContext(c, gin.H{
+			"devices": devices,
+			"keys":    r.listKeys(c),
+			"error":   err.Error(),
+		}))
+		return
+	}
+
+	c.HTML(200, "devices", passStandartContext(c, gin.H{
+		"devices":    devices,
+		"keys":       r.listKeys(c),
+		"createdKey": key,
+		"plainKey":   plain,
+	}))
+}
+
+func (r *deviceRoutes) revokeKeyAction(c *gin.Context) {
+	err := r.auth.RevokeAPIKey(c.Request.Context(), c.GetString("username"), c.Param("id"))
+	if err != nil {
+		devices, _ := r.auth.ListDevices(c.Request.Context())
+		c.HTML(400, "devices", passStandartContext(c, gin.H{
+			"devices": devices,
+			"keys":    r.listKeys(c),
+			"error":   err.Error(),
+		}))
+		return
+	}
+
+	c.Redirect(302, "/devices")
+}
@@ -0,0 +1,105 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/pkg/converter"
+)
+
+// exportBooks streams the books of ?ids=a,b,c, the whole library without
+// ids, as a zip with manifest.json or, with ?manifest=opf, a calibre
+// metadata.opf next to every book.
+func (r *booksRoutes) exportBooks(c *gin.Context) {
+	manifest := c.DefaultQuery("manifest", library.ManifestJSON)
+	if manifest != library.ManifestJSON && manifest != library.ManifestOPF {
+		c.HTML(400, "error", passStandartContext(c, gin.H{"error": library.ErrInvalidManifest.Error()}))
+		return
+	}
+
+	c.Header("Content-Type", "application/zip")
+	c.Header("Content-Disposition", "attachment; filename=kompanion-"+time.Now().Format("2006-01-02")+".zip")
+	c.Status(http.StatusOK)
+	err := r.shelf.ExportBooks(c.Request.Context(), c.Writer, bundleIDs(c.Query("ids")), manifest)
+	if err != nil {
+		// the response has started, the client gets a truncated zip
+		r.logger.Error(err, "http - web - books - exportBooks")
+	}
+}
+
+// catalog renders the library, or ?collection=<id>, as a catalog to print
+// from the browser or, with ?format=pdf, as a PDF.
+func (r *booksRoutes) catalog(c *gin.Context) {
+	format := c.DefaultQuery("format", library.CatalogHTML)
+	branding, _ := c.MustGet("branding").(settings.Branding)
+	if format == library.CatalogPDF {
+		c.Header("Content-Type", "application/pdf")
+	} else {
+		c.Header("Content-Type", "text/html; charset=utf-8")
+	}
+	c.Header("Content-Disposition", "inline; filename=catalog-"+time.Now().Format("2006-01-02")+"."+strings.ToLower(format))
+	err := r.shelf.Catalog(c.Request.Context(), c.Writer, branding.InstanceName, c.Query("collection"), format)
+	if err == nil {
+		return
+	}
+	if c.Writer.Written() {
+		// the response has started, the client gets a truncated catalog
+		r.logger.Error(err, "http - web - books - catalog")
+		return
+	}
+	c.Writer.Header().Del("Content-Disposition")
+	switch {
+	case errors.Is(err, library.ErrUnknownCatalogFormat):
+		c.HTML(400, "error", passStandartContext(c, gin.H{"error": library.ErrUnknownCatalogFormat.Error()}))
+	case errors.Is(err, entity.ErrCollectionNotFound):
+		c.HTML(404, "error", passStandartContext(c, gin.H{"error": "collection not found"}))
+	case errors.Is(err, library.ErrNoConverter), errors.Is(err, converter.ErrNotInstalled), errors.Is(err, settings.ErrFeatureDisabled):
+		c.HTML(501, "error", passStandartContext(c, gin.H{"error": "PDF catalogs need the converter, print the HTML catalog instead"}))
+	default:
+		r.logger.Error(err, "http - web - books - catalog")
+		c.HTML(500, "error", passStandartContext(c, gin.H{"error": err.Error()}))
+	}
+}
+
+// citeBooks sends BibTeX or, with ?format=ris, RIS references to the books
+// of ?ids=a,b,c, the whole library without ids.
+func (r *booksRoutes) citeBooks(c *gin.Context) {
+	r.sendCitations(c, bundleIDs(c.Query("ids")), "kompanion-"+time.Now().Format("2006-01-02"))
+}
+
+func (r *booksRoutes) citeBook(c *gin.Context) {
+	bookID := c.Param("bookID")
+	r.sendCitations(c, []string{bookID}, bookID)
+}
+
+func (r *booksRoutes) sendCitations(c *gin.Context, ids []string, filename string) {
+	format := c.DefaultQuery("format", library.CitationBibTeX)
+	data, err := r.shelf.Citations(c.Request.Context(), ids, format)
+	if errors.Is(err, library.ErrUnknownCitationFormat) {
+		c.HTML(400, "error", passStandartContext(c, gin.H{"error": library.ErrUnknownCitationFormat.Error()}))
+		return
+	}
+	if err != nil {
+		r.logger.Error(err, "http - web - books - sendCitations")
+		c.HTML(500, "error", passStandartContext(c, gin.H{"error": err.Error()}))
+		return
+	}
+
+	contentType, extension := citationType(format)
+	c.Header("Content-Disposition", "attachment; filename="+filename+extension)
+	c.Data(http.StatusOK, contentType, data)
+}
+
+func citationType(format string) (contentType, extension string) {
+	if format == library.CitationRIS {
+		return "application/x-research-info-systems; charset=utf-8", ".ris"
+	}
+	return "application/x-bibtex; charset=utf-8", ".bib"
+}
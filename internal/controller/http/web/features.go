@@ -0,0 +1,47 @@
+package web
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+// featurePaths are the routes of the features that can be switched off.
+var featurePaths = []struct {
+	prefix  string
+	feature string
+}{
+	{"/opds", settings.FeatureOPDS},
+	{"/syncs", settings.FeatureSync},
+	{"/annotations", settings.FeatureSync},
+	{"/users/auth", settings.FeatureSync},
+	{"/webdav", settings.FeatureWebDAV},
+	{"/p/", settings.FeatureSharing},
+	{"/s/", settings.FeatureSharing},
+	{"/opds/shared/", settings.FeatureSharing},
+}
+
+// featuresMiddleware answers 404 for the routes of features that are off,
+// as if they were not there. Like maintenanceMiddleware it is installed on
+// the engine to cover the routes of every controller.
+func featuresMiddleware(s settings.Settings, l logger.Interface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		features, err := s.Features(c.Request.Context())
+		if err != nil {
+			l.Error(err, "http - web - featuresMiddleware")
+		}
+		c.Set("features", features)
+
+		for _, path := range featurePaths {
+			if strings.HasPrefix(c.Request.URL.Path, path.prefix) && !features.Enabled(path.feature) {
+				c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": path.feature + " is disabled on this server"})
+				return
+			}
+		}
+		c.Next()
+	}
+}
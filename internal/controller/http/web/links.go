@@ -0,0 +1,89 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/pkg/httpfile"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/signedurl"
+)
+
+type downloadRoutes struct {
+	shelf  library.Shelf
+	links  *signedurl.Signer
+	logger logger.Interface
+}
+
+// newDownloadRoutes serves books to anyone holding a signed link, e.g.
+// wget on a device or the Kindle browser, which cannot log in.
+func newDownloadRoutes(handler *gin.Engine, shelf library.Shelf, links *signedurl.Signer, l logger.Interface) {
+	r := &downloadRoutes{shelf: shelf, links: links, logger: l}
+
+	handler.GET("/dl/:bookID", r.download)
+	handler.GET("/s/:token", r.share)
+}
+
+func downloadPath(bookID string) string {
+	return "/dl/" + bookID
+}
+
+func sharePath(token string) string {
+	return "/s/" + token
+}
+
+func (r *downloadRoutes) download(c *gin.Context) {
+	bookID := c.Param("bookID")
+	err := r.links.Verify(downloadPath(bookID), c.Request.URL.Query())
+	if errors.Is(err, signedurl.ErrExpired) {
+		c.String(http.StatusGone, "download link expired")
+		return
+	}
+	if err != nil {
+		c.String(http.StatusForbidden, "invalid download link")
+		return
+	}
+
+	_, err = serveBookFile(c, r.shelf, bookID)
+	if errors.Is(err, library.ErrDownloadRefused) {
+		c.String(http.StatusForbidden, err.Error())
+		return
+	}
+	if err != nil {
+		r.logger.Error(err, "http - web - download")
+		c.String(http.StatusNotFound, "book not found")
+	}
+}
+
+// share sends the book of a share link to anyone holding it, counting the
+// download unless it is resumed.
+func (r *downloadRoutes) share(c *gin.Context) {
+	book, file, err := r.shelf.DownloadSharedBook(c.Request.Context(), c.Param("token"), !httpfile.Resumed(c.Request))
+	switch {
+	case errors.Is(err, entity.ErrShareLinkNotFound), errors.Is(err, entity.ErrBookNotFound):
+		c.String(http.StatusNotFound, "share link not found")
+		return
+	case errors.Is(err, entity.ErrShareLinkExpired):
+		c.String(http.StatusGone, entity.ErrShareLinkExpired.Error())
+		return
+	case errors.Is(err, entity.ErrShareLinkUsedUp):
+		c.String(http.StatusGone, entity.ErrShareLinkUsedUp.Error())
+		return
+	case errors.Is(err, library.ErrDownloadRefused):
+		c.String(http.StatusForbidden, err.Error())
+		return
+	case err != nil:
+		r.logger.Error(err, "http - web - share")
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Disposition", "attachment; filename="+book.Filename())
+	c.Header("Content-Type", "application/octet-stream")
+	httpfile.Serve(c.Writer, c.Request, book.ETag(), book.UpdatedAt, file)
+}
@@ -0,0 +1,86 @@
+package web
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+// paths that stay writable in read-only mode, otherwise nobody could log in
+// and switch it off again. Backups do not change the library and are the
+// usual reason for the mode.
+var maintenanceAllowedPaths = []string{
+	"/auth/login",
+	"/auth/logout",
+	"/settings/maintenance",
+	"/settings/backup",
+	"/api/settings/maintenance",
+	"/api/backups",
+}
+
+// maintenanceMiddleware rejects every mutation with 503 while the instance is read-only.
+// It is installed on the engine, so it covers web, api, opds and webdav routes.
+func maintenanceMiddleware(s settings.Settings, l logger.Interface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		maintenance, err := s.Maintenance(c.Request.Context())
+		if err != nil {
+			l.Error(err, "http - web - maintenanceMiddleware")
+		}
+		c.Set("maintenance", maintenance)
+
+		if !maintenance.ReadOnly || isReadRequest(c.Request) {
+			c.Next()
+			return
+		}
+		for _, path := range maintenanceAllowedPaths {
+			if strings.HasPrefix(c.Request.URL.Path, path) {
+				c.Next()
+				return
+			}
+		}
+
+		message := maintenance.Message
+		if message == "" {
+			message = "server is in read-only maintenance mode"
+		}
+		c.Header("Retry-After", strconv.Itoa(int(maintenance.RetryAfter.Seconds())))
+		c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{"error": message})
+	}
+}
+
+func isReadRequest(r *http.Request) bool {
+	switch r.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, "PROPFIND":
+		return true
+	}
+	return false
+}
+
+func (r *settingsRoutes) updateMaintenance(c *gin.Context) {
+	maintenance := settings.Maintenance{
+		ReadOnly: c.PostForm("read_only") == "true",
+		Message:  strings.TrimSpace(c.PostForm("message")),
+	}
+	minutes, err := strconv.Atoi(c.DefaultPostForm("retry_after_minutes", "5"))
+	if err != nil || minutes < 1 {
+		c.HTML(400, "settings", r.settingsContext(c, gin.H{"error": "Retry after must be a positive number of minutes"}))
+		return
+	}
+	maintenance.RetryAfter = time.Duration(minutes) * time.Minute
+
+	maintenance, err = r.settings.SetMaintenance(c.Request.Context(), maintenance)
+	if err != nil {
+		r.l.Error(err, "http - web - settings - updateMaintenance")
+		c.HTML(500, "settings", r.settingsContext(c, gin.H{"error": "failed to save settings"}))
+		return
+	}
+
+	c.Set("maintenance", maintenance)
+	c.Redirect(302, "/settings/")
+}
@@ -0,0 +1,48 @@
+package web
+
+import (
+	"html/template"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/position"
+	"github.com/banjuer/kompanion/pkg/qr"
+)
+
+// resumeLinks describes where the book was last read with the links that
+// open it there, nil when its file never synced progress. fileURL is the
+// signed download link of the book.
+func (r *booksRoutes) resumeLinks(c *gin.Context, book entity.Book, fileURL string) gin.H {
+	progress, err := r.progress.Fetch(c.Request.Context(), book.DocumentID)
+	if err != nil {
+		r.logger.Error(err, "failed to fetch progress")
+		return nil
+	}
+	if progress.Document == "" {
+		return nil
+	}
+
+	p := position.FromProgress(book.ID, book.DocumentID, progress.Progress, progress.Percentage, progress.Device, progress.Timestamp)
+	deepLink := absoluteURL(c.Request, p.DeepLink(fileURL, book.Extension()))
+	return gin.H{
+		"position": p,
+		"percent":  int(p.Percentage * 100),
+		"url":      absoluteURL(c.Request, p.Path()),
+		"deepLink": deepLink,
+		"qr":       qrDataURL(deepLink),
+	}
+}
+
+// viewPosition opens a position URL in the browser, on the book page. Other
+// tools read the position from the URL itself.
+func (r *booksRoutes) viewPosition(c *gin.Context) {
+	c.Redirect(http.StatusFound, "/books/"+c.Param("bookID")+"#resume")
+}
+
+// qrDataURL returns a QR code of content as a PNG data URL for an img,
+// empty when content is too long for one.
+func qrDataURL(content string) template.URL {
+	return template.URL(qr.DataURL(content, qr.DefaultSize))
+}
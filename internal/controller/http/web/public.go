@@ -0,0 +1,146 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/richtext"
+)
+
+type publicRoutes struct {
+	shelf    library.Shelf
+	settings settings.Settings
+	logger   logger.Interface
+}
+
+// newPublicRoutes serves a page with the metadata and cover of a book to
+// anyone once the owner switched public pages on, so shared links unfurl
+// in chat apps. The book file stays behind the login.
+func newPublicRoutes(handler *gin.Engine, shelf library.Shelf, st settings.Settings, l logger.Interface) {
+	r := &publicRoutes{shelf: shelf, settings: st, logger: l}
+
+	handler.GET("/p/:bookID", r.viewBook)
+	handler.GET("/p/:bookID/cover", r.viewCover)
+}
+
+func publicBookPath(bookID string) string {
+	return "/p/" + bookID
+}
+
+// sharingMiddleware puts the sharing settings in context, the book page
+// links its public page when there is one.
+func sharingMiddleware(s settings.Settings, l logger.Interface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		sharing, err := s.Sharing(c.Request.Context())
+		if err != nil {
+			l.Error(err, "http - web - sharingMiddleware")
+		}
+		// pages of a feature that is off are not linked
+		if features, ok := c.Value("features").(settings.Features); ok && !features.Enabled(settings.FeatureSharing) {
+			sharing = settings.Sharing{}
+		}
+		c.Set("sharing", sharing)
+		c.Next()
+	}
+}
+
+// bookJSONLD describes book for JSON-LD, linking its public page and cover
+// when they are public and the pages behind the login otherwise.
+func bookJSONLD(c *gin.Context, book entity.Book, public bool) library.SchemaBook {
+	page, cover := "/books/"+book.ID, "/books/"+book.ID+"/cover"
+	if public {
+		page, cover = publicBookPath(book.ID), publicBookPath(book.ID)+"/cover"
+	}
+	if book.CoverPath == "" {
+		cover = ""
+	} else {
+		cover = absoluteURL(c.Request, cover)
+	}
+	return library.NewSchemaBook(book, absoluteURL(c.Request, page), cover)
+}
+
+// public reports whether book pages are public, answering 404 when not.
+// Visitors are anonymous readers, private books are not found for them.
+func (r *publicRoutes) public(c *gin.Context) (settings.Sharing, bool) {
+	sharing, err := r.settings.Sharing(c.Request.Context())
+	if err != nil {
+		r.logger.Error(err, "http - web - public")
+	}
+	if !sharing.PublicPages {
+		c.String(http.StatusNotFound, "page not found")
+		return sharing, false
+	}
+	if !sharing.Indexable {
+		c.Header("X-Robots-Tag", "noindex, nofollow")
+	}
+	c.Request = c.Request.WithContext(library.WithReader(c.Request.Context(), ""))
+	return sharing, true
+}
+
+func (r *publicRoutes) viewBook(c *gin.Context) {
+	sharing, ok := r.public(c)
+	if !ok {
+		return
+	}
+	book, err := r.shelf.ViewBook(c.Request.Context(), c.Param("bookID"))
+	if errors.Is(err, entity.ErrBookNotFound) {
+		c.String(http.StatusNotFound, "page not found")
+		return
+	}
+	if err != nil {
+		r.logger.Error(err, "http - web - public - viewBook")
+		c.String(http.StatusInternalServerError, "internal server error")
+		return
+	}
+
+	jsonld := bookJSONLD(c, book, true)
+	c.HTML(http.StatusOK, "public_book.html", gin.H{
+		"book":        book,
+		"jsonld":      jsonld,
+		"description": truncateRunes(richtext.PlainText(book.Description), 300),
+		"indexable":   sharing.Indexable,
+		"branding":    c.MustGet("branding"),
+	})
+}
+
+func (r *publicRoutes) viewCover(c *gin.Context) {
+	if _, ok := r.public(c); !ok {
+		return
+	}
+	bookID := c.Param("bookID")
+	if link, err := r.shelf.CoverURL(c.Request.Context(), bookID); err == nil && link != "" {
+		c.Redirect(http.StatusFound, link)
+		return
+	}
+	cover, err := r.shelf.ViewCover(c.Request.Context(), bookID)
+	if err != nil {
+		c.String(http.StatusNotFound, "cover not found")
+		return
+	}
+	defer cover.Close()
+	c.Header("Cache-Control", "public, max-age=86400")
+	c.File(cover.Name())
+}
+
+// truncateRunes shortens s to at most n characters for a link preview.
+func truncateRunes(s string, n int) string {
+	runes := []rune(s)
+	if len(runes) <= n {
+		return s
+	}
+	return string(runes[:n-1]) + "…"
+}
+
+func absoluteURL(req *http.Request, path string) string {
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + path
+}
@@ -15,11 +15,17 @@ import (
 	"github.com/foolin/goview/supports/ginview"
 	"github.com/gin-gonic/gin"
 	"github.com/banjuer/kompanion"
+	"github.com/banjuer/kompanion/internal/annotations"
 	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/internal/backup"
+	"github.com/banjuer/kompanion/internal/entity"
 	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
 	"github.com/banjuer/kompanion/internal/stats"
 	"github.com/banjuer/kompanion/internal/sync"
 	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/richtext"
+	"github.com/banjuer/kompanion/pkg/signedurl"
 )
 
 func NewRouter(
@@ -29,14 +35,27 @@ func NewRouter(
 	p sync.Progress,
 	shelf library.Shelf,
 	stats stats.ReadingStats,
+	an annotations.Annotations,
+	st settings.Settings,
+	bk backup.Backups,
+	links *signedurl.Signer,
+	sso SingleSignOn,
 	version string,
 ) {
 	// Options
 	handler.Use(gin.Logger())
-	handler.Use(gin.Recovery())
 	handler.Use(func(c *gin.Context) {
 		c.Set("startTime", time.Now())
 	})
+	handler.Use(brandingMiddleware(st, l))
+	handler.Use(maintenanceMiddleware(st, l))
+	handler.Use(featuresMiddleware(st, l))
+	handler.Use(sharingMiddleware(st, l))
+	handler.Use(func(c *gin.Context) {
+		if sso.Client != nil {
+			c.Set("sso", sso.Name)
+		}
+	})
 	// static files
 	staticFs, err := fs.Sub(kompanion.WebAssets, "web/static")
 	if err != nil {
@@ -49,6 +68,15 @@ func NewRouter(
 	config.DisableCache = gin.IsDebugging()
 	config.Funcs = template.FuncMap{
 		"formatDuration": formatDuration,
+		"formatSize":     formatSize,
+		"stars": func(n int) string {
+			return strings.Repeat("★", n)
+		},
+		"richText": func(s string) template.HTML {
+			return template.HTML(richtext.Sanitize(s))
+		},
+		"plainText": richtext.PlainText,
+		"join":      strings.Join,
 		"json": func(v interface{}) template.JS {
 			b, err := json.Marshal(v)
 			if err != nil {
@@ -59,6 +87,9 @@ func NewRouter(
 		"subtract": func(a, b int) int {
 			return a - b
 		},
+		"percent": func(f float64) int {
+			return int(math.Round(f * 100))
+		},
 		// https://github.com/go-gitea/gitea/blob/f35850f48ed0bd40ec288e2547ac687a7bf1746c/modules/templates/helper.go#L76
 		"LoadTimes": func(startTime time.Time) string {
 			return fmt.Sprint(time.Since(startTime).Nanoseconds()/1e6) + "ms"
@@ -88,12 +119,18 @@ func NewRouter(
 
 	// Login
 	authGroup := handler.Group("/auth")
-	newAuthRoutes(authGroup, a, l)
+	newAuthRoutes(authGroup, a, sso, l)
 
 	// Product pages
 	bookGroup := handler.Group("/books")
 	bookGroup.Use(authMiddleware(a))
-	newBooksRoutes(bookGroup, shelf, stats, p, l)
+	newBooksRoutes(bookGroup, shelf, stats, p, an, links, l)
+
+	// Signed download links, for clients without credentials
+	newDownloadRoutes(handler, shelf, links, l)
+
+	// Public book pages, for link previews and search engines
+	newPublicRoutes(handler, shelf, st, l)
 
 	// Stats pages
 	statsGroup := handler.Group("/stats")
@@ -103,12 +140,26 @@ func NewRouter(
 	// Device management
 	deviceGroup := handler.Group("/devices")
 	deviceGroup.Use(authMiddleware(a))
-	newDeviceRoutes(deviceGroup, a, l)
+	newDeviceRoutes(deviceGroup, a, shelf, p, l)
+
+	// Instance settings
+	settingsGroup := handler.Group("/settings")
+	settingsGroup.Use(authMiddleware(a), adminMiddleware())
+	newSettingsRoutes(settingsGroup, st, bk, shelf, l)
 }
 
 func passStandartContext(c *gin.Context, data gin.H) gin.H {
 	data["isAuthenticated"] = c.GetBool("isAuthenticated")
 	data["startTime"] = c.GetTime("startTime")
+	data["branding"] = c.MustGet("branding")
+	data["maintenance"] = c.MustGet("maintenance")
+	data["sharing"] = c.MustGet("sharing")
+	if name := c.GetString("sso"); name != "" {
+		data["sso"] = name
+	}
+	role, _ := c.Value("role").(entity.Role)
+	data["canEdit"] = role.CanEdit()
+	data["isAdmin"] = role.CanManage()
 	return data
 }
 
@@ -126,6 +177,19 @@ func formatDuration(seconds int) string {
 	return fmt.Sprintf("%ds", secs)
 }
 
+func formatSize(bytes int64) string {
+	const unit = 1024
+	if bytes < unit {
+		return fmt.Sprintf("%d B", bytes)
+	}
+	div, exp := int64(unit), 0
+	for n := bytes / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %cB", float64(bytes)/float64(div), "KMGT"[exp])
+}
+
 func generateProgressBar(percentage int, totalLength int) string {
 	if percentage < 0 {
 		percentage = 0
@@ -0,0 +1,164 @@
+package web
+
+import (
+	"errors"
+	"net/url"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/backup"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+type settingsRoutes struct {
+	settings settings.Settings
+	backups  backup.Backups
+	shelf    library.Shelf
+	l        logger.Interface
+}
+
+func newSettingsRoutes(handler *gin.RouterGroup, s settings.Settings, b backup.Backups, shelf library.Shelf, l logger.Interface) {
+	r := &settingsRoutes{s, b, shelf, l}
+
+	handler.GET("/", r.viewSettings)
+	handler.POST("/branding", r.updateBranding)
+	handler.POST("/maintenance", r.updateMaintenance)
+	handler.POST("/sharing", r.updateSharing)
+	handler.POST("/features", r.updateFeatures)
+	handler.POST("/backup", r.startBackup)
+	handler.POST("/backup/verify", r.startVerify)
+	handler.POST("/library/verify", r.startLibraryVerify)
+}
+
+// brandingMiddleware puts instance branding in context for every rendered page.
+func brandingMiddleware(s settings.Settings, l logger.Interface) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		branding, err := s.Branding(c.Request.Context())
+		if err != nil {
+			l.Error(err, "http - web - brandingMiddleware")
+		}
+		c.Set("branding", branding)
+		c.Next()
+	}
+}
+
+func (r *settingsRoutes) viewSettings(c *gin.Context) {
+	c.HTML(200, "settings", r.settingsContext(c, gin.H{
+		"backupMessage":  c.Query("backup"),
+		"libraryMessage": c.Query("library"),
+	}))
+}
+
+// settingsContext adds backup history and library issues shown on every
+// settings render.
+func (r *settingsRoutes) settingsContext(c *gin.Context, data gin.H) gin.H {
+	runs, err := r.backups.List(c.Request.Context(), 10)
+	if err != nil {
+		r.l.Error(err, "http - web - settings - backups.List")
+	}
+	data["backups"] = runs
+
+	check, issues, err := r.shelf.LibraryIssues(c.Request.Context())
+	if err != nil {
+		r.l.Error(err, "http - web - settings - shelf.LibraryIssues")
+	}
+	data["libraryCheck"] = check
+	data["libraryIssues"] = issues
+
+	features, err := r.settings.Features(c.Request.Context())
+	if err != nil {
+		r.l.Error(err, "http - web - settings - settings.Features")
+	}
+	data["features"] = features
+	data["featureNames"] = settings.FeatureNames
+	return passStandartContext(c, data)
+}
+
+func (r *settingsRoutes) startBackup(c *gin.Context) {
+	r.redirectStarted(c, "Backup started", r.backups.Start(c.Request.Context()))
+}
+
+func (r *settingsRoutes) startVerify(c *gin.Context) {
+	r.redirectStarted(c, "Restore test of the latest backup started", r.backups.StartVerify(c.Request.Context()))
+}
+
+func (r *settingsRoutes) redirectStarted(c *gin.Context, message string, err error) {
+	switch {
+	case errors.Is(err, backup.ErrNotConfigured):
+		message = "Backups are not configured, set KOMPANION_BACKUP_PATH"
+	case errors.Is(err, backup.ErrAlreadyRunning):
+		message = "Backup is already running"
+	case err != nil:
+		r.l.Error(err, "http - web - settings - redirectStarted")
+		message = "Failed to start backup"
+	}
+	c.Redirect(302, "/settings/?backup="+url.QueryEscape(message))
+}
+
+func (r *settingsRoutes) startLibraryVerify(c *gin.Context) {
+	message := "Library check started"
+	err := r.shelf.StartVerifyLibrary(c.Request.Context())
+	switch {
+	case errors.Is(err, library.ErrVerifyRunning):
+		message = "Library check is already running"
+	case err != nil:
+		r.l.Error(err, "http - web - settings - startLibraryVerify")
+		message = "Failed to start library check"
+	}
+	c.Redirect(302, "/settings/?library="+url.QueryEscape(message))
+}
+
+func (r *settingsRoutes) updateBranding(c *gin.Context) {
+	var form settings.Branding
+	if err := c.ShouldBind(&form); err != nil {
+		c.HTML(400, "settings", r.settingsContext(c, gin.H{"error": "invalid request"}))
+		return
+	}
+
+	branding, err := r.settings.UpdateBranding(c.Request.Context(), form)
+	if errors.Is(err, settings.ErrInvalidValue) {
+		c.HTML(400, "settings", r.settingsContext(c, gin.H{"error": "Accent color must look like #336699, logo must be an URL"}))
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - web - settings - updateBranding")
+		c.HTML(500, "settings", r.settingsContext(c, gin.H{"error": "failed to save settings"}))
+		return
+	}
+
+	c.Set("branding", branding)
+	c.Redirect(302, "/settings/")
+}
+
+func (r *settingsRoutes) updateSharing(c *gin.Context) {
+	sharing := settings.Sharing{
+		PublicPages: c.PostForm("public_pages") == "true",
+		Indexable:   c.PostForm("indexable") == "true",
+	}
+	sharing, err := r.settings.SetSharing(c.Request.Context(), sharing)
+	if err != nil {
+		r.l.Error(err, "http - web - settings - updateSharing")
+		c.HTML(500, "settings", r.settingsContext(c, gin.H{"error": "failed to save settings"}))
+		return
+	}
+
+	c.Set("sharing", sharing)
+	c.Redirect(302, "/settings/")
+}
+
+// updateFeatures switches every feature, the form has a checkbox for each.
+func (r *settingsRoutes) updateFeatures(c *gin.Context) {
+	changes := make(settings.Features, len(settings.FeatureNames))
+	for _, name := range settings.FeatureNames {
+		changes[name] = c.PostForm(name) == "true"
+	}
+	if _, err := r.settings.SetFeatures(c.Request.Context(), changes); err != nil {
+		r.l.Error(err, "http - web - settings - updateFeatures")
+		c.HTML(500, "settings", r.settingsContext(c, gin.H{"error": "failed to save settings"}))
+		return
+	}
+
+	c.Redirect(302, "/settings/")
+}
@@ -0,0 +1,151 @@
+package web
+
+import (
+	"errors"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/banjuer/kompanion/internal/auth"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/oidc"
+)
+
+// SingleSignOn is the OpenID Connect provider users can log in with, none
+// when Client is nil.
+type SingleSignOn struct {
+	Client *oidc.Client
+	// Name is shown on the login button, e.g. "Authelia".
+	Name string
+}
+
+// ssoCookie keeps state, nonce and PKCE verifier of a login in progress,
+// and whether it links the identity to the signed in user.
+const ssoCookie = "sso_login"
+
+type ssoRoutes struct {
+	auth auth.AuthInterface
+	sso  SingleSignOn
+	l    logger.Interface
+}
+
+func newSSORoutes(handler *gin.RouterGroup, a auth.AuthInterface, sso SingleSignOn, l logger.Interface) {
+	if sso.Client == nil {
+		return
+	}
+	r := &ssoRoutes{a, sso, l}
+
+	handler.GET("/oidc", r.loginAction)
+	handler.GET("/oidc/callback", r.callbackAction)
+}
+
+// loginAction sends the browser to the provider, ?link=1 links the identity
+// to the signed in user instead of signing in.
+func (r *ssoRoutes) loginAction(c *gin.Context) {
+	var values [3]string
+	for i := range values {
+		value, err := oidc.RandomString()
+		if err != nil {
+			r.l.Error(err, "http - web - sso - loginAction")
+			r.loginError(c, "single sign-on failed")
+			return
+		}
+		values[i] = value
+	}
+	state, nonce, verifier := values[0], values[1], values[2]
+
+	link, err := r.sso.Client.AuthCodeURL(c.Request.Context(), state, nonce, verifier)
+	if err != nil {
+		r.l.Error(err, "http - web - sso - loginAction")
+		r.loginError(c, r.sso.Name+" is not reachable")
+		return
+	}
+	// the provider sends the browser back with a top level GET, lax
+	// cookies go along
+	c.SetSameSite(http.SameSiteLaxMode)
+	mode := "login"
+	if c.Query("link") != "" {
+		mode = "link"
+	}
+	c.SetCookie(ssoCookie, state+"."+nonce+"."+verifier+"."+mode, 600, "/auth/oidc", "", c.Request.TLS != nil, true)
+	c.Redirect(http.StatusFound, link)
+}
+
+func (r *ssoRoutes) callbackAction(c *gin.Context) {
+	saved, _ := c.Cookie(ssoCookie)
+	c.SetCookie(ssoCookie, "", -1, "/auth/oidc", "", c.Request.TLS != nil, true)
+
+	if reason := c.Query("error"); reason != "" {
+		r.loginError(c, r.sso.Name+": "+strings.TrimSpace(reason+" "+c.Query("error_description")))
+		return
+	}
+	parts := strings.Split(saved, ".")
+	if len(parts) != 4 || parts[0] == "" || parts[0] != c.Query("state") {
+		r.loginError(c, "the login expired, please try again")
+		return
+	}
+	nonce, verifier := parts[1], parts[2]
+
+	claims, err := r.sso.Client.Exchange(c.Request.Context(), c.Query("code"), verifier, nonce)
+	if err != nil {
+		r.l.Error(err, "http - web - sso - callbackAction")
+		if errors.Is(err, oidc.ErrNoUsername) {
+			r.loginError(c, r.sso.Name+" did not tell the username")
+			return
+		}
+		r.loginError(c, "single sign-on failed")
+		return
+	}
+
+	identity := auth.ExternalIdentity{Issuer: claims.Issuer, Subject: claims.Subject, Username: claims.Username}
+	if parts[3] == "link" {
+		r.link(c, identity)
+		return
+	}
+
+	clientIP, _ := c.RemoteIP()
+	sessionKey, err := r.auth.LoginExternal(c.Request.Context(), identity, c.Request.UserAgent(), clientIP)
+	if errors.Is(err, auth.UserNotFound) {
+		r.loginError(c, "no account for "+claims.Username+", ask the administrator to create it")
+		return
+	}
+	if errors.Is(err, auth.IdentityNotLinked) {
+		r.loginError(c, "the account "+claims.Username+" is not linked to "+r.sso.Name+
+			", sign in with its password and link it on the devices page")
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - web - sso - callbackAction")
+		r.loginError(c, "single sign-on failed")
+		return
+	}
+	c.SetCookie("session", sessionKey, 0, "/", "", false, true)
+	c.Redirect(http.StatusFound, "/books")
+}
+
+// link links the identity to the user of the session cookie.
+func (r *ssoRoutes) link(c *gin.Context, identity auth.ExternalIdentity) {
+	sessionKey, _ := c.Cookie("session")
+	username, err := r.auth.SessionUser(c.Request.Context(), sessionKey)
+	if err != nil {
+		r.loginError(c, "sign in before linking "+r.sso.Name)
+		return
+	}
+	err = r.auth.LinkExternal(c.Request.Context(), username, identity)
+	if errors.Is(err, auth.IdentityLinked) {
+		c.Redirect(http.StatusFound, "/devices?sso_error="+url.QueryEscape("this "+r.sso.Name+" account is linked to another user"))
+		return
+	}
+	if err != nil {
+		r.l.Error(err, "http - web - sso - link")
+		c.Redirect(http.StatusFound, "/devices?sso_error="+url.QueryEscape("linking "+r.sso.Name+" failed"))
+		return
+	}
+	c.Redirect(http.StatusFound, "/devices?sso_linked=1")
+}
+
+func (r *ssoRoutes) loginError(c *gin.Context, msg string) {
+	c.Redirect(http.StatusFound, "/auth/login?sso_error="+url.QueryEscape(msg))
+}
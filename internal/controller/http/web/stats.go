@@ -3,6 +3,7 @@ package web
 import (
 	"bytes"
 	"fmt"
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
@@ -172,6 +173,20 @@ func newStatsRoutes(handler *gin.RouterGroup, statsSvc stats.ReadingStats, l log
 		}))
 	})
 
+	handler.GET("/"+stats.KOReaderFile, func(c *gin.Context) {
+		file, err := statsSvc.Export(c.Request.Context())
+		if err != nil {
+			l.Error(err, "failed to export stats")
+			c.Status(500)
+			return
+		}
+		defer file.Close()
+
+		c.Header("Content-Disposition", "attachment; filename="+stats.KOReaderFile)
+		c.Header("Content-Type", "application/vnd.sqlite3")
+		http.ServeContent(c.Writer, c.Request, stats.KOReaderFile, time.Now(), file)
+	})
+
 	handler.GET("/chart", func(c *gin.Context) {
 		now := time.Now()
 		from := now.AddDate(0, 0, -6)
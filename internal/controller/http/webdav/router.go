@@ -3,9 +3,9 @@ package webdav
 import (
 	"encoding/xml"
 	"fmt"
+	"io"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"regexp"
 	"strings"
@@ -15,6 +15,8 @@ import (
 	"github.com/banjuer/kompanion/internal/entity"
 	"github.com/banjuer/kompanion/internal/library"
 	"github.com/banjuer/kompanion/internal/stats"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/httpfile"
 	"github.com/banjuer/kompanion/pkg/logger"
 	"github.com/gin-gonic/gin"
 )
@@ -35,7 +37,6 @@ func NewRouter(
 ) {
 	// Options
 	handler.Use(gin.Logger())
-	handler.Use(gin.Recovery())
 
 	r := &routes{auth: a, logger: l, stats: rs, shelf: shelf}
 	h := handler.Group("/webdav")
@@ -45,6 +46,7 @@ func NewRouter(
 	h.Handle("PROPFIND", "/books/*filepath", r.propfindBook)
 	h.GET("/books/*filepath", r.getBook)
 	h.Handle("HEAD", "/books/*filepath", r.headBook)
+	h.GET("/statistics.sqlite3", r.getStatistics)
 	h.PUT("/statistics.sqlite3", r.putStatistics)
 }
 
@@ -91,6 +93,11 @@ func (r *routes) propfindBook(c *gin.Context) {
 }
 
 func (r *routes) getBook(c *gin.Context) {
+	if book, err := r.bookFromPath(c); err == nil && httpfile.NotModified(c.Request, book.ETag(), book.UpdatedAt) {
+		httpfile.WriteNotModified(c.Writer, book.ETag(), book.UpdatedAt)
+		return
+	}
+
 	book, file, err := r.downloadBookFromPath(c)
 	if err != nil {
 		c.JSON(http.StatusNotFound, gin.H{"message": "book not found"})
@@ -99,7 +106,19 @@ func (r *routes) getBook(c *gin.Context) {
 	defer file.Close()
 
 	c.Header("Content-Type", book.MimeType())
-	c.FileAttachment(file.Name(), book.Filename())
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=\"%s\"", book.Filename()))
+	httpfile.Serve(c.Writer, c.Request, book.ETag(), book.UpdatedAt, file)
+
+	if !httpfile.Resumed(c.Request) {
+		download := entity.Download{BookID: book.ID, Username: c.GetString("username"), Client: entity.ClientWebDAV}
+		if download.Username == "" {
+			// device_name is also set for account logins, stats are kept per login
+			download.DeviceName = c.GetString("device_name")
+		}
+		if err := r.shelf.RecordDownload(c.Request.Context(), download); err != nil {
+			r.logger.Error(err, "http - webdav - getBook")
+		}
+	}
 }
 
 func (r *routes) headBook(c *gin.Context) {
@@ -109,14 +128,34 @@ func (r *routes) headBook(c *gin.Context) {
 		return
 	}
 	defer file.Close()
-	info, _ := file.Stat()
+	size, err := file.Seek(0, io.SeekEnd)
 	c.Header("Content-Type", book.MimeType())
-	if info != nil {
-		c.Header("Content-Length", fmt.Sprintf("%d", info.Size()))
+	c.Header("Accept-Ranges", "bytes")
+	if etag := book.ETag(); etag != "" {
+		c.Header("ETag", etag)
+	}
+	c.Header("Last-Modified", book.UpdatedAt.UTC().Format(http.TimeFormat))
+	if err == nil {
+		c.Header("Content-Length", fmt.Sprintf("%d", size))
 	}
 	c.Status(http.StatusOK)
 }
 
+// getStatistics hands KOReader the statistics of all devices, its sync
+// merges them into the local database before uploading.
+func (r *routes) getStatistics(c *gin.Context) {
+	file, err := r.stats.Export(c.Request.Context())
+	if err != nil {
+		r.logger.Error(err, "http - webdav - getStatistics")
+		c.JSON(http.StatusInternalServerError, gin.H{"message": "error exporting statistics"})
+		return
+	}
+	defer file.Close()
+
+	c.Header("Content-Type", "application/vnd.sqlite3")
+	http.ServeContent(c.Writer, c.Request, stats.KOReaderFile, time.Now(), file)
+}
+
 func (r *routes) putStatistics(c *gin.Context) {
 	device := c.GetString("device_name")
 	err := r.stats.Write(c.Request.Context(), c.Request.Body, device)
@@ -130,15 +169,17 @@ func (r *routes) putStatistics(c *gin.Context) {
 
 func (r *routes) listAllBooks(c *gin.Context) ([]entity.Book, error) {
 	var books []entity.Book
-	for page := 1; ; page++ {
-		list, err := r.shelf.ListBooks(c.Request.Context(), "title", "asc", page, 100)
+	cursor := ""
+	for {
+		list, err := r.shelf.ListBooksByCursor(c.Request.Context(), library.BookFilter{}, "title", "asc", cursor, 100)
 		if err != nil {
 			return nil, err
 		}
 		books = append(books, list.Books...)
-		if !list.HasNext() {
+		if list.NextCursor == "" {
 			return books, nil
 		}
+		cursor = list.NextCursor
 	}
 }
 
@@ -243,22 +284,22 @@ func (r *routes) bookFromPath(c *gin.Context) (entity.Book, error) {
 	return r.shelf.ViewBook(c.Request.Context(), bookID)
 }
 
-func (r *routes) downloadBookFromPath(c *gin.Context) (entity.Book, *os.File, error) {
+func (r *routes) downloadBookFromPath(c *gin.Context) (entity.Book, storage.File, error) {
 	bookID := bookIDFromWebDAVPath(c.Param("filepath"))
-	return r.shelf.DownloadBook(c.Request.Context(), bookID)
+	return r.shelf.DownloadBook(c.Request.Context(), bookID, "")
 }
 
 func (r *routes) bookSize(c *gin.Context, bookID string) int64 {
-	_, file, err := r.shelf.DownloadBook(c.Request.Context(), bookID)
+	_, file, err := r.shelf.DownloadBook(c.Request.Context(), bookID, "")
 	if err != nil {
 		return 0
 	}
 	defer file.Close()
-	info, err := file.Stat()
+	size, err := file.Seek(0, io.SeekEnd)
 	if err != nil {
 		return 0
 	}
-	return info.Size()
+	return size
 }
 
 func basicAuth(auth auth.AuthInterface) gin.HandlerFunc {
@@ -270,14 +311,28 @@ func basicAuth(auth auth.AuthInterface) gin.HandlerFunc {
 			c.Abort()
 			return
 		}
-		if !auth.CheckDevicePassword(c.Request.Context(), username, password, true) {
+		// API keys of the user and device tokens stand in for passwords,
+		// read-only ones can not upload statistics
+		if key, err := auth.CheckAPIKey(c.Request.Context(), password); err == nil && key.SignsInAs(username) {
+			if !key.CanWrite() && c.Request.Method == http.MethodPut {
+				c.JSON(http.StatusForbidden, gin.H{"message": "Forbidden", "code": 2001})
+				c.Abort()
+				return
+			}
+			if key.Device == "" {
+				c.Set("username", username)
+			}
+		} else if !auth.CheckDevicePassword(c.Request.Context(), username, password, true) {
 			if !auth.CheckPassword(c.Request.Context(), username, password) {
 				c.JSON(http.StatusUnauthorized, gin.H{"message": "Unauthorized", "code": 2001})
 				c.Abort()
 				return
 			}
+			c.Set("username", username)
 		}
 		c.Set("device_name", username)
+		// devices are shared, they see the books nobody keeps private
+		c.Request = c.Request.WithContext(library.WithReader(c.Request.Context(), c.GetString("username")))
 		c.Next()
 	}
 }
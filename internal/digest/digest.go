@@ -0,0 +1,180 @@
+// Package digest mails the books added to the library to a list of
+// addresses, in the branding of the instance.
+package digest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/mailer"
+)
+
+// digestPageSize is the number of books listed at a time, newest first.
+const digestPageSize = 100
+
+// defaultAccentColor underlines the header of instances without an accent color.
+const defaultAccentColor = "#222222"
+
+// Digest mails the new books of the library.
+type Digest struct {
+	books    BookLister
+	branding BrandingSource
+	mailer   Mailer
+	to       []string
+	l        logger.Interface
+}
+
+// New -. to are the addresses every digest is sent to.
+func New(books BookLister, branding BrandingSource, m Mailer, to []string, l logger.Interface) *Digest {
+	return &Digest{books: books, branding: branding, mailer: m, to: to, l: l}
+}
+
+// Send mails the books added since since to every address and returns the
+// number of books. No digest is sent when there are none.
+func (d *Digest) Send(ctx context.Context, since time.Time) (int, error) {
+	books, err := d.newBooks(ctx, since)
+	if err != nil {
+		return 0, fmt.Errorf("Digest - Send - d.newBooks: %w", err)
+	}
+	if len(books) == 0 {
+		return 0, nil
+	}
+	// the defaults come with the error
+	branding, err := d.branding.Branding(ctx)
+	if err != nil {
+		d.l.Warn("Digest - Send - d.branding.Branding: %s", err)
+	}
+	msg, err := compose(branding, books, since)
+	if err != nil {
+		return 0, fmt.Errorf("Digest - Send - compose: %w", err)
+	}
+
+	var errs []error
+	for _, to := range d.to {
+		msg.To = to
+		if err = d.mailer.Send(ctx, msg); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", to, err))
+		}
+	}
+	if err = errors.Join(errs...); err != nil {
+		return len(books), fmt.Errorf("Digest - Send - d.mailer.Send: %w", err)
+	}
+	return len(books), nil
+}
+
+// Schedule mails the books added in each interval until ctx is done.
+func (d *Digest) Schedule(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			n, err := d.Send(ctx, now.Add(-interval))
+			if err != nil {
+				d.l.Error("Digest - Schedule - d.Send: %s", err)
+				continue
+			}
+			d.l.Info("Digest - Schedule - %d new books", n)
+		}
+	}
+}
+
+// newBooks lists the shared books added since since, newest first.
+func (d *Digest) newBooks(ctx context.Context, since time.Time) ([]entity.Book, error) {
+	filter := library.BookFilter{Restricted: true, HideScheduled: true}
+	var books []entity.Book
+	cursor := ""
+	for {
+		list, err := d.books.ListBooksByCursor(ctx, filter, "created_at", "desc", cursor, digestPageSize)
+		if err != nil {
+			return nil, err
+		}
+		for _, book := range list.Books {
+			if book.CreatedAt.Before(since) {
+				return books, nil
+			}
+			books = append(books, book)
+		}
+		if list.NextCursor == "" {
+			return books, nil
+		}
+		cursor = list.NextCursor
+	}
+}
+
+type digestPage struct {
+	Branding settings.Branding
+	// LogoURL is empty for relative logo URLs, mail clients can not load them
+	LogoURL     string
+	AccentColor string
+	Since       time.Time
+	Books       []entity.Book
+}
+
+func compose(branding settings.Branding, books []entity.Book, since time.Time) (mailer.Message, error) {
+	page := digestPage{Branding: branding, AccentColor: branding.AccentColor, Since: since, Books: books}
+	if strings.HasPrefix(branding.LogoURL, "https://") || strings.HasPrefix(branding.LogoURL, "http://") {
+		page.LogoURL = branding.LogoURL
+	}
+	if page.AccentColor == "" {
+		page.AccentColor = defaultAccentColor
+	}
+
+	var text, html strings.Builder
+	if err := textTemplate.Execute(&text, page); err != nil {
+		return mailer.Message{}, fmt.Errorf("textTemplate.Execute: %w", err)
+	}
+	if err := htmlTemplate.Execute(&html, page); err != nil {
+		return mailer.Message{}, fmt.Errorf("htmlTemplate.Execute: %w", err)
+	}
+	subject := fmt.Sprintf("%s: %d new books", branding.InstanceName, len(books))
+	if len(books) == 1 {
+		subject = fmt.Sprintf("%s: 1 new book", branding.InstanceName)
+	}
+	return mailer.Message{Subject: subject, Body: text.String(), HTML: html.String()}, nil
+}
+
+func date(t time.Time) string { return t.Format("2006-01-02") }
+
+var textTemplate = template.Must(template.New("digest").Funcs(template.FuncMap{"date": date}).Parse(
+	`New in {{ .Branding.InstanceName }} since {{ date .Since }}
+{{ with .Branding.WelcomeText }}
+{{ . }}
+{{ end }}
+{{ range .Books }}- {{ .Title }}{{ with .Author }} by {{ . }}{{ end }}{{ with .Series }} ({{ . }}){{ end }}
+{{ end }}
+Sent by {{ .Branding.InstanceName }}.
+`))
+
+var htmlTemplate = htmltemplate.Must(htmltemplate.New("digest").Funcs(htmltemplate.FuncMap{"date": date}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Branding.InstanceName }}</title>
+</head>
+<body style="font-family: sans-serif; color: #222;">
+<header style="border-bottom: 3px solid {{ .AccentColor }}; padding-bottom: .5em;">
+{{ with .LogoURL }}<img src="{{ . }}" alt="" style="max-height: 48px;">{{ end }}
+<h1 style="font-size: 1.4em; margin: .3em 0;">New in {{ .Branding.InstanceName }}</h1>
+<p style="color: #666; margin: 0;">since {{ date .Since }}</p>
+</header>
+{{ with .Branding.WelcomeText }}<p>{{ . }}</p>{{ end }}
+<ul style="padding-left: 1.2em;">
+{{ range .Books }}<li><strong>{{ .Title }}</strong>{{ with .Author }} by {{ . }}{{ end }}{{ with .Series }} <span style="color: #666;">({{ . }})</span>{{ end }}</li>
+{{ end }}</ul>
+<p style="color: #666; font-size: .9em;">Sent by {{ .Branding.InstanceName }}.</p>
+</body>
+</html>
+`))
@@ -0,0 +1,132 @@
+package digest_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/digest"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/mailer"
+)
+
+func TestDigestSend(t *testing.T) {
+	ctx := context.Background()
+	now := time.Now()
+	books := &fakeBooks{books: []entity.Book{
+		{ID: "new", Title: "Dune <Messiah>", Author: "Frank Herbert", Series: "Dune", CreatedAt: now.Add(-time.Hour)},
+		{ID: "newer", Title: "Piranesi", Author: "Susanna Clarke", CreatedAt: now.Add(-time.Minute)},
+		{ID: "old", Title: "Emma", CreatedAt: now.Add(-30 * 24 * time.Hour)},
+	}}
+	branding := fakeBranding{branding: settings.Branding{
+		InstanceName: "Family Library",
+		LogoURL:      "https://books.example.com/logo.png",
+		AccentColor:  "#aa3300",
+		WelcomeText:  "Happy reading!",
+	}}
+	mails := &fakeMailer{}
+	d := digest.New(books, branding, mails, []string{"a@example.com", "b@example.com"}, logger.New("error"))
+
+	n, err := d.Send(ctx, now.Add(-7*24*time.Hour))
+	if err != nil || n != 2 {
+		t.Fatalf("expected two new books, got %d %v", n, err)
+	}
+	if !books.filter.Restricted || !books.filter.HideScheduled || books.filter.Reader != "" {
+		t.Errorf("expected shared, visible books only, got %+v", books.filter)
+	}
+	if len(mails.sent) != 2 || mails.sent[0].To != "a@example.com" || mails.sent[1].To != "b@example.com" {
+		t.Fatalf("expected a digest for each address, got %+v", mails.sent)
+	}
+	msg := mails.sent[0]
+	if msg.Subject != "Family Library: 2 new books" {
+		t.Errorf("unexpected subject %q", msg.Subject)
+	}
+	for _, want := range []string{"Happy reading!", "- Piranesi by Susanna Clarke", "- Dune <Messiah> by Frank Herbert (Dune)"} {
+		if !strings.Contains(msg.Body, want) {
+			t.Errorf("expected %q in the text, got %q", want, msg.Body)
+		}
+	}
+	if strings.Contains(msg.Body, "Emma") {
+		t.Errorf("expected the old book left out, got %q", msg.Body)
+	}
+	for _, want := range []string{`src="https://books.example.com/logo.png"`, "solid #aa3300", "Dune &lt;Messiah&gt;", "New in Family Library"} {
+		if !strings.Contains(msg.HTML, want) {
+			t.Errorf("expected %q in the html, got %q", want, msg.HTML)
+		}
+	}
+
+	// a relative logo is left out, mail clients can not load it
+	d = digest.New(books, fakeBranding{branding: settings.Branding{InstanceName: "KOmpanion", LogoURL: "/static/logo.png"}}, mails, []string{"a@example.com"}, logger.New("error"))
+	mails.sent = nil
+	if _, err = d.Send(ctx, now.Add(-10*time.Minute)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(mails.sent) != 1 || mails.sent[0].Subject != "KOmpanion: 1 new book" || strings.Contains(mails.sent[0].HTML, "<img") {
+		t.Errorf("unexpected digest %+v", mails.sent)
+	}
+}
+
+func TestDigestWithoutNewBooks(t *testing.T) {
+	books := &fakeBooks{books: []entity.Book{{ID: "old", Title: "Emma", CreatedAt: time.Now().Add(-30 * 24 * time.Hour)}}}
+	mails := &fakeMailer{err: errors.New("smtp down")}
+	d := digest.New(books, fakeBranding{}, mails, []string{"a@example.com"}, logger.New("error"))
+
+	n, err := d.Send(context.Background(), time.Now().Add(-7*24*time.Hour))
+	if err != nil || n != 0 || len(mails.sent) != 0 {
+		t.Errorf("expected no digest, got %d %v %+v", n, err, mails.sent)
+	}
+}
+
+// fakeBooks lists its books newest first, one per page.
+type fakeBooks struct {
+	books  []entity.Book
+	filter library.BookFilter
+}
+
+func (b *fakeBooks) ListBooksByCursor(_ context.Context, filter library.BookFilter, sortBy, sortOrder, cursor string, _ int) (library.PaginatedBookList, error) {
+	b.filter = filter
+	if sortBy != "created_at" || sortOrder != "desc" {
+		return library.PaginatedBookList{}, errors.New("expected the newest books first")
+	}
+	sorted := append([]entity.Book(nil), b.books...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].CreatedAt.After(sorted[j].CreatedAt) })
+	i := 0
+	for i < len(sorted) && cursor != "" && sorted[i].ID != cursor {
+		i++
+	}
+	if cursor != "" {
+		i++
+	}
+	if i >= len(sorted) {
+		return library.PaginatedBookList{}, nil
+	}
+	list := library.PaginatedBookList{Books: sorted[i : i+1]}
+	if i+1 < len(sorted) {
+		list.NextCursor = sorted[i].ID
+	}
+	return list, nil
+}
+
+type fakeBranding struct {
+	branding settings.Branding
+}
+
+func (b fakeBranding) Branding(context.Context) (settings.Branding, error) {
+	return b.branding, nil
+}
+
+type fakeMailer struct {
+	sent []mailer.Message
+	err  error
+}
+
+func (m *fakeMailer) Send(_ context.Context, msg mailer.Message) error {
+	m.sent = append(m.sent, msg)
+	return m.err
+}
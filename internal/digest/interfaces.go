@@ -0,0 +1,27 @@
+package digest
+
+import (
+	"context"
+
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/settings"
+	"github.com/banjuer/kompanion/pkg/mailer"
+)
+
+type (
+	// BookLister is the part of library.Shelf listing the new books.
+	BookLister interface {
+		ListBooksByCursor(ctx context.Context, filter library.BookFilter, sortBy, sortOrder, cursor string, perPage int) (library.PaginatedBookList, error)
+	}
+
+	// BrandingSource - how the instance presents itself, see
+	// settings.InstanceSettings.
+	BrandingSource interface {
+		Branding(ctx context.Context) (settings.Branding, error)
+	}
+
+	// Mailer - delivers the digests, see mailer.SMTP.
+	Mailer interface {
+		Send(ctx context.Context, msg mailer.Message) error
+	}
+)
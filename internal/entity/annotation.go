@@ -0,0 +1,21 @@
+package entity
+
+// Annotation is a KOReader highlight, note or bookmark bound to a document.
+type Annotation struct {
+	Document       string `json:"document"`
+	Kind           string `json:"kind"`
+	Page           int    `json:"page"`
+	Position       string `json:"position"`
+	Chapter        string `json:"chapter"`
+	Text           string `json:"text"`
+	Note           string `json:"note"`
+	Color          string `json:"color"`
+	Timestamp      int64  `json:"timestamp"`
+	AuthDeviceName string `json:"-"`
+}
+
+const (
+	AnnotationHighlight = "highlight"
+	AnnotationNote      = "note"
+	AnnotationBookmark  = "bookmark"
+)
@@ -0,0 +1,15 @@
+package entity
+
+import "time"
+
+// Media types of library books, audiobooks are played instead of read.
+const (
+	MediaTypeBook      = "book"
+	MediaTypeAudiobook = "audiobook"
+)
+
+// Chapter is a named position in an audiobook.
+type Chapter struct {
+	Title string
+	Start time.Duration
+}
@@ -10,6 +10,19 @@ import (
 
 var ErrBookAlreadyExists = errors.New("Book already exists")
 
+var ErrBookNotFound = errors.New("Book not found")
+
+// ErrBookArchived - the book is archived and kept exactly as stored.
+var ErrBookArchived = errors.New("Book is archived")
+
+// Upload limits set by the server configuration.
+var (
+	ErrUploadTooLarge   = errors.New("Book file is larger than uploads may be")
+	ErrFormatNotAllowed = errors.New("Book format is not allowed")
+	ErrBookLimitReached = errors.New("Account has uploaded as many books as it may")
+	ErrQuotaExceeded    = errors.New("Account has no storage left for this file")
+)
+
 // Book represents a book entity in the database.
 type Book struct {
 	ID          string                 // unique identifier for the book
@@ -23,19 +36,61 @@ type Book struct {
 	CreatedAt   time.Time              // timestamp of when the book was created
 	UpdatedAt   time.Time              // timestamp of when the book was last updated
 	ISBN        string                 `form:"isbn"` // ISBN of the book
+	DOI         string                 `form:"doi"`  // DOI of articles and papers
+	Language    string                 `form:"language"` // primary language subtag, e.g. "en"
 	DocumentID  string                 // md5 hash for file content
 	FilePath    string                 // path to the book file
 	Format      string                 // format of the book file
 	CoverPath   string                 // path to the cover image
+	CoverSize   int64                  // size of the cover image in bytes
+	FileSize    int64                  // size of the book file in bytes
+	Pages       int                    // page count, estimated for reflowable formats
+	Rating      float64                // average star rating of all reviews, 0 when unrated
+	RatingCount int                    // number of ratings
+	ArchivedAt  time.Time              // when the book was archived, zero when it is not
+	MediaType   string                 // MediaTypeBook or MediaTypeAudiobook
+	Duration    time.Duration          // playing time of audiobooks
+	Genres      []string               // genres read from the book file
+	UploadedBy  string                 // account that uploaded the book, empty for older books
+	Private     bool                   // only the uploader sees the book
+	VisibleFrom time.Time              // hidden from all but admins before, zero when always visible
+}
+
+// BookFile is another format of a book, like a PDF next to the EPUB the
+// book was uploaded as. Each file has a document id of its own.
+type BookFile struct {
+	BookID     string
+	Format     string
+	FilePath   string
+	DocumentID string
+	FileSize   int64
+	CreatedAt  time.Time
+}
+
+// IsAudiobook reports whether the book is played rather than read.
+func (b Book) IsAudiobook() bool {
+	return b.MediaType == MediaTypeAudiobook
 }
 
-func (b Book) extension() string {
+// Archived books can not be edited, replaced or deleted until they are unarchived.
+func (b Book) Archived() bool {
+	return !b.ArchivedAt.IsZero()
+}
+
+// Scheduled reports whether the book is still hidden at now, waiting for
+// its visible from date.
+func (b Book) Scheduled(now time.Time) bool {
+	return now.Before(b.VisibleFrom)
+}
+
+// Extension returns the file extension without the dot, e.g. "epub".
+func (b Book) Extension() string {
 	tmp := strings.Split(b.FilePath, ".")
 	return tmp[len(tmp)-1]
 }
 
 func (b Book) Filename() string {
-	basename := b.ID + "." + b.extension()
+	basename := b.ID + "." + b.Extension()
 	if len(b.Author) == 0 {
 		return b.Title + " -- " + basename
 	}
@@ -43,7 +98,7 @@ func (b Book) Filename() string {
 }
 
 func (b Book) MimeType() string {
-	switch b.extension() {
+	switch b.Extension() {
 	case "epub":
 		return "application/epub+zip"
 	case "pdf":
@@ -52,7 +107,25 @@ func (b Book) MimeType() string {
 		return "application/x-mobipocket-ebook"
 	case "fb2":
 		return "application/fb2"
+	case "m4b":
+		return "audio/mp4"
+	case "mp3":
+		return "audio/mpeg"
+	case "cbz":
+		return "application/vnd.comicbook+zip"
+	case "cbr":
+		return "application/vnd.comicbook-rar"
+	case "djvu":
+		return "image/vnd.djvu"
 	default:
 		return ""
 	}
 }
+
+// ETag identifies the file content by its partial MD5, metadata edits keep it.
+func (b Book) ETag() string {
+	if b.DocumentID == "" {
+		return ""
+	}
+	return `"` + b.DocumentID + `"`
+}
@@ -0,0 +1,86 @@
+package entity
+
+import (
+	"errors"
+	"strings"
+	"time"
+)
+
+// MaxCollectionEntries is the most books a collection holds.
+const MaxCollectionEntries = 500
+
+var (
+	ErrCollectionNotFound = errors.New("collection not found")
+	ErrInvalidCollection  = errors.New("collection needs a name, each book once and prerequisites listed before the book")
+)
+
+// Collection is a reading path through books of the library, like a course
+// or a curated list, read in the order of its entries.
+type Collection struct {
+	ID          string
+	Name        string
+	Description string
+	Owner       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	// FeedPrefix starts the token of the shared OPDS feed of the collection,
+	// empty when it is not shared.
+	FeedPrefix string
+	Entries    []CollectionEntry
+}
+
+// CollectionEntry is a book at its place in a collection. Prerequisites are
+// the ids of books before it in the collection to read first.
+type CollectionEntry struct {
+	Book          Book
+	Note          string
+	Prerequisites []string
+	// Status is the reading status of the account looking at the collection.
+	Status ReadingStatus
+}
+
+// Validate checks the name, that a book is listed once and that
+// prerequisites come before the entry needing them.
+func (c Collection) Validate() error {
+	if strings.TrimSpace(c.Name) == "" || len(c.Entries) > MaxCollectionEntries {
+		return ErrInvalidCollection
+	}
+	seen := make(map[string]bool, len(c.Entries))
+	for _, entry := range c.Entries {
+		if entry.Book.ID == "" || seen[entry.Book.ID] {
+			return ErrInvalidCollection
+		}
+		for _, id := range entry.Prerequisites {
+			if !seen[id] {
+				return ErrInvalidCollection
+			}
+		}
+		seen[entry.Book.ID] = true
+	}
+	return nil
+}
+
+// Next returns the first entry in order not finished yet, false when all
+// are. Its prerequisites come before it and are finished.
+func (c Collection) Next() (CollectionEntry, bool) {
+	for _, entry := range c.Entries {
+		if entry.Status != StatusFinished {
+			return entry, true
+		}
+	}
+	return CollectionEntry{}, false
+}
+
+// Available reports whether all prerequisites of the entry are finished.
+func (c Collection) Available(entry CollectionEntry) bool {
+	finished := make(map[string]bool, len(c.Entries))
+	for _, e := range c.Entries {
+		finished[e.Book.ID] = e.Status == StatusFinished
+	}
+	for _, id := range entry.Prerequisites {
+		if !finished[id] {
+			return false
+		}
+	}
+	return true
+}
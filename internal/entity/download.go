@@ -0,0 +1,28 @@
+package entity
+
+import "time"
+
+// Download clients, where a book file was fetched.
+const (
+	ClientWeb    = "web"
+	ClientOPDS   = "opds"
+	ClientWebDAV = "webdav"
+)
+
+// Download is one download of a book file, by an account or by a device.
+type Download struct {
+	BookID       string
+	Username     string
+	DeviceName   string
+	Client       string
+	DownloadedAt time.Time
+}
+
+// DownloadedBook sums up the downloads of one book.
+type DownloadedBook struct {
+	Book             Book
+	Downloads        int
+	LastDownloadedAt time.Time
+	// Opened is true once KOReader synced progress or statistics for the file.
+	Opened bool
+}
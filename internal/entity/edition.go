@@ -0,0 +1,37 @@
+package entity
+
+import "errors"
+
+var ErrInvalidEditionRelation = errors.New("invalid edition relation")
+
+// ErrSameEdition - a book is not an edition of itself.
+var ErrSameEdition = errors.New("a book can not be linked to itself")
+
+// EditionRelation is how a book differs from the other books of its work.
+type EditionRelation string
+
+const (
+	RelationEdition     EditionRelation = "edition"
+	RelationTranslation EditionRelation = "translation"
+	RelationFormat      EditionRelation = "format"
+)
+
+// ParseEditionRelation validates user input, empty means another edition.
+func ParseEditionRelation(s string) (EditionRelation, error) {
+	switch relation := EditionRelation(s); relation {
+	case "":
+		return RelationEdition, nil
+	case RelationEdition, RelationTranslation, RelationFormat:
+		return relation, nil
+	default:
+		return "", ErrInvalidEditionRelation
+	}
+}
+
+// Edition is another book of the same work: an edition, a translation or
+// the same text in another format.
+type Edition struct {
+	Book     Book
+	WorkID   string
+	Relation EditionRelation
+}
@@ -0,0 +1,28 @@
+package entity
+
+import "time"
+
+// States of an upload in the ingest log.
+const (
+	// IngestWritten - the files are in storage, the book is being stored.
+	IngestWritten = "written"
+	// IngestCompensated - storing the book failed and its files were removed.
+	IngestCompensated = "compensated"
+	// IngestFailed - storing the book failed and so did removing its files.
+	IngestFailed = "failed"
+)
+
+// IngestEntry is an upload whose files were written before the book was
+// stored. Entries of stored books are removed, the rest tell what failed
+// and what is left in storage.
+type IngestEntry struct {
+	ID         string
+	BookID     string
+	DocumentID string
+	FilePath   string
+	CoverPath  string
+	State      string
+	Detail     string
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+}
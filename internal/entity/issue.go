@@ -0,0 +1,30 @@
+package entity
+
+import "time"
+
+// Kinds of problems an integrity check finds with a book.
+const (
+	IssueMissingFile    = "missing_file"
+	IssueUnreadableFile = "unreadable_file"
+	// IssueHashMismatch - the file no longer has its KOReader partial md5.
+	IssueHashMismatch = "hash_mismatch"
+	IssueMissingCover = "missing_cover"
+)
+
+// BookIssue is a problem with the stored files of a book.
+type BookIssue struct {
+	BookID     string
+	Title      string
+	Kind       string
+	Detail     string
+	DetectedAt time.Time
+	CheckedAt  time.Time
+}
+
+// LibraryCheck is one integrity check over the whole library.
+type LibraryCheck struct {
+	StartedAt  time.Time
+	FinishedAt time.Time
+	Books      int
+	Issues     int
+}
@@ -0,0 +1,37 @@
+package entity
+
+import "time"
+
+// Kinds of background jobs.
+const (
+	// JobConvert - convert the book to Target and add it as another file.
+	JobConvert = "convert"
+)
+
+// States of a background job, done jobs are removed.
+const (
+	// JobQueued - waiting for a worker, not before RunAt.
+	JobQueued = "queued"
+	// JobRunning - claimed by Worker, which sends heartbeats while it runs.
+	JobRunning = "running"
+	// JobFailed - gave up after the last attempt, Detail tells why.
+	JobFailed = "failed"
+)
+
+// Job is background work the servers sharing the database take turns on.
+// A running job whose worker stopped sending heartbeats for the visibility
+// timeout is claimed again by another worker.
+type Job struct {
+	ID          int64
+	Kind        string
+	BookID      string
+	Target      string
+	State       string
+	Attempts    int
+	Worker      string
+	Detail      string
+	RunAt       time.Time
+	HeartbeatAt *time.Time
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
@@ -0,0 +1,30 @@
+package entity
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrLoanNotFound = errors.New("loan not found")
+	ErrInvalidLoan  = errors.New("books are lent to another account for 1 to 90 days")
+	ErrLoanShared   = errors.New("only private books are lent, shared ones are in every library")
+)
+
+// Loan lets another account see a private book until it expires or either
+// account ends it.
+type Loan struct {
+	ID        string     `json:"id"`
+	BookID    string     `json:"book_id"`
+	Title     string     `json:"title"`
+	Lender    string     `json:"lender"`   // uploader of the book
+	Borrower  string     `json:"borrower"` // account the book is lent to
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt time.Time  `json:"expires_at"`
+	EndedAt   *time.Time `json:"ended_at,omitempty"`
+}
+
+// Active reports whether the borrower sees the book at now.
+func (l Loan) Active(now time.Time) bool {
+	return l.EndedAt == nil && now.Before(l.ExpiresAt)
+}
@@ -0,0 +1,52 @@
+package entity
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+var ErrInvalidMetadataRule = errors.New("invalid metadata rule")
+
+// MetadataRuleFields are the book fields rules read and write.
+var MetadataRuleFields = []string{"title", "author", "publisher", "series", "series_index", "language", "isbn"}
+
+// MetadataRule rewrites the metadata of uploaded books before they are
+// stored. Without a target the matches of Pattern in Field are replaced
+// with Replace, e.g. Pattern `\s*\[retail\]` and an empty Replace strip a
+// tag from titles. With a target, a Field matching Pattern sets Target to
+// Replace, which refers to the groups of the match like ${1} or ${name}:
+// title `^(?P<series>.+) #(?P<n>\d+):` sets series to ${series}.
+type MetadataRule struct {
+	Field   string `json:"field"`
+	Pattern string `json:"pattern"`
+	Replace string `json:"replace"`
+	Target  string `json:"target,omitempty"`
+}
+
+// Compile checks the fields of the rule and compiles its pattern.
+func (r MetadataRule) Compile() (*regexp.Regexp, error) {
+	if !isMetadataRuleField(r.Field) {
+		return nil, fmt.Errorf("%w: unknown field %q", ErrInvalidMetadataRule, r.Field)
+	}
+	if r.Target != "" && !isMetadataRuleField(r.Target) {
+		return nil, fmt.Errorf("%w: unknown target %q", ErrInvalidMetadataRule, r.Target)
+	}
+	if r.Pattern == "" {
+		return nil, fmt.Errorf("%w: empty pattern", ErrInvalidMetadataRule)
+	}
+	re, err := regexp.Compile(r.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMetadataRule, err)
+	}
+	return re, nil
+}
+
+func isMetadataRuleField(name string) bool {
+	for _, field := range MetadataRuleFields {
+		if field == name {
+			return true
+		}
+	}
+	return false
+}
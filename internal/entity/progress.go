@@ -1,5 +1,12 @@
 package entity
 
+import (
+	"errors"
+	"time"
+)
+
+var ErrProgressConflictNotFound = errors.New("progress conflict not found")
+
 // Progress -.
 type Progress struct {
 	Document       string  `json:"document"`
@@ -10,3 +17,13 @@ type Progress struct {
 	Timestamp      int64   `json:"timestamp"`
 	AuthDeviceName string
 }
+
+// ProgressConflict - a device pushed a position behind the latest one of
+// another device. Devices get Ahead until the user picks one of them.
+type ProgressConflict struct {
+	ID        int64
+	Document  string
+	Ahead     Progress
+	Behind    Progress
+	CreatedAt time.Time
+}
@@ -0,0 +1,36 @@
+package entity
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidReadingStatus = errors.New("invalid reading status")
+
+// ReadingStatus is where one reader is with a book.
+type ReadingStatus string
+
+const (
+	StatusToRead   ReadingStatus = "to_read"
+	StatusReading  ReadingStatus = "reading"
+	StatusFinished ReadingStatus = "finished"
+)
+
+// ParseReadingStatus validates user input, empty means no status.
+func ParseReadingStatus(s string) (ReadingStatus, error) {
+	switch status := ReadingStatus(s); status {
+	case "", StatusToRead, StatusReading, StatusFinished:
+		return status, nil
+	default:
+		return "", ErrInvalidReadingStatus
+	}
+}
+
+// BookStatus is the reading status of a book for one user.
+type BookStatus struct {
+	Username   string
+	BookID     string
+	Status     ReadingStatus // empty when the user has not set one
+	FinishedAt *time.Time    // set while the status is finished
+	UpdatedAt  time.Time
+}
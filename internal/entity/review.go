@@ -0,0 +1,18 @@
+package entity
+
+import (
+	"errors"
+	"time"
+)
+
+var ErrInvalidRating = errors.New("rating must be between 1 and 5")
+
+// Review is one user's rating and text about a book, either may be empty.
+type Review struct {
+	Username  string    `json:"username"`
+	BookID    string    `json:"book_id"`
+	Rating    int       `json:"rating,omitempty"` // 1-5 stars, 0 when not rated
+	Text      string    `json:"review,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,74 @@
+package entity
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+var ErrInvalidRole = errors.New("role must be admin, editor or reader")
+
+// ErrForbidden is returned to accounts whose role does not allow a change.
+var ErrForbidden = errors.New("not allowed for the role of the account")
+
+// Role of a web account. Readers download books and keep their shelves,
+// reviews and progress; editors also upload, edit and delete books; admins
+// also manage accounts, settings and backups.
+type Role string
+
+const (
+	RoleAdmin  Role = "admin"
+	RoleEditor Role = "editor"
+	RoleReader Role = "reader"
+)
+
+func ParseRole(s string) (Role, error) {
+	switch role := Role(s); role {
+	case RoleAdmin, RoleEditor, RoleReader:
+		return role, nil
+	}
+	return "", fmt.Errorf("%w: %q", ErrInvalidRole, s)
+}
+
+// CanEdit reports whether the role may change the library.
+func (r Role) CanEdit() bool {
+	return r == RoleAdmin || r == RoleEditor
+}
+
+// CanManage reports whether the role may manage accounts and the instance.
+func (r Role) CanManage() bool {
+	return r == RoleAdmin
+}
+
+type roleKey struct{}
+
+// WithRole sets the role of the account making the request. Calls without
+// a role, from the command line and background jobs, are not limited.
+func WithRole(ctx context.Context, role Role) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFrom returns the role of the account making the request, false when
+// the call is not made for an account.
+func RoleFrom(ctx context.Context) (Role, bool) {
+	role, ok := ctx.Value(roleKey{}).(Role)
+	return role, ok
+}
+
+// RequireEditor returns ErrForbidden unless the account may change the
+// library.
+func RequireEditor(ctx context.Context) error {
+	if role, ok := RoleFrom(ctx); ok && !role.CanEdit() {
+		return ErrForbidden
+	}
+	return nil
+}
+
+// RequireAdmin returns ErrForbidden unless the account may manage accounts
+// and the instance.
+func RequireAdmin(ctx context.Context) error {
+	if role, ok := RoleFrom(ctx); ok && !role.CanManage() {
+		return ErrForbidden
+	}
+	return nil
+}
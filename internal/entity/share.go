@@ -0,0 +1,41 @@
+package entity
+
+import (
+	"errors"
+	"time"
+)
+
+var (
+	ErrShareLinkNotFound = errors.New("share link not found")
+	ErrShareLinkExpired  = errors.New("share link expired")
+	ErrShareLinkUsedUp   = errors.New("share link was downloaded as often as it may")
+	ErrInvalidShareLink  = errors.New("share link must expire within 30 days and downloads can not be negative")
+)
+
+// ShareLink lets anyone holding its token download a book without an
+// account. Only a hash of the token is stored, the token is shown once.
+type ShareLink struct {
+	ID       string `json:"id"`
+	BookID   string `json:"book_id"`
+	Username string `json:"username"` // account that created the link
+	Prefix   string `json:"prefix"`   // start of the token to tell links apart
+	// MaxDownloads is how often the book may be downloaded, 0 does not limit
+	MaxDownloads int        `json:"max_downloads"`
+	Downloads    int        `json:"downloads"`
+	ExpiresAt    time.Time  `json:"expires_at"`
+	CreatedAt    time.Time  `json:"created_at"`
+	RevokedAt    *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Usable returns why the link can not be downloaded at now, nil when it can.
+func (l ShareLink) Usable(now time.Time) error {
+	switch {
+	case l.RevokedAt != nil:
+		return ErrShareLinkNotFound
+	case !now.Before(l.ExpiresAt):
+		return ErrShareLinkExpired
+	case l.MaxDownloads > 0 && l.Downloads >= l.MaxDownloads:
+		return ErrShareLinkUsedUp
+	}
+	return nil
+}
@@ -0,0 +1,27 @@
+package entity
+
+// StorageUsage is what the books an account uploaded take in storage.
+// Covers shared by several books count for each of them.
+type StorageUsage struct {
+	Books       int
+	FileBytes   int64 // the files the books were uploaded as
+	FormatBytes int64 // other formats added to the books
+	CoverBytes  int64
+	Quota       int64 // bytes the account may store, 0 without a quota
+}
+
+// Bytes is the storage used in total.
+func (u StorageUsage) Bytes() int64 {
+	return u.FileBytes + u.FormatBytes + u.CoverBytes
+}
+
+// Left is the storage left under the quota, -1 without a quota.
+func (u StorageUsage) Left() int64 {
+	if u.Quota <= 0 {
+		return -1
+	}
+	if left := u.Quota - u.Bytes(); left > 0 {
+		return left
+	}
+	return 0
+}
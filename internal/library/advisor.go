@@ -0,0 +1,206 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+var ErrConversionsRunning = errors.New("conversions are already running")
+
+// DefaultReaderFormats are the formats a device without formats of its own
+// reads well, KOReader on a 6" e-reader: reflowable text and comics shown
+// page by page. PDF and DjVu pages shrink to an unreadable size.
+var DefaultReaderFormats = []string{"epub", "fb2", "cbz", "cbr"}
+
+// conversionTargets are the formats books can be converted to and stored
+// as another file of the book, preferred first.
+var conversionTargets = []string{"epub", "fb2"}
+
+// FormatReport is the file format statistics of the library with the books
+// the devices of the library read none of the files of well.
+type FormatReport struct {
+	// Formats counts the books with a file of each format
+	Formats []FacetCount
+	Books   []FormatAdvice
+}
+
+// FormatAdvice is a book some devices can not read well.
+type FormatAdvice struct {
+	Book    entity.Book
+	Formats []string // the formats of the files of the book
+	Devices []string // the devices reading none of them well
+	// Target is the format to convert the book to for most of the devices,
+	// empty when they read none of the formats books are converted to
+	Target string
+}
+
+// FormatReport walks the books the reader of the request may see and
+// advises conversions for devices, device names with the formats they read
+// well. Devices without formats get DefaultReaderFormats. Audiobooks are
+// left out.
+func (uc *BookShelf) FormatReport(ctx context.Context, devices map[string][]string) (FormatReport, error) {
+	names := make([]string, 0, len(devices))
+	reads := make(map[string]map[string]bool, len(devices))
+	for name, formats := range devices {
+		if len(formats) == 0 {
+			formats = DefaultReaderFormats
+		}
+		reads[name] = make(map[string]bool, len(formats))
+		for _, format := range formats {
+			reads[name][strings.ToLower(format)] = true
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var report FormatReport
+	counts := make(map[string]int)
+	err := uc.forEachBook(ctx, func(book entity.Book) error {
+		if book.IsAudiobook() {
+			return nil
+		}
+		files, err := uc.repo.ListFiles(ctx, book.ID)
+		if err != nil {
+			return fmt.Errorf("s.repo.ListFiles: %w", err)
+		}
+		formats := []string{strings.ToLower(book.Extension())}
+		for _, file := range files {
+			formats = append(formats, file.Format)
+		}
+		for _, format := range formats {
+			counts[format]++
+		}
+
+		advice := FormatAdvice{Book: book, Formats: formats}
+		for _, name := range names {
+			if !readsAny(reads[name], formats) {
+				advice.Devices = append(advice.Devices, name)
+			}
+		}
+		if len(advice.Devices) > 0 {
+			advice.Target = conversionTarget(reads, advice.Devices)
+			report.Books = append(report.Books, advice)
+		}
+		return nil
+	})
+	if err != nil {
+		return FormatReport{}, fmt.Errorf("BookShelf - FormatReport - %w", err)
+	}
+	report.Formats = sortedCounts(counts)
+	sort.SliceStable(report.Formats, func(i, j int) bool { return report.Formats[i].Count > report.Formats[j].Count })
+	return report, nil
+}
+
+func readsAny(reads map[string]bool, formats []string) bool {
+	for _, format := range formats {
+		if reads[format] {
+			return true
+		}
+	}
+	return false
+}
+
+// conversionTarget picks the conversion target most of the devices read.
+func conversionTarget(reads map[string]map[string]bool, devices []string) string {
+	target, most := "", 0
+	for _, format := range conversionTargets {
+		n := 0
+		for _, device := range devices {
+			if reads[device][format] {
+				n++
+			}
+		}
+		if n > most {
+			target, most = format, n
+		}
+	}
+	return target
+}
+
+// StartConversions converts the books of the report that have a target in
+// the background and stores the results as another file of each book. It
+// returns the number of books queued. Failed conversions are logged, the
+// next report lists their books again. With a job queue the conversions are
+// jobs for the workers of all servers, books queued already are left out.
+func (uc *BookShelf) StartConversions(ctx context.Context, report FormatReport) (int, error) {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return 0, fmt.Errorf("BookShelf - StartConversions - %w", err)
+	}
+	if uc.converter == nil {
+		return 0, fmt.Errorf("BookShelf - StartConversions - %w", ErrNoConverter)
+	}
+	var queue []FormatAdvice
+	for _, advice := range report.Books {
+		if advice.Target != "" && !advice.Book.Archived() {
+			queue = append(queue, advice)
+		}
+	}
+	if len(queue) == 0 {
+		return 0, nil
+	}
+	if uc.queue != nil {
+		return uc.enqueueConversions(ctx, queue)
+	}
+	if !uc.converting.CompareAndSwap(false, true) {
+		return 0, ErrConversionsRunning
+	}
+	go func() {
+		defer uc.converting.Store(false)
+		ctx := context.WithoutCancel(ctx)
+		for _, advice := range queue {
+			if err := uc.convertBook(ctx, advice.Book.ID, advice.Target); err != nil {
+				uc.logger.Error("BookShelf - StartConversions - %s to %s: %s", advice.Book.ID, advice.Target, err)
+			}
+		}
+		uc.logger.Info("BookShelf - StartConversions - converted %d books", len(queue))
+	}()
+	return len(queue), nil
+}
+
+// enqueueConversions queues a convert job for each book.
+func (uc *BookShelf) enqueueConversions(ctx context.Context, queue []FormatAdvice) (int, error) {
+	queued := 0
+	for _, advice := range queue {
+		ok, err := uc.queue.EnqueueJob(ctx, entity.Job{Kind: entity.JobConvert, BookID: advice.Book.ID, Target: advice.Target})
+		if err != nil {
+			return queued, fmt.Errorf("BookShelf - StartConversions - uc.queue.EnqueueJob: %w", err)
+		}
+		if ok {
+			queued++
+		}
+	}
+	return queued, nil
+}
+
+// convertBook converts the file the book was uploaded as to format and
+// adds it to the book.
+func (uc *BookShelf) convertBook(ctx context.Context, bookID, format string) error {
+	// not a download, hooks and metrics are left out
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return fmt.Errorf("s.getBook: %w", err)
+	}
+	file, err := uc.storage.Open(ctx, book.FilePath)
+	if err != nil {
+		return fmt.Errorf("s.storage.Open: %w", err)
+	}
+	path, err := uc.convertTo(ctx, file, strings.ToLower(book.Extension()), format)
+	file.Close()
+	if err != nil {
+		return err
+	}
+	defer os.Remove(path)
+	converted, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("os.Open: %w", err)
+	}
+	defer converted.Close()
+	_, err = uc.AddBookFile(ctx, bookID, converted)
+	return err
+}
@@ -0,0 +1,75 @@
+package library_test
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func TestFormatReport(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	repo := library.NewMemoryBookRepo()
+	shelf := library.NewBookShelf(store, repo, logger.New("error"))
+	shelf.SetConverter(fakeConverter{content: `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>Manual</book-title></title-info></description><body><p>converted</p></body></FictionBook>`})
+
+	for _, book := range []entity.Book{
+		{ID: "manual", Title: "Manual", FilePath: "manual.pdf", DocumentID: "pdf"},
+		{ID: "novel", Title: "Novel", FilePath: "novel.fb2", DocumentID: "fb2"},
+		{ID: "audio", Title: "Audio", FilePath: "audio.m4b", DocumentID: "m4b", MediaType: entity.MediaTypeAudiobook},
+	} {
+		book.CreatedAt = time.Now()
+		if err := repo.Store(ctx, book); err != nil {
+			t.Fatal(err)
+		}
+		if err := store.Put(ctx, book.FilePath, strings.NewReader("%PDF-1.4 "+book.ID)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	devices := map[string][]string{"kobo": nil, "pocketbook": {"fb2", "djvu"}}
+	report, err := shelf.FormatReport(ctx, devices)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(report.Formats) != "[{fb2 1} {pdf 1}]" {
+		t.Errorf("expected a pdf and an fb2 book, got %v", report.Formats)
+	}
+	if len(report.Books) != 1 {
+		t.Fatalf("expected the pdf book only, got %+v", report.Books)
+	}
+	advice := report.Books[0]
+	if advice.Book.ID != "manual" || fmt.Sprint(advice.Devices) != "[kobo pocketbook]" || advice.Target != "fb2" {
+		t.Errorf("expected the manual to be converted to fb2 for both devices, got %+v", advice)
+	}
+
+	queued, err := shelf.StartConversions(ctx, report)
+	if err != nil || queued != 1 {
+		t.Fatalf("expected one conversion queued, got %d %v", queued, err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		report, err = shelf.FormatReport(ctx, devices)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if len(report.Books) == 0 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the manual converted, still advised %+v", report.Books)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if fmt.Sprint(report.Formats) != "[{fb2 2} {pdf 1}]" {
+		t.Errorf("expected the converted file counted, got %v", report.Formats)
+	}
+}
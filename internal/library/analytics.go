@@ -0,0 +1,71 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/tracing"
+)
+
+// statsLimit is the most authors, publishers, formats and languages
+// LibraryStats returns, the most common ones.
+const statsLimit = 20
+
+// LibraryStats counts the books of the library for a dashboard. Authors,
+// publishers, formats and languages are sorted by count, at most the top
+// values; years and months, as 2006-01 by when books were added, are all
+// returned oldest first.
+type LibraryStats struct {
+	Usage      entity.StorageUsage // Books counts them all
+	Authors    []FacetCount
+	Publishers []FacetCount
+	Formats    []FacetCount
+	Languages  []FacetCount
+	Years      []FacetCount
+	Months     []FacetCount
+}
+
+func (s *LibraryStats) add(stat, value string, count, top int) {
+	var values *[]FacetCount
+	switch stat {
+	case "author":
+		values = &s.Authors
+	case "publisher":
+		values = &s.Publishers
+	case "format":
+		values = &s.Formats
+	case "language":
+		values = &s.Languages
+	case "year":
+		values = &s.Years
+	case "month":
+		values = &s.Months
+	default:
+		return
+	}
+	if stat == "year" || stat == "month" || len(*values) < top {
+		*values = append(*values, FacetCount{Value: value, Count: count})
+	}
+}
+
+// sortDates puts years and months oldest first, repos count them by count
+// like the other values.
+func (s *LibraryStats) sortDates() {
+	for _, values := range [][]FacetCount{s.Years, s.Months} {
+		sort.Slice(values, func(i, j int) bool { return values[i].Value < values[j].Value })
+	}
+}
+
+// LibraryStats counts the books the reader of the request may see.
+func (uc *BookShelf) LibraryStats(ctx context.Context) (LibraryStats, error) {
+	ctx, span := tracing.Start(ctx, "BookShelf.LibraryStats")
+	defer span.End()
+	stats, err := uc.repo.Analytics(ctx, BookFilter{}.forReader(ctx), statsLimit)
+	if err != nil {
+		return LibraryStats{}, fmt.Errorf("BookShelf - LibraryStats - s.repo.Analytics: %w", err)
+	}
+	stats.sortDates()
+	return stats, nil
+}
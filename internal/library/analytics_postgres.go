@@ -0,0 +1,65 @@
+package library
+
+import (
+	"context"
+	"fmt"
+)
+
+// Analytics counts the books matching filter for LibraryStats, authors and
+// publishers by their most common spelling like Facets.
+func (bdr *BookDatabaseRepo) Analytics(ctx context.Context, filter BookFilter, top int) (LibraryStats, error) {
+	conditions, args := bookConditions(postgresDialect, "", filter)
+	matched := `
+		WITH matched AS (
+			SELECT id, author, publisher, year, language, storage_file_path, file_size, cover_size, created_at
+			FROM library_book
+			` + whereSQL(conditions) + `
+		)`
+
+	var stats LibraryStats
+	err := bdr.Conn(ctx).QueryRow(ctx, matched+`
+		SELECT count(*), COALESCE(sum(m.file_size), 0)::bigint, COALESCE(sum(f.bytes), 0)::bigint, COALESCE(sum(m.cover_size), 0)::bigint
+		FROM matched m
+		LEFT JOIN (SELECT book_id, sum(file_size) AS bytes FROM library_book_file GROUP BY book_id) f ON f.book_id = m.id
+	`, args...).Scan(&stats.Usage.Books, &stats.Usage.FileBytes, &stats.Usage.FormatBytes, &stats.Usage.CoverBytes)
+	if err != nil {
+		return LibraryStats{}, fmt.Errorf("BookDatabaseRepo - Analytics - r.Pool.QueryRow: %w", err)
+	}
+
+	rows, err := bdr.Conn(ctx).Query(ctx, matched+`
+		SELECT 'author', mode() WITHIN GROUP (ORDER BY author), count(*)
+		FROM matched WHERE COALESCE(author, '') <> '' GROUP BY library_fold(author)
+		UNION ALL
+		SELECT 'publisher', mode() WITHIN GROUP (ORDER BY publisher), count(*)
+		FROM matched WHERE COALESCE(publisher, '') <> '' GROUP BY library_fold(publisher)
+		UNION ALL
+		SELECT 'format', format, count(DISTINCT id) FROM (
+			SELECT id, lower(substring(storage_file_path from '\.([^./]+)$')) AS format FROM matched
+			UNION SELECT f.book_id, f.format FROM library_book_file f JOIN matched m ON m.id = f.book_id
+		) formats WHERE format IS NOT NULL GROUP BY format
+		UNION ALL
+		SELECT 'language', language, count(*) FROM matched WHERE COALESCE(language, '') <> '' GROUP BY language
+		UNION ALL
+		SELECT 'year', year::text, count(*) FROM matched WHERE year > 0 GROUP BY year
+		UNION ALL
+		SELECT 'month', to_char(created_at AT TIME ZONE 'UTC', 'YYYY-MM'), count(*) FROM matched GROUP BY 2
+		ORDER BY 1, 3 DESC, 2
+	`, args...)
+	if err != nil {
+		return LibraryStats{}, fmt.Errorf("BookDatabaseRepo - Analytics - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stat, value string
+		var count int
+		if err = rows.Scan(&stat, &value, &count); err != nil {
+			return LibraryStats{}, fmt.Errorf("BookDatabaseRepo - Analytics - rows.Scan: %w", err)
+		}
+		stats.add(stat, value, count, top)
+	}
+	if err = rows.Err(); err != nil {
+		return LibraryStats{}, fmt.Errorf("BookDatabaseRepo - Analytics - rows.Err: %w", err)
+	}
+	return stats, nil
+}
@@ -0,0 +1,72 @@
+package library
+
+import (
+	"context"
+	"fmt"
+)
+
+// Analytics counts like BookDatabaseRepo.Analytics, the most common
+// spelling of authors and publishers is picked with a window like Facets.
+func (bsr *BookSQLiteRepo) Analytics(ctx context.Context, filter BookFilter, top int) (LibraryStats, error) {
+	conditions, args := bookConditions(sqliteDialect, "", filter)
+	matched := `
+		WITH matched AS (
+			SELECT id, author, publisher, year, language, storage_file_path, file_size, cover_size, created_at
+			FROM library_book
+			` + whereSQL(conditions) + `
+		)`
+
+	var stats LibraryStats
+	err := bsr.Conn(ctx).QueryRow(ctx, matched+`
+		SELECT count(*), COALESCE(sum(m.file_size), 0), COALESCE(sum(f.bytes), 0), COALESCE(sum(m.cover_size), 0)
+		FROM matched m
+		LEFT JOIN (SELECT book_id, sum(file_size) AS bytes FROM library_book_file GROUP BY book_id) f ON f.book_id = m.id
+	`, args...).Scan(&stats.Usage.Books, &stats.Usage.FileBytes, &stats.Usage.FormatBytes, &stats.Usage.CoverBytes)
+	if err != nil {
+		return LibraryStats{}, fmt.Errorf("BookSQLiteRepo - Analytics - r.DB.QueryRow: %w", err)
+	}
+
+	rows, err := bsr.Conn(ctx).Query(ctx, matched+`, names AS (
+			SELECT 'author' AS stat, author AS name FROM matched WHERE COALESCE(author, '') <> ''
+			UNION ALL
+			SELECT 'publisher', publisher FROM matched WHERE COALESCE(publisher, '') <> ''
+		), spellings AS (
+			SELECT stat, lower(unaccent(name)) AS folded, name, count(*) AS n
+			FROM names GROUP BY stat, folded, name
+		), ranked AS (
+			SELECT stat, name, sum(n) OVER (PARTITION BY stat, folded) AS total,
+				row_number() OVER (PARTITION BY stat, folded ORDER BY n DESC, name) AS rank
+			FROM spellings
+		)
+		SELECT stat, name, total FROM ranked WHERE rank = 1
+		UNION ALL
+		SELECT 'format', format, count(DISTINCT id) FROM (
+			SELECT id, lower(regexp_replace(storage_file_path, '^.*\.([^./]+)$|^.*$', '$1', '')) AS format FROM matched
+			UNION SELECT f.book_id, f.format FROM library_book_file f JOIN matched m ON m.id = f.book_id
+		) formats WHERE format <> '' GROUP BY format
+		UNION ALL
+		SELECT 'language', language, count(*) FROM matched WHERE COALESCE(language, '') <> '' GROUP BY language
+		UNION ALL
+		SELECT 'year', CAST(year AS TEXT), count(*) FROM matched WHERE year > 0 GROUP BY year
+		UNION ALL
+		SELECT 'month', substr(created_at, 1, 7), count(*) FROM matched GROUP BY 2
+		ORDER BY 1, 3 DESC, 2
+	`, args...)
+	if err != nil {
+		return LibraryStats{}, fmt.Errorf("BookSQLiteRepo - Analytics - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var stat, value string
+		var count int
+		if err = rows.Scan(&stat, &value, &count); err != nil {
+			return LibraryStats{}, fmt.Errorf("BookSQLiteRepo - Analytics - rows.Scan: %w", err)
+		}
+		stats.add(stat, value, count, top)
+	}
+	if err = rows.Err(); err != nil {
+		return LibraryStats{}, fmt.Errorf("BookSQLiteRepo - Analytics - rows.Err: %w", err)
+	}
+	return stats, nil
+}
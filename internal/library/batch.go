@@ -0,0 +1,116 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// batchUpdateMax is the most books one batch edit changes.
+const batchUpdateMax = 1000
+
+var ErrInvalidPatch = errors.New("metadata patch must change something and name 1 to 1000 books")
+
+// MetadataPatch is one change made to many books at once, see
+// BookShelf.BatchUpdateMetadata. Empty fields are left alone.
+type MetadataPatch struct {
+	Publisher string
+	Series    string
+	Language  string
+	Year      int
+	// RenameAuthor replaces the author named like From, ignoring case,
+	// by To, other authors of a book stay as they are.
+	RenameAuthor struct {
+		From string
+		To   string
+	}
+	// AddGenres and RemoveGenres edit the genre list, which works as the
+	// tags of the library.
+	AddGenres    []string
+	RemoveGenres []string
+}
+
+func (p MetadataPatch) empty() bool {
+	return p.Publisher == "" && p.Series == "" && p.Language == "" && p.Year == 0 &&
+		(p.RenameAuthor.From == "" || p.RenameAuthor.To == "") &&
+		len(p.AddGenres) == 0 && len(p.RemoveGenres) == 0
+}
+
+// apply returns the book with the patch applied.
+func (p MetadataPatch) apply(book entity.Book, now time.Time) entity.Book {
+	if p.Publisher != "" {
+		book.Publisher = p.Publisher
+	}
+	if p.Series != "" {
+		book.Series = p.Series
+	}
+	if p.Language != "" {
+		book.Language = normalizeLanguage(p.Language)
+	}
+	if p.Year != 0 {
+		book.Year = p.Year
+	}
+	if from, to := strings.TrimSpace(p.RenameAuthor.From), strings.TrimSpace(p.RenameAuthor.To); from != "" && to != "" {
+		for _, name := range splitAuthors(book.Author) {
+			if strings.EqualFold(name, from) {
+				book.Author = strings.Replace(book.Author, name, to, 1)
+			}
+		}
+	}
+
+	genres := slices.DeleteFunc(slices.Clone(book.Genres), func(genre string) bool {
+		return slices.ContainsFunc(p.RemoveGenres, func(remove string) bool {
+			return strings.EqualFold(genre, strings.TrimSpace(remove))
+		})
+	})
+	for _, genre := range p.AddGenres {
+		genre = strings.TrimSpace(genre)
+		if genre != "" && !slices.ContainsFunc(genres, func(g string) bool { return strings.EqualFold(g, genre) }) {
+			genres = append(genres, genre)
+		}
+	}
+	book.Genres = genres
+	book.UpdatedAt = now
+	return book
+}
+
+// BatchUpdateMetadata applies the patch to all the books in one
+// transaction: when one of them is missing, private to another account or
+// archived none is changed.
+func (uc *BookShelf) BatchUpdateMetadata(ctx context.Context, bookIDs []string, patch MetadataPatch) ([]entity.Book, error) {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return nil, fmt.Errorf("BookShelf - BatchUpdateMetadata - %w", err)
+	}
+	ids := make([]string, 0, len(bookIDs))
+	seen := make(map[string]bool, len(bookIDs))
+	for _, id := range bookIDs {
+		if id = strings.TrimSpace(id); id != "" && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+	if patch.empty() || len(ids) == 0 || len(ids) > batchUpdateMax {
+		return nil, fmt.Errorf("BookShelf - BatchUpdateMetadata - %w", ErrInvalidPatch)
+	}
+
+	now := time.Now()
+	books, err := uc.repo.UpdateMany(ctx, ids, func(book entity.Book) (entity.Book, error) {
+		if !visible(ctx, book) {
+			return entity.Book{}, fmt.Errorf("book %s: %w", book.ID, entity.ErrBookNotFound)
+		}
+		if book.Archived() {
+			return entity.Book{}, fmt.Errorf("book %s: %w", book.ID, entity.ErrBookArchived)
+		}
+		return patch.apply(book, now), nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - BatchUpdateMetadata - s.repo.UpdateMany: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	return books, nil
+}
@@ -0,0 +1,63 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// StoreFile adds a format to a book, entity.ErrBookAlreadyExists when the
+// file or the format is already stored.
+func (bdr *BookDatabaseRepo) StoreFile(ctx context.Context, file entity.BookFile) error {
+	_, err := bdr.Conn(ctx).Exec(ctx, `
+		INSERT INTO library_book_file (book_id, format, storage_file_path, koreader_partial_md5, file_size, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, file.BookID, file.Format, file.FilePath, file.DocumentID, file.FileSize, file.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
+			return fmt.Errorf("BookDatabaseRepo - StoreFile - r.Pool.Exec: %w", entity.ErrBookAlreadyExists)
+		}
+		return fmt.Errorf("BookDatabaseRepo - StoreFile - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// ListFiles returns the formats added to the book, oldest first.
+func (bdr *BookDatabaseRepo) ListFiles(ctx context.Context, bookID string) ([]entity.BookFile, error) {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT book_id, format, storage_file_path, koreader_partial_md5, file_size, created_at
+		FROM library_book_file
+		WHERE book_id = $1
+		ORDER BY created_at, format
+	`, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListFiles - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	files := make([]entity.BookFile, 0)
+	for rows.Next() {
+		var file entity.BookFile
+		if err = rows.Scan(&file.BookID, &file.Format, &file.FilePath, &file.DocumentID, &file.FileSize, &file.CreatedAt); err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - ListFiles - rows.Scan: %w", err)
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListFiles - rows.Err: %w", err)
+	}
+	return files, nil
+}
+
+func (bdr *BookDatabaseRepo) DeleteFile(ctx context.Context, bookID, format string) error {
+	tag, err := bdr.Conn(ctx).Exec(ctx, `DELETE FROM library_book_file WHERE book_id = $1 AND format = $2`, bookID, format)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - DeleteFile - r.Pool.Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("BookDatabaseRepo - DeleteFile - %s: %w", format, entity.ErrBookNotFound)
+	}
+	return nil
+}
@@ -0,0 +1,1362 @@
+package library
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"maps"
+	"math"
+	"math/rand"
+	"path"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/moroz/uuidv7-go"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/isbn"
+	"github.com/banjuer/kompanion/pkg/trigram"
+)
+
+// MemoryBookRepo keeps the library in memory, for tests of the use cases
+// without a database. It follows BookDatabaseRepo with a few differences:
+// text is compared by code point instead of a collation, downloaded books
+// are never opened and the planner estimate is the exact count.
+type MemoryBookRepo struct {
+	mu    sync.Mutex
+	state memoryState
+}
+
+// memoryKey is a row of a table keyed by account or kind and book.
+type memoryKey struct {
+	name   string
+	bookID string
+}
+
+type memoryShare struct {
+	link entity.ShareLink
+	hash string
+}
+
+type memoryCollection struct {
+	collection entity.Collection
+	feedHash   string
+}
+
+type memoryState struct {
+	books       map[string]entity.Book
+	files       map[string][]entity.BookFile
+	works       map[string]string
+	relations   map[string]entity.EditionRelation
+	chapters    map[string][]entity.Chapter
+	statuses    map[memoryKey]entity.BookStatus
+	reviews     map[memoryKey]entity.Review
+	downloads   []entity.Download
+	shares      []memoryShare
+	loans       []entity.Loan
+	collections map[string]memoryCollection
+	ingests     []entity.IngestEntry
+	issues      map[memoryKey]entity.BookIssue
+	checks      []entity.LibraryCheck
+}
+
+func NewMemoryBookRepo() *MemoryBookRepo {
+	return &MemoryBookRepo{state: memoryState{
+		books:       make(map[string]entity.Book),
+		files:       make(map[string][]entity.BookFile),
+		works:       make(map[string]string),
+		relations:   make(map[string]entity.EditionRelation),
+		chapters:    make(map[string][]entity.Chapter),
+		statuses:    make(map[memoryKey]entity.BookStatus),
+		reviews:     make(map[memoryKey]entity.Review),
+		collections: make(map[string]memoryCollection),
+		issues:      make(map[memoryKey]entity.BookIssue),
+	}}
+}
+
+func (s memoryState) clone() memoryState {
+	c := s
+	c.books = maps.Clone(s.books)
+	c.files = make(map[string][]entity.BookFile, len(s.files))
+	for id, files := range s.files {
+		c.files[id] = slices.Clone(files)
+	}
+	c.works = maps.Clone(s.works)
+	c.relations = maps.Clone(s.relations)
+	c.chapters = maps.Clone(s.chapters)
+	c.statuses = maps.Clone(s.statuses)
+	c.reviews = maps.Clone(s.reviews)
+	c.downloads = slices.Clone(s.downloads)
+	c.shares = slices.Clone(s.shares)
+	c.loans = slices.Clone(s.loans)
+	c.collections = make(map[string]memoryCollection, len(s.collections))
+	for id, mc := range s.collections {
+		mc.collection.Entries = slices.Clone(mc.collection.Entries)
+		c.collections[id] = mc
+	}
+	c.ingests = slices.Clone(s.ingests)
+	c.issues = maps.Clone(s.issues)
+	c.checks = slices.Clone(s.checks)
+	return c
+}
+
+// WithTx restores the library to the state before fn when fn fails. Other
+// goroutines see the changes of fn before it returns.
+func (r *MemoryBookRepo) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	r.mu.Lock()
+	saved := r.state.clone()
+	r.mu.Unlock()
+
+	if err := fn(ctx); err != nil {
+		r.mu.Lock()
+		r.state = saved
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+func (r *MemoryBookRepo) Store(_ context.Context, book entity.Book) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.state.books[book.ID]; ok {
+		return fmt.Errorf("MemoryBookRepo - Store - %w", entity.ErrBookAlreadyExists)
+	}
+	for _, stored := range r.state.books {
+		if book.DocumentID != "" && stored.DocumentID == book.DocumentID {
+			return fmt.Errorf("MemoryBookRepo - Store - %w", entity.ErrBookAlreadyExists)
+		}
+	}
+	// like the columns of library_book, the rest is set by other calls
+	book.Format = ""
+	book.Genres = genres(book.Genres)
+	book.Rating, book.RatingCount = 0, 0
+	book.ArchivedAt, book.VisibleFrom = time.Time{}, time.Time{}
+	r.state.books[book.ID] = book
+	return nil
+}
+
+func (r *MemoryBookRepo) List(ctx context.Context, filter BookFilter, sortBy, sortOrder string, page, perPage int) ([]entity.Book, error) {
+	return r.Search(ctx, "", filter, sortBy, sortOrder, page, perPage)
+}
+
+func (r *MemoryBookRepo) Search(ctx context.Context, query string, filter BookFilter, sortBy, sortOrder string, page, perPage int) ([]entity.Book, error) {
+	if page <= 0 {
+		page = 1
+	}
+	if perPage <= 0 {
+		perPage = defaultPerPage
+	}
+	books := r.sorted(ctx, query, filter, sortBy, sortOrder, false)
+	start := min(len(books), (page-1)*perPage)
+	return books[start:min(len(books), start+perPage)], nil
+}
+
+func (r *MemoryBookRepo) ListByCursor(ctx context.Context, filter BookFilter, sortBy, sortOrder string, cursor Cursor, limit int) ([]entity.Book, error) {
+	return r.SearchByCursor(ctx, "", filter, sortBy, sortOrder, cursor, limit)
+}
+
+func (r *MemoryBookRepo) SearchByCursor(ctx context.Context, query string, filter BookFilter, sortBy, sortOrder string, cursor Cursor, limit int) ([]entity.Book, error) {
+	sortBy, sortOrder = normalizeSort(sortBy, sortOrder)
+	books := r.sorted(ctx, query, filter, sortBy, sortOrder, cursor.Before)
+	if !cursor.IsZero() {
+		locale := LocaleFrom(ctx)
+		desc := (sortOrder == "desc") != cursor.Before
+		books = slices.DeleteFunc(books, func(book entity.Book) bool {
+			c := compareSortKeys(sortBy, sortKey(book, sortBy, locale), book.ID, cursor.Key, cursor.ID)
+			return (desc && c >= 0) || (!desc && c <= 0)
+		})
+	}
+	books = books[:min(len(books), limit)]
+	if cursor.Before {
+		slices.Reverse(books)
+	}
+	return books, nil
+}
+
+// sorted returns the matching books in the order of sortBy, reversed when
+// reverse is set.
+func (r *MemoryBookRepo) sorted(ctx context.Context, query string, filter BookFilter, sortBy, sortOrder string, reverse bool) []entity.Book {
+	sortBy, sortOrder = normalizeSort(sortBy, sortOrder)
+	books := r.matching(query, filter)
+	locale := LocaleFrom(ctx)
+	desc := (sortOrder == "desc") != reverse
+	slices.SortFunc(books, func(a, b entity.Book) int {
+		c := compareSortKeys(sortBy, sortKey(a, sortBy, locale), a.ID, sortKey(b, sortBy, locale), b.ID)
+		if desc {
+			return -c
+		}
+		return c
+	})
+	return books
+}
+
+// compareSortKeys orders by the sort key, as typed in sortColumns, and
+// then by id.
+func compareSortKeys(sortBy, keyA, idA, keyB, idB string) int {
+	var c int
+	switch sortColumns[sortBy].typ {
+	case "text":
+		c = strings.Compare(keyA, keyB)
+	case "timestamptz":
+		a, _ := time.Parse(time.RFC3339Nano, keyA)
+		b, _ := time.Parse(time.RFC3339Nano, keyB)
+		c = a.Compare(b)
+	default:
+		a, _ := strconv.ParseFloat(keyA, 64)
+		b, _ := strconv.ParseFloat(keyB, 64)
+		c = cmp.Compare(a, b)
+	}
+	if c != 0 {
+		return c
+	}
+	return strings.Compare(idA, idB)
+}
+
+func (r *MemoryBookRepo) Count(_ context.Context, filter BookFilter) (int, error) {
+	return len(r.matching("", filter)), nil
+}
+
+func (r *MemoryBookRepo) CountSearch(_ context.Context, query string, filter BookFilter) (int, error) {
+	return len(r.matching(query, filter)), nil
+}
+
+func (r *MemoryBookRepo) CountUploadedBy(_ context.Context, username string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, book := range r.state.books {
+		if book.UploadedBy == username {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *MemoryBookRepo) StorageUsage(_ context.Context, username string) (entity.StorageUsage, error) {
+	return r.usage(func(book entity.Book) bool { return book.UploadedBy == username }), nil
+}
+
+func (r *MemoryBookRepo) LibraryUsage(context.Context) (entity.StorageUsage, error) {
+	return r.usage(func(entity.Book) bool { return true }), nil
+}
+
+func (r *MemoryBookRepo) usage(counted func(entity.Book) bool) entity.StorageUsage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var usage entity.StorageUsage
+	for _, book := range r.state.books {
+		if !counted(book) {
+			continue
+		}
+		usage.Books++
+		usage.FileBytes += book.FileSize
+		usage.CoverBytes += book.CoverSize
+		for _, file := range r.state.files[book.ID] {
+			usage.FormatBytes += file.FileSize
+		}
+	}
+	return usage
+}
+
+// Facets counts like BookDatabaseRepo.Facets, authors are grouped by their
+// case and accent folded spelling.
+func (r *MemoryBookRepo) Facets(_ context.Context, query string, filter BookFilter) (BookFacets, error) {
+	books := r.matching(query, filter)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	spellings := make(map[string]map[string]int)
+	formats := make(map[string]int)
+	genreCounts := make(map[string]int)
+	decades := make(map[string]int)
+	for _, book := range books {
+		if book.Author != "" {
+			folded := memoryFold(book.Author)
+			if spellings[folded] == nil {
+				spellings[folded] = make(map[string]int)
+			}
+			spellings[folded][book.Author]++
+		}
+		bookFormats := make(map[string]bool)
+		if ext := strings.TrimPrefix(path.Ext(book.FilePath), "."); ext != "" {
+			bookFormats[strings.ToLower(ext)] = true
+		}
+		for _, file := range r.state.files[book.ID] {
+			bookFormats[file.Format] = true
+		}
+		for format := range bookFormats {
+			formats[format]++
+		}
+		for _, genre := range book.Genres {
+			genreCounts[genre]++
+		}
+		if book.Year > 0 {
+			decades[strconv.Itoa(book.Year/10*10)]++
+		}
+	}
+	var facets BookFacets
+	for _, facet := range []struct {
+		name   string
+		counts map[string]int
+	}{{"author", commonSpellings(spellings)}, {"decade", decades}, {"format", formats}, {"genre", genreCounts}} {
+		for _, value := range byCount(facet.counts) {
+			facets.add(facet.name, value, facet.counts[value])
+		}
+	}
+	return facets, nil
+}
+
+// Analytics counts like BookDatabaseRepo.Analytics.
+func (r *MemoryBookRepo) Analytics(_ context.Context, filter BookFilter, top int) (LibraryStats, error) {
+	books := r.matching("", filter)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var stats LibraryStats
+	spellings := map[string]map[string]map[string]int{"author": {}, "publisher": {}}
+	counts := map[string]map[string]int{"format": {}, "language": {}, "year": {}, "month": {}}
+	for _, book := range books {
+		stats.Usage.Books++
+		stats.Usage.FileBytes += book.FileSize
+		stats.Usage.CoverBytes += book.CoverSize
+		for stat, name := range map[string]string{"author": book.Author, "publisher": book.Publisher} {
+			if name == "" {
+				continue
+			}
+			folded := memoryFold(name)
+			if spellings[stat][folded] == nil {
+				spellings[stat][folded] = make(map[string]int)
+			}
+			spellings[stat][folded][name]++
+		}
+		bookFormats := make(map[string]bool)
+		if ext := strings.TrimPrefix(path.Ext(book.FilePath), "."); ext != "" {
+			bookFormats[strings.ToLower(ext)] = true
+		}
+		for _, file := range r.state.files[book.ID] {
+			bookFormats[file.Format] = true
+			stats.Usage.FormatBytes += file.FileSize
+		}
+		for format := range bookFormats {
+			counts["format"][format]++
+		}
+		if book.Language != "" {
+			counts["language"][book.Language]++
+		}
+		if book.Year > 0 {
+			counts["year"][strconv.Itoa(book.Year)]++
+		}
+		counts["month"][book.CreatedAt.UTC().Format("2006-01")]++
+	}
+	for stat, folded := range spellings {
+		counts[stat] = commonSpellings(folded)
+	}
+	for stat, values := range counts {
+		for _, value := range byCount(values) {
+			stats.add(stat, value, values[value], top)
+		}
+	}
+	return stats, nil
+}
+
+// commonSpellings counts names by their folded spelling, under the most
+// common spelling, the first in order on a tie.
+func commonSpellings(spellings map[string]map[string]int) map[string]int {
+	names := make(map[string]int, len(spellings))
+	for _, counts := range spellings {
+		best, total := "", 0
+		for spelling, n := range counts {
+			total += n
+			if n > counts[best] || (n == counts[best] && spelling < best) {
+				best = spelling
+			}
+		}
+		names[best] = total
+	}
+	return names
+}
+
+// byCount returns the values of counts, the most common first.
+func byCount(counts map[string]int) []string {
+	values := make([]string, 0, len(counts))
+	for value := range counts {
+		values = append(values, value)
+	}
+	slices.SortFunc(values, func(a, b string) int {
+		if c := cmp.Compare(counts[b], counts[a]); c != 0 {
+			return c
+		}
+		return strings.Compare(a, b)
+	})
+	return values
+}
+
+// Similar finds books like BookDatabaseRepo.Similar, titles are compared
+// with pkg/trigram.
+func (r *MemoryBookRepo) Similar(_ context.Context, book entity.Book, filter BookFilter, n int) ([]entity.Book, error) {
+	genreSet := make(map[string]bool, len(book.Genres))
+	for _, genre := range book.Genres {
+		genreSet[genre] = true
+	}
+	points := make(map[string]float64)
+	similar := make([]entity.Book, 0)
+	for _, other := range r.matching("", filter) {
+		if other.ID == book.ID {
+			continue
+		}
+		title := trigram.Similarity(other.Title, book.Title)
+		shared := false
+		score := similarTitle * title
+		if book.Series != "" && other.Series == book.Series {
+			score += similarSeries
+			shared = true
+		}
+		if book.Author != "" && memoryFold(other.Author) == memoryFold(book.Author) {
+			score += similarAuthor
+			shared = true
+		}
+		seen := make(map[string]bool)
+		for _, genre := range other.Genres {
+			if genreSet[genre] && !seen[genre] {
+				score += similarGenre
+				seen[genre], shared = true, true
+			}
+		}
+		if shared || title >= similarTitleMin {
+			points[other.ID] = score
+			similar = append(similar, other)
+		}
+	}
+	slices.SortFunc(similar, func(a, b entity.Book) int {
+		if c := cmp.Compare(points[b.ID], points[a.ID]); c != 0 {
+			return c
+		}
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return similar[:min(len(similar), n)], nil
+}
+
+func (r *MemoryBookRepo) EstimateCount(context.Context) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.state.books), nil
+}
+
+func (r *MemoryBookRepo) Random(_ context.Context, filter BookFilter, n int) ([]entity.Book, error) {
+	books := r.matching("", filter)
+	rand.Shuffle(len(books), func(i, j int) { books[i], books[j] = books[j], books[i] })
+	return books[:min(len(books), n)], nil
+}
+
+func (r *MemoryBookRepo) Languages(context.Context) ([]string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	seen := make(map[string]bool)
+	languages := make([]string, 0)
+	for _, book := range r.state.books {
+		if book.Language != "" && !seen[book.Language] {
+			seen[book.Language] = true
+			languages = append(languages, book.Language)
+		}
+	}
+	slices.Sort(languages)
+	return languages, nil
+}
+
+func (r *MemoryBookRepo) GetById(_ context.Context, id string) (entity.Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	book, ok := r.state.books[id]
+	if !ok {
+		return entity.Book{}, fmt.Errorf("MemoryBookRepo - GetById - %w", entity.ErrBookNotFound)
+	}
+	return book, nil
+}
+
+func (r *MemoryBookRepo) GetByFileHash(_ context.Context, fileHash string) (entity.Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, book := range r.state.books {
+		if book.DocumentID == fileHash {
+			return book, nil
+		}
+	}
+	for bookID, files := range r.state.files {
+		for _, file := range files {
+			if file.DocumentID == fileHash {
+				return r.state.books[bookID], nil
+			}
+		}
+	}
+	return entity.Book{}, fmt.Errorf("MemoryBookRepo - GetByFileHash - %w", entity.ErrBookNotFound)
+}
+
+func (r *MemoryBookRepo) ListByISBN(_ context.Context, value string) ([]entity.Book, error) {
+	forms := isbn.Forms(value)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	books := make([]entity.Book, 0)
+	for _, book := range r.state.books {
+		cleaned := strings.ToUpper(strings.NewReplacer("-", "", " ", "").Replace(book.ISBN))
+		if slices.Contains(forms, cleaned) {
+			books = append(books, book)
+		}
+	}
+	slices.SortFunc(books, func(a, b entity.Book) int {
+		return compareSortKeys("created_at", sortKey(a, "created_at", ""), a.ID, sortKey(b, "created_at", ""), b.ID)
+	})
+	return books, nil
+}
+
+// Update saves the metadata and cover of a book that is not archived.
+func (r *MemoryBookRepo) Update(_ context.Context, book entity.Book) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, ok := r.state.books[book.ID]
+	if !ok || !stored.ArchivedAt.IsZero() {
+		return fmt.Errorf("MemoryBookRepo - Update - no rows affected")
+	}
+	stored.Title, stored.Author, stored.Publisher, stored.Year = book.Title, book.Author, book.Publisher, book.Year
+	stored.UpdatedAt, stored.ISBN, stored.Series, stored.SeriesIndex = book.UpdatedAt, book.ISBN, book.Series, book.SeriesIndex
+	stored.Description, stored.CoverPath, stored.Language = book.Description, book.CoverPath, book.Language
+	stored.DOI, stored.CoverSize = book.DOI, book.CoverSize
+	r.state.books[book.ID] = stored
+	return nil
+}
+
+// UpdateMany changes all the books or none, like the transaction of
+// BookDatabaseRepo.UpdateMany.
+func (r *MemoryBookRepo) UpdateMany(_ context.Context, ids []string, change func(entity.Book) (entity.Book, error)) ([]entity.Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ids = slices.Clone(ids)
+	slices.Sort(ids)
+	ids = slices.Compact(ids)
+	books := make([]entity.Book, 0, len(ids))
+	for _, id := range ids {
+		book, ok := r.state.books[id]
+		if !ok {
+			return nil, fmt.Errorf("MemoryBookRepo - UpdateMany - %w", entity.ErrBookNotFound)
+		}
+		changed, err := change(book)
+		if err != nil {
+			return nil, err
+		}
+		book.Title, book.Author, book.Publisher, book.Year = changed.Title, changed.Author, changed.Publisher, changed.Year
+		book.ISBN, book.DOI, book.Series, book.SeriesIndex = changed.ISBN, changed.DOI, changed.Series, changed.SeriesIndex
+		book.Description, book.Language, book.Genres = changed.Description, changed.Language, genres(changed.Genres)
+		book.UpdatedAt = changed.UpdatedAt
+		books = append(books, book)
+	}
+	for _, book := range books {
+		r.state.books[book.ID] = book
+	}
+	return books, nil
+}
+
+func (r *MemoryBookRepo) SetArchived(_ context.Context, id string, archivedAt time.Time) error {
+	return r.change(id, func(book *entity.Book) { book.ArchivedAt = archivedAt })
+}
+
+func (r *MemoryBookRepo) SetPrivate(_ context.Context, id string, private bool) error {
+	return r.change(id, func(book *entity.Book) { book.Private = private })
+}
+
+func (r *MemoryBookRepo) SetVisibleFrom(_ context.Context, id string, from time.Time) error {
+	return r.change(id, func(book *entity.Book) { book.VisibleFrom = from })
+}
+
+func (r *MemoryBookRepo) change(id string, change func(*entity.Book)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	book, ok := r.state.books[id]
+	if !ok {
+		return fmt.Errorf("MemoryBookRepo - %w", entity.ErrBookNotFound)
+	}
+	change(&book)
+	r.state.books[id] = book
+	return nil
+}
+
+func (r *MemoryBookRepo) StoreFile(_ context.Context, file entity.BookFile) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.state.books[file.BookID]; !ok {
+		return fmt.Errorf("MemoryBookRepo - StoreFile - %w", entity.ErrBookNotFound)
+	}
+	for _, files := range r.state.files {
+		for _, stored := range files {
+			if stored.DocumentID == file.DocumentID || (stored.BookID == file.BookID && stored.Format == file.Format) {
+				return fmt.Errorf("MemoryBookRepo - StoreFile - %w", entity.ErrBookAlreadyExists)
+			}
+		}
+	}
+	r.state.files[file.BookID] = append(r.state.files[file.BookID], file)
+	return nil
+}
+
+func (r *MemoryBookRepo) ListFiles(_ context.Context, bookID string) ([]entity.BookFile, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files := append(make([]entity.BookFile, 0), r.state.files[bookID]...)
+	slices.SortFunc(files, func(a, b entity.BookFile) int {
+		if c := a.CreatedAt.Compare(b.CreatedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Format, b.Format)
+	})
+	return files, nil
+}
+
+func (r *MemoryBookRepo) DeleteFile(_ context.Context, bookID, format string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	files := r.state.files[bookID]
+	kept := slices.DeleteFunc(slices.Clone(files), func(file entity.BookFile) bool { return file.Format == format })
+	if len(kept) == len(files) {
+		return fmt.Errorf("MemoryBookRepo - DeleteFile - %s: %w", format, entity.ErrBookNotFound)
+	}
+	r.state.files[bookID] = kept
+	return nil
+}
+
+// LinkEdition merges works like BookDatabaseRepo.LinkEdition.
+func (r *MemoryBookRepo) LinkEdition(_ context.Context, bookID, otherID string, relation entity.EditionRelation) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	work := otherID
+	if w, ok := r.state.works[otherID]; ok {
+		work = w
+	} else if w, ok := r.state.works[bookID]; ok {
+		work = w
+	}
+	if merged, ok := r.state.works[bookID]; ok && merged != work {
+		for id, w := range r.state.works {
+			if w == merged {
+				r.state.works[id] = work
+			}
+		}
+	}
+	r.state.works[bookID], r.state.relations[bookID] = work, relation
+	r.state.works[otherID] = work
+	if _, ok := r.state.relations[otherID]; !ok {
+		r.state.relations[otherID] = entity.EditionRelation("edition")
+	}
+	return nil
+}
+
+// UnlinkEdition dissolves a work left with a single book.
+func (r *MemoryBookRepo) UnlinkEdition(_ context.Context, bookID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unlink(bookID)
+	return nil
+}
+
+func (r *MemoryBookRepo) unlink(bookID string) {
+	work, ok := r.state.works[bookID]
+	if !ok {
+		return
+	}
+	delete(r.state.works, bookID)
+	delete(r.state.relations, bookID)
+	var left []string
+	for id, w := range r.state.works {
+		if w == work {
+			left = append(left, id)
+		}
+	}
+	if len(left) == 1 {
+		delete(r.state.works, left[0])
+		delete(r.state.relations, left[0])
+	}
+}
+
+func (r *MemoryBookRepo) ListEditions(_ context.Context, bookIDs []string) (map[string][]entity.Edition, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	editions := make(map[string][]entity.Edition)
+	for _, of := range bookIDs {
+		work, ok := r.state.works[of]
+		if !ok {
+			continue
+		}
+		for id, w := range r.state.works {
+			if w == work && id != of {
+				editions[of] = append(editions[of], entity.Edition{Book: r.state.books[id], WorkID: work, Relation: r.state.relations[id]})
+			}
+		}
+		slices.SortFunc(editions[of], func(a, b entity.Edition) int {
+			return cmp.Or(
+				strings.Compare(a.Book.Language, b.Book.Language),
+				cmp.Compare(a.Book.Year, b.Book.Year),
+				strings.Compare(a.Book.Title, b.Book.Title),
+			)
+		})
+	}
+	return editions, nil
+}
+
+func (r *MemoryBookRepo) StoreChapters(_ context.Context, bookID string, chapters []entity.Chapter) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.chapters[bookID] = slices.Clone(chapters)
+	return nil
+}
+
+func (r *MemoryBookRepo) ListChapters(_ context.Context, bookID string) ([]entity.Chapter, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(make([]entity.Chapter, 0), r.state.chapters[bookID]...), nil
+}
+
+// Delete removes a book that is not archived with everything kept about
+// it, like the foreign keys of library_book cascade.
+func (r *MemoryBookRepo) Delete(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	book, ok := r.state.books[id]
+	if !ok || !book.ArchivedAt.IsZero() {
+		return fmt.Errorf("MemoryBookRepo - Delete - no rows affected")
+	}
+	delete(r.state.books, id)
+	delete(r.state.files, id)
+	delete(r.state.chapters, id)
+	r.unlink(id)
+	for key := range r.state.statuses {
+		if key.bookID == id {
+			delete(r.state.statuses, key)
+		}
+	}
+	for key := range r.state.reviews {
+		if key.bookID == id {
+			delete(r.state.reviews, key)
+		}
+	}
+	for key := range r.state.issues {
+		if key.bookID == id {
+			delete(r.state.issues, key)
+		}
+	}
+	r.state.downloads = slices.DeleteFunc(r.state.downloads, func(d entity.Download) bool { return d.BookID == id })
+	r.state.shares = slices.DeleteFunc(r.state.shares, func(s memoryShare) bool { return s.link.BookID == id })
+	r.state.loans = slices.DeleteFunc(r.state.loans, func(l entity.Loan) bool { return l.BookID == id })
+	for cid, mc := range r.state.collections {
+		mc.collection.Entries = slices.DeleteFunc(mc.collection.Entries, func(e entity.CollectionEntry) bool { return e.Book.ID == id })
+		r.state.collections[cid] = mc
+	}
+	return nil
+}
+
+func (r *MemoryBookRepo) GetReadingStatus(_ context.Context, username, bookID string) (entity.BookStatus, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	status, ok := r.state.statuses[memoryKey{username, bookID}]
+	if !ok {
+		return entity.BookStatus{Username: username, BookID: bookID}, nil
+	}
+	return status, nil
+}
+
+func (r *MemoryBookRepo) SetReadingStatus(_ context.Context, status entity.BookStatus) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.statuses[memoryKey{status.Username, status.BookID}] = status
+	return nil
+}
+
+func (r *MemoryBookRepo) ClearReadingStatus(_ context.Context, username, bookID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.state.statuses, memoryKey{username, bookID})
+	return nil
+}
+
+func (r *MemoryBookRepo) ListReviews(_ context.Context, bookID string) ([]entity.Review, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	reviews := make([]entity.Review, 0)
+	for key, review := range r.state.reviews {
+		if key.bookID == bookID {
+			reviews = append(reviews, review)
+		}
+	}
+	slices.SortFunc(reviews, func(a, b entity.Review) int { return b.UpdatedAt.Compare(a.UpdatedAt) })
+	return reviews, nil
+}
+
+func (r *MemoryBookRepo) GetReview(_ context.Context, username, bookID string) (entity.Review, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	review, ok := r.state.reviews[memoryKey{username, bookID}]
+	if !ok {
+		return entity.Review{Username: username, BookID: bookID}, nil
+	}
+	return review, nil
+}
+
+// SaveReview keeps the creation time of a review written before and
+// refreshes the rating of the book.
+func (r *MemoryBookRepo) SaveReview(_ context.Context, review entity.Review) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := memoryKey{review.Username, review.BookID}
+	if stored, ok := r.state.reviews[key]; ok {
+		review.CreatedAt = stored.CreatedAt
+	}
+	r.state.reviews[key] = review
+	r.refreshRating(review.BookID)
+	return nil
+}
+
+func (r *MemoryBookRepo) DeleteReview(_ context.Context, username, bookID string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.state.reviews, memoryKey{username, bookID})
+	r.refreshRating(bookID)
+	return nil
+}
+
+func (r *MemoryBookRepo) refreshRating(bookID string) {
+	book, ok := r.state.books[bookID]
+	if !ok {
+		return
+	}
+	sum, count := 0, 0
+	for key, review := range r.state.reviews {
+		if key.bookID == bookID && review.Rating > 0 {
+			sum += review.Rating
+			count++
+		}
+	}
+	book.Rating, book.RatingCount = 0, count
+	if count > 0 {
+		book.Rating = math.Round(float64(sum)/float64(count)*100) / 100
+	}
+	r.state.books[bookID] = book
+}
+
+func (r *MemoryBookRepo) AddDownload(_ context.Context, download entity.Download) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.downloads = append(r.state.downloads, download)
+	return nil
+}
+
+// ListDownloads sums the downloads of username and the devices, no book
+// counts as opened.
+func (r *MemoryBookRepo) ListDownloads(_ context.Context, username string, _ bool, limit int) ([]entity.DownloadedBook, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	byBook := make(map[string]*entity.DownloadedBook)
+	for _, download := range r.state.downloads {
+		book, ok := r.state.books[download.BookID]
+		if !ok || (download.Username != username && download.DeviceName == "") {
+			continue
+		}
+		d, ok := byBook[book.ID]
+		if !ok {
+			d = &entity.DownloadedBook{Book: book}
+			byBook[book.ID] = d
+		}
+		d.Downloads++
+		if download.DownloadedAt.After(d.LastDownloadedAt) {
+			d.LastDownloadedAt = download.DownloadedAt
+		}
+	}
+	downloads := make([]entity.DownloadedBook, 0, len(byBook))
+	for _, d := range byBook {
+		downloads = append(downloads, *d)
+	}
+	slices.SortFunc(downloads, func(a, b entity.DownloadedBook) int { return b.LastDownloadedAt.Compare(a.LastDownloadedAt) })
+	return downloads[:min(len(downloads), limit)], nil
+}
+
+func (r *MemoryBookRepo) CreateShareLink(_ context.Context, link entity.ShareLink, hash string) (entity.ShareLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	link.ID, link.Downloads, link.CreatedAt, link.RevokedAt = uuidv7.Generate().String(), 0, time.Now(), nil
+	r.state.shares = append(r.state.shares, memoryShare{link, hash})
+	return link, nil
+}
+
+func (r *MemoryBookRepo) GetShareLinkByHash(_ context.Context, hash string) (entity.ShareLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, share := range r.state.shares {
+		if share.hash == hash {
+			return share.link, nil
+		}
+	}
+	return entity.ShareLink{}, entity.ErrShareLinkNotFound
+}
+
+func (r *MemoryBookRepo) ListShareLinks(_ context.Context, username, bookID string) ([]entity.ShareLink, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	links := make([]entity.ShareLink, 0)
+	for _, share := range r.state.shares {
+		if share.link.Username == username && share.link.BookID == bookID && share.link.RevokedAt == nil {
+			links = append(links, share.link)
+		}
+	}
+	slices.SortFunc(links, func(a, b entity.ShareLink) int { return b.CreatedAt.Compare(a.CreatedAt) })
+	return links, nil
+}
+
+func (r *MemoryBookRepo) RevokeShareLink(_ context.Context, username, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, share := range r.state.shares {
+		if share.link.ID == id && share.link.Username == username && share.link.RevokedAt == nil {
+			now := time.Now()
+			r.state.shares[i].link.RevokedAt = &now
+			return nil
+		}
+	}
+	return entity.ErrShareLinkNotFound
+}
+
+func (r *MemoryBookRepo) CountShareDownload(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, share := range r.state.shares {
+		link := share.link
+		if link.ID == id && link.RevokedAt == nil && link.ExpiresAt.After(time.Now()) &&
+			(link.MaxDownloads == 0 || link.Downloads < link.MaxDownloads) {
+			r.state.shares[i].link.Downloads++
+			return nil
+		}
+	}
+	return entity.ErrShareLinkUsedUp
+}
+
+func (r *MemoryBookRepo) CreateLoan(_ context.Context, loan entity.Loan) (entity.Loan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	book, ok := r.state.books[loan.BookID]
+	if !ok {
+		return entity.Loan{}, fmt.Errorf("MemoryBookRepo - CreateLoan - %w", entity.ErrBookNotFound)
+	}
+	loan.ID, loan.Title, loan.CreatedAt, loan.EndedAt = uuidv7.Generate().String(), book.Title, time.Now(), nil
+	r.state.loans = append(r.state.loans, loan)
+	return loan, nil
+}
+
+func (r *MemoryBookRepo) GetLoan(_ context.Context, id string) (entity.Loan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, loan := range r.state.loans {
+		if loan.ID == id {
+			return r.withTitle(loan), nil
+		}
+	}
+	return entity.Loan{}, entity.ErrLoanNotFound
+}
+
+func (r *MemoryBookRepo) ListLoans(_ context.Context, username string) ([]entity.Loan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.loansWhere(func(loan entity.Loan) bool {
+		return (loan.Lender == username || loan.Borrower == username) && loan.EndedAt == nil
+	}), nil
+}
+
+func (r *MemoryBookRepo) EndLoan(_ context.Context, id string, endedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, loan := range r.state.loans {
+		if loan.ID == id && loan.EndedAt == nil {
+			r.state.loans[i].EndedAt = &endedAt
+			return nil
+		}
+	}
+	return fmt.Errorf("MemoryBookRepo - EndLoan - %w", entity.ErrLoanNotFound)
+}
+
+func (r *MemoryBookRepo) EndExpiredLoans(_ context.Context, now time.Time) ([]entity.Loan, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	ended := r.loansWhere(func(loan entity.Loan) bool {
+		return loan.EndedAt == nil && !loan.ExpiresAt.After(now)
+	})
+	for i, loan := range r.state.loans {
+		if loan.EndedAt == nil && !loan.ExpiresAt.After(now) {
+			r.state.loans[i].EndedAt = &r.state.loans[i].ExpiresAt
+		}
+	}
+	for i := range ended {
+		ended[i].EndedAt = &ended[i].ExpiresAt
+	}
+	return ended, nil
+}
+
+// loansWhere returns the matching loans, soonest to expire first.
+func (r *MemoryBookRepo) loansWhere(match func(entity.Loan) bool) []entity.Loan {
+	loans := make([]entity.Loan, 0)
+	for _, loan := range r.state.loans {
+		if match(loan) {
+			loans = append(loans, r.withTitle(loan))
+		}
+	}
+	slices.SortFunc(loans, func(a, b entity.Loan) int {
+		return cmp.Or(a.ExpiresAt.Compare(b.ExpiresAt), strings.Compare(a.ID, b.ID))
+	})
+	return loans
+}
+
+// withTitle gives the loan the current title of its book.
+func (r *MemoryBookRepo) withTitle(loan entity.Loan) entity.Loan {
+	loan.Title = r.state.books[loan.BookID].Title
+	return loan
+}
+
+func (r *MemoryBookRepo) CreateCollection(_ context.Context, collection entity.Collection) (entity.Collection, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, entry := range collection.Entries {
+		if _, ok := r.state.books[entry.Book.ID]; !ok {
+			return entity.Collection{}, fmt.Errorf("MemoryBookRepo - CreateCollection - %w", entity.ErrBookNotFound)
+		}
+	}
+	now := time.Now()
+	collection.ID, collection.CreatedAt, collection.UpdatedAt, collection.FeedPrefix = uuidv7.Generate().String(), now, now, ""
+	stored := collection
+	stored.Entries = slices.Clone(collection.Entries)
+	r.state.collections[collection.ID] = memoryCollection{collection: stored}
+	return collection, nil
+}
+
+// GetCollection returns the entries with the current books and the
+// reading status of username.
+func (r *MemoryBookRepo) GetCollection(_ context.Context, id, username string) (entity.Collection, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mc, ok := r.state.collections[id]
+	if !ok {
+		return entity.Collection{}, entity.ErrCollectionNotFound
+	}
+	collection := mc.collection
+	collection.Entries = make([]entity.CollectionEntry, 0, len(mc.collection.Entries))
+	for _, entry := range mc.collection.Entries {
+		entry.Book = r.state.books[entry.Book.ID]
+		entry.Prerequisites = append(make([]string, 0), entry.Prerequisites...)
+		entry.Status = r.state.statuses[memoryKey{username, entry.Book.ID}].Status
+		collection.Entries = append(collection.Entries, entry)
+	}
+	return collection, nil
+}
+
+func (r *MemoryBookRepo) ListCollections(context.Context) ([]entity.Collection, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	collections := make([]entity.Collection, 0, len(r.state.collections))
+	for _, mc := range r.state.collections {
+		collection := mc.collection
+		collection.Entries = nil
+		collections = append(collections, collection)
+	}
+	slices.SortFunc(collections, func(a, b entity.Collection) int {
+		return cmp.Or(strings.Compare(strings.ToLower(a.Name), strings.ToLower(b.Name)), strings.Compare(a.ID, b.ID))
+	})
+	return collections, nil
+}
+
+func (r *MemoryBookRepo) UpdateCollection(_ context.Context, collection entity.Collection) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mc, ok := r.state.collections[collection.ID]
+	if !ok {
+		return fmt.Errorf("MemoryBookRepo - UpdateCollection - %w", entity.ErrCollectionNotFound)
+	}
+	mc.collection.Name, mc.collection.Description = collection.Name, collection.Description
+	mc.collection.UpdatedAt = collection.UpdatedAt
+	mc.collection.Entries = slices.Clone(collection.Entries)
+	r.state.collections[collection.ID] = mc
+	return nil
+}
+
+func (r *MemoryBookRepo) DeleteCollection(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.state.collections[id]; !ok {
+		return fmt.Errorf("MemoryBookRepo - DeleteCollection - %w", entity.ErrCollectionNotFound)
+	}
+	delete(r.state.collections, id)
+	return nil
+}
+
+func (r *MemoryBookRepo) SetCollectionFeed(_ context.Context, id, tokenHash, prefix string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	mc, ok := r.state.collections[id]
+	if !ok {
+		return fmt.Errorf("MemoryBookRepo - SetCollectionFeed - %w", entity.ErrCollectionNotFound)
+	}
+	mc.feedHash, mc.collection.FeedPrefix = tokenHash, prefix
+	r.state.collections[id] = mc
+	return nil
+}
+
+func (r *MemoryBookRepo) GetCollectionIDByFeedHash(_ context.Context, tokenHash string) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, mc := range r.state.collections {
+		if tokenHash != "" && mc.feedHash == tokenHash {
+			return id, nil
+		}
+	}
+	return "", entity.ErrCollectionNotFound
+}
+
+func (r *MemoryBookRepo) RecordIngest(_ context.Context, entry entity.IngestEntry) (string, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	entry.ID, entry.State, entry.CreatedAt, entry.UpdatedAt = uuidv7.Generate().String(), entity.IngestWritten, now, now
+	r.state.ingests = append(r.state.ingests, entry)
+	return entry.ID, nil
+}
+
+func (r *MemoryBookRepo) SetIngestState(_ context.Context, id, state, detail string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for i, entry := range r.state.ingests {
+		if entry.ID == id {
+			r.state.ingests[i].State, r.state.ingests[i].Detail, r.state.ingests[i].UpdatedAt = state, detail, time.Now()
+		}
+	}
+	return nil
+}
+
+func (r *MemoryBookRepo) DeleteIngest(_ context.Context, id string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.ingests = slices.DeleteFunc(r.state.ingests, func(e entity.IngestEntry) bool { return e.ID == id })
+	return nil
+}
+
+func (r *MemoryBookRepo) ListIngests(context.Context) ([]entity.IngestEntry, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append(make([]entity.IngestEntry, 0), r.state.ingests...), nil
+}
+
+func (r *MemoryBookRepo) Reindex(context.Context) error {
+	return nil
+}
+
+// SaveIssues keeps the time a problem found again was first detected.
+func (r *MemoryBookRepo) SaveIssues(_ context.Context, bookID string, issues []entity.BookIssue, checkedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, issue := range issues {
+		key := memoryKey{issue.Kind, bookID}
+		stored, ok := r.state.issues[key]
+		if !ok {
+			stored = entity.BookIssue{BookID: bookID, Kind: issue.Kind, DetectedAt: checkedAt}
+		}
+		stored.Detail, stored.CheckedAt = issue.Detail, checkedAt
+		r.state.issues[key] = stored
+	}
+	return nil
+}
+
+func (r *MemoryBookRepo) ResolveIssues(_ context.Context, checkedBefore time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for key, issue := range r.state.issues {
+		if issue.CheckedAt.Before(checkedBefore) {
+			delete(r.state.issues, key)
+		}
+	}
+	return nil
+}
+
+func (r *MemoryBookRepo) ListIssues(context.Context) ([]entity.BookIssue, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	issues := make([]entity.BookIssue, 0, len(r.state.issues))
+	for _, issue := range r.state.issues {
+		book, ok := r.state.books[issue.BookID]
+		if !ok {
+			continue
+		}
+		issue.Title = book.Title
+		issues = append(issues, issue)
+	}
+	slices.SortFunc(issues, func(a, b entity.BookIssue) int {
+		return cmp.Or(b.DetectedAt.Compare(a.DetectedAt), strings.Compare(a.Title, b.Title))
+	})
+	return issues, nil
+}
+
+func (r *MemoryBookRepo) StoreLibraryCheck(_ context.Context, check entity.LibraryCheck) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.state.checks = append(r.state.checks, check)
+	return nil
+}
+
+func (r *MemoryBookRepo) LastLibraryCheck(context.Context) (entity.LibraryCheck, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var last entity.LibraryCheck
+	for _, check := range r.state.checks {
+		if check.StartedAt.After(last.StartedAt) {
+			last = check
+		}
+	}
+	return last, nil
+}
+
+// matching returns the books matching the query and the filter, in no
+// particular order. Invalid queries are searched as a single phrase like
+// bookConditions does.
+func (r *MemoryBookRepo) matching(query string, filter BookFilter) []entity.Book {
+	var terms SearchQuery
+	if query != "" {
+		var err error
+		if terms, err = ParseSearchQuery(query); err != nil {
+			terms = SearchQuery{{Value: query}}
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	now := time.Now()
+	books := make([]entity.Book, 0)
+	for _, book := range r.state.books {
+		if filter.CoverPath != "" && book.CoverPath != filter.CoverPath {
+			continue
+		}
+		if filter.FilePath != "" && book.FilePath != filter.FilePath {
+			continue
+		}
+		if terms.matches(book) && r.matchesFilter(book, filter, now) {
+			books = append(books, book)
+		}
+	}
+	return books
+}
+
+// matchesFilter follows bookConditions, the cover path aside.
+func (r *MemoryBookRepo) matchesFilter(book entity.Book, filter BookFilter, now time.Time) bool {
+	if filter.Language != "" && book.Language != filter.Language {
+		return false
+	}
+	if filter.MediaType != "" && book.MediaType != filter.MediaType {
+		return false
+	}
+	if filter.Username != "" && filter.Status != "" && r.state.statuses[memoryKey{filter.Username, book.ID}].Status != filter.Status {
+		return false
+	}
+	if filter.Restricted && book.Private && (filter.Reader == "" || (book.UploadedBy != filter.Reader && !r.lent(book.ID, filter.Reader, now))) {
+		return false
+	}
+	if filter.HideScheduled && book.VisibleFrom.After(now) {
+		return false
+	}
+	if filter.GroupEditions {
+		// the oldest book of the work matching the filter stands for it
+		work, ok := r.state.works[book.ID]
+		same := filter
+		same.GroupEditions = false
+		for id, w := range r.state.works {
+			if !ok || w != work || id == book.ID {
+				continue
+			}
+			first := r.state.books[id]
+			older := cmp.Or(first.CreatedAt.Compare(book.CreatedAt), strings.Compare(first.ID, book.ID)) < 0
+			if older && r.matchesFilter(first, same, now) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (r *MemoryBookRepo) lent(bookID, borrower string, now time.Time) bool {
+	for _, loan := range r.state.loans {
+		if loan.BookID == bookID && loan.Borrower == borrower && loan.Active(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// matches tells whether the book matches every term, like the conditions
+// of the query.
+func (q SearchQuery) matches(book entity.Book) bool {
+	for _, term := range q {
+		if term.matches(book) == term.Negate {
+			return false
+		}
+	}
+	return true
+}
+
+func (t SearchTerm) matches(book entity.Book) bool {
+	field := searchFields[t.Field]
+	switch {
+	case t.Field == "":
+		for _, text := range []string{book.Title, book.Author, book.Publisher, book.ISBN, book.Description} {
+			if containsFold(text, t.Value) {
+				return true
+			}
+		}
+		return false
+	case t.Field == "lang":
+		return book.Language == t.Value
+	case t.Field == "format":
+		return strings.HasSuffix(strings.ToLower(book.FilePath), "."+strings.ToLower(strings.TrimPrefix(t.Value, ".")))
+	case field.numeric:
+		value := map[string]float64{"year": float64(book.Year), "pages": float64(book.Pages), "rating": book.Rating}[t.Field]
+		want, _ := strconv.ParseFloat(t.Value, 64)
+		switch t.Op {
+		case "..":
+			upper, _ := strconv.ParseFloat(t.Upper, 64)
+			return value >= want && value <= upper
+		case ">":
+			return value > want
+		case ">=":
+			return value >= want
+		case "<":
+			return value < want
+		case "<=":
+			return value <= want
+		}
+		return value == want
+	}
+	text := map[string]string{
+		"title": book.Title, "author": book.Author, "publisher": book.Publisher,
+		"isbn": book.ISBN, "series": book.Series, "description": book.Description,
+	}[t.Field]
+	if field.folded {
+		return strings.Contains(memoryFold(text), memoryFold(t.Value))
+	}
+	return containsFold(text, t.Value)
+}
+
+func containsFold(s, substr string) bool {
+	return strings.Contains(strings.ToLower(s), strings.ToLower(substr))
+}
+
+// memoryFold lowercases and drops accents like library_fold.
+func memoryFold(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
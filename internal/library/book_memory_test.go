@@ -0,0 +1,94 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func TestMemoryBookRepo(t *testing.T) {
+	ctx := context.Background()
+	repo := library.NewMemoryBookRepo()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		book := entity.Book{
+			ID:         fmt.Sprintf("book-%d", i),
+			Title:      fmt.Sprintf("Title %d", i),
+			Author:     "Ann",
+			DocumentID: fmt.Sprintf("hash-%d", i),
+			CreatedAt:  created.Add(time.Duration(i) * time.Hour),
+			Private:    i == 5,
+			UploadedBy: "owner",
+		}
+		if err := repo.Store(ctx, book); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if err := repo.Store(ctx, entity.Book{ID: "copy", DocumentID: "hash-1"}); !errors.Is(err, entity.ErrBookAlreadyExists) {
+		t.Fatalf("expected a stored file to be refused, got %v", err)
+	}
+
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	reader := library.WithReader(entity.WithRole(ctx, entity.RoleEditor), "reader")
+
+	var titles []string
+	cursor := ""
+	for {
+		list, err := shelf.ListBooksByCursor(reader, library.BookFilter{}, "created_at", "asc", cursor, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, book := range list.Books {
+			titles = append(titles, book.Title)
+		}
+		if cursor = list.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if fmt.Sprint(titles) != "[Title 1 Title 2 Title 3 Title 4]" {
+		t.Errorf("expected the books others may see in order, got %v", titles)
+	}
+
+	stats, err := shelf.LibraryStats(reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Usage.Books != 4 || fmt.Sprint(stats.Authors, stats.Months) != "[{Ann 4}] [{2024-01 4}]" {
+		t.Errorf("unexpected stats of the books others may see %+v", stats)
+	}
+
+	_, err = shelf.BatchUpdateMetadata(reader, []string{"book-1", "book-5"}, library.MetadataPatch{Publisher: "Press"})
+	if !errors.Is(err, entity.ErrBookNotFound) {
+		t.Fatalf("expected the private book to be refused, got %v", err)
+	}
+	if book, _ := repo.GetById(ctx, "book-1"); book.Publisher != "" {
+		t.Errorf("expected no book to change, got publisher %q", book.Publisher)
+	}
+
+	failed := errors.New("failed")
+	err = repo.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := shelf.SaveReview(ctx, "reader", "book-2", 4, "good"); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected the error of the transaction, got %v", err)
+	}
+	if book, _ := repo.GetById(ctx, "book-2"); book.RatingCount != 0 {
+		t.Errorf("expected the review to be rolled back, got %d ratings", book.RatingCount)
+	}
+	if _, err = shelf.SaveReview(ctx, "reader", "book-2", 4, "good"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if book, _ := repo.GetById(ctx, "book-2"); book.Rating != 4 || book.RatingCount != 1 {
+		t.Errorf("expected the rating of the review, got %v from %d", book.Rating, book.RatingCount)
+	}
+}
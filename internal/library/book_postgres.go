@@ -3,34 +3,44 @@ package library
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/isbn"
 	"github.com/banjuer/kompanion/pkg/postgres"
+	"github.com/jackc/pgx/v5"
 	"github.com/shopspring/decimal"
 )
 
 type BookDatabaseRepo struct {
 	*postgres.Postgres
+
+	mu         sync.Mutex
+	collations map[string]string // locale -> ICU collation, empty when missing
 }
 
 func NewBookDatabaseRepo(pg *postgres.Postgres) *BookDatabaseRepo {
-	return &BookDatabaseRepo{pg}
+	return &BookDatabaseRepo{Postgres: pg, collations: make(map[string]string)}
 }
 
 func (bdr *BookDatabaseRepo) Store(ctx context.Context, book entity.Book) error {
 	query := `
-		INSERT INTO library_book (id, title, author, publisher, year, created_at, updated_at, isbn, storage_file_path, koreader_partial_md5, storage_cover_path, series, series_index, summary)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)
+		INSERT INTO library_book (id, title, author, publisher, year, created_at, updated_at, isbn, storage_file_path, koreader_partial_md5, storage_cover_path, series, series_index, summary, language, pages, file_size, media_type, duration_seconds, genres, uploaded_by, doi, cover_size, is_private)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, NULLIF($22, ''), $23, $24)
 	`
 	args := []interface{}{
 		book.ID, book.Title, book.Author, book.Publisher, book.Year,
 		book.CreatedAt, book.UpdatedAt, book.ISBN, book.FilePath,
 		book.DocumentID, book.CoverPath, book.Series, book.SeriesIndex, book.Description,
+		book.Language, book.Pages, book.FileSize, book.MediaType, int(book.Duration.Seconds()), genres(book.Genres),
+		book.UploadedBy, book.DOI, book.CoverSize, book.Private,
 	}
 
-	_, err := bdr.Pool.Exec(ctx, query, args...)
+	_, err := bdr.Conn(ctx).Exec(ctx, query, args...)
 	if err != nil {
 		if strings.Contains(err.Error(), "duplicate key value violates unique constraint") {
 			return fmt.Errorf("BookDatabaseRepo - Store - r.Pool.Exec: %w", entity.ErrBookAlreadyExists)
@@ -53,13 +63,16 @@ func (bdr *BookDatabaseRepo) Update(ctx context.Context, book entity.Book) error
 			series = $7,
 			series_index = $8,
 			summary = $9,
-			storage_cover_path = $10
-		WHERE id = $11
+			storage_cover_path = $10,
+			language = $11,
+			doi = NULLIF($12, ''),
+			cover_size = $13
+		WHERE id = $14 AND archived_at IS NULL
 	`
 	args := []interface{}{
-		book.Title, book.Author, book.Publisher, book.Year, book.UpdatedAt, book.ISBN, book.Series, book.SeriesIndex, book.Description, book.CoverPath, book.ID,
+		book.Title, book.Author, book.Publisher, book.Year, book.UpdatedAt, book.ISBN, book.Series, book.SeriesIndex, book.Description, book.CoverPath, book.Language, book.DOI, book.CoverSize, book.ID,
 	}
-	rows, err := bdr.Pool.Exec(ctx, query, args...)
+	rows, err := bdr.Conn(ctx).Exec(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("BookDatabaseRepo - Update - r.Pool.Exec: %w", err)
 	}
@@ -69,179 +82,266 @@ func (bdr *BookDatabaseRepo) Update(ctx context.Context, book entity.Book) error
 	return nil
 }
 
+// UpdateMany changes the books with ids in one transaction, all or none.
+// The rows are locked while change runs. The metadata is written with the
+// genres, files and covers are left alone.
+func (bdr *BookDatabaseRepo) UpdateMany(ctx context.Context, ids []string, change func(entity.Book) (entity.Book, error)) ([]entity.Book, error) {
+	tx, err := bdr.Conn(ctx).Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - UpdateMany - r.Pool.Begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	rows, err := tx.Query(ctx, `SELECT `+bookColumns+` FROM library_book WHERE id = ANY($1) ORDER BY id FOR UPDATE`, ids)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - UpdateMany - tx.Query: %w", err)
+	}
+	books, err := scanBooks(rows)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - UpdateMany - scanBooks: %w", err)
+	}
+	if len(books) < len(ids) {
+		return nil, fmt.Errorf("BookDatabaseRepo - UpdateMany - %w", entity.ErrBookNotFound)
+	}
+
+	for i, book := range books {
+		if book, err = change(book); err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(ctx, `
+			UPDATE library_book
+			SET title = $1, author = $2, publisher = $3, year = $4, isbn = $5, doi = NULLIF($6, ''), series = $7,
+				series_index = $8, summary = $9, language = $10, genres = $11, updated_at = $12
+			WHERE id = $13
+		`, book.Title, book.Author, book.Publisher, book.Year, book.ISBN, book.DOI, book.Series,
+			book.SeriesIndex, book.Description, book.Language, genres(book.Genres), book.UpdatedAt, book.ID)
+		if err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - UpdateMany - tx.Exec: %w", err)
+		}
+		books[i] = book
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - UpdateMany - tx.Commit: %w", err)
+	}
+	return books, nil
+}
+
+// ListByISBN returns the books with the ISBN, compared without hyphens
+// and spaces. ISBN-10 and ISBN-13 of a book find each other, books stored
+// before ISBNs were normalized may have either.
+func (bdr *BookDatabaseRepo) ListByISBN(ctx context.Context, value string) ([]entity.Book, error) {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT `+bookColumns+`
+		FROM library_book
+		WHERE upper(regexp_replace(isbn, '[- ]', '', 'g')) = ANY($1)
+		ORDER BY created_at, id
+	`, isbn.Forms(value))
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListByISBN - r.Pool.Query: %w", err)
+	}
+	books, err := scanBooks(rows)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListByISBN - scanBooks: %w", err)
+	}
+	return books, nil
+}
+
+// SetArchived archives the book at archivedAt, a zero time unarchives it.
+func (bdr *BookDatabaseRepo) SetArchived(ctx context.Context, id string, archivedAt time.Time) error {
+	var at *time.Time
+	if !archivedAt.IsZero() {
+		at = &archivedAt
+	}
+	rows, err := bdr.Conn(ctx).Exec(ctx, `UPDATE library_book SET archived_at = $1 WHERE id = $2`, at, id)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - SetArchived - r.Pool.Exec: %w", err)
+	}
+	if rows.RowsAffected() == 0 {
+		return fmt.Errorf("BookDatabaseRepo - SetArchived - %w", entity.ErrBookNotFound)
+	}
+	return nil
+}
+
+// SetVisibleFrom hides the book from all but admins until from, a zero
+// time clears it.
+func (bdr *BookDatabaseRepo) SetVisibleFrom(ctx context.Context, id string, from time.Time) error {
+	var at *time.Time
+	if !from.IsZero() {
+		at = &from
+	}
+	rows, err := bdr.Conn(ctx).Exec(ctx, `UPDATE library_book SET visible_from = $1 WHERE id = $2`, at, id)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - SetVisibleFrom - r.Pool.Exec: %w", err)
+	}
+	if rows.RowsAffected() == 0 {
+		return fmt.Errorf("BookDatabaseRepo - SetVisibleFrom - %w", entity.ErrBookNotFound)
+	}
+	return nil
+}
+
+// SetPrivate hides the book from everyone but its uploader, false shares it.
+func (bdr *BookDatabaseRepo) SetPrivate(ctx context.Context, id string, private bool) error {
+	rows, err := bdr.Conn(ctx).Exec(ctx, `UPDATE library_book SET is_private = $1 WHERE id = $2`, private, id)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - SetPrivate - r.Pool.Exec: %w", err)
+	}
+	if rows.RowsAffected() == 0 {
+		return fmt.Errorf("BookDatabaseRepo - SetPrivate - %w", entity.ErrBookNotFound)
+	}
+	return nil
+}
+
 func (bdr *BookDatabaseRepo) List(ctx context.Context,
+	filter BookFilter,
 	sortBy, sortOrder string,
 	page, perPage int,
 ) ([]entity.Book, error) {
-	switch sortOrder {
-	case "asc", "desc":
-	default:
-		sortOrder = "desc"
+	books, err := bdr.offsetQuery(ctx, "", filter, sortBy, sortOrder, page, perPage)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - List - %w", err)
 	}
+	return books, nil
+}
 
-	switch sortBy {
-	case "title", "author", "publisher", "year", "created_at", "updated_at", "isbn":
-	default:
-		sortBy = "created_at"
+func (bdr *BookDatabaseRepo) Search(ctx context.Context, query string, filter BookFilter, sortBy, sortOrder string, page, perPage int) ([]entity.Book, error) {
+	books, err := bdr.offsetQuery(ctx, query, filter, sortBy, sortOrder, page, perPage)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - Search - %w", err)
 	}
+	return books, nil
+}
+
+func (bdr *BookDatabaseRepo) offsetQuery(ctx context.Context,
+	query string, filter BookFilter,
+	sortBy, sortOrder string,
+	page, perPage int,
+) ([]entity.Book, error) {
+	sortBy, sortOrder = normalizeSort(sortBy, sortOrder)
 
 	if page <= 0 {
 		page = 1
 	}
-	if perPage <= 0 || perPage > 100 {
-		perPage = 25
+	if perPage <= 0 {
+		perPage = defaultPerPage
 	}
 
-	query := fmt.Sprintf(`
-		SELECT
-			id, title, author, publisher, year, created_at, updated_at, isbn, storage_file_path, koreader_partial_md5, storage_cover_path, series, series_index, summary
+	conditions, args := bookConditions(postgresDialect, query, filter)
+	orderBy, args := bdr.localizedSort(ctx, sortBy, sortColumns[sortBy].expr, args)
+	// id breaks ties, books sharing an author or year keep their place
+	// between pages like in keysetQuery
+	sqlQuery := fmt.Sprintf(`
+		SELECT `+bookColumns+`
 		FROM library_book
-		ORDER BY %s %s
+		%s
+		ORDER BY %s %s, id %s
 		LIMIT %d OFFSET %d
-	`, sortBy, sortOrder, perPage, (page-1)*perPage)
+	`, whereSQL(conditions), orderBy, sortOrder, sortOrder, perPage, (page-1)*perPage)
 
-	rows, err := bdr.Pool.Query(ctx, query)
+	rows, err := bdr.Conn(ctx).Query(ctx, sqlQuery, args...)
 	if err != nil {
-		return nil, fmt.Errorf("BookDatabaseRepo - List - r.Pool.Query: %w", err)
+		return nil, fmt.Errorf("r.Pool.Query: %w", err)
 	}
 	defer rows.Close()
 
-	books := make([]entity.Book, 0)
-	for rows.Next() {
-		var book entity.Book
-		var seriesIndex decimal.NullDecimal
-		var summary sql.NullString
-		var author sql.NullString
-		var publisher sql.NullString
-		var isbn sql.NullString
-		var coverPath sql.NullString
-		var series sql.NullString
-		err = rows.Scan(&book.ID, &book.Title, &author, &publisher, &book.Year, &book.CreatedAt, &book.UpdatedAt, &isbn, &book.FilePath, &book.DocumentID, &coverPath, &series, &seriesIndex, &summary)
-		if err != nil {
-			return nil, fmt.Errorf("BookDatabaseRepo - List - rows.Scan: %w", err)
-		}
-		if seriesIndex.Valid {
-			book.SeriesIndex = &seriesIndex
-		}
-		if summary.Valid {
-			book.Description = summary.String
-		}
-		if author.Valid {
-			book.Author = author.String
-		}
-		if publisher.Valid {
-			book.Publisher = publisher.String
-		}
-		if isbn.Valid {
-			book.ISBN = isbn.String
-		}
-		if coverPath.Valid {
-			book.CoverPath = coverPath.String
-		}
-		if series.Valid {
-			book.Series = series.String
-		}
-		books = append(books, book)
+	books, err := scanBooks(rows)
+	if err != nil {
+		return nil, fmt.Errorf("scanBooks: %w", err)
 	}
 
 	return books, nil
 }
 
-func (bdr *BookDatabaseRepo) Search(ctx context.Context, query string, sortBy, sortOrder string, page, perPage int) ([]entity.Book, error) {
-	switch sortOrder {
-	case "asc", "desc":
-	default:
-		sortOrder = "desc"
+// ListByCursor returns up to limit books after (or before) the cursor in keyset order.
+func (bdr *BookDatabaseRepo) ListByCursor(ctx context.Context, filter BookFilter, sortBy, sortOrder string, cursor Cursor, limit int) ([]entity.Book, error) {
+	books, err := bdr.keysetQuery(ctx, "", filter, sortBy, sortOrder, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListByCursor - %w", err)
 	}
+	return books, nil
+}
 
-	switch sortBy {
-	case "title", "author", "publisher", "year", "created_at", "updated_at", "isbn":
-	default:
-		sortBy = "created_at"
+func (bdr *BookDatabaseRepo) SearchByCursor(ctx context.Context, query string, filter BookFilter, sortBy, sortOrder string, cursor Cursor, limit int) ([]entity.Book, error) {
+	books, err := bdr.keysetQuery(ctx, query, filter, sortBy, sortOrder, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - SearchByCursor - %w", err)
 	}
+	return books, nil
+}
 
-	if page <= 0 {
-		page = 1
+func (bdr *BookDatabaseRepo) keysetQuery(ctx context.Context,
+	query string, filter BookFilter,
+	sortBy, sortOrder string,
+	cursor Cursor, limit int,
+) ([]entity.Book, error) {
+	sortBy, sortOrder = normalizeSort(sortBy, sortOrder)
+	column := sortColumns[sortBy]
+
+	// walking backwards reverses the order, rows are flipped back below
+	direction, cmp := sortOrder, ">"
+	if sortOrder == "desc" {
+		cmp = "<"
 	}
-	if perPage <= 0 || perPage > 100 {
-		perPage = 25
+	if cursor.Before {
+		direction, cmp = reverseOrder(direction), reverseCmp(cmp)
 	}
 
-	searchPattern := "%" + query + "%"
+	conditions, args := bookConditions(postgresDialect, query, filter)
+	expr, args := bdr.localizedSort(ctx, sortBy, column.expr, args)
+	if !cursor.IsZero() {
+		args = append(args, cursor.Key, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d::%s, $%d::uuid)", expr, cmp, len(args)-1, column.typ, len(args)))
+	}
 
 	sqlQuery := fmt.Sprintf(`
-		SELECT
-			id, title, author, publisher, year, created_at, updated_at, isbn, storage_file_path, koreader_partial_md5, storage_cover_path, series, series_index, summary
+		SELECT `+bookColumns+`
 		FROM library_book
-		WHERE title ILIKE $1
-		   OR author ILIKE $1
-		   OR publisher ILIKE $1
-		   OR isbn ILIKE $1
-		ORDER BY %s %s
-		LIMIT %d OFFSET %d
-	`, sortBy, sortOrder, perPage, (page-1)*perPage)
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT %d
+	`, whereSQL(conditions), expr, direction, direction, limit)
 
-	rows, err := bdr.Pool.Query(ctx, sqlQuery, searchPattern)
+	rows, err := bdr.Conn(ctx).Query(ctx, sqlQuery, args...)
 	if err != nil {
-		return nil, fmt.Errorf("BookDatabaseRepo - Search - r.Pool.Query: %w", err)
+		return nil, fmt.Errorf("r.Pool.Query: %w", err)
 	}
 	defer rows.Close()
 
-	books := make([]entity.Book, 0)
-	for rows.Next() {
-		var book entity.Book
-		var seriesIndex decimal.NullDecimal
-		var summary sql.NullString
-		var author sql.NullString
-		var publisher sql.NullString
-		var isbn sql.NullString
-		var coverPath sql.NullString
-		var series sql.NullString
-		err = rows.Scan(&book.ID, &book.Title, &author, &publisher, &book.Year, &book.CreatedAt, &book.UpdatedAt, &isbn, &book.FilePath, &book.DocumentID, &coverPath, &series, &seriesIndex, &summary)
-		if err != nil {
-			return nil, fmt.Errorf("BookDatabaseRepo - Search - rows.Scan: %w", err)
-		}
-		if seriesIndex.Valid {
-			book.SeriesIndex = &seriesIndex
-		}
-		if summary.Valid {
-			book.Description = summary.String
-		}
-		if author.Valid {
-			book.Author = author.String
-		}
-		if publisher.Valid {
-			book.Publisher = publisher.String
-		}
-		if isbn.Valid {
-			book.ISBN = isbn.String
-		}
-		if coverPath.Valid {
-			book.CoverPath = coverPath.String
-		}
-		if series.Valid {
-			book.Series = series.String
+	books, err := scanBooks(rows)
+	if err != nil {
+		return nil, fmt.Errorf("scanBooks: %w", err)
+	}
+	if cursor.Before {
+		for i, j := 0, len(books)-1; i < j; i, j = i+1, j-1 {
+			books[i], books[j] = books[j], books[i]
 		}
-		books = append(books, book)
 	}
 
 	return books, nil
 }
 
-func (bdr *BookDatabaseRepo) CountSearch(ctx context.Context, query string) (int, error) {
-	searchPattern := "%" + query + "%"
+func reverseOrder(order string) string {
+	if order == "asc" {
+		return "desc"
+	}
+	return "asc"
+}
 
+func reverseCmp(cmp string) string {
+	if cmp == ">" {
+		return "<"
+	}
+	return ">"
+}
+
+func (bdr *BookDatabaseRepo) CountSearch(ctx context.Context, query string, filter BookFilter) (int, error) {
+	conditions, args := bookConditions(postgresDialect, query, filter)
 	sqlQuery := `
 		SELECT COUNT(*)
 		FROM library_book
-		WHERE title ILIKE $1
-		   OR author ILIKE $1
-		   OR publisher ILIKE $1
-		   OR isbn ILIKE $1
-	`
+	` + whereSQL(conditions)
 
 	var count int
-	err := bdr.Pool.QueryRow(ctx, sqlQuery, searchPattern).Scan(&count)
+	err := bdr.Conn(ctx).QueryRow(ctx, sqlQuery, args...).Scan(&count)
 	if err != nil {
 		return 0, fmt.Errorf("BookDatabaseRepo - CountSearch - r.Pool.QueryRow: %w", err)
 	}
@@ -249,102 +349,136 @@ func (bdr *BookDatabaseRepo) CountSearch(ctx context.Context, query string) (int
 	return count, nil
 }
 
+// Facets counts the books matching query and filter in one grouped query.
+// Authors spelled with and without accents or in another case are one
+// entry under their most common spelling. Formats count the other formats
+// of a book too, decades are the first year of the decade.
+func (bdr *BookDatabaseRepo) Facets(ctx context.Context, query string, filter BookFilter) (BookFacets, error) {
+	conditions, args := bookConditions(postgresDialect, query, filter)
+	sqlQuery := `
+		WITH matched AS (
+			SELECT id, author, year, genres, storage_file_path
+			FROM library_book
+			` + whereSQL(conditions) + `
+		)
+		SELECT 'author', mode() WITHIN GROUP (ORDER BY author), count(*)
+		FROM matched WHERE COALESCE(author, '') <> '' GROUP BY library_fold(author)
+		UNION ALL
+		SELECT 'format', format, count(DISTINCT id) FROM (
+			SELECT id, lower(substring(storage_file_path from '\.([^./]+)$')) AS format FROM matched
+			UNION SELECT f.book_id, f.format FROM library_book_file f JOIN matched m ON m.id = f.book_id
+		) formats WHERE format IS NOT NULL GROUP BY format
+		UNION ALL
+		SELECT 'genre', genre, count(*) FROM matched, unnest(genres) AS genre GROUP BY genre
+		UNION ALL
+		SELECT 'decade', (year / 10 * 10)::text, count(*) FROM matched WHERE year > 0 GROUP BY year / 10
+		ORDER BY 1, 3 DESC, 2
+	`
+
+	rows, err := bdr.Conn(ctx).Query(ctx, sqlQuery, args...)
+	if err != nil {
+		return BookFacets{}, fmt.Errorf("BookDatabaseRepo - Facets - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	var facets BookFacets
+	for rows.Next() {
+		var facet, value string
+		var count int
+		if err = rows.Scan(&facet, &value, &count); err != nil {
+			return BookFacets{}, fmt.Errorf("BookDatabaseRepo - Facets - rows.Scan: %w", err)
+		}
+		facets.add(facet, value, count)
+	}
+	if err = rows.Err(); err != nil {
+		return BookFacets{}, fmt.Errorf("BookDatabaseRepo - Facets - rows.Err: %w", err)
+	}
+	return facets, nil
+}
+
 func (bdr *BookDatabaseRepo) GetById(ctx context.Context, id string) (entity.Book, error) {
 	query := `
-		SELECT id, title, author, publisher, year, created_at, updated_at, isbn, storage_file_path, koreader_partial_md5, storage_cover_path, series, series_index, summary
+		SELECT ` + bookColumns + `
 		FROM library_book
 		WHERE id = $1
 	`
 	args := []interface{}{id}
 
-	row := bdr.Pool.QueryRow(ctx, query, args...)
-	var book entity.Book
-	var seriesIndex decimal.NullDecimal
-	var summary sql.NullString
-	var author sql.NullString
-	var publisher sql.NullString
-	var isbn sql.NullString
-	var coverPath sql.NullString
-	var series sql.NullString
-	err := row.Scan(&book.ID, &book.Title, &author, &publisher, &book.Year, &book.CreatedAt, &book.UpdatedAt, &isbn, &book.FilePath, &book.DocumentID, &coverPath, &series, &seriesIndex, &summary)
+	book, err := scanBook(bdr.Conn(ctx).QueryRow(ctx, query, args...))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.Book{}, fmt.Errorf("BookDatabaseRepo - Get - %w", entity.ErrBookNotFound)
+	}
 	if err != nil {
 		return entity.Book{}, fmt.Errorf("BookDatabaseRepo - Get - r.Pool.QueryRow: %w", err)
 	}
-	if seriesIndex.Valid {
-		book.SeriesIndex = &seriesIndex
-	}
-	if summary.Valid {
-		book.Description = summary.String
-	}
-	if author.Valid {
-		book.Author = author.String
-	}
-	if publisher.Valid {
-		book.Publisher = publisher.String
-	}
-	if isbn.Valid {
-		book.ISBN = isbn.String
-	}
-	if coverPath.Valid {
-		book.CoverPath = coverPath.String
-	}
-	if series.Valid {
-		book.Series = series.String
-	}
 
 	return book, nil
 }
 
 func (bdr *BookDatabaseRepo) GetByFileHash(ctx context.Context, fileHash string) (entity.Book, error) {
 	query := `
-		SELECT id, title, author, publisher, year, created_at, updated_at, isbn, storage_file_path, koreader_partial_md5, storage_cover_path, series, series_index, summary
+		SELECT ` + bookColumns + `
 		FROM library_book
 		WHERE koreader_partial_md5 = $1
+			OR id = (SELECT book_id FROM library_book_file WHERE koreader_partial_md5 = $1)
 	`
 	args := []interface{}{fileHash}
 
-	row := bdr.Pool.QueryRow(ctx, query, args...)
-	var book entity.Book
-	var seriesIndex decimal.NullDecimal
-	var summary sql.NullString
-	var author sql.NullString
-	var publisher sql.NullString
-	var isbn sql.NullString
-	var coverPath sql.NullString
-	var series sql.NullString
-	err := row.Scan(&book.ID, &book.Title, &author, &publisher, &book.Year, &book.CreatedAt, &book.UpdatedAt, &isbn, &book.FilePath, &book.DocumentID, &coverPath, &series, &seriesIndex, &summary)
+	book, err := scanBook(bdr.Conn(ctx).QueryRow(ctx, query, args...))
 	if err != nil {
 		return entity.Book{}, fmt.Errorf("BookDatabaseRepo - GetByFileHash - r.Pool.QueryRow: %w", err)
 	}
-	if seriesIndex.Valid {
-		book.SeriesIndex = &seriesIndex
-	}
-	if summary.Valid {
-		book.Description = summary.String
-	}
-	if author.Valid {
-		book.Author = author.String
-	}
-	if publisher.Valid {
-		book.Publisher = publisher.String
-	}
-	if isbn.Valid {
-		book.ISBN = isbn.String
-	}
-	if coverPath.Valid {
-		book.CoverPath = coverPath.String
+
+	return book, nil
+}
+
+// StorageUsage sums the files of the books an account uploaded, the other
+// formats of the books counted with them.
+func (bdr *BookDatabaseRepo) StorageUsage(ctx context.Context, username string) (entity.StorageUsage, error) {
+	query := `
+		SELECT count(*), COALESCE(sum(b.file_size), 0)::bigint, COALESCE(sum(f.bytes), 0)::bigint, COALESCE(sum(b.cover_size), 0)::bigint
+		FROM library_book b
+		LEFT JOIN (SELECT book_id, sum(file_size) AS bytes FROM library_book_file GROUP BY book_id) f ON f.book_id = b.id
+		WHERE b.uploaded_by = $1
+	`
+	var usage entity.StorageUsage
+	err := bdr.Conn(ctx).QueryRow(ctx, query, username).Scan(&usage.Books, &usage.FileBytes, &usage.FormatBytes, &usage.CoverBytes)
+	if err != nil {
+		return entity.StorageUsage{}, fmt.Errorf("BookDatabaseRepo - StorageUsage - r.Pool.QueryRow: %w", err)
 	}
-	if series.Valid {
-		book.Series = series.String
+	return usage, nil
+}
+
+// LibraryUsage sums the files of all books.
+func (bdr *BookDatabaseRepo) LibraryUsage(ctx context.Context) (entity.StorageUsage, error) {
+	query := `
+		SELECT count(*), COALESCE(sum(b.file_size), 0)::bigint, COALESCE(sum(f.bytes), 0)::bigint, COALESCE(sum(b.cover_size), 0)::bigint
+		FROM library_book b
+		LEFT JOIN (SELECT book_id, sum(file_size) AS bytes FROM library_book_file GROUP BY book_id) f ON f.book_id = b.id
+	`
+	var usage entity.StorageUsage
+	err := bdr.Conn(ctx).QueryRow(ctx, query).Scan(&usage.Books, &usage.FileBytes, &usage.FormatBytes, &usage.CoverBytes)
+	if err != nil {
+		return entity.StorageUsage{}, fmt.Errorf("BookDatabaseRepo - LibraryUsage - r.Pool.QueryRow: %w", err)
 	}
+	return usage, nil
+}
 
-	return book, nil
+// CountUploadedBy counts the books an account uploaded.
+func (bdr *BookDatabaseRepo) CountUploadedBy(ctx context.Context, username string) (int, error) {
+	var count int
+	err := bdr.Conn(ctx).QueryRow(ctx, `SELECT count(*) FROM library_book WHERE uploaded_by = $1`, username).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("BookDatabaseRepo - CountUploadedBy - r.Pool.QueryRow: %w", err)
+	}
+	return count, nil
 }
 
-func (bdr *BookDatabaseRepo) Count(ctx context.Context) (int, error) {
-	sqlQuery := `SELECT count(*) FROM library_book`
+func (bdr *BookDatabaseRepo) Count(ctx context.Context, filter BookFilter) (int, error) {
+	conditions, args := bookConditions(postgresDialect, "", filter)
+	sqlQuery := `SELECT count(*) FROM library_book ` + whereSQL(conditions)
 
-	row := bdr.Pool.QueryRow(ctx, sqlQuery)
+	row := bdr.Conn(ctx).QueryRow(ctx, sqlQuery, args...)
 	var count int
 	err := row.Scan(&count)
 	if err != nil {
@@ -354,17 +488,347 @@ func (bdr *BookDatabaseRepo) Count(ctx context.Context) (int, error) {
 	return count, nil
 }
 
+// EstimateCount returns the planner estimate of the number of books, it is
+// negative before the table was first analyzed.
+func (bdr *BookDatabaseRepo) EstimateCount(ctx context.Context) (int, error) {
+	var estimate float64
+	err := bdr.Conn(ctx).QueryRow(ctx, `SELECT reltuples FROM pg_class WHERE oid = 'library_book'::regclass`).Scan(&estimate)
+	if err != nil {
+		return 0, fmt.Errorf("BookDatabaseRepo - EstimateCount - r.Pool.QueryRow: %w", err)
+	}
+	return int(estimate), nil
+}
+
+// randomSampleMin is the estimated library size from which Random draws
+// from a sample of the table instead of shuffling every matching book.
+const randomSampleMin = 10000
+
+// randomOversample - the sample holds this many times the books wanted,
+// so filters leave enough of them.
+const randomOversample = 20
+
+// Random returns up to n books matching filter in random order. Large
+// libraries are sampled first, the planner estimate of the table size
+// keeps the guard from counting every row; a sample the filter left
+// short falls back to shuffling all matching books.
+func (bdr *BookDatabaseRepo) Random(ctx context.Context, filter BookFilter, n int) ([]entity.Book, error) {
+	var estimate float64
+	err := bdr.Conn(ctx).QueryRow(ctx, `SELECT reltuples FROM pg_class WHERE oid = 'library_book'::regclass`).Scan(&estimate)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - Random - r.Pool.QueryRow: %w", err)
+	}
+	if estimate >= randomSampleMin {
+		percent := min(100, 100*float64(n*randomOversample)/estimate)
+		books, err := bdr.randomQuery(ctx, fmt.Sprintf("TABLESAMPLE BERNOULLI (%g)", percent), filter, n)
+		if err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - Random - %w", err)
+		}
+		if len(books) == n {
+			return books, nil
+		}
+	}
+	books, err := bdr.randomQuery(ctx, "", filter, n)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - Random - %w", err)
+	}
+	return books, nil
+}
+
+func (bdr *BookDatabaseRepo) randomQuery(ctx context.Context, sample string, filter BookFilter, n int) ([]entity.Book, error) {
+	conditions, args := bookConditions(postgresDialect, "", filter)
+	sqlQuery := fmt.Sprintf(`
+		SELECT `+bookColumns+`
+		FROM library_book %s
+		%s
+		ORDER BY random()
+		LIMIT %d
+	`, sample, whereSQL(conditions), n)
+
+	rows, err := bdr.Conn(ctx).Query(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	books, err := scanBooks(rows)
+	if err != nil {
+		return nil, fmt.Errorf("scanBooks: %w", err)
+	}
+	return books, nil
+}
+
+// Languages lists languages present in the library for the filter menu.
+func (bdr *BookDatabaseRepo) Languages(ctx context.Context) ([]string, error) {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT DISTINCT language
+		FROM library_book
+		WHERE language IS NOT NULL AND language <> ''
+		ORDER BY language
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - Languages - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	languages := make([]string, 0)
+	for rows.Next() {
+		var language string
+		if err = rows.Scan(&language); err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - Languages - rows.Scan: %w", err)
+		}
+		languages = append(languages, language)
+	}
+	return languages, rows.Err()
+}
+
+// Reindex rebuilds the library indexes and refreshes the planner
+// statistics, e.g. after a bulk import or a restore.
+func (bdr *BookDatabaseRepo) Reindex(ctx context.Context) error {
+	for _, query := range []string{`REINDEX TABLE library_book`, `ANALYZE library_book`} {
+		if _, err := bdr.Conn(ctx).Exec(ctx, query); err != nil {
+			return fmt.Errorf("BookDatabaseRepo - Reindex - r.Pool.Exec: %w", err)
+		}
+	}
+	return nil
+}
+
 func (bdr *BookDatabaseRepo) Delete(ctx context.Context, id string) error {
 	query := `
 		DELETE FROM library_book
-		WHERE id = $1
+		WHERE id = $1 AND archived_at IS NULL
 	`
 	args := []interface{}{id}
 
-	_, err := bdr.Pool.Exec(ctx, query, args...)
+	rows, err := bdr.Conn(ctx).Exec(ctx, query, args...)
 	if err != nil {
 		return fmt.Errorf("BookDatabaseRepo - Delete - r.Pool.Exec: %w", err)
 	}
+	if rows.RowsAffected() == 0 {
+		return fmt.Errorf("BookDatabaseRepo - Delete - no rows affected")
+	}
 
 	return nil
 }
+
+// bookConditions builds the WHERE conditions shared by listing, search and
+// counting, in the SQL of the database d. The query is parsed with ParseSearchQuery, SearchBooks rejects
+// invalid ones before this, here they are searched as a single phrase.
+func bookConditions(d dialect, query string, filter BookFilter) ([]string, []interface{}) {
+	conditions := make([]string, 0, 2)
+	args := make([]interface{}, 0, 2)
+	if query != "" {
+		q, err := ParseSearchQuery(query)
+		if err != nil {
+			q = SearchQuery{{Value: query}}
+		}
+		conditions, args = q.conditions(d, args)
+	}
+	if filter.Language != "" {
+		args = append(args, filter.Language)
+		conditions = append(conditions, fmt.Sprintf("language = $%d", len(args)))
+	}
+	if filter.MediaType != "" {
+		args = append(args, filter.MediaType)
+		conditions = append(conditions, fmt.Sprintf("media_type = $%d", len(args)))
+	}
+	if filter.CoverPath != "" {
+		args = append(args, filter.CoverPath)
+		conditions = append(conditions, fmt.Sprintf("storage_cover_path = $%d", len(args)))
+	}
+	if filter.FilePath != "" {
+		args = append(args, filter.FilePath)
+		conditions = append(conditions, fmt.Sprintf("storage_file_path = $%d", len(args)))
+	}
+	if filter.Username != "" && filter.Status != "" {
+		args = append(args, filter.Username, string(filter.Status))
+		conditions = append(conditions, fmt.Sprintf("id IN (SELECT book_id FROM reading_status WHERE username = $%d AND status = $%d)", len(args)-1, len(args)))
+	}
+	if filter.Restricted {
+		conditions = append(conditions, privateCondition("", filter, &args))
+	}
+	if filter.HideScheduled {
+		conditions = append(conditions, "(visible_from IS NULL OR visible_from <= now())")
+	}
+	if filter.GroupEditions {
+		conditions = append(conditions, editionCondition(filter, &args))
+	}
+	return conditions, args
+}
+
+// privateCondition hides the books of table other accounts than the
+// reader keep private, unless they are lent to the reader.
+func privateCondition(table string, filter BookFilter, args *[]interface{}) string {
+	if filter.Reader == "" {
+		return "NOT " + table + "is_private"
+	}
+	*args = append(*args, filter.Reader)
+	n := len(*args)
+	return fmt.Sprintf(`(NOT %sis_private OR %suploaded_by = $%d OR %sid IN (
+		SELECT book_id FROM library_book_loan WHERE borrower = $%d AND ended_at IS NULL AND expires_at > now()))`,
+		table, table, n, table, n)
+}
+
+// editionCondition hides a book when an older book of its work matches
+// the filter too, the oldest one stands for the work.
+func editionCondition(filter BookFilter, args *[]interface{}) string {
+	same := ""
+	if filter.Language != "" {
+		*args = append(*args, filter.Language)
+		same += fmt.Sprintf(" AND first.language = $%d", len(*args))
+	}
+	if filter.MediaType != "" {
+		*args = append(*args, filter.MediaType)
+		same += fmt.Sprintf(" AND first.media_type = $%d", len(*args))
+	}
+	if filter.Username != "" && filter.Status != "" {
+		*args = append(*args, filter.Username, string(filter.Status))
+		same += fmt.Sprintf(" AND first.id IN (SELECT book_id FROM reading_status WHERE username = $%d AND status = $%d)", len(*args)-1, len(*args))
+	}
+	if filter.Restricted {
+		same += " AND " + privateCondition("first.", filter, args)
+	}
+	if filter.HideScheduled {
+		same += " AND (first.visible_from IS NULL OR first.visible_from <= now())"
+	}
+	return `NOT EXISTS (
+			SELECT 1 FROM library_book_edition e
+			JOIN library_book_edition o ON o.work_id = e.work_id AND o.book_id <> e.book_id
+			JOIN library_book first ON first.id = o.book_id
+			WHERE e.book_id = library_book.id
+				AND (first.created_at, first.id) < (library_book.created_at, library_book.id)` + same + `
+		)`
+}
+
+func whereSQL(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return "WHERE " + strings.Join(conditions, " AND ")
+}
+
+// localizedSort applies the request locale to text sorting: leading articles
+// are ignored in titles and the ICU collation of the language is used when
+// the server has one. The article pattern is passed as a parameter.
+func (bdr *BookDatabaseRepo) localizedSort(ctx context.Context, sortBy, expr string, args []interface{}) (string, []interface{}) {
+	locale := LocaleFrom(ctx)
+	if locale == "" {
+		return expr, args
+	}
+	switch sortBy {
+	case "title":
+		if pattern := articlePattern(locale); pattern != "" {
+			args = append(args, pattern)
+			expr = fmt.Sprintf("regexp_replace(%s, $%d, '', 'i')", expr, len(args))
+		}
+	case "author", "publisher":
+	default:
+		return expr, args
+	}
+	if collation := bdr.collation(ctx, locale); collation != "" {
+		expr = fmt.Sprintf("(%s COLLATE %s)", expr, pgx.Identifier{collation}.Sanitize())
+	}
+	return expr, args
+}
+
+// collation looks up the ICU collation for a locale once, Postgres built
+// without ICU has none and falls back to the database collation.
+func (bdr *BookDatabaseRepo) collation(ctx context.Context, locale string) string {
+	bdr.mu.Lock()
+	defer bdr.mu.Unlock()
+	if collation, ok := bdr.collations[locale]; ok {
+		return collation
+	}
+
+	var collation string
+	err := bdr.Conn(ctx).QueryRow(ctx, `SELECT collname FROM pg_collation WHERE collname = $1`, locale+"-x-icu").Scan(&collation)
+	if err != nil && !errors.Is(err, pgx.ErrNoRows) {
+		return ""
+	}
+	bdr.collations[locale] = collation
+	return collation
+}
+
+type sortColumn struct {
+	expr string
+	typ  string
+}
+
+// sortColumns are the keyset expressions per sortBy. NULLs are coalesced,
+// a row comparison with NULL would drop the book from every page.
+var sortColumns = map[string]sortColumn{
+	"title":      {"title", "text"},
+	"author":     {"COALESCE(author, '')", "text"},
+	"publisher":  {"COALESCE(publisher, '')", "text"},
+	"isbn":       {"COALESCE(isbn, '')", "text"},
+	"year":       {"COALESCE(year, 0)", "int"},
+	"created_at": {"created_at", "timestamptz"},
+	"updated_at": {"updated_at", "timestamptz"},
+	"size":       {"COALESCE(file_size, 0)", "bigint"},
+	"pages":      {"COALESCE(pages, 0)", "int"},
+	"rating":     {"COALESCE(rating_avg, 0)", "numeric"},
+}
+
+// bookColumns matches the Scan order of scanBook
+const bookColumns = `id, title, author, publisher, year, created_at, updated_at, isbn, storage_file_path, koreader_partial_md5, storage_cover_path, series, series_index, summary, language, pages, file_size, rating_avg::float8, rating_count, archived_at, media_type, duration_seconds, genres, doi, cover_size, uploaded_by, is_private, visible_from`
+
+func scanBook(row pgx.Row) (entity.Book, error) {
+	var book entity.Book
+	var seriesIndex decimal.NullDecimal
+	var summary sql.NullString
+	var author sql.NullString
+	var publisher sql.NullString
+	var isbn sql.NullString
+	var coverPath sql.NullString
+	var series sql.NullString
+	var language sql.NullString
+	var pages sql.NullInt32
+	var fileSize sql.NullInt64
+	var rating sql.NullFloat64
+	var archivedAt sql.NullTime
+	var duration sql.NullInt32
+	var doi sql.NullString
+	var visibleFrom sql.NullTime
+	err := row.Scan(&book.ID, &book.Title, &author, &publisher, &book.Year, &book.CreatedAt, &book.UpdatedAt, &isbn, &book.FilePath, &book.DocumentID, &coverPath, &series, &seriesIndex, &summary, &language, &pages, &fileSize, &rating, &book.RatingCount, &archivedAt, &book.MediaType, &duration, &book.Genres, &doi, &book.CoverSize, &book.UploadedBy, &book.Private, &visibleFrom)
+	if err != nil {
+		return entity.Book{}, err
+	}
+	if seriesIndex.Valid {
+		book.SeriesIndex = &seriesIndex
+	}
+	book.Description = summary.String
+	book.Author = author.String
+	book.Publisher = publisher.String
+	book.ISBN = isbn.String
+	book.CoverPath = coverPath.String
+	book.Series = series.String
+	book.Language = language.String
+	book.Pages = int(pages.Int32)
+	book.FileSize = fileSize.Int64
+	book.Rating = rating.Float64
+	book.ArchivedAt = archivedAt.Time
+	book.Duration = time.Duration(duration.Int32) * time.Second
+	book.DOI = doi.String
+	book.VisibleFrom = visibleFrom.Time
+
+	return book, nil
+}
+
+// genres stores an empty list instead of NULL, the column is not nullable.
+func genres(list []string) []string {
+	if list == nil {
+		return []string{}
+	}
+	return list
+}
+
+func scanBooks(rows pgx.Rows) ([]entity.Book, error) {
+	books := make([]entity.Book, 0)
+	for rows.Next() {
+		book, err := scanBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
@@ -2,12 +2,15 @@ package library_test
 
 import (
 	"context"
+	"errors"
+	"reflect"
 	"testing"
 	"time"
 
 	"github.com/banjuer/kompanion/internal/entity"
 	"github.com/banjuer/kompanion/internal/library"
 	"github.com/banjuer/kompanion/pkg/postgres"
+	"github.com/jackc/pgx/v5"
 	"github.com/pashagolub/pgxmock/v4"
 	"github.com/shopspring/decimal"
 )
@@ -29,13 +32,17 @@ func TestBookDatabaseRepoCreate(t *testing.T) {
 		CoverPath:   "cover_path",
 		Series:      "Test Series",
 		SeriesIndex: &seriesIndex,
+		Language:    "en",
+		Pages:       320,
+		FileSize:    1048576,
+		MediaType:   entity.MediaTypeBook,
 	}
 
 	mock, bdr := setupTestBookDatabaseRepo()
 	defer mock.Close()
 
 	mock.ExpectExec("INSERT INTO library_book").
-		WithArgs(book.ID, book.Title, book.Author, book.Publisher, book.Year, book.CreatedAt, book.UpdatedAt, book.ISBN, book.FilePath, book.DocumentID, book.CoverPath, book.Series, book.SeriesIndex, book.Description).
+		WithArgs(book.ID, book.Title, book.Author, book.Publisher, book.Year, book.CreatedAt, book.UpdatedAt, book.ISBN, book.FilePath, book.DocumentID, book.CoverPath, book.Series, book.SeriesIndex, book.Description, book.Language, book.Pages, book.FileSize, book.MediaType, 0, []string{}, "", book.DOI, book.CoverSize, false).
 		WillReturnResult(pgxmock.NewResult("INSERT", 1))
 
 	err := bdr.Store(context.Background(), book)
@@ -58,13 +65,16 @@ func TestBookDatabaseRepoUpdateStoresCoverPath(t *testing.T) {
 		CoverPath:   "covers/1.jpg",
 		Series:      "Test Series",
 		SeriesIndex: &seriesIndex,
+		Language:    "en",
+		Pages:       320,
+		FileSize:    1048576,
 	}
 
 	mock, bdr := setupTestBookDatabaseRepo()
 	defer mock.Close()
 
 	mock.ExpectExec("UPDATE library_book").
-		WithArgs(book.Title, book.Author, book.Publisher, book.Year, book.UpdatedAt, book.ISBN, book.Series, book.SeriesIndex, book.Description, book.CoverPath, book.ID).
+		WithArgs(book.Title, book.Author, book.Publisher, book.Year, book.UpdatedAt, book.ISBN, book.Series, book.SeriesIndex, book.Description, book.CoverPath, book.Language, book.DOI, book.CoverSize, book.ID).
 		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
 
 	err := bdr.Update(context.Background(), book)
@@ -90,13 +100,16 @@ func TestBookDatabaseRepoGetById(t *testing.T) {
 		CoverPath:   "cover_path",
 		Series:      "Test Series",
 		SeriesIndex: &seriesIndex,
+		Language:    "en",
+		Pages:       320,
+		FileSize:    1048576,
 	}
 
 	mock, bdr := setupTestBookDatabaseRepo()
 	defer mock.Close()
 
-	rows := pgxmock.NewRows([]string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary"}).
-		AddRow(book.ID, book.Title, book.Author, book.Publisher, book.Year, book.CreatedAt, book.UpdatedAt, book.ISBN, book.FilePath, book.DocumentID, book.CoverPath, book.Series, "2", book.Description)
+	rows := pgxmock.NewRows([]string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary", "language", "pages", "file_size", "rating_avg", "rating_count", "archived_at", "media_type", "duration_seconds", "genres", "doi", "cover_size", "uploaded_by", "is_private", "visible_from"}).
+		AddRow(book.ID, book.Title, book.Author, book.Publisher, book.Year, book.CreatedAt, book.UpdatedAt, book.ISBN, book.FilePath, book.DocumentID, book.CoverPath, book.Series, "2", book.Description, book.Language, book.Pages, book.FileSize, nil, 0, nil, "book", nil, []string{"sf_fantasy"}, nil, int64(0), "", false, nil)
 
 	mock.ExpectQuery("SELECT (.+) FROM library_book").
 		WithArgs(book.ID).
@@ -110,6 +123,9 @@ func TestBookDatabaseRepoGetById(t *testing.T) {
 	if result.DocumentID != book.DocumentID {
 		t.Errorf("expected DocumentID %v, got %v", book.DocumentID, result.DocumentID)
 	}
+	if len(result.Genres) != 1 || result.Genres[0] != "sf_fantasy" {
+		t.Errorf("expected genres [sf_fantasy], got %v", result.Genres)
+	}
 }
 
 func TestBookDatabaseRepoGetByFileHash(t *testing.T) {
@@ -129,13 +145,16 @@ func TestBookDatabaseRepoGetByFileHash(t *testing.T) {
 		CoverPath:   "cover_path",
 		Series:      "Test Series",
 		SeriesIndex: &seriesIndex,
+		Language:    "en",
+		Pages:       320,
+		FileSize:    1048576,
 	}
 
 	mock, bdr := setupTestBookDatabaseRepo()
 	defer mock.Close()
 
-	rows := pgxmock.NewRows([]string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary"}).
-		AddRow(book.ID, book.Title, book.Author, book.Publisher, book.Year, book.CreatedAt, book.UpdatedAt, book.ISBN, book.FilePath, book.DocumentID, book.CoverPath, book.Series, "1", book.Description)
+	rows := pgxmock.NewRows([]string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary", "language", "pages", "file_size", "rating_avg", "rating_count", "archived_at", "media_type", "duration_seconds", "genres", "doi", "cover_size", "uploaded_by", "is_private", "visible_from"}).
+		AddRow(book.ID, book.Title, book.Author, book.Publisher, book.Year, book.CreatedAt, book.UpdatedAt, book.ISBN, book.FilePath, book.DocumentID, book.CoverPath, book.Series, "1", book.Description, book.Language, book.Pages, book.FileSize, nil, 0, nil, "book", nil, []string{"sf_fantasy"}, nil, int64(0), "", false, nil)
 
 	mock.ExpectQuery("SELECT (.+) FROM library_book").
 		WithArgs(book.DocumentID).
@@ -168,18 +187,21 @@ func TestBookDatabaseRepoList(t *testing.T) {
 		CoverPath:   "cover_path",
 		Series:      "Test Series",
 		SeriesIndex: &seriesIndex,
+		Language:    "en",
+		Pages:       320,
+		FileSize:    1048576,
 	}
 
 	mock, bdr := setupTestBookDatabaseRepo()
 	defer mock.Close()
 
-	rows := pgxmock.NewRows([]string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary"}).
-		AddRow(book.ID, book.Title, book.Author, book.Publisher, book.Year, book.CreatedAt, book.UpdatedAt, book.ISBN, book.FilePath, book.DocumentID, book.CoverPath, book.Series, "3.5", book.Description)
+	rows := pgxmock.NewRows([]string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary", "language", "pages", "file_size", "rating_avg", "rating_count", "archived_at", "media_type", "duration_seconds", "genres", "doi", "cover_size", "uploaded_by", "is_private", "visible_from"}).
+		AddRow(book.ID, book.Title, book.Author, book.Publisher, book.Year, book.CreatedAt, book.UpdatedAt, book.ISBN, book.FilePath, book.DocumentID, book.CoverPath, book.Series, "3.5", book.Description, book.Language, book.Pages, book.FileSize, nil, 0, nil, "book", nil, []string{"sf_fantasy"}, nil, int64(0), "", false, nil)
 
 	mock.ExpectQuery("SELECT (.+) FROM library_book").
 		WillReturnRows(rows)
 
-	results, err := bdr.List(context.Background(), "created_at", "desc", 1, 10)
+	results, err := bdr.List(context.Background(), library.BookFilter{}, "created_at", "desc", 1, 10)
 	if err != nil {
 		t.Errorf("unexpected error: %v", err)
 	}
@@ -205,3 +227,387 @@ func setupTestBookDatabaseRepo() (pgxmock.PgxPoolIface, *library.BookDatabaseRep
 
 	return mock, bdr
 }
+
+func TestBookDatabaseRepoListByCursor(t *testing.T) {
+	createdAt := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	cursor := library.Cursor{SortBy: "created_at", SortOrder: "desc", Key: createdAt.Format(time.RFC3339Nano), ID: "2"}
+
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	rows := pgxmock.NewRows([]string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary", "language", "pages", "file_size", "rating_avg", "rating_count", "archived_at", "media_type", "duration_seconds", "genres", "doi", "cover_size", "uploaded_by", "is_private", "visible_from"}).
+		AddRow("1", "title", "author", "publisher", 2021, createdAt.Add(-time.Hour), createdAt, "isbn", "file_path", "document_id", "cover_path", "", nil, "", "en", nil, nil, nil, 0, nil, "book", nil, []string{"sf_fantasy"}, nil, int64(0), "", false, nil)
+
+	mock.ExpectQuery(`SELECT (.+) FROM library_book WHERE \(created_at, id\) < \(\$1::timestamptz, \$2::uuid\) ORDER BY created_at desc, id desc`).
+		WithArgs(cursor.Key, cursor.ID).
+		WillReturnRows(rows)
+
+	results, err := bdr.ListByCursor(context.Background(), library.BookFilter{}, "created_at", "desc", cursor, 3)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if len(results) != 1 || results[0].ID != "1" {
+		t.Errorf("expected book 1, got %+v", results)
+	}
+}
+
+func TestBookDatabaseRepoCountFiltersLanguage(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM library_book WHERE language = \$1`).
+		WithArgs("de").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(2))
+
+	count, err := bdr.Count(context.Background(), library.BookFilter{Language: "de"})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if count != 2 {
+		t.Errorf("expected 2 books, got %v", count)
+	}
+}
+
+func TestBookDatabaseRepoCountGroupsEditions(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM library_book WHERE language = \$1 AND NOT EXISTS \(.+first\.language = \$2\s+\)`).
+		WithArgs("de", "de").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(1))
+
+	count, err := bdr.Count(context.Background(), library.BookFilter{Language: "de", GroupEditions: true})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 book, got %v", count)
+	}
+}
+
+func TestBookDatabaseRepoCountHidesPrivateBooks(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM library_book WHERE \(NOT is_private OR uploaded_by = \$1 OR id IN \(\s+SELECT book_id FROM library_book_loan WHERE borrower = \$1 .+\)\) AND NOT EXISTS \(.+AND \(NOT first\.is_private OR first\.uploaded_by = \$2 OR first\.id IN \(.+borrower = \$2 .+\)\)\s+\)`).
+		WithArgs("reader", "reader").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(3))
+
+	count, err := bdr.Count(context.Background(), library.BookFilter{Reader: "reader", Restricted: true, GroupEditions: true})
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 books, got %v", count)
+	}
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM library_book WHERE NOT is_private$`).
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(2))
+	if _, err = bdr.Count(context.Background(), library.BookFilter{Restricted: true}); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
+
+func TestBookDatabaseRepoFacets(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectQuery(`WITH matched AS \(\s+SELECT id, author, year, genres, storage_file_path\s+FROM library_book\s+WHERE library_fold\(COALESCE\(author, ''\)\) LIKE library_fold\(\$1\) AND language = \$2\s+\)\s+SELECT 'author', mode\(\) WITHIN GROUP \(ORDER BY author\), count\(\*\)\s+FROM matched WHERE COALESCE\(author, ''\) <> '' GROUP BY library_fold\(author\).+GROUP BY year / 10\s+ORDER BY 1, 3 DESC, 2`).
+		WithArgs("%tolkien%", "en").
+		WillReturnRows(pgxmock.NewRows([]string{"facet", "value", "count"}).
+			AddRow("author", "J. R. R. Tolkien", 3).
+			AddRow("decade", "1950", 2).
+			AddRow("decade", "1930", 1).
+			AddRow("format", "epub", 3).
+			AddRow("genre", "sf_fantasy", 3))
+
+	facets, err := bdr.Facets(context.Background(), "author:tolkien", library.NewBookFilter("en"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := library.BookFacets{
+		Authors: []library.FacetCount{{Value: "J. R. R. Tolkien", Count: 3}},
+		Formats: []library.FacetCount{{Value: "epub", Count: 3}},
+		Genres:  []library.FacetCount{{Value: "sf_fantasy", Count: 3}},
+		Decades: []library.FacetCount{{Value: "1950", Count: 2}, {Value: "1930", Count: 1}},
+	}
+	if !reflect.DeepEqual(facets, want) {
+		t.Errorf("unexpected facets\n got %+v\nwant %+v", facets, want)
+	}
+}
+
+func TestBookDatabaseRepoUpdateMany(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+	columns := []string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary", "language", "pages", "file_size", "rating_avg", "rating_count", "archived_at", "media_type", "duration_seconds", "genres", "doi", "cover_size", "uploaded_by", "is_private", "visible_from"}
+	now := time.Now()
+
+	mock.ExpectBegin()
+	mock.ExpectQuery(`SELECT (.+) FROM library_book WHERE id = ANY\(\$1\) ORDER BY id FOR UPDATE`).
+		WithArgs([]string{"1", "2"}).
+		WillReturnRows(pgxmock.NewRows(columns).
+			AddRow("1", "title", "author", "old", 2021, now, now, "", "a.epub", "hash-a", "", "", nil, "", "en", 0, int64(0), nil, 0, nil, "book", nil, []string{}, nil, int64(0), "", false, nil).
+			AddRow("2", "title", "author", "old", 2021, now, now, "", "b.epub", "hash-b", "", "", nil, "", "en", 0, int64(0), nil, 0, nil, "book", nil, []string{}, nil, int64(0), "", false, nil))
+	for _, id := range []string{"1", "2"} {
+		mock.ExpectExec(`UPDATE library_book\s+SET title = \$1, author = \$2, publisher = \$3, year = \$4, isbn = \$5, doi = NULLIF\(\$6, ''\), series = \$7,\s+series_index = \$8, summary = \$9, language = \$10, genres = \$11, updated_at = \$12\s+WHERE id = \$13`).
+			WithArgs("title", "author", "new", 2021, "", "", "", pgxmock.AnyArg(), "", "en", []string{}, pgxmock.AnyArg(), id).
+			WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	}
+	mock.ExpectCommit()
+	mock.ExpectRollback()
+
+	books, err := bdr.UpdateMany(context.Background(), []string{"1", "2"}, func(book entity.Book) (entity.Book, error) {
+		book.Publisher = "new"
+		return book, nil
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(books) != 2 || books[1].Publisher != "new" {
+		t.Errorf("unexpected books %+v", books)
+	}
+
+	// a missing book changes none
+	mock.ExpectBegin()
+	mock.ExpectQuery(`FOR UPDATE`).
+		WithArgs([]string{"1", "3"}).
+		WillReturnRows(pgxmock.NewRows(columns).
+			AddRow("1", "title", "author", "old", 2021, now, now, "", "a.epub", "hash-a", "", "", nil, "", "en", 0, int64(0), nil, 0, nil, "book", nil, []string{}, nil, int64(0), "", false, nil))
+	mock.ExpectRollback()
+	if _, err = bdr.UpdateMany(context.Background(), []string{"1", "3"}, func(book entity.Book) (entity.Book, error) {
+		return book, nil
+	}); !errors.Is(err, entity.ErrBookNotFound) {
+		t.Errorf("expected ErrBookNotFound, got %v", err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestBookDatabaseRepoStorageUsage(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectQuery(`FROM library_book b\s+LEFT JOIN \(SELECT book_id, sum\(file_size\) AS bytes FROM library_book_file GROUP BY book_id\) f ON f.book_id = b.id\s+WHERE b.uploaded_by = \$1`).
+		WithArgs("reader").
+		WillReturnRows(pgxmock.NewRows([]string{"count", "files", "formats", "covers"}).AddRow(2, int64(3000), int64(1000), int64(200)))
+
+	usage, err := bdr.StorageUsage(context.Background(), "reader")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usage.Books != 2 || usage.Bytes() != 4200 {
+		t.Errorf("expected 2 books taking 4200 bytes, got %+v", usage)
+	}
+}
+
+func TestBookDatabaseRepoCountFiltersReadingStatus(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectQuery(`SELECT count\(\*\) FROM library_book WHERE language = \$1 AND id IN \(SELECT book_id FROM reading_status WHERE username = \$2 AND status = \$3\)`).
+		WithArgs("de", "reader", "to_read").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(1))
+
+	filter := library.NewBookFilter("de").WithStatus("reader", "to_read")
+	count, err := bdr.Count(context.Background(), filter)
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if count != 1 {
+		t.Errorf("expected 1 book, got %v", count)
+	}
+}
+
+func TestBookDatabaseRepoListSortsTitleForLocale(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	ctx := library.WithLocale(context.Background(), "en-US")
+	mock.ExpectQuery("SELECT collname FROM pg_collation").
+		WithArgs("en-x-icu").
+		WillReturnRows(pgxmock.NewRows([]string{"collname"}).AddRow("en-x-icu"))
+	mock.ExpectQuery(`ORDER BY \(regexp_replace\(title, \$1, '', 'i'\) COLLATE "en-x-icu"\) asc`).
+		WithArgs(`^(?:(?:the|a|an)\s+)`).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary", "language", "pages", "file_size", "rating_avg", "rating_count", "archived_at", "media_type", "duration_seconds", "genres", "doi", "cover_size", "uploaded_by", "is_private", "visible_from"}))
+
+	if _, err := bdr.List(ctx, library.BookFilter{}, "title", "asc", 1, 10); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestBookDatabaseRepoListSortsBySize(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectQuery(`ORDER BY COALESCE\(file_size, 0\) desc, id desc\s+LIMIT 10 OFFSET 0`).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary", "language", "pages", "file_size", "rating_avg", "rating_count", "archived_at", "media_type", "duration_seconds", "genres", "doi", "cover_size", "uploaded_by", "is_private", "visible_from"}))
+
+	if _, err := bdr.List(context.Background(), library.BookFilter{}, "size", "desc", 1, 10); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestBookDatabaseRepoListByISBNMatchesBothForms(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectQuery(`WHERE upper\(regexp_replace\(isbn, '\[- \]', '', 'g'\)\) = ANY\(\$1\)`).
+		WithArgs([]string{"9780306406157", "0306406152"}).
+		WillReturnRows(pgxmock.NewRows([]string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary", "language", "pages", "file_size", "rating_avg", "rating_count", "archived_at", "media_type", "duration_seconds", "genres", "doi", "cover_size", "uploaded_by", "is_private", "visible_from"}))
+
+	if _, err := bdr.ListByISBN(context.Background(), "0-306-40615-2"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestBookDatabaseRepoGetByIdNotFound(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectQuery("SELECT (.+) FROM library_book").
+		WithArgs("missing").
+		WillReturnError(pgx.ErrNoRows)
+
+	_, err := bdr.GetById(context.Background(), "missing")
+	if !errors.Is(err, entity.ErrBookNotFound) {
+		t.Errorf("expected ErrBookNotFound, got %v", err)
+	}
+}
+
+func TestBookDatabaseRepoSaveReviewRefreshesRating(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	now := time.Now()
+	review := entity.Review{Username: "reader", BookID: "book-id", Rating: 5, Text: "great", CreatedAt: now, UpdatedAt: now}
+	mock.ExpectExec(`INSERT INTO book_review`).
+		WithArgs(review.Username, review.BookID, review.Rating, review.Text, review.CreatedAt, review.UpdatedAt).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	mock.ExpectExec(`UPDATE library_book SET rating_avg = r.avg, rating_count = r.count`).
+		WithArgs(review.BookID).
+		WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+
+	if err := bdr.SaveReview(context.Background(), review); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestBookDatabaseRepoListUnopenedDownloads(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	downloadedAt := time.Now()
+	rows := pgxmock.NewRows([]string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary", "language", "pages", "file_size", "rating_avg", "rating_count", "archived_at", "media_type", "duration_seconds", "genres", "doi", "cover_size", "uploaded_by", "is_private", "visible_from", "downloads", "last_downloaded_at", "opened"}).
+		AddRow("1", "title", "author", "publisher", 2021, downloadedAt, downloadedAt, "isbn", "file_path", "document_id", "cover_path", "", nil, "", "en", nil, nil, nil, 0, nil, "book", nil, []string{}, nil, int64(0), "", false, nil, 2, downloadedAt, false)
+	mock.ExpectQuery(`SELECT (.+) FROM \(.+WHERE username = \$1 OR device_name <> ''.+\) d JOIN library_book ON library_book.id = d.book_id WHERE NOT \(EXISTS .+ LIMIT \$2`).
+		WithArgs("reader", 100).
+		WillReturnRows(rows)
+
+	downloads, err := bdr.ListDownloads(context.Background(), "reader", true, 100)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(downloads) != 1 || downloads[0].Book.ID != "1" || downloads[0].Downloads != 2 || downloads[0].Opened {
+		t.Errorf("unexpected downloads %+v", downloads)
+	}
+}
+
+func TestBookDatabaseRepoStoreChapters(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	chapters := []entity.Chapter{{Title: "Opening", Start: 0}, {Title: "Ending", Start: 90 * time.Second}}
+	mock.ExpectExec(`DELETE FROM library_chapter WHERE book_id = \$1`).
+		WithArgs("book-id").
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	mock.ExpectExec(`INSERT INTO library_chapter .+ FROM unnest\(\$2::int\[\], \$3::text\[\], \$4::bigint\[\]\)`).
+		WithArgs("book-id", []int32{0, 1}, []string{"Opening", "Ending"}, []int64{0, 90000}).
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+
+	if err := bdr.StoreChapters(context.Background(), "book-id", chapters); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unmet expectations: %v", err)
+	}
+}
+
+func TestBookDatabaseRepoRandomSamplesLargeLibraries(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	columns := []string{"id", "title", "author", "publisher", "year", "created_at", "updated_at", "isbn", "file_path", "file_hash", "cover_path", "series", "series_index", "summary", "language", "pages", "file_size", "rating_avg", "rating_count", "archived_at", "media_type", "duration_seconds", "genres", "doi", "cover_size", "uploaded_by", "is_private", "visible_from"}
+	row := func(id string) []any {
+		return []any{id, "title", "author", "publisher", 2021, time.Now(), time.Now(), "isbn", "file_path", "document_id", "cover_path", "", nil, "", "de", nil, nil, nil, 0, nil, "book", nil, nil, nil, int64(0), "", false, nil}
+	}
+
+	mock.ExpectQuery(`SELECT reltuples FROM pg_class`).
+		WillReturnRows(pgxmock.NewRows([]string{"reltuples"}).AddRow(float64(200000)))
+	mock.ExpectQuery(`FROM library_book TABLESAMPLE BERNOULLI \(0\.02\) WHERE language = \$1 ORDER BY random\(\) LIMIT 2`).
+		WithArgs("de").
+		WillReturnRows(pgxmock.NewRows(columns).AddRow(row("1")...))
+	// the filter left the sample short
+	mock.ExpectQuery(`FROM library_book WHERE language = \$1 ORDER BY random\(\) LIMIT 2`).
+		WithArgs("de").
+		WillReturnRows(pgxmock.NewRows(columns).AddRow(row("2")...).AddRow(row("3")...))
+
+	books, err := bdr.Random(context.Background(), library.BookFilter{Language: "de"}, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(books) != 2 || books[0].ID != "2" {
+		t.Errorf("expected the books of the full shuffle, got %+v", books)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestBookDatabaseRepoLinkEditionMergesWorks(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectQuery(`SELECT book_id, work_id FROM library_book_edition WHERE book_id = ANY\(\$1\)`).
+		WithArgs([]string{"fr", "de"}).
+		WillReturnRows(pgxmock.NewRows([]string{"book_id", "work_id"}).AddRow("fr", "fr").AddRow("de", "en"))
+	old := "fr"
+	mock.ExpectExec(`UPDATE library_book_edition SET work_id = \$2`).
+		WithArgs("fr", "en", "translation", &old, "de").
+		WillReturnResult(pgxmock.NewResult("INSERT", 2))
+
+	if err := bdr.LinkEdition(context.Background(), "fr", "de", entity.RelationTranslation); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("unfulfilled expectations: %v", err)
+	}
+}
+
+func TestBookDatabaseRepoCountShareDownloadStopsAtMax(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectExec(`UPDATE book_share_link\s+SET downloads = downloads \+ 1\s+WHERE id = \$1 AND revoked_at IS NULL AND expires_at > NOW\(\)\s+AND \(max_downloads = 0 OR downloads < max_downloads\)`).
+		WithArgs("link-id").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+
+	if err := bdr.CountShareDownload(context.Background(), "link-id"); !errors.Is(err, entity.ErrShareLinkUsedUp) {
+		t.Errorf("expected ErrShareLinkUsedUp, got %v", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,863 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/isbn"
+	"github.com/banjuer/kompanion/pkg/sqlite"
+)
+
+// BookSQLiteRepo keeps the library in a SQLite file, for servers without
+// Postgres. The schema is in migrations/sqlite and the conditions of
+// listing and search are shared with BookDatabaseRepo. Text sorts by code
+// point, SQLite has no ICU collations.
+type BookSQLiteRepo struct {
+	*sqlite.SQLite
+}
+
+func NewBookSQLiteRepo(db *sqlite.SQLite) *BookSQLiteRepo {
+	return &BookSQLiteRepo{SQLite: db}
+}
+
+// sqliteBookColumns are bookColumns without the casts of Postgres.
+var sqliteBookColumns = strings.Replace(bookColumns, "rating_avg::float8", "rating_avg", 1)
+
+func (bsr *BookSQLiteRepo) Store(ctx context.Context, book entity.Book) error {
+	query := `
+		INSERT INTO library_book (id, title, author, publisher, year, created_at, updated_at, isbn, storage_file_path, koreader_partial_md5, storage_cover_path, series, series_index, summary, language, pages, file_size, media_type, duration_seconds, genres, uploaded_by, doi, cover_size, is_private)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17, $18, $19, $20, $21, NULLIF($22, ''), $23, $24)
+	`
+	args := []interface{}{
+		book.ID, book.Title, book.Author, book.Publisher, book.Year,
+		book.CreatedAt, book.UpdatedAt, book.ISBN, book.FilePath,
+		book.DocumentID, book.CoverPath, book.Series, book.SeriesIndex, book.Description,
+		book.Language, book.Pages, book.FileSize, book.MediaType, int(book.Duration.Seconds()), jsonList(genres(book.Genres)),
+		book.UploadedBy, book.DOI, book.CoverSize, book.Private,
+	}
+
+	_, err := bsr.Conn(ctx).Exec(ctx, query, args...)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return fmt.Errorf("BookSQLiteRepo - Store - r.DB.Exec: %w", entity.ErrBookAlreadyExists)
+		}
+		return fmt.Errorf("BookSQLiteRepo - Store - r.DB.Exec: %w", err)
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) Update(ctx context.Context, book entity.Book) error {
+	query := `
+		UPDATE library_book
+		SET title = $1,
+			author = $2,
+			publisher = $3,
+			year = $4,
+			updated_at = $5,
+			isbn = $6,
+			series = $7,
+			series_index = $8,
+			summary = $9,
+			storage_cover_path = $10,
+			language = $11,
+			doi = NULLIF($12, ''),
+			cover_size = $13
+		WHERE id = $14 AND archived_at IS NULL
+	`
+	args := []interface{}{
+		book.Title, book.Author, book.Publisher, book.Year, book.UpdatedAt, book.ISBN, book.Series, book.SeriesIndex, book.Description, book.CoverPath, book.Language, book.DOI, book.CoverSize, book.ID,
+	}
+	result, err := bsr.Conn(ctx).Exec(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - Update - r.DB.Exec: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("BookSQLiteRepo - Update - no rows affected")
+	}
+	return nil
+}
+
+// UpdateMany changes the books with ids in one transaction, all or none.
+func (bsr *BookSQLiteRepo) UpdateMany(ctx context.Context, ids []string, change func(entity.Book) (entity.Book, error)) ([]entity.Book, error) {
+	var books []entity.Book
+	err := bsr.WithTx(ctx, func(ctx context.Context) error {
+		rows, err := bsr.Conn(ctx).Query(ctx, `
+			SELECT `+sqliteBookColumns+` FROM library_book
+			WHERE id IN (SELECT value FROM json_each($1)) ORDER BY id
+		`, jsonList(ids))
+		if err != nil {
+			return fmt.Errorf("tx.Query: %w", err)
+		}
+		if books, err = scanSQLiteBooks(rows); err != nil {
+			return fmt.Errorf("scanSQLiteBooks: %w", err)
+		}
+		if len(books) < len(ids) {
+			return entity.ErrBookNotFound
+		}
+
+		for i, book := range books {
+			if book, err = change(book); err != nil {
+				return err
+			}
+			_, err = bsr.Conn(ctx).Exec(ctx, `
+				UPDATE library_book
+				SET title = $1, author = $2, publisher = $3, year = $4, isbn = $5, doi = NULLIF($6, ''), series = $7,
+					series_index = $8, summary = $9, language = $10, genres = $11, updated_at = $12
+				WHERE id = $13
+			`, book.Title, book.Author, book.Publisher, book.Year, book.ISBN, book.DOI, book.Series,
+				book.SeriesIndex, book.Description, book.Language, jsonList(genres(book.Genres)), book.UpdatedAt, book.ID)
+			if err != nil {
+				return fmt.Errorf("tx.Exec: %w", err)
+			}
+			books[i] = book
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - UpdateMany - %w", err)
+	}
+	return books, nil
+}
+
+// ListByISBN returns the books with the ISBN, compared without hyphens
+// and spaces in either form.
+func (bsr *BookSQLiteRepo) ListByISBN(ctx context.Context, value string) ([]entity.Book, error) {
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT `+sqliteBookColumns+`
+		FROM library_book
+		WHERE upper(regexp_replace(isbn, '[- ]', '', 'g')) IN (SELECT value FROM json_each($1))
+		ORDER BY created_at, id
+	`, jsonList(isbn.Forms(value)))
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListByISBN - r.DB.Query: %w", err)
+	}
+	books, err := scanSQLiteBooks(rows)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListByISBN - scanSQLiteBooks: %w", err)
+	}
+	return books, nil
+}
+
+// SetArchived archives the book at archivedAt, a zero time unarchives it.
+func (bsr *BookSQLiteRepo) SetArchived(ctx context.Context, id string, archivedAt time.Time) error {
+	if err := bsr.setBook(ctx, `archived_at = $1`, nullTime(archivedAt), id); err != nil {
+		return fmt.Errorf("BookSQLiteRepo - SetArchived - %w", err)
+	}
+	return nil
+}
+
+// SetVisibleFrom hides the book from all but admins until from, a zero
+// time clears it.
+func (bsr *BookSQLiteRepo) SetVisibleFrom(ctx context.Context, id string, from time.Time) error {
+	if err := bsr.setBook(ctx, `visible_from = $1`, nullTime(from), id); err != nil {
+		return fmt.Errorf("BookSQLiteRepo - SetVisibleFrom - %w", err)
+	}
+	return nil
+}
+
+// SetPrivate hides the book from everyone but its uploader, false shares it.
+func (bsr *BookSQLiteRepo) SetPrivate(ctx context.Context, id string, private bool) error {
+	if err := bsr.setBook(ctx, `is_private = $1`, private, id); err != nil {
+		return fmt.Errorf("BookSQLiteRepo - SetPrivate - %w", err)
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) setBook(ctx context.Context, set string, value interface{}, id string) error {
+	result, err := bsr.Conn(ctx).Exec(ctx, `UPDATE library_book SET `+set+` WHERE id = $2`, value, id)
+	if err != nil {
+		return fmt.Errorf("r.DB.Exec: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return entity.ErrBookNotFound
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) List(ctx context.Context, filter BookFilter, sortBy, sortOrder string, page, perPage int) ([]entity.Book, error) {
+	books, err := bsr.offsetQuery(ctx, "", filter, sortBy, sortOrder, page, perPage)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - List - %w", err)
+	}
+	return books, nil
+}
+
+func (bsr *BookSQLiteRepo) Search(ctx context.Context, query string, filter BookFilter, sortBy, sortOrder string, page, perPage int) ([]entity.Book, error) {
+	books, err := bsr.offsetQuery(ctx, query, filter, sortBy, sortOrder, page, perPage)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - Search - %w", err)
+	}
+	return books, nil
+}
+
+func (bsr *BookSQLiteRepo) offsetQuery(ctx context.Context,
+	query string, filter BookFilter,
+	sortBy, sortOrder string,
+	page, perPage int,
+) ([]entity.Book, error) {
+	sortBy, sortOrder = normalizeSort(sortBy, sortOrder)
+	if page <= 0 {
+		page = 1
+	}
+	if perPage <= 0 {
+		perPage = defaultPerPage
+	}
+
+	conditions, args := bookConditions(sqliteDialect, query, filter)
+	orderBy, args := sqliteSort(ctx, sortBy, sortColumns[sortBy].expr, args)
+	sqlQuery := fmt.Sprintf(`
+		SELECT `+sqliteBookColumns+`
+		FROM library_book
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT %d OFFSET %d
+	`, whereSQL(conditions), orderBy, sortOrder, sortOrder, perPage, (page-1)*perPage)
+
+	rows, err := bsr.Conn(ctx).Query(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("r.DB.Query: %w", err)
+	}
+	books, err := scanSQLiteBooks(rows)
+	if err != nil {
+		return nil, fmt.Errorf("scanSQLiteBooks: %w", err)
+	}
+	return books, nil
+}
+
+// ListByCursor returns up to limit books after (or before) the cursor in keyset order.
+func (bsr *BookSQLiteRepo) ListByCursor(ctx context.Context, filter BookFilter, sortBy, sortOrder string, cursor Cursor, limit int) ([]entity.Book, error) {
+	books, err := bsr.keysetQuery(ctx, "", filter, sortBy, sortOrder, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListByCursor - %w", err)
+	}
+	return books, nil
+}
+
+func (bsr *BookSQLiteRepo) SearchByCursor(ctx context.Context, query string, filter BookFilter, sortBy, sortOrder string, cursor Cursor, limit int) ([]entity.Book, error) {
+	books, err := bsr.keysetQuery(ctx, query, filter, sortBy, sortOrder, cursor, limit)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - SearchByCursor - %w", err)
+	}
+	return books, nil
+}
+
+func (bsr *BookSQLiteRepo) keysetQuery(ctx context.Context,
+	query string, filter BookFilter,
+	sortBy, sortOrder string,
+	cursor Cursor, limit int,
+) ([]entity.Book, error) {
+	sortBy, sortOrder = normalizeSort(sortBy, sortOrder)
+	column := sortColumns[sortBy]
+
+	direction, cmp := sortOrder, ">"
+	if sortOrder == "desc" {
+		cmp = "<"
+	}
+	if cursor.Before {
+		direction, cmp = reverseOrder(direction), reverseCmp(cmp)
+	}
+
+	conditions, args := bookConditions(sqliteDialect, query, filter)
+	expr, args := sqliteSort(ctx, sortBy, column.expr, args)
+	if !cursor.IsZero() {
+		key, err := sqliteSortKey(column.typ, cursor.Key)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, key, cursor.ID)
+		conditions = append(conditions, fmt.Sprintf("(%s, id) %s ($%d, $%d)", expr, cmp, len(args)-1, len(args)))
+	}
+
+	sqlQuery := fmt.Sprintf(`
+		SELECT `+sqliteBookColumns+`
+		FROM library_book
+		%s
+		ORDER BY %s %s, id %s
+		LIMIT %d
+	`, whereSQL(conditions), expr, direction, direction, limit)
+
+	rows, err := bsr.Conn(ctx).Query(ctx, sqlQuery, args...)
+	if err != nil {
+		return nil, fmt.Errorf("r.DB.Query: %w", err)
+	}
+	books, err := scanSQLiteBooks(rows)
+	if err != nil {
+		return nil, fmt.Errorf("scanSQLiteBooks: %w", err)
+	}
+	if cursor.Before {
+		for i, j := 0, len(books)-1; i < j; i, j = i+1, j-1 {
+			books[i], books[j] = books[j], books[i]
+		}
+	}
+	return books, nil
+}
+
+// sqliteSort ignores the leading articles of titles in the request locale
+// like localizedSort, without a collation.
+func sqliteSort(ctx context.Context, sortBy, expr string, args []interface{}) (string, []interface{}) {
+	if sortBy != "title" {
+		return expr, args
+	}
+	if pattern := articlePattern(LocaleFrom(ctx)); pattern != "" {
+		args = append(args, pattern)
+		expr = fmt.Sprintf("regexp_replace(%s, $%d, '', 'i')", expr, len(args))
+	}
+	return expr, args
+}
+
+// sqliteSortKey turns the key of a cursor into a value SQLite compares
+// with the sort column, it has no casts to the types of sortColumns.
+func sqliteSortKey(typ, key string) (interface{}, error) {
+	switch typ {
+	case "text":
+		return key, nil
+	case "timestamptz":
+		t, err := time.Parse(time.RFC3339Nano, key)
+		if err != nil {
+			return nil, fmt.Errorf("cursor key: %w", err)
+		}
+		return t, nil
+	case "numeric":
+		n, err := strconv.ParseFloat(key, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cursor key: %w", err)
+		}
+		return n, nil
+	default:
+		n, err := strconv.ParseInt(key, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("cursor key: %w", err)
+		}
+		return n, nil
+	}
+}
+
+func (bsr *BookSQLiteRepo) Count(ctx context.Context, filter BookFilter) (int, error) {
+	count, err := bsr.count(ctx, "", filter)
+	if err != nil {
+		return 0, fmt.Errorf("BookSQLiteRepo - Count - %w", err)
+	}
+	return count, nil
+}
+
+func (bsr *BookSQLiteRepo) CountSearch(ctx context.Context, query string, filter BookFilter) (int, error) {
+	count, err := bsr.count(ctx, query, filter)
+	if err != nil {
+		return 0, fmt.Errorf("BookSQLiteRepo - CountSearch - %w", err)
+	}
+	return count, nil
+}
+
+func (bsr *BookSQLiteRepo) count(ctx context.Context, query string, filter BookFilter) (int, error) {
+	conditions, args := bookConditions(sqliteDialect, query, filter)
+	var count int
+	err := bsr.Conn(ctx).QueryRow(ctx, `SELECT count(*) FROM library_book `+whereSQL(conditions), args...).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("r.DB.QueryRow: %w", err)
+	}
+	return count, nil
+}
+
+// Facets counts like BookDatabaseRepo.Facets. The most common spelling of
+// an author is picked with a window, the extension of the file with a
+// pattern that leaves files without one empty.
+func (bsr *BookSQLiteRepo) Facets(ctx context.Context, query string, filter BookFilter) (BookFacets, error) {
+	conditions, args := bookConditions(sqliteDialect, query, filter)
+	sqlQuery := `
+		WITH matched AS (
+			SELECT id, author, year, genres, storage_file_path
+			FROM library_book
+			` + whereSQL(conditions) + `
+		), spellings AS (
+			SELECT lower(unaccent(author)) AS folded, author, count(*) AS n
+			FROM matched WHERE COALESCE(author, '') <> '' GROUP BY folded, author
+		), authors AS (
+			SELECT author, sum(n) OVER (PARTITION BY folded) AS total,
+				row_number() OVER (PARTITION BY folded ORDER BY n DESC, author) AS rank
+			FROM spellings
+		)
+		SELECT 'author', author, total FROM authors WHERE rank = 1
+		UNION ALL
+		SELECT 'format', format, count(DISTINCT id) FROM (
+			SELECT id, lower(regexp_replace(storage_file_path, '^.*\.([^./]+)$|^.*$', '$1', '')) AS format FROM matched
+			UNION SELECT f.book_id, f.format FROM library_book_file f JOIN matched m ON m.id = f.book_id
+		) formats WHERE format <> '' GROUP BY format
+		UNION ALL
+		SELECT 'genre', genre.value, count(*) FROM matched, json_each(matched.genres) AS genre GROUP BY genre.value
+		UNION ALL
+		SELECT 'decade', CAST(year / 10 * 10 AS TEXT), count(*) FROM matched WHERE year > 0 GROUP BY year / 10
+		ORDER BY 1, 3 DESC, 2
+	`
+	rows, err := bsr.Conn(ctx).Query(ctx, sqlQuery, args...)
+	if err != nil {
+		return BookFacets{}, fmt.Errorf("BookSQLiteRepo - Facets - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	var facets BookFacets
+	for rows.Next() {
+		var facet, value string
+		var count int
+		if err = rows.Scan(&facet, &value, &count); err != nil {
+			return BookFacets{}, fmt.Errorf("BookSQLiteRepo - Facets - rows.Scan: %w", err)
+		}
+		facets.add(facet, value, count)
+	}
+	if err = rows.Err(); err != nil {
+		return BookFacets{}, fmt.Errorf("BookSQLiteRepo - Facets - rows.Err: %w", err)
+	}
+	return facets, nil
+}
+
+func (bsr *BookSQLiteRepo) GetById(ctx context.Context, id string) (entity.Book, error) {
+	book, err := scanSQLiteBook(bsr.Conn(ctx).QueryRow(ctx, `SELECT `+sqliteBookColumns+` FROM library_book WHERE id = $1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Book{}, fmt.Errorf("BookSQLiteRepo - Get - %w", entity.ErrBookNotFound)
+	}
+	if err != nil {
+		return entity.Book{}, fmt.Errorf("BookSQLiteRepo - Get - r.DB.QueryRow: %w", err)
+	}
+	return book, nil
+}
+
+func (bsr *BookSQLiteRepo) GetByFileHash(ctx context.Context, fileHash string) (entity.Book, error) {
+	book, err := scanSQLiteBook(bsr.Conn(ctx).QueryRow(ctx, `
+		SELECT `+sqliteBookColumns+`
+		FROM library_book
+		WHERE koreader_partial_md5 = $1
+			OR id = (SELECT book_id FROM library_book_file WHERE koreader_partial_md5 = $1)
+	`, fileHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Book{}, fmt.Errorf("BookSQLiteRepo - GetByFileHash - %w", entity.ErrBookNotFound)
+	}
+	if err != nil {
+		return entity.Book{}, fmt.Errorf("BookSQLiteRepo - GetByFileHash - r.DB.QueryRow: %w", err)
+	}
+	return book, nil
+}
+
+// StorageUsage sums the files of the books an account uploaded, the other
+// formats of the books counted with them.
+func (bsr *BookSQLiteRepo) StorageUsage(ctx context.Context, username string) (entity.StorageUsage, error) {
+	usage, err := bsr.usage(ctx, `WHERE b.uploaded_by = $1`, username)
+	if err != nil {
+		return entity.StorageUsage{}, fmt.Errorf("BookSQLiteRepo - StorageUsage - %w", err)
+	}
+	return usage, nil
+}
+
+// LibraryUsage sums the files of all books.
+func (bsr *BookSQLiteRepo) LibraryUsage(ctx context.Context) (entity.StorageUsage, error) {
+	usage, err := bsr.usage(ctx, "")
+	if err != nil {
+		return entity.StorageUsage{}, fmt.Errorf("BookSQLiteRepo - LibraryUsage - %w", err)
+	}
+	return usage, nil
+}
+
+func (bsr *BookSQLiteRepo) usage(ctx context.Context, where string, args ...interface{}) (entity.StorageUsage, error) {
+	var usage entity.StorageUsage
+	err := bsr.Conn(ctx).QueryRow(ctx, `
+		SELECT count(*), COALESCE(sum(b.file_size), 0), COALESCE(sum(f.bytes), 0), COALESCE(sum(b.cover_size), 0)
+		FROM library_book b
+		LEFT JOIN (SELECT book_id, sum(file_size) AS bytes FROM library_book_file GROUP BY book_id) f ON f.book_id = b.id
+		`+where, args...).Scan(&usage.Books, &usage.FileBytes, &usage.FormatBytes, &usage.CoverBytes)
+	if err != nil {
+		return entity.StorageUsage{}, fmt.Errorf("r.DB.QueryRow: %w", err)
+	}
+	return usage, nil
+}
+
+// CountUploadedBy counts the books an account uploaded.
+func (bsr *BookSQLiteRepo) CountUploadedBy(ctx context.Context, username string) (int, error) {
+	var count int
+	err := bsr.Conn(ctx).QueryRow(ctx, `SELECT count(*) FROM library_book WHERE uploaded_by = $1`, username).Scan(&count)
+	if err != nil {
+		return 0, fmt.Errorf("BookSQLiteRepo - CountUploadedBy - r.DB.QueryRow: %w", err)
+	}
+	return count, nil
+}
+
+// EstimateCount counts the books, SQLite keeps no estimate.
+func (bsr *BookSQLiteRepo) EstimateCount(ctx context.Context) (int, error) {
+	var count int
+	if err := bsr.Conn(ctx).QueryRow(ctx, `SELECT count(*) FROM library_book`).Scan(&count); err != nil {
+		return 0, fmt.Errorf("BookSQLiteRepo - EstimateCount - r.DB.QueryRow: %w", err)
+	}
+	return count, nil
+}
+
+// Random returns up to n books matching filter in random order, a SQLite
+// library is small enough to shuffle.
+func (bsr *BookSQLiteRepo) Random(ctx context.Context, filter BookFilter, n int) ([]entity.Book, error) {
+	conditions, args := bookConditions(sqliteDialect, "", filter)
+	rows, err := bsr.Conn(ctx).Query(ctx, fmt.Sprintf(`
+		SELECT `+sqliteBookColumns+`
+		FROM library_book
+		%s
+		ORDER BY random()
+		LIMIT %d
+	`, whereSQL(conditions), n), args...)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - Random - r.DB.Query: %w", err)
+	}
+	books, err := scanSQLiteBooks(rows)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - Random - scanSQLiteBooks: %w", err)
+	}
+	return books, nil
+}
+
+// Languages lists languages present in the library for the filter menu.
+func (bsr *BookSQLiteRepo) Languages(ctx context.Context) ([]string, error) {
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT DISTINCT language
+		FROM library_book
+		WHERE language IS NOT NULL AND language <> ''
+		ORDER BY language
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - Languages - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	languages := make([]string, 0)
+	for rows.Next() {
+		var language string
+		if err = rows.Scan(&language); err != nil {
+			return nil, fmt.Errorf("BookSQLiteRepo - Languages - rows.Scan: %w", err)
+		}
+		languages = append(languages, language)
+	}
+	return languages, rows.Err()
+}
+
+// Reindex rebuilds the indexes and refreshes the statistics of the query
+// planner.
+func (bsr *BookSQLiteRepo) Reindex(ctx context.Context) error {
+	for _, query := range []string{`REINDEX`, `ANALYZE`} {
+		if _, err := bsr.Conn(ctx).Exec(ctx, query); err != nil {
+			return fmt.Errorf("BookSQLiteRepo - Reindex - r.DB.Exec: %w", err)
+		}
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) Delete(ctx context.Context, id string) error {
+	result, err := bsr.Conn(ctx).Exec(ctx, `DELETE FROM library_book WHERE id = $1 AND archived_at IS NULL`, id)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - Delete - r.DB.Exec: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("BookSQLiteRepo - Delete - no rows affected")
+	}
+	return nil
+}
+
+// StoreFile adds a format to a book, entity.ErrBookAlreadyExists when the
+// file or the format is already stored.
+func (bsr *BookSQLiteRepo) StoreFile(ctx context.Context, file entity.BookFile) error {
+	_, err := bsr.Conn(ctx).Exec(ctx, `
+		INSERT INTO library_book_file (book_id, format, storage_file_path, koreader_partial_md5, file_size, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, file.BookID, file.Format, file.FilePath, file.DocumentID, file.FileSize, file.CreatedAt)
+	if err != nil {
+		if strings.Contains(err.Error(), "UNIQUE constraint failed") {
+			return fmt.Errorf("BookSQLiteRepo - StoreFile - r.DB.Exec: %w", entity.ErrBookAlreadyExists)
+		}
+		return fmt.Errorf("BookSQLiteRepo - StoreFile - r.DB.Exec: %w", err)
+	}
+	return nil
+}
+
+// ListFiles returns the formats added to the book, oldest first.
+func (bsr *BookSQLiteRepo) ListFiles(ctx context.Context, bookID string) ([]entity.BookFile, error) {
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT book_id, format, storage_file_path, koreader_partial_md5, file_size, created_at
+		FROM library_book_file
+		WHERE book_id = $1
+		ORDER BY created_at, format
+	`, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListFiles - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	files := make([]entity.BookFile, 0)
+	for rows.Next() {
+		var file entity.BookFile
+		if err = rows.Scan(&file.BookID, &file.Format, &file.FilePath, &file.DocumentID, &file.FileSize, &file.CreatedAt); err != nil {
+			return nil, fmt.Errorf("BookSQLiteRepo - ListFiles - rows.Scan: %w", err)
+		}
+		files = append(files, file)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListFiles - rows.Err: %w", err)
+	}
+	return files, nil
+}
+
+func (bsr *BookSQLiteRepo) DeleteFile(ctx context.Context, bookID, format string) error {
+	result, err := bsr.Conn(ctx).Exec(ctx, `DELETE FROM library_book_file WHERE book_id = $1 AND format = $2`, bookID, format)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - DeleteFile - r.DB.Exec: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("BookSQLiteRepo - DeleteFile - %s: %w", format, entity.ErrBookNotFound)
+	}
+	return nil
+}
+
+// StoreChapters replaces the chapters of an audiobook.
+func (bsr *BookSQLiteRepo) StoreChapters(ctx context.Context, bookID string, chapters []entity.Chapter) error {
+	err := bsr.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := bsr.Conn(ctx).Exec(ctx, `DELETE FROM library_chapter WHERE book_id = $1`, bookID); err != nil {
+			return fmt.Errorf("tx.Exec: %w", err)
+		}
+		for i, chapter := range chapters {
+			_, err := bsr.Conn(ctx).Exec(ctx, `
+				INSERT INTO library_chapter (book_id, position, title, start_ms) VALUES ($1, $2, $3, $4)
+			`, bookID, i, chapter.Title, chapter.Start.Milliseconds())
+			if err != nil {
+				return fmt.Errorf("tx.Exec: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - StoreChapters - %w", err)
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) ListChapters(ctx context.Context, bookID string) ([]entity.Chapter, error) {
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT title, start_ms
+		FROM library_chapter
+		WHERE book_id = $1
+		ORDER BY position
+	`, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListChapters - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	chapters := make([]entity.Chapter, 0)
+	for rows.Next() {
+		var chapter entity.Chapter
+		var startMS int64
+		if err = rows.Scan(&chapter.Title, &startMS); err != nil {
+			return nil, fmt.Errorf("BookSQLiteRepo - ListChapters - rows.Scan: %w", err)
+		}
+		chapter.Start = time.Duration(startMS) * time.Millisecond
+		chapters = append(chapters, chapter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListChapters - rows.Err: %w", err)
+	}
+	return chapters, nil
+}
+
+// LinkEdition puts both books in one work like BookDatabaseRepo.LinkEdition,
+// in steps as SQLite has no data-modifying WITH.
+func (bsr *BookSQLiteRepo) LinkEdition(ctx context.Context, bookID, otherID string, relation entity.EditionRelation) error {
+	err := bsr.WithTx(ctx, func(ctx context.Context) error {
+		works := make(map[string]string, 2)
+		for _, id := range []string{bookID, otherID} {
+			var work string
+			err := bsr.Conn(ctx).QueryRow(ctx, `SELECT work_id FROM library_book_edition WHERE book_id = $1`, id).Scan(&work)
+			if err != nil && !errors.Is(err, sql.ErrNoRows) {
+				return fmt.Errorf("tx.QueryRow: %w", err)
+			}
+			if err == nil {
+				works[id] = work
+			}
+		}
+
+		work := otherID
+		if w, ok := works[otherID]; ok {
+			work = w
+		} else if w, ok := works[bookID]; ok {
+			work = w
+		}
+		// the other books of the old work of bookID move along
+		if merged, ok := works[bookID]; ok && merged != work {
+			_, err := bsr.Conn(ctx).Exec(ctx, `
+				UPDATE library_book_edition SET work_id = $2 WHERE work_id = $1 AND book_id <> $3
+			`, merged, work, bookID)
+			if err != nil {
+				return fmt.Errorf("tx.Exec: %w", err)
+			}
+		}
+		_, err := bsr.Conn(ctx).Exec(ctx, `
+			INSERT INTO library_book_edition (book_id, work_id, relation)
+			VALUES ($1, $2, $3), ($4, $2, 'edition')
+			ON CONFLICT (book_id) DO UPDATE SET
+				work_id = excluded.work_id,
+				relation = CASE WHEN library_book_edition.book_id = $1 THEN excluded.relation ELSE library_book_edition.relation END
+		`, bookID, work, string(relation), otherID)
+		if err != nil {
+			return fmt.Errorf("tx.Exec: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - LinkEdition - %w", err)
+	}
+	return nil
+}
+
+// UnlinkEdition takes a book out of its work, a work left with a single
+// book is dissolved.
+func (bsr *BookSQLiteRepo) UnlinkEdition(ctx context.Context, bookID string) error {
+	err := bsr.WithTx(ctx, func(ctx context.Context) error {
+		var work string
+		err := bsr.Conn(ctx).QueryRow(ctx, `SELECT work_id FROM library_book_edition WHERE book_id = $1`, bookID).Scan(&work)
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("tx.QueryRow: %w", err)
+		}
+		if _, err = bsr.Conn(ctx).Exec(ctx, `DELETE FROM library_book_edition WHERE book_id = $1`, bookID); err != nil {
+			return fmt.Errorf("tx.Exec: %w", err)
+		}
+		_, err = bsr.Conn(ctx).Exec(ctx, `
+			DELETE FROM library_book_edition
+			WHERE work_id = $1 AND (SELECT count(*) FROM library_book_edition WHERE work_id = $1) = 1
+		`, work)
+		if err != nil {
+			return fmt.Errorf("tx.Exec: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - UnlinkEdition - %w", err)
+	}
+	return nil
+}
+
+// ListEditions returns the other books of the work of each book, by
+// language and year.
+func (bsr *BookSQLiteRepo) ListEditions(ctx context.Context, bookIDs []string) (map[string][]entity.Edition, error) {
+	editions := make(map[string][]entity.Edition)
+	if len(bookIDs) == 0 {
+		return editions, nil
+	}
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT `+sqliteBookColumns+`, e.book_id, o.work_id, o.relation
+		FROM library_book_edition e
+		JOIN library_book_edition o ON o.work_id = e.work_id AND o.book_id <> e.book_id
+		JOIN library_book ON library_book.id = o.book_id
+		WHERE e.book_id IN (SELECT value FROM json_each($1))
+		ORDER BY COALESCE(library_book.language, ''), library_book.year, library_book.title
+	`, jsonList(bookIDs))
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListEditions - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var edition entity.Edition
+		var of, relation string
+		edition.Book, err = scanSQLiteBook(sqliteExtraRow{rows, []interface{}{&of, &edition.WorkID, &relation}})
+		if err != nil {
+			return nil, fmt.Errorf("BookSQLiteRepo - ListEditions - rows.Scan: %w", err)
+		}
+		edition.Relation = entity.EditionRelation(relation)
+		editions[of] = append(editions[of], edition)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListEditions - rows.Err: %w", err)
+	}
+	return editions, nil
+}
+
+type sqliteRow interface {
+	Scan(dest ...interface{}) error
+}
+
+// sqliteExtraRow scans columns selected after sqliteBookColumns into extra.
+type sqliteExtraRow struct {
+	sqliteRow
+	extra []interface{}
+}
+
+func (r sqliteExtraRow) Scan(dest ...interface{}) error {
+	return r.sqliteRow.Scan(append(dest, r.extra...)...)
+}
+
+func scanSQLiteBook(row sqliteRow) (entity.Book, error) {
+	var book entity.Book
+	var seriesIndex decimal.NullDecimal
+	var summary, author, publisher, isbn, coverPath, series, language, doi sql.NullString
+	var pages, duration sql.NullInt32
+	var fileSize sql.NullInt64
+	var rating sql.NullFloat64
+	var archivedAt, visibleFrom sql.NullTime
+	var genreList string
+	err := row.Scan(&book.ID, &book.Title, &author, &publisher, &book.Year, &book.CreatedAt, &book.UpdatedAt, &isbn, &book.FilePath, &book.DocumentID, &coverPath, &series, &seriesIndex, &summary, &language, &pages, &fileSize, &rating, &book.RatingCount, &archivedAt, &book.MediaType, &duration, &genreList, &doi, &book.CoverSize, &book.UploadedBy, &book.Private, &visibleFrom)
+	if err != nil {
+		return entity.Book{}, err
+	}
+	if err = json.Unmarshal([]byte(genreList), &book.Genres); err != nil {
+		return entity.Book{}, fmt.Errorf("genres: %w", err)
+	}
+	if seriesIndex.Valid {
+		book.SeriesIndex = &seriesIndex
+	}
+	book.Description = summary.String
+	book.Author = author.String
+	book.Publisher = publisher.String
+	book.ISBN = isbn.String
+	book.CoverPath = coverPath.String
+	book.Series = series.String
+	book.Language = language.String
+	book.Pages = int(pages.Int32)
+	book.FileSize = fileSize.Int64
+	book.Rating = rating.Float64
+	book.ArchivedAt = archivedAt.Time
+	book.Duration = time.Duration(duration.Int32) * time.Second
+	book.DOI = doi.String
+	book.VisibleFrom = visibleFrom.Time
+	return book, nil
+}
+
+func scanSQLiteBooks(rows *sql.Rows) ([]entity.Book, error) {
+	defer rows.Close()
+	books := make([]entity.Book, 0)
+	for rows.Next() {
+		book, err := scanSQLiteBook(rows)
+		if err != nil {
+			return nil, err
+		}
+		books = append(books, book)
+	}
+	return books, rows.Err()
+}
+
+// jsonList stores a list in a TEXT column, SQLite has no arrays.
+func jsonList(list []string) string {
+	if list == nil {
+		list = []string{}
+	}
+	b, _ := json.Marshal(list)
+	return string(b)
+}
+
+// nullTime stores the zero time as NULL.
+func nullTime(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	return &t
+}
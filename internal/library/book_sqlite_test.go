@@ -0,0 +1,164 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/sqlite"
+)
+
+func newSQLiteRepo(t *testing.T) *library.BookSQLiteRepo {
+	t.Helper()
+	db, err := sqlite.New(filepath.Join(t.TempDir(), "library.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(db.Close)
+	migrations, err := filepath.Glob("../../migrations/sqlite/*.up.sql")
+	if err != nil || len(migrations) == 0 {
+		t.Fatalf("no migrations: %v", err)
+	}
+	for _, path := range migrations {
+		schema, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = db.DB.Exec(string(schema)); err != nil {
+			t.Fatalf("%s: %v", path, err)
+		}
+	}
+	return library.NewBookSQLiteRepo(db)
+}
+
+func TestBookSQLiteRepo(t *testing.T) {
+	ctx := context.Background()
+	repo := newSQLiteRepo(t)
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= 5; i++ {
+		book := entity.Book{
+			ID:         fmt.Sprintf("book-%d", i),
+			Title:      fmt.Sprintf("Title %d", i),
+			Author:     "Ann",
+			Year:       1990 + i,
+			FilePath:   fmt.Sprintf("books/%d.epub", i),
+			DocumentID: fmt.Sprintf("hash-%d", i),
+			MediaType:  entity.MediaTypeBook,
+			Genres:     []string{"Fantasy"},
+			CreatedAt:  created.Add(time.Duration(i) * time.Hour),
+			UpdatedAt:  created,
+			Private:    i == 5,
+			UploadedBy: "owner",
+		}
+		if i == 3 {
+			book.Author = "Ånn"
+		}
+		if err := repo.Store(ctx, book); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	err := repo.Store(ctx, entity.Book{ID: "copy", FilePath: "books/copy.epub", DocumentID: "hash-1", MediaType: entity.MediaTypeBook, CreatedAt: created, UpdatedAt: created})
+	if !errors.Is(err, entity.ErrBookAlreadyExists) {
+		t.Fatalf("expected a stored file to be refused, got %v", err)
+	}
+
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	reader := library.WithReader(entity.WithRole(ctx, entity.RoleEditor), "reader")
+
+	var titles []string
+	cursor := ""
+	for {
+		list, err := shelf.ListBooksByCursor(reader, library.BookFilter{}, "created_at", "desc", cursor, 2)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		for _, book := range list.Books {
+			titles = append(titles, book.Title)
+		}
+		if cursor = list.NextCursor; cursor == "" {
+			break
+		}
+	}
+	if fmt.Sprint(titles) != "[Title 4 Title 3 Title 2 Title 1]" {
+		t.Errorf("expected the books others may see in order, got %v", titles)
+	}
+
+	found, err := repo.Search(ctx, "author:ann year:>=1993", library.BookFilter{}, "title", "asc", 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(found) != 3 || found[0].ID != "book-3" || found[0].Genres[0] != "Fantasy" {
+		t.Errorf("expected the books from 1993 by either spelling, got %v", found)
+	}
+
+	facets, err := repo.Facets(ctx, "", library.BookFilter{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(facets.Authors, facets.Formats, facets.Genres) != "[{Ann 5}] [{epub 5}] [{Fantasy 5}]" {
+		t.Errorf("unexpected facets %+v", facets)
+	}
+
+	stats, err := shelf.LibraryStats(reader)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stats.Usage.Books != 4 || fmt.Sprint(stats.Authors, stats.Formats, stats.Months) != "[{Ann 4}] [{epub 4}] [{2024-01 4}]" {
+		t.Errorf("unexpected stats of the books others may see %+v", stats)
+	}
+	if fmt.Sprint(stats.Years) != "[{1991 1} {1992 1} {1993 1} {1994 1}]" {
+		t.Errorf("expected the years oldest first, got %v", stats.Years)
+	}
+
+	_, err = shelf.BatchUpdateMetadata(reader, []string{"book-1", "book-5"}, library.MetadataPatch{Publisher: "Press"})
+	if !errors.Is(err, entity.ErrBookNotFound) {
+		t.Fatalf("expected the private book to be refused, got %v", err)
+	}
+	if book, _ := repo.GetById(ctx, "book-1"); book.Publisher != "" {
+		t.Errorf("expected no book to change, got publisher %q", book.Publisher)
+	}
+
+	if _, err = shelf.SaveReview(ctx, "reader", "book-2", 4, "good"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if book, _ := repo.GetById(ctx, "book-2"); book.Rating != 4 || book.RatingCount != 1 {
+		t.Errorf("expected the rating of the review, got %v from %d", book.Rating, book.RatingCount)
+	}
+
+	collection, err := shelf.CreateCollection(reader, "editor", entity.Collection{
+		Name: "Path",
+		Entries: []entity.CollectionEntry{
+			{Book: entity.Book{ID: "book-1"}},
+			{Book: entity.Book{ID: "book-2"}, Prerequisites: []string{"book-1"}},
+		},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	collection, err = repo.GetCollection(ctx, collection.ID, "reader")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(collection.Entries) != 2 || collection.Entries[1].Book.Title != "Title 2" || fmt.Sprint(collection.Entries[1].Prerequisites) != "[book-1]" {
+		t.Errorf("unexpected entries %+v", collection.Entries)
+	}
+
+	if err = shelf.LinkEdition(reader, "book-2", "book-1", "translation"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	grouped, err := repo.Count(ctx, library.BookFilter{GroupEditions: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if grouped != 4 {
+		t.Errorf("expected the editions to be listed once, got %d books", grouped)
+	}
+}
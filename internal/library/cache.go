@@ -0,0 +1,194 @@
+package library
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"time"
+
+	"github.com/moroz/uuidv7-go"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/thumbnail"
+)
+
+// generationKey holds the generation of the library, it is part of every
+// cached key and replaced on each change, so old entries are never read
+// again and age out.
+const generationKey = "library:generation"
+
+// coverCacheMax is the largest cover kept in the response cache.
+const coverCacheMax = 1 << 20
+
+// SetCache keeps listings and covers for ttl, or until the library
+// changes. A cache shared by servers sees their changes too, one of a
+// single server serves their listings stale for up to ttl.
+func (uc *BookShelf) SetCache(cache ResponseCache, ttl time.Duration) {
+	uc.cache = cache
+	uc.cacheTTL = ttl
+}
+
+// libraryChanged drops the cached counts, listings and covers.
+func (uc *BookShelf) libraryChanged(ctx context.Context) {
+	uc.counts.reset()
+	if uc.cache == nil {
+		return
+	}
+	if err := uc.cache.Set(ctx, generationKey, []byte(uuidv7.Generate().String()), 0); err != nil {
+		uc.logger.Error("BookShelf - libraryChanged - cache.Set: %s", err)
+	}
+}
+
+// generation returns the current generation of the library, false when
+// the cache can not be used.
+func (uc *BookShelf) generation(ctx context.Context) (string, bool) {
+	if uc.cache == nil {
+		return "", false
+	}
+	gen, ok, err := uc.cache.Get(ctx, generationKey)
+	if err != nil {
+		uc.logger.Error("BookShelf - generation - cache.Get: %s", err)
+		return "", false
+	}
+	if ok {
+		return string(gen), true
+	}
+	gen = []byte(uuidv7.Generate().String())
+	if err = uc.cache.Set(ctx, generationKey, gen, 0); err != nil {
+		uc.logger.Error("BookShelf - generation - cache.Set: %s", err)
+		return "", false
+	}
+	return string(gen), true
+}
+
+// listKey identifies a listing by its method, arguments and the request
+// modes it depends on, empty when listings are not cached.
+func (uc *BookShelf) listKey(ctx context.Context, method string, args ...any) string {
+	gen, ok := uc.generation(ctx)
+	if !ok {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s %q %t %+v", method, LocaleFrom(ctx), approximateCount(ctx), args)))
+	return "list:" + gen + ":" + hex.EncodeToString(sum[:])
+}
+
+// cachedBookList is PaginatedBookList with the fields the cache keeps.
+type cachedBookList struct {
+	Books            []entity.Book
+	NextCursor       string
+	PrevCursor       string
+	Editions         map[string][]entity.Edition
+	Facets           *BookFacets
+	ApproximateTotal bool
+	TotalCount       int
+	PerPage          int
+	CurrentPage      int
+}
+
+// cachedList returns the listing stored under key.
+func (uc *BookShelf) cachedList(ctx context.Context, key string) (PaginatedBookList, bool) {
+	if key == "" {
+		return PaginatedBookList{}, false
+	}
+	data, ok, err := uc.cache.Get(ctx, key)
+	if err != nil {
+		uc.logger.Error("BookShelf - cachedList - cache.Get: %s", err)
+	}
+	var cached cachedBookList
+	if !ok || json.Unmarshal(data, &cached) != nil {
+		return PaginatedBookList{}, false
+	}
+	list := NewPaginatedBookList(cached.Books, cached.PerPage, cached.CurrentPage, cached.TotalCount)
+	list.NextCursor, list.PrevCursor = cached.NextCursor, cached.PrevCursor
+	list.Editions = cached.Editions
+	list.Facets = cached.Facets
+	list.ApproximateTotal = cached.ApproximateTotal
+	return list, true
+}
+
+// cacheList stores a listing under key and passes it on.
+func (uc *BookShelf) cacheList(ctx context.Context, key string, list PaginatedBookList, err error) (PaginatedBookList, error) {
+	if key == "" || err != nil {
+		return list, err
+	}
+	data, err := json.Marshal(cachedBookList{
+		Books:            list.Books,
+		NextCursor:       list.NextCursor,
+		PrevCursor:       list.PrevCursor,
+		Editions:         list.Editions,
+		Facets:           list.Facets,
+		ApproximateTotal: list.ApproximateTotal,
+		TotalCount:       list.totalCount,
+		PerPage:          list.perPage,
+		CurrentPage:      list.currentPage,
+	})
+	if err == nil {
+		err = uc.cache.Set(ctx, key, data, uc.cacheTTL)
+	}
+	if err != nil {
+		uc.logger.Error("BookShelf - cacheList - cache.Set: %s", err)
+	}
+	return list, nil
+}
+
+// cachedCover returns the cover of the book in the size of opts from the
+// cache, or views it and keeps it when it is small enough.
+func (uc *BookShelf) cachedCover(ctx context.Context, bookID string, opts thumbnail.Options, view func() (*os.File, error)) (*os.File, error) {
+	gen, ok := uc.generation(ctx)
+	if !ok {
+		return view()
+	}
+	key := "cover:" + gen + ":" + bookID + "/" + opts.String()
+	// a hit skips the visibility check, readers keep their own entries
+	if username, ok := ReaderFrom(ctx); ok {
+		key += "@" + username
+	}
+	if data, ok, err := uc.cache.Get(ctx, key); err != nil {
+		uc.logger.Error("BookShelf - cachedCover - cache.Get: %s", err)
+	} else if ok {
+		return tempCover(data)
+	}
+
+	file, err := view()
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil || info.Size() > coverCacheMax {
+		return file, nil
+	}
+	data, err := io.ReadAll(file)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("BookShelf - cachedCover - io.ReadAll: %w", err)
+	}
+	if err = uc.cache.Set(ctx, key, data, uc.cacheTTL); err != nil {
+		uc.logger.Error("BookShelf - cachedCover - cache.Set: %s", err)
+	}
+	return file, nil
+}
+
+// tempCover writes a cover to an unlinked temporary file, the open file
+// stays readable.
+func tempCover(data []byte) (*os.File, error) {
+	file, err := os.CreateTemp("", "cover-")
+	if err != nil {
+		return nil, fmt.Errorf("os.CreateTemp: %w", err)
+	}
+	os.Remove(file.Name())
+	if _, err = file.Write(data); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("file.Write: %w", err)
+	}
+	return file, nil
+}
@@ -0,0 +1,236 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/calibre"
+	"github.com/banjuer/kompanion/pkg/richtext"
+	"github.com/shopspring/decimal"
+)
+
+var ErrCalibreDelete = errors.New("deleting books from calibre is not enabled")
+
+// calibreFormats are the formats Calibre may send, those StoreBook reads.
+var calibreFormats = []string{"epub", "pdf", "fb2", "djvu", "cbz", "cbr"}
+
+// CalibreLibrary shows the library to Calibre as a wireless device: Calibre
+// reads the books with their metadata, sends books and metadata edits, and
+// fetches book files. A book is on the device as <book id>.<extension>,
+// books Calibre sends keep the path Calibre gave them for the session.
+type CalibreLibrary struct {
+	shelf       *BookShelf
+	allowDelete bool
+	received    map[string]string // lpath of the books Calibre sent to their id
+}
+
+var _ calibre.Library = (*CalibreLibrary)(nil)
+
+// CalibreLibrary serves one Calibre session, deleting a book in Calibre
+// deletes it from the library only with allowDelete.
+func (uc *BookShelf) CalibreLibrary(allowDelete bool) *CalibreLibrary {
+	return &CalibreLibrary{shelf: uc, allowDelete: allowDelete, received: map[string]string{}}
+}
+
+// Extensions are the formats Calibre may send, those uploads may have.
+func (cl *CalibreLibrary) Extensions() []string {
+	var extensions []string
+	for _, format := range calibreFormats {
+		if cl.shelf.checkUploadFormat(format) == nil {
+			extensions = append(extensions, format)
+		}
+	}
+	return extensions
+}
+
+func (cl *CalibreLibrary) Books(ctx context.Context) ([]calibre.Metadata, error) {
+	lpaths := make(map[string]string, len(cl.received))
+	for lpath, bookID := range cl.received {
+		lpaths[bookID] = lpath
+	}
+	var books []calibre.Metadata
+	err := cl.shelf.forEachBook(ctx, func(book entity.Book) error {
+		if book.MediaType == entity.MediaTypeAudiobook {
+			return nil
+		}
+		m := calibreMetadata(book)
+		if lpath, ok := lpaths[book.ID]; ok {
+			m.Lpath = lpath
+		}
+		books = append(books, m)
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("CalibreLibrary - Books - %w", err)
+	}
+	return books, nil
+}
+
+// UpdateBook applies the metadata edited in Calibre, a book with the same
+// metadata is left as it is.
+func (cl *CalibreLibrary) UpdateBook(ctx context.Context, m calibre.Metadata) error {
+	bookID := cl.bookID(m.Lpath)
+	book, err := cl.shelf.getBook(ctx, bookID)
+	if err != nil {
+		return fmt.Errorf("CalibreLibrary - UpdateBook - s.getBook: %w", err)
+	}
+	patch := calibrePatch(m)
+	if !calibreChanged(book, patch) {
+		return nil
+	}
+	if _, err = cl.shelf.UpdateBookMetadata(ctx, bookID, patch); err != nil {
+		return fmt.Errorf("CalibreLibrary - UpdateBook - %w", err)
+	}
+	return nil
+}
+
+// AddBook stores a book sent by Calibre with the metadata of Calibre. A
+// book the library has already gets the metadata.
+func (cl *CalibreLibrary) AddBook(ctx context.Context, m calibre.Metadata, file io.Reader) error {
+	tempFile, err := os.CreateTemp("", "calibre-*"+path.Ext(m.Lpath))
+	if err != nil {
+		return fmt.Errorf("CalibreLibrary - AddBook - os.CreateTemp: %w", err)
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+	if _, err = io.Copy(tempFile, file); err != nil {
+		return fmt.Errorf("CalibreLibrary - AddBook - io.Copy: %w", err)
+	}
+	if _, err = tempFile.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("CalibreLibrary - AddBook - tempFile.Seek: %w", err)
+	}
+	book, err := cl.shelf.StoreBook(ctx, tempFile, path.Base(m.Lpath))
+	if err != nil && !errors.Is(err, entity.ErrBookAlreadyExists) {
+		return fmt.Errorf("CalibreLibrary - AddBook - %w", err)
+	}
+	cl.received[m.Lpath] = book.ID
+	return cl.UpdateBook(ctx, m)
+}
+
+func (cl *CalibreLibrary) DeleteBook(ctx context.Context, lpath string) error {
+	if !cl.allowDelete {
+		return fmt.Errorf("CalibreLibrary - DeleteBook - %w", ErrCalibreDelete)
+	}
+	if err := cl.shelf.DeleteBook(ctx, cl.bookID(lpath)); err != nil {
+		return fmt.Errorf("CalibreLibrary - DeleteBook - %w", err)
+	}
+	delete(cl.received, lpath)
+	return nil
+}
+
+func (cl *CalibreLibrary) OpenBook(ctx context.Context, lpath string) (io.ReadCloser, int64, error) {
+	book, file, err := cl.shelf.DownloadBook(ctx, cl.bookID(lpath), "")
+	if err != nil {
+		return nil, 0, fmt.Errorf("CalibreLibrary - OpenBook - %w", err)
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, 0, fmt.Errorf("CalibreLibrary - OpenBook - %s: %w", book.ID, err)
+	}
+	return file, size, nil
+}
+
+func (cl *CalibreLibrary) bookID(lpath string) string {
+	if bookID, ok := cl.received[lpath]; ok {
+		return bookID
+	}
+	name := path.Base(lpath)
+	return strings.TrimSuffix(name, path.Ext(name))
+}
+
+func calibreMetadata(book entity.Book) calibre.Metadata {
+	m := calibre.Metadata{
+		Lpath:        book.ID + "." + book.Extension(),
+		UUID:         book.ID,
+		LastModified: calibre.Time{Time: book.UpdatedAt},
+		Size:         book.FileSize,
+		Title:        book.Title,
+		Authors:      splitAuthors(book.Author),
+		Publisher:    book.Publisher,
+		Series:       book.Series,
+		Tags:         book.Genres,
+		Comments:     book.Description,
+		Identifiers:  map[string]string{},
+	}
+	if book.Year > 0 {
+		m.Pubdate = calibre.Time{Time: time.Date(book.Year, 1, 1, 0, 0, 0, 0, time.UTC)}
+	}
+	if book.SeriesIndex != nil && book.SeriesIndex.Valid {
+		m.SeriesIndex = book.SeriesIndex.Decimal.InexactFloat64()
+	}
+	if book.Language != "" {
+		m.Languages = []string{book.Language}
+	}
+	if book.ISBN != "" {
+		m.Identifiers["isbn"] = book.ISBN
+	}
+	if book.DOI != "" {
+		m.Identifiers["doi"] = book.DOI
+	}
+	return m
+}
+
+// calibrePatch is the metadata of Calibre for UpdateBookMetadata, fields
+// Calibre has no value for are empty and left as they are.
+func calibrePatch(m calibre.Metadata) entity.Book {
+	patch := entity.Book{
+		Title:       m.Title,
+		Publisher:   m.Publisher,
+		Series:      m.Series,
+		Description: m.Comments,
+		ISBN:        m.Identifiers["isbn"],
+		DOI:         m.Identifiers["doi"],
+	}
+	// Calibre names books without a title or authors Unknown
+	if patch.Title == "Unknown" {
+		patch.Title = ""
+	}
+	if len(m.Authors) > 0 && m.Authors[0] != "Unknown" {
+		patch.Author = strings.Join(m.Authors, " & ")
+	}
+	if !m.Pubdate.IsZero() {
+		patch.Year = m.Pubdate.Year()
+	}
+	if len(m.Languages) > 0 {
+		patch.Language = m.Languages[0]
+	}
+	if m.Series != "" {
+		index := decimal.NewNullDecimal(decimal.NewFromFloat(m.SeriesIndex))
+		patch.SeriesIndex = &index
+	}
+	return patch
+}
+
+func calibreChanged(book, patch entity.Book) bool {
+	changed := func(value, patched string) bool { return patched != "" && patched != value }
+	if changed(book.Title, patch.Title) || changed(book.Publisher, patch.Publisher) || changed(book.Series, patch.Series) ||
+		changed(book.ISBN, normalizeISBN(patch.ISBN)) || changed(book.DOI, normalizeDOI(patch.DOI)) ||
+		changed(book.Language, normalizeLanguage(patch.Language)) {
+		return true
+	}
+	if patch.Description != "" && richtext.Sanitize(patch.Description) != book.Description {
+		return true
+	}
+	// Calibre joins authors with &, the library may separate them otherwise
+	if patch.Author != "" && strings.Join(splitAuthors(book.Author), " & ") != patch.Author {
+		return true
+	}
+	if patch.Year != 0 && patch.Year != book.Year {
+		return true
+	}
+	if patch.SeriesIndex != nil {
+		return book.SeriesIndex == nil || !book.SeriesIndex.Valid || !book.SeriesIndex.Decimal.Equal(patch.SeriesIndex.Decimal)
+	}
+	return false
+}
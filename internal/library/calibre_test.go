@@ -0,0 +1,54 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func TestCalibreLibrary(t *testing.T) {
+	ctx := context.Background()
+	updated := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	repo := library.NewMemoryBookRepo()
+	book := entity.Book{ID: "dune", Title: "Dune", Author: "Frank Herbert", Year: 1965, Language: "en", FilePath: "books/dune.epub", DocumentID: "hash", MediaType: entity.MediaTypeBook, CreatedAt: updated, UpdatedAt: updated}
+	if err := repo.Store(ctx, book); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lib := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error")).CalibreLibrary(false)
+
+	books, err := lib.Books(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(books) != 1 || books[0].Lpath != "dune.epub" || books[0].Pubdate.Year() != 1965 || !books[0].LastModified.Equal(updated) {
+		t.Fatalf("unexpected books %+v", books)
+	}
+
+	// the metadata as reported leaves the book alone
+	if err = lib.UpdateBook(ctx, books[0]); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if stored, _ := repo.GetById(ctx, "dune"); !stored.UpdatedAt.Equal(updated) {
+		t.Errorf("expected an unchanged book to stay, updated at %s", stored.UpdatedAt)
+	}
+
+	edited := books[0]
+	edited.Title, edited.Series, edited.SeriesIndex, edited.Languages = "Dune", "Dune Chronicles", 1, []string{"eng"}
+	if err = lib.UpdateBook(ctx, edited); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	stored, _ := repo.GetById(ctx, "dune")
+	if stored.Series != "Dune Chronicles" || stored.SeriesIndex == nil || stored.SeriesIndex.Decimal.String() != "1" || stored.Language != "en" {
+		t.Errorf("expected the series from calibre, got %+v", stored)
+	}
+
+	if err = lib.DeleteBook(ctx, "dune.epub"); !errors.Is(err, library.ErrCalibreDelete) {
+		t.Errorf("expected deleting to need --delete, got %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package library
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/richtext"
+	"github.com/banjuer/kompanion/pkg/thumbnail"
+)
+
+const (
+	// CatalogHTML is one page to print from the browser, covers included.
+	CatalogHTML = "html"
+	// CatalogPDF is the page converted to PDF, it needs the converter.
+	CatalogPDF = "pdf"
+)
+
+var (
+	ErrUnknownCatalogFormat = errors.New("catalog format must be html or pdf")
+	ErrNoConverter          = errors.New("no converter is configured")
+)
+
+// catalogCoverWidth is the width of the covers in a catalog, small enough
+// for a catalog of a few thousand books to stay a few megabytes.
+const catalogCoverWidth = 120
+
+// catalogDescriptionLength caps the description of a book in a catalog, in
+// characters.
+const catalogDescriptionLength = 400
+
+// SetConverter enables PDF catalogs, SetDelivery sets it as well.
+func (uc *BookShelf) SetConverter(c Converter) {
+	uc.converter = c
+}
+
+type catalogPage struct {
+	Title       string
+	Description string
+	GeneratedAt time.Time
+	Books       []catalogEntry
+}
+
+type catalogEntry struct {
+	entity.Book
+	Cover       template.URL
+	Description string
+	InSeries    string // the series with the number of the book in it
+}
+
+// Catalog writes the books the reader of the request may see as a catalog
+// with covers and metadata, for an inventory or a list to share. Without a
+// collection id the whole library is listed by author and title, else the
+// collection in reading order. title names the library in the heading.
+func (uc *BookShelf) Catalog(ctx context.Context, w io.Writer, title, collectionID, format string) error {
+	if format != CatalogHTML && format != CatalogPDF {
+		return fmt.Errorf("BookShelf - Catalog - %w", ErrUnknownCatalogFormat)
+	}
+	if format == CatalogPDF && uc.converter == nil {
+		return fmt.Errorf("BookShelf - Catalog - %w", ErrNoConverter)
+	}
+
+	page := catalogPage{Title: title, GeneratedAt: time.Now()}
+	var books []entity.Book
+	if collectionID != "" {
+		username, _ := ReaderFrom(ctx)
+		collection, err := uc.Collection(ctx, username, collectionID)
+		if err != nil {
+			return fmt.Errorf("BookShelf - Catalog - %w", err)
+		}
+		page.Title, page.Description = collection.Name, collection.Description
+		for _, entry := range collection.Entries {
+			books = append(books, entry.Book)
+		}
+	} else {
+		err := uc.forEachBook(ctx, func(book entity.Book) error {
+			books = append(books, book)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("BookShelf - Catalog - %w", err)
+		}
+		sort.SliceStable(books, func(i, j int) bool {
+			a, b := strings.ToLower(books[i].Author), strings.ToLower(books[j].Author)
+			if a != b {
+				return a < b
+			}
+			return strings.ToLower(books[i].Title) < strings.ToLower(books[j].Title)
+		})
+	}
+
+	if format == CatalogHTML {
+		page.Books = uc.catalogEntries(ctx, books, func(book entity.Book, cover []byte) template.URL {
+			return template.URL("data:" + http.DetectContentType(cover) + ";base64," + base64.StdEncoding.EncodeToString(cover))
+		})
+		if err := catalogTemplate.Execute(w, page); err != nil {
+			return fmt.Errorf("BookShelf - Catalog - template.Execute: %w", err)
+		}
+		return nil
+	}
+
+	// the converter works on files, the covers are written next to the page
+	dir, err := os.MkdirTemp("", "catalog-")
+	if err != nil {
+		return fmt.Errorf("BookShelf - Catalog - os.MkdirTemp: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	page.Books = uc.catalogEntries(ctx, books, func(book entity.Book, cover []byte) template.URL {
+		name := book.ID + ".jpg"
+		if http.DetectContentType(cover) == "image/png" {
+			name = book.ID + ".png"
+		}
+		if err := os.WriteFile(filepath.Join(dir, name), cover, 0o600); err != nil {
+			return ""
+		}
+		return template.URL(name)
+	})
+	if err = uc.writeCatalogPDF(ctx, w, dir, page); err != nil {
+		return fmt.Errorf("BookShelf - Catalog - %w", err)
+	}
+	return nil
+}
+
+// catalogEntries adds the covers and shortened descriptions, coverURL
+// makes the source of a cover. Books without a readable cover get none.
+func (uc *BookShelf) catalogEntries(ctx context.Context, books []entity.Book, coverURL func(entity.Book, []byte) template.URL) []catalogEntry {
+	entries := make([]catalogEntry, 0, len(books))
+	for _, book := range books {
+		entry := catalogEntry{Book: book, Description: shorten(richtext.PlainText(book.Description), catalogDescriptionLength), InSeries: book.Series}
+		if book.Series != "" && book.SeriesIndex != nil && book.SeriesIndex.Valid {
+			entry.InSeries += " #" + book.SeriesIndex.Decimal.String()
+		}
+		if book.CoverPath != "" {
+			cover, err := uc.catalogCover(ctx, book.ID)
+			if err != nil {
+				uc.logger.Warn("BookShelf - Catalog - cover of %s: %s", book.ID, err)
+			} else {
+				entry.Cover = coverURL(book, cover)
+			}
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func (uc *BookShelf) catalogCover(ctx context.Context, bookID string) ([]byte, error) {
+	file, err := uc.ViewCoverSized(ctx, bookID, thumbnail.Options{Width: catalogCoverWidth})
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return io.ReadAll(file)
+}
+
+func (uc *BookShelf) writeCatalogPDF(ctx context.Context, w io.Writer, dir string, page catalogPage) error {
+	source := filepath.Join(dir, "catalog.html")
+	file, err := os.Create(source)
+	if err != nil {
+		return fmt.Errorf("os.Create: %w", err)
+	}
+	err = catalogTemplate.Execute(file, page)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("template.Execute: %w", err)
+	}
+
+	path, err := uc.converter.Convert(ctx, source, CatalogPDF)
+	if err != nil {
+		return fmt.Errorf("uc.converter.Convert: %w", err)
+	}
+	defer os.Remove(path)
+	pdf, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("os.Open: %w", err)
+	}
+	defer pdf.Close()
+	if _, err = io.Copy(w, pdf); err != nil {
+		return fmt.Errorf("io.Copy: %w", err)
+	}
+	return nil
+}
+
+// shorten cuts s after n characters at a space, with an ellipsis.
+func shorten(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	cut := string([]rune(s)[:n])
+	if i := strings.LastIndex(cut, " "); i > 0 {
+		cut = cut[:i]
+	}
+	return cut + "…"
+}
+
+var catalogTemplate = template.Must(template.New("catalog").Funcs(template.FuncMap{
+	"date": func(t time.Time) string { return t.Format("2006-01-02") },
+}).Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{ .Title }}</title>
+<style>
+body { font-family: Georgia, serif; font-size: 11pt; margin: 2em; color: #222; }
+header { border-bottom: 1px solid #999; margin-bottom: 1em; }
+article { display: flex; gap: 1em; padding: .6em 0; border-bottom: 1px solid #ddd; break-inside: avoid; page-break-inside: avoid; }
+article img { width: 80px; height: auto; flex: none; }
+article .no-cover { width: 80px; flex: none; }
+h2 { font-size: 12pt; margin: 0; }
+dl { display: grid; grid-template-columns: auto 1fr; gap: 0 .8em; margin: .3em 0; font-size: 9pt; }
+dt { color: #666; }
+dd { margin: 0; }
+p.description { font-size: 9pt; margin: .3em 0 0; }
+@page { margin: 1.5cm; }
+</style>
+</head>
+<body>
+<header>
+<h1>{{ .Title }}</h1>
+{{ with .Description }}<p>{{ . }}</p>{{ end }}
+<p>{{ len .Books }} books, as of {{ date .GeneratedAt }}</p>
+</header>
+{{ range .Books }}
+<article>
+{{ if .Cover }}<img src="{{ .Cover }}" alt="">{{ else }}<div class="no-cover"></div>{{ end }}
+<div>
+<h2>{{ .Title }}</h2>
+{{ with .Author }}<div>{{ . }}</div>{{ end }}
+<dl>
+{{ with .InSeries }}<dt>Series</dt><dd>{{ . }}</dd>{{ end }}
+{{ with .Publisher }}<dt>Publisher</dt><dd>{{ . }}</dd>{{ end }}
+{{ with .Year }}<dt>Year</dt><dd>{{ . }}</dd>{{ end }}
+{{ with .ISBN }}<dt>ISBN</dt><dd>{{ . }}</dd>{{ end }}
+{{ with .Language }}<dt>Language</dt><dd>{{ . }}</dd>{{ end }}
+{{ with .Pages }}<dt>Pages</dt><dd>{{ . }}</dd>{{ end }}
+<dt>Format</dt><dd>{{ .Extension }}</dd>
+<dt>Added</dt><dd>{{ date .CreatedAt }}</dd>
+</dl>
+{{ with .Description }}<p class="description">{{ . }}</p>{{ end }}
+</div>
+</article>
+{{ end }}
+</body>
+</html>
+`))
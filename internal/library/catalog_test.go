@@ -0,0 +1,59 @@
+package library_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func TestCatalog(t *testing.T) {
+	ctx := context.Background()
+	repo := library.NewMemoryBookRepo()
+	for _, book := range []entity.Book{
+		{ID: "dune", Title: "Dune", Author: "Frank Herbert", Series: "Dune", Description: "<p>Spice & <b>sand</b></p>"},
+		{ID: "emma", Title: "Emma", Author: "Jane Austen", Year: 1815, ISBN: "9780141439587"},
+		{ID: "secret", Title: "Secret diary", Author: "Ann", Private: true, UploadedBy: "owner"},
+	} {
+		book.FilePath, book.DocumentID, book.CreatedAt = "books/"+book.ID+".epub", "hash-"+book.ID, time.Now()
+		if err := repo.Store(ctx, book); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	reader := library.WithReader(ctx, "reader")
+
+	var buf bytes.Buffer
+	if err := shelf.Catalog(reader, &buf, "Home library", "", library.CatalogHTML); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	page := buf.String()
+	if !strings.Contains(page, "<h1>Home library</h1>") || !strings.Contains(page, "2 books") {
+		t.Errorf("expected the heading and count of the books the reader sees, got %s", page)
+	}
+	if strings.Index(page, "Frank Herbert") > strings.Index(page, "Jane Austen") || strings.Contains(page, "Secret diary") {
+		t.Errorf("expected the shared books by author, got %s", page)
+	}
+	if !strings.Contains(page, "Spice &amp; sand") || !strings.Contains(page, "9780141439587") {
+		t.Errorf("expected the metadata as text, got %s", page)
+	}
+
+	if err := shelf.Catalog(reader, &buf, "", "", library.CatalogPDF); !errors.Is(err, library.ErrNoConverter) {
+		t.Errorf("expected PDF to need the converter, got %v", err)
+	}
+	shelf.SetConverter(fakeConverter{content: "%PDF-1.7"})
+	buf.Reset()
+	if err := shelf.Catalog(reader, &buf, "", "", library.CatalogPDF); err != nil || buf.String() != "%PDF-1.7" {
+		t.Errorf("expected the converted catalog, got %q, %v", buf.String(), err)
+	}
+	if err := shelf.Catalog(reader, &buf, "", "", "docx"); !errors.Is(err, library.ErrUnknownCatalogFormat) {
+		t.Errorf("expected the format to be refused, got %v", err)
+	}
+}
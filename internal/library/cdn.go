@@ -0,0 +1,44 @@
+package library
+
+import (
+	"context"
+	"fmt"
+)
+
+// SetCDN sends downloads and covers to a CDN serving the book storage,
+// without it the server sends the files.
+func (uc *BookShelf) SetCDN(cdn CDN) {
+	uc.cdn = cdn
+}
+
+// BookFileURL links to the book file of the preferred format on the CDN,
+// "" when there is none.
+func (uc *BookShelf) BookFileURL(ctx context.Context, bookID, format string) (string, error) {
+	if uc.cdn == nil {
+		return "", nil
+	}
+	book, err := uc.BookFormat(ctx, bookID, format)
+	if err != nil {
+		return "", fmt.Errorf("BookShelf - BookFileURL - %w", err)
+	}
+	if err = uc.beforeDownload(ctx, book); err != nil {
+		return "", fmt.Errorf("BookShelf - BookFileURL - %w", err)
+	}
+	uc.metrics.BookDownloaded(book.Extension())
+	return uc.cdn.URL(book.FilePath), nil
+}
+
+// CoverURL links to the original cover on the CDN, "" when there is none.
+func (uc *BookShelf) CoverURL(ctx context.Context, bookID string) (string, error) {
+	if uc.cdn == nil {
+		return "", nil
+	}
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return "", fmt.Errorf("BookShelf - CoverURL - s.getBook: %w", err)
+	}
+	if book.CoverPath == "" {
+		return "", fmt.Errorf("BookShelf - CoverURL - %w", ErrNoCover)
+	}
+	return uc.cdn.URL(book.CoverPath), nil
+}
@@ -0,0 +1,50 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+type fakeCDN struct{}
+
+func (fakeCDN) URL(path string) string {
+	return "https://cdn.example.com/" + path
+}
+
+func TestCDNLinks(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", FilePath: "2024/01/01/book-id.epub"}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+
+	link, err := shelf.BookFileURL(ctx, "book-id", "")
+	if err != nil || link != "" {
+		t.Fatalf("expected no link without a CDN, got %q, %v", link, err)
+	}
+
+	shelf.SetCDN(fakeCDN{})
+	link, err = shelf.BookFileURL(ctx, "book-id", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if link != "https://cdn.example.com/2024/01/01/book-id.epub" {
+		t.Errorf("unexpected book link %q", link)
+	}
+	if _, err = shelf.CoverURL(ctx, "book-id"); !errors.Is(err, library.ErrNoCover) {
+		t.Errorf("expected ErrNoCover, got %v", err)
+	}
+
+	repo.book.CoverPath = "covers/abc.jpg"
+	link, err = shelf.CoverURL(ctx, "book-id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if link != "https://cdn.example.com/covers/abc.jpg" {
+		t.Errorf("unexpected cover link %q", link)
+	}
+}
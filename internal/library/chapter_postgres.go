@@ -0,0 +1,66 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// StoreChapters replaces the chapters of an audiobook.
+func (bdr *BookDatabaseRepo) StoreChapters(ctx context.Context, bookID string, chapters []entity.Chapter) error {
+	_, err := bdr.Conn(ctx).Exec(ctx, `DELETE FROM library_chapter WHERE book_id = $1`, bookID)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - StoreChapters - r.Pool.Exec: %w", err)
+	}
+	if len(chapters) == 0 {
+		return nil
+	}
+
+	positions := make([]int32, len(chapters))
+	titles := make([]string, len(chapters))
+	starts := make([]int64, len(chapters))
+	for i, chapter := range chapters {
+		positions[i] = int32(i)
+		titles[i] = chapter.Title
+		starts[i] = chapter.Start.Milliseconds()
+	}
+	_, err = bdr.Conn(ctx).Exec(ctx, `
+		INSERT INTO library_chapter (book_id, position, title, start_ms)
+		SELECT $1, c.position, c.title, c.start_ms
+		FROM unnest($2::int[], $3::text[], $4::bigint[]) AS c(position, title, start_ms)
+	`, bookID, positions, titles, starts)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - StoreChapters - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (bdr *BookDatabaseRepo) ListChapters(ctx context.Context, bookID string) ([]entity.Chapter, error) {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT title, start_ms
+		FROM library_chapter
+		WHERE book_id = $1
+		ORDER BY position
+	`, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListChapters - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	chapters := make([]entity.Chapter, 0)
+	for rows.Next() {
+		var chapter entity.Chapter
+		var startMS int64
+		if err = rows.Scan(&chapter.Title, &startMS); err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - ListChapters - rows.Scan: %w", err)
+		}
+		chapter.Start = time.Duration(startMS) * time.Millisecond
+		chapters = append(chapters, chapter)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListChapters - rows.Err: %w", err)
+	}
+	return chapters, nil
+}
@@ -0,0 +1,234 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/richtext"
+)
+
+var ErrUnknownCitationFormat = errors.New("citation format must be bibtex or ris")
+
+const (
+	CitationBibTeX = "bibtex"
+	CitationRIS    = "ris"
+)
+
+// Citations renders references to the books for reference managers like
+// Zotero or JabRef. Without ids the whole library is cited, books that are
+// gone are left out.
+func (uc *BookShelf) Citations(ctx context.Context, bookIDs []string, format string) ([]byte, error) {
+	if format != CitationBibTeX && format != CitationRIS {
+		return nil, fmt.Errorf("BookShelf - Citations - %w", ErrUnknownCitationFormat)
+	}
+
+	books := make([]entity.Book, 0, len(bookIDs))
+	if len(bookIDs) > 0 {
+		for _, id := range bookIDs {
+			book, err := uc.getBook(ctx, id)
+			if errors.Is(err, entity.ErrBookNotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, fmt.Errorf("BookShelf - Citations - s.getBook: %w", err)
+			}
+			books = append(books, book)
+		}
+	} else {
+		err := uc.forEachBook(ctx, func(book entity.Book) error {
+			books = append(books, book)
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("BookShelf - Citations - %w", err)
+		}
+	}
+
+	if format == CitationRIS {
+		return RenderRIS(books), nil
+	}
+	return RenderBibTeX(books), nil
+}
+
+// RenderBibTeX renders a @book entry per book, @misc for documents without
+// publisher and ISBN. Keys are the surname of the first author, the year
+// and the first word of the title, made unique with a letter.
+func RenderBibTeX(books []entity.Book) []byte {
+	var b strings.Builder
+	keys := make(map[string]int)
+	for i, book := range books {
+		if i > 0 {
+			b.WriteString("\n")
+		}
+		key := citationKey(book)
+		if n := keys[key]; n > 0 {
+			keys[key]++
+			key += string(rune('a' + n - 1))
+		} else {
+			keys[key] = 1
+		}
+
+		kind := "book"
+		if book.Publisher == "" && book.ISBN == "" {
+			kind = "misc"
+		}
+		fmt.Fprintf(&b, "@%s{%s,\n", kind, key)
+		field := func(name, value string) {
+			if value != "" {
+				fmt.Fprintf(&b, "  %s = {%s},\n", name, bibtexEscape(value))
+			}
+		}
+		field("title", book.Title)
+		field("author", strings.Join(splitAuthors(book.Author), " and "))
+		field("publisher", book.Publisher)
+		if book.Year > 0 {
+			field("year", strconv.Itoa(book.Year))
+		}
+		field("isbn", book.ISBN)
+		field("doi", book.DOI)
+		field("series", book.Series)
+		if book.SeriesIndex != nil && book.SeriesIndex.Valid {
+			field("volume", book.SeriesIndex.Decimal.String())
+		}
+		if book.Pages > 0 && !book.IsAudiobook() {
+			field("pagetotal", strconv.Itoa(book.Pages))
+		}
+		field("language", book.Language)
+		field("keywords", strings.Join(book.Genres, ", "))
+		field("abstract", richtext.PlainText(book.Description))
+		b.WriteString("}\n")
+	}
+	return []byte(b.String())
+}
+
+// RenderRIS renders a RIS record per book, lines end with CRLF as the
+// format asks.
+func RenderRIS(books []entity.Book) []byte {
+	var b strings.Builder
+	for _, book := range books {
+		tag := func(name, value string) {
+			value = strings.Join(strings.Fields(value), " ")
+			if value != "" {
+				fmt.Fprintf(&b, "%s  - %s\r\n", name, value)
+			}
+		}
+		kind := "BOOK"
+		if book.Publisher == "" && book.ISBN == "" {
+			kind = "GEN"
+		}
+		tag("TY", kind)
+		tag("TI", book.Title)
+		for _, author := range splitAuthors(book.Author) {
+			tag("AU", author)
+		}
+		if book.Year > 0 {
+			tag("PY", strconv.Itoa(book.Year))
+		}
+		tag("PB", book.Publisher)
+		tag("SN", book.ISBN)
+		tag("DO", book.DOI)
+		tag("T3", book.Series)
+		if book.SeriesIndex != nil && book.SeriesIndex.Valid {
+			tag("VL", book.SeriesIndex.Decimal.String())
+		}
+		tag("LA", book.Language)
+		for _, genre := range book.Genres {
+			tag("KW", genre)
+		}
+		tag("AB", richtext.PlainText(book.Description))
+		tag("ID", book.ID)
+		b.WriteString("ER  - \r\n")
+	}
+	return []byte(b.String())
+}
+
+// splitAuthors splits "A. Author, B. Author" or "A & B", a single
+// "Surname, Given" stays one author.
+func splitAuthors(author string) []string {
+	var authors []string
+	for _, part := range strings.FieldsFunc(author, func(r rune) bool { return r == '&' || r == ';' }) {
+		names := strings.Split(part, ",")
+		whole := len(names) > 1
+		for _, name := range names {
+			if !strings.Contains(strings.TrimSpace(name), " ") {
+				whole = false
+			}
+		}
+		if !whole {
+			names = []string{part}
+		}
+		for _, name := range names {
+			if name = strings.TrimSpace(name); name != "" {
+				authors = append(authors, name)
+			}
+		}
+	}
+	return authors
+}
+
+func citationKey(book entity.Book) string {
+	surname := ""
+	if authors := splitAuthors(book.Author); len(authors) > 0 {
+		if before, _, found := strings.Cut(authors[0], ","); found {
+			surname = before
+		} else if fields := strings.Fields(authors[0]); len(fields) > 0 {
+			surname = fields[len(fields)-1]
+		}
+	}
+	word := ""
+	// the first word that is not an article
+	for _, w := range strings.Fields(book.Title) {
+		w = keyPart(w)
+		if word == "" {
+			word = w
+		}
+		if len(w) > 3 {
+			word = w
+			break
+		}
+	}
+	year := ""
+	if book.Year > 0 {
+		year = strconv.Itoa(book.Year)
+	}
+	key := keyPart(surname) + year + word
+	if key == "" {
+		return "book"
+	}
+	return key
+}
+
+// keyPart keeps the ASCII letters and digits of s, accents dropped.
+func keyPart(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(strings.ToLower(s)) {
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+var bibtexReplacer = strings.NewReplacer(
+	`\`, `\textbackslash{}`,
+	`{`, `\{`,
+	`}`, `\}`,
+	`&`, `\&`,
+	`%`, `\%`,
+	`$`, `\$`,
+	`#`, `\#`,
+	`_`, `\_`,
+	`~`, `\textasciitilde{}`,
+	`^`, `\textasciicircum{}`,
+)
+
+func bibtexEscape(s string) string {
+	return bibtexReplacer.Replace(strings.Join(strings.Fields(s), " "))
+}
@@ -0,0 +1,41 @@
+package library_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+)
+
+func TestRenderBibTeX(t *testing.T) {
+	index := decimal.NewNullDecimal(decimal.RequireFromString("2"))
+	books := []entity.Book{
+		{ID: "1", Title: "The Hobbit", Author: "J. R. R. Tolkien", Publisher: "Allen & Unwin", Year: 1937, Series: "Middle-earth", SeriesIndex: &index},
+		{ID: "2", Title: "The Hobbit", Author: "Tolkien, J. R. R.", Year: 1937},
+		{ID: "3", Title: "Notes on 50% of {things}", Author: "Ada Lovelace, Charles Babbage"},
+	}
+
+	got := string(library.RenderBibTeX(books))
+	for _, want := range []string{
+		"@book{tolkien1937hobbit,\n  title = {The Hobbit},\n  author = {J. R. R. Tolkien},\n  publisher = {Allen \\& Unwin},\n  year = {1937},\n",
+		"  series = {Middle-earth},\n  volume = {2},\n}\n",
+		"@misc{tolkien1937hobbita,\n  title = {The Hobbit},\n  author = {Tolkien, J. R. R.},\n",
+		"@misc{lovelacenotes,\n  title = {Notes on 50\\% of \\{things\\}},\n  author = {Ada Lovelace and Charles Babbage},\n",
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("expected %q in\n%s", want, got)
+		}
+	}
+}
+
+func TestRenderRIS(t *testing.T) {
+	books := []entity.Book{{ID: "1", Title: "The Hobbit", Author: "J. R. R. Tolkien & Christopher Tolkien", ISBN: "9780261102217", Year: 1937, Genres: []string{"Fantasy"}}}
+
+	want := "TY  - BOOK\r\nTI  - The Hobbit\r\nAU  - J. R. R. Tolkien\r\nAU  - Christopher Tolkien\r\nPY  - 1937\r\nSN  - 9780261102217\r\nKW  - Fantasy\r\nID  - 1\r\nER  - \r\n"
+	if got := string(library.RenderRIS(books)); got != want {
+		t.Errorf("unexpected RIS\n%q", got)
+	}
+}
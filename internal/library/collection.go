@@ -0,0 +1,129 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// CreateCollection starts a reading path owned by username, with the books
+// of its entries in reading order. Editors create collections, every
+// account reads them.
+func (uc *BookShelf) CreateCollection(ctx context.Context, username string, collection entity.Collection) (entity.Collection, error) {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return entity.Collection{}, fmt.Errorf("BookShelf - CreateCollection - %w", err)
+	}
+	collection, err := uc.collectionEntries(ctx, collection)
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookShelf - CreateCollection - %w", err)
+	}
+	collection.Owner = username
+	collection, err = uc.repo.CreateCollection(ctx, collection)
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookShelf - CreateCollection - s.repo.CreateCollection: %w", err)
+	}
+	return collection, nil
+}
+
+// Collections lists the collections of the library by name, without their
+// entries.
+func (uc *BookShelf) Collections(ctx context.Context) ([]entity.Collection, error) {
+	collections, err := uc.repo.ListCollections(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - Collections - s.repo.ListCollections: %w", err)
+	}
+	return collections, nil
+}
+
+// Collection returns the collection with the entries the reader may see, in
+// reading order and with the reading status of username, see
+// entity.Collection.Next. Prerequisites on hidden books are left out.
+func (uc *BookShelf) Collection(ctx context.Context, username, id string) (entity.Collection, error) {
+	collection, err := uc.repo.GetCollection(ctx, id, username)
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookShelf - Collection - s.repo.GetCollection: %w", err)
+	}
+	canSee := uc.canSee(ctx)
+	hidden := make(map[string]bool)
+	collection.Entries = slices.DeleteFunc(collection.Entries, func(entry entity.CollectionEntry) bool {
+		hidden[entry.Book.ID] = !canSee(entry.Book)
+		return hidden[entry.Book.ID]
+	})
+	for i := range collection.Entries {
+		collection.Entries[i].Prerequisites = slices.DeleteFunc(collection.Entries[i].Prerequisites, func(id string) bool {
+			return hidden[id]
+		})
+	}
+	return collection, nil
+}
+
+// UpdateCollection replaces the name, description and entries of the
+// collection. Its owner and admins may change it.
+func (uc *BookShelf) UpdateCollection(ctx context.Context, id string, collection entity.Collection) (entity.Collection, error) {
+	current, err := uc.editableCollection(ctx, id)
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookShelf - UpdateCollection - %w", err)
+	}
+	collection, err = uc.collectionEntries(ctx, collection)
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookShelf - UpdateCollection - %w", err)
+	}
+	collection.ID, collection.Owner, collection.CreatedAt = current.ID, current.Owner, current.CreatedAt
+	collection.UpdatedAt = time.Now()
+	if err = uc.repo.UpdateCollection(ctx, collection); err != nil {
+		return entity.Collection{}, fmt.Errorf("BookShelf - UpdateCollection - s.repo.UpdateCollection: %w", err)
+	}
+	return collection, nil
+}
+
+// DeleteCollection removes the collection, the books stay in the library.
+func (uc *BookShelf) DeleteCollection(ctx context.Context, id string) error {
+	if _, err := uc.editableCollection(ctx, id); err != nil {
+		return fmt.Errorf("BookShelf - DeleteCollection - %w", err)
+	}
+	if err := uc.repo.DeleteCollection(ctx, id); err != nil {
+		return fmt.Errorf("BookShelf - DeleteCollection - s.repo.DeleteCollection: %w", err)
+	}
+	return nil
+}
+
+// editableCollection returns the collection when the editor of the request
+// owns it or is an admin.
+func (uc *BookShelf) editableCollection(ctx context.Context, id string) (entity.Collection, error) {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return entity.Collection{}, err
+	}
+	collection, err := uc.repo.GetCollection(ctx, id, "")
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("s.repo.GetCollection: %w", err)
+	}
+	if role, ok := entity.RoleFrom(ctx); ok && role.CanManage() {
+		return collection, nil
+	}
+	if username, ok := ReaderFrom(ctx); ok && username != collection.Owner {
+		return entity.Collection{}, entity.ErrForbidden
+	}
+	return collection, nil
+}
+
+// collectionEntries validates the collection and fills in the books of its
+// entries, books the editor can not see are not found.
+func (uc *BookShelf) collectionEntries(ctx context.Context, collection entity.Collection) (entity.Collection, error) {
+	collection.Name = strings.TrimSpace(collection.Name)
+	if err := collection.Validate(); err != nil {
+		return entity.Collection{}, err
+	}
+	for i, entry := range collection.Entries {
+		book, err := uc.getBook(ctx, entry.Book.ID)
+		if err != nil {
+			return entity.Collection{}, fmt.Errorf("s.getBook %s: %w", entry.Book.ID, err)
+		}
+		collection.Entries[i].Book = book
+		collection.Entries[i].Note = strings.TrimSpace(entry.Note)
+	}
+	return collection, nil
+}
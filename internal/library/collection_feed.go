@@ -0,0 +1,96 @@
+package library
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/thumbnail"
+)
+
+// ShareCollectionFeed lets anyone holding the returned token read the
+// collection as an OPDS feed, without an account. The token stays valid
+// until the feed is shared again or revoked and can not be shown again.
+func (uc *BookShelf) ShareCollectionFeed(ctx context.Context, id string) (string, error) {
+	if _, err := uc.editableCollection(ctx, id); err != nil {
+		return "", fmt.Errorf("BookShelf - ShareCollectionFeed - %w", err)
+	}
+	secret := make([]byte, 24)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("BookShelf - ShareCollectionFeed - rand.Read: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+	if err := uc.repo.SetCollectionFeed(ctx, id, hashShareToken(token), token[:6]); err != nil {
+		return "", fmt.Errorf("BookShelf - ShareCollectionFeed - s.repo.SetCollectionFeed: %w", err)
+	}
+	return token, nil
+}
+
+// RevokeCollectionFeed stops the shared feed of the collection from working.
+func (uc *BookShelf) RevokeCollectionFeed(ctx context.Context, id string) error {
+	if _, err := uc.editableCollection(ctx, id); err != nil {
+		return fmt.Errorf("BookShelf - RevokeCollectionFeed - %w", err)
+	}
+	if err := uc.repo.SetCollectionFeed(ctx, id, "", ""); err != nil {
+		return fmt.Errorf("BookShelf - RevokeCollectionFeed - s.repo.SetCollectionFeed: %w", err)
+	}
+	return nil
+}
+
+// SharedCollection returns the collection of a feed token with the books
+// a visitor of a public page may see, private and scheduled ones are left
+// out.
+func (uc *BookShelf) SharedCollection(ctx context.Context, token string) (entity.Collection, error) {
+	id, err := uc.repo.GetCollectionIDByFeedHash(ctx, hashShareToken(token))
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookShelf - SharedCollection - s.repo.GetCollectionIDByFeedHash: %w", err)
+	}
+	collection, err := uc.Collection(WithReader(ctx, ""), "", id)
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookShelf - SharedCollection - %w", err)
+	}
+	return collection, nil
+}
+
+// DownloadSharedCollectionBook opens a book file of the shared collection,
+// other books of the library are not found.
+func (uc *BookShelf) DownloadSharedCollectionBook(ctx context.Context, token, bookID, format string) (entity.Book, storage.File, error) {
+	if err := uc.sharedCollectionBook(ctx, token, bookID); err != nil {
+		return entity.Book{}, nil, fmt.Errorf("BookShelf - DownloadSharedCollectionBook - %w", err)
+	}
+	book, file, err := uc.DownloadBook(WithReader(ctx, ""), bookID, format)
+	if err != nil {
+		return book, nil, fmt.Errorf("BookShelf - DownloadSharedCollectionBook - %w", err)
+	}
+	return book, file, nil
+}
+
+// ViewSharedCollectionCover is ViewCoverSized for the books of the shared
+// collection.
+func (uc *BookShelf) ViewSharedCollectionCover(ctx context.Context, token, bookID string, opts thumbnail.Options) (*os.File, error) {
+	if err := uc.sharedCollectionBook(ctx, token, bookID); err != nil {
+		return nil, fmt.Errorf("BookShelf - ViewSharedCollectionCover - %w", err)
+	}
+	file, err := uc.ViewCoverSized(WithReader(ctx, ""), bookID, opts)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - ViewSharedCollectionCover - %w", err)
+	}
+	return file, nil
+}
+
+func (uc *BookShelf) sharedCollectionBook(ctx context.Context, token, bookID string) error {
+	collection, err := uc.SharedCollection(ctx, token)
+	if err != nil {
+		return err
+	}
+	for _, entry := range collection.Entries {
+		if entry.Book.ID == bookID {
+			return nil
+		}
+	}
+	return entity.ErrBookNotFound
+}
@@ -0,0 +1,202 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+const collectionColumns = `id, name, description, owner, created_at, updated_at, feed_token_prefix`
+
+func scanCollection(row pgx.Row) (entity.Collection, error) {
+	var c entity.Collection
+	err := row.Scan(&c.ID, &c.Name, &c.Description, &c.Owner, &c.CreatedAt, &c.UpdatedAt, &c.FeedPrefix)
+	return c, err
+}
+
+func (bdr *BookDatabaseRepo) CreateCollection(ctx context.Context, collection entity.Collection) (entity.Collection, error) {
+	tx, err := bdr.Conn(ctx).Begin(ctx)
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookDatabaseRepo - CreateCollection - r.Pool.Begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	created, err := scanCollection(tx.QueryRow(ctx, `
+		INSERT INTO library_collection (name, description, owner)
+		VALUES ($1, $2, $3)
+		RETURNING `+collectionColumns,
+		collection.Name, collection.Description, collection.Owner))
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookDatabaseRepo - CreateCollection - row.Scan: %w", err)
+	}
+	if err = insertCollectionEntries(ctx, tx, created.ID, collection.Entries); err != nil {
+		return entity.Collection{}, fmt.Errorf("BookDatabaseRepo - CreateCollection - %w", err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return entity.Collection{}, fmt.Errorf("BookDatabaseRepo - CreateCollection - tx.Commit: %w", err)
+	}
+	created.Entries = collection.Entries
+	return created, nil
+}
+
+// UpdateCollection saves the name and description and replaces the
+// entries of the collection.
+func (bdr *BookDatabaseRepo) UpdateCollection(ctx context.Context, collection entity.Collection) error {
+	tx, err := bdr.Conn(ctx).Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - UpdateCollection - r.Pool.Begin: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	tag, err := tx.Exec(ctx, `
+		UPDATE library_collection SET name = $2, description = $3, updated_at = $4
+		WHERE id = $1
+	`, collection.ID, collection.Name, collection.Description, collection.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - UpdateCollection - tx.Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("BookDatabaseRepo - UpdateCollection - %w", entity.ErrCollectionNotFound)
+	}
+	if _, err = tx.Exec(ctx, `DELETE FROM library_collection_entry WHERE collection_id = $1`, collection.ID); err != nil {
+		return fmt.Errorf("BookDatabaseRepo - UpdateCollection - tx.Exec: %w", err)
+	}
+	if err = insertCollectionEntries(ctx, tx, collection.ID, collection.Entries); err != nil {
+		return fmt.Errorf("BookDatabaseRepo - UpdateCollection - %w", err)
+	}
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("BookDatabaseRepo - UpdateCollection - tx.Commit: %w", err)
+	}
+	return nil
+}
+
+func insertCollectionEntries(ctx context.Context, tx pgx.Tx, collectionID string, entries []entity.CollectionEntry) error {
+	for i, entry := range entries {
+		prerequisites := entry.Prerequisites
+		if prerequisites == nil {
+			prerequisites = []string{}
+		}
+		_, err := tx.Exec(ctx, `
+			INSERT INTO library_collection_entry (collection_id, book_id, position, note, prerequisites)
+			VALUES ($1, $2, $3, $4, $5::uuid[])
+		`, collectionID, entry.Book.ID, i, entry.Note, prerequisites)
+		if err != nil {
+			return fmt.Errorf("tx.Exec: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetCollection returns the collection with its entries in reading order,
+// each with the reading status of username.
+func (bdr *BookDatabaseRepo) GetCollection(ctx context.Context, id, username string) (entity.Collection, error) {
+	collection, err := scanCollection(bdr.Conn(ctx).QueryRow(ctx, `
+		SELECT `+collectionColumns+`
+		FROM library_collection
+		WHERE id = $1
+	`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.Collection{}, entity.ErrCollectionNotFound
+	}
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookDatabaseRepo - GetCollection - row.Scan: %w", err)
+	}
+
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT `+bookColumns+`, e.note, e.prerequisites::text[],
+			COALESCE((SELECT status FROM reading_status s WHERE s.book_id = e.book_id AND s.username = $2), '')
+		FROM library_collection_entry e
+		JOIN library_book ON library_book.id = e.book_id
+		WHERE e.collection_id = $1
+		ORDER BY e.position
+	`, id, username)
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookDatabaseRepo - GetCollection - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	collection.Entries = make([]entity.CollectionEntry, 0)
+	for rows.Next() {
+		var entry entity.CollectionEntry
+		entry.Book, err = scanBook(extraRow{rows, []interface{}{&entry.Note, &entry.Prerequisites, &entry.Status}})
+		if err != nil {
+			return entity.Collection{}, fmt.Errorf("BookDatabaseRepo - GetCollection - rows.Scan: %w", err)
+		}
+		collection.Entries = append(collection.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return entity.Collection{}, fmt.Errorf("BookDatabaseRepo - GetCollection - rows.Err: %w", err)
+	}
+	return collection, nil
+}
+
+// ListCollections returns the collections without their entries, by name.
+func (bdr *BookDatabaseRepo) ListCollections(ctx context.Context) ([]entity.Collection, error) {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT `+collectionColumns+`
+		FROM library_collection
+		ORDER BY lower(name), id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListCollections - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	collections := make([]entity.Collection, 0)
+	for rows.Next() {
+		collection, err := scanCollection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - ListCollections - rows.Scan: %w", err)
+		}
+		collections = append(collections, collection)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListCollections - rows.Err: %w", err)
+	}
+	return collections, nil
+}
+
+func (bdr *BookDatabaseRepo) DeleteCollection(ctx context.Context, id string) error {
+	tag, err := bdr.Conn(ctx).Exec(ctx, `DELETE FROM library_collection WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - DeleteCollection - r.Pool.Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("BookDatabaseRepo - DeleteCollection - %w", entity.ErrCollectionNotFound)
+	}
+	return nil
+}
+
+// SetCollectionFeed stores the hash of the token of the shared feed of the
+// collection, an empty hash stops sharing it.
+func (bdr *BookDatabaseRepo) SetCollectionFeed(ctx context.Context, id, tokenHash, prefix string) error {
+	tag, err := bdr.Conn(ctx).Exec(ctx, `
+		UPDATE library_collection SET feed_token_hash = NULLIF($2, ''), feed_token_prefix = $3
+		WHERE id = $1
+	`, id, tokenHash, prefix)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - SetCollectionFeed - r.Pool.Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("BookDatabaseRepo - SetCollectionFeed - %w", entity.ErrCollectionNotFound)
+	}
+	return nil
+}
+
+// GetCollectionIDByFeedHash returns the id of the collection shared with
+// the token of the hash.
+func (bdr *BookDatabaseRepo) GetCollectionIDByFeedHash(ctx context.Context, tokenHash string) (string, error) {
+	var id string
+	err := bdr.Conn(ctx).QueryRow(ctx, `SELECT id FROM library_collection WHERE feed_token_hash = $1`, tokenHash).Scan(&id)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", entity.ErrCollectionNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("BookDatabaseRepo - GetCollectionIDByFeedHash - row.Scan: %w", err)
+	}
+	return id, nil
+}
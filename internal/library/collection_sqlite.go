@@ -0,0 +1,185 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/moroz/uuidv7-go"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+func (bsr *BookSQLiteRepo) CreateCollection(ctx context.Context, collection entity.Collection) (entity.Collection, error) {
+	now := time.Now().UTC()
+	collection.ID, collection.CreatedAt, collection.UpdatedAt, collection.FeedPrefix = uuidv7.Generate().String(), now, now, ""
+	err := bsr.WithTx(ctx, func(ctx context.Context) error {
+		_, err := bsr.Conn(ctx).Exec(ctx, `
+			INSERT INTO library_collection (id, name, description, owner, created_at, updated_at)
+			VALUES ($1, $2, $3, $4, $5, $5)
+		`, collection.ID, collection.Name, collection.Description, collection.Owner, now)
+		if err != nil {
+			return fmt.Errorf("tx.Exec: %w", err)
+		}
+		return bsr.insertCollectionEntries(ctx, collection.ID, collection.Entries)
+	})
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookSQLiteRepo - CreateCollection - %w", err)
+	}
+	return collection, nil
+}
+
+// UpdateCollection saves the name and description and replaces the
+// entries of the collection.
+func (bsr *BookSQLiteRepo) UpdateCollection(ctx context.Context, collection entity.Collection) error {
+	err := bsr.WithTx(ctx, func(ctx context.Context) error {
+		result, err := bsr.Conn(ctx).Exec(ctx, `
+			UPDATE library_collection SET name = $2, description = $3, updated_at = $4
+			WHERE id = $1
+		`, collection.ID, collection.Name, collection.Description, collection.UpdatedAt)
+		if err != nil {
+			return fmt.Errorf("tx.Exec: %w", err)
+		}
+		if n, _ := result.RowsAffected(); n == 0 {
+			return entity.ErrCollectionNotFound
+		}
+		if _, err = bsr.Conn(ctx).Exec(ctx, `DELETE FROM library_collection_entry WHERE collection_id = $1`, collection.ID); err != nil {
+			return fmt.Errorf("tx.Exec: %w", err)
+		}
+		return bsr.insertCollectionEntries(ctx, collection.ID, collection.Entries)
+	})
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - UpdateCollection - %w", err)
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) insertCollectionEntries(ctx context.Context, collectionID string, entries []entity.CollectionEntry) error {
+	for i, entry := range entries {
+		_, err := bsr.Conn(ctx).Exec(ctx, `
+			INSERT INTO library_collection_entry (collection_id, book_id, position, note, prerequisites)
+			VALUES ($1, $2, $3, $4, $5)
+		`, collectionID, entry.Book.ID, i, entry.Note, jsonList(entry.Prerequisites))
+		if err != nil {
+			return fmt.Errorf("tx.Exec: %w", err)
+		}
+	}
+	return nil
+}
+
+// GetCollection returns the collection with its entries in reading order,
+// each with the reading status of username.
+func (bsr *BookSQLiteRepo) GetCollection(ctx context.Context, id, username string) (entity.Collection, error) {
+	collection, err := scanCollection(bsr.Conn(ctx).QueryRow(ctx, `
+		SELECT `+collectionColumns+`
+		FROM library_collection
+		WHERE id = $1
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Collection{}, entity.ErrCollectionNotFound
+	}
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookSQLiteRepo - GetCollection - row.Scan: %w", err)
+	}
+
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT `+sqliteBookColumns+`, e.note, e.prerequisites,
+			COALESCE((SELECT status FROM reading_status s WHERE s.book_id = e.book_id AND s.username = $2), '')
+		FROM library_collection_entry e
+		JOIN library_book ON library_book.id = e.book_id
+		WHERE e.collection_id = $1
+		ORDER BY e.position
+	`, id, username)
+	if err != nil {
+		return entity.Collection{}, fmt.Errorf("BookSQLiteRepo - GetCollection - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	collection.Entries = make([]entity.CollectionEntry, 0)
+	for rows.Next() {
+		var entry entity.CollectionEntry
+		var prerequisites string
+		entry.Book, err = scanSQLiteBook(sqliteExtraRow{rows, []interface{}{&entry.Note, &prerequisites, &entry.Status}})
+		if err != nil {
+			return entity.Collection{}, fmt.Errorf("BookSQLiteRepo - GetCollection - rows.Scan: %w", err)
+		}
+		if err = json.Unmarshal([]byte(prerequisites), &entry.Prerequisites); err != nil {
+			return entity.Collection{}, fmt.Errorf("BookSQLiteRepo - GetCollection - json.Unmarshal: %w", err)
+		}
+		collection.Entries = append(collection.Entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return entity.Collection{}, fmt.Errorf("BookSQLiteRepo - GetCollection - rows.Err: %w", err)
+	}
+	return collection, nil
+}
+
+// ListCollections returns the collections without their entries, by name.
+func (bsr *BookSQLiteRepo) ListCollections(ctx context.Context) ([]entity.Collection, error) {
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT `+collectionColumns+`
+		FROM library_collection
+		ORDER BY lower(name), id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListCollections - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	collections := make([]entity.Collection, 0)
+	for rows.Next() {
+		collection, err := scanCollection(rows)
+		if err != nil {
+			return nil, fmt.Errorf("BookSQLiteRepo - ListCollections - rows.Scan: %w", err)
+		}
+		collections = append(collections, collection)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListCollections - rows.Err: %w", err)
+	}
+	return collections, nil
+}
+
+func (bsr *BookSQLiteRepo) DeleteCollection(ctx context.Context, id string) error {
+	result, err := bsr.Conn(ctx).Exec(ctx, `DELETE FROM library_collection WHERE id = $1`, id)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - DeleteCollection - r.DB.Exec: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("BookSQLiteRepo - DeleteCollection - %w", entity.ErrCollectionNotFound)
+	}
+	return nil
+}
+
+// SetCollectionFeed stores the hash of the token of the shared feed of the
+// collection, an empty hash stops sharing it.
+func (bsr *BookSQLiteRepo) SetCollectionFeed(ctx context.Context, id, tokenHash, prefix string) error {
+	result, err := bsr.Conn(ctx).Exec(ctx, `
+		UPDATE library_collection SET feed_token_hash = NULLIF($2, ''), feed_token_prefix = $3
+		WHERE id = $1
+	`, id, tokenHash, prefix)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - SetCollectionFeed - r.DB.Exec: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("BookSQLiteRepo - SetCollectionFeed - %w", entity.ErrCollectionNotFound)
+	}
+	return nil
+}
+
+// GetCollectionIDByFeedHash returns the id of the collection shared with
+// the token of the hash.
+func (bsr *BookSQLiteRepo) GetCollectionIDByFeedHash(ctx context.Context, tokenHash string) (string, error) {
+	var id string
+	err := bsr.Conn(ctx).QueryRow(ctx, `SELECT id FROM library_collection WHERE feed_token_hash = $1`, tokenHash).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", entity.ErrCollectionNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("BookSQLiteRepo - GetCollectionIDByFeedHash - row.Scan: %w", err)
+	}
+	return id, nil
+}
@@ -0,0 +1,202 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+// collectionRepo keeps books by id and one collection with the reading
+// statuses of one reader.
+type collectionRepo struct {
+	fakeBookRepo
+	books      map[string]entity.Book
+	collection entity.Collection
+	finished   map[string]bool
+	feedHash   string
+}
+
+func (r *collectionRepo) GetById(_ context.Context, id string) (entity.Book, error) {
+	book, ok := r.books[id]
+	if !ok {
+		return entity.Book{}, entity.ErrBookNotFound
+	}
+	return book, nil
+}
+
+func (r *collectionRepo) CreateCollection(_ context.Context, collection entity.Collection) (entity.Collection, error) {
+	collection.ID = "collection-id"
+	r.collection = collection
+	return collection, nil
+}
+
+func (r *collectionRepo) UpdateCollection(_ context.Context, collection entity.Collection) error {
+	r.collection = collection
+	return nil
+}
+
+func (r *collectionRepo) GetCollection(_ context.Context, id, _ string) (entity.Collection, error) {
+	if id != r.collection.ID {
+		return entity.Collection{}, entity.ErrCollectionNotFound
+	}
+	collection := r.collection
+	collection.Entries = nil
+	for _, entry := range r.collection.Entries {
+		entry.Prerequisites = slices.Clone(entry.Prerequisites)
+		if r.finished[entry.Book.ID] {
+			entry.Status = entity.StatusFinished
+		}
+		collection.Entries = append(collection.Entries, entry)
+	}
+	return collection, nil
+}
+
+func (r *collectionRepo) SetCollectionFeed(_ context.Context, id, tokenHash, _ string) error {
+	if id != r.collection.ID {
+		return entity.ErrCollectionNotFound
+	}
+	r.feedHash = tokenHash
+	return nil
+}
+
+func (r *collectionRepo) GetCollectionIDByFeedHash(_ context.Context, tokenHash string) (string, error) {
+	if tokenHash == "" || tokenHash != r.feedHash {
+		return "", entity.ErrCollectionNotFound
+	}
+	return r.collection.ID, nil
+}
+
+func TestCollectionReadingOrder(t *testing.T) {
+	repo := &collectionRepo{
+		books: map[string]entity.Book{
+			"intro":    {ID: "intro", Title: "Introduction"},
+			"private":  {ID: "private", Title: "Notes", Private: true, UploadedBy: "teacher"},
+			"advanced": {ID: "advanced", Title: "Advanced"},
+		},
+		finished: map[string]bool{},
+	}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	teacher := library.WithReader(entity.WithRole(context.Background(), entity.RoleEditor), "teacher")
+
+	entries := func(ids ...[]string) []entity.CollectionEntry {
+		var entries []entity.CollectionEntry
+		for _, e := range ids {
+			entries = append(entries, entity.CollectionEntry{Book: entity.Book{ID: e[0]}, Prerequisites: e[1:]})
+		}
+		return entries
+	}
+	_, err := shelf.CreateCollection(teacher, "teacher", entity.Collection{Name: "Course", Entries: entries([]string{"advanced", "intro"}, []string{"intro"})})
+	if !errors.Is(err, entity.ErrInvalidCollection) {
+		t.Fatalf("expected a prerequisite after its book to be refused, got %v", err)
+	}
+	collection, err := shelf.CreateCollection(teacher, "teacher", entity.Collection{
+		Name:    " Course ",
+		Entries: entries([]string{"intro"}, []string{"private", "intro"}, []string{"advanced", "intro", "private"}),
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if collection.Name != "Course" || collection.Owner != "teacher" || collection.Entries[2].Book.Title != "Advanced" {
+		t.Fatalf("unexpected collection %+v", collection)
+	}
+
+	student := library.WithReader(entity.WithRole(context.Background(), entity.RoleReader), "student")
+	seen, err := shelf.Collection(student, "student", collection.ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(seen.Entries) != 2 || seen.Entries[1].Book.ID != "advanced" || !slices.Equal(seen.Entries[1].Prerequisites, []string{"intro"}) {
+		t.Fatalf("expected the private book to be hidden, got %+v", seen.Entries)
+	}
+
+	next := func() (entity.CollectionEntry, bool) {
+		seen, err := shelf.Collection(student, "student", collection.ID)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return seen.Next()
+	}
+	if entry, ok := next(); !ok || entry.Book.ID != "intro" || seen.Available(seen.Entries[1]) {
+		t.Fatalf("expected intro next and advanced waiting for it, got %+v", entry)
+	}
+	repo.finished["intro"] = true
+	if entry, _ := next(); entry.Book.ID != "advanced" {
+		t.Errorf("expected advanced after intro, got %q", entry.Book.ID)
+	}
+	repo.finished["advanced"] = true
+	if _, ok := next(); ok {
+		t.Errorf("expected the collection to be finished")
+	}
+
+	other := library.WithReader(entity.WithRole(context.Background(), entity.RoleEditor), "other")
+	if _, err = shelf.UpdateCollection(other, collection.ID, entity.Collection{Name: "Mine"}); !errors.Is(err, entity.ErrForbidden) {
+		t.Errorf("expected another editor to be forbidden, got %v", err)
+	}
+	if err = shelf.DeleteCollection(student, collection.ID); !errors.Is(err, entity.ErrForbidden) {
+		t.Errorf("expected a reader to be forbidden, got %v", err)
+	}
+	updated, err := shelf.UpdateCollection(teacher, collection.ID, entity.Collection{Name: "Course", Entries: entries([]string{"advanced"})})
+	if err != nil || len(updated.Entries) != 1 || updated.Owner != "teacher" {
+		t.Errorf("unexpected update %+v, %v", updated, err)
+	}
+}
+
+func TestSharedCollectionFeed(t *testing.T) {
+	books := map[string]entity.Book{
+		"intro":   {ID: "intro", Title: "Introduction"},
+		"private": {ID: "private", Title: "Notes", Private: true, UploadedBy: "teacher"},
+		"other":   {ID: "other", Title: "Not in the collection"},
+	}
+	repo := &collectionRepo{
+		books: books,
+		collection: entity.Collection{ID: "course", Name: "Course", Owner: "teacher", Entries: []entity.CollectionEntry{
+			{Book: books["intro"]},
+			{Book: books["private"]},
+		}},
+	}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	teacher := library.WithReader(entity.WithRole(context.Background(), entity.RoleEditor), "teacher")
+	student := library.WithReader(entity.WithRole(context.Background(), entity.RoleReader), "student")
+
+	if _, err := shelf.ShareCollectionFeed(student, "course"); !errors.Is(err, entity.ErrForbidden) {
+		t.Fatalf("expected a reader to be forbidden, got %v", err)
+	}
+	old, err := shelf.ShareCollectionFeed(teacher, "course")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	token, err := shelf.ShareCollectionFeed(teacher, "course")
+	if err != nil || token == old {
+		t.Fatalf("expected sharing again to give a new token, got %q, %v", token, err)
+	}
+	if _, err = shelf.SharedCollection(context.Background(), old); !errors.Is(err, entity.ErrCollectionNotFound) {
+		t.Errorf("expected the replaced token to stop working, got %v", err)
+	}
+
+	shared, err := shelf.SharedCollection(context.Background(), token)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(shared.Entries) != 1 || shared.Entries[0].Book.ID != "intro" {
+		t.Errorf("expected only the book nobody keeps private, got %+v", shared.Entries)
+	}
+	if _, _, err = shelf.DownloadSharedCollectionBook(context.Background(), token, "other", ""); !errors.Is(err, entity.ErrBookNotFound) {
+		t.Errorf("expected a book outside the collection not to be found, got %v", err)
+	}
+	if _, _, err = shelf.DownloadSharedCollectionBook(context.Background(), token, "private", ""); !errors.Is(err, entity.ErrBookNotFound) {
+		t.Errorf("expected a private book not to be found, got %v", err)
+	}
+
+	if err = shelf.RevokeCollectionFeed(teacher, "course"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = shelf.SharedCollection(context.Background(), token); !errors.Is(err, entity.ErrCollectionNotFound) {
+		t.Errorf("expected the revoked token to stop working, got %v", err)
+	}
+}
@@ -0,0 +1,88 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/banjuer/kompanion/pkg/comic"
+	"github.com/banjuer/kompanion/pkg/metadata"
+)
+
+// SetComicReader replaces the reader of CBZ and CBR archives, the default
+// looks up unrar in PATH.
+func (uc *BookShelf) SetComicReader(r ComicReader) {
+	uc.comics = r
+}
+
+// readComic fills the metadata of a comic archive from its ComicInfo.xml,
+// the cover is the front cover page or the first page. Archives that can
+// not be read keep the metadata they have.
+func (uc *BookShelf) readComic(ctx context.Context, file *os.File, m metadata.Metadata) metadata.Metadata {
+	archive, err := uc.comics.Open(ctx, file, m.Format)
+	if err != nil {
+		uc.logger.Warn("BookShelf - readComic - uc.comics.Open: %s", err)
+		return m
+	}
+	defer archive.Close()
+
+	info, err := comic.ReadInfo(ctx, archive)
+	if err != nil {
+		uc.logger.Warn("BookShelf - readComic - comic.ReadInfo: %s", err)
+	}
+	m.Title = info.Title
+	if m.Title == "" && info.Series != "" {
+		m.Title = strings.TrimSpace(info.Series + " " + info.Number)
+	}
+	m.Author = info.Writer
+	m.Description = info.Summary
+	m.Publisher = info.Publisher
+	m.Date = info.Date()
+	m.Language = info.LanguageISO
+	m.Series = info.Series
+	m.SeriesIndex = info.Number
+	m.ISBN = info.GTIN
+	m.Pages = len(archive.Pages())
+
+	m.Cover, err = comic.Cover(ctx, archive, info)
+	if err != nil {
+		uc.logger.Warn("BookShelf - readComic - comic.Cover: %s", err)
+	}
+	return m
+}
+
+// ComicPage returns the image of page n of a comic book, counted from 1,
+// with the name of the image in the archive. Pages of books a download hook
+// refuses are refused too.
+func (uc *BookShelf) ComicPage(ctx context.Context, bookID string, n int) (string, []byte, error) {
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return "", nil, fmt.Errorf("BookShelf - ComicPage - s.getBook: %w", err)
+	}
+	format := strings.ToLower(book.Extension())
+	if !metadata.IsComic(format) {
+		return "", nil, fmt.Errorf("BookShelf - ComicPage - %s: %w", format, comic.ErrUnsupportedFormat)
+	}
+
+	if err = uc.beforeDownload(ctx, book); err != nil {
+		return "", nil, fmt.Errorf("BookShelf - ComicPage - %w", err)
+	}
+
+	file, err := uc.storage.Read(ctx, book.FilePath)
+	if err != nil {
+		return "", nil, fmt.Errorf("BookShelf - ComicPage - s.storage.Read: %w", err)
+	}
+	defer file.Close()
+	archive, err := uc.comics.Open(ctx, file, format)
+	if err != nil {
+		return "", nil, fmt.Errorf("BookShelf - ComicPage - uc.comics.Open: %w", err)
+	}
+	defer archive.Close()
+
+	name, data, err := comic.Page(ctx, archive, n)
+	if err != nil {
+		return "", nil, fmt.Errorf("BookShelf - ComicPage - %w", err)
+	}
+	return name, data, nil
+}
@@ -0,0 +1,109 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// approximateCountMin is the estimated library size from which unfiltered
+// listings take the planner estimate instead of counting every book.
+const approximateCountMin = 100000
+
+// countCacheTTL bounds how stale an approximate count gets when other
+// instances change the library, changes made here clear the cache.
+const countCacheTTL = time.Minute
+
+// countCacheSize is the most listings counted at once, the cache starts
+// over when it is full.
+const countCacheSize = 1024
+
+type approximateKey struct{}
+
+// WithApproximateCount lets listings of the request take estimated or
+// cached totals instead of counting all matching books every time, for
+// endpoints where page numbers only need to be close.
+func WithApproximateCount(ctx context.Context) context.Context {
+	return context.WithValue(ctx, approximateKey{}, true)
+}
+
+func approximateCount(ctx context.Context) bool {
+	approximate, _ := ctx.Value(approximateKey{}).(bool)
+	return approximate
+}
+
+type countCache struct {
+	mu     sync.Mutex
+	counts map[string]cachedCount
+}
+
+type cachedCount struct {
+	count   int
+	expires time.Time
+}
+
+func (c *countCache) get(key string) (int, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	cached, ok := c.counts[key]
+	if !ok || time.Now().After(cached.expires) {
+		return 0, false
+	}
+	return cached.count, true
+}
+
+func (c *countCache) put(key string, count int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.counts == nil || len(c.counts) >= countCacheSize {
+		c.counts = make(map[string]cachedCount)
+	}
+	c.counts[key] = cachedCount{count: count, expires: time.Now().Add(countCacheTTL)}
+}
+
+// reset drops the cached counts after the library changed.
+func (c *countCache) reset() {
+	c.mu.Lock()
+	c.counts = nil
+	c.mu.Unlock()
+}
+
+// countBooks counts the books matching query and filter, exactly unless
+// the request allows approximate counts: those are cached until the
+// library changes, unfiltered ones of large libraries are estimated. The
+// second result tells whether the count is approximate.
+func (uc *BookShelf) countBooks(ctx context.Context, query string, filter BookFilter) (int, bool, error) {
+	count := func() (int, error) {
+		if query == "" {
+			return uc.repo.Count(ctx, filter)
+		}
+		return uc.repo.CountSearch(ctx, query, filter)
+	}
+	if !approximateCount(ctx) {
+		n, err := count()
+		return n, false, err
+	}
+
+	filter.Facets = false
+	if query == "" && filter == (BookFilter{}) {
+		estimate, err := uc.repo.EstimateCount(ctx)
+		if err != nil {
+			return 0, false, fmt.Errorf("s.repo.EstimateCount: %w", err)
+		}
+		if estimate >= approximateCountMin {
+			return estimate, true, nil
+		}
+	}
+
+	key := fmt.Sprintf("%q %+v", query, filter)
+	if n, ok := uc.counts.get(key); ok {
+		return n, true, nil
+	}
+	n, err := count()
+	if err != nil {
+		return 0, false, err
+	}
+	uc.counts.put(key, n)
+	return n, false, nil
+}
@@ -0,0 +1,181 @@
+package library
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/banjuer/kompanion/pkg/thumbnail"
+)
+
+var ErrNoCover = errors.New("book has no cover")
+
+// coverSizeStep - sizes whose width and height are multiples of it are
+// kept in the book storage next to the cover, shared by all servers. Other
+// sizes only go to the cover cache, so odd sizes do not fill the storage.
+const coverSizeStep = 50
+
+// SetCoverCache keeps resized covers, without it every request resizes.
+func (uc *BookShelf) SetCoverCache(cache CoverCache) {
+	uc.coverCache = cache
+}
+
+// SetCoverEncoder makes covers in WebP and AVIF for clients taking them.
+func (uc *BookShelf) SetCoverEncoder(encoder CoverEncoder) {
+	uc.encoder = encoder
+}
+
+// CoverFormat picks the format of covers for the Accept header of a
+// request, empty for the format of the stored cover.
+func (uc *BookShelf) CoverFormat(accept string) string {
+	if uc.encoder == nil {
+		return ""
+	}
+	return thumbnail.Negotiate(accept, uc.encoder.Formats())
+}
+
+// ViewCoverSized returns the cover scaled to the options, the stored
+// original when none are given.
+func (uc *BookShelf) ViewCoverSized(ctx context.Context, bookID string, opts thumbnail.Options) (*os.File, error) {
+	return uc.cachedCover(ctx, bookID, opts, func() (*os.File, error) {
+		return uc.viewCoverSized(ctx, bookID, opts)
+	})
+}
+
+func (uc *BookShelf) viewCoverSized(ctx context.Context, bookID string, opts thumbnail.Options) (*os.File, error) {
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - ViewCoverSized - s.getBook: %w", err)
+	}
+	if book.CoverPath == "" {
+		return nil, fmt.Errorf("BookShelf - ViewCoverSized - %w", ErrNoCover)
+	}
+	if opts.IsOriginal() {
+		return uc.viewCover(ctx, bookID)
+	}
+
+	// a replaced cover changes updated_at, old sizes age out of the cache
+	key := book.CoverPath + "@" + strconv.FormatInt(book.UpdatedAt.UnixNano(), 10) + "/" + opts.String()
+	if uc.coverCache != nil {
+		if file, ok := uc.coverCache.Get(key); ok {
+			return file, nil
+		}
+	}
+
+	data, err := uc.coverSize(ctx, book.CoverPath, opts)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - ViewCoverSized - %w", err)
+	}
+	if uc.coverCache != nil {
+		file, err := uc.coverCache.Put(key, data)
+		if err != nil {
+			return nil, fmt.Errorf("BookShelf - ViewCoverSized - cache.Put: %w", err)
+		}
+		return file, nil
+	}
+
+	file, err := tempCover(data)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - ViewCoverSized - tempCover: %w", err)
+	}
+	return file, nil
+}
+
+// coverSize reads the size from the book storage or resizes the cover,
+// storing the sizes of coverSizeStep.
+func (uc *BookShelf) coverSize(ctx context.Context, coverPath string, opts thumbnail.Options) ([]byte, error) {
+	stored := opts.Width%coverSizeStep == 0 && opts.Height%coverSizeStep == 0
+	sizePath := coverSizesDir(coverPath) + opts.String()
+	if stored {
+		if file, err := uc.storage.Open(ctx, sizePath); err == nil {
+			defer file.Close()
+			return io.ReadAll(file)
+		}
+	}
+
+	original, err := uc.storage.Open(ctx, coverPath)
+	if err != nil {
+		return nil, fmt.Errorf("s.storage.Open: %w", err)
+	}
+	defer original.Close()
+	var data []byte
+	if uc.encoder != nil {
+		data, _, err = uc.encoder.Resize(ctx, original, opts)
+	} else {
+		data, _, err = thumbnail.Resize(original, opts)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("thumbnail.Resize: %w", err)
+	}
+
+	if stored {
+		if err = uc.storeCoverSize(ctx, coverPath, opts.String(), data); err != nil {
+			uc.logger.Warn("BookShelf - ViewCoverSized - storeCoverSize: %s", err)
+		}
+	}
+	return data, nil
+}
+
+// coverSizesDir holds the sizes of a cover, covers are content addressed
+// so a size never goes stale. Its index lists the sizes for releaseCover.
+func coverSizesDir(coverPath string) string {
+	return strings.TrimSuffix(coverPath, path.Ext(coverPath)) + ".sizes/"
+}
+
+// storeCoverSize writes a size and adds it to the index. Two servers
+// adding sizes at once may drop one from the index, the size is then left
+// behind when the cover is deleted.
+func (uc *BookShelf) storeCoverSize(ctx context.Context, coverPath, name string, data []byte) error {
+	dir := coverSizesDir(coverPath)
+	if err := uc.storage.Put(ctx, dir+name, bytes.NewReader(data)); err != nil {
+		return fmt.Errorf("s.storage.Put: %w", err)
+	}
+	sizes := uc.coverSizes(ctx, coverPath)
+	for _, size := range sizes {
+		if size == name {
+			return nil
+		}
+	}
+	index := strings.Join(append(sizes, name), "\n") + "\n"
+	if err := uc.storage.Put(ctx, dir+"index", strings.NewReader(index)); err != nil {
+		return fmt.Errorf("s.storage.Put: %w", err)
+	}
+	return nil
+}
+
+func (uc *BookShelf) coverSizes(ctx context.Context, coverPath string) []string {
+	file, err := uc.storage.Open(ctx, coverSizesDir(coverPath)+"index")
+	if err != nil {
+		return nil
+	}
+	defer file.Close()
+	var sizes []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if size := strings.TrimSpace(scanner.Text()); size != "" {
+			sizes = append(sizes, size)
+		}
+	}
+	return sizes
+}
+
+// deleteCoverSizes removes the stored sizes of a deleted cover.
+func (uc *BookShelf) deleteCoverSizes(ctx context.Context, coverPath string) {
+	sizes := uc.coverSizes(ctx, coverPath)
+	if len(sizes) == 0 {
+		return
+	}
+	dir := coverSizesDir(coverPath)
+	for _, size := range append(sizes, "index") {
+		if err := uc.storage.Delete(ctx, dir+size); err != nil {
+			uc.logger.Warn("BookShelf - releaseCover - failed to delete cover size: %s", err)
+		}
+	}
+}
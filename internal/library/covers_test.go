@@ -0,0 +1,125 @@
+package library_test
+
+import (
+	"context"
+	"image"
+	"image/jpeg"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/bookmeta"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/thumbnail"
+)
+
+type fakeCoverSource map[string][]byte
+
+func (s fakeCoverSource) Cover(_ context.Context, isbn string) ([]byte, error) {
+	if cover, ok := s[isbn]; ok {
+		return cover, nil
+	}
+	return nil, bookmeta.ErrBookNotFound
+}
+
+func TestStoreBookFetchesMissingCover(t *testing.T) {
+	repo := &uniqueBookRepo{books: make(map[string]entity.Book)}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	shelf.SetCoverSource(fakeCoverSource{"9780261102217": []byte("\x89PNG\r\n\x1a\n cover")})
+
+	for _, tc := range []struct {
+		isbn  string
+		cover bool
+	}{
+		{"978-0-261-10221-7", true},
+		{"978-0-441-17271-9", false},
+	} {
+		file, err := os.CreateTemp(t.TempDir(), "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = file.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>` + tc.isbn + `</book-title></title-info>
+<publish-info><isbn>` + tc.isbn + `</isbn></publish-info></description><body><p>text</p></body></FictionBook>`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Seek(0, 0)
+		book, err := shelf.StoreBook(context.Background(), file, "book.fb2")
+		file.Close()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if (book.CoverPath != "") != tc.cover {
+			t.Errorf("expected a cover %v for %s, got %q", tc.cover, tc.isbn, book.CoverPath)
+		}
+	}
+}
+
+func TestCoverSizesStored(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	shelf := library.NewBookShelf(store, library.NewMemoryBookRepo(), logger.New("error"))
+	upload := filepath.Join(t.TempDir(), "sizes.fb2")
+	err := os.WriteFile(upload, []byte(`<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>Sizes</book-title></title-info></description><body><p>text</p></body></FictionBook>`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(upload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	book, err := shelf.StoreBook(ctx, file, "sizes.fb2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	setCover := func(shade uint8) entity.Book {
+		img := image.NewGray(image.Rect(0, 0, 400, 600))
+		for i := range img.Pix {
+			img.Pix[i] = shade
+		}
+		file, err := os.CreateTemp(t.TempDir(), "cover")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if err = jpeg.Encode(file, img, nil); err != nil {
+			t.Fatal(err)
+		}
+		book, err := shelf.UpdateCover(ctx, book.ID, file)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		return book
+	}
+	book = setCover(10)
+
+	for _, width := range []int{200, 123} {
+		file, err := shelf.ViewCoverSized(ctx, book.ID, thumbnail.Options{Width: width, Fit: thumbnail.FitContain})
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		file.Close()
+	}
+	sizes := strings.TrimSuffix(book.CoverPath, ".jpg") + ".sizes/"
+	if _, err = store.Open(ctx, sizes+"200x0-contain"); err != nil {
+		t.Fatalf("expected the 200 wide size stored: %v", err)
+	}
+	if _, err = store.Open(ctx, sizes+"123x0-contain"); err == nil {
+		t.Error("expected sizes off the step left out of the storage")
+	}
+
+	// replacing the cover deletes the sizes of the old one
+	setCover(200)
+	for _, name := range []string{"200x0-contain", "index"} {
+		if _, err = store.Open(ctx, sizes+name); err == nil {
+			t.Errorf("expected %s deleted with the cover", name)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package library
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// Cursor points at a book in a sorted list: keyset pagination continues
+// strictly after (or before) its sort key and id, so pages do not shift
+// when books are added or removed between requests.
+// Clients only see it as an opaque string.
+type Cursor struct {
+	SortBy    string `json:"s"`
+	SortOrder string `json:"o"`
+	Key       string `json:"k"`
+	ID        string `json:"i"`
+	Before    bool   `json:"b,omitempty"`
+	Locale    string `json:"l,omitempty"`
+}
+
+// IsZero reports a cursor for the first page.
+func (c Cursor) IsZero() bool {
+	return c.ID == ""
+}
+
+// EncodeCursor -.
+func EncodeCursor(c Cursor) string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+// DecodeCursor parses a cursor issued for the same sorting; empty string is the first page.
+func DecodeCursor(s, sortBy, sortOrder string) (Cursor, error) {
+	if s == "" {
+		return Cursor{SortBy: sortBy, SortOrder: sortOrder}, nil
+	}
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	var c Cursor
+	if err = json.Unmarshal(data, &c); err != nil {
+		return Cursor{}, ErrInvalidCursor
+	}
+	if c.SortBy != sortBy || c.SortOrder != sortOrder || c.ID == "" {
+		return Cursor{}, ErrInvalidCursor
+	}
+	return c, nil
+}
+
+// decodeLocaleCursor also ties the cursor to the request locale, title keys
+// differ between locales.
+func decodeLocaleCursor(s, sortBy, sortOrder, locale string) (Cursor, error) {
+	c, err := DecodeCursor(s, sortBy, sortOrder)
+	if err != nil {
+		return Cursor{}, err
+	}
+	if !c.IsZero() && c.Locale != locale {
+		return Cursor{}, ErrInvalidCursor
+	}
+	c.Locale = locale
+	return c, nil
+}
+
+// normalizeSort falls back to the newest first order the same way the repository does.
+func normalizeSort(sortBy, sortOrder string) (string, string) {
+	if _, ok := sortColumns[sortBy]; !ok {
+		sortBy = "created_at"
+	}
+	if sortOrder != "asc" {
+		sortOrder = "desc"
+	}
+	return sortBy, sortOrder
+}
+
+func cursorFor(book entity.Book, c Cursor, before bool) string {
+	return EncodeCursor(Cursor{
+		SortBy:    c.SortBy,
+		SortOrder: c.SortOrder,
+		Key:       sortKey(book, c.SortBy, c.Locale),
+		ID:        book.ID,
+		Before:    before,
+		Locale:    c.Locale,
+	})
+}
+
+// sortKey must match sortColumns and localizedSort in book_postgres.go, NULLs are read as zero values there.
+func sortKey(book entity.Book, sortBy, locale string) string {
+	switch sortBy {
+	case "title":
+		return StripArticle(locale, book.Title)
+	case "author":
+		return book.Author
+	case "publisher":
+		return book.Publisher
+	case "isbn":
+		return book.ISBN
+	case "year":
+		return strconv.Itoa(book.Year)
+	case "size":
+		return strconv.FormatInt(book.FileSize, 10)
+	case "pages":
+		return strconv.Itoa(book.Pages)
+	case "rating":
+		return strconv.FormatFloat(book.Rating, 'f', 2, 64)
+	case "updated_at":
+		return book.UpdatedAt.UTC().Format(time.RFC3339Nano)
+	default:
+		return book.CreatedAt.UTC().Format(time.RFC3339Nano)
+	}
+}
+
+// newCursorBookList trims the extra book fetched to detect another page
+// and issues cursors for the neighbouring pages.
+func newCursorBookList(books []entity.Book, c Cursor, perPage int) PaginatedBookList {
+	hasMore := len(books) > perPage
+	if hasMore {
+		if c.Before {
+			books = books[len(books)-perPage:]
+		} else {
+			books = books[:perPage]
+		}
+	}
+
+	p := PaginatedBookList{Books: books, perPage: perPage}
+	if len(books) == 0 {
+		return p
+	}
+	first, last := books[0], books[len(books)-1]
+	if hasMore || c.Before {
+		p.NextCursor = cursorFor(last, c, false)
+	}
+	if (hasMore && c.Before) || (!c.Before && !c.IsZero()) {
+		p.PrevCursor = cursorFor(first, c, true)
+	}
+	return p
+}
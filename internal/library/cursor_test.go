@@ -0,0 +1,126 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func TestDecodeCursorRejectsOtherSorting(t *testing.T) {
+	cursor := library.EncodeCursor(library.Cursor{SortBy: "title", SortOrder: "asc", Key: "a", ID: "1"})
+
+	_, err := library.DecodeCursor(cursor, "created_at", "desc")
+	if !errors.Is(err, library.ErrInvalidCursor) {
+		t.Fatalf("expected ErrInvalidCursor, got %v", err)
+	}
+
+	c, err := library.DecodeCursor(cursor, "title", "asc")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.Key != "a" || c.ID != "1" {
+		t.Fatalf("unexpected cursor %+v", c)
+	}
+}
+
+func TestListBooksByCursorWalksForwardAndBack(t *testing.T) {
+	ctx := context.Background()
+	repo := newKeysetBookRepo(5)
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+
+	first, err := shelf.ListBooksByCursor(ctx, library.BookFilter{}, "created_at", "desc", "", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertBookIDs(t, first.Books, "book-5", "book-4")
+	if first.PrevCursor != "" || first.NextCursor == "" {
+		t.Fatalf("expected only next cursor on first page, got %+v", first)
+	}
+
+	second, err := shelf.ListBooksByCursor(ctx, library.BookFilter{}, "created_at", "desc", first.NextCursor, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertBookIDs(t, second.Books, "book-3", "book-2")
+
+	last, err := shelf.ListBooksByCursor(ctx, library.BookFilter{}, "created_at", "desc", second.NextCursor, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertBookIDs(t, last.Books, "book-1")
+	if last.NextCursor != "" {
+		t.Fatalf("expected no next cursor on last page")
+	}
+
+	back, err := shelf.ListBooksByCursor(ctx, library.BookFilter{}, "created_at", "desc", second.PrevCursor, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	assertBookIDs(t, back.Books, "book-5", "book-4")
+	if back.PrevCursor != "" || back.NextCursor == "" {
+		t.Fatalf("expected to be back on first page, got %+v", back)
+	}
+}
+
+func assertBookIDs(t *testing.T, books []entity.Book, ids ...string) {
+	t.Helper()
+	got := make([]string, 0, len(books))
+	for _, b := range books {
+		got = append(got, b.ID)
+	}
+	if fmt.Sprint(got) != fmt.Sprint(ids) {
+		t.Fatalf("expected books %v, got %v", ids, got)
+	}
+}
+
+// keysetBookRepo implements created_at desc keyset pagination in memory.
+type keysetBookRepo struct {
+	fakeBookRepo
+	books []entity.Book
+}
+
+func newKeysetBookRepo(n int) *keysetBookRepo {
+	repo := &keysetBookRepo{}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	for i := 1; i <= n; i++ {
+		repo.books = append(repo.books, entity.Book{
+			ID:        fmt.Sprintf("book-%d", i),
+			CreatedAt: start.Add(time.Duration(i) * time.Hour),
+		})
+	}
+	return repo
+}
+
+func (r *keysetBookRepo) ListByCursor(_ context.Context, _ library.BookFilter, _, _ string, c library.Cursor, limit int) ([]entity.Book, error) {
+	books := append([]entity.Book(nil), r.books...)
+	sort.Slice(books, func(i, j int) bool { return books[i].CreatedAt.After(books[j].CreatedAt) })
+	if c.Before {
+		sort.Slice(books, func(i, j int) bool { return books[i].CreatedAt.Before(books[j].CreatedAt) })
+	}
+
+	result := make([]entity.Book, 0)
+	for _, b := range books {
+		key := b.CreatedAt.Format(time.RFC3339Nano)
+		if !c.IsZero() && ((!c.Before && key >= c.Key) || (c.Before && key <= c.Key)) {
+			continue
+		}
+		result = append(result, b)
+		if len(result) == limit {
+			break
+		}
+	}
+	if c.Before {
+		for i, j := 0, len(result)-1; i < j; i, j = i+1, j-1 {
+			result[i], result[j] = result[j], result[i]
+		}
+	}
+	return result, nil
+}
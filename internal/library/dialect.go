@@ -0,0 +1,40 @@
+package library
+
+import "fmt"
+
+// dialect spells what the databases of the library write differently in
+// the conditions of bookConditions, the rest of them is shared.
+type dialect struct {
+	ilike  string // matches text against a parameter escaped with escapeLike, ignoring case
+	like   string // ilike of folded text
+	fold   string // lowercases and drops accents
+	number string // a parameter compared with a number
+}
+
+var postgresDialect = dialect{
+	ilike:  "%s ILIKE %s",
+	like:   "%s LIKE %s",
+	fold:   "library_fold(%s)",
+	number: "%s::numeric",
+}
+
+// sqliteDialect spells out the escape of LIKE, which ignores the case of
+// ASCII letters only.
+var sqliteDialect = dialect{
+	ilike:  `lower(%s) LIKE lower(%s) ESCAPE '\'`,
+	like:   `%s LIKE %s ESCAPE '\'`,
+	fold:   "lower(unaccent(%s))",
+	number: "CAST(%s AS REAL)",
+}
+
+func (d dialect) matches(expr string, n int) string {
+	return fmt.Sprintf(d.ilike, expr, fmt.Sprintf("$%d", n))
+}
+
+func (d dialect) matchesFolded(expr string, n int) string {
+	return fmt.Sprintf(d.like, fmt.Sprintf(d.fold, expr), fmt.Sprintf(d.fold, fmt.Sprintf("$%d", n)))
+}
+
+func (d dialect) numberParam(n int) string {
+	return fmt.Sprintf(d.number, fmt.Sprintf("$%d", n))
+}
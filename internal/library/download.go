@@ -0,0 +1,38 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// downloadHistoryLimit keeps the history to the books that matter.
+const downloadHistoryLimit = 100
+
+// RecordDownload adds a download of the book to the history.
+func (uc *BookShelf) RecordDownload(ctx context.Context, download entity.Download) error {
+	if download.DownloadedAt.IsZero() {
+		download.DownloadedAt = time.Now()
+	}
+	if err := uc.repo.AddDownload(ctx, download); err != nil {
+		return fmt.Errorf("BookShelf - RecordDownload - s.repo.AddDownload: %w", err)
+	}
+	return nil
+}
+
+// Downloads -. books downloaded by the user or a device, last downloaded
+// first; unopened keeps the ones no device has opened yet.
+func (uc *BookShelf) Downloads(ctx context.Context, username string, unopened bool) ([]entity.DownloadedBook, error) {
+	downloads, err := uc.repo.ListDownloads(ctx, username, unopened, downloadHistoryLimit)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - Downloads - s.repo.ListDownloads: %w", err)
+	}
+	// devices share their downloads with every account, not the books
+	canSee := uc.canSee(ctx)
+	return slices.DeleteFunc(downloads, func(d entity.DownloadedBook) bool {
+		return !canSee(d.Book)
+	}), nil
+}
@@ -0,0 +1,73 @@
+package library
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+func (bdr *BookDatabaseRepo) AddDownload(ctx context.Context, download entity.Download) error {
+	_, err := bdr.Conn(ctx).Exec(ctx, `
+		INSERT INTO book_download (book_id, username, device_name, client, downloaded_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, download.BookID, download.Username, download.DeviceName, download.Client, download.DownloadedAt)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - AddDownload - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// ListDownloads returns downloaded books, last downloaded first. Devices are
+// shared by all accounts, so their downloads are part of every history.
+func (bdr *BookDatabaseRepo) ListDownloads(ctx context.Context, username string, unopened bool, limit int) ([]entity.DownloadedBook, error) {
+	opened := `(EXISTS (SELECT 1 FROM sync_progress p WHERE p.koreader_partial_md5 = library_book.koreader_partial_md5)
+			OR EXISTS (SELECT 1 FROM stats_book s WHERE s.koreader_partial_md5 = library_book.koreader_partial_md5))`
+	where := ""
+	if unopened {
+		where = "WHERE NOT " + opened
+	}
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT `+bookColumns+`, d.downloads, d.last_downloaded_at, `+opened+`
+		FROM (
+			SELECT book_id, COUNT(*) AS downloads, MAX(downloaded_at) AS last_downloaded_at
+			FROM book_download
+			WHERE username = $1 OR device_name <> ''
+			GROUP BY book_id
+		) d
+		JOIN library_book ON library_book.id = d.book_id
+		`+where+`
+		ORDER BY d.last_downloaded_at DESC
+		LIMIT $2
+	`, username, limit)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListDownloads - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	downloads := make([]entity.DownloadedBook, 0)
+	for rows.Next() {
+		var d entity.DownloadedBook
+		d.Book, err = scanBook(extraRow{rows, []interface{}{&d.Downloads, &d.LastDownloadedAt, &d.Opened}})
+		if err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - ListDownloads - rows.Scan: %w", err)
+		}
+		downloads = append(downloads, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListDownloads - rows.Err: %w", err)
+	}
+	return downloads, nil
+}
+
+// extraRow scans columns selected after bookColumns into extra.
+type extraRow struct {
+	pgx.Row
+	extra []interface{}
+}
+
+func (r extraRow) Scan(dest ...interface{}) error {
+	return r.Row.Scan(append(dest, r.extra...)...)
+}
@@ -0,0 +1,87 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"slices"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// LinkEdition marks bookID as an edition, translation or format of the
+// work otherID belongs to. Works of both books are merged.
+func (uc *BookShelf) LinkEdition(ctx context.Context, bookID, otherID, relation string) error {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return fmt.Errorf("BookShelf - LinkEdition - %w", err)
+	}
+	parsed, err := entity.ParseEditionRelation(relation)
+	if err != nil {
+		return fmt.Errorf("BookShelf - LinkEdition - %w", err)
+	}
+	if bookID == otherID {
+		return fmt.Errorf("BookShelf - LinkEdition - %w", entity.ErrSameEdition)
+	}
+	for _, id := range []string{bookID, otherID} {
+		if _, err = uc.getBook(ctx, id); err != nil {
+			return fmt.Errorf("BookShelf - LinkEdition - s.getBook: %w", err)
+		}
+	}
+	if err = uc.repo.LinkEdition(ctx, bookID, otherID, parsed); err != nil {
+		return fmt.Errorf("BookShelf - LinkEdition - s.repo.LinkEdition: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	return nil
+}
+
+// UnlinkEdition takes a book out of its work.
+func (uc *BookShelf) UnlinkEdition(ctx context.Context, bookID string) error {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return fmt.Errorf("BookShelf - UnlinkEdition - %w", err)
+	}
+	if err := uc.repo.UnlinkEdition(ctx, bookID); err != nil {
+		return fmt.Errorf("BookShelf - UnlinkEdition - s.repo.UnlinkEdition: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	return nil
+}
+
+// Editions lists the other books of the work of a book.
+func (uc *BookShelf) Editions(ctx context.Context, bookID string) ([]entity.Edition, error) {
+	if _, err := uc.getBook(ctx, bookID); err != nil {
+		return nil, fmt.Errorf("BookShelf - Editions - s.getBook: %w", err)
+	}
+	editions, err := uc.repo.ListEditions(ctx, []string{bookID})
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - Editions - s.repo.ListEditions: %w", err)
+	}
+	return uc.visibleEditions(ctx, editions[bookID]), nil
+}
+
+// visibleEditions drops the editions the reader may not see, never nil.
+func (uc *BookShelf) visibleEditions(ctx context.Context, editions []entity.Edition) []entity.Edition {
+	canSee := uc.canSee(ctx)
+	return slices.DeleteFunc(append([]entity.Edition{}, editions...), func(e entity.Edition) bool {
+		return !canSee(e.Book)
+	})
+}
+
+// withEditions adds the other editions of the listed books when the filter
+// groups them, readers pick the edition they want from the list.
+func (uc *BookShelf) withEditions(ctx context.Context, filter BookFilter, list PaginatedBookList, method string) (PaginatedBookList, error) {
+	if !filter.GroupEditions || len(list.Books) == 0 {
+		return list, nil
+	}
+	ids := make([]string, len(list.Books))
+	for i, book := range list.Books {
+		ids[i] = book.ID
+	}
+	editions, err := uc.repo.ListEditions(ctx, ids)
+	if err != nil {
+		return PaginatedBookList{}, fmt.Errorf("BookShelf - %s - s.repo.ListEditions: %w", method, err)
+	}
+	for id := range editions {
+		editions[id] = uc.visibleEditions(ctx, editions[id])
+	}
+	list.Editions = editions
+	return list, nil
+}
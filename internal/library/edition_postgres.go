@@ -0,0 +1,115 @@
+package library
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// LinkEdition adds bookID to the work of otherID, the work of bookID joins
+// it when the book was linked before. A book not linked yet starts a work
+// of its own id.
+func (bdr *BookDatabaseRepo) LinkEdition(ctx context.Context, bookID, otherID string, relation entity.EditionRelation) error {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT book_id, work_id FROM library_book_edition WHERE book_id = ANY($1)
+	`, []string{bookID, otherID})
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - LinkEdition - r.Pool.Query: %w", err)
+	}
+	works := make(map[string]string, 2)
+	for rows.Next() {
+		var id, work string
+		if err = rows.Scan(&id, &work); err != nil {
+			rows.Close()
+			return fmt.Errorf("BookDatabaseRepo - LinkEdition - rows.Scan: %w", err)
+		}
+		works[id] = work
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("BookDatabaseRepo - LinkEdition - rows.Err: %w", err)
+	}
+
+	work := otherID
+	if w, ok := works[otherID]; ok {
+		work = w
+	} else if w, ok := works[bookID]; ok {
+		work = w
+	}
+	// the other books of the old work of bookID move along
+	var merged *string
+	if w, ok := works[bookID]; ok && w != work {
+		merged = &w
+	}
+
+	_, err = bdr.Conn(ctx).Exec(ctx, `
+		WITH merged AS (
+			UPDATE library_book_edition SET work_id = $2
+			WHERE work_id = $4 AND book_id <> $1
+		)
+		INSERT INTO library_book_edition (book_id, work_id, relation)
+		VALUES ($1, $2, $3), ($5, $2, 'edition')
+		ON CONFLICT (book_id) DO UPDATE SET
+			work_id = EXCLUDED.work_id,
+			relation = CASE WHEN library_book_edition.book_id = $1 THEN EXCLUDED.relation ELSE library_book_edition.relation END
+	`, bookID, work, string(relation), merged, otherID)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - LinkEdition - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// UnlinkEdition takes a book out of its work, a work left with a single
+// book is dissolved.
+func (bdr *BookDatabaseRepo) UnlinkEdition(ctx context.Context, bookID string) error {
+	_, err := bdr.Conn(ctx).Exec(ctx, `
+		WITH gone AS (
+			DELETE FROM library_book_edition WHERE book_id = $1 RETURNING work_id
+		)
+		DELETE FROM library_book_edition e
+		USING gone
+		WHERE e.work_id = gone.work_id AND e.book_id <> $1
+			AND (SELECT count(*) FROM library_book_edition WHERE work_id = gone.work_id AND book_id <> $1) = 1
+	`, bookID)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - UnlinkEdition - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// ListEditions returns the other books of the work of each book, by
+// language and year.
+func (bdr *BookDatabaseRepo) ListEditions(ctx context.Context, bookIDs []string) (map[string][]entity.Edition, error) {
+	editions := make(map[string][]entity.Edition)
+	if len(bookIDs) == 0 {
+		return editions, nil
+	}
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT `+bookColumns+`, e.book_id, o.work_id, o.relation
+		FROM library_book_edition e
+		JOIN library_book_edition o ON o.work_id = e.work_id AND o.book_id <> e.book_id
+		JOIN library_book ON library_book.id = o.book_id
+		WHERE e.book_id = ANY($1)
+		ORDER BY COALESCE(library_book.language, ''), library_book.year, library_book.title
+	`, bookIDs)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListEditions - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var edition entity.Edition
+		var of, relation string
+		edition.Book, err = scanBook(extraRow{rows, []interface{}{&of, &edition.WorkID, &relation}})
+		if err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - ListEditions - rows.Scan: %w", err)
+		}
+		edition.Relation = entity.EditionRelation(relation)
+		editions[of] = append(editions[of], edition)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListEditions - rows.Err: %w", err)
+	}
+	return editions, nil
+}
@@ -0,0 +1,229 @@
+package library
+
+import (
+	"archive/zip"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+const (
+	// ManifestJSON writes one manifest.json for the whole export.
+	ManifestJSON = "json"
+	// ManifestOPF writes a Calibre metadata.opf next to every book.
+	ManifestOPF = "opf"
+)
+
+var ErrInvalidManifest = errors.New("manifest must be json or opf")
+
+// ExportManifest is manifest.json of a library export.
+type ExportManifest struct {
+	ExportedAt time.Time     `json:"exported_at"`
+	Books      []ExportEntry `json:"books"`
+}
+
+type ExportEntry struct {
+	ID          string  `json:"id"`
+	Title       string  `json:"title"`
+	Author      string  `json:"author,omitempty"`
+	Publisher   string  `json:"publisher,omitempty"`
+	Year        int     `json:"year,omitempty"`
+	ISBN        string  `json:"isbn,omitempty"`
+	DOI         string  `json:"doi,omitempty"`
+	Series      string  `json:"series,omitempty"`
+	SeriesIndex string  `json:"series_index,omitempty"`
+	Language    string  `json:"language,omitempty"`
+	Description string  `json:"description,omitempty"`
+	Rating      float64 `json:"rating,omitempty"`
+	DocumentID  string  `json:"document_id"`
+	File        string  `json:"file"`
+	Cover       string  `json:"cover,omitempty"`
+}
+
+// ExportBooks streams the books with their covers and metadata into a zip,
+// every book in a folder named by its id. Without ids the whole library is
+// exported. Books that are gone or that a download hook refuses are left
+// out, once writing started other errors only end the archive early.
+func (uc *BookShelf) ExportBooks(ctx context.Context, w io.Writer, bookIDs []string, manifest string) error {
+	if manifest != ManifestJSON && manifest != ManifestOPF {
+		return fmt.Errorf("BookShelf - ExportBooks - %w", ErrInvalidManifest)
+	}
+
+	zw := zip.NewWriter(w)
+	export := ExportManifest{ExportedAt: time.Now().UTC(), Books: make([]ExportEntry, 0)}
+	add := func(book entity.Book) error {
+		if err := uc.beforeDownload(ctx, book); err != nil {
+			uc.logger.Info("BookShelf - ExportBooks - %s left out: %s", book.ID, err)
+			return nil
+		}
+		entry, err := uc.exportBook(ctx, zw, book, manifest)
+		if err != nil {
+			return fmt.Errorf("book %s: %w", book.ID, err)
+		}
+		export.Books = append(export.Books, entry)
+		return nil
+	}
+
+	if len(bookIDs) > 0 {
+		for _, id := range bookIDs {
+			book, err := uc.getBook(ctx, id)
+			if errors.Is(err, entity.ErrBookNotFound) {
+				continue
+			}
+			if err != nil {
+				return fmt.Errorf("BookShelf - ExportBooks - s.getBook: %w", err)
+			}
+			if err = add(book); err != nil {
+				return fmt.Errorf("BookShelf - ExportBooks - %w", err)
+			}
+		}
+	} else if err := uc.forEachBook(ctx, add); err != nil {
+		return fmt.Errorf("BookShelf - ExportBooks - %w", err)
+	}
+
+	if manifest == ManifestJSON {
+		mw, err := zw.Create("manifest.json")
+		if err != nil {
+			return fmt.Errorf("BookShelf - ExportBooks - zw.Create: %w", err)
+		}
+		enc := json.NewEncoder(mw)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(export); err != nil {
+			return fmt.Errorf("BookShelf - ExportBooks - json.Encode: %w", err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("BookShelf - ExportBooks - zw.Close: %w", err)
+	}
+	return nil
+}
+
+func (uc *BookShelf) exportBook(ctx context.Context, zw *zip.Writer, book entity.Book, manifest string) (ExportEntry, error) {
+	entry := ExportEntry{
+		ID:          book.ID,
+		Title:       book.Title,
+		Author:      book.Author,
+		Publisher:   book.Publisher,
+		Year:        book.Year,
+		ISBN:        book.ISBN,
+		DOI:         book.DOI,
+		Series:      book.Series,
+		Language:    book.Language,
+		Description: book.Description,
+		Rating:      book.Rating,
+		DocumentID:  book.DocumentID,
+		File:        book.ID + "/" + exportName(book),
+	}
+	if book.SeriesIndex != nil && book.SeriesIndex.Valid {
+		entry.SeriesIndex = book.SeriesIndex.Decimal.String()
+	}
+
+	err := uc.exportFile(ctx, zw, book.FilePath, entry.File, book.UpdatedAt)
+	if err != nil {
+		return entry, err
+	}
+	if book.CoverPath != "" {
+		cover := book.ID + "/cover.jpg"
+		err = uc.exportFile(ctx, zw, book.CoverPath, cover, book.UpdatedAt)
+		if err != nil {
+			uc.logger.Warn("BookShelf - ExportBooks - cover of %s: %s", book.ID, err)
+		} else {
+			entry.Cover = cover
+		}
+	}
+
+	if manifest == ManifestOPF {
+		ow, err := zw.CreateHeader(&zip.FileHeader{Name: book.ID + "/metadata.opf", Method: zip.Deflate, Modified: book.UpdatedAt})
+		if err != nil {
+			return entry, err
+		}
+		if _, err = io.WriteString(ow, bookOPF(entry)); err != nil {
+			return entry, err
+		}
+	}
+	return entry, nil
+}
+
+func (uc *BookShelf) exportFile(ctx context.Context, zw *zip.Writer, storagePath, name string, modified time.Time) error {
+	file, err := uc.storage.Open(ctx, storagePath)
+	if err != nil {
+		return fmt.Errorf("s.storage.Open: %w", err)
+	}
+	defer file.Close()
+
+	// books and covers are compressed already, they are stored as is
+	fw, err := zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Store, Modified: modified})
+	if err != nil {
+		return fmt.Errorf("zw.CreateHeader: %w", err)
+	}
+	_, err = file.WriteTo(fw)
+	return err
+}
+
+// exportName is the book filename without path separators.
+func exportName(book entity.Book) string {
+	return strings.NewReplacer("/", "_", "\\", "_").Replace(book.Filename())
+}
+
+// bookOPF is the metadata.opf calibre reads when adding a book folder.
+func bookOPF(entry ExportEntry) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="utf-8"?>` + "\n")
+	b.WriteString(`<package xmlns="http://www.idpf.org/2007/opf" unique-identifier="uuid_id" version="2.0">` + "\n")
+	b.WriteString(`  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:opf="http://www.idpf.org/2007/opf">` + "\n")
+	opfTag(&b, `dc:identifier opf:scheme="uuid" id="uuid_id"`, entry.ID)
+	opfTag(&b, `dc:identifier opf:scheme="ISBN"`, entry.ISBN)
+	opfTag(&b, `dc:identifier opf:scheme="DOI"`, entry.DOI)
+	opfTag(&b, "dc:title", entry.Title)
+	opfTag(&b, `dc:creator opf:role="aut"`, entry.Author)
+	opfTag(&b, "dc:publisher", entry.Publisher)
+	if entry.Year > 0 {
+		opfTag(&b, "dc:date", fmt.Sprintf("%04d-01-01T00:00:00+00:00", entry.Year))
+	}
+	opfTag(&b, "dc:description", entry.Description)
+	opfTag(&b, "dc:language", entry.Language)
+	opfMeta(&b, "calibre:series", entry.Series)
+	if entry.Series != "" {
+		opfMeta(&b, "calibre:series_index", entry.SeriesIndex)
+	}
+	if entry.Rating > 0 {
+		// calibre rates 0-10, half stars included
+		opfMeta(&b, "calibre:rating", strconv.FormatFloat(entry.Rating*2, 'f', 0, 64))
+	}
+	b.WriteString("  </metadata>\n")
+	if entry.Cover != "" {
+		b.WriteString(`  <guide>` + "\n")
+		b.WriteString(`    <reference type="cover" title="Cover" href="cover.jpg"/>` + "\n")
+		b.WriteString(`  </guide>` + "\n")
+	}
+	b.WriteString("</package>\n")
+	return b.String()
+}
+
+// opfTag writes <tag attrs>value</tag>, empty values are left out.
+func opfTag(b *strings.Builder, tag, value string) {
+	if value == "" {
+		return
+	}
+	name, _, _ := strings.Cut(tag, " ")
+	b.WriteString("    <" + tag + ">")
+	_ = xml.EscapeText(b, []byte(value))
+	b.WriteString("</" + name + ">\n")
+}
+
+func opfMeta(b *strings.Builder, name, content string) {
+	if content == "" {
+		return
+	}
+	b.WriteString(`    <meta name="` + name + `" content="`)
+	_ = xml.EscapeText(b, []byte(content))
+	b.WriteString("\"/>\n")
+}
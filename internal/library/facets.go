@@ -0,0 +1,59 @@
+package library
+
+import (
+	"context"
+	"fmt"
+)
+
+// facetLimit is the most values returned per facet, the most common ones.
+const facetLimit = 20
+
+// FacetCount is one value of a facet and the number of matching books
+// having it.
+type FacetCount struct {
+	Value string
+	Count int
+}
+
+// BookFacets counts the books matching a listing by author, format, genre
+// and decade, for filter sidebars. Each facet is sorted by count, at most
+// facetLimit values.
+type BookFacets struct {
+	Authors []FacetCount
+	Formats []FacetCount
+	Genres  []FacetCount
+	Decades []FacetCount
+}
+
+func (f *BookFacets) add(facet, value string, count int) {
+	var values *[]FacetCount
+	switch facet {
+	case "author":
+		values = &f.Authors
+	case "format":
+		values = &f.Formats
+	case "genre":
+		values = &f.Genres
+	case "decade":
+		values = &f.Decades
+	default:
+		return
+	}
+	if len(*values) < facetLimit {
+		*values = append(*values, FacetCount{Value: value, Count: count})
+	}
+}
+
+// withFacets adds the facet counts of the listing when the filter asks for
+// them.
+func (uc *BookShelf) withFacets(ctx context.Context, query string, filter BookFilter, list PaginatedBookList, method string) (PaginatedBookList, error) {
+	if !filter.Facets {
+		return list, nil
+	}
+	facets, err := uc.repo.Facets(ctx, query, filter)
+	if err != nil {
+		return PaginatedBookList{}, fmt.Errorf("BookShelf - %s - s.repo.Facets: %w", method, err)
+	}
+	list.Facets = &facets
+	return list, nil
+}
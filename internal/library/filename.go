@@ -0,0 +1,132 @@
+package library
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// DefaultFilenamePatterns are tried in order on the name of an upload
+// whose file has no title or author.
+var DefaultFilenamePatterns = []string{
+	"{author} - {title} ({year})",
+	"{author} - {title}",
+	"{title} ({year})",
+	"{title}",
+}
+
+var filenameFields = map[string]string{
+	"title":  `(.+?)`,
+	"author": `(.+?)`,
+	"year":   `(\d{4})`,
+}
+
+// FilenamePattern reads book metadata from an upload's file name, e.g.
+// "{author} - {title} ({year})" matches "Ursula K. Le Guin - The
+// Dispossessed (1974).epub".
+type FilenamePattern struct {
+	re     *regexp.Regexp
+	fields []string
+}
+
+// ParseFilenamePatterns compiles patterns, DefaultFilenamePatterns when
+// there are none. Placeholders are {title}, {author} and {year}, separated
+// by some text; spaces match any run of spaces and the extension of the
+// file name is left out.
+func ParseFilenamePatterns(patterns []string) ([]FilenamePattern, error) {
+	if len(patterns) == 0 {
+		patterns = DefaultFilenamePatterns
+	}
+	parsed := make([]FilenamePattern, 0, len(patterns))
+	for _, pattern := range patterns {
+		var expr strings.Builder
+		var fields []string
+		expr.WriteString(`^\s*`)
+		last := 0
+		for _, loc := range placeholderRe.FindAllStringSubmatchIndex(pattern, -1) {
+			name := pattern[loc[2]:loc[3]]
+			field, ok := filenameFields[name]
+			if !ok {
+				return nil, fmt.Errorf("filename pattern %q has an unknown placeholder {%s}", pattern, name)
+			}
+			expr.WriteString(filenameLiteral(pattern[last:loc[0]]))
+			expr.WriteString(field)
+			fields = append(fields, name)
+			last = loc[1]
+		}
+		expr.WriteString(filenameLiteral(pattern[last:]))
+		expr.WriteString(`\s*$`)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("filename pattern %q has no placeholder", pattern)
+		}
+		re, err := regexp.Compile(expr.String())
+		if err != nil {
+			return nil, fmt.Errorf("filename pattern %q: %w", pattern, err)
+		}
+		parsed = append(parsed, FilenamePattern{re: re, fields: fields})
+	}
+	return parsed, nil
+}
+
+// filenameLiteral quotes the text between placeholders, a space matches
+// any run of spaces.
+func filenameLiteral(literal string) string {
+	parts := strings.Split(literal, " ")
+	for i, part := range parts {
+		parts[i] = regexp.QuoteMeta(part)
+	}
+	return strings.Join(parts, `\s*`)
+}
+
+// match returns the values of the placeholders in name.
+func (p FilenamePattern) match(name string) (map[string]string, bool) {
+	groups := p.re.FindStringSubmatch(name)
+	if groups == nil {
+		return nil, false
+	}
+	values := make(map[string]string, len(p.fields))
+	for i, field := range p.fields {
+		values[field] = strings.TrimSpace(groups[i+1])
+	}
+	return values, true
+}
+
+// SetFilenamePatterns replaces the DefaultFilenamePatterns.
+func (uc *BookShelf) SetFilenamePatterns(patterns []FilenamePattern) {
+	uc.filenamePatterns = patterns
+}
+
+// fillFromFilename fills the title, author and year the file did not have
+// from the uploaded file name, with the first pattern that matches. Books
+// with a title and an author are left as they are.
+func (uc *BookShelf) fillFromFilename(book *entity.Book, date, filename string) {
+	if book.Title != "" && book.Author != "" {
+		return
+	}
+	name := path.Base(strings.ReplaceAll(filename, `\`, "/"))
+	name = strings.TrimSuffix(name, path.Ext(name))
+	name = strings.ReplaceAll(name, "_", " ")
+	if strings.TrimSpace(name) == "" {
+		return
+	}
+	for _, pattern := range uc.filenamePatterns {
+		values, ok := pattern.match(name)
+		if !ok {
+			continue
+		}
+		if book.Title == "" {
+			book.Title = values["title"]
+		}
+		if book.Author == "" {
+			book.Author = values["author"]
+		}
+		if year, _ := strconv.Atoi(values["year"]); book.Year == 0 && metadataYear(date) == 0 {
+			book.Year = year
+		}
+		return
+	}
+}
@@ -0,0 +1,76 @@
+package library_test
+
+import (
+	"context"
+	"os"
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func storeUntitledBook(t *testing.T, shelf *library.BookShelf, filename, body string) entity.Book {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	_, err = file.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info></title-info></description><body><p>` + body + `</p></body></FictionBook>`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file.Seek(0, 0)
+
+	book, err := shelf.StoreBook(context.Background(), file, filename)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return book
+}
+
+func TestStoreBookReadsMetadataFromFilename(t *testing.T) {
+	repo := &uniqueBookRepo{books: make(map[string]entity.Book)}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+
+	for i, tc := range []struct {
+		filename, title, author string
+		year                    int
+	}{
+		{"Ursula K. Le Guin - The Dispossessed (1974).fb2", "The Dispossessed", "Ursula K. Le Guin", 1974},
+		{`C:\Books\Stanislaw_Lem_-_Solaris.fb2`, "Solaris", "Stanislaw Lem", 0},
+		{"Roadside Picnic (1972).fb2", "Roadside Picnic", "", 1972},
+		{"notes.fb2", "notes", "", 0},
+	} {
+		book := storeUntitledBook(t, shelf, tc.filename, string(rune('a'+i)))
+		if book.Title != tc.title || book.Author != tc.author || book.Year != tc.year {
+			t.Fatalf("expected %q by %q (%d) from %q, got %q by %q (%d)",
+				tc.title, tc.author, tc.year, tc.filename, book.Title, book.Author, book.Year)
+		}
+	}
+}
+
+func TestSetFilenamePatterns(t *testing.T) {
+	repo := &uniqueBookRepo{books: make(map[string]entity.Book)}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	patterns, err := library.ParseFilenamePatterns([]string{"{year} - {author} - {title}"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	shelf.SetFilenamePatterns(patterns)
+
+	book := storeUntitledBook(t, shelf, "1961 - Stanislaw Lem - Solaris.fb2", "text")
+	if book.Title != "Solaris" || book.Author != "Stanislaw Lem" || book.Year != 1961 {
+		t.Fatalf("unexpected book from the configured pattern: %+v", book)
+	}
+
+	if _, err = library.ParseFilenamePatterns([]string{"{author} - {isbn}"}); err == nil {
+		t.Fatalf("expected an unknown placeholder to be rejected")
+	}
+	if _, err = library.ParseFilenamePatterns([]string{"no placeholders"}); err == nil {
+		t.Fatalf("expected a pattern without placeholders to be rejected")
+	}
+}
@@ -0,0 +1,96 @@
+package library
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/isbn"
+	"github.com/banjuer/kompanion/pkg/metadata"
+)
+
+// ErrInvalidISBN - an ISBN typed in by a user has a wrong length or check
+// digit.
+var ErrInvalidISBN = errors.New("isbn must be a valid ISBN-10 or ISBN-13")
+
+// BookFilter narrows listing and search results, the zero value matches every book.
+type BookFilter struct {
+	Language string
+	// MediaType keeps only books or only audiobooks.
+	MediaType string
+	// CoverPath keeps books sharing one stored cover.
+	CoverPath string
+	// FilePath keeps the book stored at one path.
+	FilePath string
+
+	// Status keeps books the user has put on that shelf, it needs Username.
+	Username string
+	Status   entity.ReadingStatus
+
+	// GroupEditions lists a work once, as its oldest book matching the
+	// filter, see BookShelf.LinkEdition.
+	GroupEditions bool
+
+	// Restricted hides the books accounts other than Reader keep private,
+	// an empty Reader sees only shared books. See WithReader.
+	Reader     string
+	Restricted bool
+	// HideScheduled hides books before their visible from date, only
+	// admins see them early.
+	HideScheduled bool
+
+	// Facets counts all matching books by author, format, genre and
+	// decade into the list, see BookFacets.
+	Facets bool
+}
+
+// NewBookFilter builds a filter from user input, language accepts tags like "en-US" or "eng".
+func NewBookFilter(language string) BookFilter {
+	return BookFilter{Language: normalizeLanguage(language)}
+}
+
+// WithStatus limits the filter to one reading status of a user, an
+// unknown status is ignored.
+func (f BookFilter) WithStatus(username, status string) BookFilter {
+	parsed, err := entity.ParseReadingStatus(status)
+	if err != nil || username == "" {
+		return f
+	}
+	f.Username, f.Status = username, parsed
+	return f
+}
+
+// WithMediaType limits the filter to books or audiobooks, other values
+// are ignored.
+func (f BookFilter) WithMediaType(mediaType string) BookFilter {
+	if mediaType == entity.MediaTypeBook || mediaType == entity.MediaTypeAudiobook {
+		f.MediaType = mediaType
+	}
+	return f
+}
+
+func normalizeLanguage(tag string) string {
+	return metadata.NormalizeLanguage(tag)
+}
+
+func normalizeDOI(doi string) string {
+	return metadata.NormalizeDOI(doi)
+}
+
+// normalizeISBN writes valid ISBNs as ISBN-13 without hyphens, others are
+// kept as they are, files and providers hold all kinds of identifiers.
+func normalizeISBN(value string) string {
+	if normalized, err := isbn.Normalize(value); err == nil {
+		return normalized
+	}
+	return strings.TrimSpace(value)
+}
+
+// checkISBN refuses an invalid ISBN replacing the one of a book. The
+// book's own, even invalid, may be sent back unchanged.
+func checkISBN(current, value string) error {
+	if value == "" || isbn.Clean(value) == isbn.Clean(current) || isbn.Valid(value) {
+		return nil
+	}
+	return ErrInvalidISBN
+}
@@ -0,0 +1,210 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/metadata"
+	"github.com/banjuer/kompanion/pkg/tracing"
+	"github.com/banjuer/kompanion/pkg/utils"
+)
+
+var (
+	ErrFormatExists = errors.New("book already has a file of this format")
+	ErrPrimaryFile  = errors.New("the file the book was uploaded as can not be removed")
+)
+
+// withFile returns book as the file of another format, so file names, mime
+// types and ETags follow the format.
+func withFile(book entity.Book, file entity.BookFile) entity.Book {
+	book.FilePath = file.FilePath
+	book.DocumentID = file.DocumentID
+	book.FileSize = file.FileSize
+	book.Format = file.Format
+	return book
+}
+
+// BookFiles lists the formats of a book, the file it was uploaded as first.
+func (uc *BookShelf) BookFiles(ctx context.Context, bookID string) ([]entity.BookFile, error) {
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - BookFiles - s.getBook: %w", err)
+	}
+	files, err := uc.repo.ListFiles(ctx, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - BookFiles - s.repo.ListFiles: %w", err)
+	}
+	primary := entity.BookFile{
+		BookID:     book.ID,
+		Format:     strings.ToLower(book.Extension()),
+		FilePath:   book.FilePath,
+		DocumentID: book.DocumentID,
+		FileSize:   book.FileSize,
+		CreatedAt:  book.CreatedAt,
+	}
+	return append([]entity.BookFile{primary}, files...), nil
+}
+
+// BookFormat returns the book as its file of format, or as the file it was
+// uploaded as when there is none.
+func (uc *BookShelf) BookFormat(ctx context.Context, bookID, format string) (entity.Book, error) {
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return book, fmt.Errorf("BookShelf - BookFormat - s.getBook: %w", err)
+	}
+	format = strings.ToLower(format)
+	if format == "" || format == strings.ToLower(book.Extension()) {
+		return book, nil
+	}
+	files, err := uc.repo.ListFiles(ctx, bookID)
+	if err != nil {
+		return book, fmt.Errorf("BookShelf - BookFormat - s.repo.ListFiles: %w", err)
+	}
+	for _, file := range files {
+		if file.Format == format {
+			return withFile(book, file), nil
+		}
+	}
+	return book, nil
+}
+
+// AddBookFile stores another format of a book, tempFile is a temp file the
+// caller deletes afterwards. A file already in the library is
+// entity.ErrBookAlreadyExists, a second file of one format ErrFormatExists.
+func (uc *BookShelf) AddBookFile(ctx context.Context, bookID string, tempFile *os.File) (entity.BookFile, error) {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - %w", err)
+	}
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - s.getBook: %w", err)
+	}
+	if book.Archived() {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - %w", entity.ErrBookArchived)
+	}
+
+	if err = uc.checkUploadSize(tempFile); err != nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - %w", err)
+	}
+	documentID, err := utils.PartialMD5(tempFile.Name())
+	if err != nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - PartialMD5: %w", err)
+	}
+	unlock, err := uc.lockIngest(ctx, &uc.ingest, "ingest:"+documentID)
+	if err != nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - %w", err)
+	}
+	defer unlock()
+	if _, err = uc.repo.GetByFileHash(ctx, documentID); err == nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - %w", entity.ErrBookAlreadyExists)
+	}
+
+	m, err := metadata.ExtractBookMetadata(tempFile)
+	if err != nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - exractMetadata: %w", err)
+	}
+	if m.Format == "" {
+		return entity.BookFile{}, errors.New("BookShelf - AddBookFile - unknown file format")
+	}
+	if err = uc.checkUploadFormat(m.Format); err != nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - %w", err)
+	}
+	// formats count for the account that uploaded the book
+	if err = uc.checkQuota(ctx, book.UploadedBy, m.Size); err != nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - %w", err)
+	}
+	files, err := uc.BookFiles(ctx, bookID)
+	if err != nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - %w", err)
+	}
+	for _, file := range files {
+		if file.Format == m.Format {
+			return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - %s: %w", m.Format, ErrFormatExists)
+		}
+	}
+
+	if _, err = tempFile.Seek(0, io.SeekStart); err != nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - tempFile.Seek: %w", err)
+	}
+	pathBook := book
+	pathBook.Format = m.Format
+	filePath, err := uc.putBookFile(ctx, pathBook, storage.TempFile{File: tempFile})
+	if err != nil {
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - s.storage.Put: %w", err)
+	}
+
+	file := entity.BookFile{
+		BookID:     book.ID,
+		Format:     m.Format,
+		FilePath:   filePath,
+		DocumentID: documentID,
+		FileSize:   m.Size,
+		CreatedAt:  time.Now(),
+	}
+	if err = uc.repo.StoreFile(ctx, file); err != nil {
+		if deleteErr := uc.storage.Delete(ctx, filePath); deleteErr != nil {
+			uc.logger.Warn("BookShelf - AddBookFile - failed to delete book file: %s", deleteErr)
+		}
+		return entity.BookFile{}, fmt.Errorf("BookShelf - AddBookFile - s.repo.StoreFile: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	return file, nil
+}
+
+// DeleteBookFile removes a format added to a book.
+func (uc *BookShelf) DeleteBookFile(ctx context.Context, bookID, format string) error {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return fmt.Errorf("BookShelf - DeleteBookFile - %w", err)
+	}
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return fmt.Errorf("BookShelf - DeleteBookFile - s.getBook: %w", err)
+	}
+	if book.Archived() {
+		return fmt.Errorf("BookShelf - DeleteBookFile - %w", entity.ErrBookArchived)
+	}
+	format = strings.ToLower(format)
+	if format == strings.ToLower(book.Extension()) {
+		return fmt.Errorf("BookShelf - DeleteBookFile - %w", ErrPrimaryFile)
+	}
+
+	formatBook, err := uc.BookFormat(ctx, bookID, format)
+	if err != nil {
+		return fmt.Errorf("BookShelf - DeleteBookFile - %w", err)
+	}
+	if err = uc.repo.DeleteFile(ctx, bookID, format); err != nil {
+		return fmt.Errorf("BookShelf - DeleteBookFile - s.repo.DeleteFile: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	if err = uc.storage.Delete(ctx, formatBook.FilePath); err != nil {
+		uc.logger.Warn("BookShelf - DeleteBookFile - failed to delete book file: %s", err)
+	}
+	return nil
+}
+
+// DownloadBook opens the book file of the preferred format, the file the
+// book was uploaded as when format is empty or the book has no such file.
+func (uc *BookShelf) DownloadBook(ctx context.Context, bookID, format string) (entity.Book, storage.File, error) {
+	ctx, span := tracing.Start(ctx, "BookShelf.DownloadBook")
+	defer span.End()
+	book, err := uc.BookFormat(ctx, bookID, format)
+	if err != nil {
+		return book, nil, fmt.Errorf("BookShelf - DownloadBook - %s", err)
+	}
+	if err = uc.beforeDownload(ctx, book); err != nil {
+		return book, nil, fmt.Errorf("BookShelf - DownloadBook - %w", err)
+	}
+	file, err := uc.storage.Open(ctx, book.FilePath)
+	if err != nil {
+		return book, nil, fmt.Errorf("BookShelf - DownloadBook - s.storage.Open: %s", err)
+	}
+	uc.metrics.BookDownloaded(book.Extension())
+	return book, file, nil
+}
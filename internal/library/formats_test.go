@@ -0,0 +1,101 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+// newFileRepo finds no book by file hash, so every file is new.
+type newFileRepo struct {
+	fakeBookRepo
+}
+
+func (r *newFileRepo) GetByFileHash(context.Context, string) (entity.Book, error) {
+	return entity.Book{}, entity.ErrBookNotFound
+}
+
+func TestBookFormats(t *testing.T) {
+	ctx := context.Background()
+	bookStorage := storage.NewMemoryStorage()
+	writeStorageFile(t, bookStorage, "2024/01/01/book-id.epub", "epub content")
+	repo := &newFileRepo{fakeBookRepo{book: entity.Book{
+		ID:         "book-id",
+		Title:      "Book",
+		FilePath:   "2024/01/01/book-id.epub",
+		DocumentID: "epub-hash",
+		CreatedAt:  time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}}}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+
+	fb2 := `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>Book</book-title></title-info></description><body><p>text</p></body></FictionBook>`
+	upload := func() *os.File {
+		file, err := os.CreateTemp(t.TempDir(), "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { file.Close() })
+		if _, err = file.WriteString(fb2); err != nil {
+			t.Fatal(err)
+		}
+		file.Seek(0, 0)
+		return file
+	}
+
+	added, err := shelf.AddBookFile(ctx, "book-id", upload())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if added.Format != "fb2" || added.FilePath != "2024/01/01/book-id.fb2" {
+		t.Fatalf("unexpected file %+v", added)
+	}
+	if _, err = shelf.AddBookFile(ctx, "book-id", upload()); !errors.Is(err, library.ErrFormatExists) {
+		t.Fatalf("expected ErrFormatExists for a second fb2, got %v", err)
+	}
+
+	files, err := shelf.BookFiles(ctx, "book-id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(files) != 2 || files[0].Format != "epub" || files[1].Format != "fb2" {
+		t.Fatalf("expected the epub before the fb2, got %+v", files)
+	}
+
+	book, file, err := shelf.DownloadBook(ctx, "book-id", "FB2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	content, _ := io.ReadAll(file)
+	file.Close()
+	if string(content) != fb2 || book.ETag() != `"`+added.DocumentID+`"` || !strings.HasSuffix(book.Filename(), ".fb2") {
+		t.Fatalf("expected the fb2 file, got %q as %s", content, book.Filename())
+	}
+	book, file, err = shelf.DownloadBook(ctx, "book-id", "pdf")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	file.Close()
+	if book.DocumentID != "epub-hash" {
+		t.Fatalf("expected the uploaded file without a pdf, got %+v", book)
+	}
+
+	if err = shelf.DeleteBookFile(ctx, "book-id", "epub"); !errors.Is(err, library.ErrPrimaryFile) {
+		t.Fatalf("expected ErrPrimaryFile, got %v", err)
+	}
+	if err = shelf.DeleteBookFile(ctx, "book-id", "fb2"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = bookStorage.Open(ctx, added.FilePath); !errors.Is(err, storage.ErrNotFound) {
+		t.Fatalf("expected the fb2 file to be deleted, got %v", err)
+	}
+}
@@ -0,0 +1,324 @@
+package library
+
+import (
+	"context"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+var ErrInvalidHistoryImport = errors.New("reading history must be a Goodreads or StoryGraph CSV export")
+
+// historyRow is one book of a reading history export.
+type historyRow struct {
+	row        int // counted from 1 without the header
+	title      string
+	author     string
+	isbns      []string
+	status     entity.ReadingStatus
+	rating     int
+	review     string
+	finishedAt *time.Time
+}
+
+// historyFormat maps the columns of an export to a history row.
+type historyFormat struct {
+	title    string
+	author   string
+	isbns    []string
+	status   string
+	rating   string
+	review   string
+	finished string
+	// statuses maps shelves to reading statuses, others are left alone
+	statuses map[string]entity.ReadingStatus
+}
+
+var historyFormats = []historyFormat{
+	{
+		title:    "title",
+		author:   "author",
+		isbns:    []string{"isbn13", "isbn"},
+		status:   "exclusive shelf",
+		rating:   "my rating",
+		review:   "my review",
+		finished: "date read",
+		statuses: map[string]entity.ReadingStatus{
+			"read":              entity.StatusFinished,
+			"currently-reading": entity.StatusReading,
+			"to-read":           entity.StatusToRead,
+		},
+	},
+	{
+		title:    "title",
+		author:   "authors",
+		isbns:    []string{"isbn/uid"},
+		status:   "read status",
+		rating:   "star rating",
+		review:   "review",
+		finished: "last date read",
+		statuses: map[string]entity.ReadingStatus{
+			"read":              entity.StatusFinished,
+			"currently-reading": entity.StatusReading,
+			"to-read":           entity.StatusToRead,
+		},
+	},
+}
+
+// goodreadsSeries is the series Goodreads appends to titles, as in
+// "The Hunger Games (The Hunger Games, #1)".
+var goodreadsSeries = regexp.MustCompile(`\s*\([^()]*#[\d.]+\)$`)
+
+// ImportReadingHistory reads a Goodreads or StoryGraph CSV export and sets
+// the reading status, rating, review and finish date of the user for the
+// books of the library it finds, by ISBN or else by title and author.
+// Values in the export win, empty ones leave the user's as they are. Rows
+// without a single matching book are reported as problems. Finish hooks
+// do not run, the books were read long ago.
+func (uc *BookShelf) ImportReadingHistory(ctx context.Context, username string, r io.Reader) (MaintenanceReport, error) {
+	rows, err := readHistoryCSV(r)
+	if err != nil {
+		return MaintenanceReport{}, fmt.Errorf("BookShelf - ImportReadingHistory - %w", err)
+	}
+
+	var report MaintenanceReport
+	for _, row := range rows {
+		report.Books++
+		book, problem, err := uc.historyBook(ctx, row)
+		if err != nil {
+			return MaintenanceReport{}, fmt.Errorf("BookShelf - ImportReadingHistory - %w", err)
+		}
+		if problem != "" {
+			report.problem(entity.Book{Title: row.title}, "row %d: %s", row.row, problem)
+			continue
+		}
+		// the status and the review of a row are saved together or not at all
+		var changed bool
+		err = uc.repo.WithTx(ctx, func(ctx context.Context) (err error) {
+			changed, err = uc.importHistoryRow(ctx, username, book.ID, row)
+			return err
+		})
+		if err != nil {
+			return MaintenanceReport{}, fmt.Errorf("BookShelf - ImportReadingHistory - %w", err)
+		}
+		if changed {
+			report.Changed++
+		}
+	}
+	if report.Changed > 0 {
+		uc.libraryChanged(ctx)
+	}
+	return report, nil
+}
+
+// historyBook finds the book of a row, a problem when there is none or
+// more than one.
+func (uc *BookShelf) historyBook(ctx context.Context, row historyRow) (entity.Book, string, error) {
+	canSee := uc.canSee(ctx)
+	for _, isbn := range row.isbns {
+		books, err := uc.repo.ListByISBN(ctx, isbn)
+		if err != nil {
+			return entity.Book{}, "", fmt.Errorf("s.repo.ListByISBN: %w", err)
+		}
+		books = slices.DeleteFunc(books, func(book entity.Book) bool { return !canSee(book) })
+		switch len(books) {
+		case 0:
+			continue
+		case 1:
+			return books[0], "", nil
+		}
+		return entity.Book{}, fmt.Sprintf("%d books with isbn %s", len(books), isbn), nil
+	}
+
+	title := strings.ReplaceAll(row.title, `"`, "")
+	if strings.TrimSpace(title) == "" {
+		return entity.Book{}, "neither isbn nor title", nil
+	}
+	candidates, err := uc.repo.Search(ctx, `title:"`+title+`"`, BookFilter{}.forReader(ctx), "", "", 1, 20)
+	if err != nil {
+		return entity.Book{}, "", fmt.Errorf("s.repo.Search: %w", err)
+	}
+	var books []entity.Book
+	for _, book := range candidates {
+		if sameTitle(book.Title, title) && sameAuthor(book.Author, row.author) {
+			books = append(books, book)
+		}
+	}
+	switch len(books) {
+	case 0:
+		return entity.Book{}, "no book with this title in the library", nil
+	case 1:
+		return books[0], "", nil
+	}
+	return entity.Book{}, fmt.Sprintf("%d books with this title", len(books)), nil
+}
+
+// importHistoryRow saves the status and review of a row, false when the
+// user already had them.
+func (uc *BookShelf) importHistoryRow(ctx context.Context, username, bookID string, row historyRow) (bool, error) {
+	changed := false
+	if row.status != "" {
+		current, err := uc.repo.GetReadingStatus(ctx, username, bookID)
+		if err != nil {
+			return false, fmt.Errorf("s.repo.GetReadingStatus: %w", err)
+		}
+		status := entity.BookStatus{Username: username, BookID: bookID, Status: row.status, UpdatedAt: time.Now()}
+		if row.status == entity.StatusFinished {
+			status.FinishedAt = row.finishedAt
+			if status.FinishedAt == nil && current.Status == entity.StatusFinished {
+				status.FinishedAt = current.FinishedAt
+			}
+		}
+		if current.Status != status.Status || !sameTime(current.FinishedAt, status.FinishedAt) {
+			if err = uc.repo.SetReadingStatus(ctx, status); err != nil {
+				return false, fmt.Errorf("s.repo.SetReadingStatus: %w", err)
+			}
+			changed = true
+		}
+	}
+
+	if row.rating == 0 && row.review == "" {
+		return changed, nil
+	}
+	review, err := uc.repo.GetReview(ctx, username, bookID)
+	if err != nil {
+		return false, fmt.Errorf("s.repo.GetReview: %w", err)
+	}
+	updated := review
+	if row.rating > 0 {
+		updated.Rating = row.rating
+	}
+	if row.review != "" {
+		updated.Text = row.review
+	}
+	if updated.Rating == review.Rating && updated.Text == review.Text {
+		return changed, nil
+	}
+	now := time.Now()
+	if updated.CreatedAt.IsZero() {
+		updated.CreatedAt = now
+	}
+	updated.Username, updated.BookID, updated.UpdatedAt = username, bookID, now
+	if err = uc.repo.SaveReview(ctx, updated); err != nil {
+		return false, fmt.Errorf("s.repo.SaveReview: %w", err)
+	}
+	return true, nil
+}
+
+// sameTitle compares titles ignoring case, accents and punctuation.
+func sameTitle(a, b string) bool {
+	if keyPart(a) == "" || keyPart(b) == "" {
+		return strings.EqualFold(strings.TrimSpace(a), strings.TrimSpace(b))
+	}
+	return keyPart(a) == keyPart(b)
+}
+
+// sameAuthor reports whether the surname of the first author of the export
+// is in the author of the book. Books without an author match any.
+func sameAuthor(bookAuthor, author string) bool {
+	authors := splitAuthors(author)
+	if bookAuthor == "" || len(authors) == 0 {
+		return true
+	}
+	surname := authors[0]
+	if before, _, found := strings.Cut(surname, ","); found {
+		surname = before
+	} else if fields := strings.Fields(surname); len(fields) > 0 {
+		surname = fields[len(fields)-1]
+	}
+	return keyPart(surname) == "" || strings.Contains(keyPart(bookAuthor), keyPart(surname))
+}
+
+func sameTime(a, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+// readHistoryCSV reads the rows of an export, the format is told by its
+// header.
+func readHistoryCSV(r io.Reader) ([]historyRow, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	cr.LazyQuotes = true
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidHistoryImport, err)
+	}
+	column := make(map[string]int, len(header))
+	for i, name := range header {
+		column[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	var format *historyFormat
+	for i := range historyFormats {
+		_, hasTitle := column[historyFormats[i].title]
+		_, hasStatus := column[historyFormats[i].status]
+		if hasTitle && hasStatus {
+			format = &historyFormats[i]
+			break
+		}
+	}
+	if format == nil {
+		return nil, ErrInvalidHistoryImport
+	}
+
+	var rows []historyRow
+	for n := 1; ; n++ {
+		fields, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return rows, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidHistoryImport, err)
+		}
+		if len(rows) >= metadataImportMax {
+			return nil, fmt.Errorf("%w: more than %d rows", ErrInvalidHistoryImport, metadataImportMax)
+		}
+		value := func(name string) string {
+			if i, ok := column[name]; ok && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+		row := historyRow{
+			row:        n,
+			title:      goodreadsSeries.ReplaceAllString(value(format.title), ""),
+			author:     value(format.author),
+			status:     format.statuses[strings.ToLower(value(format.status))],
+			review:     value(format.review),
+			finishedAt: parseHistoryDate(value(format.finished)),
+		}
+		for _, name := range format.isbns {
+			// Goodreads writes ="0439023483" so spreadsheets keep the zeros
+			isbn := strings.Trim(value(name), `="`)
+			if isbn != "" {
+				row.isbns = append(row.isbns, isbn)
+			}
+		}
+		if rating, err := strconv.ParseFloat(value(format.rating), 64); err == nil && rating > 0 && rating <= 5 {
+			row.rating = int(math.Round(rating))
+		}
+		rows = append(rows, row)
+	}
+}
+
+// parseHistoryDate reads 2019/08/14 as both sites write it, nil when the
+// date is missing or unknown.
+func parseHistoryDate(s string) *time.Time {
+	for _, layout := range []string{"2006/01/02", "2006-01-02"} {
+		if t, err := time.Parse(layout, s); err == nil {
+			return &t
+		}
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+var ErrDownloadRefused = errors.New("download refused")
+
+// AddIngestHook tells hook about every book stored from now on.
+func (uc *BookShelf) AddIngestHook(hook IngestHook) {
+	uc.ingestHooks = append(uc.ingestHooks, hook)
+}
+
+// AddFinishHook tells hook about every book marked finished from now on.
+func (uc *BookShelf) AddFinishHook(hook FinishHook) {
+	uc.finishHooks = append(uc.finishHooks, hook)
+}
+
+// AddDeleteHook tells hook about every book deleted from now on.
+func (uc *BookShelf) AddDeleteHook(hook DeleteHook) {
+	uc.deleteHooks = append(uc.deleteHooks, hook)
+}
+
+// AddDownloadHook asks hook before every download of a book file.
+func (uc *BookShelf) AddDownloadHook(hook DownloadHook) {
+	uc.downloadHooks = append(uc.downloadHooks, hook)
+}
+
+// bookIngested runs the ingest hooks, their errors do not undo the upload.
+func (uc *BookShelf) bookIngested(ctx context.Context, book entity.Book) {
+	for _, hook := range uc.ingestHooks {
+		if err := hook.BookIngested(ctx, book); err != nil {
+			uc.logger.Error("BookShelf - bookIngested - %s: %s", book.ID, err)
+		}
+	}
+}
+
+// bookFinished runs the finish hooks, their errors are logged.
+func (uc *BookShelf) bookFinished(ctx context.Context, username string, book entity.Book) {
+	for _, hook := range uc.finishHooks {
+		if err := hook.BookFinished(ctx, username, book); err != nil {
+			uc.logger.Error("BookShelf - bookFinished - %s: %s", book.ID, err)
+		}
+	}
+}
+
+// bookDeleted runs the delete hooks, their errors are logged.
+func (uc *BookShelf) bookDeleted(ctx context.Context, book entity.Book) {
+	for _, hook := range uc.deleteHooks {
+		if err := hook.BookDeleted(ctx, book); err != nil {
+			uc.logger.Error("BookShelf - bookDeleted - %s: %s", book.ID, err)
+		}
+	}
+}
+
+// beforeDownload returns ErrDownloadRefused when a download hook refuses
+// the file, book is the book as the file to hand out.
+func (uc *BookShelf) beforeDownload(ctx context.Context, book entity.Book) error {
+	for _, hook := range uc.downloadHooks {
+		if err := hook.BeforeDownload(ctx, book); err != nil {
+			return fmt.Errorf("%w: %s", ErrDownloadRefused, err)
+		}
+	}
+	return nil
+}
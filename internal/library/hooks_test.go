@@ -0,0 +1,138 @@
+package library_test
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+// refusePDF refuses downloads of PDF files.
+type refusePDF struct {
+	asked []string
+}
+
+func (h *refusePDF) BeforeDownload(_ context.Context, book entity.Book) error {
+	h.asked = append(h.asked, book.Extension())
+	if book.Extension() == "pdf" {
+		return errors.New("no PDFs here")
+	}
+	return nil
+}
+
+func TestDownloadHookRefusesFiles(t *testing.T) {
+	ctx := context.Background()
+	bookStorage := storage.NewMemoryStorage()
+	writeStorageFile(t, bookStorage, "2024/01/01/book-id.epub", "epub content")
+	writeStorageFile(t, bookStorage, "2024/01/01/other-id.pdf", "pdf content")
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", FilePath: "2024/01/01/book-id.epub"}}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+	hook := &refusePDF{}
+	shelf.AddDownloadHook(hook)
+
+	_, file, err := shelf.DownloadBook(ctx, "book-id", "")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	file.Close()
+
+	repo.book = entity.Book{ID: "other-id", FilePath: "2024/01/01/other-id.pdf"}
+	if _, _, err = shelf.DownloadBook(ctx, "other-id", ""); !errors.Is(err, library.ErrDownloadRefused) {
+		t.Fatalf("expected ErrDownloadRefused, got %v", err)
+	}
+	if len(hook.asked) != 2 || hook.asked[0] != "epub" || hook.asked[1] != "pdf" {
+		t.Fatalf("unexpected hook calls %v", hook.asked)
+	}
+}
+
+func TestDownloadHookRefusesExportsAndPages(t *testing.T) {
+	ctx := context.Background()
+	bookStorage := storage.NewMemoryStorage()
+	writeStorageFile(t, bookStorage, "2024/01/01/book-id.pdf", "pdf content")
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", Title: "title", FilePath: "2024/01/01/book-id.pdf"}}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+	shelf.AddDownloadHook(&refusePDF{})
+
+	var buf bytes.Buffer
+	if err := shelf.ExportBooks(ctx, &buf, []string{"book-id"}, library.ManifestJSON); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(zr.File) != 1 || zr.File[0].Name != "manifest.json" {
+		t.Fatalf("expected only the manifest in the export, got %d files", len(zr.File))
+	}
+
+	shelf.AddDownloadHook(refuseAll{})
+	repo.book.FilePath = "2024/01/01/book-id.cbz"
+	if _, _, err = shelf.ComicPage(ctx, "book-id", 1); !errors.Is(err, library.ErrDownloadRefused) {
+		t.Fatalf("expected ErrDownloadRefused, got %v", err)
+	}
+}
+
+type refuseAll struct{}
+
+func (refuseAll) BeforeDownload(context.Context, entity.Book) error {
+	return errors.New("closed")
+}
+
+type finishedBooks struct {
+	finished []string
+}
+
+func (h *finishedBooks) BookFinished(_ context.Context, username string, book entity.Book) error {
+	h.finished = append(h.finished, username+" "+book.ID)
+	return nil
+}
+
+func TestFinishHookRunsOncePerFinish(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id"}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	hook := &finishedBooks{}
+	shelf.AddFinishHook(hook)
+
+	for _, status := range []entity.ReadingStatus{entity.StatusReading, entity.StatusFinished, entity.StatusFinished} {
+		if _, err := shelf.SetReadingStatus(ctx, "reader", "book-id", status); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(hook.finished) != 1 || hook.finished[0] != "reader book-id" {
+		t.Fatalf("expected one finish, got %v", hook.finished)
+	}
+}
+
+type deletedBooks []string
+
+func (h *deletedBooks) BookDeleted(_ context.Context, book entity.Book) error {
+	*h = append(*h, book.ID)
+	return nil
+}
+
+func TestDeleteHookRunsAfterDeletion(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", ArchivedAt: time.Now()}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	hook := &deletedBooks{}
+	shelf.AddDeleteHook(hook)
+
+	if err := shelf.DeleteBook(ctx, "book-id"); !errors.Is(err, entity.ErrBookArchived) {
+		t.Fatalf("expected ErrBookArchived, got %v", err)
+	}
+	repo.book.ArchivedAt = time.Time{}
+	if err := shelf.DeleteBook(ctx, "book-id"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(*hook) != 1 || (*hook)[0] != "book-id" {
+		t.Fatalf("expected one deletion, got %v", *hook)
+	}
+}
@@ -0,0 +1,212 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// importExtensions are the files a directory import stores, the formats
+// StoreBook reads.
+var importExtensions = map[string]bool{
+	".epub": true, ".pdf": true, ".fb2": true, ".djvu": true, ".cbz": true, ".cbr": true, ".m4b": true, ".mp3": true,
+}
+
+// ImportOptions choose what the folders of a directory import become.
+type ImportOptions struct {
+	// Tags adds the names of the folders a book is in to its genres, the
+	// tags of the library: SciFi/Asimov/Foundation.epub gets SciFi and Asimov.
+	Tags bool
+	// Collections puts the books of each folder in a collection named
+	// after the folder path, SciFi / Asimov, in file name order.
+	Collections bool
+	// Depth uses only the first folder levels for tags and collections,
+	// 0 uses all.
+	Depth int
+}
+
+// ImportFile is a book file of a directory import with what its folders
+// give it.
+type ImportFile struct {
+	Path       string // relative to the imported directory
+	Tags       []string
+	Collection string
+}
+
+// ImportPlan is what a directory import stores, the taxonomy to review
+// before the import: the tags and collections with their number of books.
+type ImportPlan struct {
+	Files       []ImportFile
+	Tags        []FacetCount
+	Collections []FacetCount
+}
+
+// PlanImport walks the directory for book files and derives the tags and
+// collections of their folders. Nothing is stored, hidden files and
+// folders are skipped.
+func PlanImport(root string, opts ImportOptions) (ImportPlan, error) {
+	var plan ImportPlan
+	tags, collections := map[string]int{}, map[string]int{}
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path != root && strings.HasPrefix(d.Name(), ".") {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if d.IsDir() || !importExtensions[strings.ToLower(filepath.Ext(path))] {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		file := ImportFile{Path: rel}
+		folders := folderNames(filepath.Dir(rel))
+		if opts.Depth > 0 && len(folders) > opts.Depth {
+			folders = folders[:opts.Depth]
+		}
+		if opts.Tags {
+			file.Tags = folders
+			for _, tag := range folders {
+				tags[tag]++
+			}
+		}
+		if opts.Collections && len(folders) > 0 {
+			file.Collection = strings.Join(folders, " / ")
+			collections[file.Collection]++
+		}
+		plan.Files = append(plan.Files, file)
+		return nil
+	})
+	if err != nil {
+		return ImportPlan{}, fmt.Errorf("PlanImport - filepath.WalkDir: %w", err)
+	}
+	plan.Tags, plan.Collections = sortedCounts(tags), sortedCounts(collections)
+	return plan, nil
+}
+
+// folderNames splits a relative folder path, without empty names.
+func folderNames(dir string) []string {
+	var names []string
+	for _, name := range strings.Split(filepath.ToSlash(dir), "/") {
+		if name = strings.TrimSpace(name); name != "" && name != "." {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+func sortedCounts(counts map[string]int) []FacetCount {
+	values := make([]FacetCount, 0, len(counts))
+	for value, count := range counts {
+		values = append(values, FacetCount{Value: value, Count: count})
+	}
+	sort.Slice(values, func(i, j int) bool { return values[i].Value < values[j].Value })
+	return values
+}
+
+// ImportDirectory stores the files of a plan made by PlanImport for root.
+// Files the library has already are not stored again but get the tags and
+// collections too. Report counts the files and the books stored, files
+// that fail are problems and the import goes on.
+func (uc *BookShelf) ImportDirectory(ctx context.Context, root string, plan ImportPlan) (MaintenanceReport, error) {
+	var report MaintenanceReport
+	if err := entity.RequireEditor(ctx); err != nil {
+		return report, fmt.Errorf("BookShelf - ImportDirectory - %w", err)
+	}
+	// the books of each collection, and of each set of tags
+	collections := map[string][]string{}
+	tagged := map[string][]string{}
+	for _, file := range plan.Files {
+		report.Books++
+		book, err := uc.importFile(ctx, filepath.Join(root, file.Path))
+		if err != nil && !errors.Is(err, entity.ErrBookAlreadyExists) {
+			report.problem(entity.Book{Title: file.Path}, "%s", err)
+			continue
+		}
+		if err == nil {
+			report.Changed++
+		}
+		if len(file.Tags) > 0 {
+			key := strings.Join(file.Tags, "\x00")
+			tagged[key] = append(tagged[key], book.ID)
+		}
+		if file.Collection != "" {
+			collections[file.Collection] = append(collections[file.Collection], book.ID)
+		}
+	}
+
+	for key, bookIDs := range tagged {
+		if _, err := uc.BatchUpdateMetadata(ctx, bookIDs, MetadataPatch{AddGenres: strings.Split(key, "\x00")}); err != nil {
+			return report, fmt.Errorf("BookShelf - ImportDirectory - %w", err)
+		}
+	}
+	if err := uc.importCollections(ctx, collections); err != nil {
+		return report, fmt.Errorf("BookShelf - ImportDirectory - %w", err)
+	}
+	return report, nil
+}
+
+func (uc *BookShelf) importFile(ctx context.Context, path string) (entity.Book, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return entity.Book{}, err
+	}
+	defer file.Close()
+	// the file stays the user's, storage gets a copy
+	return uc.storeFile(ctx, file, filepath.Base(path), false)
+}
+
+// importCollections adds the books to the collections of their names,
+// creating the collections the library has not.
+func (uc *BookShelf) importCollections(ctx context.Context, books map[string][]string) error {
+	if len(books) == 0 {
+		return nil
+	}
+	existing, err := uc.Collections(ctx)
+	if err != nil {
+		return err
+	}
+	byName := make(map[string]string, len(existing))
+	for _, collection := range existing {
+		byName[collection.Name] = collection.ID
+	}
+	for name, bookIDs := range books {
+		collection := entity.Collection{Name: name}
+		if id, ok := byName[name]; ok {
+			if collection, err = uc.Collection(ctx, "", id); err != nil {
+				return err
+			}
+		}
+		listed := make(map[string]bool, len(collection.Entries))
+		for _, entry := range collection.Entries {
+			listed[entry.Book.ID] = true
+		}
+		for _, bookID := range bookIDs {
+			if !listed[bookID] {
+				listed[bookID] = true
+				collection.Entries = append(collection.Entries, entity.CollectionEntry{Book: entity.Book{ID: bookID}})
+			}
+		}
+		if collection.ID != "" {
+			_, err = uc.UpdateCollection(ctx, collection.ID, collection)
+		} else {
+			_, err = uc.CreateCollection(ctx, UploaderFrom(ctx), collection)
+		}
+		if err != nil {
+			return fmt.Errorf("collection %q: %w", name, err)
+		}
+	}
+	return nil
+}
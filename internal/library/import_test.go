@@ -0,0 +1,79 @@
+package library_test
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func TestImportDirectory(t *testing.T) {
+	ctx := context.Background()
+	root := t.TempDir()
+	for _, path := range []string{"SciFi/Asimov/Foundation.fb2", "SciFi/Asimov/Robots.fb2", "SciFi/Lem/Solaris.fb2", "Loose.fb2", ".trash/Old.fb2", "SciFi/notes.txt"} {
+		path = filepath.Join(root, path)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		body := `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>` + filepath.Base(path) + `</book-title></title-info></description><body><p>` + path + `</p></body></FictionBook>`
+		if err := os.WriteFile(path, []byte(body), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	plan, err := library.PlanImport(root, library.ImportOptions{Tags: true, Collections: true, Depth: 1})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(plan.Tags) != "[{SciFi 3}]" || fmt.Sprint(plan.Collections) != "[{SciFi 3}]" || len(plan.Files) != 4 {
+		t.Fatalf("expected the first folder level of four books, got %+v", plan)
+	}
+
+	plan, err = library.PlanImport(root, library.ImportOptions{Tags: true, Collections: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if fmt.Sprint(plan.Tags) != "[{Asimov 2} {Lem 1} {SciFi 3}]" || fmt.Sprint(plan.Collections) != "[{SciFi / Asimov 2} {SciFi / Lem 1}]" {
+		t.Fatalf("unexpected taxonomy %+v", plan)
+	}
+
+	repo := library.NewMemoryBookRepo()
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	report, err := shelf.ImportDirectory(ctx, root, plan)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Books != 4 || report.Changed != 4 || len(report.Problems) != 0 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+
+	collections, err := shelf.Collections(ctx)
+	if err != nil || len(collections) != 2 {
+		t.Fatalf("expected two collections, got %v %v", collections, err)
+	}
+	asimov, err := shelf.Collection(ctx, "", collections[0].ID)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if asimov.Name != "SciFi / Asimov" || len(asimov.Entries) != 2 || asimov.Entries[0].Book.Title != "Foundation.fb2" {
+		t.Fatalf("unexpected collection %+v", asimov)
+	}
+	if genres := fmt.Sprint(asimov.Entries[1].Book.Genres); genres != "[SciFi Asimov]" {
+		t.Errorf("expected the folders as tags, got %s", genres)
+	}
+
+	// a second import stores nothing and keeps the collections
+	report, err = shelf.ImportDirectory(ctx, root, plan)
+	if err != nil || report.Changed != 0 {
+		t.Fatalf("expected nothing stored again, got %+v %v", report, err)
+	}
+	if asimov, _ = shelf.Collection(ctx, "", asimov.ID); len(asimov.Entries) != 2 {
+		t.Errorf("expected the collection unchanged, got %d entries", len(asimov.Entries))
+	}
+}
@@ -0,0 +1,206 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// ingestLocks serializes the ingest of identical files. Concurrent uploads
+// of one file would both miss the duplicate check, write the file to
+// storage and leave one of them failing on the unique document id.
+type ingestLocks struct {
+	mu    sync.Mutex
+	locks map[string]*ingestLock
+}
+
+type ingestLock struct {
+	sync.Mutex
+	users int
+}
+
+// lock waits for other ingests of the content hash and returns the unlock
+// function. Locks are dropped once nobody waits for them.
+func (l *ingestLocks) lock(hash string) func() {
+	l.mu.Lock()
+	if l.locks == nil {
+		l.locks = make(map[string]*ingestLock)
+	}
+	lock, ok := l.locks[hash]
+	if !ok {
+		lock = &ingestLock{}
+		l.locks[hash] = lock
+	}
+	lock.users++
+	l.mu.Unlock()
+
+	lock.Lock()
+	return func() {
+		lock.Unlock()
+		l.mu.Lock()
+		lock.users--
+		if lock.users == 0 {
+			delete(l.locks, hash)
+		}
+		l.mu.Unlock()
+	}
+}
+
+// SetIngestLock makes servers sharing the database wait for each other's
+// uploads of one file, and for writes to one path, like uploads to one
+// server do.
+func (uc *BookShelf) SetIngestLock(lock IngestLock) {
+	uc.ingestLock = lock
+}
+
+// lockIngest takes the lock name in this process, then with an ingest lock
+// set across servers, and returns the unlock function.
+func (uc *BookShelf) lockIngest(ctx context.Context, locks *ingestLocks, name string) (func(), error) {
+	unlock := locks.lock(name)
+	if uc.ingestLock == nil {
+		return unlock, nil
+	}
+	release, err := uc.ingestLock.Lock(ctx, name)
+	if err != nil {
+		unlock()
+		return nil, fmt.Errorf("uc.ingestLock.Lock: %w", err)
+	}
+	return func() {
+		release()
+		unlock()
+	}, nil
+}
+
+const (
+	// ingestStaleAfter is how long an upload may take from writing its
+	// files to storing the book, older written entries were interrupted.
+	ingestStaleAfter = time.Hour
+	// ingestRetention keeps the entries of compensated uploads to look
+	// into failures.
+	ingestRetention = 30 * 24 * time.Hour
+)
+
+// recordIngest logs the files written for the book before it is stored, so
+// an interrupted upload can be cleaned up. It returns "" when the log can
+// not be written, the upload goes on without it.
+func (uc *BookShelf) recordIngest(ctx context.Context, book entity.Book) string {
+	id, err := uc.repo.RecordIngest(ctx, entity.IngestEntry{
+		BookID:     book.ID,
+		DocumentID: book.DocumentID,
+		FilePath:   book.FilePath,
+		CoverPath:  book.CoverPath,
+	})
+	if err != nil {
+		uc.logger.Warn("BookShelf - recordIngest - s.repo.RecordIngest: %s", err)
+	}
+	return id
+}
+
+// finishIngest drops the log entry of a stored book.
+func (uc *BookShelf) finishIngest(ctx context.Context, id string) {
+	if id == "" {
+		return
+	}
+	if err := uc.repo.DeleteIngest(ctx, id); err != nil {
+		uc.logger.Warn("BookShelf - finishIngest - s.repo.DeleteIngest: %s", err)
+	}
+}
+
+// compensateIngest removes the files written for a book that could not be
+// stored and records why, in the log entry id.
+func (uc *BookShelf) compensateIngest(ctx context.Context, id string, book entity.Book, cause error) {
+	state, detail := entity.IngestCompensated, cause.Error()
+	if err := uc.removeUploadFiles(ctx, book); err != nil {
+		uc.logger.Error("BookShelf - compensateIngest - %s: %s", book.ID, err)
+		state, detail = entity.IngestFailed, detail+"; "+err.Error()
+	}
+	if id == "" {
+		return
+	}
+	if err := uc.repo.SetIngestState(ctx, id, state, detail); err != nil {
+		uc.logger.Warn("BookShelf - compensateIngest - s.repo.SetIngestState: %s", err)
+	}
+}
+
+// removeUploadFiles deletes the file of a book that is not stored unless a
+// stored book is at its path, and its cover unless another book shows it.
+func (uc *BookShelf) removeUploadFiles(ctx context.Context, book entity.Book) error {
+	refs, err := uc.repo.Count(ctx, BookFilter{FilePath: book.FilePath})
+	if err != nil {
+		return fmt.Errorf("s.repo.Count: %w", err)
+	}
+	if refs == 0 {
+		if err = uc.storage.Delete(ctx, book.FilePath); err != nil {
+			return fmt.Errorf("s.storage.Delete %s: %w", book.FilePath, err)
+		}
+	}
+	if book.CoverPath == "" {
+		return nil
+	}
+	refs, err = uc.repo.Count(ctx, BookFilter{CoverPath: book.CoverPath})
+	if err != nil {
+		return fmt.Errorf("s.repo.Count: %w", err)
+	}
+	if refs > 0 {
+		return nil
+	}
+	if err = uc.storage.Delete(ctx, book.CoverPath); err != nil {
+		return fmt.Errorf("s.storage.Delete %s: %w", book.CoverPath, err)
+	}
+	return nil
+}
+
+// Ingests lists the uploads that were interrupted or failed after their
+// files were written, and the ones still being stored.
+func (uc *BookShelf) Ingests(ctx context.Context) ([]entity.IngestEntry, error) {
+	entries, err := uc.repo.ListIngests(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - Ingests - s.repo.ListIngests: %w", err)
+	}
+	return entries, nil
+}
+
+// CleanIngests removes what failed uploads left in storage. Uploads
+// interrupted for an hour count as failed unless their book was stored
+// after all. Entries of compensated uploads are dropped after 30 days.
+func (uc *BookShelf) CleanIngests(ctx context.Context) (MaintenanceReport, error) {
+	entries, err := uc.repo.ListIngests(ctx)
+	if err != nil {
+		return MaintenanceReport{}, fmt.Errorf("BookShelf - CleanIngests - s.repo.ListIngests: %w", err)
+	}
+	var report MaintenanceReport
+	now := time.Now()
+	for _, entry := range entries {
+		book := entity.Book{ID: entry.BookID, FilePath: entry.FilePath, CoverPath: entry.CoverPath}
+		switch {
+		case entry.State == entity.IngestCompensated && now.Sub(entry.UpdatedAt) < ingestRetention,
+			entry.State == entity.IngestWritten && now.Sub(entry.CreatedAt) < ingestStaleAfter:
+			continue
+		case entry.State == entity.IngestCompensated:
+		default:
+			report.Books++
+			_, err := uc.repo.GetById(ctx, entry.BookID)
+			switch {
+			case err == nil:
+				// the server stopped after storing the book, the files are its
+			case !errors.Is(err, entity.ErrBookNotFound):
+				report.problem(book, "%s", err)
+				continue
+			default:
+				if err = uc.removeUploadFiles(ctx, book); err != nil {
+					report.problem(book, "%s", err)
+					continue
+				}
+				report.Changed++
+			}
+		}
+		if err := uc.repo.DeleteIngest(ctx, entry.ID); err != nil {
+			return report, fmt.Errorf("BookShelf - CleanIngests - s.repo.DeleteIngest: %w", err)
+		}
+	}
+	return report, nil
+}
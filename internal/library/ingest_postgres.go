@@ -0,0 +1,66 @@
+package library
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// RecordIngest logs the files written for a book before it is stored.
+func (bdr *BookDatabaseRepo) RecordIngest(ctx context.Context, entry entity.IngestEntry) (string, error) {
+	var id string
+	err := bdr.Conn(ctx).QueryRow(ctx, `
+		INSERT INTO library_ingest_log (book_id, document_id, file_path, cover_path, state)
+		VALUES ($1, $2, $3, $4, $5)
+		RETURNING id
+	`, entry.BookID, entry.DocumentID, entry.FilePath, entry.CoverPath, entity.IngestWritten).Scan(&id)
+	if err != nil {
+		return "", fmt.Errorf("BookDatabaseRepo - RecordIngest - row.Scan: %w", err)
+	}
+	return id, nil
+}
+
+func (bdr *BookDatabaseRepo) SetIngestState(ctx context.Context, id, state, detail string) error {
+	_, err := bdr.Conn(ctx).Exec(ctx, `
+		UPDATE library_ingest_log SET state = $2, detail = $3, updated_at = now()
+		WHERE id = $1
+	`, id, state, detail)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - SetIngestState - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (bdr *BookDatabaseRepo) DeleteIngest(ctx context.Context, id string) error {
+	if _, err := bdr.Conn(ctx).Exec(ctx, `DELETE FROM library_ingest_log WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("BookDatabaseRepo - DeleteIngest - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// ListIngests returns the logged uploads, oldest first.
+func (bdr *BookDatabaseRepo) ListIngests(ctx context.Context) ([]entity.IngestEntry, error) {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT id, book_id, document_id, file_path, cover_path, state, detail, created_at, updated_at
+		FROM library_ingest_log
+		ORDER BY created_at, id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListIngests - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]entity.IngestEntry, 0)
+	for rows.Next() {
+		var e entity.IngestEntry
+		if err = rows.Scan(&e.ID, &e.BookID, &e.DocumentID, &e.FilePath, &e.CoverPath, &e.State, &e.Detail, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - ListIngests - rows.Scan: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListIngests - rows.Err: %w", err)
+	}
+	return entries, nil
+}
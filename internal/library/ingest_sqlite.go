@@ -0,0 +1,153 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/moroz/uuidv7-go"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// RecordIngest logs the files written for a book before it is stored.
+func (bsr *BookSQLiteRepo) RecordIngest(ctx context.Context, entry entity.IngestEntry) (string, error) {
+	id, now := uuidv7.Generate().String(), time.Now().UTC()
+	_, err := bsr.Conn(ctx).Exec(ctx, `
+		INSERT INTO library_ingest_log (id, book_id, document_id, file_path, cover_path, state, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $7)
+	`, id, entry.BookID, entry.DocumentID, entry.FilePath, entry.CoverPath, entity.IngestWritten, now)
+	if err != nil {
+		return "", fmt.Errorf("BookSQLiteRepo - RecordIngest - r.DB.Exec: %w", err)
+	}
+	return id, nil
+}
+
+func (bsr *BookSQLiteRepo) SetIngestState(ctx context.Context, id, state, detail string) error {
+	_, err := bsr.Conn(ctx).Exec(ctx, `
+		UPDATE library_ingest_log SET state = $2, detail = $3, updated_at = now()
+		WHERE id = $1
+	`, id, state, detail)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - SetIngestState - r.DB.Exec: %w", err)
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) DeleteIngest(ctx context.Context, id string) error {
+	if _, err := bsr.Conn(ctx).Exec(ctx, `DELETE FROM library_ingest_log WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("BookSQLiteRepo - DeleteIngest - r.DB.Exec: %w", err)
+	}
+	return nil
+}
+
+// ListIngests returns the logged uploads, oldest first.
+func (bsr *BookSQLiteRepo) ListIngests(ctx context.Context) ([]entity.IngestEntry, error) {
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT id, book_id, document_id, file_path, cover_path, state, detail, created_at, updated_at
+		FROM library_ingest_log
+		ORDER BY created_at, id
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListIngests - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	entries := make([]entity.IngestEntry, 0)
+	for rows.Next() {
+		var e entity.IngestEntry
+		if err = rows.Scan(&e.ID, &e.BookID, &e.DocumentID, &e.FilePath, &e.CoverPath, &e.State, &e.Detail, &e.CreatedAt, &e.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("BookSQLiteRepo - ListIngests - rows.Scan: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListIngests - rows.Err: %w", err)
+	}
+	return entries, nil
+}
+
+// SaveIssues records the problems found with a book. A problem found again
+// keeps the time it was first detected.
+func (bsr *BookSQLiteRepo) SaveIssues(ctx context.Context, bookID string, issues []entity.BookIssue, checkedAt time.Time) error {
+	for _, issue := range issues {
+		_, err := bsr.Conn(ctx).Exec(ctx, `
+			INSERT INTO library_issue (book_id, kind, detail, detected_at, checked_at)
+			VALUES ($1, $2, $3, $4, $4)
+			ON CONFLICT (book_id, kind) DO UPDATE
+			SET detail = excluded.detail,
+				checked_at = excluded.checked_at
+		`, bookID, issue.Kind, issue.Detail, checkedAt)
+		if err != nil {
+			return fmt.Errorf("BookSQLiteRepo - SaveIssues - r.DB.Exec: %w", err)
+		}
+	}
+	return nil
+}
+
+// ResolveIssues removes the problems a complete check no longer found.
+func (bsr *BookSQLiteRepo) ResolveIssues(ctx context.Context, checkedBefore time.Time) error {
+	_, err := bsr.Conn(ctx).Exec(ctx, `DELETE FROM library_issue WHERE checked_at < $1`, checkedBefore)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - ResolveIssues - r.DB.Exec: %w", err)
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) ListIssues(ctx context.Context) ([]entity.BookIssue, error) {
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT i.book_id, b.title, i.kind, i.detail, i.detected_at, i.checked_at
+		FROM library_issue i
+		JOIN library_book b ON b.id = i.book_id
+		ORDER BY i.detected_at DESC, b.title
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListIssues - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	issues := make([]entity.BookIssue, 0)
+	for rows.Next() {
+		var issue entity.BookIssue
+		err = rows.Scan(&issue.BookID, &issue.Title, &issue.Kind, &issue.Detail, &issue.DetectedAt, &issue.CheckedAt)
+		if err != nil {
+			return nil, fmt.Errorf("BookSQLiteRepo - ListIssues - rows.Scan: %w", err)
+		}
+		issues = append(issues, issue)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListIssues - rows.Err: %w", err)
+	}
+	return issues, nil
+}
+
+func (bsr *BookSQLiteRepo) StoreLibraryCheck(ctx context.Context, check entity.LibraryCheck) error {
+	_, err := bsr.Conn(ctx).Exec(ctx, `
+		INSERT INTO library_check (started_at, finished_at, book_count, issue_count)
+		VALUES ($1, $2, $3, $4)
+	`, check.StartedAt, check.FinishedAt, check.Books, check.Issues)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - StoreLibraryCheck - r.DB.Exec: %w", err)
+	}
+	return nil
+}
+
+// LastLibraryCheck returns the latest check, zero when there was none.
+func (bsr *BookSQLiteRepo) LastLibraryCheck(ctx context.Context) (entity.LibraryCheck, error) {
+	var check entity.LibraryCheck
+	err := bsr.Conn(ctx).QueryRow(ctx, `
+		SELECT started_at, finished_at, book_count, issue_count
+		FROM library_check
+		ORDER BY started_at DESC
+		LIMIT 1
+	`).Scan(&check.StartedAt, &check.FinishedAt, &check.Books, &check.Issues)
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.LibraryCheck{}, nil
+	}
+	if err != nil {
+		return entity.LibraryCheck{}, fmt.Errorf("BookSQLiteRepo - LastLibraryCheck - row.Scan: %w", err)
+	}
+	return check, nil
+}
@@ -0,0 +1,364 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+// uniqueBookRepo stores books by document id like the unique constraint.
+type uniqueBookRepo struct {
+	fakeBookRepo
+	mu    sync.Mutex
+	books map[string]entity.Book
+}
+
+func (r *uniqueBookRepo) Store(_ context.Context, book entity.Book) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.books[book.DocumentID]; ok {
+		return entity.ErrBookAlreadyExists
+	}
+	r.books[book.DocumentID] = book
+	return nil
+}
+
+func (r *uniqueBookRepo) GetByFileHash(_ context.Context, hash string) (entity.Book, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	book, ok := r.books[hash]
+	if !ok {
+		return entity.Book{}, entity.ErrBookNotFound
+	}
+	return book, nil
+}
+
+func (r *uniqueBookRepo) Count(_ context.Context, filter library.BookFilter) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, book := range r.books {
+		if filter.FilePath != "" && book.FilePath == filter.FilePath {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *uniqueBookRepo) CountUploadedBy(_ context.Context, username string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	count := 0
+	for _, book := range r.books {
+		if book.UploadedBy == username {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (r *uniqueBookRepo) StorageUsage(_ context.Context, username string) (entity.StorageUsage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var usage entity.StorageUsage
+	for _, book := range r.books {
+		if book.UploadedBy == username {
+			usage.Books++
+			usage.FileBytes += book.FileSize
+			usage.CoverBytes += book.CoverSize
+		}
+	}
+	return usage, nil
+}
+
+func TestConcurrentUploadsOfOneFileStoreOneBook(t *testing.T) {
+	const uploads = 4
+	fb2 := `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>Same book</book-title></title-info></description><body><p>text</p></body></FictionBook>`
+	files := make([]*os.File, uploads)
+	for i := range files {
+		file, err := os.CreateTemp(t.TempDir(), "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err = file.WriteString(fb2); err != nil {
+			t.Fatal(err)
+		}
+		if _, err = file.Seek(0, 0); err != nil {
+			t.Fatal(err)
+		}
+		files[i] = file
+	}
+
+	bookStorage := storage.NewMemoryStorage()
+	repo := &uniqueBookRepo{books: make(map[string]entity.Book)}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+
+	type result struct {
+		book entity.Book
+		err  error
+	}
+	results := make([]result, uploads)
+	var wg sync.WaitGroup
+	for i, file := range files {
+		wg.Add(1)
+		go func(i int, file *os.File) {
+			defer wg.Done()
+			book, err := shelf.StoreBook(context.Background(), file, "same.fb2")
+			results[i] = result{book, err}
+		}(i, file)
+	}
+	wg.Wait()
+
+	stored := 0
+	for _, r := range results {
+		switch {
+		case r.err == nil:
+			stored++
+		case !errors.Is(r.err, entity.ErrBookAlreadyExists):
+			t.Fatalf("unexpected error: %v", r.err)
+		}
+	}
+	if stored != 1 || len(repo.books) != 1 {
+		t.Fatalf("expected one stored book, got %d stored and %d in the repo", stored, len(repo.books))
+	}
+	for _, r := range results {
+		if r.book.ID != results[0].book.ID {
+			t.Fatalf("expected every upload to resolve to one book, got %q and %q", r.book.ID, results[0].book.ID)
+		}
+	}
+}
+
+func TestStoreBookDiscardsFilesOfALostRace(t *testing.T) {
+	fb2 := `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>Raced</book-title></title-info></description><body><p>text</p></body></FictionBook>`
+	for _, samePath := range []bool{false, true} {
+		file, err := os.CreateTemp(t.TempDir(), "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		if _, err = file.WriteString(fb2); err != nil {
+			t.Fatal(err)
+		}
+		file.Seek(0, 0)
+
+		bookStorage := storage.NewMemoryStorage()
+		repo := &raceLostBookRepo{uniqueBookRepo: uniqueBookRepo{books: make(map[string]entity.Book)}, samePath: samePath}
+		shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+
+		book, err := shelf.StoreBook(context.Background(), file, "raced.fb2")
+		if !errors.Is(err, entity.ErrBookAlreadyExists) || book.ID != "other-server" {
+			t.Fatalf("expected the book stored by the other server, got %+v, %v", book, err)
+		}
+		_, err = bookStorage.Open(context.Background(), repo.discarded)
+		if samePath && err != nil {
+			t.Fatalf("expected the file %q of the other server's book to be kept, got %v", repo.discarded, err)
+		}
+		if !samePath && err == nil {
+			t.Fatalf("expected the uploaded file %q to be deleted", repo.discarded)
+		}
+	}
+}
+
+// raceLostBookRepo has another server store the same file between the
+// duplicate check and Store.
+type raceLostBookRepo struct {
+	uniqueBookRepo
+	discarded string
+	// samePath has the other server render the same path and write the
+	// file there
+	samePath bool
+}
+
+func (r *raceLostBookRepo) Store(ctx context.Context, book entity.Book) error {
+	r.discarded = book.FilePath
+	other := book
+	other.ID = "other-server"
+	if !r.samePath {
+		other.FilePath = "other/" + book.FilePath
+	}
+	r.uniqueBookRepo.Store(ctx, other)
+	return r.uniqueBookRepo.Store(ctx, book)
+}
+
+// sharedLock is the lock of the database servers share, taken once in the
+// whole test like an advisory lock.
+type sharedLock struct {
+	mu   sync.Mutex
+	held map[string]chan struct{}
+}
+
+func (l *sharedLock) Lock(ctx context.Context, name string) (func(), error) {
+	for {
+		l.mu.Lock()
+		wait, ok := l.held[name]
+		if !ok {
+			done := make(chan struct{})
+			l.held[name] = done
+			l.mu.Unlock()
+			return func() {
+				l.mu.Lock()
+				delete(l.held, name)
+				l.mu.Unlock()
+				close(done)
+			}, nil
+		}
+		l.mu.Unlock()
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func TestServersSharingTheDatabaseStoreOneBook(t *testing.T) {
+	const servers = 4
+	fb2 := `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>Shared</book-title></title-info></description><body><p>text</p></body></FictionBook>`
+	bookStorage := storage.NewMemoryStorage()
+	repo := &uniqueBookRepo{books: make(map[string]entity.Book)}
+	lock := &sharedLock{held: make(map[string]chan struct{})}
+	template, err := library.ParsePathTemplate("{title}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	errs := make([]error, servers)
+	var wg sync.WaitGroup
+	for i := 0; i < servers; i++ {
+		file, err := os.CreateTemp(t.TempDir(), "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		file.WriteString(fb2)
+		file.Seek(0, 0)
+		// every server locks in process on its own
+		shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+		shelf.SetPathTemplate(template)
+		shelf.SetIngestLock(lock)
+
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = shelf.StoreBook(context.Background(), file, "shared.fb2")
+		}(i)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil && !errors.Is(err, entity.ErrBookAlreadyExists) {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(repo.books) != 1 {
+		t.Fatalf("expected one stored book, got %d", len(repo.books))
+	}
+	for _, book := range repo.books {
+		if _, err = bookStorage.Open(context.Background(), book.FilePath); err != nil {
+			t.Fatalf("expected the file %q of the stored book, got %v", book.FilePath, err)
+		}
+	}
+}
+
+// failingStoreRepo loses the database on Store and keeps the ingest log.
+type failingStoreRepo struct {
+	uniqueBookRepo
+	ingests map[string]entity.IngestEntry
+}
+
+func (r *failingStoreRepo) Store(context.Context, entity.Book) error {
+	return errors.New("connection reset")
+}
+
+func (r *failingStoreRepo) GetById(context.Context, string) (entity.Book, error) {
+	return entity.Book{}, entity.ErrBookNotFound
+}
+
+func (r *failingStoreRepo) RecordIngest(_ context.Context, entry entity.IngestEntry) (string, error) {
+	entry.ID = fmt.Sprintf("ingest-%d", len(r.ingests)+1)
+	entry.State = entity.IngestWritten
+	entry.CreatedAt, entry.UpdatedAt = time.Now(), time.Now()
+	r.ingests[entry.ID] = entry
+	return entry.ID, nil
+}
+
+func (r *failingStoreRepo) SetIngestState(_ context.Context, id, state, detail string) error {
+	entry := r.ingests[id]
+	entry.State, entry.Detail = state, detail
+	r.ingests[id] = entry
+	return nil
+}
+
+func (r *failingStoreRepo) DeleteIngest(_ context.Context, id string) error {
+	delete(r.ingests, id)
+	return nil
+}
+
+func (r *failingStoreRepo) ListIngests(context.Context) ([]entity.IngestEntry, error) {
+	var entries []entity.IngestEntry
+	for _, entry := range r.ingests {
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+func TestStoreBookRemovesFilesWhenStoreFails(t *testing.T) {
+	ctx := context.Background()
+	bookStorage := storage.NewMemoryStorage()
+	repo := &failingStoreRepo{uniqueBookRepo: uniqueBookRepo{books: make(map[string]entity.Book)}, ingests: make(map[string]entity.IngestEntry)}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+
+	file, err := os.CreateTemp(t.TempDir(), "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>Lost</book-title></title-info></description><body><p>text</p></body></FictionBook>`)
+	file.Seek(0, 0)
+
+	if _, err = shelf.StoreBook(ctx, file, "lost.fb2"); err == nil {
+		t.Fatal("expected the failing store to fail the upload")
+	}
+	entries, _ := shelf.Ingests(ctx)
+	if len(entries) != 1 || entries[0].State != entity.IngestCompensated || !strings.Contains(entries[0].Detail, "connection reset") {
+		t.Fatalf("expected a compensated ingest entry, got %+v", entries)
+	}
+	if _, err = bookStorage.Open(ctx, entries[0].FilePath); err == nil {
+		t.Errorf("expected the file %q to be removed", entries[0].FilePath)
+	}
+
+	// an upload interrupted before Store left its file behind
+	writeStorageFile(t, bookStorage, "2024/01/01/orphan.fb2", "orphan")
+	repo.ingests["ingest-orphan"] = entity.IngestEntry{
+		ID: "ingest-orphan", BookID: "orphan", FilePath: "2024/01/01/orphan.fb2",
+		State: entity.IngestWritten, CreatedAt: time.Now().Add(-2 * time.Hour),
+	}
+	report, err := shelf.CleanIngests(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Books != 1 || report.Changed != 1 {
+		t.Errorf("expected the interrupted upload to be cleaned, got %+v", report)
+	}
+	if _, err = bookStorage.Open(ctx, "2024/01/01/orphan.fb2"); err == nil {
+		t.Error("expected the orphaned file to be removed")
+	}
+	if _, ok := repo.ingests["ingest-orphan"]; ok || len(repo.ingests) != 1 {
+		t.Errorf("expected only the recent compensated entry to be kept, got %+v", repo.ingests)
+	}
+}
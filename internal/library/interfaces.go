@@ -2,37 +2,290 @@ package library
 
 import (
 	"context"
+	"io"
 	"os"
+	"time"
 
 	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/comic"
+	"github.com/banjuer/kompanion/pkg/mailer"
+	"github.com/banjuer/kompanion/pkg/thumbnail"
 )
 
 type (
 	// Shelf -
 	Shelf interface {
 		StoreBook(ctx context.Context, tempFile *os.File, uploadedFilename string) (entity.Book, error)
-		ListBooks(ctx context.Context, sortBy, sortOrder string, page, perPage int) (PaginatedBookList, error)
-		SearchBooks(ctx context.Context, query string, sortBy, sortOrder string, page, perPage int) (PaginatedBookList, error)
+		UploadConflict(ctx context.Context, existing entity.Book) UploadConflict
+		ListBooks(ctx context.Context, filter BookFilter, sortBy, sortOrder string, page, perPage int) (PaginatedBookList, error)
+		SearchBooks(ctx context.Context, query string, filter BookFilter, sortBy, sortOrder string, page, perPage int) (PaginatedBookList, error)
+		ListBooksByCursor(ctx context.Context, filter BookFilter, sortBy, sortOrder, cursor string, perPage int) (PaginatedBookList, error)
+		SearchBooksByCursor(ctx context.Context, query string, filter BookFilter, sortBy, sortOrder, cursor string, perPage int) (PaginatedBookList, error)
 		ViewBook(ctx context.Context, bookID string) (entity.Book, error)
-		DownloadBook(ctx context.Context, bookID string) (entity.Book, *os.File, error)
+		DownloadBook(ctx context.Context, bookID, format string) (entity.Book, storage.File, error)
+		BookFormat(ctx context.Context, bookID, format string) (entity.Book, error)
+		BookFileURL(ctx context.Context, bookID, format string) (string, error)
+		BookFiles(ctx context.Context, bookID string) ([]entity.BookFile, error)
+		AddBookFile(ctx context.Context, bookID string, tempFile *os.File) (entity.BookFile, error)
+		DeleteBookFile(ctx context.Context, bookID, format string) error
 		UpdateBookMetadata(ctx context.Context, bookID string, metadata entity.Book) (entity.Book, error)
+		BatchUpdateMetadata(ctx context.Context, bookIDs []string, patch MetadataPatch) ([]entity.Book, error)
+		SetBookPrivate(ctx context.Context, bookID string, private bool) (entity.Book, error)
+		SetBookVisibleFrom(ctx context.Context, bookID string, from time.Time) (entity.Book, error)
+		LendBook(ctx context.Context, username, bookID, borrower string, days int) (entity.Loan, error)
+		Loans(ctx context.Context, username string) ([]entity.Loan, error)
+		EndLoan(ctx context.Context, username, loanID string) error
+		CreateCollection(ctx context.Context, username string, collection entity.Collection) (entity.Collection, error)
+		Collections(ctx context.Context) ([]entity.Collection, error)
+		Collection(ctx context.Context, username, id string) (entity.Collection, error)
+		UpdateCollection(ctx context.Context, id string, collection entity.Collection) (entity.Collection, error)
+		DeleteCollection(ctx context.Context, id string) error
+		ShareCollectionFeed(ctx context.Context, id string) (string, error)
+		RevokeCollectionFeed(ctx context.Context, id string) error
+		SharedCollection(ctx context.Context, token string) (entity.Collection, error)
+		DownloadSharedCollectionBook(ctx context.Context, token, bookID, format string) (entity.Book, storage.File, error)
+		ViewSharedCollectionCover(ctx context.Context, token, bookID string, opts thumbnail.Options) (*os.File, error)
+		ExportMetadata(ctx context.Context, w io.Writer, format string) error
+		ImportMetadata(ctx context.Context, r io.Reader, format string) (MaintenanceReport, error)
 		EnrichBookMetadata(ctx context.Context, bookID string) (entity.Book, error)
 		EnrichBookMetadataFromBase(ctx context.Context, bookID string, metadata entity.Book) (entity.Book, error)
 		ViewCover(ctx context.Context, bookID string) (*os.File, error)
+		ViewCoverSized(ctx context.Context, bookID string, opts thumbnail.Options) (*os.File, error)
+		CoverFormat(accept string) string
+		CoverURL(ctx context.Context, bookID string) (string, error)
 		UpdateCover(ctx context.Context, bookID string, coverFile *os.File) (entity.Book, error)
 		DeleteBook(ctx context.Context, bookID string) error
+		ArchiveBook(ctx context.Context, bookID string) (entity.Book, error)
+		Chapters(ctx context.Context, bookID string) ([]entity.Chapter, error)
+		ComicPage(ctx context.Context, bookID string, page int) (string, []byte, error)
+		SameCoverBooks(ctx context.Context, bookID string) ([]entity.Book, error)
+		SimilarBooks(ctx context.Context, bookID string, n int) ([]entity.Book, error)
+		Editions(ctx context.Context, bookID string) ([]entity.Edition, error)
+		LinkEdition(ctx context.Context, bookID, otherID, relation string) error
+		UnlinkEdition(ctx context.Context, bookID string) error
+		RandomBooks(ctx context.Context, n int, filter BookFilter) ([]entity.Book, error)
+		SendToDevice(ctx context.Context, bookID, email string) error
+		Languages(ctx context.Context) ([]string, error)
+		ReadingStatus(ctx context.Context, username, bookID string) (entity.BookStatus, error)
+		SetReadingStatus(ctx context.Context, username, bookID string, status entity.ReadingStatus) (entity.BookStatus, error)
+		ClearReadingStatus(ctx context.Context, username, bookID string) error
+		ImportReadingHistory(ctx context.Context, username string, r io.Reader) (MaintenanceReport, error)
+		Reviews(ctx context.Context, bookID string) ([]entity.Review, error)
+		Review(ctx context.Context, username, bookID string) (entity.Review, error)
+		SaveReview(ctx context.Context, username, bookID string, rating int, text string) (entity.Review, error)
+		DeleteReview(ctx context.Context, username, bookID string) error
+		RecordDownload(ctx context.Context, download entity.Download) error
+		Downloads(ctx context.Context, username string, unopened bool) ([]entity.DownloadedBook, error)
+		CreateShareLink(ctx context.Context, username, bookID string, ttl time.Duration, maxDownloads int) (entity.ShareLink, string, error)
+		ShareLinks(ctx context.Context, username, bookID string) ([]entity.ShareLink, error)
+		RevokeShareLink(ctx context.Context, username, id string) error
+		SharedBook(ctx context.Context, token string) (entity.ShareLink, entity.Book, error)
+		DownloadSharedBook(ctx context.Context, token string, count bool) (entity.Book, storage.File, error)
+		ExportBooks(ctx context.Context, w io.Writer, bookIDs []string, manifest string) error
+		Citations(ctx context.Context, bookIDs []string, format string) ([]byte, error)
+		Catalog(ctx context.Context, w io.Writer, title, collectionID, format string) error
+		StorageUsage(ctx context.Context, username string) (entity.StorageUsage, error)
+		LibraryStats(ctx context.Context) (LibraryStats, error)
+		StartVerifyLibrary(ctx context.Context) error
+		LibraryIssues(ctx context.Context) (entity.LibraryCheck, []entity.BookIssue, error)
+		FormatReport(ctx context.Context, devices map[string][]string) (FormatReport, error)
+		StartConversions(ctx context.Context, report FormatReport) (int, error)
+		PruneFormats(ctx context.Context, policy PrunePolicy, dryRun bool) (PruneReport, error)
+	}
+
+	// Mailer - delivers books to e-reader mailboxes (Send to Kindle).
+	Mailer interface {
+		Send(ctx context.Context, msg mailer.Message) error
+	}
+
+	// Converter - converts a book file into another format, returns a temporary file path.
+	Converter interface {
+		Convert(ctx context.Context, source, format string) (string, error)
+	}
+
+	// IngestHook - is told about books added to the library, e.g. to notify
+	// other services. See pkg/extension for external programs.
+	IngestHook interface {
+		BookIngested(ctx context.Context, book entity.Book) error
+	}
+
+	// DeleteHook - is told about books deleted from the library.
+	DeleteHook interface {
+		BookDeleted(ctx context.Context, book entity.Book) error
+	}
+
+	// FinishHook - is told when an account marks a book finished.
+	FinishHook interface {
+		BookFinished(ctx context.Context, username string, book entity.Book) error
+	}
+
+	// LoanHook - is told when a private book is lent to another account
+	// and when the loan ends, e.g. to notify both accounts.
+	LoanHook interface {
+		BookLent(ctx context.Context, loan entity.Loan, book entity.Book) error
+		LoanEnded(ctx context.Context, loan entity.Loan, book entity.Book) error
+	}
+
+	// DownloadHook - is asked before a book file is handed out, the book is
+	// given as the file of the format requested. An error refuses the
+	// download.
+	DownloadHook interface {
+		BeforeDownload(ctx context.Context, book entity.Book) error
+	}
+
+	// ComicReader - opens CBZ and CBR archives, see pkg/comic.
+	ComicReader interface {
+		Open(ctx context.Context, file *os.File, format string) (comic.Archive, error)
+	}
+
+	// CoverCache - keeps resized covers on disk, see pkg/diskcache.
+	CoverCache interface {
+		Get(key string) (*os.File, bool)
+		Put(key string, data []byte) (*os.File, error)
+	}
+
+	// CoverEncoder - resizes covers into WebP and AVIF as well, see
+	// thumbnail.Encoder.
+	CoverEncoder interface {
+		Formats() []string
+		Resize(ctx context.Context, r io.Reader, o thumbnail.Options) ([]byte, string, error)
+	}
+
+	// ResponseCache - keeps listings and covers between requests, see
+	// pkg/cache. A ttl of 0 keeps the value until it is evicted.
+	ResponseCache interface {
+		Get(ctx context.Context, key string) ([]byte, bool, error)
+		Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	}
+
+	// CDN - links to files of the book storage a CDN serves, see pkg/cdn.
+	CDN interface {
+		URL(path string) string
+	}
+
+	// Metrics - records uploads, downloads and how long ingests take, see
+	// pkg/metrics.
+	Metrics interface {
+		BookUploaded(result string, took time.Duration)
+		MetadataExtracted(format string, took time.Duration)
+		BookDownloaded(format string)
+	}
+
+	// CoverSource - covers by ISBN for books whose file has none, see
+	// bookmeta.OpenLibraryCovers.
+	CoverSource interface {
+		Cover(ctx context.Context, isbn string) ([]byte, error)
+	}
+
+	// MetadataRuleSource - the rules rewriting the metadata of uploads,
+	// see settings.InstanceSettings.
+	MetadataRuleSource interface {
+		MetadataRules(ctx context.Context) ([]entity.MetadataRule, error)
+	}
+
+	// UploadPreferences - whether accounts keep their uploads private,
+	// see auth.AuthService.
+	UploadPreferences interface {
+		PrivateUploads(ctx context.Context, username string) bool
+	}
+
+	// JobLock - keeps a job from running on two servers sharing the
+	// database, see postgres.Locker.
+	JobLock interface {
+		TryLock(ctx context.Context, name string) (release func(), err error)
+	}
+
+	// IngestLock - keeps servers sharing the database from storing one
+	// file, or writing one path, at the same time, see postgres.Locker.
+	IngestLock interface {
+		Lock(ctx context.Context, name string) (release func(), err error)
+	}
+
+	// JobQueue - background jobs the workers of all servers sharing the
+	// database take turns on, see JobDatabaseRepo.
+	JobQueue interface {
+		EnqueueJob(ctx context.Context, job entity.Job) (bool, error)
+		ClaimJob(ctx context.Context, worker string, timeout time.Duration) (entity.Job, bool, error)
+		HeartbeatJob(ctx context.Context, id int64, worker string) error
+		CompleteJob(ctx context.Context, id int64, worker string) error
+		RetryJob(ctx context.Context, id int64, worker, detail string, at time.Time) error
+		FailJob(ctx context.Context, id int64, worker, detail string) error
 	}
 
 	// BookRepo -
 	BookRepo interface {
+		// WithTx runs fn in one transaction, the calls fn makes with its
+		// context are all saved or none is.
+		WithTx(ctx context.Context, fn func(ctx context.Context) error) error
 		Store(context.Context, entity.Book) error
-		List(ctx context.Context, sortBy, sortOrder string, page, perPage int) ([]entity.Book, error)
-		Search(ctx context.Context, query string, sortBy, sortOrder string, page, perPage int) ([]entity.Book, error)
-		Count(ctx context.Context) (int, error)
-		CountSearch(ctx context.Context, query string) (int, error)
+		List(ctx context.Context, filter BookFilter, sortBy, sortOrder string, page, perPage int) ([]entity.Book, error)
+		Search(ctx context.Context, query string, filter BookFilter, sortBy, sortOrder string, page, perPage int) ([]entity.Book, error)
+		ListByCursor(ctx context.Context, filter BookFilter, sortBy, sortOrder string, cursor Cursor, limit int) ([]entity.Book, error)
+		SearchByCursor(ctx context.Context, query string, filter BookFilter, sortBy, sortOrder string, cursor Cursor, limit int) ([]entity.Book, error)
+		Count(ctx context.Context, filter BookFilter) (int, error)
+		CountUploadedBy(ctx context.Context, username string) (int, error)
+		StorageUsage(ctx context.Context, username string) (entity.StorageUsage, error)
+		LibraryUsage(ctx context.Context) (entity.StorageUsage, error)
+		CountSearch(ctx context.Context, query string, filter BookFilter) (int, error)
+		Facets(ctx context.Context, query string, filter BookFilter) (BookFacets, error)
+		Analytics(ctx context.Context, filter BookFilter, top int) (LibraryStats, error)
+		EstimateCount(ctx context.Context) (int, error)
+		Random(ctx context.Context, filter BookFilter, n int) ([]entity.Book, error)
+		Similar(ctx context.Context, book entity.Book, filter BookFilter, n int) ([]entity.Book, error)
+		Languages(ctx context.Context) ([]string, error)
 		GetById(context.Context, string) (entity.Book, error)
 		GetByFileHash(context.Context, string) (entity.Book, error)
+		ListByISBN(ctx context.Context, isbn string) ([]entity.Book, error)
 		Update(context.Context, entity.Book) error
+		UpdateMany(ctx context.Context, ids []string, change func(entity.Book) (entity.Book, error)) ([]entity.Book, error)
+		SetArchived(ctx context.Context, bookID string, archivedAt time.Time) error
+		SetPrivate(ctx context.Context, bookID string, private bool) error
+		SetVisibleFrom(ctx context.Context, bookID string, from time.Time) error
+		StoreFile(ctx context.Context, file entity.BookFile) error
+		ListFiles(ctx context.Context, bookID string) ([]entity.BookFile, error)
+		DeleteFile(ctx context.Context, bookID, format string) error
+		LinkEdition(ctx context.Context, bookID, otherID string, relation entity.EditionRelation) error
+		UnlinkEdition(ctx context.Context, bookID string) error
+		ListEditions(ctx context.Context, bookIDs []string) (map[string][]entity.Edition, error)
+		StoreChapters(ctx context.Context, bookID string, chapters []entity.Chapter) error
+		ListChapters(ctx context.Context, bookID string) ([]entity.Chapter, error)
 		Delete(context.Context, string) error
+		GetReadingStatus(ctx context.Context, username, bookID string) (entity.BookStatus, error)
+		SetReadingStatus(ctx context.Context, status entity.BookStatus) error
+		ClearReadingStatus(ctx context.Context, username, bookID string) error
+		ListReviews(ctx context.Context, bookID string) ([]entity.Review, error)
+		GetReview(ctx context.Context, username, bookID string) (entity.Review, error)
+		SaveReview(ctx context.Context, review entity.Review) error
+		DeleteReview(ctx context.Context, username, bookID string) error
+		AddDownload(ctx context.Context, download entity.Download) error
+		ListDownloads(ctx context.Context, username string, unopened bool, limit int) ([]entity.DownloadedBook, error)
+		CreateShareLink(ctx context.Context, link entity.ShareLink, hash string) (entity.ShareLink, error)
+		GetShareLinkByHash(ctx context.Context, hash string) (entity.ShareLink, error)
+		ListShareLinks(ctx context.Context, username, bookID string) ([]entity.ShareLink, error)
+		RevokeShareLink(ctx context.Context, username, id string) error
+		CountShareDownload(ctx context.Context, id string) error
+		CreateLoan(ctx context.Context, loan entity.Loan) (entity.Loan, error)
+		GetLoan(ctx context.Context, id string) (entity.Loan, error)
+		ListLoans(ctx context.Context, username string) ([]entity.Loan, error)
+		EndLoan(ctx context.Context, id string, endedAt time.Time) error
+		EndExpiredLoans(ctx context.Context, now time.Time) ([]entity.Loan, error)
+		CreateCollection(ctx context.Context, collection entity.Collection) (entity.Collection, error)
+		GetCollection(ctx context.Context, id, username string) (entity.Collection, error)
+		ListCollections(ctx context.Context) ([]entity.Collection, error)
+		UpdateCollection(ctx context.Context, collection entity.Collection) error
+		DeleteCollection(ctx context.Context, id string) error
+		SetCollectionFeed(ctx context.Context, id, tokenHash, prefix string) error
+		GetCollectionIDByFeedHash(ctx context.Context, tokenHash string) (string, error)
+		RecordIngest(ctx context.Context, entry entity.IngestEntry) (string, error)
+		SetIngestState(ctx context.Context, id, state, detail string) error
+		DeleteIngest(ctx context.Context, id string) error
+		ListIngests(ctx context.Context) ([]entity.IngestEntry, error)
+		Reindex(ctx context.Context) error
+		SaveIssues(ctx context.Context, bookID string, issues []entity.BookIssue, checkedAt time.Time) error
+		ResolveIssues(ctx context.Context, checkedBefore time.Time) error
+		ListIssues(ctx context.Context) ([]entity.BookIssue, error)
+		StoreLibraryCheck(ctx context.Context, check entity.LibraryCheck) error
+		LastLibraryCheck(ctx context.Context) (entity.LibraryCheck, error)
 	}
 )
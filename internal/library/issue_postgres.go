@@ -0,0 +1,95 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// SaveIssues records the problems found with a book. A problem found again
+// keeps the time it was first detected.
+func (bdr *BookDatabaseRepo) SaveIssues(ctx context.Context, bookID string, issues []entity.BookIssue, checkedAt time.Time) error {
+	for _, issue := range issues {
+		_, err := bdr.Conn(ctx).Exec(ctx, `
+			INSERT INTO library_issue (book_id, kind, detail, detected_at, checked_at)
+			VALUES ($1, $2, $3, $4, $4)
+			ON CONFLICT (book_id, kind) DO UPDATE
+			SET detail = EXCLUDED.detail,
+				checked_at = EXCLUDED.checked_at
+		`, bookID, issue.Kind, issue.Detail, checkedAt)
+		if err != nil {
+			return fmt.Errorf("BookDatabaseRepo - SaveIssues - r.Pool.Exec: %w", err)
+		}
+	}
+	return nil
+}
+
+// ResolveIssues removes the problems a complete check no longer found.
+func (bdr *BookDatabaseRepo) ResolveIssues(ctx context.Context, checkedBefore time.Time) error {
+	_, err := bdr.Conn(ctx).Exec(ctx, `DELETE FROM library_issue WHERE checked_at < $1`, checkedBefore)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - ResolveIssues - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (bdr *BookDatabaseRepo) ListIssues(ctx context.Context) ([]entity.BookIssue, error) {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT i.book_id, b.title, i.kind, i.detail, i.detected_at, i.checked_at
+		FROM library_issue i
+		JOIN library_book b ON b.id = i.book_id
+		ORDER BY i.detected_at DESC, b.title
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListIssues - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	issues := make([]entity.BookIssue, 0)
+	for rows.Next() {
+		var issue entity.BookIssue
+		err = rows.Scan(&issue.BookID, &issue.Title, &issue.Kind, &issue.Detail, &issue.DetectedAt, &issue.CheckedAt)
+		if err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - ListIssues - rows.Scan: %w", err)
+		}
+		issues = append(issues, issue)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListIssues - rows.Err: %w", err)
+	}
+	return issues, nil
+}
+
+func (bdr *BookDatabaseRepo) StoreLibraryCheck(ctx context.Context, check entity.LibraryCheck) error {
+	_, err := bdr.Conn(ctx).Exec(ctx, `
+		INSERT INTO library_check (started_at, finished_at, book_count, issue_count)
+		VALUES ($1, $2, $3, $4)
+	`, check.StartedAt, check.FinishedAt, check.Books, check.Issues)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - StoreLibraryCheck - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// LastLibraryCheck returns the latest check, zero when there was none.
+func (bdr *BookDatabaseRepo) LastLibraryCheck(ctx context.Context) (entity.LibraryCheck, error) {
+	var check entity.LibraryCheck
+	err := bdr.Conn(ctx).QueryRow(ctx, `
+		SELECT started_at, finished_at, book_count, issue_count
+		FROM library_check
+		ORDER BY started_at DESC
+		LIMIT 1
+	`).Scan(&check.StartedAt, &check.FinishedAt, &check.Books, &check.Issues)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.LibraryCheck{}, nil
+	}
+	if err != nil {
+		return entity.LibraryCheck{}, fmt.Errorf("BookDatabaseRepo - LastLibraryCheck - row.Scan: %w", err)
+	}
+	return check, nil
+}
@@ -0,0 +1,119 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+// ErrJobLost - the visibility timeout of the job passed and another worker
+// claimed it.
+var ErrJobLost = errors.New("job was claimed by another worker")
+
+// JobDatabaseRepo - the job queue in Postgres, shared by the servers of the
+// database whichever storage the library is kept in.
+type JobDatabaseRepo struct {
+	*postgres.Postgres
+}
+
+// NewJobDatabaseRepo -.
+func NewJobDatabaseRepo(pg *postgres.Postgres) *JobDatabaseRepo {
+	return &JobDatabaseRepo{pg}
+}
+
+const jobColumns = `id, kind, book_id, target, state, attempts, worker, detail, run_at, heartbeat_at, created_at, updated_at`
+
+func scanJob(row pgx.Row) (entity.Job, error) {
+	var j entity.Job
+	err := row.Scan(&j.ID, &j.Kind, &j.BookID, &j.Target, &j.State, &j.Attempts, &j.Worker, &j.Detail, &j.RunAt, &j.HeartbeatAt, &j.CreatedAt, &j.UpdatedAt)
+	return j, err
+}
+
+// EnqueueJob queues the job, false when it is queued or running already.
+// A failed job is queued again with its attempts reset.
+func (r *JobDatabaseRepo) EnqueueJob(ctx context.Context, job entity.Job) (bool, error) {
+	sql := `INSERT INTO library_job (kind, book_id, target)
+		VALUES ($1, $2, $3)
+		ON CONFLICT (kind, book_id, target) DO UPDATE
+		SET state = 'queued', attempts = 0, worker = '', detail = '', run_at = now(), heartbeat_at = NULL, updated_at = now()
+		WHERE library_job.state = 'failed'`
+	tag, err := r.Pool.Exec(ctx, sql, job.Kind, job.BookID, job.Target)
+	if err != nil {
+		return false, fmt.Errorf("JobDatabaseRepo - EnqueueJob - r.Pool.Exec: %w", err)
+	}
+	return tag.RowsAffected() == 1, nil
+}
+
+// ClaimJob hands the oldest due job to worker, false when there is none.
+// Workers skip the rows others are claiming, running jobs without a
+// heartbeat for timeout are due again.
+func (r *JobDatabaseRepo) ClaimJob(ctx context.Context, worker string, timeout time.Duration) (entity.Job, bool, error) {
+	sql := `UPDATE library_job
+		SET state = 'running', worker = $1, attempts = attempts + 1, heartbeat_at = now(), updated_at = now()
+		WHERE id = (
+			SELECT id FROM library_job
+			WHERE (state = 'queued' AND run_at <= now())
+				OR (state = 'running' AND heartbeat_at < now() - make_interval(secs => $2))
+			ORDER BY run_at, id
+			LIMIT 1
+			FOR UPDATE SKIP LOCKED
+		)
+		RETURNING ` + jobColumns
+	job, err := scanJob(r.Pool.QueryRow(ctx, sql, worker, timeout.Seconds()))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.Job{}, false, nil
+	}
+	if err != nil {
+		return entity.Job{}, false, fmt.Errorf("JobDatabaseRepo - ClaimJob - row.Scan: %w", err)
+	}
+	return job, true, nil
+}
+
+// HeartbeatJob keeps the job of worker from being claimed again, ErrJobLost
+// when another worker has it.
+func (r *JobDatabaseRepo) HeartbeatJob(ctx context.Context, id int64, worker string) error {
+	sql := `UPDATE library_job SET heartbeat_at = now()
+		WHERE id = $1 AND worker = $2 AND state = 'running'`
+	return r.update(ctx, "HeartbeatJob", sql, id, worker)
+}
+
+// CompleteJob removes the done job of worker.
+func (r *JobDatabaseRepo) CompleteJob(ctx context.Context, id int64, worker string) error {
+	sql := `DELETE FROM library_job WHERE id = $1 AND worker = $2 AND state = 'running'`
+	return r.update(ctx, "CompleteJob", sql, id, worker)
+}
+
+// RetryJob queues the job of worker again, not before at.
+func (r *JobDatabaseRepo) RetryJob(ctx context.Context, id int64, worker, detail string, at time.Time) error {
+	sql := `UPDATE library_job
+		SET state = 'queued', worker = '', detail = $3, run_at = $4, heartbeat_at = NULL, updated_at = now()
+		WHERE id = $1 AND worker = $2 AND state = 'running'`
+	return r.update(ctx, "RetryJob", sql, id, worker, detail, at)
+}
+
+// FailJob gives up on the job of worker.
+func (r *JobDatabaseRepo) FailJob(ctx context.Context, id int64, worker, detail string) error {
+	sql := `UPDATE library_job
+		SET state = 'failed', detail = $3, heartbeat_at = NULL, updated_at = now()
+		WHERE id = $1 AND worker = $2 AND state = 'running'`
+	return r.update(ctx, "FailJob", sql, id, worker, detail)
+}
+
+// update runs a change of a job the worker holds, ErrJobLost when it
+// holds it no more.
+func (r *JobDatabaseRepo) update(ctx context.Context, method, sql string, args ...interface{}) error {
+	tag, err := r.Pool.Exec(ctx, sql, args...)
+	if err != nil {
+		return fmt.Errorf("JobDatabaseRepo - %s - r.Pool.Exec: %w", method, err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("JobDatabaseRepo - %s - %w", method, ErrJobLost)
+	}
+	return nil
+}
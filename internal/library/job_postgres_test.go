@@ -0,0 +1,84 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/pkg/postgres"
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v4"
+)
+
+var jobColumns = []string{"id", "kind", "book_id", "target", "state", "attempts", "worker", "detail", "run_at", "heartbeat_at", "created_at", "updated_at"}
+
+func TestJobDatabaseRepoClaimJob(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	repo := library.NewJobDatabaseRepo(postgres.Mock(mock))
+	ctx := context.Background()
+	now := time.Now()
+
+	mock.ExpectQuery(`UPDATE library_job\s+SET state = 'running'(.+)FOR UPDATE SKIP LOCKED`).
+		WithArgs("server/1", float64(600)).
+		WillReturnRows(pgxmock.NewRows(jobColumns).
+			AddRow(int64(7), entity.JobConvert, "book", "epub", entity.JobRunning, 1, "server/1", "", now, &now, now, now))
+	job, ok, err := repo.ClaimJob(ctx, "server/1", 10*time.Minute)
+	if err != nil || !ok {
+		t.Fatalf("expected a job, got %v %v", ok, err)
+	}
+	if job.ID != 7 || job.BookID != "book" || job.Target != "epub" || job.Attempts != 1 {
+		t.Errorf("unexpected job %+v", job)
+	}
+
+	mock.ExpectQuery(`UPDATE library_job`).WithArgs("server/2", float64(600)).WillReturnError(pgx.ErrNoRows)
+	if _, ok, err = repo.ClaimJob(ctx, "server/2", 10*time.Minute); err != nil || ok {
+		t.Errorf("expected no job due, got %v %v", ok, err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestJobDatabaseRepoLostJob(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	repo := library.NewJobDatabaseRepo(postgres.Mock(mock))
+	ctx := context.Background()
+
+	mock.ExpectExec(`INSERT INTO library_job(.+)WHERE library_job.state = 'failed'`).
+		WithArgs(entity.JobConvert, "book", "epub").
+		WillReturnResult(pgxmock.NewResult("INSERT", 0))
+	queued, err := repo.EnqueueJob(ctx, entity.Job{Kind: entity.JobConvert, BookID: "book", Target: "epub"})
+	if err != nil || queued {
+		t.Errorf("expected the queued job left alone, got %v %v", queued, err)
+	}
+
+	// another worker claimed the job after its visibility timeout
+	mock.ExpectExec(`UPDATE library_job SET heartbeat_at = now\(\)`).
+		WithArgs(int64(7), "server/1").
+		WillReturnResult(pgxmock.NewResult("UPDATE", 0))
+	if err = repo.HeartbeatJob(ctx, 7, "server/1"); !errors.Is(err, library.ErrJobLost) {
+		t.Errorf("expected ErrJobLost, got %v", err)
+	}
+	mock.ExpectExec(`DELETE FROM library_job`).
+		WithArgs(int64(7), "server/1").
+		WillReturnResult(pgxmock.NewResult("DELETE", 0))
+	if err = repo.CompleteJob(ctx, 7, "server/1"); !errors.Is(err, library.ErrJobLost) {
+		t.Errorf("expected ErrJobLost, got %v", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,178 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+const (
+	// jobAttempts is how often a job is tried before it is failed.
+	jobAttempts = 3
+	// jobBackoff is the wait before the next attempt, times the attempts so far.
+	jobBackoff = time.Minute
+)
+
+// JobOptions - the workers of a server. A job without a heartbeat for
+// Timeout, the visibility timeout, is claimed again by another worker.
+type JobOptions struct {
+	Worker  string // names the server in the jobs it claims
+	Workers int    // 0 runs no jobs on this server
+	Timeout time.Duration
+	Poll    time.Duration // how often idle workers look for due jobs
+}
+
+// DefaultJobOptions are used for the options SetJobQueue is not given.
+var DefaultJobOptions = JobOptions{Worker: "kompanion", Workers: 1, Timeout: 10 * time.Minute, Poll: 5 * time.Second}
+
+// SetJobQueue queues the conversions StartConversions is asked for, the
+// workers of RunJobs on all servers sharing the queue run them.
+func (uc *BookShelf) SetJobQueue(queue JobQueue, options JobOptions) {
+	if options.Worker == "" {
+		options.Worker = DefaultJobOptions.Worker
+	}
+	if options.Workers < 0 {
+		options.Workers = 0
+	}
+	if options.Timeout <= 0 {
+		options.Timeout = DefaultJobOptions.Timeout
+	}
+	if options.Poll <= 0 {
+		options.Poll = DefaultJobOptions.Poll
+	}
+	uc.queue = queue
+	uc.jobOptions = options
+}
+
+// RunJobs runs the workers of the job queue until ctx is done.
+func (uc *BookShelf) RunJobs(ctx context.Context) {
+	if uc.queue == nil {
+		return
+	}
+	var wg sync.WaitGroup
+	for i := 1; i <= uc.jobOptions.Workers; i++ {
+		wg.Add(1)
+		go func(worker string) {
+			defer wg.Done()
+			uc.work(ctx, worker)
+		}(fmt.Sprintf("%s/%d", uc.jobOptions.Worker, i))
+	}
+	wg.Wait()
+}
+
+// work claims and runs jobs until the queue has none due, then polls.
+func (uc *BookShelf) work(ctx context.Context, worker string) {
+	ticker := time.NewTicker(uc.jobOptions.Poll)
+	defer ticker.Stop()
+
+	for {
+		for ctx.Err() == nil && uc.claimJob(ctx, worker) {
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// claimJob runs the next due job, false when there was none.
+func (uc *BookShelf) claimJob(ctx context.Context, worker string) bool {
+	job, ok, err := uc.queue.ClaimJob(ctx, worker, uc.jobOptions.Timeout)
+	if err != nil {
+		if ctx.Err() == nil {
+			uc.logger.Error("BookShelf - claimJob - uc.queue.ClaimJob: %s", err)
+		}
+		return false
+	}
+	if !ok {
+		return false
+	}
+	uc.runJob(ctx, worker, job)
+	return true
+}
+
+// runJob runs a claimed job while sending heartbeats, and completes, retries
+// or fails it. A job lost to another worker is left to that worker.
+func (uc *BookShelf) runJob(ctx context.Context, worker string, job entity.Job) {
+	var err error
+	if job.Attempts > jobAttempts {
+		// the workers running it before stopped sending heartbeats
+		err = fmt.Errorf("gave up after %d attempts", jobAttempts)
+	} else {
+		var lost bool
+		lost, err = uc.withHeartbeat(ctx, worker, job, uc.doJob)
+		if lost {
+			uc.logger.Warn("BookShelf - runJob - job %d was claimed by another worker", job.ID)
+			return
+		}
+	}
+
+	switch {
+	case err == nil:
+		err = uc.queue.CompleteJob(ctx, job.ID, worker)
+	case job.Attempts < jobAttempts && !errors.Is(err, entity.ErrBookNotFound):
+		uc.logger.Warn("BookShelf - runJob - job %d, attempt %d: %s", job.ID, job.Attempts, err)
+		err = uc.queue.RetryJob(ctx, job.ID, worker, err.Error(), time.Now().Add(time.Duration(job.Attempts)*jobBackoff))
+	default:
+		uc.logger.Error("BookShelf - runJob - job %d failed: %s", job.ID, err)
+		err = uc.queue.FailJob(ctx, job.ID, worker, err.Error())
+	}
+	if err != nil && ctx.Err() == nil {
+		uc.logger.Error("BookShelf - runJob - job %d: %s", job.ID, err)
+	}
+}
+
+// withHeartbeat runs fn with heartbeats for the job every third of the
+// visibility timeout. fn is canceled and true returned when another worker
+// claimed the job.
+func (uc *BookShelf) withHeartbeat(ctx context.Context, worker string, job entity.Job,
+	fn func(context.Context, entity.Job) error) (bool, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	beats := make(chan bool, 1)
+	go func() {
+		ticker := time.NewTicker(uc.jobOptions.Timeout / 3)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				beats <- false
+				return
+			case <-ticker.C:
+				err := uc.queue.HeartbeatJob(ctx, job.ID, worker)
+				if errors.Is(err, ErrJobLost) {
+					cancel()
+					<-done
+					beats <- true
+					return
+				}
+				if err != nil && ctx.Err() == nil {
+					uc.logger.Error("BookShelf - withHeartbeat - uc.queue.HeartbeatJob: %s", err)
+				}
+			}
+		}
+	}()
+	err := fn(ctx, job)
+	close(done)
+	return <-beats, err
+}
+
+// doJob runs the job by its kind.
+func (uc *BookShelf) doJob(ctx context.Context, job entity.Job) error {
+	switch job.Kind {
+	case entity.JobConvert:
+		err := uc.convertBook(ctx, job.BookID, job.Target)
+		if errors.Is(err, ErrFormatExists) {
+			return nil
+		}
+		return err
+	default:
+		return fmt.Errorf("unknown job kind %q", job.Kind)
+	}
+}
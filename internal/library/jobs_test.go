@@ -0,0 +1,251 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+const convertedFB2 = `<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>Manual</book-title></title-info></description><body><p>converted</p></body></FictionBook>`
+
+func TestConversionJobsSharedByServers(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	repo := library.NewMemoryBookRepo()
+	queue := &memoryJobQueue{}
+	converter := &jobConverter{content: convertedFB2}
+	storeJobBook(t, store, repo)
+
+	var servers []*library.BookShelf
+	for _, name := range []string{"a", "b"} {
+		shelf := library.NewBookShelf(store, repo, logger.New("error"))
+		shelf.SetConverter(converter)
+		shelf.SetJobQueue(queue, library.JobOptions{Worker: name, Workers: 2, Timeout: time.Minute})
+		servers = append(servers, shelf)
+	}
+
+	devices := map[string][]string{"pocketbook": {"fb2"}}
+	report, err := servers[0].FormatReport(ctx, devices)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for i, want := range []int{1, 0} {
+		queued, err := servers[i].StartConversions(ctx, report)
+		if err != nil || queued != want {
+			t.Fatalf("server %d: expected %d conversions queued, got %d %v", i, want, queued, err)
+		}
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	var wg sync.WaitGroup
+	for _, shelf := range servers {
+		wg.Add(1)
+		go func(shelf *library.BookShelf) {
+			defer wg.Done()
+			shelf.RunJobs(runCtx)
+		}(shelf)
+	}
+	waitFor(t, func() bool { return queue.completed.Load() == 1 })
+	cancel()
+	wg.Wait()
+
+	if n := converter.calls.Load(); n != 1 {
+		t.Errorf("expected the book converted once, got %d", n)
+	}
+	if report, err = servers[1].FormatReport(ctx, devices); err != nil || len(report.Books) != 0 {
+		t.Errorf("expected the manual converted, got %+v %v", report.Books, err)
+	}
+}
+
+func TestConversionJobRetriedAndLost(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	repo := library.NewMemoryBookRepo()
+	queue := &memoryJobQueue{}
+	converter := &jobConverter{err: errors.New("ebook-convert crashed")}
+	storeJobBook(t, store, repo)
+	shelf := library.NewBookShelf(store, repo, logger.New("error"))
+	shelf.SetConverter(converter)
+	shelf.SetJobQueue(queue, library.JobOptions{Worker: "a", Workers: 1, Timeout: 300 * time.Millisecond, Poll: 10 * time.Millisecond})
+
+	report, err := shelf.FormatReport(ctx, map[string][]string{"pocketbook": {"fb2"}})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = shelf.StartConversions(ctx, report); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	runCtx, cancel := context.WithCancel(ctx)
+	done := make(chan struct{})
+	go func() {
+		shelf.RunJobs(runCtx)
+		close(done)
+	}()
+	waitFor(t, func() bool { return queue.job(1).Detail != "" })
+	job := queue.job(1)
+	if job.State != entity.JobQueued || job.Attempts != 1 || !job.RunAt.After(time.Now()) ||
+		!strings.Contains(job.Detail, "ebook-convert crashed") {
+		t.Errorf("expected the job queued again later, got %+v", job)
+	}
+
+	// a worker whose heartbeats fail lets go of the job
+	converter.err = nil
+	converter.delay = time.Second
+	queue.lost.Store(true)
+	queue.reclaim(1)
+	waitFor(t, func() bool { return converter.canceled.Load() })
+	cancel()
+	<-done
+	if job = queue.job(1); job.State != entity.JobRunning || queue.completed.Load() != 0 {
+		t.Errorf("expected the job left to the other worker, got %+v", job)
+	}
+}
+
+func storeJobBook(t *testing.T, store storage.Storage, repo library.BookRepo) {
+	book := entity.Book{ID: "manual", Title: "Manual", FilePath: "manual.pdf", DocumentID: "pdf", CreatedAt: time.Now()}
+	if err := repo.Store(context.Background(), book); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put(context.Background(), book.FilePath, strings.NewReader("%PDF-1.4 manual")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// jobConverter counts the conversions, which take delay and fail with err.
+type jobConverter struct {
+	content  string
+	err      error
+	delay    time.Duration
+	calls    atomic.Int32
+	canceled atomic.Bool
+}
+
+func (c *jobConverter) Convert(ctx context.Context, source, format string) (string, error) {
+	c.calls.Add(1)
+	select {
+	case <-ctx.Done():
+		c.canceled.Store(true)
+		return "", ctx.Err()
+	case <-time.After(c.delay):
+	}
+	if c.err != nil {
+		return "", c.err
+	}
+	return fakeConverter{content: c.content}.Convert(ctx, source, format)
+}
+
+// memoryJobQueue claims like JobDatabaseRepo, lost makes heartbeats tell
+// that another worker has the job.
+type memoryJobQueue struct {
+	mu        sync.Mutex
+	jobs      []entity.Job
+	lost      atomic.Bool
+	completed atomic.Int32
+}
+
+func (q *memoryJobQueue) EnqueueJob(_ context.Context, job entity.Job) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for _, j := range q.jobs {
+		if j.Kind == job.Kind && j.BookID == job.BookID && j.Target == job.Target {
+			return false, nil
+		}
+	}
+	job.ID = int64(len(q.jobs) + 1)
+	job.State = entity.JobQueued
+	job.RunAt = time.Now()
+	q.jobs = append(q.jobs, job)
+	return true, nil
+}
+
+func (q *memoryJobQueue) ClaimJob(_ context.Context, worker string, timeout time.Duration) (entity.Job, bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	now := time.Now()
+	for i, j := range q.jobs {
+		due := j.State == entity.JobQueued && !j.RunAt.After(now) ||
+			j.State == entity.JobRunning && j.HeartbeatAt.Before(now.Add(-timeout))
+		if due {
+			j.State, j.Worker, j.HeartbeatAt = entity.JobRunning, worker, &now
+			j.Attempts++
+			q.jobs[i] = j
+			return j, true, nil
+		}
+	}
+	return entity.Job{}, false, nil
+}
+
+func (q *memoryJobQueue) HeartbeatJob(_ context.Context, id int64, worker string) error {
+	if q.lost.Load() {
+		return library.ErrJobLost
+	}
+	return q.update(id, worker, func(j *entity.Job) {
+		now := time.Now()
+		j.HeartbeatAt = &now
+	})
+}
+
+func (q *memoryJobQueue) CompleteJob(_ context.Context, id int64, worker string) error {
+	err := q.update(id, worker, func(j *entity.Job) { j.State = "done" })
+	if err == nil {
+		q.completed.Add(1)
+	}
+	return err
+}
+
+func (q *memoryJobQueue) RetryJob(_ context.Context, id int64, worker, detail string, at time.Time) error {
+	return q.update(id, worker, func(j *entity.Job) {
+		j.State, j.Worker, j.Detail, j.RunAt = entity.JobQueued, "", detail, at
+	})
+}
+
+func (q *memoryJobQueue) FailJob(_ context.Context, id int64, worker, detail string) error {
+	return q.update(id, worker, func(j *entity.Job) { j.State, j.Detail = entity.JobFailed, detail })
+}
+
+func (q *memoryJobQueue) update(id int64, worker string, change func(*entity.Job)) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	for i := range q.jobs {
+		if q.jobs[i].ID == id && q.jobs[i].Worker == worker && q.jobs[i].State == entity.JobRunning {
+			change(&q.jobs[i])
+			return nil
+		}
+	}
+	return library.ErrJobLost
+}
+
+func (q *memoryJobQueue) job(id int64) entity.Job {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.jobs[id-1]
+}
+
+// reclaim makes the job due now.
+func (q *memoryJobQueue) reclaim(id int64) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.jobs[id-1].RunAt = time.Now()
+}
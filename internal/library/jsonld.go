@@ -0,0 +1,133 @@
+package library
+
+import (
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/richtext"
+)
+
+// SchemaBook is the schema.org description of a book, served as JSON-LD so
+// search engines and link previews understand the book pages.
+type SchemaBook struct {
+	Context         string            `json:"@context"`
+	Type            string            `json:"@type"`
+	ID              string            `json:"@id,omitempty"`
+	URL             string            `json:"url,omitempty"`
+	Name            string            `json:"name"`
+	Author          *SchemaThing      `json:"author,omitempty"`
+	Publisher       *SchemaThing      `json:"publisher,omitempty"`
+	DatePublished   string            `json:"datePublished,omitempty"`
+	ISBN            string            `json:"isbn,omitempty"`
+	InLanguage      string            `json:"inLanguage,omitempty"`
+	Description     string            `json:"description,omitempty"`
+	Image           string            `json:"image,omitempty"`
+	BookFormat      string            `json:"bookFormat,omitempty"`
+	NumberOfPages   int               `json:"numberOfPages,omitempty"`
+	Duration        string            `json:"duration,omitempty"`
+	Genre           []string          `json:"genre,omitempty"`
+	IsPartOf        *SchemaThing      `json:"isPartOf,omitempty"`
+	Position        string            `json:"position,omitempty"`
+	AggregateRating *SchemaRating     `json:"aggregateRating,omitempty"`
+	Encoding        []SchemaMediaFile `json:"encoding,omitempty"`
+}
+
+// SchemaThing is a named schema.org entity: a person, an organization or
+// a book series.
+type SchemaThing struct {
+	Type string `json:"@type"`
+	Name string `json:"name"`
+}
+
+type SchemaRating struct {
+	Type        string  `json:"@type"`
+	RatingValue float64 `json:"ratingValue"`
+	RatingCount int     `json:"ratingCount"`
+	BestRating  int     `json:"bestRating"`
+	WorstRating int     `json:"worstRating"`
+}
+
+type SchemaMediaFile struct {
+	Type           string `json:"@type"`
+	EncodingFormat string `json:"encodingFormat"`
+	ContentSize    string `json:"contentSize,omitempty"`
+}
+
+// NewSchemaBook describes book for the page at pageURL, coverURL is the
+// absolute URL of its cover and empty when there is none.
+func NewSchemaBook(book entity.Book, pageURL, coverURL string) SchemaBook {
+	s := SchemaBook{
+		Context:       "https://schema.org",
+		Type:          "Book",
+		ID:            pageURL,
+		URL:           pageURL,
+		Name:          book.Title,
+		ISBN:          book.ISBN,
+		InLanguage:    book.Language,
+		Description:   richtext.PlainText(book.Description),
+		Image:         coverURL,
+		BookFormat:    "https://schema.org/EBook",
+		NumberOfPages: book.Pages,
+		Genre:         book.Genres,
+	}
+	if book.IsAudiobook() {
+		s.Type = "Audiobook"
+		s.BookFormat = "https://schema.org/AudiobookFormat"
+		s.NumberOfPages = 0
+		s.Duration = isoDuration(book.Duration)
+	}
+	if book.Author != "" {
+		s.Author = &SchemaThing{Type: "Person", Name: book.Author}
+	}
+	if book.Publisher != "" {
+		s.Publisher = &SchemaThing{Type: "Organization", Name: book.Publisher}
+	}
+	if book.Year > 0 {
+		s.DatePublished = strconv.Itoa(book.Year)
+	}
+	if book.Series != "" {
+		s.IsPartOf = &SchemaThing{Type: "BookSeries", Name: book.Series}
+		if book.SeriesIndex != nil && book.SeriesIndex.Valid {
+			s.Position = book.SeriesIndex.Decimal.String()
+		}
+	}
+	if book.RatingCount > 0 {
+		s.AggregateRating = &SchemaRating{
+			Type:        "AggregateRating",
+			RatingValue: book.Rating,
+			RatingCount: book.RatingCount,
+			BestRating:  5,
+			WorstRating: 1,
+		}
+	}
+	if mime := book.MimeType(); mime != "" {
+		file := SchemaMediaFile{Type: "MediaObject", EncodingFormat: mime}
+		if book.FileSize > 0 {
+			file.ContentSize = strconv.FormatInt(book.FileSize, 10) + " B"
+		}
+		s.Encoding = []SchemaMediaFile{file}
+	}
+	return s
+}
+
+// isoDuration formats d as an ISO 8601 duration, e.g. PT9H41M.
+func isoDuration(d time.Duration) string {
+	if d <= 0 {
+		return ""
+	}
+	d = d.Round(time.Second)
+	h, m, s := int(d.Hours()), int(d.Minutes())%60, int(d.Seconds())%60
+	out := "PT"
+	if h > 0 {
+		out += fmt.Sprintf("%dH", h)
+	}
+	if m > 0 {
+		out += fmt.Sprintf("%dM", m)
+	}
+	if s > 0 {
+		out += fmt.Sprintf("%dS", s)
+	}
+	return out
+}
@@ -0,0 +1,40 @@
+package library_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+)
+
+func TestNewSchemaBook(t *testing.T) {
+	index := decimal.NewNullDecimal(decimal.RequireFromString("2.5"))
+	book := entity.Book{
+		ID:          "book-id",
+		Title:       "The Hobbit",
+		Author:      "J. R. R. Tolkien",
+		Year:        1937,
+		Series:      "Middle-earth",
+		SeriesIndex: &index,
+		Duration:    9*time.Hour + 41*time.Minute,
+		MediaType:   entity.MediaTypeAudiobook,
+		FilePath:    "2024/01/01/book-id.m4b",
+	}
+
+	s := library.NewSchemaBook(book, "https://example.com/p/book-id", "")
+	if s.Type != "Audiobook" || s.Duration != "PT9H41M" {
+		t.Errorf("expected an audiobook of PT9H41M, got %s of %q", s.Type, s.Duration)
+	}
+	if s.Author == nil || s.Author.Name != "J. R. R. Tolkien" {
+		t.Errorf("unexpected author %+v", s.Author)
+	}
+	if s.IsPartOf == nil || s.IsPartOf.Name != "Middle-earth" || s.Position != "2.5" {
+		t.Errorf("unexpected series %+v at %q", s.IsPartOf, s.Position)
+	}
+	if s.DatePublished != "1937" || s.Image != "" || s.Publisher != nil {
+		t.Errorf("unexpected schema %+v", s)
+	}
+}
@@ -0,0 +1,170 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// MaxLoanDays is the longest a book is lent for.
+const MaxLoanDays = 90
+
+// AddLoanHook tells hook about every loan started and ended from now on.
+func (uc *BookShelf) AddLoanHook(hook LoanHook) {
+	uc.loanHooks = append(uc.loanHooks, hook)
+}
+
+// LendBook lets borrower see a private book username uploaded for days,
+// in the library, OPDS and WebDAV, until the loan expires or either
+// account ends it.
+func (uc *BookShelf) LendBook(ctx context.Context, username, bookID, borrower string, days int) (entity.Loan, error) {
+	if days < 1 || days > MaxLoanDays || borrower == "" || borrower == username {
+		return entity.Loan{}, fmt.Errorf("BookShelf - LendBook - %w", entity.ErrInvalidLoan)
+	}
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return entity.Loan{}, fmt.Errorf("BookShelf - LendBook - s.getBook: %w", err)
+	}
+	if book.UploadedBy != username {
+		return entity.Loan{}, fmt.Errorf("BookShelf - LendBook - %w", entity.ErrForbidden)
+	}
+	if !book.Private {
+		return entity.Loan{}, fmt.Errorf("BookShelf - LendBook - %w", entity.ErrLoanShared)
+	}
+
+	loan := entity.Loan{
+		BookID:    book.ID,
+		Lender:    username,
+		Borrower:  borrower,
+		ExpiresAt: time.Now().Add(time.Duration(days) * 24 * time.Hour),
+	}
+	loan, err = uc.repo.CreateLoan(ctx, loan)
+	if err != nil {
+		return entity.Loan{}, fmt.Errorf("BookShelf - LendBook - s.repo.CreateLoan: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	for _, hook := range uc.loanHooks {
+		if err := hook.BookLent(ctx, loan, book); err != nil {
+			uc.logger.Error("BookShelf - LendBook - %s: %s", book.ID, err)
+		}
+	}
+	return loan, nil
+}
+
+// Loans lists the books username lent or borrowed that are still lent.
+func (uc *BookShelf) Loans(ctx context.Context, username string) ([]entity.Loan, error) {
+	loans, err := uc.repo.ListLoans(ctx, username)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - Loans - s.repo.ListLoans: %w", err)
+	}
+	return loans, nil
+}
+
+// EndLoan hands a book back before the loan expires, the lender and the
+// borrower may end it.
+func (uc *BookShelf) EndLoan(ctx context.Context, username, loanID string) error {
+	loan, err := uc.repo.GetLoan(ctx, loanID)
+	if err != nil {
+		return fmt.Errorf("BookShelf - EndLoan - s.repo.GetLoan: %w", err)
+	}
+	if loan.Lender != username && loan.Borrower != username {
+		return fmt.Errorf("BookShelf - EndLoan - %w", entity.ErrLoanNotFound)
+	}
+	now := time.Now()
+	if err = uc.repo.EndLoan(ctx, loan.ID, now); err != nil {
+		return fmt.Errorf("BookShelf - EndLoan - s.repo.EndLoan: %w", err)
+	}
+	loan.EndedAt = &now
+	uc.libraryChanged(ctx)
+	uc.loansEnded(ctx, []entity.Loan{loan})
+	return nil
+}
+
+// EndExpiredLoans ends the loans that expired and tells the loan hooks,
+// the books are hidden from the borrowers already.
+func (uc *BookShelf) EndExpiredLoans(ctx context.Context) (int, error) {
+	loans, err := uc.repo.EndExpiredLoans(ctx, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("BookShelf - EndExpiredLoans - s.repo.EndExpiredLoans: %w", err)
+	}
+	if len(loans) > 0 {
+		uc.libraryChanged(ctx)
+		uc.loansEnded(ctx, loans)
+	}
+	return len(loans), nil
+}
+
+// ScheduleEndExpiredLoans ends expired loans every interval until ctx is
+// done.
+func (uc *BookShelf) ScheduleEndExpiredLoans(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := uc.EndExpiredLoans(ctx); err != nil {
+				uc.logger.Error("BookShelf - ScheduleEndExpiredLoans - uc.EndExpiredLoans: %s", err)
+			}
+		}
+	}
+}
+
+// loansEnded runs the loan hooks, their errors are logged.
+func (uc *BookShelf) loansEnded(ctx context.Context, loans []entity.Loan) {
+	if len(uc.loanHooks) == 0 {
+		return
+	}
+	for _, loan := range loans {
+		book, err := uc.repo.GetById(ctx, loan.BookID)
+		if err != nil {
+			uc.logger.Error("BookShelf - loansEnded - s.repo.GetById: %s", err)
+			continue
+		}
+		for _, hook := range uc.loanHooks {
+			if err := hook.LoanEnded(ctx, loan, book); err != nil {
+				uc.logger.Error("BookShelf - loansEnded - %s: %s", book.ID, err)
+			}
+		}
+	}
+}
+
+// canSee returns whether the reader of the request may see a book, the
+// books lent to the reader are looked up once.
+func (uc *BookShelf) canSee(ctx context.Context) func(entity.Book) bool {
+	var borrowed map[string]bool
+	return func(book entity.Book) bool {
+		if visible(ctx, book) {
+			return true
+		}
+		username, _ := ReaderFrom(ctx)
+		if username == "" || (book.Scheduled(time.Now()) && !seesScheduled(ctx)) {
+			return false
+		}
+		if borrowed == nil {
+			borrowed = uc.borrowedBooks(ctx, username)
+		}
+		return borrowed[book.ID]
+	}
+}
+
+// borrowedBooks returns the ids of the books lent to username, none when
+// they can not be read.
+func (uc *BookShelf) borrowedBooks(ctx context.Context, username string) map[string]bool {
+	borrowed := make(map[string]bool)
+	loans, err := uc.repo.ListLoans(ctx, username)
+	if err != nil {
+		uc.logger.Error("BookShelf - borrowedBooks - s.repo.ListLoans: %s", err)
+	}
+	now := time.Now()
+	for _, loan := range loans {
+		if loan.Borrower == username && loan.Active(now) {
+			borrowed[loan.BookID] = true
+		}
+	}
+	return borrowed
+}
@@ -0,0 +1,121 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+const loanColumns = `l.id, l.book_id, b.title, l.lender, l.borrower, l.created_at, l.expires_at, l.ended_at`
+
+func scanLoan(row pgx.Row) (entity.Loan, error) {
+	var l entity.Loan
+	err := row.Scan(&l.ID, &l.BookID, &l.Title, &l.Lender, &l.Borrower, &l.CreatedAt, &l.ExpiresAt, &l.EndedAt)
+	return l, err
+}
+
+func scanLoans(rows pgx.Rows) ([]entity.Loan, error) {
+	defer rows.Close()
+	loans := make([]entity.Loan, 0)
+	for rows.Next() {
+		loan, err := scanLoan(rows)
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, loan)
+	}
+	return loans, rows.Err()
+}
+
+func (bdr *BookDatabaseRepo) CreateLoan(ctx context.Context, loan entity.Loan) (entity.Loan, error) {
+	loan, err := scanLoan(bdr.Conn(ctx).QueryRow(ctx, `
+		WITH l AS (
+			INSERT INTO library_book_loan (book_id, lender, borrower, expires_at)
+			VALUES ($1, $2, $3, $4)
+			RETURNING *
+		)
+		SELECT `+loanColumns+`
+		FROM l JOIN library_book b ON b.id = l.book_id`,
+		loan.BookID, loan.Lender, loan.Borrower, loan.ExpiresAt))
+	if err != nil {
+		return entity.Loan{}, fmt.Errorf("BookDatabaseRepo - CreateLoan - row.Scan: %w", err)
+	}
+	return loan, nil
+}
+
+func (bdr *BookDatabaseRepo) GetLoan(ctx context.Context, id string) (entity.Loan, error) {
+	loan, err := scanLoan(bdr.Conn(ctx).QueryRow(ctx, `
+		SELECT `+loanColumns+`
+		FROM library_book_loan l JOIN library_book b ON b.id = l.book_id
+		WHERE l.id = $1
+	`, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.Loan{}, entity.ErrLoanNotFound
+	}
+	if err != nil {
+		return entity.Loan{}, fmt.Errorf("BookDatabaseRepo - GetLoan - row.Scan: %w", err)
+	}
+	return loan, nil
+}
+
+// ListLoans returns the loans username lent or borrowed that have not
+// ended, soonest to expire first.
+func (bdr *BookDatabaseRepo) ListLoans(ctx context.Context, username string) ([]entity.Loan, error) {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT `+loanColumns+`
+		FROM library_book_loan l JOIN library_book b ON b.id = l.book_id
+		WHERE (l.lender = $1 OR l.borrower = $1) AND l.ended_at IS NULL
+		ORDER BY l.expires_at, l.id
+	`, username)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListLoans - r.Pool.Query: %w", err)
+	}
+	loans, err := scanLoans(rows)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListLoans - rows.Scan: %w", err)
+	}
+	return loans, nil
+}
+
+// EndLoan ends a loan that has not ended yet.
+func (bdr *BookDatabaseRepo) EndLoan(ctx context.Context, id string, endedAt time.Time) error {
+	tag, err := bdr.Conn(ctx).Exec(ctx, `
+		UPDATE library_book_loan SET ended_at = $2
+		WHERE id = $1 AND ended_at IS NULL
+	`, id, endedAt)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - EndLoan - r.Pool.Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return fmt.Errorf("BookDatabaseRepo - EndLoan - %w", entity.ErrLoanNotFound)
+	}
+	return nil
+}
+
+// EndExpiredLoans ends the loans that expired before now and returns them,
+// each loan is returned once.
+func (bdr *BookDatabaseRepo) EndExpiredLoans(ctx context.Context, now time.Time) ([]entity.Loan, error) {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		WITH l AS (
+			UPDATE library_book_loan SET ended_at = expires_at
+			WHERE ended_at IS NULL AND expires_at <= $1
+			RETURNING *
+		)
+		SELECT `+loanColumns+`
+		FROM l JOIN library_book b ON b.id = l.book_id
+		ORDER BY l.expires_at, l.id
+	`, now)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - EndExpiredLoans - r.Pool.Query: %w", err)
+	}
+	loans, err := scanLoans(rows)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - EndExpiredLoans - rows.Scan: %w", err)
+	}
+	return loans, nil
+}
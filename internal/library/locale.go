@@ -0,0 +1,97 @@
+package library
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+type localeKey struct{}
+
+var localePattern = regexp.MustCompile(`^[a-z]{2,3}$`)
+
+// leadingArticles are ignored when titles are sorted for a locale.
+// Elided forms end with an apostrophe and need no space after them.
+var leadingArticles = map[string][]string{
+	"en": {"the", "a", "an"},
+	"de": {"der", "die", "das", "ein", "eine"},
+	"fr": {"le", "la", "les", "un", "une", "l'"},
+	"es": {"el", "la", "los", "las", "un", "una"},
+	"it": {"il", "lo", "la", "i", "gli", "le", "un", "uno", "una", "l'"},
+	"pt": {"o", "a", "os", "as", "um", "uma"},
+	"nl": {"de", "het", "een"},
+}
+
+// WithLocale sets the locale used to sort and search for one request.
+// Anything that is not a plain language subtag after normalization is ignored.
+func WithLocale(ctx context.Context, locale string) context.Context {
+	locale = normalizeLanguage(locale)
+	if !localePattern.MatchString(locale) {
+		return ctx
+	}
+	return context.WithValue(ctx, localeKey{}, locale)
+}
+
+// LocaleFrom returns the request locale, empty when the client sent none.
+func LocaleFrom(ctx context.Context) string {
+	locale, _ := ctx.Value(localeKey{}).(string)
+	return locale
+}
+
+// StripArticle removes a leading article of the locale, "The Hobbit" sorts as "Hobbit".
+// It must agree with articlePattern used by the database.
+func StripArticle(locale, title string) string {
+	for _, article := range leadingArticles[locale] {
+		rest, ok := cutPrefixFold(title, article)
+		if !ok {
+			continue
+		}
+		if strings.HasSuffix(article, "'") {
+			return rest
+		}
+		trimmed := strings.TrimLeft(rest, " \t\n\r\f\v")
+		if trimmed != rest {
+			return trimmed
+		}
+	}
+	return title
+}
+
+// cutPrefixFold cuts prefix off s ignoring case. The prefix of s is compared
+// rune by rune, case changes may change the length of s in bytes.
+func cutPrefixFold(s, prefix string) (string, bool) {
+	end := 0
+	for range prefix {
+		if end == len(s) {
+			return s, false
+		}
+		_, size := utf8.DecodeRuneInString(s[end:])
+		end += size
+	}
+	if !strings.EqualFold(s[:end], prefix) {
+		return s, false
+	}
+	return s[end:], true
+}
+
+// articlePattern is the case-insensitive regexp_replace pattern for StripArticle.
+func articlePattern(locale string) string {
+	articles := leadingArticles[locale]
+	if len(articles) == 0 {
+		return ""
+	}
+	var words, elided []string
+	for _, article := range articles {
+		if strings.HasSuffix(article, "'") {
+			elided = append(elided, article)
+		} else {
+			words = append(words, article)
+		}
+	}
+	pattern := `^(?:(?:` + strings.Join(words, "|") + `)\s+`
+	if len(elided) > 0 {
+		pattern += `|(?:` + strings.Join(elided, "|") + `)`
+	}
+	return pattern + `)`
+}
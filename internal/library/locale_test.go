@@ -0,0 +1,43 @@
+package library_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/library"
+)
+
+func TestStripArticle(t *testing.T) {
+	tests := []struct {
+		locale, title, want string
+	}{
+		{"en", "The Hobbit", "Hobbit"},
+		{"en", "An Apple", "Apple"},
+		{"en", "Theory of Everything", "Theory of Everything"},
+		{"fr", "Les Misérables", "Misérables"},
+		{"fr", "L'Étranger", "Étranger"},
+		{"de", "Der Prozess", "Prozess"},
+		{"en", "THE HOBBIT", "HOBBIT"},
+		// İ lowercases to two runes, the title is cut at its own runes
+		{"it", "İ Promessi Sposi", "İ Promessi Sposi"},
+		{"it", "Il Gattopardo", "Gattopardo"},
+		{"", "The Hobbit", "The Hobbit"},
+	}
+	for _, tt := range tests {
+		if got := library.StripArticle(tt.locale, tt.title); got != tt.want {
+			t.Errorf("StripArticle(%q, %q) = %q, want %q", tt.locale, tt.title, got, tt.want)
+		}
+	}
+}
+
+func TestWithLocaleNormalizesTag(t *testing.T) {
+	ctx := library.WithLocale(context.Background(), "de-AT")
+	if got := library.LocaleFrom(ctx); got != "de" {
+		t.Errorf("expected de, got %q", got)
+	}
+
+	ctx = library.WithLocale(context.Background(), "x'; DROP TABLE")
+	if got := library.LocaleFrom(ctx); got != "" {
+		t.Errorf("expected invalid locale to be ignored, got %q", got)
+	}
+}
@@ -0,0 +1,254 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/bookmeta"
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/metadata"
+	"github.com/banjuer/kompanion/pkg/richtext"
+)
+
+const maintenancePageSize = 100
+
+// BookProblem is a book a maintenance run could not handle.
+type BookProblem struct {
+	BookID  string
+	Title   string
+	Problem string
+}
+
+// MaintenanceReport sums up a maintenance run over the whole library.
+type MaintenanceReport struct {
+	Books    int
+	Changed  int
+	Problems []BookProblem
+}
+
+func (r *MaintenanceReport) problem(book entity.Book, format string, args ...interface{}) {
+	r.Problems = append(r.Problems, BookProblem{BookID: book.ID, Title: book.Title, Problem: fmt.Sprintf(format, args...)})
+}
+
+// RebuildCovers extracts covers from the book files again, falling back to
+// the metadata provider. Only books without a readable cover are handled
+// unless all is set, archived books are skipped.
+func (uc *BookShelf) RebuildCovers(ctx context.Context, all bool) (MaintenanceReport, error) {
+	var report MaintenanceReport
+	err := uc.forEachBook(ctx, func(book entity.Book) error {
+		report.Books++
+		if book.Archived() {
+			return nil
+		}
+		if !all && !uc.bookNeedsCover(ctx, book) {
+			return nil
+		}
+		m, err := uc.extractMetadata(ctx, book)
+		if err != nil {
+			report.problem(book, "%s", err)
+			return nil
+		}
+		cover := m.Cover
+		if len(cover) == 0 {
+			_, cover = uc.enrichBookMetadata(ctx, book, nil)
+		}
+		if len(cover) == 0 {
+			cover = uc.fetchCover(ctx, book)
+		}
+		if len(cover) == 0 {
+			report.problem(book, "no cover in the file or from the metadata provider")
+			return nil
+		}
+
+		oldCoverPath := book.CoverPath
+		book.CoverPath, err = writeCover(ctx, uc.storage, cover)
+		if err != nil {
+			return fmt.Errorf("book %s: %w", book.ID, err)
+		}
+		book.CoverSize = int64(len(cover))
+		// a new updated_at also drops the resized covers from the cache
+		book.UpdatedAt = time.Now()
+		if err = uc.repo.Update(ctx, book); err != nil {
+			return fmt.Errorf("book %s: s.repo.Update: %w", book.ID, err)
+		}
+		if oldCoverPath != book.CoverPath {
+			uc.releaseCover(ctx, oldCoverPath)
+		}
+		report.Changed++
+		return nil
+	})
+	if report.Changed > 0 {
+		uc.libraryChanged(ctx)
+	}
+	if err != nil {
+		return report, fmt.Errorf("BookShelf - RebuildCovers - %w", err)
+	}
+	return report, nil
+}
+
+// DedupCovers moves covers stored per book to their content address, so
+// books with identical covers share one file, and records the size of
+// covers stored before sizes were kept. Archived books are skipped.
+func (uc *BookShelf) DedupCovers(ctx context.Context) (MaintenanceReport, error) {
+	var report MaintenanceReport
+	err := uc.forEachBook(ctx, func(book entity.Book) error {
+		report.Books++
+		if book.Archived() || book.CoverPath == "" {
+			return nil
+		}
+		file, err := uc.storage.Open(ctx, book.CoverPath)
+		if err != nil {
+			report.problem(book, "cover %s: %s", book.CoverPath, err)
+			return nil
+		}
+		cover, err := io.ReadAll(file)
+		file.Close()
+		if err != nil {
+			report.problem(book, "cover %s: %s", book.CoverPath, err)
+			return nil
+		}
+		if coverPath(cover) == book.CoverPath {
+			if book.CoverSize == int64(len(cover)) {
+				return nil
+			}
+			book.CoverSize = int64(len(cover))
+			if err = uc.repo.Update(ctx, book); err != nil {
+				return fmt.Errorf("book %s: s.repo.Update: %w", book.ID, err)
+			}
+			report.Changed++
+			return nil
+		}
+
+		oldCoverPath := book.CoverPath
+		book.CoverPath, err = writeCover(ctx, uc.storage, cover)
+		if err != nil {
+			return fmt.Errorf("book %s: %w", book.ID, err)
+		}
+		book.CoverSize = int64(len(cover))
+		if err = uc.repo.Update(ctx, book); err != nil {
+			return fmt.Errorf("book %s: s.repo.Update: %w", book.ID, err)
+		}
+		uc.releaseCover(ctx, oldCoverPath)
+		report.Changed++
+		return nil
+	})
+	if report.Changed > 0 {
+		uc.libraryChanged(ctx)
+	}
+	if err != nil {
+		return report, fmt.Errorf("BookShelf - DedupCovers - %w", err)
+	}
+	return report, nil
+}
+
+// RescanMetadata reads the metadata of every book file again and fills the
+// fields that are empty, edited fields and archived books are kept.
+func (uc *BookShelf) RescanMetadata(ctx context.Context) (MaintenanceReport, error) {
+	var report MaintenanceReport
+	err := uc.forEachBook(ctx, func(book entity.Book) error {
+		report.Books++
+		if book.Archived() {
+			return nil
+		}
+		m, err := uc.extractMetadata(ctx, book)
+		if err != nil {
+			report.problem(book, "%s", err)
+			return nil
+		}
+		scanned := entity.Book{
+			Title:       m.Title,
+			Author:      m.Author,
+			Description: richtext.Sanitize(m.Description),
+			Publisher:   m.Publisher,
+			ISBN:        normalizeISBN(m.ISBN),
+			DOI:         metadata.NormalizeDOI(m.DOI),
+			Series:      m.Series,
+			SeriesIndex: parseSeriesIndex(m.SeriesIndex),
+		}
+		updated := bookmeta.MergeMissingBookMetadata(book, scanned)
+		if updated.Language == "" {
+			updated.Language = metadata.NormalizeLanguage(m.Language)
+		}
+		if reflect.DeepEqual(updated, book) {
+			return nil
+		}
+
+		updated.UpdatedAt = time.Now()
+		if err = uc.repo.Update(ctx, updated); err != nil {
+			return fmt.Errorf("book %s: s.repo.Update: %w", book.ID, err)
+		}
+		uc.libraryChanged(ctx)
+		report.Changed++
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("BookShelf - RescanMetadata - %w", err)
+	}
+	return report, nil
+}
+
+// Reindex rebuilds the indexes searching and sorting the library use.
+func (uc *BookShelf) Reindex(ctx context.Context) error {
+	if err := uc.repo.Reindex(ctx); err != nil {
+		return fmt.Errorf("BookShelf - Reindex - s.repo.Reindex: %w", err)
+	}
+	return nil
+}
+
+// forEachBook walks the whole library in the order books were added and
+// stops at the first error of fn.
+func (uc *BookShelf) forEachBook(ctx context.Context, fn func(entity.Book) error) error {
+	cursor := ""
+	for {
+		list, err := uc.ListBooksByCursor(ctx, BookFilter{}, "created_at", "asc", cursor, maintenancePageSize)
+		if err != nil {
+			return fmt.Errorf("uc.ListBooksByCursor: %w", err)
+		}
+		for _, book := range list.Books {
+			if err = fn(book); err != nil {
+				return err
+			}
+		}
+		if list.NextCursor == "" {
+			return nil
+		}
+		cursor = list.NextCursor
+	}
+}
+
+// extractMetadata reads the book file from a temporary copy, the storage
+// may not be on the local disk.
+func (uc *BookShelf) extractMetadata(ctx context.Context, book entity.Book) (metadata.Metadata, error) {
+	src, err := uc.storage.Open(ctx, book.FilePath)
+	if err != nil {
+		return metadata.Metadata{}, fmt.Errorf("file %s: %w", book.FilePath, err)
+	}
+	defer src.Close()
+
+	file, err := os.CreateTemp("", "book-")
+	if err != nil {
+		return metadata.Metadata{}, err
+	}
+	// kept on disk until done, unrar reads comic archives by name
+	defer os.Remove(file.Name())
+	defer file.Close()
+	if _, err = src.WriteTo(file); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return metadata.Metadata{}, fmt.Errorf("file %s: %w", book.FilePath, err)
+	}
+
+	m, err := metadata.ExtractBookMetadata(file)
+	if err != nil {
+		return metadata.Metadata{}, fmt.Errorf("file %s: %w", book.FilePath, err)
+	}
+	if metadata.IsComic(m.Format) {
+		m = uc.readComic(ctx, file, m)
+	}
+	return m, nil
+}
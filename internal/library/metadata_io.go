@@ -0,0 +1,348 @@
+package library
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/richtext"
+)
+
+const (
+	// MetadataCSV is one row per book with a header row, for spreadsheets.
+	MetadataCSV = "csv"
+	// MetadataJSON is {"books": [...]} with one object per book.
+	MetadataJSON = "json"
+)
+
+var (
+	ErrInvalidMetadataFormat = errors.New("metadata format must be csv or json")
+	ErrInvalidMetadataImport = errors.New("metadata import must be an export with an id or isbn column")
+)
+
+// metadataImportMax is the most rows one import reads.
+const metadataImportMax = 100000
+
+// MetadataRecord is the metadata of one book in an export, and the edit
+// of one book in an import. Genres are joined with "; " in CSV.
+type MetadataRecord struct {
+	ID          string   `json:"id"`
+	Title       string   `json:"title"`
+	Author      string   `json:"author,omitempty"`
+	Publisher   string   `json:"publisher,omitempty"`
+	Year        int      `json:"year,omitempty"`
+	ISBN        string   `json:"isbn,omitempty"`
+	DOI         string   `json:"doi,omitempty"`
+	Series      string   `json:"series,omitempty"`
+	SeriesIndex string   `json:"series_index,omitempty"`
+	Language    string   `json:"language,omitempty"`
+	Genres      []string `json:"genres,omitempty"`
+	Description string   `json:"description,omitempty"`
+
+	row int // in the import, counted from 1 without the header
+}
+
+// metadataColumns are the CSV columns in export order.
+var metadataColumns = []string{"id", "title", "author", "publisher", "year", "isbn", "doi", "series", "series_index", "language", "genres", "description"}
+
+func newMetadataRecord(book entity.Book) MetadataRecord {
+	record := MetadataRecord{
+		ID:          book.ID,
+		Title:       book.Title,
+		Author:      book.Author,
+		Publisher:   book.Publisher,
+		Year:        book.Year,
+		ISBN:        book.ISBN,
+		DOI:         book.DOI,
+		Series:      book.Series,
+		Language:    book.Language,
+		Genres:      book.Genres,
+		Description: book.Description,
+	}
+	if book.SeriesIndex != nil && book.SeriesIndex.Valid {
+		record.SeriesIndex = book.SeriesIndex.Decimal.String()
+	}
+	return record
+}
+
+func (r MetadataRecord) csvRow() []string {
+	return []string{r.ID, r.Title, r.Author, r.Publisher, strconv.Itoa(r.Year), r.ISBN, r.DOI, r.Series, r.SeriesIndex, r.Language, strings.Join(r.Genres, "; "), r.Description}
+}
+
+// ExportMetadata writes the metadata of the whole library, without files
+// or covers, for editing elsewhere and ImportMetadata.
+func (uc *BookShelf) ExportMetadata(ctx context.Context, w io.Writer, format string) error {
+	switch format {
+	case MetadataCSV:
+		cw := csv.NewWriter(w)
+		if err := cw.Write(metadataColumns); err != nil {
+			return fmt.Errorf("BookShelf - ExportMetadata - cw.Write: %w", err)
+		}
+		err := uc.forEachBook(ctx, func(book entity.Book) error {
+			return cw.Write(newMetadataRecord(book).csvRow())
+		})
+		if err == nil {
+			cw.Flush()
+			err = cw.Error()
+		}
+		if err != nil {
+			return fmt.Errorf("BookShelf - ExportMetadata - %w", err)
+		}
+	case MetadataJSON:
+		export := struct {
+			ExportedAt time.Time        `json:"exported_at"`
+			Books      []MetadataRecord `json:"books"`
+		}{ExportedAt: time.Now().UTC(), Books: make([]MetadataRecord, 0)}
+		err := uc.forEachBook(ctx, func(book entity.Book) error {
+			export.Books = append(export.Books, newMetadataRecord(book))
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("BookShelf - ExportMetadata - %w", err)
+		}
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		if err = enc.Encode(export); err != nil {
+			return fmt.Errorf("BookShelf - ExportMetadata - json.Encode: %w", err)
+		}
+	default:
+		return fmt.Errorf("BookShelf - ExportMetadata - %w", ErrInvalidMetadataFormat)
+	}
+	return nil
+}
+
+// ImportMetadata applies edited metadata, as ExportMetadata writes it, to
+// the books. Rows find their book by id, or by ISBN when the id is empty.
+// Empty values leave the field as it is. Rows without a single matching
+// book, archived books and invalid values are reported as problems, the
+// other books are changed together in one transaction.
+func (uc *BookShelf) ImportMetadata(ctx context.Context, r io.Reader, format string) (MaintenanceReport, error) {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return MaintenanceReport{}, fmt.Errorf("BookShelf - ImportMetadata - %w", err)
+	}
+	var records []MetadataRecord
+	var report MaintenanceReport
+	var err error
+	switch format {
+	case MetadataCSV:
+		records, err = readMetadataCSV(r, &report)
+	case MetadataJSON:
+		var doc struct {
+			Books []MetadataRecord `json:"books"`
+		}
+		if err = json.NewDecoder(r).Decode(&doc); err != nil {
+			err = fmt.Errorf("%w: %s", ErrInvalidMetadataImport, err)
+		}
+		records = doc.Books
+		for i := range records {
+			records[i].row = i + 1
+		}
+	default:
+		err = ErrInvalidMetadataFormat
+	}
+	if err != nil {
+		return MaintenanceReport{}, fmt.Errorf("BookShelf - ImportMetadata - %w", err)
+	}
+	if len(records) > metadataImportMax {
+		return MaintenanceReport{}, fmt.Errorf("BookShelf - ImportMetadata - %w: more than %d rows", ErrInvalidMetadataImport, metadataImportMax)
+	}
+
+	edits := make(map[string]MetadataRecord)
+	var ids []string
+	for _, record := range records {
+		report.Books++
+		row := record.row
+		book, problem, err := uc.importTarget(ctx, record)
+		if err != nil {
+			return MaintenanceReport{}, fmt.Errorf("BookShelf - ImportMetadata - %w", err)
+		}
+		if problem == "" && book.Archived() {
+			problem = "the book is archived"
+		}
+		if problem == "" && checkISBN(book.ISBN, strings.TrimSpace(record.ISBN)) != nil {
+			problem = fmt.Sprintf("isbn %q is not a valid ISBN", record.ISBN)
+		}
+		if problem == "" && record.SeriesIndex != "" && parseSeriesIndex(record.SeriesIndex) == nil {
+			problem = fmt.Sprintf("series index %q is not a number", record.SeriesIndex)
+		}
+		if _, dup := edits[book.ID]; problem == "" && dup {
+			problem = "the book is edited by an earlier row"
+		}
+		if problem != "" {
+			report.problem(book, "row %d: %s", row, problem)
+			continue
+		}
+		if sameMetadata(record.apply(book, time.Time{}), book) {
+			continue
+		}
+		edits[book.ID] = record
+		ids = append(ids, book.ID)
+	}
+	if len(ids) == 0 {
+		return report, nil
+	}
+
+	now := time.Now()
+	books, err := uc.repo.UpdateMany(ctx, ids, func(book entity.Book) (entity.Book, error) {
+		return edits[book.ID].apply(book, now), nil
+	})
+	if err != nil {
+		return MaintenanceReport{}, fmt.Errorf("BookShelf - ImportMetadata - s.repo.UpdateMany: %w", err)
+	}
+	report.Changed = len(books)
+	uc.libraryChanged(ctx)
+	return report, nil
+}
+
+// importTarget finds the book a record edits, a problem when there is
+// none or more than one with its ISBN.
+func (uc *BookShelf) importTarget(ctx context.Context, record MetadataRecord) (entity.Book, string, error) {
+	if id := strings.TrimSpace(record.ID); id != "" {
+		book, err := uc.repo.GetById(ctx, id)
+		if errors.Is(err, entity.ErrBookNotFound) {
+			return entity.Book{ID: id}, "no book with this id", nil
+		}
+		if err != nil {
+			return entity.Book{}, "", fmt.Errorf("s.repo.GetById: %w", err)
+		}
+		return book, "", nil
+	}
+
+	isbn := strings.TrimSpace(record.ISBN)
+	if isbn == "" {
+		return entity.Book{}, "neither id nor isbn", nil
+	}
+	books, err := uc.repo.ListByISBN(ctx, isbn)
+	if err != nil {
+		return entity.Book{}, "", fmt.Errorf("s.repo.ListByISBN: %w", err)
+	}
+	switch len(books) {
+	case 0:
+		return entity.Book{}, fmt.Sprintf("no book with isbn %s", isbn), nil
+	case 1:
+		return books[0], "", nil
+	}
+	return entity.Book{}, fmt.Sprintf("%d books with isbn %s, give the id", len(books), isbn), nil
+}
+
+// apply returns the book with the non-empty values of the record.
+func (r MetadataRecord) apply(book entity.Book, now time.Time) entity.Book {
+	set := func(field *string, value string) {
+		if value = strings.TrimSpace(value); value != "" {
+			*field = value
+		}
+	}
+	set(&book.Title, r.Title)
+	set(&book.Author, r.Author)
+	set(&book.Publisher, r.Publisher)
+	if isbn := strings.TrimSpace(r.ISBN); isbn != "" && isbn != book.ISBN {
+		book.ISBN = normalizeISBN(isbn)
+	}
+	set(&book.Series, r.Series)
+	if r.Year != 0 {
+		book.Year = r.Year
+	}
+	if r.DOI != "" {
+		book.DOI = normalizeDOI(r.DOI)
+	}
+	if r.Language != "" {
+		book.Language = normalizeLanguage(r.Language)
+	}
+	if r.Description != "" && r.Description != book.Description {
+		book.Description = richtext.Sanitize(r.Description)
+	}
+	if seriesIndex := parseSeriesIndex(strings.TrimSpace(r.SeriesIndex)); seriesIndex != nil {
+		book.SeriesIndex = seriesIndex
+	}
+	if len(r.Genres) > 0 {
+		book.Genres = r.Genres
+	}
+	book.UpdatedAt = now
+	return book
+}
+
+// sameMetadata reports whether two versions of a book have the metadata
+// an import can change in common.
+func sameMetadata(a, b entity.Book) bool {
+	seriesIndex := func(book entity.Book) string {
+		return newMetadataRecord(book).SeriesIndex
+	}
+	return a.Title == b.Title && a.Author == b.Author && a.Publisher == b.Publisher && a.Year == b.Year &&
+		a.ISBN == b.ISBN && a.DOI == b.DOI && a.Series == b.Series && seriesIndex(a) == seriesIndex(b) &&
+		a.Language == b.Language && a.Description == b.Description && slices.Equal(a.Genres, b.Genres)
+}
+
+// readMetadataCSV reads the rows of a CSV export, columns are found by the
+// header and unknown ones ignored. Rows with a year that is not a number
+// are reported and left out.
+func readMetadataCSV(r io.Reader, report *MaintenanceReport) ([]MetadataRecord, error) {
+	cr := csv.NewReader(r)
+	cr.FieldsPerRecord = -1
+	header, err := cr.Read()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidMetadataImport, err)
+	}
+	column := make(map[string]int, len(header))
+	for i, name := range header {
+		column[strings.ToLower(strings.TrimSpace(strings.TrimPrefix(name, "\ufeff")))] = i
+	}
+	_, hasID := column["id"]
+	_, hasISBN := column["isbn"]
+	if !hasID && !hasISBN {
+		return nil, ErrInvalidMetadataImport
+	}
+
+	var records []MetadataRecord
+	for row := 1; ; row++ {
+		fields, err := cr.Read()
+		if errors.Is(err, io.EOF) {
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrInvalidMetadataImport, err)
+		}
+		if len(records) >= metadataImportMax {
+			return nil, fmt.Errorf("%w: more than %d rows", ErrInvalidMetadataImport, metadataImportMax)
+		}
+		value := func(name string) string {
+			if i, ok := column[name]; ok && i < len(fields) {
+				return strings.TrimSpace(fields[i])
+			}
+			return ""
+		}
+		record := MetadataRecord{
+			ID:          value("id"),
+			Title:       value("title"),
+			Author:      value("author"),
+			Publisher:   value("publisher"),
+			ISBN:        value("isbn"),
+			DOI:         value("doi"),
+			Series:      value("series"),
+			SeriesIndex: value("series_index"),
+			Language:    value("language"),
+			Description: value("description"),
+			row:         row,
+		}
+		if year := value("year"); year != "" {
+			if record.Year, err = strconv.Atoi(year); err != nil {
+				report.Books++
+				report.problem(entity.Book{ID: record.ID}, "row %d: year %q is not a number", row, year)
+				continue
+			}
+		}
+		for _, genre := range strings.Split(value("genres"), ";") {
+			if genre = strings.TrimSpace(genre); genre != "" {
+				record.Genres = append(record.Genres, genre)
+			}
+		}
+		records = append(records, record)
+	}
+}
@@ -0,0 +1,49 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// Results of an upload passed to Metrics.
+const (
+	UploadStored    = "stored"
+	UploadDuplicate = "duplicate"
+	UploadFailed    = "failed"
+)
+
+// SetMetrics records uploads, downloads and ingest times in m.
+func (uc *BookShelf) SetMetrics(m Metrics) {
+	uc.metrics = m
+}
+
+// LibraryUsage reports the storage all books take.
+func (uc *BookShelf) LibraryUsage(ctx context.Context) (entity.StorageUsage, error) {
+	usage, err := uc.repo.LibraryUsage(ctx)
+	if err != nil {
+		return entity.StorageUsage{}, fmt.Errorf("BookShelf - LibraryUsage - s.repo.LibraryUsage: %w", err)
+	}
+	return usage, nil
+}
+
+func uploadResult(err error) string {
+	switch {
+	case err == nil:
+		return UploadStored
+	case errors.Is(err, entity.ErrBookAlreadyExists):
+		return UploadDuplicate
+	default:
+		return UploadFailed
+	}
+}
+
+// noMetrics records nothing, until SetMetrics.
+type noMetrics struct{}
+
+func (noMetrics) BookUploaded(string, time.Duration)      {}
+func (noMetrics) MetadataExtracted(string, time.Duration) {}
+func (noMetrics) BookDownloaded(string)                   {}
@@ -4,6 +4,15 @@ import "github.com/banjuer/kompanion/internal/entity"
 
 type PaginatedBookList struct {
 	Books []entity.Book
+	// set in cursor mode, see Cursor
+	NextCursor string
+	PrevCursor string
+	// other editions per book id, when the filter groups editions
+	Editions map[string][]entity.Edition
+	// counts of all matching books, when the filter asks for facets
+	Facets *BookFacets
+	// the total is estimated or cached, see WithApproximateCount
+	ApproximateTotal bool
 	// for pagination
 	totalCount  int
 	perPage     int
@@ -19,6 +28,11 @@ func NewPaginatedBookList(books []entity.Book, perPage, currentPage, totalCount
 	}
 }
 
+// PerPage is the page size the listing got, see BookShelf.SetPageLimits.
+func (p PaginatedBookList) PerPage() int {
+	return p.perPage
+}
+
 func (p PaginatedBookList) TotalPages() int {
 	if p.totalCount == 0 {
 		return 0
@@ -27,11 +41,11 @@ func (p PaginatedBookList) TotalPages() int {
 }
 
 func (p PaginatedBookList) HasNext() bool {
-	return p.currentPage < p.TotalPages()
+	return p.NextCursor != "" || p.currentPage < p.TotalPages()
 }
 
 func (p PaginatedBookList) HasPrev() bool {
-	return p.currentPage > 1
+	return p.PrevCursor != "" || p.currentPage > 1
 }
 
 func (p PaginatedBookList) First() int {
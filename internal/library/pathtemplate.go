@@ -0,0 +1,208 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"path"
+	"regexp"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// DefaultPathTemplate is the layout of the stored book files when no
+// template is configured, <upload date>/<book id>.<ext>.
+const DefaultPathTemplate = "{created}/{id}.{ext}"
+
+// maxPathComponent keeps every directory and file name of a rendered path
+// within the name limit of common filesystems.
+const maxPathComponent = 120
+
+// maxPathCollisions is the highest number appended to a name that is taken,
+// the book id is appended after that.
+const maxPathCollisions = 99
+
+var pathFields = map[string]func(entity.Book) string{
+	"id":        func(b entity.Book) string { return b.ID },
+	"ext":       func(b entity.Book) string { return b.Format },
+	"title":     func(b entity.Book) string { return b.Title },
+	"author":    func(b entity.Book) string { return b.Author },
+	"publisher": func(b entity.Book) string { return b.Publisher },
+	"series":    func(b entity.Book) string { return b.Series },
+	"language":  func(b entity.Book) string { return b.Language },
+	"series_index": func(b entity.Book) string {
+		if b.SeriesIndex == nil || !b.SeriesIndex.Valid {
+			return ""
+		}
+		return b.SeriesIndex.Decimal.String()
+	},
+	"year": func(b entity.Book) string {
+		if b.Year <= 0 {
+			return ""
+		}
+		return strconv.Itoa(b.Year)
+	},
+}
+
+var (
+	placeholderRe        = regexp.MustCompile(`\{([a-z_]*)\}`)
+	separatorBeforeExtRe = regexp.MustCompile(`[ _-]+\.([^.]*)$`)
+	yearRe               = regexp.MustCompile(`\d{4}`)
+)
+
+// PathTemplate lays out the book files in storage, e.g.
+// "{author}/{title} ({year}).{ext}". "/" separates directories; the
+// values are sanitized so they never add one.
+type PathTemplate struct {
+	components []string
+}
+
+// ParsePathTemplate checks the placeholders of s, an empty s is the
+// DefaultPathTemplate. Placeholders are {id}, {ext}, {title}, {author},
+// {publisher}, {series}, {series_index}, {language}, {year} and {created},
+// the upload date: YYYY/MM/DD as a directory of its own, YYYY-MM-DD within
+// a name.
+func ParsePathTemplate(s string) (PathTemplate, error) {
+	if strings.TrimSpace(s) == "" {
+		s = DefaultPathTemplate
+	}
+	if strings.HasPrefix(s, "/") || strings.HasSuffix(s, "/") {
+		return PathTemplate{}, errors.New("path template must be relative and end with a file name")
+	}
+	if !strings.Contains(path.Base(s), "{ext}") {
+		return PathTemplate{}, errors.New("path template file name must contain {ext}")
+	}
+	components := strings.Split(s, "/")
+	for _, component := range components {
+		if component == "" || component == "." || component == ".." {
+			return PathTemplate{}, fmt.Errorf("path template has an invalid directory %q", component)
+		}
+		for _, match := range placeholderRe.FindAllStringSubmatch(component, -1) {
+			if _, ok := pathFields[match[1]]; !ok && match[1] != "created" {
+				return PathTemplate{}, fmt.Errorf("path template has an unknown placeholder %s", match[0])
+			}
+		}
+	}
+	return PathTemplate{components: components}, nil
+}
+
+// Path renders the storage path of book. Empty values leave "()" and "[]"
+// around them out, a name that ends up empty is "Unknown".
+func (t PathTemplate) Path(book entity.Book) string {
+	parts := make([]string, 0, len(t.components))
+	for _, component := range t.components {
+		if component == "{created}" {
+			parts = append(parts, book.CreatedAt.Format("2006/01/02"))
+			continue
+		}
+		name := placeholderRe.ReplaceAllStringFunc(component, func(placeholder string) string {
+			key := placeholder[1 : len(placeholder)-1]
+			if key == "created" {
+				return book.CreatedAt.Format("2006-01-02")
+			}
+			return sanitizePathValue(pathFields[key](book))
+		})
+		parts = append(parts, cleanPathComponent(name))
+	}
+	return strings.Join(parts, "/")
+}
+
+var pathUnsafe = strings.NewReplacer(
+	"/", "_", `\`, "_", ":", "_", "*", "_", "?", "_", `"`, "_", "<", "_", ">", "_", "|", "_",
+)
+
+// sanitizePathValue makes a metadata value safe inside a file name on
+// Linux, macOS and Windows.
+func sanitizePathValue(value string) string {
+	value = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, value)
+	return strings.Join(strings.Fields(pathUnsafe.Replace(value)), " ")
+}
+
+// cleanPathComponent drops the brackets left by empty values, hidden file
+// and trailing dots and cuts long names before their extension.
+func cleanPathComponent(name string) string {
+	for _, empty := range []string{"()", "[]"} {
+		name = strings.ReplaceAll(name, empty, "")
+	}
+	name = strings.Join(strings.Fields(name), " ")
+	name = separatorBeforeExtRe.ReplaceAllString(name, ".$1")
+	name = strings.TrimRight(strings.TrimLeft(name, " -_"), " .")
+	if name == "" || strings.HasPrefix(name, ".") {
+		name = "Unknown" + name
+	}
+	if len(name) <= maxPathComponent {
+		return name
+	}
+	ext := path.Ext(name)
+	if len(ext) > maxPathComponent/4 {
+		ext = ""
+	}
+	base := name[:maxPathComponent-len(ext)]
+	for !utf8.ValidString(base) {
+		base = base[:len(base)-1]
+	}
+	return strings.TrimRight(base, " .") + ext
+}
+
+// numberedPath inserts " (n)" before the extension of p, or the book id
+// once the numbers run out.
+func numberedPath(p string, n int, bookID string) string {
+	ext := path.Ext(p)
+	suffix := fmt.Sprintf(" (%d)", n)
+	if n > maxPathCollisions {
+		suffix = " (" + bookID + ")"
+	}
+	return strings.TrimSuffix(p, ext) + suffix + ext
+}
+
+// metadataYear reads the year of a publication date from the file
+// metadata, 0 when there is none.
+func metadataYear(date string) int {
+	year, _ := strconv.Atoi(yearRe.FindString(date))
+	return year
+}
+
+// SetPathTemplate replaces the DefaultPathTemplate for books stored from
+// now on, stored books keep their path.
+func (uc *BookShelf) SetPathTemplate(t PathTemplate) {
+	uc.pathTemplate = t
+}
+
+// putBookFile stores r under the path the template gives book and returns
+// it. A path taken by another file gets a number, uploads rendering the
+// same path wait for each other so neither overwrites the other.
+func (uc *BookShelf) putBookFile(ctx context.Context, book entity.Book, r io.Reader) (string, error) {
+	wanted := uc.pathTemplate.Path(book)
+	unlock, err := uc.lockIngest(ctx, &uc.placing, "path:"+wanted)
+	if err != nil {
+		return "", err
+	}
+	defer unlock()
+
+	storagePath := wanted
+	for n := 2; ; n++ {
+		f, err := uc.storage.Open(ctx, storagePath)
+		if err != nil {
+			break
+		}
+		f.Close()
+		storagePath = numberedPath(wanted, n, book.ID)
+		if n > maxPathCollisions {
+			break
+		}
+	}
+	if err := uc.storage.Put(ctx, storagePath, r); err != nil {
+		return "", err
+	}
+	return storagePath, nil
+}
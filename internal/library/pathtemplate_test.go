@@ -0,0 +1,116 @@
+package library_test
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/shopspring/decimal"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func TestPathTemplatePath(t *testing.T) {
+	book := entity.Book{
+		ID:          "0192",
+		Title:       `What If? / "Serious" Answers`,
+		Author:      "Randall Munroe",
+		Series:      "Science",
+		SeriesIndex: &decimal.NullDecimal{Decimal: decimal.NewFromFloat(1.5), Valid: true},
+		Year:        2014,
+		Format:      "epub",
+		CreatedAt:   time.Date(2026, 3, 7, 0, 0, 0, 0, time.UTC),
+	}
+	for template, want := range map[string]string{
+		"":                                "2026/03/07/0192.epub",
+		"{author}/{title} ({year}).{ext}": "Randall Munroe/What If_ _ _Serious_ Answers (2014).epub",
+		"{series}/{series_index} - {title}.{ext}": "Science/1.5 - What If_ _ _Serious_ Answers.epub",
+		"{created}/{publisher}/{id}.{ext}":        "2026/03/07/Unknown/0192.epub",
+		"{language}/{author} [{series}].{ext}":    "Unknown/Randall Munroe [Science].epub",
+	} {
+		tmpl, err := library.ParsePathTemplate(template)
+		if err != nil {
+			t.Fatalf("unexpected error for %q: %v", template, err)
+		}
+		if got := tmpl.Path(book); got != want {
+			t.Fatalf("expected %q for %q, got %q", want, template, got)
+		}
+	}
+
+	book.Year = 0
+	book.Title = ".hidden"
+	tmpl, _ := library.ParsePathTemplate("{title} ({year}) - {publisher}.{ext}")
+	if got := tmpl.Path(book); got != "Unknown.hidden.epub" {
+		t.Fatalf("expected empty values and hidden names to be cleaned, got %q", got)
+	}
+
+	book.Title = strings.Repeat("长", 100)
+	tmpl, _ = library.ParsePathTemplate("{title}.{ext}")
+	if got := tmpl.Path(book); len(got) > 120 || !strings.HasSuffix(got, "长.epub") {
+		t.Fatalf("expected the name cut to 120 bytes before the extension, got %q", got)
+	}
+}
+
+func TestParsePathTemplateRejects(t *testing.T) {
+	for _, template := range []string{
+		"/{title}.{ext}",
+		"{author}/",
+		"{title}",
+		"{ext}/{title}",
+		"../{title}.{ext}",
+		"{author}//{title}.{ext}",
+		"{isbn}.{ext}",
+	} {
+		if _, err := library.ParsePathTemplate(template); err == nil {
+			t.Fatalf("expected %q to be rejected", template)
+		}
+	}
+}
+
+func TestStoreBookNumbersTakenPaths(t *testing.T) {
+	ctx := context.Background()
+	bookStorage := storage.NewMemoryStorage()
+	repo := &uniqueBookRepo{books: make(map[string]entity.Book)}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+	tmpl, err := library.ParsePathTemplate("{author}/{title}.{ext}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	shelf.SetPathTemplate(tmpl)
+
+	var paths []string
+	for _, text := range []string{"first", "second", "third"} {
+		file, err := os.CreateTemp(t.TempDir(), "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		_, err = file.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><author><first-name>Anna</first-name><last-name>Ivanova</last-name></author><book-title>Same: Title</book-title></title-info></description><body><p>` + text + `</p></body></FictionBook>`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Seek(0, 0)
+
+		book, err := shelf.StoreBook(ctx, file, "same.fb2")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		paths = append(paths, book.FilePath)
+	}
+
+	want := []string{"Anna Ivanova/Same_ Title.fb2", "Anna Ivanova/Same_ Title (2).fb2", "Anna Ivanova/Same_ Title (3).fb2"}
+	for i := range want {
+		if paths[i] != want[i] {
+			t.Fatalf("expected paths %v, got %v", want, paths)
+		}
+		if _, err := bookStorage.Open(ctx, paths[i]); err != nil {
+			t.Fatalf("expected %q in storage: %v", paths[i], err)
+		}
+	}
+}
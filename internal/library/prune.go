@@ -0,0 +1,100 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// PrunePolicy chooses the redundant formats PruneFormats deletes from
+// books with more than one file. The file a book was uploaded as is never
+// deleted.
+type PrunePolicy struct {
+	// Keep are the formats worth keeping, "epub" and "azw3". Other formats
+	// of a book are redundant once it has a file of one of them.
+	Keep []string
+	// Drop are formats that are redundant next to any other file, "mobi".
+	Drop []string
+	// Exclude are ids of books left as they are.
+	Exclude []string
+}
+
+// PrunedFile is a format PruneFormats deleted, or would on a dry run.
+type PrunedFile struct {
+	Book entity.Book
+	File entity.BookFile
+}
+
+// PruneReport lists the redundant formats with the bytes they take.
+type PruneReport struct {
+	Books    int // books with more than one file
+	Files    []PrunedFile
+	Bytes    int64
+	Problems []BookProblem
+}
+
+// PruneFormats deletes the formats the policy makes redundant, a dry run
+// only lists them. Archived books are skipped.
+func (uc *BookShelf) PruneFormats(ctx context.Context, policy PrunePolicy, dryRun bool) (PruneReport, error) {
+	var report PruneReport
+	if err := entity.RequireEditor(ctx); err != nil {
+		return report, fmt.Errorf("BookShelf - PruneFormats - %w", err)
+	}
+	keep, drop, excluded := formatSet(policy.Keep), formatSet(policy.Drop), make(map[string]bool)
+	for _, id := range policy.Exclude {
+		excluded[strings.TrimSpace(id)] = true
+	}
+	if len(keep) == 0 && len(drop) == 0 {
+		return report, nil
+	}
+
+	err := uc.forEachBook(ctx, func(book entity.Book) error {
+		files, err := uc.repo.ListFiles(ctx, book.ID)
+		if err != nil {
+			return fmt.Errorf("s.repo.ListFiles: %w", err)
+		}
+		if len(files) == 0 {
+			return nil
+		}
+		report.Books++
+		if excluded[book.ID] || book.Archived() {
+			return nil
+		}
+
+		primary := strings.ToLower(book.Extension())
+		kept := keep[primary]
+		for _, file := range files {
+			kept = kept || keep[file.Format]
+		}
+		for _, file := range files {
+			if file.Format == primary || !(drop[file.Format] || (kept && !keep[file.Format])) {
+				continue
+			}
+			if !dryRun {
+				if err := uc.DeleteBookFile(ctx, book.ID, file.Format); err != nil {
+					report.Problems = append(report.Problems, BookProblem{BookID: book.ID, Title: book.Title, Problem: err.Error()})
+					continue
+				}
+			}
+			report.Files = append(report.Files, PrunedFile{Book: book, File: file})
+			report.Bytes += file.FileSize
+		}
+		return nil
+	})
+	if err != nil {
+		return report, fmt.Errorf("BookShelf - PruneFormats - %w", err)
+	}
+	return report, nil
+}
+
+func formatSet(formats []string) map[string]bool {
+	set := make(map[string]bool, len(formats))
+	for _, format := range formats {
+		if format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), ".")); format != "" {
+			set[format] = true
+		}
+	}
+	return set
+}
@@ -0,0 +1,72 @@
+package library_test
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func TestPruneFormats(t *testing.T) {
+	ctx := context.Background()
+	store := storage.NewMemoryStorage()
+	repo := library.NewMemoryBookRepo()
+	shelf := library.NewBookShelf(store, repo, logger.New("error"))
+
+	files := map[string][]string{
+		"novel":  {"azw3", "mobi", "pdf"}, // uploaded as epub
+		"comic":  {"mobi"},                // uploaded as cbz, nothing to keep
+		"manual": {"mobi"},                // uploaded as mobi
+		"kept":   {"mobi"},                // excluded
+	}
+	for id, primary := range map[string]string{"novel": "epub", "comic": "cbz", "manual": "mobi", "kept": "epub"} {
+		book := entity.Book{ID: id, Title: id, FilePath: id + "." + primary, DocumentID: id, CreatedAt: time.Now()}
+		if err := repo.Store(ctx, book); err != nil {
+			t.Fatal(err)
+		}
+		for _, format := range files[id] {
+			file := entity.BookFile{BookID: id, Format: format, FilePath: id + "/" + format, DocumentID: id + format, FileSize: 100, CreatedAt: time.Now()}
+			if err := repo.StoreFile(ctx, file); err != nil {
+				t.Fatal(err)
+			}
+			if err := store.Put(ctx, file.FilePath, strings.NewReader(format)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	policy := library.PrunePolicy{Keep: []string{"epub", "azw3"}, Drop: []string{".MOBI"}, Exclude: []string{"kept"}}
+	report, err := shelf.PruneFormats(ctx, policy, true)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	var pruned []string
+	for _, file := range report.Files {
+		pruned = append(pruned, file.Book.ID+"."+file.File.Format)
+	}
+	sort.Strings(pruned)
+	if report.Books != 4 || report.Bytes != 300 || fmt.Sprint(pruned) != "[comic.mobi novel.mobi novel.pdf]" {
+		t.Fatalf("unexpected dry run %+v %v", report, pruned)
+	}
+	if left, _ := repo.ListFiles(ctx, "novel"); len(left) != 3 {
+		t.Fatalf("expected a dry run to keep the files, got %v", left)
+	}
+
+	report, err = shelf.PruneFormats(ctx, policy, false)
+	if err != nil || len(report.Files) != 3 || len(report.Problems) != 0 {
+		t.Fatalf("unexpected report %+v %v", report, err)
+	}
+	if left, _ := repo.ListFiles(ctx, "novel"); len(left) != 1 || left[0].Format != "azw3" {
+		t.Errorf("expected the azw3 kept, got %v", left)
+	}
+	if left, _ := repo.ListFiles(ctx, "kept"); len(left) != 1 {
+		t.Errorf("expected the excluded book untouched, got %v", left)
+	}
+}
@@ -0,0 +1,208 @@
+package library
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var ErrInvalidQuery = errors.New("invalid search query")
+
+// SearchTerm is one part of a search query: free text when Field is
+// empty, otherwise a field comparison such as author:tolkien or year:>2000.
+type SearchTerm struct {
+	Field  string
+	Op     string // =, >, >=, <, <= or .. for numeric fields; text fields match substrings
+	Value  string
+	Upper  string // end of a year:1990..2000 range
+	Negate bool
+}
+
+// SearchQuery is a parsed search; all terms must match.
+type SearchQuery []SearchTerm
+
+type queryField struct {
+	column  string
+	numeric bool
+	// folded matches ignoring case and accents, see library_fold
+	folded bool
+}
+
+// searchFields maps query fields to columns, values are always passed as parameters.
+var searchFields = map[string]queryField{
+	"title":       {column: "title", folded: true},
+	"author":      {column: "author", folded: true},
+	"publisher":   {column: "publisher"},
+	"isbn":        {column: "isbn"},
+	"series":      {column: "series"},
+	"description": {column: "summary"},
+	"lang":        {column: "language"},
+	"format":      {column: "storage_file_path"},
+	"year":        {column: "year", numeric: true},
+	"pages":       {column: "pages", numeric: true},
+	"rating":      {column: "rating_avg", numeric: true},
+}
+
+var fieldAliases = map[string]string{
+	"language": "lang",
+	"summary":  "description",
+	"ext":      "format",
+}
+
+// ParseSearchQuery parses words, "quoted phrases" and field:value terms,
+// a leading minus excludes matches:
+//
+//	author:tolkien year:>2000 -publisher:acme "middle earth"
+func ParseSearchQuery(s string) (SearchQuery, error) {
+	var q SearchQuery
+	for _, token := range splitQuery(s) {
+		term := SearchTerm{Op: "="}
+		if strings.HasPrefix(token, "-") && len(token) > 1 {
+			term.Negate = true
+			token = token[1:]
+		}
+
+		// unknown fields stay text, titles like "Re:Zero" are searched as typed
+		if field, f, value, ok := cutField(token); ok {
+			term.Field = field
+			value = unquote(value)
+			if f.numeric {
+				if err := parseComparison(&term, value); err != nil {
+					return nil, err
+				}
+			} else {
+				term.Value = value
+			}
+			if term.Field == "lang" {
+				term.Value = normalizeLanguage(term.Value)
+			}
+		} else {
+			term.Value = unquote(token)
+		}
+		if term.Value == "" {
+			continue
+		}
+		q = append(q, term)
+	}
+	return q, nil
+}
+
+// splitQuery splits on spaces outside of double quotes, quotes are kept.
+func splitQuery(s string) []string {
+	var tokens []string
+	var current strings.Builder
+	quoted := false
+	for _, r := range s {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			current.WriteRune(r)
+		case unicode.IsSpace(r) && !quoted:
+			if current.Len() > 0 {
+				tokens = append(tokens, current.String())
+				current.Reset()
+			}
+		default:
+			current.WriteRune(r)
+		}
+	}
+	if current.Len() > 0 {
+		tokens = append(tokens, current.String())
+	}
+	return tokens
+}
+
+func cutField(token string) (string, queryField, string, bool) {
+	name, value, ok := strings.Cut(token, ":")
+	if !ok || value == "" {
+		return "", queryField{}, "", false
+	}
+	name = strings.ToLower(name)
+	if alias, ok := fieldAliases[name]; ok {
+		name = alias
+	}
+	f, ok := searchFields[name]
+	return name, f, value, ok
+}
+
+func unquote(s string) string {
+	return strings.TrimSpace(strings.ReplaceAll(s, `"`, ""))
+}
+
+func parseComparison(term *SearchTerm, value string) error {
+	if lower, upper, ok := strings.Cut(value, ".."); ok {
+		term.Op, term.Value, term.Upper = "..", lower, upper
+		if !isNumber(lower) || !isNumber(upper) {
+			return fmt.Errorf("%w: %s needs a range like 1990..2000", ErrInvalidQuery, term.Field)
+		}
+		return nil
+	}
+	for _, op := range []string{">=", "<=", ">", "<", "="} {
+		if strings.HasPrefix(value, op) {
+			term.Op, value = op, value[len(op):]
+			break
+		}
+	}
+	if !isNumber(value) {
+		return fmt.Errorf("%w: %s needs a number", ErrInvalidQuery, term.Field)
+	}
+	term.Value = value
+	return nil
+}
+
+func isNumber(s string) bool {
+	_, err := strconv.ParseFloat(s, 64)
+	return err == nil
+}
+
+// freeTextColumns are searched by terms without a field.
+var freeTextColumns = []string{"title", "author", "publisher", "isbn", "summary"}
+
+// conditions translates the query to SQL conditions, appending the values
+// to args so placeholders continue after the ones already used.
+func (q SearchQuery) conditions(d dialect, args []interface{}) ([]string, []interface{}) {
+	conditions := make([]string, 0, len(q))
+	for _, term := range q {
+		var condition string
+		field := searchFields[term.Field]
+		switch {
+		case term.Field == "":
+			args = append(args, "%"+escapeLike(term.Value)+"%")
+			matches := make([]string, 0, len(freeTextColumns))
+			for _, column := range freeTextColumns {
+				matches = append(matches, d.matches(fmt.Sprintf("COALESCE(%s, '')", column), len(args)))
+			}
+			condition = "(" + strings.Join(matches, " OR ") + ")"
+		case term.Field == "lang":
+			args = append(args, term.Value)
+			condition = fmt.Sprintf("COALESCE(language, '') = $%d", len(args))
+		case term.Field == "format":
+			args = append(args, "%."+escapeLike(strings.TrimPrefix(term.Value, ".")))
+			condition = d.matches("storage_file_path", len(args))
+		case field.numeric && term.Op == "..":
+			args = append(args, term.Value, term.Upper)
+			condition = fmt.Sprintf("COALESCE(%s, 0) BETWEEN %s AND %s", field.column, d.numberParam(len(args)-1), d.numberParam(len(args)))
+		case field.numeric:
+			args = append(args, term.Value)
+			condition = fmt.Sprintf("COALESCE(%s, 0) %s %s", field.column, term.Op, d.numberParam(len(args)))
+		case field.folded:
+			args = append(args, "%"+escapeLike(term.Value)+"%")
+			condition = d.matchesFolded(fmt.Sprintf("COALESCE(%s, '')", field.column), len(args))
+		default:
+			args = append(args, "%"+escapeLike(term.Value)+"%")
+			condition = d.matches(fmt.Sprintf("COALESCE(%s, '')", field.column), len(args))
+		}
+		if term.Negate {
+			condition = "NOT " + condition
+		}
+		conditions = append(conditions, condition)
+	}
+	return conditions, args
+}
+
+// escapeLike makes % and _ in user input match literally.
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`).Replace(s)
+}
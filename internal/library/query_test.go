@@ -0,0 +1,56 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v4"
+
+	"github.com/banjuer/kompanion/internal/library"
+)
+
+func TestParseSearchQuery(t *testing.T) {
+	q, err := library.ParseSearchQuery(`author:tolkien year:>2000 -publisher:"acme books" "middle earth" Re:Zero lang:eng rating:3..5`)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := library.SearchQuery{
+		{Field: "author", Op: "=", Value: "tolkien"},
+		{Field: "year", Op: ">", Value: "2000"},
+		{Field: "publisher", Op: "=", Value: "acme books", Negate: true},
+		{Op: "=", Value: "middle earth"},
+		{Op: "=", Value: "Re:Zero"},
+		{Field: "lang", Op: "=", Value: "en"},
+		{Field: "rating", Op: "..", Value: "3", Upper: "5"},
+	}
+	if !reflect.DeepEqual(q, want) {
+		t.Errorf("unexpected query\n got %+v\nwant %+v", q, want)
+	}
+}
+
+func TestParseSearchQueryInvalid(t *testing.T) {
+	for _, s := range []string{"year:>soon", "pages:100..", "rating:abc"} {
+		if _, err := library.ParseSearchQuery(s); !errors.Is(err, library.ErrInvalidQuery) {
+			t.Errorf("ParseSearchQuery(%q): expected ErrInvalidQuery, got %v", s, err)
+		}
+	}
+}
+
+func TestBookDatabaseRepoCountSearchQuery(t *testing.T) {
+	mock, bdr := setupTestBookDatabaseRepo()
+	defer mock.Close()
+
+	mock.ExpectQuery(`SELECT COUNT\(\*\) FROM library_book WHERE library_fold\(COALESCE\(author, ''\)\) LIKE library_fold\(\$1\) AND COALESCE\(year, 0\) >= \$2::numeric AND NOT \(COALESCE\(title, ''\) ILIKE \$3 OR .+\) AND language = \$4`).
+		WithArgs("%tolkien%", "2000", `%50\%%`, "de").
+		WillReturnRows(pgxmock.NewRows([]string{"count"}).AddRow(3))
+
+	count, err := bdr.CountSearch(context.Background(), "author:tolkien year:>=2000 -50%", library.NewBookFilter("de"))
+	if err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if count != 3 {
+		t.Errorf("expected 3 books, got %v", count)
+	}
+}
@@ -0,0 +1,71 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// ReadingStatus -. status of the book for the user, empty when none is set
+func (uc *BookShelf) ReadingStatus(ctx context.Context, username, bookID string) (entity.BookStatus, error) {
+	status, err := uc.repo.GetReadingStatus(ctx, username, bookID)
+	if err != nil {
+		return entity.BookStatus{}, fmt.Errorf("BookShelf - ReadingStatus - s.repo.GetReadingStatus: %w", err)
+	}
+	return status, nil
+}
+
+// SetReadingStatus puts the book on one of the user's shelves. The finish
+// date is kept when a finished book is saved as finished again, an empty
+// status clears it.
+func (uc *BookShelf) SetReadingStatus(ctx context.Context, username, bookID string, status entity.ReadingStatus) (entity.BookStatus, error) {
+	if _, err := entity.ParseReadingStatus(string(status)); err != nil {
+		return entity.BookStatus{}, fmt.Errorf("BookShelf - SetReadingStatus - %w", err)
+	}
+	if status == "" {
+		if err := uc.ClearReadingStatus(ctx, username, bookID); err != nil {
+			return entity.BookStatus{}, err
+		}
+		return entity.BookStatus{Username: username, BookID: bookID}, nil
+	}
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return entity.BookStatus{}, fmt.Errorf("BookShelf - SetReadingStatus - s.getBook: %w", err)
+	}
+
+	current, err := uc.repo.GetReadingStatus(ctx, username, bookID)
+	if err != nil {
+		return entity.BookStatus{}, fmt.Errorf("BookShelf - SetReadingStatus - s.repo.GetReadingStatus: %w", err)
+	}
+	now := time.Now()
+	updated := entity.BookStatus{
+		Username:  username,
+		BookID:    bookID,
+		Status:    status,
+		UpdatedAt: now,
+	}
+	if status == entity.StatusFinished {
+		updated.FinishedAt = &now
+		if current.Status == entity.StatusFinished && current.FinishedAt != nil {
+			updated.FinishedAt = current.FinishedAt
+		}
+	}
+	if err = uc.repo.SetReadingStatus(ctx, updated); err != nil {
+		return entity.BookStatus{}, fmt.Errorf("BookShelf - SetReadingStatus - s.repo.SetReadingStatus: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	if status == entity.StatusFinished && current.Status != entity.StatusFinished {
+		uc.bookFinished(ctx, username, book)
+	}
+	return updated, nil
+}
+
+func (uc *BookShelf) ClearReadingStatus(ctx context.Context, username, bookID string) error {
+	if err := uc.repo.ClearReadingStatus(ctx, username, bookID); err != nil {
+		return fmt.Errorf("BookShelf - ClearReadingStatus - s.repo.ClearReadingStatus: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	return nil
+}
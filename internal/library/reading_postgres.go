@@ -0,0 +1,54 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// GetReadingStatus returns an empty status when the user has not set one.
+func (bdr *BookDatabaseRepo) GetReadingStatus(ctx context.Context, username, bookID string) (entity.BookStatus, error) {
+	status := entity.BookStatus{Username: username, BookID: bookID}
+	err := bdr.Conn(ctx).QueryRow(ctx, `
+		SELECT status, finished_at, updated_at
+		FROM reading_status
+		WHERE username = $1 AND book_id = $2
+	`, username, bookID).Scan(&status.Status, &status.FinishedAt, &status.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return status, nil
+	}
+	if err != nil {
+		return entity.BookStatus{}, fmt.Errorf("BookDatabaseRepo - GetReadingStatus - r.Pool.QueryRow: %w", err)
+	}
+	return status, nil
+}
+
+func (bdr *BookDatabaseRepo) SetReadingStatus(ctx context.Context, status entity.BookStatus) error {
+	_, err := bdr.Conn(ctx).Exec(ctx, `
+		INSERT INTO reading_status (username, book_id, status, finished_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (username, book_id) DO UPDATE
+		SET status = EXCLUDED.status,
+			finished_at = EXCLUDED.finished_at,
+			updated_at = EXCLUDED.updated_at
+	`, status.Username, status.BookID, string(status.Status), status.FinishedAt, status.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - SetReadingStatus - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (bdr *BookDatabaseRepo) ClearReadingStatus(ctx context.Context, username, bookID string) error {
+	_, err := bdr.Conn(ctx).Exec(ctx, `
+		DELETE FROM reading_status
+		WHERE username = $1 AND book_id = $2
+	`, username, bookID)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - ClearReadingStatus - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
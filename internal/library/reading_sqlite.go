@@ -0,0 +1,185 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/sqlite"
+)
+
+// GetReadingStatus returns an empty status when the user has not set one.
+func (bsr *BookSQLiteRepo) GetReadingStatus(ctx context.Context, username, bookID string) (entity.BookStatus, error) {
+	status := entity.BookStatus{Username: username, BookID: bookID}
+	err := bsr.Conn(ctx).QueryRow(ctx, `
+		SELECT status, finished_at, updated_at
+		FROM reading_status
+		WHERE username = $1 AND book_id = $2
+	`, username, bookID).Scan(&status.Status, &status.FinishedAt, &status.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return status, nil
+	}
+	if err != nil {
+		return entity.BookStatus{}, fmt.Errorf("BookSQLiteRepo - GetReadingStatus - r.DB.QueryRow: %w", err)
+	}
+	return status, nil
+}
+
+func (bsr *BookSQLiteRepo) SetReadingStatus(ctx context.Context, status entity.BookStatus) error {
+	_, err := bsr.Conn(ctx).Exec(ctx, `
+		INSERT INTO reading_status (username, book_id, status, finished_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5)
+		ON CONFLICT (username, book_id) DO UPDATE
+		SET status = excluded.status,
+			finished_at = excluded.finished_at,
+			updated_at = excluded.updated_at
+	`, status.Username, status.BookID, string(status.Status), status.FinishedAt, status.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - SetReadingStatus - r.DB.Exec: %w", err)
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) ClearReadingStatus(ctx context.Context, username, bookID string) error {
+	_, err := bsr.Conn(ctx).Exec(ctx, `DELETE FROM reading_status WHERE username = $1 AND book_id = $2`, username, bookID)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - ClearReadingStatus - r.DB.Exec: %w", err)
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) ListReviews(ctx context.Context, bookID string) ([]entity.Review, error) {
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT `+reviewColumns+`
+		FROM book_review
+		WHERE book_id = $1
+		ORDER BY updated_at DESC
+	`, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListReviews - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	reviews := make([]entity.Review, 0)
+	for rows.Next() {
+		var r entity.Review
+		if err = rows.Scan(&r.Username, &r.BookID, &r.Rating, &r.Text, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("BookSQLiteRepo - ListReviews - rows.Scan: %w", err)
+		}
+		reviews = append(reviews, r)
+	}
+	return reviews, rows.Err()
+}
+
+// GetReview returns an empty review when the user has not written one.
+func (bsr *BookSQLiteRepo) GetReview(ctx context.Context, username, bookID string) (entity.Review, error) {
+	r := entity.Review{Username: username, BookID: bookID}
+	err := bsr.Conn(ctx).QueryRow(ctx, `
+		SELECT `+reviewColumns+`
+		FROM book_review
+		WHERE username = $1 AND book_id = $2
+	`, username, bookID).Scan(&r.Username, &r.BookID, &r.Rating, &r.Text, &r.CreatedAt, &r.UpdatedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return r, nil
+	}
+	if err != nil {
+		return entity.Review{}, fmt.Errorf("BookSQLiteRepo - GetReview - r.DB.QueryRow: %w", err)
+	}
+	return r, nil
+}
+
+// SaveReview upserts the review and refreshes the book's rating.
+func (bsr *BookSQLiteRepo) SaveReview(ctx context.Context, review entity.Review) error {
+	var rating interface{}
+	if review.Rating > 0 {
+		rating = review.Rating
+	}
+	_, err := bsr.Conn(ctx).Exec(ctx, `
+		INSERT INTO book_review (username, book_id, rating, review, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (username, book_id) DO UPDATE
+		SET rating = excluded.rating,
+			review = excluded.review,
+			updated_at = excluded.updated_at
+	`, review.Username, review.BookID, rating, review.Text, review.CreatedAt, review.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - SaveReview - r.DB.Exec: %w", err)
+	}
+	if err = bsr.refreshRating(ctx, review.BookID); err != nil {
+		return fmt.Errorf("BookSQLiteRepo - SaveReview - %w", err)
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) DeleteReview(ctx context.Context, username, bookID string) error {
+	_, err := bsr.Conn(ctx).Exec(ctx, `DELETE FROM book_review WHERE username = $1 AND book_id = $2`, username, bookID)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - DeleteReview - r.DB.Exec: %w", err)
+	}
+	if err = bsr.refreshRating(ctx, bookID); err != nil {
+		return fmt.Errorf("BookSQLiteRepo - DeleteReview - %w", err)
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) refreshRating(ctx context.Context, bookID string) error {
+	_, err := bsr.Conn(ctx).Exec(ctx, `
+		UPDATE library_book
+		SET rating_avg = (SELECT round(avg(rating), 2) FROM book_review WHERE book_id = $1),
+			rating_count = (SELECT count(rating) FROM book_review WHERE book_id = $1)
+		WHERE id = $1
+	`, bookID)
+	if err != nil {
+		return fmt.Errorf("refreshRating - r.DB.Exec: %w", err)
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) AddDownload(ctx context.Context, download entity.Download) error {
+	_, err := bsr.Conn(ctx).Exec(ctx, `
+		INSERT INTO book_download (book_id, username, device_name, client, downloaded_at)
+		VALUES ($1, $2, $3, $4, $5)
+	`, download.BookID, download.Username, download.DeviceName, download.Client, download.DownloadedAt)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - AddDownload - r.DB.Exec: %w", err)
+	}
+	return nil
+}
+
+// ListDownloads returns downloaded books, last downloaded first. Progress
+// and statistics stay in Postgres, so no book counts as opened and
+// unopened lists them all.
+func (bsr *BookSQLiteRepo) ListDownloads(ctx context.Context, username string, unopened bool, limit int) ([]entity.DownloadedBook, error) {
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT `+sqliteBookColumns+`, d.downloads, d.last_downloaded_at
+		FROM (
+			SELECT book_id, count(*) AS downloads, max(downloaded_at) AS last_downloaded_at
+			FROM book_download
+			WHERE username = $1 OR device_name <> ''
+			GROUP BY book_id
+		) d
+		JOIN library_book ON library_book.id = d.book_id
+		ORDER BY d.last_downloaded_at DESC
+		LIMIT $2
+	`, username, limit)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListDownloads - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	downloads := make([]entity.DownloadedBook, 0)
+	for rows.Next() {
+		var d entity.DownloadedBook
+		d.Book, err = scanSQLiteBook(sqliteExtraRow{rows, []interface{}{&d.Downloads, sqlite.Time(&d.LastDownloadedAt)}})
+		if err != nil {
+			return nil, fmt.Errorf("BookSQLiteRepo - ListDownloads - rows.Scan: %w", err)
+		}
+		downloads = append(downloads, d)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListDownloads - rows.Err: %w", err)
+	}
+	return downloads, nil
+}
@@ -0,0 +1,72 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// Reviews -. reviews of all users, newest first
+func (uc *BookShelf) Reviews(ctx context.Context, bookID string) ([]entity.Review, error) {
+	reviews, err := uc.repo.ListReviews(ctx, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - Reviews - s.repo.ListReviews: %w", err)
+	}
+	return reviews, nil
+}
+
+// Review -. review of the user, empty when none is written
+func (uc *BookShelf) Review(ctx context.Context, username, bookID string) (entity.Review, error) {
+	review, err := uc.repo.GetReview(ctx, username, bookID)
+	if err != nil {
+		return entity.Review{}, fmt.Errorf("BookShelf - Review - s.repo.GetReview: %w", err)
+	}
+	return review, nil
+}
+
+// SaveReview rates and reviews the book for the user, rating 0 keeps only
+// the text. Without rating and text the review is deleted.
+func (uc *BookShelf) SaveReview(ctx context.Context, username, bookID string, rating int, text string) (entity.Review, error) {
+	if rating < 0 || rating > 5 {
+		return entity.Review{}, fmt.Errorf("BookShelf - SaveReview - %w", entity.ErrInvalidRating)
+	}
+	text = strings.TrimSpace(text)
+	if rating == 0 && text == "" {
+		if err := uc.DeleteReview(ctx, username, bookID); err != nil {
+			return entity.Review{}, err
+		}
+		return entity.Review{Username: username, BookID: bookID}, nil
+	}
+	if _, err := uc.getBook(ctx, bookID); err != nil {
+		return entity.Review{}, fmt.Errorf("BookShelf - SaveReview - s.getBook: %w", err)
+	}
+
+	review, err := uc.repo.GetReview(ctx, username, bookID)
+	if err != nil {
+		return entity.Review{}, fmt.Errorf("BookShelf - SaveReview - s.repo.GetReview: %w", err)
+	}
+	now := time.Now()
+	if review.CreatedAt.IsZero() {
+		review.CreatedAt = now
+	}
+	review.Rating = rating
+	review.Text = text
+	review.UpdatedAt = now
+	if err = uc.repo.SaveReview(ctx, review); err != nil {
+		return entity.Review{}, fmt.Errorf("BookShelf - SaveReview - s.repo.SaveReview: %w", err)
+	}
+	// listings show the average rating
+	uc.libraryChanged(ctx)
+	return review, nil
+}
+
+func (uc *BookShelf) DeleteReview(ctx context.Context, username, bookID string) error {
+	if err := uc.repo.DeleteReview(ctx, username, bookID); err != nil {
+		return fmt.Errorf("BookShelf - DeleteReview - s.repo.DeleteReview: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	return nil
+}
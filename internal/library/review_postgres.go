@@ -0,0 +1,110 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+const reviewColumns = `username, book_id, COALESCE(rating, 0), review, created_at, updated_at`
+
+func (bdr *BookDatabaseRepo) ListReviews(ctx context.Context, bookID string) ([]entity.Review, error) {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT `+reviewColumns+`
+		FROM book_review
+		WHERE book_id = $1
+		ORDER BY updated_at DESC
+	`, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListReviews - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	reviews := make([]entity.Review, 0)
+	for rows.Next() {
+		var r entity.Review
+		if err = rows.Scan(&r.Username, &r.BookID, &r.Rating, &r.Text, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - ListReviews - rows.Scan: %w", err)
+		}
+		reviews = append(reviews, r)
+	}
+	return reviews, rows.Err()
+}
+
+// GetReview returns an empty review when the user has not written one.
+func (bdr *BookDatabaseRepo) GetReview(ctx context.Context, username, bookID string) (entity.Review, error) {
+	r := entity.Review{Username: username, BookID: bookID}
+	err := bdr.Conn(ctx).QueryRow(ctx, `
+		SELECT `+reviewColumns+`
+		FROM book_review
+		WHERE username = $1 AND book_id = $2
+	`, username, bookID).Scan(&r.Username, &r.BookID, &r.Rating, &r.Text, &r.CreatedAt, &r.UpdatedAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return r, nil
+	}
+	if err != nil {
+		return entity.Review{}, fmt.Errorf("BookDatabaseRepo - GetReview - r.Pool.QueryRow: %w", err)
+	}
+	return r, nil
+}
+
+// SaveReview upserts the review and refreshes the book's rating.
+func (bdr *BookDatabaseRepo) SaveReview(ctx context.Context, review entity.Review) error {
+	var rating interface{}
+	if review.Rating > 0 {
+		rating = review.Rating
+	}
+	_, err := bdr.Conn(ctx).Exec(ctx, `
+		INSERT INTO book_review (username, book_id, rating, review, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		ON CONFLICT (username, book_id) DO UPDATE
+		SET rating = EXCLUDED.rating,
+			review = EXCLUDED.review,
+			updated_at = EXCLUDED.updated_at
+	`, review.Username, review.BookID, rating, review.Text, review.CreatedAt, review.UpdatedAt)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - SaveReview - r.Pool.Exec: %w", err)
+	}
+	if err = bdr.refreshRating(ctx, review.BookID); err != nil {
+		return fmt.Errorf("BookDatabaseRepo - SaveReview - %w", err)
+	}
+	return nil
+}
+
+func (bdr *BookDatabaseRepo) DeleteReview(ctx context.Context, username, bookID string) error {
+	_, err := bdr.Conn(ctx).Exec(ctx, `
+		DELETE FROM book_review
+		WHERE username = $1 AND book_id = $2
+	`, username, bookID)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - DeleteReview - r.Pool.Exec: %w", err)
+	}
+	if err = bdr.refreshRating(ctx, bookID); err != nil {
+		return fmt.Errorf("BookDatabaseRepo - DeleteReview - %w", err)
+	}
+	return nil
+}
+
+// refreshRating recomputes the aggregate stored on library_book, it is
+// idempotent so a concurrent review only needs another refresh.
+func (bdr *BookDatabaseRepo) refreshRating(ctx context.Context, bookID string) error {
+	_, err := bdr.Conn(ctx).Exec(ctx, `
+		UPDATE library_book
+		SET rating_avg = r.avg,
+			rating_count = r.count
+		FROM (
+			SELECT ROUND(AVG(rating), 2) AS avg, COUNT(rating) AS count
+			FROM book_review
+			WHERE book_id = $1
+		) r
+		WHERE id = $1
+	`, bookID)
+	if err != nil {
+		return fmt.Errorf("refreshRating - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
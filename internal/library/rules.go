@@ -0,0 +1,88 @@
+package library
+
+import (
+	"context"
+	"strings"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// SetMetadataRules rewrites the metadata of uploads with the rules of
+// source, see entity.MetadataRule.
+func (uc *BookShelf) SetMetadataRules(source MetadataRuleSource) {
+	uc.rules = source
+}
+
+// applyMetadataRules runs the rules in order, each sees what the ones
+// before it changed. Broken rules are logged and skipped.
+func (uc *BookShelf) applyMetadataRules(ctx context.Context, book entity.Book) entity.Book {
+	if uc.rules == nil {
+		return book
+	}
+	rules, err := uc.rules.MetadataRules(ctx)
+	if err != nil {
+		uc.logger.Error("BookShelf - applyMetadataRules - s.rules.MetadataRules: %s", err)
+		return book
+	}
+	for i, rule := range rules {
+		re, err := rule.Compile()
+		if err != nil {
+			uc.logger.Error("BookShelf - applyMetadataRules - rule %d: %s", i+1, err)
+			continue
+		}
+		value := metadataRuleValue(book, rule.Field)
+		if rule.Target == "" {
+			setMetadataRuleValue(&book, rule.Field, re.ReplaceAllString(value, rule.Replace))
+			continue
+		}
+		match := re.FindStringSubmatchIndex(value)
+		if match == nil {
+			continue
+		}
+		setMetadataRuleValue(&book, rule.Target, string(re.ExpandString(nil, rule.Replace, value, match)))
+	}
+	return book
+}
+
+func metadataRuleValue(book entity.Book, field string) string {
+	switch field {
+	case "title":
+		return book.Title
+	case "author":
+		return book.Author
+	case "publisher":
+		return book.Publisher
+	case "series":
+		return book.Series
+	case "series_index":
+		if book.SeriesIndex == nil || !book.SeriesIndex.Valid {
+			return ""
+		}
+		return book.SeriesIndex.Decimal.String()
+	case "language":
+		return book.Language
+	case "isbn":
+		return book.ISBN
+	}
+	return ""
+}
+
+func setMetadataRuleValue(book *entity.Book, field, value string) {
+	value = strings.TrimSpace(value)
+	switch field {
+	case "title":
+		book.Title = value
+	case "author":
+		book.Author = value
+	case "publisher":
+		book.Publisher = value
+	case "series":
+		book.Series = value
+	case "series_index":
+		book.SeriesIndex = parseSeriesIndex(value)
+	case "language":
+		book.Language = normalizeLanguage(value)
+	case "isbn":
+		book.ISBN = value
+	}
+}
@@ -0,0 +1,51 @@
+package library_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+type staticRules []entity.MetadataRule
+
+func (r staticRules) MetadataRules(context.Context) ([]entity.MetadataRule, error) {
+	return r, nil
+}
+
+func TestMetadataRulesRewriteUploads(t *testing.T) {
+	repo := &uniqueBookRepo{books: make(map[string]entity.Book)}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	shelf.SetMetadataRules(staticRules{
+		{Field: "title", Pattern: `\s*\[retail\]`},
+		{Field: "title", Pattern: `^(?P<series>.+?) #(?P<n>\d+) (?P<title>.+)$`, Replace: "${series}", Target: "series"},
+		{Field: "title", Pattern: `^.+? #(\d+) .+$`, Replace: "$1", Target: "series_index"},
+		{Field: "title", Pattern: `^.+? #\d+ (.+)$`, Replace: "$1"},
+		{Field: "author", Pattern: `^Asimov$`, Replace: "Isaac Asimov"},
+		{Field: "nowhere", Pattern: `.`},
+	})
+
+	book := storeUntitledBook(t, shelf, "Asimov - Foundation #2 Foundation and Empire [retail].fb2", "a")
+	if book.Title != "Foundation and Empire" || book.Series != "Foundation" || book.Author != "Isaac Asimov" {
+		t.Fatalf("unexpected metadata %q by %q in %q", book.Title, book.Author, book.Series)
+	}
+	if book.SeriesIndex == nil || book.SeriesIndex.Decimal.String() != "2" {
+		t.Fatalf("unexpected series index %v", book.SeriesIndex)
+	}
+}
+
+func TestMetadataRuleCompile(t *testing.T) {
+	for _, rule := range []entity.MetadataRule{
+		{Field: "summary", Pattern: "x"},
+		{Field: "title", Pattern: ""},
+		{Field: "title", Pattern: "(unclosed"},
+		{Field: "title", Pattern: "x", Target: "cover"},
+	} {
+		if _, err := rule.Compile(); err == nil {
+			t.Fatalf("expected %+v to be refused", rule)
+		}
+	}
+}
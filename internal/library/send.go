@@ -0,0 +1,142 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"os"
+	"strings"
+
+	"github.com/banjuer/kompanion/pkg/mailer"
+)
+
+var (
+	ErrDeliveryNotConfigured = errors.New("send to device is not configured")
+	ErrInvalidEmail          = errors.New("invalid email")
+	ErrUnsupportedFormat     = errors.New("format is not accepted by device and can not be converted")
+)
+
+// formats accepted by Amazon Send to Kindle
+var deviceFormats = map[string]string{
+	"epub": "application/epub+zip",
+	"pdf":  "application/pdf",
+	"txt":  "text/plain",
+	"rtf":  "application/rtf",
+	"doc":  "application/msword",
+	"docx": "application/vnd.openxmlformats-officedocument.wordprocessingml.document",
+	"htm":  "text/html",
+	"html": "text/html",
+}
+
+// deviceConvertFormat is the target for every other format, e.g. fb2 or mobi.
+const deviceConvertFormat = "epub"
+
+// SetDelivery enables SendToDevice. converter may be nil, then only
+// formats accepted by the device are sent.
+func (uc *BookShelf) SetDelivery(m Mailer, c Converter) {
+	uc.mailer = m
+	uc.converter = c
+}
+
+// SendToDevice emails the book to a device address, like calibre's "send to Kindle".
+func (uc *BookShelf) SendToDevice(ctx context.Context, bookID, email string) error {
+	if uc.mailer == nil {
+		return ErrDeliveryNotConfigured
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil {
+		return fmt.Errorf("BookShelf - SendToDevice - mail.ParseAddress: %w", ErrInvalidEmail)
+	}
+
+	// a format the device accepts needs no conversion
+	var preferred string
+	if files, err := uc.BookFiles(ctx, bookID); err == nil {
+		for _, file := range files {
+			if _, ok := deviceFormats[file.Format]; ok {
+				preferred = file.Format
+				break
+			}
+		}
+	}
+	book, file, err := uc.DownloadBook(ctx, bookID, preferred)
+	if err != nil {
+		return fmt.Errorf("BookShelf - SendToDevice - uc.DownloadBook: %w", err)
+	}
+	defer file.Close()
+
+	var attachment io.Reader = file
+	format := strings.ToLower(book.Extension())
+	if book.IsAudiobook() {
+		return fmt.Errorf("BookShelf - SendToDevice - %s: %w", format, ErrUnsupportedFormat)
+	}
+	if _, ok := deviceFormats[format]; !ok {
+		if uc.converter == nil {
+			return fmt.Errorf("BookShelf - SendToDevice - %s: %w", format, ErrUnsupportedFormat)
+		}
+		converted, err := uc.convert(ctx, file, format)
+		if err != nil {
+			return fmt.Errorf("BookShelf - SendToDevice - %w", err)
+		}
+		defer converted.Close()
+		attachment = converted
+		format = deviceConvertFormat
+	}
+
+	filename := strings.TrimSuffix(book.Filename(), book.Extension()) + format
+	err = uc.mailer.Send(ctx, mailer.Message{
+		To:      address.Address,
+		Subject: book.Title,
+		Body:    fmt.Sprintf("%s\n\nSent from KOmpanion.\n", book.Title),
+		Attachments: []mailer.Attachment{{
+			Filename:    filename,
+			ContentType: deviceFormats[format],
+			Content:     attachment,
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("BookShelf - SendToDevice - uc.mailer.Send: %w", err)
+	}
+	uc.logger.Info("BookShelf - SendToDevice - book %s sent to %s", bookID, address.Address)
+
+	return nil
+}
+
+// convert hands the book to the converter, which works on files, and opens
+// the result. The converted file is gone once closed.
+func (uc *BookShelf) convert(ctx context.Context, book io.Reader, format string) (*os.File, error) {
+	path, err := uc.convertTo(ctx, book, format, deviceConvertFormat)
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(path)
+	converted, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("os.Open: %w", err)
+	}
+	return converted, nil
+}
+
+// convertTo converts the book of format to target and returns the path of
+// the converted file, the caller removes it.
+func (uc *BookShelf) convertTo(ctx context.Context, book io.Reader, format, target string) (string, error) {
+	source, err := os.CreateTemp("", "send-*."+format)
+	if err != nil {
+		return "", fmt.Errorf("os.CreateTemp: %w", err)
+	}
+	defer os.Remove(source.Name())
+	_, err = io.Copy(source, book)
+	if closeErr := source.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("io.Copy: %w", err)
+	}
+
+	path, err := uc.converter.Convert(ctx, source.Name(), target)
+	if err != nil {
+		return "", fmt.Errorf("uc.converter.Convert: %w", err)
+	}
+	return path, nil
+}
@@ -0,0 +1,110 @@
+package library
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/storage"
+)
+
+// MaxShareLinkTTL is the longest a share link stays valid.
+const MaxShareLinkTTL = 30 * 24 * time.Hour
+
+// CreateShareLink lets anyone holding the returned token download the book
+// for ttl, maxDownloads times at most when it is not 0. The token is
+// returned once and can not be shown again.
+func (uc *BookShelf) CreateShareLink(ctx context.Context, username, bookID string, ttl time.Duration, maxDownloads int) (entity.ShareLink, string, error) {
+	if ttl <= 0 || ttl > MaxShareLinkTTL || maxDownloads < 0 {
+		return entity.ShareLink{}, "", entity.ErrInvalidShareLink
+	}
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return entity.ShareLink{}, "", fmt.Errorf("BookShelf - CreateShareLink - s.getBook: %w", err)
+	}
+
+	secret := make([]byte, 24)
+	if _, err = rand.Read(secret); err != nil {
+		return entity.ShareLink{}, "", fmt.Errorf("BookShelf - CreateShareLink - rand.Read: %w", err)
+	}
+	token := base64.RawURLEncoding.EncodeToString(secret)
+
+	link := entity.ShareLink{
+		BookID:       book.ID,
+		Username:     username,
+		Prefix:       token[:6],
+		MaxDownloads: maxDownloads,
+		ExpiresAt:    time.Now().Add(ttl),
+	}
+	link, err = uc.repo.CreateShareLink(ctx, link, hashShareToken(token))
+	if err != nil {
+		return entity.ShareLink{}, "", fmt.Errorf("BookShelf - CreateShareLink - s.repo.CreateShareLink: %w", err)
+	}
+	return link, token, nil
+}
+
+// ShareLinks lists the links username created for the book that are not
+// revoked, expired and used up ones included.
+func (uc *BookShelf) ShareLinks(ctx context.Context, username, bookID string) ([]entity.ShareLink, error) {
+	links, err := uc.repo.ListShareLinks(ctx, username, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - ShareLinks - s.repo.ListShareLinks: %w", err)
+	}
+	return links, nil
+}
+
+// RevokeShareLink stops a link username created from working.
+func (uc *BookShelf) RevokeShareLink(ctx context.Context, username, id string) error {
+	if err := uc.repo.RevokeShareLink(ctx, username, id); err != nil {
+		return fmt.Errorf("BookShelf - RevokeShareLink - s.repo.RevokeShareLink: %w", err)
+	}
+	return nil
+}
+
+// SharedBook returns the book a share link is for, without counting a
+// download, e.g. to show what is shared before downloading.
+func (uc *BookShelf) SharedBook(ctx context.Context, token string) (entity.ShareLink, entity.Book, error) {
+	link, err := uc.repo.GetShareLinkByHash(ctx, hashShareToken(token))
+	if err != nil {
+		return entity.ShareLink{}, entity.Book{}, fmt.Errorf("BookShelf - SharedBook - s.repo.GetShareLinkByHash: %w", err)
+	}
+	if err = link.Usable(time.Now()); err != nil {
+		return link, entity.Book{}, fmt.Errorf("BookShelf - SharedBook - %w", err)
+	}
+	book, err := uc.repo.GetById(ctx, link.BookID)
+	if err != nil {
+		return link, entity.Book{}, fmt.Errorf("BookShelf - SharedBook - s.repo.GetById: %w", err)
+	}
+	return link, book, nil
+}
+
+// DownloadSharedBook opens the book file of a share link. With count the
+// download is counted, resumed downloads are not.
+func (uc *BookShelf) DownloadSharedBook(ctx context.Context, token string, count bool) (entity.Book, storage.File, error) {
+	link, _, err := uc.SharedBook(ctx, token)
+	if err != nil {
+		return entity.Book{}, nil, err
+	}
+	book, file, err := uc.DownloadBook(ctx, link.BookID, "")
+	if err != nil {
+		return entity.Book{}, nil, fmt.Errorf("BookShelf - DownloadSharedBook - %w", err)
+	}
+	if count {
+		// a concurrent download may have taken the last one
+		if err = uc.repo.CountShareDownload(ctx, link.ID); err != nil {
+			file.Close()
+			return entity.Book{}, nil, fmt.Errorf("BookShelf - DownloadSharedBook - s.repo.CountShareDownload: %w", err)
+		}
+	}
+	return book, file, nil
+}
+
+func hashShareToken(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:])
+}
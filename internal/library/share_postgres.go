@@ -0,0 +1,109 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+const shareLinkColumns = `id, book_id, username, token_prefix, max_downloads, downloads, expires_at, created_at, revoked_at`
+
+func scanShareLink(row pgx.Row) (entity.ShareLink, error) {
+	var l entity.ShareLink
+	err := row.Scan(&l.ID, &l.BookID, &l.Username, &l.Prefix, &l.MaxDownloads, &l.Downloads, &l.ExpiresAt, &l.CreatedAt, &l.RevokedAt)
+	return l, err
+}
+
+func (bdr *BookDatabaseRepo) CreateShareLink(ctx context.Context, link entity.ShareLink, hash string) (entity.ShareLink, error) {
+	link, err := scanShareLink(bdr.Conn(ctx).QueryRow(ctx, `
+		INSERT INTO book_share_link (book_id, username, token_prefix, token_hash, max_downloads, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+		RETURNING `+shareLinkColumns,
+		link.BookID, link.Username, link.Prefix, hash, link.MaxDownloads, link.ExpiresAt))
+	if err != nil {
+		return entity.ShareLink{}, fmt.Errorf("BookDatabaseRepo - CreateShareLink - row.Scan: %w", err)
+	}
+	return link, nil
+}
+
+// GetShareLinkByHash returns revoked and expired links too, the caller
+// tells why they can not be used.
+func (bdr *BookDatabaseRepo) GetShareLinkByHash(ctx context.Context, hash string) (entity.ShareLink, error) {
+	link, err := scanShareLink(bdr.Conn(ctx).QueryRow(ctx, `
+		SELECT `+shareLinkColumns+`
+		FROM book_share_link
+		WHERE token_hash = $1
+	`, hash))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.ShareLink{}, entity.ErrShareLinkNotFound
+	}
+	if err != nil {
+		return entity.ShareLink{}, fmt.Errorf("BookDatabaseRepo - GetShareLinkByHash - row.Scan: %w", err)
+	}
+	return link, nil
+}
+
+// ListShareLinks returns the links username created for the book that are
+// not revoked, newest first.
+func (bdr *BookDatabaseRepo) ListShareLinks(ctx context.Context, username, bookID string) ([]entity.ShareLink, error) {
+	rows, err := bdr.Conn(ctx).Query(ctx, `
+		SELECT `+shareLinkColumns+`
+		FROM book_share_link
+		WHERE username = $1 AND book_id = $2 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`, username, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListShareLinks - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	links := make([]entity.ShareLink, 0)
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("BookDatabaseRepo - ListShareLinks - rows.Scan: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - ListShareLinks - rows.Err: %w", err)
+	}
+	return links, nil
+}
+
+func (bdr *BookDatabaseRepo) RevokeShareLink(ctx context.Context, username, id string) error {
+	tag, err := bdr.Conn(ctx).Exec(ctx, `
+		UPDATE book_share_link
+		SET revoked_at = NOW()
+		WHERE id = $1 AND username = $2 AND revoked_at IS NULL
+	`, id, username)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - RevokeShareLink - r.Pool.Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return entity.ErrShareLinkNotFound
+	}
+	return nil
+}
+
+// CountShareDownload counts a download of the link unless it was used up,
+// expired or revoked in the meantime.
+func (bdr *BookDatabaseRepo) CountShareDownload(ctx context.Context, id string) error {
+	tag, err := bdr.Conn(ctx).Exec(ctx, `
+		UPDATE book_share_link
+		SET downloads = downloads + 1
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > NOW()
+			AND (max_downloads = 0 OR downloads < max_downloads)
+	`, id)
+	if err != nil {
+		return fmt.Errorf("BookDatabaseRepo - CountShareDownload - r.Pool.Exec: %w", err)
+	}
+	if tag.RowsAffected() == 0 {
+		return entity.ErrShareLinkUsedUp
+	}
+	return nil
+}
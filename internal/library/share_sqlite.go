@@ -0,0 +1,211 @@
+package library
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/moroz/uuidv7-go"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+func (bsr *BookSQLiteRepo) CreateShareLink(ctx context.Context, link entity.ShareLink, hash string) (entity.ShareLink, error) {
+	link.ID, link.Downloads, link.CreatedAt, link.RevokedAt = uuidv7.Generate().String(), 0, time.Now().UTC(), nil
+	_, err := bsr.Conn(ctx).Exec(ctx, `
+		INSERT INTO book_share_link (id, book_id, username, token_prefix, token_hash, max_downloads, expires_at, created_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+	`, link.ID, link.BookID, link.Username, link.Prefix, hash, link.MaxDownloads, link.ExpiresAt, link.CreatedAt)
+	if err != nil {
+		return entity.ShareLink{}, fmt.Errorf("BookSQLiteRepo - CreateShareLink - r.DB.Exec: %w", err)
+	}
+	return link, nil
+}
+
+// GetShareLinkByHash returns revoked and expired links too, the caller
+// tells why they can not be used.
+func (bsr *BookSQLiteRepo) GetShareLinkByHash(ctx context.Context, hash string) (entity.ShareLink, error) {
+	link, err := scanShareLink(bsr.Conn(ctx).QueryRow(ctx, `
+		SELECT `+shareLinkColumns+`
+		FROM book_share_link
+		WHERE token_hash = $1
+	`, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.ShareLink{}, entity.ErrShareLinkNotFound
+	}
+	if err != nil {
+		return entity.ShareLink{}, fmt.Errorf("BookSQLiteRepo - GetShareLinkByHash - row.Scan: %w", err)
+	}
+	return link, nil
+}
+
+// ListShareLinks returns the links username created for the book that are
+// not revoked, newest first.
+func (bsr *BookSQLiteRepo) ListShareLinks(ctx context.Context, username, bookID string) ([]entity.ShareLink, error) {
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT `+shareLinkColumns+`
+		FROM book_share_link
+		WHERE username = $1 AND book_id = $2 AND revoked_at IS NULL
+		ORDER BY created_at DESC
+	`, username, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListShareLinks - r.DB.Query: %w", err)
+	}
+	defer rows.Close()
+
+	links := make([]entity.ShareLink, 0)
+	for rows.Next() {
+		link, err := scanShareLink(rows)
+		if err != nil {
+			return nil, fmt.Errorf("BookSQLiteRepo - ListShareLinks - rows.Scan: %w", err)
+		}
+		links = append(links, link)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListShareLinks - rows.Err: %w", err)
+	}
+	return links, nil
+}
+
+func (bsr *BookSQLiteRepo) RevokeShareLink(ctx context.Context, username, id string) error {
+	result, err := bsr.Conn(ctx).Exec(ctx, `
+		UPDATE book_share_link
+		SET revoked_at = now()
+		WHERE id = $1 AND username = $2 AND revoked_at IS NULL
+	`, id, username)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - RevokeShareLink - r.DB.Exec: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return entity.ErrShareLinkNotFound
+	}
+	return nil
+}
+
+// CountShareDownload counts a download of the link unless it was used up,
+// expired or revoked in the meantime.
+func (bsr *BookSQLiteRepo) CountShareDownload(ctx context.Context, id string) error {
+	result, err := bsr.Conn(ctx).Exec(ctx, `
+		UPDATE book_share_link
+		SET downloads = downloads + 1
+		WHERE id = $1 AND revoked_at IS NULL AND expires_at > now()
+			AND (max_downloads = 0 OR downloads < max_downloads)
+	`, id)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - CountShareDownload - r.DB.Exec: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return entity.ErrShareLinkUsedUp
+	}
+	return nil
+}
+
+func (bsr *BookSQLiteRepo) CreateLoan(ctx context.Context, loan entity.Loan) (entity.Loan, error) {
+	loan.ID, loan.CreatedAt, loan.EndedAt = uuidv7.Generate().String(), time.Now().UTC(), nil
+	_, err := bsr.Conn(ctx).Exec(ctx, `
+		INSERT INTO library_book_loan (id, book_id, lender, borrower, created_at, expires_at)
+		VALUES ($1, $2, $3, $4, $5, $6)
+	`, loan.ID, loan.BookID, loan.Lender, loan.Borrower, loan.CreatedAt, loan.ExpiresAt)
+	if err != nil {
+		return entity.Loan{}, fmt.Errorf("BookSQLiteRepo - CreateLoan - r.DB.Exec: %w", err)
+	}
+	if loan, err = bsr.GetLoan(ctx, loan.ID); err != nil {
+		return entity.Loan{}, fmt.Errorf("BookSQLiteRepo - CreateLoan - %w", err)
+	}
+	return loan, nil
+}
+
+func (bsr *BookSQLiteRepo) GetLoan(ctx context.Context, id string) (entity.Loan, error) {
+	loan, err := scanLoan(bsr.Conn(ctx).QueryRow(ctx, `
+		SELECT `+loanColumns+`
+		FROM library_book_loan l JOIN library_book b ON b.id = l.book_id
+		WHERE l.id = $1
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return entity.Loan{}, entity.ErrLoanNotFound
+	}
+	if err != nil {
+		return entity.Loan{}, fmt.Errorf("BookSQLiteRepo - GetLoan - row.Scan: %w", err)
+	}
+	return loan, nil
+}
+
+// ListLoans returns the loans username lent or borrowed that have not
+// ended, soonest to expire first.
+func (bsr *BookSQLiteRepo) ListLoans(ctx context.Context, username string) ([]entity.Loan, error) {
+	rows, err := bsr.Conn(ctx).Query(ctx, `
+		SELECT `+loanColumns+`
+		FROM library_book_loan l JOIN library_book b ON b.id = l.book_id
+		WHERE (l.lender = $1 OR l.borrower = $1) AND l.ended_at IS NULL
+		ORDER BY l.expires_at, l.id
+	`, username)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListLoans - r.DB.Query: %w", err)
+	}
+	loans, err := scanSQLiteLoans(rows)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - ListLoans - rows.Scan: %w", err)
+	}
+	return loans, nil
+}
+
+// EndLoan ends a loan that has not ended yet.
+func (bsr *BookSQLiteRepo) EndLoan(ctx context.Context, id string, endedAt time.Time) error {
+	result, err := bsr.Conn(ctx).Exec(ctx, `
+		UPDATE library_book_loan SET ended_at = $2
+		WHERE id = $1 AND ended_at IS NULL
+	`, id, endedAt)
+	if err != nil {
+		return fmt.Errorf("BookSQLiteRepo - EndLoan - r.DB.Exec: %w", err)
+	}
+	if n, _ := result.RowsAffected(); n == 0 {
+		return fmt.Errorf("BookSQLiteRepo - EndLoan - %w", entity.ErrLoanNotFound)
+	}
+	return nil
+}
+
+// EndExpiredLoans ends the loans that expired before now and returns them,
+// each loan is returned once.
+func (bsr *BookSQLiteRepo) EndExpiredLoans(ctx context.Context, now time.Time) ([]entity.Loan, error) {
+	var loans []entity.Loan
+	err := bsr.WithTx(ctx, func(ctx context.Context) error {
+		rows, err := bsr.Conn(ctx).Query(ctx, `
+			SELECT `+loanColumns+`
+			FROM library_book_loan l JOIN library_book b ON b.id = l.book_id
+			WHERE l.ended_at IS NULL AND l.expires_at <= $1
+			ORDER BY l.expires_at, l.id
+		`, now)
+		if err != nil {
+			return fmt.Errorf("tx.Query: %w", err)
+		}
+		if loans, err = scanSQLiteLoans(rows); err != nil {
+			return fmt.Errorf("rows.Scan: %w", err)
+		}
+		for i := range loans {
+			loans[i].EndedAt = &loans[i].ExpiresAt
+			if _, err = bsr.Conn(ctx).Exec(ctx, `UPDATE library_book_loan SET ended_at = expires_at WHERE id = $1`, loans[i].ID); err != nil {
+				return fmt.Errorf("tx.Exec: %w", err)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - EndExpiredLoans - %w", err)
+	}
+	return loans, nil
+}
+
+func scanSQLiteLoans(rows *sql.Rows) ([]entity.Loan, error) {
+	defer rows.Close()
+	loans := make([]entity.Loan, 0)
+	for rows.Next() {
+		loan, err := scanLoan(rows)
+		if err != nil {
+			return nil, err
+		}
+		loans = append(loans, loan)
+	}
+	return loans, rows.Err()
+}
@@ -0,0 +1,97 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func TestShareLinks(t *testing.T) {
+	ctx := context.Background()
+	bookStorage := storage.NewMemoryStorage()
+	writeStorageFile(t, bookStorage, "2024/01/01/book-id.epub", "epub content")
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", FilePath: "2024/01/01/book-id.epub"}}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+
+	if _, _, err := shelf.CreateShareLink(ctx, "user", "book-id", 0, 1); !errors.Is(err, entity.ErrInvalidShareLink) {
+		t.Fatalf("expected ErrInvalidShareLink without a ttl, got %v", err)
+	}
+	if _, _, err := shelf.CreateShareLink(ctx, "user", "book-id", 2*library.MaxShareLinkTTL, 1); !errors.Is(err, entity.ErrInvalidShareLink) {
+		t.Fatalf("expected ErrInvalidShareLink for a long ttl, got %v", err)
+	}
+
+	link, token, err := shelf.CreateShareLink(ctx, "user", "book-id", time.Hour, 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if link.BookID != "book-id" || link.Prefix != token[:6] || len(token) < 32 {
+		t.Fatalf("unexpected link %+v with token %q", link, token)
+	}
+
+	// resumed downloads are not counted
+	for _, count := range []bool{true, false, true} {
+		_, file, err := shelf.DownloadSharedBook(ctx, token, count)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		content, _ := io.ReadAll(file)
+		file.Close()
+		if string(content) != "epub content" {
+			t.Fatalf("unexpected content %q", content)
+		}
+	}
+	if _, _, err = shelf.DownloadSharedBook(ctx, token, true); !errors.Is(err, entity.ErrShareLinkUsedUp) {
+		t.Fatalf("expected ErrShareLinkUsedUp, got %v", err)
+	}
+
+	links, err := shelf.ShareLinks(ctx, "user", "book-id")
+	if err != nil || len(links) != 1 || links[0].Downloads != 2 {
+		t.Fatalf("unexpected links %+v, %v", links, err)
+	}
+
+	_, token, err = shelf.CreateShareLink(ctx, "user", "book-id", time.Hour, 0)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err = shelf.RevokeShareLink(ctx, "other", links[0].ID); !errors.Is(err, entity.ErrShareLinkNotFound) {
+		t.Fatalf("expected other accounts not to revoke the link, got %v", err)
+	}
+	links, _ = shelf.ShareLinks(ctx, "user", "book-id")
+	for _, link := range links {
+		if err = shelf.RevokeShareLink(ctx, "user", link.ID); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if _, _, err = shelf.DownloadSharedBook(ctx, token, true); !errors.Is(err, entity.ErrShareLinkNotFound) {
+		t.Fatalf("expected ErrShareLinkNotFound after revoking, got %v", err)
+	}
+	if _, _, err = shelf.SharedBook(ctx, "unknown"); !errors.Is(err, entity.ErrShareLinkNotFound) {
+		t.Fatalf("expected ErrShareLinkNotFound, got %v", err)
+	}
+}
+
+func TestShareLinkUsable(t *testing.T) {
+	now := time.Now()
+	revoked := now.Add(-time.Minute)
+	for _, tt := range []struct {
+		link entity.ShareLink
+		want error
+	}{
+		{entity.ShareLink{ExpiresAt: now.Add(time.Hour)}, nil},
+		{entity.ShareLink{ExpiresAt: now.Add(time.Hour), MaxDownloads: 3, Downloads: 2}, nil},
+		{entity.ShareLink{ExpiresAt: now.Add(time.Hour), MaxDownloads: 3, Downloads: 3}, entity.ErrShareLinkUsedUp},
+		{entity.ShareLink{ExpiresAt: now}, entity.ErrShareLinkExpired},
+		{entity.ShareLink{ExpiresAt: now.Add(time.Hour), RevokedAt: &revoked}, entity.ErrShareLinkNotFound},
+	} {
+		if err := tt.link.Usable(now); !errors.Is(err, tt.want) {
+			t.Errorf("%+v: expected %v, got %v", tt.link, tt.want, err)
+		}
+	}
+}
@@ -1,10 +1,16 @@
 package library
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/moroz/uuidv7-go"
@@ -13,8 +19,12 @@ import (
 	"github.com/banjuer/kompanion/internal/bookmeta"
 	"github.com/banjuer/kompanion/internal/entity"
 	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/comic"
 	"github.com/banjuer/kompanion/pkg/logger"
 	"github.com/banjuer/kompanion/pkg/metadata"
+	"github.com/banjuer/kompanion/pkg/richtext"
+	"github.com/banjuer/kompanion/pkg/thumbnail"
+	"github.com/banjuer/kompanion/pkg/tracing"
 	"github.com/banjuer/kompanion/pkg/utils"
 )
 
@@ -23,109 +33,255 @@ type BookShelf struct {
 	storage          storage.Storage
 	repo             BookRepo
 	logger           logger.Interface
-	metadataProvider bookmeta.Provider
+	metadata         *bookmeta.Chain
+	covers           CoverSource
+	mailer           Mailer
+	converter        Converter
+	coverCache       CoverCache
+	encoder          CoverEncoder
+	comics           ComicReader
+	jobs             JobLock
+	queue            JobQueue
+	jobOptions       JobOptions
+	cdn              CDN
+	limits           UploadLimits
+	ingest           ingestLocks
+	ingestLock       IngestLock
+	pathTemplate     PathTemplate
+	filenamePatterns []FilenamePattern
+	placing          ingestLocks
+	verifying        atomic.Bool
+	converting       atomic.Bool
+	ingestHooks      []IngestHook
+	finishHooks      []FinishHook
+	deleteHooks      []DeleteHook
+	downloadHooks    []DownloadHook
+	loanHooks        []LoanHook
+	metrics          Metrics
+	rules            MetadataRuleSource
+	preferences      UploadPreferences
+	counts           countCache
+	cache            ResponseCache
+	cacheTTL         time.Duration
+	pages            PageLimits
 }
 
 // NewBookShelf 创建BookShelf实例
 func NewBookShelf(storage storage.Storage, repo BookRepo, l logger.Interface, providers ...bookmeta.Provider) *BookShelf {
-	var metadataProvider bookmeta.Provider
-	if len(providers) > 0 {
-		metadataProvider = providers[0]
-	}
-	return &BookShelf{
-		storage:          storage,
-		repo:             repo,
-		logger:           l,
-		metadataProvider: metadataProvider,
-	}
+	uc := &BookShelf{
+		storage:      storage,
+		repo:         repo,
+		logger:       l,
+		metrics:      noMetrics{},
+		pages:        DefaultPageLimits,
+		comics:       comic.New(""),
+		pathTemplate: PathTemplate{components: strings.Split(DefaultPathTemplate, "/")},
+	}
+	if len(providers) > 0 && providers[0] != nil {
+		// the provider fills what the file leaves out
+		uc.metadata, _ = bookmeta.NewChain([]bookmeta.Source{{Name: bookmeta.FileSource}, {Name: "isbn", ISBN: providers[0]}}, nil)
+	}
+	// the defaults parse
+	uc.filenamePatterns, _ = ParseFilenamePatterns(nil)
+	return uc
 }
 
+// StoreBook stores an upload, tempFile is a temp file the caller deletes
+// after StoreBook returns. Filesystem storage links it instead of copying.
 func (uc *BookShelf) StoreBook(ctx context.Context, tempFile *os.File, uploadedFilename string) (entity.Book, error) {
+	return uc.storeFile(ctx, tempFile, uploadedFilename, true)
+}
+
+// storeFile is StoreBook for files the caller may not own, like the ones
+// of an imported directory: only temp files are linked into storage.
+func (uc *BookShelf) storeFile(ctx context.Context, file *os.File, uploadedFilename string, temp bool) (entity.Book, error) {
+	ctx, span := tracing.Start(ctx, "BookShelf.StoreBook")
+	defer span.End()
+	start := time.Now()
+	book, err := uc.storeBook(ctx, file, uploadedFilename, temp)
+	result := uploadResult(err)
+	uc.metrics.BookUploaded(result, time.Since(start))
+	span.SetAttribute("upload.result", result)
+	if result == UploadFailed {
+		span.RecordError(err)
+	}
+	return book, err
+}
+
+func (uc *BookShelf) storeBook(ctx context.Context, tempFile *os.File, uploadedFilename string, temp bool) (entity.Book, error) {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - %w", err)
+	}
+	if err := uc.checkUploadSize(tempFile); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - %w", err)
+	}
 	koreaderPartialMD5, err := utils.PartialMD5(tempFile.Name())
 	if err != nil {
 		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - PartialMD5: %w", err)
 	}
+	// a concurrent upload of the same file finds the book stored here
+	unlock, err := uc.lockIngest(ctx, &uc.ingest, "ingest:"+koreaderPartialMD5)
+	if err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - %w", err)
+	}
+	defer unlock()
 	foundBook, err := uc.repo.GetByFileHash(ctx, koreaderPartialMD5)
 	if err == nil {
 		return foundBook, entity.ErrBookAlreadyExists
 	}
 
+	extractStart := time.Now()
+	_, extractSpan := tracing.Start(ctx, "extract metadata")
 	m, err := metadata.ExtractBookMetadata(tempFile)
+	extractSpan.RecordError(err)
+	extractSpan.SetAttribute("book.format", m.Format)
+	extractSpan.End()
 	if err != nil {
 		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - exractMetadata: %w", err)
 	}
+	uc.metrics.MetadataExtracted(m.Format, time.Since(extractStart))
 	if m.Format == "" {
 		return entity.Book{}, errors.New("BookShelf - StoreBook - unknown file format")
 	}
+	if err = uc.checkUploadFormat(m.Format); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - %w", err)
+	}
+	if err = uc.checkBookLimit(ctx); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - %w", err)
+	}
+	if err = uc.checkQuota(ctx, UploaderFrom(ctx), m.Size); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - %w", err)
+	}
+	if metadata.IsComic(m.Format) {
+		m = uc.readComic(ctx, tempFile, m)
+	}
 
 	bookID := uuidv7.Generate()
 	createDate := time.Now()
-	storagepath := fmt.Sprintf("%s/%s.%s", createDate.Format("2006/01/02"), bookID, m.Format)
-
-	err = uc.storage.Write(ctx, tempFile.Name(), storagepath)
-	if err != nil {
-		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - s.storage.Write: %w", err)
-	}
-	uc.logger.Info("BookShelf - StoreBook - documentID: %s", koreaderPartialMD5)
 
-	coverBytes := m.Cover
 	book := entity.Book{
 		ID:          bookID.String(),
 		Title:       m.Title,
 		Author:      m.Author,
-		Description: m.Description,
+		Description: richtext.Sanitize(m.Description),
 		Publisher:   m.Publisher,
 		Year:        0,
 		CreatedAt:   createDate,
 		UpdatedAt:   createDate,
 		ISBN:        m.ISBN,
+		DOI:         metadata.NormalizeDOI(m.DOI),
 		DocumentID:  koreaderPartialMD5,
-		FilePath:    storagepath,
 		Format:      m.Format,
 		Series:      m.Series,
+		Language:    metadata.NormalizeLanguage(m.Language),
+		Pages:       m.Pages,
+		FileSize:    m.Size,
+		SeriesIndex: parseSeriesIndex(m.SeriesIndex),
+		MediaType:   entity.MediaTypeBook,
+		Genres:      m.Genres,
+		UploadedBy:  UploaderFrom(ctx),
+	}
+	// without an uploader nobody could see the book
+	book.Private = uc.privateUpload(ctx) && book.UploadedBy != ""
+	if metadata.IsAudio(m.Format) {
+		book.MediaType = entity.MediaTypeAudiobook
+		book.Duration = m.Duration.Round(time.Second)
+	}
+	uc.fillFromFilename(&book, m.Date, uploadedFilename)
+
+	// the sources look up the ISBN sent with the upload, and do not
+	// replace what was sent
+	book = overrideUploadMetadata(ctx, book)
+	book, coverBytes := uc.enrichBookMetadata(ctx, book, m.Cover)
+	book = uc.applyMetadataRules(ctx, book)
+	book = overrideUploadMetadata(ctx, book)
+	book.ISBN = normalizeISBN(book.ISBN)
+	if len(coverBytes) == 0 {
+		coverBytes = uc.fetchCover(ctx, book)
+	}
+
+	// the path template sees the enriched metadata
+	pathBook := book
+	if pathBook.Year == 0 {
+		pathBook.Year = metadataYear(m.Date)
+	}
+	// metadata extraction moved the offset, the upload streams from the start
+	_, err = tempFile.Seek(0, io.SeekStart)
+	if err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - tempFile.Seek: %w", err)
 	}
-
-	if m.SeriesIndex != "" {
-		if d, err := decimal.NewFromString(m.SeriesIndex); err == nil {
-			seriesIndex := decimal.NewNullDecimal(d)
-			book.SeriesIndex = &seriesIndex
-		}
+	var upload io.Reader = tempFile
+	if temp {
+		upload = storage.TempFile{File: tempFile}
 	}
-
-	book, enrichedCover := uc.enrichBookMetadata(ctx, book)
-	if len(coverBytes) == 0 && len(enrichedCover) > 0 {
-		coverBytes = enrichedCover
+	book.FilePath, err = uc.putBookFile(ctx, pathBook, upload)
+	if err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - s.storage.Put: %w", err)
 	}
+	uc.logger.Info("BookShelf - StoreBook - documentID: %s", koreaderPartialMD5)
 
-	coverPath, err := writeCover(ctx, uc.storage, coverBytes, bookID.String())
+	coverPath, err := writeCover(ctx, uc.storage, coverBytes)
 	if err != nil {
 		uc.logger.Error("BookShelf - StoreBook - writeCover: %s", err)
 	}
 	book.CoverPath = coverPath
+	if coverPath != "" {
+		book.CoverSize = int64(len(coverBytes))
+	}
 
-	// place in database
+	// place in database, the files written so far are removed again when
+	// that fails
+	ingestID := uc.recordIngest(ctx, book)
 	err = uc.repo.Store(
 		ctx,
 		book,
 	)
 	if err != nil {
+		uc.compensateIngest(ctx, ingestID, book, err)
+		if errors.Is(err, entity.ErrBookAlreadyExists) {
+			// another server stored the file first, keep its book
+			if foundBook, err := uc.repo.GetByFileHash(ctx, koreaderPartialMD5); err == nil {
+				return foundBook, entity.ErrBookAlreadyExists
+			}
+		}
 		return entity.Book{}, fmt.Errorf("BookShelf - StoreBook - s.repo.Store: %w", err)
 	}
+	uc.finishIngest(ctx, ingestID)
+	uc.libraryChanged(ctx)
+	if len(m.Chapters) > 0 {
+		chapters := make([]entity.Chapter, len(m.Chapters))
+		for i, c := range m.Chapters {
+			chapters[i] = entity.Chapter{Title: c.Title, Start: c.Start}
+		}
+		if err = uc.repo.StoreChapters(ctx, book.ID, chapters); err != nil {
+			uc.logger.Error("BookShelf - StoreBook - s.repo.StoreChapters: %s", err)
+		}
+	}
+	uc.bookIngested(ctx, book)
 	return book, nil
 }
 
 // ListBooks -. 从数据库获取书籍列表
 func (uc *BookShelf) ListBooks(ctx context.Context,
+	filter BookFilter,
 	sortBy, sortOrder string,
 	page, perPage int) (PaginatedBookList, error) {
-	books, err := uc.repo.List(ctx, sortBy, sortOrder, page, perPage)
+	ctx, span := tracing.Start(ctx, "BookShelf.ListBooks")
+	defer span.End()
+	perPage = uc.perPage(ctx, perPage)
+	filter = filter.forReader(ctx)
+	key := uc.listKey(ctx, "ListBooks", filter, sortBy, sortOrder, page, perPage)
+	if list, ok := uc.cachedList(ctx, key); ok {
+		return list, nil
+	}
+	books, err := uc.repo.List(ctx, filter, sortBy, sortOrder, page, perPage)
 	if err != nil {
 		return PaginatedBookList{}, fmt.Errorf("BookShelf - ListBooks - s.repo.List: %w", err)
 	}
 
-	totalCount, err := uc.repo.Count(ctx)
+	totalCount, approximate, err := uc.countBooks(ctx, "", filter)
 	if err != nil {
-		return PaginatedBookList{}, fmt.Errorf("BookShelf - ListBooks - s.repo.Count: %w", err)
+		return PaginatedBookList{}, fmt.Errorf("BookShelf - ListBooks - countBooks: %w", err)
 	}
 
 	pbl := NewPaginatedBookList(
@@ -134,23 +290,41 @@ func (uc *BookShelf) ListBooks(ctx context.Context,
 		page,
 		totalCount,
 	)
+	pbl.ApproximateTotal = approximate
 
-	return pbl, nil
+	if pbl, err = uc.withFacets(ctx, "", filter, pbl, "ListBooks"); err != nil {
+		return PaginatedBookList{}, err
+	}
+	list, err := uc.withEditions(ctx, filter, pbl, "ListBooks")
+	return uc.cacheList(ctx, key, list, err)
 }
 
 // SearchBooks -. 搜索书籍
 func (uc *BookShelf) SearchBooks(ctx context.Context,
 	query string,
+	filter BookFilter,
 	sortBy, sortOrder string,
 	page, perPage int) (PaginatedBookList, error) {
-	books, err := uc.repo.Search(ctx, query, sortBy, sortOrder, page, perPage)
+	ctx, span := tracing.Start(ctx, "BookShelf.SearchBooks")
+	defer span.End()
+	perPage = uc.perPage(ctx, perPage)
+	filter = filter.forReader(ctx)
+	query = searchQuery(ctx, query)
+	if _, err := ParseSearchQuery(query); err != nil {
+		return PaginatedBookList{}, fmt.Errorf("BookShelf - SearchBooks - ParseSearchQuery: %w", err)
+	}
+	key := uc.listKey(ctx, "SearchBooks", query, filter, sortBy, sortOrder, page, perPage)
+	if list, ok := uc.cachedList(ctx, key); ok {
+		return list, nil
+	}
+	books, err := uc.repo.Search(ctx, query, filter, sortBy, sortOrder, page, perPage)
 	if err != nil {
 		return PaginatedBookList{}, fmt.Errorf("BookShelf - SearchBooks - s.repo.Search: %w", err)
 	}
 
-	totalCount, err := uc.repo.CountSearch(ctx, query)
+	totalCount, approximate, err := uc.countBooks(ctx, query, filter)
 	if err != nil {
-		return PaginatedBookList{}, fmt.Errorf("BookShelf - SearchBooks - s.repo.CountSearch: %w", err)
+		return PaginatedBookList{}, fmt.Errorf("BookShelf - SearchBooks - countBooks: %w", err)
 	}
 
 	pbl := NewPaginatedBookList(
@@ -159,36 +333,179 @@ func (uc *BookShelf) SearchBooks(ctx context.Context,
 		page,
 		totalCount,
 	)
+	pbl.ApproximateTotal = approximate
+
+	if pbl, err = uc.withFacets(ctx, query, filter, pbl, "SearchBooks"); err != nil {
+		return PaginatedBookList{}, err
+	}
+	list, err := uc.withEditions(ctx, filter, pbl, "SearchBooks")
+	return uc.cacheList(ctx, key, list, err)
+}
+
+// ListBooksByCursor -. keyset pagination, cursor is empty for the first page.
+// Page numbers are not known in this mode, use NextCursor and PrevCursor.
+func (uc *BookShelf) ListBooksByCursor(ctx context.Context,
+	filter BookFilter,
+	sortBy, sortOrder, cursor string,
+	perPage int) (PaginatedBookList, error) {
+	sortBy, sortOrder = normalizeSort(sortBy, sortOrder)
+	c, err := decodeLocaleCursor(cursor, sortBy, sortOrder, LocaleFrom(ctx))
+	if err != nil {
+		return PaginatedBookList{}, fmt.Errorf("BookShelf - ListBooksByCursor - decodeLocaleCursor: %w", err)
+	}
+	perPage = uc.perPage(ctx, perPage)
+	filter = filter.forReader(ctx)
+	key := uc.listKey(ctx, "ListBooksByCursor", filter, sortBy, sortOrder, cursor, perPage)
+	if list, ok := uc.cachedList(ctx, key); ok {
+		return list, nil
+	}
+
+	books, err := uc.repo.ListByCursor(ctx, filter, sortBy, sortOrder, c, perPage+1)
+	if err != nil {
+		return PaginatedBookList{}, fmt.Errorf("BookShelf - ListBooksByCursor - s.repo.ListByCursor: %w", err)
+	}
+
+	list, err := uc.withFacets(ctx, "", filter, newCursorBookList(books, c, perPage), "ListBooksByCursor")
+	if err != nil {
+		return PaginatedBookList{}, err
+	}
+	list, err = uc.withEditions(ctx, filter, list, "ListBooksByCursor")
+	return uc.cacheList(ctx, key, list, err)
+}
+
+// SearchBooksByCursor -. keyset pagination for search
+func (uc *BookShelf) SearchBooksByCursor(ctx context.Context,
+	query string,
+	filter BookFilter,
+	sortBy, sortOrder, cursor string,
+	perPage int) (PaginatedBookList, error) {
+	sortBy, sortOrder = normalizeSort(sortBy, sortOrder)
+	c, err := decodeLocaleCursor(cursor, sortBy, sortOrder, LocaleFrom(ctx))
+	if err != nil {
+		return PaginatedBookList{}, fmt.Errorf("BookShelf - SearchBooksByCursor - decodeLocaleCursor: %w", err)
+	}
+	perPage = uc.perPage(ctx, perPage)
+	filter = filter.forReader(ctx)
+	query = searchQuery(ctx, query)
+	if _, err = ParseSearchQuery(query); err != nil {
+		return PaginatedBookList{}, fmt.Errorf("BookShelf - SearchBooksByCursor - ParseSearchQuery: %w", err)
+	}
+	key := uc.listKey(ctx, "SearchBooksByCursor", query, filter, sortBy, sortOrder, cursor, perPage)
+	if list, ok := uc.cachedList(ctx, key); ok {
+		return list, nil
+	}
+
+	books, err := uc.repo.SearchByCursor(ctx, query, filter, sortBy, sortOrder, c, perPage+1)
+	if err != nil {
+		return PaginatedBookList{}, fmt.Errorf("BookShelf - SearchBooksByCursor - s.repo.SearchByCursor: %w", err)
+	}
+
+	list, err := uc.withFacets(ctx, query, filter, newCursorBookList(books, c, perPage), "SearchBooksByCursor")
+	if err != nil {
+		return PaginatedBookList{}, err
+	}
+	list, err = uc.withEditions(ctx, filter, list, "SearchBooksByCursor")
+	return uc.cacheList(ctx, key, list, err)
+}
 
-	return pbl, nil
+// Languages -. languages present in the library, for filtering
+func (uc *BookShelf) Languages(ctx context.Context) ([]string, error) {
+	languages, err := uc.repo.Languages(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - Languages - s.repo.Languages: %w", err)
+	}
+	return languages, nil
+}
+
+// searchQuery drops a leading article of the request locale, so "the hobbit"
+// also finds "Hobbit, The".
+func searchQuery(ctx context.Context, query string) string {
+	if stripped := strings.TrimSpace(StripArticle(LocaleFrom(ctx), query)); stripped != "" {
+		return stripped
+	}
+	return query
+}
+
+// defaultPerPage is the page size of listings that ask for none.
+const defaultPerPage = 25
+
+// PageLimits caps the books per listing page. Admins may ask for larger
+// pages than other accounts and devices, e.g. to export the library.
+type PageLimits struct {
+	Max      int
+	AdminMax int
+}
+
+// DefaultPageLimits are used until SetPageLimits is called.
+var DefaultPageLimits = PageLimits{Max: 100, AdminMax: 1000}
+
+// SetPageLimits sets the page size caps, values below 1 keep the default
+// and admins get at least the cap of everyone else.
+func (uc *BookShelf) SetPageLimits(limits PageLimits) {
+	if limits.Max < 1 {
+		limits.Max = DefaultPageLimits.Max
+	}
+	if limits.AdminMax < 1 {
+		limits.AdminMax = DefaultPageLimits.AdminMax
+	}
+	limits.AdminMax = max(limits.AdminMax, limits.Max)
+	uc.pages = limits
+}
+
+// perPage is the page size a listing gets: the default when it asks for
+// none, at most the cap of the account.
+func (uc *BookShelf) perPage(ctx context.Context, perPage int) int {
+	if perPage <= 0 {
+		return defaultPerPage
+	}
+	limit := uc.pages.Max
+	if role, ok := entity.RoleFrom(ctx); ok && role.CanManage() {
+		limit = uc.pages.AdminMax
+	}
+	return min(perPage, limit)
 }
 
 func (uc *BookShelf) ViewBook(ctx context.Context, bookID string) (entity.Book, error) {
-	book, err := uc.repo.GetById(ctx, bookID)
+	book, err := uc.getBook(ctx, bookID)
 	if err != nil {
-		return entity.Book{}, fmt.Errorf("BookShelf - GetBook - s.repo.Get: %w", err)
+		return entity.Book{}, fmt.Errorf("BookShelf - GetBook - s.getBook: %w", err)
 	}
 
 	return book, nil
 }
 
 func (uc *BookShelf) UpdateBookMetadata(ctx context.Context, bookID string, metadata entity.Book) (entity.Book, error) {
-	book, err := uc.repo.GetById(ctx, bookID)
+	ctx, span := tracing.Start(ctx, "BookShelf.UpdateBookMetadata")
+	defer span.End()
+	if err := entity.RequireEditor(ctx); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - UpdateBookMetadata - %w", err)
+	}
+	book, err := uc.getBook(ctx, bookID)
 	if err != nil {
 		return entity.Book{}, fmt.Errorf("BookShelf - UpdateBookMetadata - s.repo.Get: %w", err)
 	}
 
+	if book.Archived() {
+		return entity.Book{}, fmt.Errorf("BookShelf - UpdateBookMetadata - %w", entity.ErrBookArchived)
+	}
+	if err = checkISBN(book.ISBN, metadata.ISBN); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - UpdateBookMetadata - %w", err)
+	}
+
 	updatedBook := entity.Book{
 		ID:          book.ID,
 		Title:       utils.If(metadata.Title == "", book.Title, metadata.Title),
 		Author:      utils.If(metadata.Author == "", book.Author, metadata.Author),
-		Description: utils.If(metadata.Description == "", book.Description, metadata.Description),
+		Description: utils.If(metadata.Description == "", book.Description, richtext.Sanitize(metadata.Description)),
 		Publisher:   utils.If(metadata.Publisher == "", book.Publisher, metadata.Publisher),
 		Year:        utils.If(metadata.Year == 0, book.Year, metadata.Year),
-		ISBN:        utils.If(metadata.ISBN == "", book.ISBN, metadata.ISBN),
+		ISBN:        utils.If(metadata.ISBN == "", book.ISBN, normalizeISBN(metadata.ISBN)),
+		DOI:         utils.If(metadata.DOI == "", book.DOI, normalizeDOI(metadata.DOI)),
 		Series:      utils.If(metadata.Series == "", book.Series, metadata.Series),
+		Language:    utils.If(metadata.Language == "", book.Language, normalizeLanguage(metadata.Language)),
 		SeriesIndex: metadata.SeriesIndex,
 		CoverPath:   book.CoverPath,
+		CoverSize:   book.CoverSize,
 		UpdatedAt:   time.Now(),
 	}
 
@@ -200,12 +517,16 @@ func (uc *BookShelf) UpdateBookMetadata(ctx context.Context, bookID string, meta
 	if err != nil {
 		return entity.Book{}, fmt.Errorf("BookShelf - UpdateBookMetadata - s.repo.Update: %w", err)
 	}
+	uc.libraryChanged(ctx)
 
 	return updatedBook, nil
 }
 
 func (uc *BookShelf) EnrichBookMetadata(ctx context.Context, bookID string) (entity.Book, error) {
-	book, err := uc.repo.GetById(ctx, bookID)
+	if err := entity.RequireEditor(ctx); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - EnrichBookMetadata - %w", err)
+	}
+	book, err := uc.getBook(ctx, bookID)
 	if err != nil {
 		return entity.Book{}, fmt.Errorf("BookShelf - EnrichBookMetadata - s.repo.Get: %w", err)
 	}
@@ -214,7 +535,10 @@ func (uc *BookShelf) EnrichBookMetadata(ctx context.Context, bookID string) (ent
 }
 
 func (uc *BookShelf) EnrichBookMetadataFromBase(ctx context.Context, bookID string, metadata entity.Book) (entity.Book, error) {
-	book, err := uc.repo.GetById(ctx, bookID)
+	if err := entity.RequireEditor(ctx); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - EnrichBookMetadataFromBase - %w", err)
+	}
+	book, err := uc.getBook(ctx, bookID)
 	if err != nil {
 		return entity.Book{}, fmt.Errorf("BookShelf - EnrichBookMetadataFromBase - s.repo.Get: %w", err)
 	}
@@ -225,7 +549,8 @@ func (uc *BookShelf) EnrichBookMetadataFromBase(ctx context.Context, bookID stri
 	baseBook.Description = metadata.Description
 	baseBook.Publisher = metadata.Publisher
 	baseBook.Year = metadata.Year
-	baseBook.ISBN = metadata.ISBN
+	baseBook.ISBN = normalizeISBN(metadata.ISBN)
+	baseBook.DOI = normalizeDOI(metadata.DOI)
 	baseBook.Series = metadata.Series
 	baseBook.SeriesIndex = metadata.SeriesIndex
 
@@ -233,25 +558,44 @@ func (uc *BookShelf) EnrichBookMetadataFromBase(ctx context.Context, bookID stri
 }
 
 func (uc *BookShelf) enrichAndStoreBookMetadata(ctx context.Context, book entity.Book) (entity.Book, error) {
-	if book.ISBN == "" {
-		return entity.Book{}, fmt.Errorf("BookShelf - enrichAndStoreBookMetadata - isbn is empty")
+	if book.Archived() {
+		return entity.Book{}, fmt.Errorf("BookShelf - enrichAndStoreBookMetadata - %w", entity.ErrBookArchived)
+	}
+	if book.ISBN == "" && book.DOI == "" {
+		return entity.Book{}, fmt.Errorf("BookShelf - enrichAndStoreBookMetadata - isbn and doi are empty")
 	}
-	if uc.metadataProvider == nil {
+	if uc.metadata == nil {
 		return entity.Book{}, fmt.Errorf("BookShelf - enrichAndStoreBookMetadata - metadata provider is not configured")
 	}
 
-	lookup, err := uc.metadataProvider.LookupByISBN(ctx, book.ISBN)
+	found, err := uc.metadata.Lookup(ctx, book)
+	if len(found) == 0 {
+		if err == nil {
+			err = bookmeta.ErrBookNotFound
+		}
+		return entity.Book{}, fmt.Errorf("BookShelf - enrichAndStoreBookMetadata - metadata.Lookup: %w", err)
+	}
 	if err != nil {
-		return entity.Book{}, fmt.Errorf("BookShelf - enrichAndStoreBookMetadata - provider.LookupByISBN: %w", err)
+		uc.logger.Warn("BookShelf - enrichAndStoreBookMetadata - metadata.Lookup: %s", err)
 	}
 
-	updatedBook := bookmeta.MergeMissingBookMetadata(book, lookup.Book)
-	if uc.bookNeedsCover(ctx, updatedBook) && len(lookup.Cover) > 0 {
-		coverPath, err := writeCover(ctx, uc.storage, lookup.Cover, book.ID)
+	// fields set on the book are kept, the providers fill the others in
+	// the order of the chain
+	lookup, cover := uc.metadata.Merge(entity.Book{}, nil, found)
+	lookup.Description = richtext.Sanitize(lookup.Description)
+	lookup.Language = normalizeLanguage(lookup.Language)
+	updatedBook := bookmeta.MergeMissingBookMetadata(book, lookup)
+	if updatedBook.Language == "" {
+		updatedBook.Language = lookup.Language
+	}
+	updatedBook.ISBN = normalizeISBN(updatedBook.ISBN)
+	if uc.bookNeedsCover(ctx, updatedBook) && len(cover) > 0 {
+		coverPath, err := writeCover(ctx, uc.storage, cover)
 		if err != nil {
 			return entity.Book{}, fmt.Errorf("BookShelf - enrichAndStoreBookMetadata - writeCover: %w", err)
 		}
 		updatedBook.CoverPath = coverPath
+		updatedBook.CoverSize = int64(len(cover))
 	}
 	updatedBook.UpdatedAt = time.Now()
 
@@ -259,6 +603,10 @@ func (uc *BookShelf) enrichAndStoreBookMetadata(ctx context.Context, book entity
 	if err != nil {
 		return entity.Book{}, fmt.Errorf("BookShelf - enrichAndStoreBookMetadata - s.repo.Update: %w", err)
 	}
+	uc.libraryChanged(ctx)
+	if book.CoverPath != updatedBook.CoverPath {
+		uc.releaseCover(ctx, book.CoverPath)
+	}
 
 	return updatedBook, nil
 }
@@ -267,7 +615,7 @@ func (uc *BookShelf) bookNeedsCover(ctx context.Context, book entity.Book) bool
 	if book.CoverPath == "" {
 		return true
 	}
-	cover, err := uc.storage.Read(ctx, book.CoverPath)
+	cover, err := uc.storage.Open(ctx, book.CoverPath)
 	if err != nil {
 		return true
 	}
@@ -275,36 +623,81 @@ func (uc *BookShelf) bookNeedsCover(ctx context.Context, book entity.Book) bool
 	return false
 }
 
-func (uc *BookShelf) enrichBookMetadata(ctx context.Context, book entity.Book) (entity.Book, []byte) {
-	if uc.metadataProvider == nil || book.ISBN == "" {
-		return book, nil
+// SetMetadataChain looks up uploads with the providers of chain, taking
+// each field from the first source that has it.
+func (uc *BookShelf) SetMetadataChain(chain *bookmeta.Chain) {
+	uc.metadata = chain
+}
+
+// SetCoverSource fetches covers for uploads without one, by their ISBN.
+func (uc *BookShelf) SetCoverSource(covers CoverSource) {
+	uc.covers = covers
+}
+
+// fetchCover asks the cover source for the cover of a book, nil without a
+// source, an ISBN or a cover.
+func (uc *BookShelf) fetchCover(ctx context.Context, book entity.Book) []byte {
+	if uc.covers == nil || book.ISBN == "" || book.MediaType == entity.MediaTypeAudiobook {
+		return nil
+	}
+	ctx, span := tracing.Start(ctx, "fetch cover")
+	defer span.End()
+	cover, err := uc.covers.Cover(ctx, book.ISBN)
+	if err != nil {
+		if !errors.Is(err, bookmeta.ErrBookNotFound) {
+			uc.logger.Warn("BookShelf - fetchCover - covers.Cover: %s", err)
+		}
+		return nil
 	}
+	return cover
+}
+
+// enrichBookMetadata merges the metadata and cover of the file with the
+// ones the providers know of the book.
+func (uc *BookShelf) enrichBookMetadata(ctx context.Context, book entity.Book, cover []byte) (entity.Book, []byte) {
+	if uc.metadata == nil {
+		return book, cover
+	}
+	ctx, span := tracing.Start(ctx, "enrich metadata")
+	defer span.End()
 
-	lookup, err := uc.metadataProvider.LookupByISBN(ctx, book.ISBN)
+	found, err := uc.metadata.Lookup(ctx, book)
 	if err != nil {
-		uc.logger.Warn("BookShelf - enrichBookMetadata - provider.LookupByISBN: %s", err)
-		return book, nil
+		uc.logger.Warn("BookShelf - enrichBookMetadata - metadata.Lookup: %s", err)
+	}
+	if len(found) == 0 {
+		return book, cover
 	}
 
-	return bookmeta.MergeMissingBookMetadata(book, lookup.Book), lookup.Cover
+	book, cover = uc.metadata.Merge(book, cover, found)
+	book.Description = richtext.Sanitize(book.Description)
+	book.Language = normalizeLanguage(book.Language)
+	return book, cover
 }
 
-func (uc *BookShelf) DownloadBook(ctx context.Context, bookID string) (entity.Book, *os.File, error) {
-	book, err := uc.repo.GetById(ctx, bookID)
-	if err != nil {
-		return book, nil, fmt.Errorf("BookShelf - DownloadBook - s.repo.Get: %s", err)
+// Chapters lists the chapters of an audiobook in playing order, books
+// have none.
+func (uc *BookShelf) Chapters(ctx context.Context, bookID string) ([]entity.Chapter, error) {
+	if _, err := uc.getBook(ctx, bookID); err != nil {
+		return nil, fmt.Errorf("BookShelf - Chapters - s.getBook: %w", err)
 	}
-	file, err := uc.storage.Read(ctx, book.FilePath)
+	chapters, err := uc.repo.ListChapters(ctx, bookID)
 	if err != nil {
-		return book, nil, fmt.Errorf("BookShelf - DownloadBook - s.storage.Read: %s", err)
+		return nil, fmt.Errorf("BookShelf - Chapters - s.repo.ListChapters: %w", err)
 	}
-	return book, file, nil
+	return chapters, nil
 }
 
 func (uc *BookShelf) ViewCover(ctx context.Context, bookID string) (*os.File, error) {
-	book, err := uc.repo.GetById(ctx, bookID)
+	return uc.cachedCover(ctx, bookID, thumbnail.Options{}, func() (*os.File, error) {
+		return uc.viewCover(ctx, bookID)
+	})
+}
+
+func (uc *BookShelf) viewCover(ctx context.Context, bookID string) (*os.File, error) {
+	book, err := uc.getBook(ctx, bookID)
 	if err != nil {
-		return nil, fmt.Errorf("BookShelf - ViewCover - s.repo.Get: %s", err)
+		return nil, fmt.Errorf("BookShelf - ViewCover - s.getBook: %s", err)
 	}
 	if book.CoverPath == "" {
 		return nil, fmt.Errorf("BookShelf - ViewCover - no cover")
@@ -317,9 +710,16 @@ func (uc *BookShelf) ViewCover(ctx context.Context, bookID string) (*os.File, er
 }
 
 func (uc *BookShelf) UpdateCover(ctx context.Context, bookID string, coverFile *os.File) (entity.Book, error) {
-	book, err := uc.repo.GetById(ctx, bookID)
+	if err := entity.RequireEditor(ctx); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - UpdateCover - %w", err)
+	}
+	book, err := uc.getBook(ctx, bookID)
 	if err != nil {
-		return entity.Book{}, fmt.Errorf("BookShelf - UpdateCover - s.repo.GetById: %w", err)
+		return entity.Book{}, fmt.Errorf("BookShelf - UpdateCover - s.getBook: %w", err)
+	}
+
+	if book.Archived() {
+		return entity.Book{}, fmt.Errorf("BookShelf - UpdateCover - %w", entity.ErrBookArchived)
 	}
 
 	oldCoverPath := book.CoverPath
@@ -329,12 +729,13 @@ func (uc *BookShelf) UpdateCover(ctx context.Context, bookID string, coverFile *
 		return entity.Book{}, fmt.Errorf("BookShelf - UpdateCover - os.ReadFile: %w", err)
 	}
 
-	newCoverPath, err := writeCover(ctx, uc.storage, coverBytes, bookID)
+	newCoverPath, err := writeCover(ctx, uc.storage, coverBytes)
 	if err != nil {
 		return entity.Book{}, fmt.Errorf("BookShelf - UpdateCover - writeCover: %w", err)
 	}
 
 	book.CoverPath = newCoverPath
+	book.CoverSize = int64(len(coverBytes))
 	book.UpdatedAt = time.Now()
 
 	err = uc.repo.Update(ctx, book)
@@ -342,26 +743,39 @@ func (uc *BookShelf) UpdateCover(ctx context.Context, bookID string, coverFile *
 		return entity.Book{}, fmt.Errorf("BookShelf - UpdateCover - s.repo.Update: %w", err)
 	}
 
-	if oldCoverPath != "" && oldCoverPath != newCoverPath {
-		err = uc.storage.Delete(ctx, oldCoverPath)
-		if err != nil {
-			uc.logger.Warn("BookShelf - UpdateCover - failed to delete old cover: %s", err)
-		}
+	uc.libraryChanged(ctx)
+
+	if oldCoverPath != newCoverPath {
+		uc.releaseCover(ctx, oldCoverPath)
 	}
 
 	return book, nil
 }
 
 func (uc *BookShelf) DeleteBook(ctx context.Context, bookID string) error {
-	book, err := uc.repo.GetById(ctx, bookID)
+	ctx, span := tracing.Start(ctx, "BookShelf.DeleteBook")
+	defer span.End()
+	if err := entity.RequireEditor(ctx); err != nil {
+		return fmt.Errorf("BookShelf - DeleteBook - %w", err)
+	}
+	book, err := uc.getBook(ctx, bookID)
 	if err != nil {
-		return fmt.Errorf("BookShelf - DeleteBook - s.repo.GetById: %w", err)
+		return fmt.Errorf("BookShelf - DeleteBook - s.getBook: %w", err)
+	}
+	if book.Archived() {
+		return fmt.Errorf("BookShelf - DeleteBook - %w", entity.ErrBookArchived)
+	}
+
+	files, err := uc.repo.ListFiles(ctx, bookID)
+	if err != nil {
+		return fmt.Errorf("BookShelf - DeleteBook - s.repo.ListFiles: %w", err)
 	}
 
 	err = uc.repo.Delete(ctx, bookID)
 	if err != nil {
 		return fmt.Errorf("BookShelf - DeleteBook - s.repo.Delete: %w", err)
 	}
+	uc.libraryChanged(ctx)
 
 	if book.FilePath != "" {
 		err = uc.storage.Delete(ctx, book.FilePath)
@@ -369,40 +783,142 @@ func (uc *BookShelf) DeleteBook(ctx context.Context, bookID string) error {
 			uc.logger.Warn("BookShelf - DeleteBook - failed to delete book file: %s", err)
 		}
 	}
-
-	if book.CoverPath != "" {
-		err = uc.storage.Delete(ctx, book.CoverPath)
-		if err != nil {
-			uc.logger.Warn("BookShelf - DeleteBook - failed to delete cover file: %s", err)
+	for _, file := range files {
+		if err = uc.storage.Delete(ctx, file.FilePath); err != nil {
+			uc.logger.Warn("BookShelf - DeleteBook - failed to delete book file: %s", err)
 		}
 	}
 
+	uc.releaseCover(ctx, book.CoverPath)
+	uc.bookDeleted(ctx, book)
+
 	return nil
 }
 
-func writeCover(
-	ctx context.Context,
-	storage storage.Storage,
-	cover []byte,
-	bookID string,
-) (string, error) {
+// ArchiveBook keeps the book exactly as stored: metadata edits, cover or
+// file changes and deletion fail with entity.ErrBookArchived until
+// UnarchiveBook is run from the command line.
+func (uc *BookShelf) ArchiveBook(ctx context.Context, bookID string) (entity.Book, error) {
+	return uc.setArchived(ctx, bookID, time.Now().UTC().Truncate(time.Microsecond))
+}
+
+// UnarchiveBook lifts the archive flag, it is left to the administrator.
+func (uc *BookShelf) UnarchiveBook(ctx context.Context, bookID string) (entity.Book, error) {
+	return uc.setArchived(ctx, bookID, time.Time{})
+}
+
+func (uc *BookShelf) setArchived(ctx context.Context, bookID string, archivedAt time.Time) (entity.Book, error) {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - setArchived - %w", err)
+	}
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - setArchived - s.getBook: %w", err)
+	}
+	if book.Archived() == !archivedAt.IsZero() {
+		return book, nil
+	}
+	if err = uc.repo.SetArchived(ctx, bookID, archivedAt); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - setArchived - s.repo.SetArchived: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	book.ArchivedAt = archivedAt
+	return book, nil
+}
+
+// parseSeriesIndex reads a series index from book metadata, nil when the
+// file has none or it is not a number.
+func parseSeriesIndex(s string) *decimal.NullDecimal {
+	d, err := decimal.NewFromString(s)
+	if err != nil {
+		return nil
+	}
+	seriesIndex := decimal.NewNullDecimal(d)
+	return &seriesIndex
+}
+
+// coverPath names a cover after the SHA-256 of its content, books with
+// the same cover share one file.
+func coverPath(cover []byte) string {
+	sum := sha256.Sum256(cover)
+	return "covers/" + hex.EncodeToString(sum[:]) + ".jpg"
+}
+
+// writeCover stores a cover under its content address, a cover already
+// in storage is not written again.
+func writeCover(ctx context.Context, storage storage.Storage, cover []byte) (string, error) {
 	if len(cover) == 0 {
 		return "", nil
 	}
-	coverTempFile, err := os.CreateTemp("", "cover")
+	coverpath := coverPath(cover)
+	if file, err := storage.Open(ctx, coverpath); err == nil {
+		file.Close()
+		return coverpath, nil
+	}
+	err := storage.Put(ctx, coverpath, bytes.NewReader(cover))
 	if err != nil {
-		return "", fmt.Errorf("BookShelf - writeCover - os.CreateTemp: %w", err)
+		return "", fmt.Errorf("BookShelf - writeCover - s.storage.Put: %w", err)
 	}
-	defer coverTempFile.Close()
-	_, err = coverTempFile.Write(cover)
+	return coverpath, nil
+}
+
+// releaseCover deletes a cover file once no book refers to it anymore. It
+// is called after the reference is gone from the database.
+func (uc *BookShelf) releaseCover(ctx context.Context, coverPath string) {
+	if coverPath == "" {
+		return
+	}
+	refs, err := uc.repo.Count(ctx, BookFilter{CoverPath: coverPath})
 	if err != nil {
-		return "", fmt.Errorf("BookShelf - writeCover - coverTempFile.Write: %w", err)
+		uc.logger.Warn("BookShelf - releaseCover - s.repo.Count: %s", err)
+		return
+	}
+	if refs > 0 {
+		return
+	}
+	if err = uc.storage.Delete(ctx, coverPath); err != nil {
+		uc.logger.Warn("BookShelf - releaseCover - failed to delete cover file: %s", err)
 	}
+	uc.deleteCoverSizes(ctx, coverPath)
+}
+
+// sameCoverLimit caps the "other books with this cover" hint.
+const sameCoverLimit = 10
 
-	coverpath := fmt.Sprintf("covers/%s.jpg", bookID)
-	err = storage.Write(ctx, coverTempFile.Name(), coverpath)
+// SameCoverBooks lists other books sharing the cover of a book, often
+// editions of the same work or duplicates.
+func (uc *BookShelf) SameCoverBooks(ctx context.Context, bookID string) ([]entity.Book, error) {
+	book, err := uc.getBook(ctx, bookID)
 	if err != nil {
-		return "", fmt.Errorf("BookShelf - writeCover - s.storage.Write: %w", err)
+		return nil, fmt.Errorf("BookShelf - SameCoverBooks - s.getBook: %w", err)
 	}
-	return coverpath, nil
+	if book.CoverPath == "" {
+		return nil, nil
+	}
+	filter := BookFilter{CoverPath: book.CoverPath}.forReader(ctx)
+	list, err := uc.repo.List(ctx, filter, "created_at", "asc", 1, sameCoverLimit+1)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - SameCoverBooks - s.repo.List: %w", err)
+	}
+	books := make([]entity.Book, 0, len(list))
+	for _, other := range list {
+		if other.ID != book.ID && len(books) < sameCoverLimit {
+			books = append(books, other)
+		}
+	}
+	return books, nil
+}
+
+// maxRandomBooks caps one draw of RandomBooks.
+const maxRandomBooks = 50
+
+// RandomBooks draws up to n books matching filter in random order, for a
+// "surprise me" shelf. n is between 1 and maxRandomBooks.
+func (uc *BookShelf) RandomBooks(ctx context.Context, n int, filter BookFilter) ([]entity.Book, error) {
+	n = min(max(n, 1), maxRandomBooks)
+	books, err := uc.repo.Random(ctx, filter.forReader(ctx), n)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - RandomBooks - s.repo.Random: %w", err)
+	}
+	return books, nil
 }
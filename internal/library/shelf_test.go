@@ -1,14 +1,30 @@
 package library_test
 
 import (
+	"archive/zip"
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 
 	"github.com/banjuer/kompanion/internal/bookmeta"
 	"github.com/banjuer/kompanion/internal/entity"
 	"github.com/banjuer/kompanion/internal/library"
 	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/cache"
+	"github.com/banjuer/kompanion/pkg/comic"
+	"github.com/banjuer/kompanion/pkg/isbn"
 	"github.com/banjuer/kompanion/pkg/logger"
+	"github.com/banjuer/kompanion/pkg/mailer"
+	"github.com/banjuer/kompanion/pkg/utils"
 )
 
 func TestShelfListBooks(t *testing.T) {
@@ -41,6 +57,90 @@ func TestUpdateBookMetadataPreservesCoverPath(t *testing.T) {
 	}
 }
 
+func TestUpdateBookMetadataNormalizesISBN(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", Title: "title", ISBN: "B00LEGACY"}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+
+	if _, err := shelf.UpdateBookMetadata(ctx, "book-id", entity.Book{Title: "new title", ISBN: "B00LEGACY"}); err != nil {
+		t.Fatalf("the stored identifier sent back: %v", err)
+	}
+	if _, err := shelf.UpdateBookMetadata(ctx, "book-id", entity.Book{ISBN: "978-0-306-40615-8"}); !errors.Is(err, library.ErrInvalidISBN) {
+		t.Fatalf("expected ErrInvalidISBN, got %v", err)
+	}
+	book, err := shelf.UpdateBookMetadata(ctx, "book-id", entity.Book{ISBN: "0-306-40615-2"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if book.ISBN != "9780306406157" || repo.updated.ISBN != "9780306406157" {
+		t.Errorf("expected the ISBN-13 to be stored, got %q", repo.updated.ISBN)
+	}
+}
+
+func TestArchivedBookIsKeptAsStored(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", Title: "old title", ISBN: "9780000000000"}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+
+	book, err := shelf.ArchiveBook(ctx, "book-id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !book.Archived() || !repo.book.Archived() {
+		t.Fatalf("expected the book to be archived, got %+v", book)
+	}
+
+	if _, err = shelf.UpdateBookMetadata(ctx, "book-id", entity.Book{Title: "new title"}); !errors.Is(err, entity.ErrBookArchived) {
+		t.Errorf("expected ErrBookArchived on edit, got %v", err)
+	}
+	if _, err = shelf.EnrichBookMetadataFromBase(ctx, "book-id", entity.Book{ISBN: "9780000000000"}); !errors.Is(err, entity.ErrBookArchived) {
+		t.Errorf("expected ErrBookArchived on enrich, got %v", err)
+	}
+	if _, err = shelf.UpdateCover(ctx, "book-id", nil); !errors.Is(err, entity.ErrBookArchived) {
+		t.Errorf("expected ErrBookArchived on cover change, got %v", err)
+	}
+	if err = shelf.DeleteBook(ctx, "book-id"); !errors.Is(err, entity.ErrBookArchived) {
+		t.Errorf("expected ErrBookArchived on delete, got %v", err)
+	}
+	if repo.updated.ID != "" {
+		t.Errorf("expected no update, got %+v", repo.updated)
+	}
+
+	if _, err = shelf.UnarchiveBook(ctx, "book-id"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = shelf.UpdateBookMetadata(ctx, "book-id", entity.Book{Title: "new title"}); err != nil {
+		t.Errorf("expected the unarchived book to be editable, got %v", err)
+	}
+}
+
+func TestReadersCanNotChangeBooks(t *testing.T) {
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", Title: "old title"}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	ctx := entity.WithRole(context.Background(), entity.RoleReader)
+
+	if _, err := shelf.UpdateBookMetadata(ctx, "book-id", entity.Book{Title: "new title"}); !errors.Is(err, entity.ErrForbidden) {
+		t.Errorf("expected ErrForbidden on edit, got %v", err)
+	}
+	if _, err := shelf.ArchiveBook(ctx, "book-id"); !errors.Is(err, entity.ErrForbidden) {
+		t.Errorf("expected ErrForbidden on archive, got %v", err)
+	}
+	if err := shelf.DeleteBook(ctx, "book-id"); !errors.Is(err, entity.ErrForbidden) {
+		t.Errorf("expected ErrForbidden on delete, got %v", err)
+	}
+	if repo.updated.ID != "" || repo.book.Archived() {
+		t.Errorf("expected the book unchanged, got %+v", repo.book)
+	}
+	if _, err := shelf.ViewBook(ctx, "book-id"); err != nil {
+		t.Errorf("expected readers to view books, got %v", err)
+	}
+
+	ctx = entity.WithRole(context.Background(), entity.RoleEditor)
+	if _, err := shelf.UpdateBookMetadata(ctx, "book-id", entity.Book{Title: "new title"}); err != nil {
+		t.Errorf("expected editors to edit books, got %v", err)
+	}
+}
+
 func TestEnrichBookMetadataFillsMissingFields(t *testing.T) {
 	repo := &fakeBookRepo{
 		book: entity.Book{
@@ -74,7 +174,7 @@ func TestEnrichBookMetadataFillsMissingFields(t *testing.T) {
 	if book.Author != "豆瓣作者" || book.Publisher != "豆瓣出版社" || book.Year != 2013 {
 		t.Fatalf("expected missing metadata to be filled, got %+v", book)
 	}
-	if book.CoverPath != "covers/book-id.jpg" {
+	if book.CoverPath != contentCoverPath("cover bytes") {
 		t.Fatalf("expected douban cover to be written, got %q", book.CoverPath)
 	}
 	if repo.updated.Author != "豆瓣作者" {
@@ -104,10 +204,10 @@ func TestEnrichBookMetadataReplacesMissingCoverFile(t *testing.T) {
 		t.Fatalf("unexpected error: %v", err)
 	}
 
-	if book.CoverPath != "covers/book-id.jpg" {
+	if book.CoverPath != contentCoverPath("douban cover") {
 		t.Fatalf("expected missing cover file to be replaced, got %q", book.CoverPath)
 	}
-	if repo.updated.CoverPath != "covers/book-id.jpg" {
+	if repo.updated.CoverPath != contentCoverPath("douban cover") {
 		t.Fatalf("expected stored cover path to be replaced, got %q", repo.updated.CoverPath)
 	}
 }
@@ -159,53 +259,1307 @@ func TestEnrichBookMetadataFromBaseUsesSubmittedMetadata(t *testing.T) {
 	}
 }
 
-type fakeBookRepo struct {
-	book    entity.Book
-	updated entity.Book
+func TestEnrichBookMetadataLooksUpDOI(t *testing.T) {
+	repo := &fakeBookRepo{
+		book: entity.Book{
+			ID:    "book-id",
+			Title: "Microsoft Word - bartel_final.docx",
+			DOI:   "10.1016/j.cell.2009.01.042",
+		},
+	}
+	chain, err := bookmeta.NewChain([]bookmeta.Source{{Name: "crossref", DOI: fakeMetadataProvider{
+		result: bookmeta.LookupResult{
+			Book: entity.Book{
+				Title:  "MicroRNAs: Target Recognition and Regulatory Functions",
+				Author: "David P. Bartel",
+				Series: "Cell",
+				Year:   2009,
+			},
+		},
+	}}}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	shelf.SetMetadataChain(chain)
+
+	book, err := shelf.EnrichBookMetadata(context.Background(), "book-id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if book.Title != "Microsoft Word - bartel_final.docx" {
+		t.Fatalf("expected an existing title to be preserved, got %q", book.Title)
+	}
+	if book.Author != "David P. Bartel" || book.Series != "Cell" || book.Year != 2009 {
+		t.Fatalf("expected metadata of the DOI, got %+v", book)
+	}
 }
 
-func (r *fakeBookRepo) Store(context.Context, entity.Book) error {
-	return nil
+func contentCoverPath(cover string) string {
+	sum := sha256.Sum256([]byte(cover))
+	return "covers/" + hex.EncodeToString(sum[:]) + ".jpg"
 }
 
-func (r *fakeBookRepo) List(context.Context, string, string, int, int) ([]entity.Book, error) {
-	return nil, nil
+func TestCoversAreSharedByContent(t *testing.T) {
+	ctx := context.Background()
+	bookStorage := storage.NewMemoryStorage()
+	shared := contentCoverPath("shared cover")
+	writeStorageFile(t, bookStorage, shared, "shared cover")
+
+	repo := &fakeBookRepo{
+		book:       entity.Book{ID: "book-id", CoverPath: shared},
+		coverBooks: []entity.Book{{ID: "book-id", CoverPath: shared}, {ID: "other-id", CoverPath: shared}},
+	}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+
+	others, err := shelf.SameCoverBooks(ctx, "book-id")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(others) != 1 || others[0].ID != "other-id" {
+		t.Fatalf("expected the other book with this cover, got %+v", others)
+	}
+
+	coverFile, err := os.CreateTemp(t.TempDir(), "cover")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coverFile.Close()
+	if _, err = coverFile.WriteString("new cover"); err != nil {
+		t.Fatal(err)
+	}
+	// the book no longer refers to the shared cover, the other book does
+	repo.coverBooks = repo.coverBooks[1:]
+	book, err := shelf.UpdateCover(ctx, "book-id", coverFile)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if book.CoverPath != contentCoverPath("new cover") {
+		t.Fatalf("expected the cover under its content address, got %q", book.CoverPath)
+	}
+	if _, err = bookStorage.Open(ctx, shared); err != nil {
+		t.Fatalf("expected the shared cover to be kept: %v", err)
+	}
+
+	repo.book = entity.Book{ID: "other-id", CoverPath: shared}
+	repo.coverBooks = nil
+	if err = shelf.DeleteBook(ctx, "other-id"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = bookStorage.Open(ctx, shared); err == nil {
+		t.Fatal("expected the unreferenced cover to be deleted")
+	}
 }
 
-func (r *fakeBookRepo) Search(context.Context, string, string, string, int, int) ([]entity.Book, error) {
-	return nil, nil
+func TestComicPage(t *testing.T) {
+	ctx := context.Background()
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, page := range []string{"10.jpg", "9.jpg"} {
+		f, err := w.Create(page)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Write([]byte("page " + page))
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	bookStorage := storage.NewMemoryStorage()
+	writeStorageFile(t, bookStorage, "2024/01/01/book-id.cbz", buf.String())
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", FilePath: "2024/01/01/book-id.cbz"}}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+
+	name, data, err := shelf.ComicPage(ctx, "book-id", 2)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if name != "10.jpg" || string(data) != "page 10.jpg" {
+		t.Fatalf("expected the second page, got %q %q", name, data)
+	}
+	if _, _, err = shelf.ComicPage(ctx, "book-id", 3); !errors.Is(err, comic.ErrPageNotFound) {
+		t.Fatalf("expected ErrPageNotFound, got %v", err)
+	}
+
+	repo.book.FilePath = "2024/01/01/book-id.epub"
+	if _, _, err = shelf.ComicPage(ctx, "book-id", 1); !errors.Is(err, comic.ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat for an epub, got %v", err)
+	}
 }
 
-func (r *fakeBookRepo) Count(context.Context) (int, error) {
-	return 0, nil
+func TestSendToDeviceConvertsUnsupportedFormat(t *testing.T) {
+	ctx := context.Background()
+	bookStorage := storage.NewMemoryStorage()
+	writeStorageFile(t, bookStorage, "2024/01/01/book-id.fb2", "fb2 content")
+
+	repo := &fakeBookRepo{
+		book: entity.Book{ID: "book-id", Title: "title", FilePath: "2024/01/01/book-id.fb2"},
+	}
+	m := &fakeMailer{}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+	shelf.SetDelivery(m, fakeConverter{content: "epub content"})
+
+	err := shelf.SendToDevice(ctx, "book-id", "reader@kindle.com")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if m.sent.To != "reader@kindle.com" {
+		t.Fatalf("expected mail to device address, got %q", m.sent.To)
+	}
+	if m.filename != "title -- book-id.epub" || m.content != "epub content" {
+		t.Fatalf("expected converted epub attachment, got %q %q", m.filename, m.content)
+	}
 }
 
-func (r *fakeBookRepo) CountSearch(context.Context, string) (int, error) {
-	return 0, nil
+func TestSendToDeviceWithoutConverter(t *testing.T) {
+	ctx := context.Background()
+	bookStorage := storage.NewMemoryStorage()
+	writeStorageFile(t, bookStorage, "2024/01/01/book-id.fb2", "fb2 content")
+
+	repo := &fakeBookRepo{
+		book: entity.Book{ID: "book-id", Title: "title", FilePath: "2024/01/01/book-id.fb2"},
+	}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+	shelf.SetDelivery(&fakeMailer{}, nil)
+
+	err := shelf.SendToDevice(ctx, "book-id", "reader@kindle.com")
+	if !errors.Is(err, library.ErrUnsupportedFormat) {
+		t.Fatalf("expected ErrUnsupportedFormat, got %v", err)
+	}
 }
 
-func (r *fakeBookRepo) GetById(context.Context, string) (entity.Book, error) {
-	return r.book, nil
+func TestExportBooks(t *testing.T) {
+	ctx := context.Background()
+	bookStorage := storage.NewMemoryStorage()
+	writeStorageFile(t, bookStorage, "2024/01/01/book-id.epub", "epub content")
+	writeStorageFile(t, bookStorage, "covers/book-id.jpg", "jpeg")
+
+	repo := &fakeBookRepo{
+		book: entity.Book{ID: "book-id", Title: "Fish & Chips", Author: "author", FilePath: "2024/01/01/book-id.epub", CoverPath: "covers/book-id.jpg"},
+	}
+	shelf := library.NewBookShelf(bookStorage, repo, logger.New("error"))
+
+	var buf bytes.Buffer
+	if err := shelf.ExportBooks(ctx, &buf, []string{"book-id"}, library.ManifestOPF); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	files := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, _ := io.ReadAll(rc)
+		rc.Close()
+		files[f.Name] = string(content)
+	}
+
+	if files["book-id/Fish & Chips - author -- book-id.epub"] != "epub content" || files["book-id/cover.jpg"] != "jpeg" {
+		t.Fatalf("expected book and cover in the export, got %v", files)
+	}
+	if opf := files["book-id/metadata.opf"]; !strings.Contains(opf, "<dc:title>Fish &amp; Chips</dc:title>") || !strings.Contains(opf, `href="cover.jpg"`) {
+		t.Fatalf("unexpected metadata.opf %q", opf)
+	}
+
+	err = shelf.ExportBooks(ctx, io.Discard, nil, "xml")
+	if !errors.Is(err, library.ErrInvalidManifest) {
+		t.Fatalf("expected ErrInvalidManifest, got %v", err)
+	}
 }
 
-func (r *fakeBookRepo) GetByFileHash(context.Context, string) (entity.Book, error) {
-	return entity.Book{}, nil
+func writeStorageFile(t *testing.T, s storage.Storage, path, content string) {
+	t.Helper()
+	tmp, err := os.CreateTemp(t.TempDir(), "book")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tmp.Close()
+	if _, err = tmp.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Write(context.Background(), tmp.Name(), path); err != nil {
+		t.Fatal(err)
+	}
 }
 
-func (r *fakeBookRepo) Update(_ context.Context, book entity.Book) error {
-	r.updated = book
-	return nil
+func TestSetReadingStatusKeepsFinishDate(t *testing.T) {
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id"}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	ctx := context.Background()
+
+	first, err := shelf.SetReadingStatus(ctx, "reader", "book-id", entity.StatusFinished)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if first.FinishedAt == nil {
+		t.Fatal("expected finish date to be set")
+	}
+	again, err := shelf.SetReadingStatus(ctx, "reader", "book-id", entity.StatusFinished)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !again.FinishedAt.Equal(*first.FinishedAt) {
+		t.Errorf("expected finish date %v to be kept, got %v", first.FinishedAt, again.FinishedAt)
+	}
+
+	reading, err := shelf.SetReadingStatus(ctx, "reader", "book-id", entity.StatusReading)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if reading.FinishedAt != nil || repo.status.Status != entity.StatusReading {
+		t.Errorf("unexpected status %+v", repo.status)
+	}
+
+	if _, err = shelf.SetReadingStatus(ctx, "reader", "book-id", "abandoned"); !errors.Is(err, entity.ErrInvalidReadingStatus) {
+		t.Errorf("expected invalid status error, got %v", err)
+	}
+	if _, err = shelf.SetReadingStatus(ctx, "reader", "book-id", ""); err != nil || repo.status.Status != "" {
+		t.Errorf("expected status to be cleared, got %+v, %v", repo.status, err)
+	}
 }
 
-func (r *fakeBookRepo) Delete(context.Context, string) error {
-	return nil
+func TestSaveReview(t *testing.T) {
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id"}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	ctx := context.Background()
+
+	review, err := shelf.SaveReview(ctx, "reader", "book-id", 4, "  Loved the ending.  ")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if review.Rating != 4 || review.Text != "Loved the ending." || review.CreatedAt.IsZero() {
+		t.Errorf("unexpected review %+v", review)
+	}
+
+	if _, err = shelf.SaveReview(ctx, "reader", "book-id", 6, ""); !errors.Is(err, entity.ErrInvalidRating) {
+		t.Errorf("expected invalid rating error, got %v", err)
+	}
+	if _, err = shelf.SaveReview(ctx, "reader", "book-id", 0, " "); err != nil || repo.review.Rating != 0 {
+		t.Errorf("expected review to be deleted, got %+v, %v", repo.review, err)
+	}
 }
 
-type fakeMetadataProvider struct {
-	result bookmeta.LookupResult
-	err    error
+func TestRecordDownload(t *testing.T) {
+	repo := &fakeBookRepo{}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+
+	err := shelf.RecordDownload(context.Background(), entity.Download{BookID: "book-id", DeviceName: "kindle", Client: entity.ClientOPDS})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(repo.downloads) != 1 || repo.downloads[0].DownloadedAt.IsZero() || repo.downloads[0].DeviceName != "kindle" {
+		t.Errorf("unexpected downloads %+v", repo.downloads)
+	}
 }
 
-func (p fakeMetadataProvider) LookupByISBN(context.Context, string) (bookmeta.LookupResult, error) {
-	return p.result, p.err
+func TestLinkEdition(t *testing.T) {
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id"}, coverBooks: []entity.Book{{ID: "en", CoverPath: "covers/en.jpg"}}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	ctx := context.Background()
+
+	if err := shelf.LinkEdition(ctx, "en", "en", ""); !errors.Is(err, entity.ErrSameEdition) {
+		t.Errorf("expected same edition error, got %v", err)
+	}
+	if err := shelf.LinkEdition(ctx, "de", "en", "sequel"); !errors.Is(err, entity.ErrInvalidEditionRelation) {
+		t.Errorf("expected invalid relation error, got %v", err)
+	}
+	if err := shelf.LinkEdition(ctx, "de", "en", "translation"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	// fr starts a work of its own, linking it merges both works
+	if err := shelf.LinkEdition(ctx, "fr-pdf", "fr", "format"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := shelf.LinkEdition(ctx, "fr", "de", "translation"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	editions, err := shelf.Editions(ctx, "en")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(editions) != 3 {
+		t.Errorf("expected 3 other editions, got %+v", editions)
+	}
+
+	list, err := shelf.ListBooks(ctx, library.BookFilter{CoverPath: "covers/en.jpg", GroupEditions: true}, "", "", 1, 25)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Editions["en"]) != 3 {
+		t.Errorf("expected grouped editions, got %+v", list.Editions)
+	}
+
+	if err = shelf.UnlinkEdition(ctx, "de"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if editions, _ = shelf.Editions(ctx, "de"); len(editions) != 0 {
+		t.Errorf("expected no editions after unlinking, got %+v", editions)
+	}
+}
+
+func TestListBooksFacets(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeBookRepo{
+		book:   entity.Book{ID: "book-id"},
+		facets: library.BookFacets{Formats: []library.FacetCount{{Value: "epub", Count: 1}}},
+	}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+
+	list, err := shelf.ListBooks(ctx, library.BookFilter{}, "", "", 1, 25)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if list.Facets != nil {
+		t.Errorf("expected no facets unless asked for, got %+v", list.Facets)
+	}
+	list, err = shelf.SearchBooks(ctx, "tolkien", library.BookFilter{Facets: true}, "", "", 1, 25)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if list.Facets == nil || len(list.Facets.Formats) != 1 || list.Facets.Formats[0].Value != "epub" {
+		t.Errorf("expected the format counts, got %+v", list.Facets)
+	}
+}
+
+func TestListBooksApproximateCount(t *testing.T) {
+	ctx := context.Background()
+	approximate := library.WithApproximateCount(ctx)
+	repo := &fakeBookRepo{
+		book:       entity.Book{ID: "book-id"},
+		coverBooks: []entity.Book{{ID: "book-id", CoverPath: "covers/a.jpg"}},
+		estimate:   250000,
+	}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+
+	list, err := shelf.ListBooks(approximate, library.BookFilter{}, "", "", 1, 25)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !list.ApproximateTotal || list.TotalPages() != 10000 {
+		t.Errorf("expected the estimate of a large library, got %d pages", list.TotalPages())
+	}
+	if list, _ = shelf.ListBooks(ctx, library.BookFilter{}, "", "", 1, 25); list.ApproximateTotal || list.TotalPages() != 0 {
+		t.Errorf("expected an exact count, got %d pages", list.TotalPages())
+	}
+
+	filter := library.BookFilter{CoverPath: "covers/a.jpg"}
+	if _, err = shelf.ListBooks(approximate, filter, "", "", 1, 1); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repo.coverBooks = append(repo.coverBooks, entity.Book{ID: "other-id", CoverPath: "covers/a.jpg"})
+	if list, _ = shelf.ListBooks(approximate, filter, "", "", 1, 1); !list.ApproximateTotal || list.TotalPages() != 1 {
+		t.Errorf("expected the cached count, got %d pages", list.TotalPages())
+	}
+	// changes to the library clear the cache
+	if _, err = shelf.SetReadingStatus(ctx, "reader", "book-id", entity.StatusReading); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if list, _ = shelf.ListBooks(approximate, filter, "", "", 1, 1); list.ApproximateTotal || list.TotalPages() != 2 {
+		t.Errorf("expected a fresh count, got %d pages", list.TotalPages())
+	}
+}
+
+func TestListBooksCached(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeBookRepo{
+		book:       entity.Book{ID: "book-id"},
+		coverBooks: []entity.Book{{ID: "book-id", Title: "Dune", CoverPath: "covers/a.jpg"}},
+	}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	shelf.SetCache(cache.NewMemory(1<<20), time.Minute)
+
+	filter := library.BookFilter{CoverPath: "covers/a.jpg"}
+	if _, err := shelf.ListBooks(ctx, filter, "", "", 1, 10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repo.coverBooks = append(repo.coverBooks, entity.Book{ID: "other-id", CoverPath: "covers/a.jpg"})
+	list, err := shelf.ListBooks(ctx, filter, "", "", 1, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(list.Books) != 1 || list.Books[0].Title != "Dune" || list.TotalPages() != 1 || list.PerPage() != 10 {
+		t.Errorf("expected the cached listing, got %+v", list)
+	}
+	if list, _ = shelf.ListBooks(ctx, filter, "", "", 1, 20); len(list.Books) != 2 {
+		t.Errorf("expected another page size listed anew, got %d books", len(list.Books))
+	}
+
+	// changes to the library drop the cache
+	if _, err = shelf.SaveReview(ctx, "reader", "book-id", 4, ""); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if list, _ = shelf.ListBooks(ctx, filter, "", "", 1, 10); len(list.Books) != 2 {
+		t.Errorf("expected a fresh listing, got %d books", len(list.Books))
+	}
+}
+
+func TestBatchUpdateMetadata(t *testing.T) {
+	ctx := entity.WithRole(context.Background(), entity.RoleEditor)
+	repo := &fakeBookRepo{book: entity.Book{
+		Author:    "J.R.R. Tolkein & Christopher Tolkien",
+		Publisher: "Allen",
+		Genres:    []string{"fantasy", "unsorted"},
+	}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+
+	patch := library.MetadataPatch{Publisher: "Allen & Unwin", AddGenres: []string{"Classics", "Fantasy"}, RemoveGenres: []string{"Unsorted"}}
+	patch.RenameAuthor.From, patch.RenameAuthor.To = "j.r.r. tolkein", "J.R.R. Tolkien"
+	books, err := shelf.BatchUpdateMetadata(ctx, []string{"a", "b", "a"}, patch)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(books) != 2 {
+		t.Fatalf("expected each book once, got %d", len(books))
+	}
+	book := books[1]
+	if book.ID != "b" || book.Publisher != "Allen & Unwin" || book.Author != "J.R.R. Tolkien & Christopher Tolkien" {
+		t.Errorf("unexpected book %+v", book)
+	}
+	if strings.Join(book.Genres, ",") != "fantasy,Classics" {
+		t.Errorf("unexpected genres %v", book.Genres)
+	}
+	if strings.Join(repo.book.Genres, ",") != "fantasy,unsorted" {
+		t.Errorf("expected the stored genres untouched, got %v", repo.book.Genres)
+	}
+
+	if _, err = shelf.BatchUpdateMetadata(ctx, []string{"a"}, library.MetadataPatch{}); !errors.Is(err, library.ErrInvalidPatch) {
+		t.Errorf("expected ErrInvalidPatch for an empty patch, got %v", err)
+	}
+	if _, err = shelf.BatchUpdateMetadata(entity.WithRole(context.Background(), entity.RoleReader), []string{"a"}, patch); !errors.Is(err, entity.ErrForbidden) {
+		t.Errorf("expected ErrForbidden for readers, got %v", err)
+	}
+	repo.book.ArchivedAt = time.Now()
+	if _, err = shelf.BatchUpdateMetadata(ctx, []string{"a"}, patch); !errors.Is(err, entity.ErrBookArchived) {
+		t.Errorf("expected ErrBookArchived, got %v", err)
+	}
+}
+
+func TestExportImportMetadata(t *testing.T) {
+	ctx := entity.WithRole(context.Background(), entity.RoleEditor)
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", Title: "Dune", Author: "Frank Herbert", ISBN: "978-0-441-17271-9", Genres: []string{"sf"}}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+
+	var out bytes.Buffer
+	if err := shelf.ExportMetadata(ctx, &out, library.MetadataCSV); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	want := "id,title,author,publisher,year,isbn,doi,series,series_index,language,genres,description\n" +
+		"book-id,Dune,Frank Herbert,,0,978-0-441-17271-9,,,,,sf,\n"
+	if out.String() != want {
+		t.Errorf("unexpected export\n%s", out.String())
+	}
+
+	report, err := shelf.ImportMetadata(ctx, strings.NewReader(
+		"ID,isbn,publisher,year,genres\n"+
+			"book-id,,Ace,1965,sf; classics\n"+
+			",9780441172719,Chilton,,\n"+
+			",,,,\n"+
+			"book-id,,Ace,x,\n"), library.MetadataCSV)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Books != 4 || report.Changed != 1 || len(report.Problems) != 3 {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if repo.updated.Publisher != "Ace" || repo.updated.Year != 1965 || repo.updated.Title != "Dune" || strings.Join(repo.updated.Genres, ",") != "sf,classics" {
+		t.Errorf("unexpected update %+v", repo.updated)
+	}
+	if !strings.Contains(report.Problems[0].Problem, "row 4: year") || !strings.Contains(report.Problems[1].Problem, "row 2: the book is edited by an earlier row") {
+		t.Errorf("unexpected problems %+v", report.Problems)
+	}
+
+	// an unchanged book is not written
+	repo.updated = entity.Book{}
+	report, err = shelf.ImportMetadata(ctx, strings.NewReader(`{"books": [{"id": "book-id", "title": "Dune"}]}`), library.MetadataJSON)
+	if err != nil || report.Changed != 0 || repo.updated.ID != "" {
+		t.Errorf("expected nothing changed, got %+v %v", report, err)
+	}
+
+	if _, err = shelf.ImportMetadata(ctx, strings.NewReader("title\nDune\n"), library.MetadataCSV); !errors.Is(err, library.ErrInvalidMetadataImport) {
+		t.Errorf("expected ErrInvalidMetadataImport without id and isbn, got %v", err)
+	}
+	if _, err = shelf.ImportMetadata(entity.WithRole(context.Background(), entity.RoleReader), strings.NewReader(""), library.MetadataCSV); !errors.Is(err, entity.ErrForbidden) {
+		t.Errorf("expected ErrForbidden for readers, got %v", err)
+	}
+}
+
+func TestImportReadingHistory(t *testing.T) {
+	ctx := context.Background()
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", Title: "Dune", Author: "Frank Herbert", ISBN: "978-0-441-17271-9"}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+
+	goodreads := "Book Id,Title,Author,ISBN,ISBN13,My Rating,Exclusive Shelf,Date Read,My Review\n" +
+		`1,"Dune (Dune, #1)",Frank Herbert,="0441172717",="9780441172719",4,read,2019/08/14,Spice` + "\n" +
+		`2,Emma,Jane Austen,="",="",0,to-read,,` + "\n"
+	report, err := shelf.ImportReadingHistory(ctx, "reader", strings.NewReader(goodreads))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if report.Books != 2 || report.Changed != 1 || len(report.Problems) != 1 || report.Problems[0].Title != "Emma" {
+		t.Fatalf("unexpected report %+v", report)
+	}
+	if repo.status.Status != entity.StatusFinished || repo.status.FinishedAt == nil || repo.status.FinishedAt.Format("2006-01-02") != "2019-08-14" {
+		t.Errorf("unexpected status %+v", repo.status)
+	}
+	if repo.review.Rating != 4 || repo.review.Text != "Spice" || repo.review.Username != "reader" {
+		t.Errorf("unexpected review %+v", repo.review)
+	}
+
+	// StoryGraph rates in quarter stars, a known history is not written again
+	storygraph := "Title,Authors,ISBN/UID,Read Status,Star Rating,Last Date Read\n" +
+		"Dune,Frank Herbert,9780441172719,read,4.25,2019/08/14\n"
+	report, err = shelf.ImportReadingHistory(ctx, "reader", strings.NewReader(storygraph))
+	if err != nil || report.Changed != 0 || len(report.Problems) != 0 {
+		t.Errorf("expected nothing changed, got %+v %v", report, err)
+	}
+
+	if _, err = shelf.ImportReadingHistory(ctx, "reader", strings.NewReader("id,title\n")); !errors.Is(err, library.ErrInvalidHistoryImport) {
+		t.Errorf("expected ErrInvalidHistoryImport, got %v", err)
+	}
+}
+
+func TestListBooksPageLimits(t *testing.T) {
+	reader := entity.WithRole(context.Background(), entity.RoleReader)
+	admin := entity.WithRole(context.Background(), entity.RoleAdmin)
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), &fakeBookRepo{}, logger.New("error"))
+	shelf.SetPageLimits(library.PageLimits{Max: 50, AdminMax: 500})
+
+	for _, tc := range []struct {
+		ctx     context.Context
+		perPage int
+		want    int
+	}{
+		{reader, 0, 25},
+		{reader, 40, 40},
+		{reader, 5000, 50},
+		{admin, 5000, 500},
+		{context.Background(), 5000, 50},
+	} {
+		list, err := shelf.ListBooks(tc.ctx, library.BookFilter{}, "", "", 1, tc.perPage)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if list.PerPage() != tc.want {
+			t.Errorf("perPage %d: expected pages of %d, got %d", tc.perPage, tc.want, list.PerPage())
+		}
+
+		list, err = shelf.ListBooksByCursor(tc.ctx, library.BookFilter{}, "", "", "", tc.perPage)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if list.PerPage() != tc.want {
+			t.Errorf("cursor perPage %d: expected pages of %d, got %d", tc.perPage, tc.want, list.PerPage())
+		}
+	}
+}
+
+func TestPrivateBooks(t *testing.T) {
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", UploadedBy: "owner", Private: true}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	owner := library.WithReader(context.Background(), "owner")
+	other := entity.WithRole(library.WithReader(context.Background(), "other"), entity.RoleEditor)
+	device := library.WithReader(context.Background(), "")
+
+	if _, err := shelf.ViewBook(owner, "book-id"); err != nil {
+		t.Errorf("expected the uploader to see the book, got %v", err)
+	}
+	for _, ctx := range []context.Context{other, device} {
+		if _, err := shelf.ViewBook(ctx, "book-id"); !errors.Is(err, entity.ErrBookNotFound) {
+			t.Errorf("expected the book to be hidden, got %v", err)
+		}
+	}
+	if _, err := shelf.ViewBook(context.Background(), "book-id"); err != nil {
+		t.Errorf("expected calls without a reader to see the book, got %v", err)
+	}
+
+	if _, err := shelf.ListBooksByCursor(other, library.BookFilter{}, "created_at", "desc", "", 10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !repo.filter.Restricted || repo.filter.Reader != "other" {
+		t.Errorf("expected listings to be limited to the reader, got %+v", repo.filter)
+	}
+
+	repo.book.Private = false
+	if _, err := shelf.SetBookPrivate(other, "book-id", true); !errors.Is(err, entity.ErrForbidden) {
+		t.Errorf("expected other accounts to be refused, got %v", err)
+	}
+	book, err := shelf.SetBookPrivate(owner, "book-id", true)
+	if err != nil || !book.Private || !repo.book.Private {
+		t.Errorf("expected the uploader to hide the book, got %+v, %v", book, err)
+	}
+
+	repo.book = entity.Book{ID: "book-id"}
+	if _, err = shelf.SetBookPrivate(context.Background(), "book-id", true); !errors.Is(err, library.ErrNoUploader) {
+		t.Errorf("expected books without uploader to stay shared, got %v", err)
+	}
+}
+
+func TestScheduledBooks(t *testing.T) {
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id"}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	admin := entity.WithRole(library.WithReader(context.Background(), "admin"), entity.RoleAdmin)
+	editor := entity.WithRole(library.WithReader(context.Background(), "editor"), entity.RoleEditor)
+	device := library.WithReader(context.Background(), "")
+
+	if _, err := shelf.SetBookVisibleFrom(editor, "book-id", time.Now().Add(time.Hour)); !errors.Is(err, entity.ErrForbidden) {
+		t.Errorf("expected only admins to schedule books, got %v", err)
+	}
+	book, err := shelf.SetBookVisibleFrom(admin, "book-id", time.Now().Add(time.Hour))
+	if err != nil || !book.Scheduled(time.Now()) {
+		t.Fatalf("expected the book to be scheduled, got %+v, %v", book, err)
+	}
+	if _, err = shelf.ViewBook(admin, "book-id"); err != nil {
+		t.Errorf("expected admins to see the book early, got %v", err)
+	}
+	for _, ctx := range []context.Context{editor, device} {
+		if _, err = shelf.ViewBook(ctx, "book-id"); !errors.Is(err, entity.ErrBookNotFound) {
+			t.Errorf("expected the book to be hidden, got %v", err)
+		}
+	}
+	if _, err = shelf.ListBooksByCursor(editor, library.BookFilter{}, "created_at", "desc", "", 10); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !repo.filter.HideScheduled {
+		t.Errorf("expected listings to hide scheduled books, got %+v", repo.filter)
+	}
+	if _, err = shelf.ListBooksByCursor(admin, library.BookFilter{}, "created_at", "desc", "", 10); err != nil || repo.filter.HideScheduled {
+		t.Errorf("expected admins to list scheduled books, got %+v, %v", repo.filter, err)
+	}
+
+	repo.book.VisibleFrom = time.Now().Add(-time.Minute)
+	if _, err = shelf.ViewBook(device, "book-id"); err != nil {
+		t.Errorf("expected the book to show once its date passed, got %v", err)
+	}
+}
+
+type loanHook struct {
+	lent, ended []entity.Loan
+}
+
+func (h *loanHook) BookLent(_ context.Context, loan entity.Loan, _ entity.Book) error {
+	h.lent = append(h.lent, loan)
+	return nil
+}
+
+func (h *loanHook) LoanEnded(_ context.Context, loan entity.Loan, _ entity.Book) error {
+	h.ended = append(h.ended, loan)
+	return nil
+}
+
+func TestLendBook(t *testing.T) {
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", UploadedBy: "owner", Private: true}}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	hook := &loanHook{}
+	shelf.AddLoanHook(hook)
+	owner := library.WithReader(context.Background(), "owner")
+	friend := library.WithReader(context.Background(), "friend")
+
+	if _, err := shelf.LendBook(owner, "owner", "book-id", "friend", library.MaxLoanDays+1); !errors.Is(err, entity.ErrInvalidLoan) {
+		t.Errorf("expected ErrInvalidLoan, got %v", err)
+	}
+	if _, err := shelf.LendBook(friend, "friend", "book-id", "other", 7); !errors.Is(err, entity.ErrBookNotFound) {
+		t.Errorf("expected only the uploader to lend, got %v", err)
+	}
+	loan, err := shelf.LendBook(owner, "owner", "book-id", "friend", 7)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if loan.Borrower != "friend" || time.Until(loan.ExpiresAt) < 6*24*time.Hour || len(hook.lent) != 1 {
+		t.Errorf("unexpected loan %+v", loan)
+	}
+	if _, err = shelf.ViewBook(friend, "book-id"); err != nil {
+		t.Errorf("expected the borrower to see the book, got %v", err)
+	}
+
+	// an expired loan hides the book again and is ended once
+	repo.loans[0].ExpiresAt = time.Now().Add(-time.Minute)
+	if _, err = shelf.ViewBook(friend, "book-id"); !errors.Is(err, entity.ErrBookNotFound) {
+		t.Errorf("expected the book to be hidden after the loan, got %v", err)
+	}
+	for range 2 {
+		if _, err = shelf.EndExpiredLoans(context.Background()); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if len(hook.ended) != 1 || hook.ended[0].ID != loan.ID {
+		t.Errorf("expected the loan to end once, got %+v", hook.ended)
+	}
+
+	loan, _ = shelf.LendBook(owner, "owner", "book-id", "friend", 7)
+	if err = shelf.EndLoan(context.Background(), "other", loan.ID); !errors.Is(err, entity.ErrLoanNotFound) {
+		t.Errorf("expected other accounts not to end the loan, got %v", err)
+	}
+	if err = shelf.EndLoan(context.Background(), "friend", loan.ID); err != nil || len(hook.ended) != 2 {
+		t.Errorf("expected the borrower to hand the book back, got %v", err)
+	}
+
+	repo.book.Private = false
+	if _, err = shelf.LendBook(owner, "owner", "book-id", "friend", 7); !errors.Is(err, entity.ErrLoanShared) {
+		t.Errorf("expected ErrLoanShared, got %v", err)
+	}
+}
+
+func TestVerifyLibrary(t *testing.T) {
+	ctx := context.Background()
+	st := storage.NewMemoryStorage()
+	content := strings.Repeat("book content ", 1000)
+	if err := st.Put(ctx, "2024/book-id.epub", strings.NewReader(content)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	documentID, err := utils.PartialMD5Reader(strings.NewReader(content))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	repo := &fakeBookRepo{book: entity.Book{ID: "book-id", FilePath: "2024/book-id.epub", DocumentID: documentID}}
+	shelf := library.NewBookShelf(st, repo, logger.New("error"))
+
+	check, issues, err := shelf.VerifyLibrary(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if check.Books != 1 || check.Issues != 0 || len(issues) != 0 {
+		t.Errorf("expected a healthy book, got %+v %+v", check, issues)
+	}
+	if repo.resolved != check.StartedAt || repo.check != check {
+		t.Errorf("expected the check to be stored and old issues resolved, got %v %+v", repo.resolved, repo.check)
+	}
+
+	repo.book.CoverPath = "covers/book-id.jpg"
+	_ = st.Put(ctx, "2024/book-id.epub", strings.NewReader("other content"))
+	check, issues, err = shelf.VerifyLibrary(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if check.Issues != 2 || issues[0].Kind != entity.IssueHashMismatch || issues[1].Kind != entity.IssueMissingCover {
+		t.Errorf("expected changed file and missing cover, got %+v", issues)
+	}
+
+	_ = st.Delete(ctx, "2024/book-id.epub")
+	_, issues, _ = shelf.VerifyLibrary(ctx)
+	if len(issues) == 0 || issues[0].Kind != entity.IssueMissingFile {
+		t.Errorf("expected missing file, got %+v", issues)
+	}
+}
+
+func TestVerifyLibraryStartedByAccount(t *testing.T) {
+	repo := library.NewMemoryBookRepo()
+	now := time.Now()
+	for _, book := range []entity.Book{
+		{ID: "shared", FilePath: "shared.epub", CreatedAt: now},
+		{ID: "private", FilePath: "private.epub", UploadedBy: "other", Private: true, CreatedAt: now},
+		{ID: "scheduled", FilePath: "scheduled.epub", VisibleFrom: now.Add(time.Hour), CreatedAt: now},
+	} {
+		if err := repo.Store(context.Background(), book); err != nil {
+			t.Fatal(err)
+		}
+	}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	admin := entity.WithRole(library.WithReader(context.Background(), "admin"), entity.RoleAdmin)
+	editor := entity.WithRole(library.WithReader(context.Background(), "editor"), entity.RoleEditor)
+
+	for _, ctx := range []context.Context{admin, editor} {
+		last, _ := repo.LastLibraryCheck(ctx)
+		if err := shelf.StartVerifyLibrary(ctx); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		waitFor(t, func() bool {
+			check, _ := repo.LastLibraryCheck(ctx)
+			return check.StartedAt.After(last.StartedAt)
+		})
+		check, _ := repo.LastLibraryCheck(ctx)
+		issues, _ := repo.ListIssues(ctx)
+		if check.Books != 3 || len(issues) != 3 {
+			t.Errorf("expected every book checked and its missing file kept, got %+v %+v", check, issues)
+		}
+	}
+}
+
+type fakeMailer struct {
+	sent     mailer.Message
+	filename string
+	content  string
+}
+
+func (m *fakeMailer) Send(_ context.Context, msg mailer.Message) error {
+	m.sent = msg
+	for _, a := range msg.Attachments {
+		content, err := io.ReadAll(a.Content)
+		if err != nil {
+			return err
+		}
+		m.filename = a.Filename
+		m.content = string(content)
+	}
+	return nil
+}
+
+type fakeConverter struct {
+	content string
+}
+
+func (c fakeConverter) Convert(_ context.Context, _, format string) (string, error) {
+	tmp, err := os.CreateTemp("", "converted-*."+format)
+	if err != nil {
+		return "", err
+	}
+	defer tmp.Close()
+	_, err = tmp.WriteString(c.content)
+	return tmp.Name(), err
+}
+
+type fakeBookRepo struct {
+	book      entity.Book
+	updated   entity.Book
+	status    entity.BookStatus
+	review    entity.Review
+	downloads []entity.Download
+	issues    []entity.BookIssue
+	resolved  time.Time
+	check     entity.LibraryCheck
+	chapters  []entity.Chapter
+	files     []entity.BookFile
+	// coverBooks answers listing and counting by cover path
+	coverBooks []entity.Book
+	// works maps linked book ids to their work
+	works map[string]string
+	// shares maps token hashes to share links
+	shares map[string]*entity.ShareLink
+	// facets answers Facets
+	facets library.BookFacets
+	// estimate answers EstimateCount
+	estimate int
+	// filter is the last filter ListByCursor was asked for
+	filter library.BookFilter
+	loans  []entity.Loan
+}
+
+func (r *fakeBookRepo) WithTx(ctx context.Context, fn func(context.Context) error) error {
+	return fn(ctx)
+}
+
+func (r *fakeBookRepo) Store(context.Context, entity.Book) error {
+	return nil
+}
+
+func (r *fakeBookRepo) List(_ context.Context, filter library.BookFilter, _, _ string, _, _ int) ([]entity.Book, error) {
+	return r.withCover(filter.CoverPath), nil
+}
+
+func (r *fakeBookRepo) withCover(coverPath string) []entity.Book {
+	var books []entity.Book
+	for _, book := range r.coverBooks {
+		if coverPath != "" && book.CoverPath == coverPath {
+			books = append(books, book)
+		}
+	}
+	return books
+}
+
+func (r *fakeBookRepo) Search(context.Context, string, library.BookFilter, string, string, int, int) ([]entity.Book, error) {
+	return nil, nil
+}
+
+func (r *fakeBookRepo) ListByCursor(_ context.Context, filter library.BookFilter, _, _ string, _ library.Cursor, _ int) ([]entity.Book, error) {
+	r.filter = filter
+	if r.book.ID == "" {
+		return nil, nil
+	}
+	return []entity.Book{r.book}, nil
+}
+
+func (r *fakeBookRepo) SearchByCursor(context.Context, string, library.BookFilter, string, string, library.Cursor, int) ([]entity.Book, error) {
+	return nil, nil
+}
+
+func (r *fakeBookRepo) Count(_ context.Context, filter library.BookFilter) (int, error) {
+	return len(r.withCover(filter.CoverPath)), nil
+}
+
+func (r *fakeBookRepo) CountUploadedBy(context.Context, string) (int, error) {
+	return 0, nil
+}
+
+func (r *fakeBookRepo) StorageUsage(context.Context, string) (entity.StorageUsage, error) {
+	return entity.StorageUsage{}, nil
+}
+
+func (r *fakeBookRepo) LibraryUsage(context.Context) (entity.StorageUsage, error) {
+	return entity.StorageUsage{}, nil
+}
+
+func (r *fakeBookRepo) CountSearch(context.Context, string, library.BookFilter) (int, error) {
+	return 0, nil
+}
+
+func (r *fakeBookRepo) Facets(context.Context, string, library.BookFilter) (library.BookFacets, error) {
+	return r.facets, nil
+}
+
+func (r *fakeBookRepo) Analytics(context.Context, library.BookFilter, int) (library.LibraryStats, error) {
+	return library.LibraryStats{}, nil
+}
+
+func (r *fakeBookRepo) EstimateCount(context.Context) (int, error) {
+	return r.estimate, nil
+}
+
+func (r *fakeBookRepo) Random(context.Context, library.BookFilter, int) ([]entity.Book, error) {
+	if r.book.ID == "" {
+		return nil, nil
+	}
+	return []entity.Book{r.book}, nil
+}
+
+func (r *fakeBookRepo) Similar(context.Context, entity.Book, library.BookFilter, int) ([]entity.Book, error) {
+	return nil, nil
+}
+
+func (r *fakeBookRepo) Languages(context.Context) ([]string, error) {
+	return nil, nil
+}
+
+func (r *fakeBookRepo) GetById(context.Context, string) (entity.Book, error) {
+	return r.book, nil
+}
+
+func (r *fakeBookRepo) ListByISBN(_ context.Context, value string) ([]entity.Book, error) {
+	if !slices.Contains(isbn.Forms(value), isbn.Clean(r.book.ISBN)) {
+		return nil, nil
+	}
+	return []entity.Book{r.book}, nil
+}
+
+func (r *fakeBookRepo) GetByFileHash(context.Context, string) (entity.Book, error) {
+	return entity.Book{}, nil
+}
+
+func (r *fakeBookRepo) Update(_ context.Context, book entity.Book) error {
+	r.updated = book
+	return nil
+}
+
+func (r *fakeBookRepo) UpdateMany(_ context.Context, ids []string, change func(entity.Book) (entity.Book, error)) ([]entity.Book, error) {
+	var books []entity.Book
+	for _, id := range ids {
+		book := r.book
+		book.ID = id
+		book, err := change(book)
+		if err != nil {
+			return nil, err
+		}
+		r.updated = book
+		books = append(books, book)
+	}
+	return books, nil
+}
+
+func (r *fakeBookRepo) SetArchived(_ context.Context, _ string, archivedAt time.Time) error {
+	r.book.ArchivedAt = archivedAt
+	return nil
+}
+
+func (r *fakeBookRepo) SetPrivate(_ context.Context, _ string, private bool) error {
+	r.book.Private = private
+	return nil
+}
+
+func (r *fakeBookRepo) SetVisibleFrom(_ context.Context, _ string, from time.Time) error {
+	r.book.VisibleFrom = from
+	return nil
+}
+
+func (r *fakeBookRepo) StoreChapters(_ context.Context, _ string, chapters []entity.Chapter) error {
+	r.chapters = chapters
+	return nil
+}
+
+func (r *fakeBookRepo) ListChapters(context.Context, string) ([]entity.Chapter, error) {
+	return r.chapters, nil
+}
+
+func (r *fakeBookRepo) StoreFile(_ context.Context, file entity.BookFile) error {
+	r.files = append(r.files, file)
+	return nil
+}
+
+func (r *fakeBookRepo) ListFiles(context.Context, string) ([]entity.BookFile, error) {
+	return r.files, nil
+}
+
+func (r *fakeBookRepo) DeleteFile(_ context.Context, _, format string) error {
+	for i, file := range r.files {
+		if file.Format == format {
+			r.files = append(r.files[:i], r.files[i+1:]...)
+			return nil
+		}
+	}
+	return entity.ErrBookNotFound
+}
+
+func (r *fakeBookRepo) LinkEdition(_ context.Context, bookID, otherID string, _ entity.EditionRelation) error {
+	if r.works == nil {
+		r.works = make(map[string]string)
+	}
+	work, ok := r.works[otherID]
+	if !ok {
+		work = otherID
+	}
+	if old, ok := r.works[bookID]; ok {
+		for id, w := range r.works {
+			if w == old {
+				r.works[id] = work
+			}
+		}
+	}
+	r.works[bookID], r.works[otherID] = work, work
+	return nil
+}
+
+func (r *fakeBookRepo) UnlinkEdition(_ context.Context, bookID string) error {
+	delete(r.works, bookID)
+	return nil
+}
+
+func (r *fakeBookRepo) ListEditions(_ context.Context, bookIDs []string) (map[string][]entity.Edition, error) {
+	editions := make(map[string][]entity.Edition)
+	for _, bookID := range bookIDs {
+		work, ok := r.works[bookID]
+		if !ok {
+			continue
+		}
+		for id, w := range r.works {
+			if w == work && id != bookID {
+				editions[bookID] = append(editions[bookID], entity.Edition{Book: entity.Book{ID: id}, WorkID: w, Relation: entity.RelationEdition})
+			}
+		}
+	}
+	return editions, nil
+}
+
+func (r *fakeBookRepo) Delete(context.Context, string) error {
+	return nil
+}
+
+func (r *fakeBookRepo) GetReadingStatus(context.Context, string, string) (entity.BookStatus, error) {
+	return r.status, nil
+}
+
+func (r *fakeBookRepo) SetReadingStatus(_ context.Context, status entity.BookStatus) error {
+	r.status = status
+	return nil
+}
+
+func (r *fakeBookRepo) ClearReadingStatus(context.Context, string, string) error {
+	r.status = entity.BookStatus{}
+	return nil
+}
+
+func (r *fakeBookRepo) ListReviews(context.Context, string) ([]entity.Review, error) {
+	return []entity.Review{r.review}, nil
+}
+
+func (r *fakeBookRepo) GetReview(context.Context, string, string) (entity.Review, error) {
+	return r.review, nil
+}
+
+func (r *fakeBookRepo) SaveReview(_ context.Context, review entity.Review) error {
+	r.review = review
+	return nil
+}
+
+func (r *fakeBookRepo) DeleteReview(context.Context, string, string) error {
+	r.review = entity.Review{}
+	return nil
+}
+
+func (r *fakeBookRepo) AddDownload(_ context.Context, download entity.Download) error {
+	r.downloads = append(r.downloads, download)
+	return nil
+}
+
+func (r *fakeBookRepo) ListDownloads(context.Context, string, bool, int) ([]entity.DownloadedBook, error) {
+	return nil, nil
+}
+
+func (r *fakeBookRepo) CreateShareLink(_ context.Context, link entity.ShareLink, hash string) (entity.ShareLink, error) {
+	if r.shares == nil {
+		r.shares = make(map[string]*entity.ShareLink)
+	}
+	link.ID = hash[:8]
+	link.CreatedAt = time.Now()
+	r.shares[hash] = &link
+	return link, nil
+}
+
+func (r *fakeBookRepo) GetShareLinkByHash(_ context.Context, hash string) (entity.ShareLink, error) {
+	if link, ok := r.shares[hash]; ok {
+		return *link, nil
+	}
+	return entity.ShareLink{}, entity.ErrShareLinkNotFound
+}
+
+func (r *fakeBookRepo) ListShareLinks(_ context.Context, username, bookID string) ([]entity.ShareLink, error) {
+	var links []entity.ShareLink
+	for _, link := range r.shares {
+		if link.Username == username && link.BookID == bookID && link.RevokedAt == nil {
+			links = append(links, *link)
+		}
+	}
+	return links, nil
+}
+
+func (r *fakeBookRepo) RevokeShareLink(_ context.Context, username, id string) error {
+	for _, link := range r.shares {
+		if link.ID == id && link.Username == username && link.RevokedAt == nil {
+			now := time.Now()
+			link.RevokedAt = &now
+			return nil
+		}
+	}
+	return entity.ErrShareLinkNotFound
+}
+
+func (r *fakeBookRepo) CountShareDownload(_ context.Context, id string) error {
+	for _, link := range r.shares {
+		if link.ID == id && link.Usable(time.Now()) == nil {
+			link.Downloads++
+			return nil
+		}
+	}
+	return entity.ErrShareLinkUsedUp
+}
+
+type fakeMetadataProvider struct {
+	result bookmeta.LookupResult
+	err    error
+}
+
+func (p fakeMetadataProvider) LookupByISBN(context.Context, string) (bookmeta.LookupResult, error) {
+	return p.result, p.err
+}
+
+func (p fakeMetadataProvider) LookupByDOI(context.Context, string) (bookmeta.LookupResult, error) {
+	return p.result, p.err
+}
+
+func (r *fakeBookRepo) CreateLoan(_ context.Context, loan entity.Loan) (entity.Loan, error) {
+	loan.ID = fmt.Sprintf("loan-%d", len(r.loans)+1)
+	r.loans = append(r.loans, loan)
+	return loan, nil
+}
+
+func (r *fakeBookRepo) GetLoan(_ context.Context, id string) (entity.Loan, error) {
+	for _, loan := range r.loans {
+		if loan.ID == id {
+			return loan, nil
+		}
+	}
+	return entity.Loan{}, entity.ErrLoanNotFound
+}
+
+func (r *fakeBookRepo) ListLoans(_ context.Context, username string) ([]entity.Loan, error) {
+	var loans []entity.Loan
+	for _, loan := range r.loans {
+		if loan.EndedAt == nil && (loan.Lender == username || loan.Borrower == username) {
+			loans = append(loans, loan)
+		}
+	}
+	return loans, nil
+}
+
+func (r *fakeBookRepo) EndLoan(_ context.Context, id string, endedAt time.Time) error {
+	for i := range r.loans {
+		if r.loans[i].ID == id && r.loans[i].EndedAt == nil {
+			r.loans[i].EndedAt = &endedAt
+			return nil
+		}
+	}
+	return entity.ErrLoanNotFound
+}
+
+func (r *fakeBookRepo) EndExpiredLoans(_ context.Context, now time.Time) ([]entity.Loan, error) {
+	var ended []entity.Loan
+	for i := range r.loans {
+		if r.loans[i].EndedAt == nil && !now.Before(r.loans[i].ExpiresAt) {
+			r.loans[i].EndedAt = &r.loans[i].ExpiresAt
+			ended = append(ended, r.loans[i])
+		}
+	}
+	return ended, nil
+}
+
+func (r *fakeBookRepo) CreateCollection(_ context.Context, collection entity.Collection) (entity.Collection, error) {
+	return collection, nil
+}
+
+func (r *fakeBookRepo) GetCollection(context.Context, string, string) (entity.Collection, error) {
+	return entity.Collection{}, entity.ErrCollectionNotFound
+}
+
+func (r *fakeBookRepo) ListCollections(context.Context) ([]entity.Collection, error) {
+	return nil, nil
+}
+
+func (r *fakeBookRepo) UpdateCollection(context.Context, entity.Collection) error {
+	return nil
+}
+
+func (r *fakeBookRepo) DeleteCollection(context.Context, string) error {
+	return nil
+}
+
+func (r *fakeBookRepo) SetCollectionFeed(context.Context, string, string, string) error {
+	return nil
+}
+
+func (r *fakeBookRepo) GetCollectionIDByFeedHash(context.Context, string) (string, error) {
+	return "", entity.ErrCollectionNotFound
+}
+
+func (r *fakeBookRepo) RecordIngest(context.Context, entity.IngestEntry) (string, error) {
+	return "", nil
+}
+
+func (r *fakeBookRepo) SetIngestState(context.Context, string, string, string) error {
+	return nil
+}
+
+func (r *fakeBookRepo) DeleteIngest(context.Context, string) error {
+	return nil
+}
+
+func (r *fakeBookRepo) ListIngests(context.Context) ([]entity.IngestEntry, error) {
+	return nil, nil
+}
+
+func (r *fakeBookRepo) Reindex(context.Context) error {
+	return nil
+}
+
+func (r *fakeBookRepo) SaveIssues(_ context.Context, _ string, issues []entity.BookIssue, _ time.Time) error {
+	r.issues = append(r.issues, issues...)
+	return nil
+}
+
+func (r *fakeBookRepo) ResolveIssues(_ context.Context, checkedBefore time.Time) error {
+	r.resolved = checkedBefore
+	return nil
+}
+
+func (r *fakeBookRepo) ListIssues(context.Context) ([]entity.BookIssue, error) {
+	return r.issues, nil
+}
+
+func (r *fakeBookRepo) StoreLibraryCheck(_ context.Context, check entity.LibraryCheck) error {
+	r.check = check
+	return nil
+}
+
+func (r *fakeBookRepo) LastLibraryCheck(context.Context) (entity.LibraryCheck, error) {
+	return r.check, nil
 }
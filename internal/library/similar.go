@@ -0,0 +1,41 @@
+package library
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/tracing"
+)
+
+// maxSimilarBooks caps one call of SimilarBooks.
+const maxSimilarBooks = 20
+
+// Points of a book for what it shares with the book similar books are
+// looked for, the repos order by their sum. Books share a title when its
+// trigram similarity is at least similarTitleMin.
+const (
+	similarSeries   = 4
+	similarAuthor   = 3
+	similarTitle    = 2 // times the trigram similarity of the titles
+	similarGenre    = 1 // for each shared genre
+	similarTitleMin = 0.3
+)
+
+// SimilarBooks suggests up to n books sharing the series, author, genres
+// or a similar title with a book, the most alike first. n is between 1 and
+// maxSimilarBooks.
+func (uc *BookShelf) SimilarBooks(ctx context.Context, bookID string, n int) ([]entity.Book, error) {
+	ctx, span := tracing.Start(ctx, "BookShelf.SimilarBooks")
+	defer span.End()
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - SimilarBooks - s.getBook: %w", err)
+	}
+	n = min(max(n, 1), maxSimilarBooks)
+	books, err := uc.repo.Similar(ctx, book, BookFilter{}.forReader(ctx), n)
+	if err != nil {
+		return nil, fmt.Errorf("BookShelf - SimilarBooks - s.repo.Similar: %w", err)
+	}
+	return books, nil
+}
@@ -0,0 +1,46 @@
+package library
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// Similar returns up to n other books matching filter that share the
+// series, the author by its folded spelling, a genre or a title of
+// pg_trgm similarity with book, ordered by the points of SimilarBooks.
+func (bdr *BookDatabaseRepo) Similar(ctx context.Context, book entity.Book, filter BookFilter, n int) ([]entity.Book, error) {
+	conditions, args := bookConditions(postgresDialect, "", filter)
+	i := len(args)
+	args = append(args, book.ID, book.Series, book.Author, book.Title, genres(book.Genres), similarTitleMin)
+	conditions = append(conditions, fmt.Sprintf(`id <> $%d`, i+1), fmt.Sprintf(`(
+			($%[1]d <> '' AND series = $%[1]d)
+			OR ($%[2]d <> '' AND library_fold(author) = library_fold($%[2]d))
+			OR similarity(title, $%[3]d) >= $%[5]d
+			OR genres && $%[4]d
+		)`, i+2, i+3, i+4, i+5, i+6))
+
+	query := fmt.Sprintf(`
+		SELECT `+bookColumns+`
+		FROM library_book
+		%s
+		ORDER BY
+			CASE WHEN $%[2]d <> '' AND series = $%[2]d THEN %[6]d ELSE 0 END
+			+ CASE WHEN $%[3]d <> '' AND library_fold(author) = library_fold($%[3]d) THEN %[7]d ELSE 0 END
+			+ %[8]d * similarity(title, $%[4]d)
+			+ %[9]d * cardinality(ARRAY(SELECT unnest(genres) INTERSECT SELECT unnest($%[5]d::text[]))) DESC,
+			created_at, id
+		LIMIT %[10]d
+	`, whereSQL(conditions), i+2, i+3, i+4, i+5, similarSeries, similarAuthor, similarTitle, similarGenre, n)
+
+	rows, err := bdr.Conn(ctx).Query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - Similar - r.Pool.Query: %w", err)
+	}
+	books, err := scanBooks(rows)
+	if err != nil {
+		return nil, fmt.Errorf("BookDatabaseRepo - Similar - scanBooks: %w", err)
+	}
+	return books, nil
+}
@@ -0,0 +1,43 @@
+package library
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// Similar finds books like BookDatabaseRepo.Similar, similarity() is the
+// trigram similarity of pkg/trigram the connection registers.
+func (bsr *BookSQLiteRepo) Similar(ctx context.Context, book entity.Book, filter BookFilter, n int) ([]entity.Book, error) {
+	conditions, args := bookConditions(sqliteDialect, "", filter)
+	i := len(args)
+	args = append(args, book.ID, book.Series, book.Author, book.Title, jsonList(book.Genres), similarTitleMin)
+	conditions = append(conditions, fmt.Sprintf(`id <> $%d`, i+1), fmt.Sprintf(`(
+			($%[1]d <> '' AND series = $%[1]d)
+			OR ($%[2]d <> '' AND lower(unaccent(author)) = lower(unaccent($%[2]d)))
+			OR similarity(title, $%[3]d) >= $%[5]d
+			OR EXISTS (SELECT 1 FROM json_each(genres) g WHERE g.value IN (SELECT value FROM json_each($%[4]d)))
+		)`, i+2, i+3, i+4, i+5, i+6))
+
+	rows, err := bsr.Conn(ctx).Query(ctx, fmt.Sprintf(`
+		SELECT `+sqliteBookColumns+`
+		FROM library_book
+		%s
+		ORDER BY
+			CASE WHEN $%[2]d <> '' AND series = $%[2]d THEN %[6]d ELSE 0 END
+			+ CASE WHEN $%[3]d <> '' AND lower(unaccent(author)) = lower(unaccent($%[3]d)) THEN %[7]d ELSE 0 END
+			+ %[8]d * similarity(title, $%[4]d)
+			+ %[9]d * (SELECT count(DISTINCT g.value) FROM json_each(genres) g WHERE g.value IN (SELECT value FROM json_each($%[5]d))) DESC,
+			created_at, id
+		LIMIT %[10]d
+	`, whereSQL(conditions), i+2, i+3, i+4, i+5, similarSeries, similarAuthor, similarTitle, similarGenre, n), args...)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - Similar - r.DB.Query: %w", err)
+	}
+	books, err := scanSQLiteBooks(rows)
+	if err != nil {
+		return nil, fmt.Errorf("BookSQLiteRepo - Similar - scanSQLiteBooks: %w", err)
+	}
+	return books, nil
+}
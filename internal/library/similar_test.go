@@ -0,0 +1,54 @@
+package library_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func TestSimilarBooks(t *testing.T) {
+	ctx := context.Background()
+	created := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	books := []entity.Book{
+		{ID: "fellowship", Title: "The Fellowship of the Ring", Author: "J.R.R. Tolkien", Series: "The Lord of the Rings", Genres: []string{"Fantasy"}},
+		{ID: "towers", Title: "The Two Towers", Author: "J.R.R. Tolkien", Series: "The Lord of the Rings", Genres: []string{"Fantasy"}},
+		{ID: "hobbit", Title: "The Hobbit", Author: "J.R.R. Tolkien", Genres: []string{"Fantasy"}},
+		{ID: "earthsea", Title: "A Wizard of Earthsea", Author: "Ursula K. Le Guin", Genres: []string{"Fantasy"}},
+		{ID: "fellowship-guide", Title: "Fellowship of the Ring, a guide", Author: "Someone"},
+		{ID: "private", Title: "The Return of the King", Author: "J.R.R. Tolkien", Series: "The Lord of the Rings", Private: true, UploadedBy: "owner"},
+		{ID: "cookbook", Title: "Soups", Author: "Cook", Genres: []string{"Cooking"}},
+	}
+	for name, repo := range map[string]library.BookRepo{"memory": library.NewMemoryBookRepo(), "sqlite": newSQLiteRepo(t)} {
+		t.Run(name, func(t *testing.T) {
+			for i, book := range books {
+				book.FilePath = fmt.Sprintf("books/%s.epub", book.ID)
+				book.DocumentID = "hash-" + book.ID
+				book.MediaType = entity.MediaTypeBook
+				book.CreatedAt, book.UpdatedAt = created.Add(time.Duration(i)*time.Hour), created
+				if err := repo.Store(ctx, book); err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+
+			shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+			reader := library.WithReader(ctx, "reader")
+			similar, err := shelf.SimilarBooks(reader, "fellowship", 10)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			var ids []string
+			for _, book := range similar {
+				ids = append(ids, book.ID)
+			}
+			if fmt.Sprint(ids) != "[towers hobbit fellowship-guide earthsea]" {
+				t.Errorf("expected the series, the author, the title and the genre in order, got %v", ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,243 @@
+package library
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/richtext"
+	"github.com/shopspring/decimal"
+)
+
+// UploadLimits keep shared instances from running out of storage, zero
+// values do not limit.
+type UploadLimits struct {
+	MaxSize int64 // bytes of one book file
+	// Formats allowed to be uploaded, file extensions like "epub"
+	Formats []string
+	// MaxBooks an account may upload, books from before the limit count
+	// only when they were uploaded by the account
+	MaxBooks int
+	// Quota is the bytes the books of an account may take, with their
+	// other formats and covers
+	Quota int64
+}
+
+type uploaderKey struct{}
+
+// WithUploader sets the account uploading books in this request, its
+// books are counted for UploadLimits.MaxBooks.
+func WithUploader(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, uploaderKey{}, username)
+}
+
+// UploaderFrom returns the uploading account, empty when unknown.
+func UploaderFrom(ctx context.Context) string {
+	username, _ := ctx.Value(uploaderKey{}).(string)
+	return username
+}
+
+type uploadMetadataKey struct{}
+
+// WithUploadMetadata sets metadata for the book uploaded in this request.
+// Its fields that are set win over the file, the metadata sources and the
+// metadata rules, so imports from curated sources need no second update.
+func WithUploadMetadata(ctx context.Context, metadata entity.Book) context.Context {
+	return context.WithValue(ctx, uploadMetadataKey{}, metadata)
+}
+
+// overrideUploadMetadata lays the metadata sent with the upload over book.
+func overrideUploadMetadata(ctx context.Context, book entity.Book) entity.Book {
+	metadata, ok := ctx.Value(uploadMetadataKey{}).(entity.Book)
+	if !ok {
+		return book
+	}
+	if metadata.Title != "" {
+		book.Title = metadata.Title
+	}
+	if metadata.Author != "" {
+		book.Author = metadata.Author
+	}
+	if metadata.Description != "" {
+		book.Description = richtext.Sanitize(metadata.Description)
+	}
+	if metadata.Publisher != "" {
+		book.Publisher = metadata.Publisher
+	}
+	if metadata.Year != 0 {
+		book.Year = metadata.Year
+	}
+	if metadata.ISBN != "" {
+		book.ISBN = metadata.ISBN
+	}
+	if metadata.DOI != "" {
+		book.DOI = normalizeDOI(metadata.DOI)
+	}
+	if metadata.Series != "" {
+		book.Series = metadata.Series
+	}
+	if metadata.SeriesIndex != nil {
+		book.SeriesIndex = metadata.SeriesIndex
+	}
+	if metadata.Language != "" {
+		book.Language = normalizeLanguage(metadata.Language)
+	}
+	if len(metadata.Genres) > 0 {
+		book.Genres = metadata.Genres
+	}
+	return book
+}
+
+// UploadConflict describes the book StoreBook found for an uploaded file,
+// which entity.ErrBookAlreadyExists refers to.
+type UploadConflict struct {
+	BookID     string
+	Title      string
+	Author     string
+	UploadedAt time.Time
+	UploadedBy string
+	// Fields sent with the upload, see WithUploadMetadata, that the stored
+	// book has otherwise, named like the upload form fields
+	Differences []string
+	// Hidden books are private to another account or not visible yet, the
+	// conflict tells nothing about them
+	Hidden bool
+}
+
+// MetadataDiffers reports whether the upload sent metadata the stored book
+// does not have.
+func (c UploadConflict) MetadataDiffers() bool {
+	return len(c.Differences) > 0
+}
+
+// UploadConflict compares an upload with the book StoreBook returned along
+// with entity.ErrBookAlreadyExists. The file is the same, so only metadata
+// sent with the upload can differ.
+func (uc *BookShelf) UploadConflict(ctx context.Context, existing entity.Book) UploadConflict {
+	if !uc.canSee(ctx)(existing) {
+		return UploadConflict{Hidden: true}
+	}
+	conflict := UploadConflict{
+		BookID:     existing.ID,
+		Title:      existing.Title,
+		Author:     existing.Author,
+		UploadedAt: existing.CreatedAt,
+		UploadedBy: existing.UploadedBy,
+	}
+	sent := overrideUploadMetadata(ctx, existing)
+	sent.ISBN = normalizeISBN(sent.ISBN)
+	for _, field := range []struct {
+		name    string
+		differs bool
+	}{
+		{"title", sent.Title != existing.Title},
+		{"author", sent.Author != existing.Author},
+		{"description", sent.Description != existing.Description},
+		{"publisher", sent.Publisher != existing.Publisher},
+		{"year", sent.Year != existing.Year},
+		{"isbn", sent.ISBN != normalizeISBN(existing.ISBN)},
+		{"doi", sent.DOI != existing.DOI},
+		{"series", sent.Series != existing.Series},
+		{"series_index", !sameSeriesIndex(sent.SeriesIndex, existing.SeriesIndex)},
+		{"language", sent.Language != existing.Language},
+		{"tags", strings.Join(sent.Genres, "\x00") != strings.Join(existing.Genres, "\x00")},
+	} {
+		if field.differs {
+			conflict.Differences = append(conflict.Differences, field.name)
+		}
+	}
+	return conflict
+}
+
+func sameSeriesIndex(a, b *decimal.NullDecimal) bool {
+	aValid, bValid := a != nil && a.Valid, b != nil && b.Valid
+	if !aValid || !bValid {
+		return aValid == bValid
+	}
+	return a.Decimal.Equal(b.Decimal)
+}
+
+// SetUploadLimits limits book uploads and added formats.
+func (uc *BookShelf) SetUploadLimits(limits UploadLimits) {
+	formats := make([]string, 0, len(limits.Formats))
+	for _, format := range limits.Formats {
+		if format = strings.ToLower(strings.TrimPrefix(strings.TrimSpace(format), ".")); format != "" {
+			formats = append(formats, format)
+		}
+	}
+	limits.Formats = formats
+	uc.limits = limits
+}
+
+// checkUploadSize rejects files over the size limit before anything is
+// read from them.
+func (uc *BookShelf) checkUploadSize(file *os.File) error {
+	if uc.limits.MaxSize <= 0 {
+		return nil
+	}
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("file.Stat: %w", err)
+	}
+	if info.Size() > uc.limits.MaxSize {
+		return fmt.Errorf("%w: %d MB at most", entity.ErrUploadTooLarge, uc.limits.MaxSize>>20)
+	}
+	return nil
+}
+
+func (uc *BookShelf) checkUploadFormat(format string) error {
+	if len(uc.limits.Formats) == 0 {
+		return nil
+	}
+	for _, allowed := range uc.limits.Formats {
+		if strings.EqualFold(allowed, format) {
+			return nil
+		}
+	}
+	return fmt.Errorf("%w: %s, allowed are %s", entity.ErrFormatNotAllowed, format, strings.Join(uc.limits.Formats, ", "))
+}
+
+func (uc *BookShelf) checkBookLimit(ctx context.Context) error {
+	username := UploaderFrom(ctx)
+	if uc.limits.MaxBooks <= 0 || username == "" {
+		return nil
+	}
+	count, err := uc.repo.CountUploadedBy(ctx, username)
+	if err != nil {
+		return fmt.Errorf("s.repo.CountUploadedBy: %w", err)
+	}
+	if count >= uc.limits.MaxBooks {
+		return fmt.Errorf("%w: %d books", entity.ErrBookLimitReached, uc.limits.MaxBooks)
+	}
+	return nil
+}
+
+// checkQuota rejects size more bytes for the books of username once they
+// would exceed the quota. Books without an uploader are not counted.
+func (uc *BookShelf) checkQuota(ctx context.Context, username string, size int64) error {
+	if uc.limits.Quota <= 0 || username == "" {
+		return nil
+	}
+	usage, err := uc.repo.StorageUsage(ctx, username)
+	if err != nil {
+		return fmt.Errorf("s.repo.StorageUsage: %w", err)
+	}
+	if usage.Bytes()+size > uc.limits.Quota {
+		return fmt.Errorf("%w: %d of %d MB used", entity.ErrQuotaExceeded, usage.Bytes()>>20, uc.limits.Quota>>20)
+	}
+	return nil
+}
+
+// StorageUsage reports the storage the books uploaded by username take and
+// the quota of the account.
+func (uc *BookShelf) StorageUsage(ctx context.Context, username string) (entity.StorageUsage, error) {
+	usage, err := uc.repo.StorageUsage(ctx, username)
+	if err != nil {
+		return entity.StorageUsage{}, fmt.Errorf("BookShelf - StorageUsage - s.repo.StorageUsage: %w", err)
+	}
+	usage.Quota = uc.limits.Quota
+	return usage, nil
+}
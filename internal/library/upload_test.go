@@ -0,0 +1,165 @@
+package library_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/library"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+func TestStoreBookUploadLimits(t *testing.T) {
+	repo := &uniqueBookRepo{books: make(map[string]entity.Book)}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	ctx := library.WithPrivateUpload(library.WithUploader(context.Background(), "reader"), true)
+
+	upload := func(body string) error {
+		file, err := os.CreateTemp(t.TempDir(), "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		file.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>` + body + `</book-title></title-info></description><body><p>` + body + `</p></body></FictionBook>`)
+		file.Seek(0, 0)
+		_, err = shelf.StoreBook(ctx, file, "book.fb2")
+		return err
+	}
+
+	shelf.SetUploadLimits(library.UploadLimits{MaxSize: 64})
+	if err := upload("too long for the limit"); !errors.Is(err, entity.ErrUploadTooLarge) {
+		t.Errorf("expected upload too large, got %v", err)
+	}
+
+	shelf.SetUploadLimits(library.UploadLimits{Formats: []string{".EPUB", "pdf"}})
+	if err := upload("fb2"); !errors.Is(err, entity.ErrFormatNotAllowed) {
+		t.Errorf("expected format not allowed, got %v", err)
+	}
+
+	shelf.SetUploadLimits(library.UploadLimits{Formats: []string{"fb2"}, MaxBooks: 1})
+	if err := upload("first"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := upload("second"); !errors.Is(err, entity.ErrBookLimitReached) {
+		t.Errorf("expected book limit reached, got %v", err)
+	}
+	for _, book := range repo.books {
+		if book.UploadedBy != "reader" || !book.Private {
+			t.Errorf("expected a private book of the uploader to be stored, got %q, %t", book.UploadedBy, book.Private)
+		}
+	}
+}
+
+func TestStoreBookStorageQuota(t *testing.T) {
+	repo := &uniqueBookRepo{books: make(map[string]entity.Book)}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	ctx := library.WithUploader(context.Background(), "reader")
+
+	upload := func(body string) error {
+		file, err := os.CreateTemp(t.TempDir(), "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		file.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>` + body + `</book-title></title-info></description><body><p>` + body + `</p></body></FictionBook>`)
+		file.Seek(0, 0)
+		_, err = shelf.StoreBook(ctx, file, "book.fb2")
+		return err
+	}
+
+	shelf.SetUploadLimits(library.UploadLimits{Quota: 300})
+	if err := upload("first"); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if err := upload("second"); !errors.Is(err, entity.ErrQuotaExceeded) {
+		t.Errorf("expected quota exceeded, got %v", err)
+	}
+	if usage, _ := shelf.StorageUsage(context.Background(), "other"); usage.Books != 0 {
+		t.Errorf("expected books of other accounts not to count, got %+v", usage)
+	}
+
+	usage, err := shelf.StorageUsage(context.Background(), "reader")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if usage.Books != 1 || usage.Bytes() == 0 || usage.Quota != 300 || usage.Left() != 300-usage.Bytes() {
+		t.Errorf("expected the usage of the first book, got %+v", usage)
+	}
+}
+
+func TestStoreBookUploadMetadataWins(t *testing.T) {
+	repo := &uniqueBookRepo{books: make(map[string]entity.Book)}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	shelf.SetMetadataRules(staticRules{{Field: "title", Pattern: "Curated", Replace: "Rewritten"}})
+	ctx := library.WithUploadMetadata(context.Background(), entity.Book{
+		Title:    "Curated Title",
+		Language: "DE",
+		Genres:   []string{"poetry"},
+	})
+
+	file, err := os.CreateTemp(t.TempDir(), "upload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	file.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>File Title</book-title><author><first-name>Anna</first-name><last-name>Achmatova</last-name></author></title-info></description><body><p>poems</p></body></FictionBook>`)
+	file.Seek(0, 0)
+
+	book, err := shelf.StoreBook(ctx, file, "book.fb2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if book.Title != "Curated Title" || book.Author == "" || book.Language != "de" || len(book.Genres) != 1 || book.Genres[0] != "poetry" {
+		t.Fatalf("unexpected metadata %+v", book)
+	}
+}
+
+func TestUploadConflict(t *testing.T) {
+	repo := &uniqueBookRepo{books: make(map[string]entity.Book)}
+	shelf := library.NewBookShelf(storage.NewMemoryStorage(), repo, logger.New("error"))
+	owner := library.WithUploader(context.Background(), "owner")
+
+	upload := func(ctx context.Context) (entity.Book, error) {
+		file, err := os.CreateTemp(t.TempDir(), "upload")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer file.Close()
+		file.WriteString(`<?xml version="1.0" encoding="utf-8"?>
+<FictionBook><description><title-info><book-title>Solaris</book-title></title-info></description><body><p>text</p></body></FictionBook>`)
+		file.Seek(0, 0)
+		return shelf.StoreBook(ctx, file, "book.fb2")
+	}
+	stored, err := upload(owner)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx := library.WithUploadMetadata(owner, entity.Book{Title: "Solaris", Author: "Stanisław Lem", Genres: []string{"sf"}})
+	existing, err := upload(ctx)
+	if !errors.Is(err, entity.ErrBookAlreadyExists) {
+		t.Fatalf("expected the book to exist, got %v", err)
+	}
+	conflict := shelf.UploadConflict(ctx, existing)
+	if conflict.BookID != stored.ID || conflict.Title != "Solaris" || conflict.UploadedBy != "owner" || !conflict.UploadedAt.Equal(stored.CreatedAt) {
+		t.Errorf("expected the stored book, got %+v", conflict)
+	}
+	if !conflict.MetadataDiffers() || strings.Join(conflict.Differences, ",") != "author,tags" {
+		t.Errorf("expected author and tags to differ, got %v", conflict.Differences)
+	}
+	if conflict = shelf.UploadConflict(owner, existing); conflict.MetadataDiffers() {
+		t.Errorf("expected no differences without metadata, got %v", conflict.Differences)
+	}
+
+	existing.Private = true
+	if conflict = shelf.UploadConflict(library.WithReader(ctx, "other"), existing); !conflict.Hidden || conflict.BookID != "" {
+		t.Errorf("expected the private book of another account hidden, got %+v", conflict)
+	}
+}
@@ -0,0 +1,158 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/storage"
+	"github.com/banjuer/kompanion/pkg/postgres"
+	"github.com/banjuer/kompanion/pkg/utils"
+)
+
+var ErrVerifyRunning = errors.New("library check is already running")
+
+// VerifyLibrary walks all books, reads every file from the storage and
+// compares its partial md5 with the recorded document id, so a file that
+// went missing or was silently corrupted shows up. Problems are recorded,
+// the ones that are gone are resolved once the whole library was checked.
+// It checks every book whoever started it.
+func (uc *BookShelf) VerifyLibrary(ctx context.Context) (entity.LibraryCheck, []entity.BookIssue, error) {
+	ctx = WithoutReader(ctx)
+	if !uc.verifying.CompareAndSwap(false, true) {
+		return entity.LibraryCheck{}, nil, ErrVerifyRunning
+	}
+	defer uc.verifying.Store(false)
+	if uc.jobs != nil {
+		release, err := uc.jobs.TryLock(ctx, "library-verify")
+		if errors.Is(err, postgres.ErrLocked) {
+			return entity.LibraryCheck{}, nil, ErrVerifyRunning
+		}
+		if err != nil {
+			return entity.LibraryCheck{}, nil, fmt.Errorf("BookShelf - VerifyLibrary - uc.jobs.TryLock: %w", err)
+		}
+		defer release()
+	}
+
+	// the database keeps microseconds, a later truncation would resolve
+	// the issues of this run
+	check := entity.LibraryCheck{StartedAt: time.Now().UTC().Truncate(time.Microsecond)}
+	issues := make([]entity.BookIssue, 0)
+	err := uc.forEachBook(ctx, func(book entity.Book) error {
+		check.Books++
+		found := uc.checkBook(ctx, book)
+		if err := uc.repo.SaveIssues(ctx, book.ID, found, check.StartedAt); err != nil {
+			return fmt.Errorf("s.repo.SaveIssues: %w", err)
+		}
+		issues = append(issues, found...)
+		return nil
+	})
+	if err != nil {
+		return check, issues, fmt.Errorf("BookShelf - VerifyLibrary - %w", err)
+	}
+
+	check.FinishedAt = time.Now().UTC()
+	check.Issues = len(issues)
+	// issues are resolved only along with the check that found them gone
+	err = uc.repo.WithTx(ctx, func(ctx context.Context) error {
+		if err := uc.repo.ResolveIssues(ctx, check.StartedAt); err != nil {
+			return fmt.Errorf("s.repo.ResolveIssues: %w", err)
+		}
+		if err := uc.repo.StoreLibraryCheck(ctx, check); err != nil {
+			return fmt.Errorf("s.repo.StoreLibraryCheck: %w", err)
+		}
+		return nil
+	})
+	if err != nil {
+		return check, issues, fmt.Errorf("BookShelf - VerifyLibrary - %w", err)
+	}
+	return check, issues, nil
+}
+
+// SetJobLock keeps servers sharing the database from checking the library
+// at the same time.
+func (uc *BookShelf) SetJobLock(jobs JobLock) {
+	uc.jobs = jobs
+}
+
+// StartVerifyLibrary runs VerifyLibrary in the background.
+func (uc *BookShelf) StartVerifyLibrary(ctx context.Context) error {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return err
+	}
+	if uc.verifying.Load() {
+		return ErrVerifyRunning
+	}
+	go func() {
+		_, _, err := uc.VerifyLibrary(context.WithoutCancel(ctx))
+		if err != nil {
+			uc.logger.Error("BookShelf - StartVerifyLibrary - uc.VerifyLibrary: %s", err)
+		}
+	}()
+	return nil
+}
+
+// ScheduleVerifyLibrary checks the library every interval until ctx is done.
+func (uc *BookShelf) ScheduleVerifyLibrary(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check, _, err := uc.VerifyLibrary(ctx)
+			if err != nil {
+				uc.logger.Error("BookShelf - ScheduleVerifyLibrary - uc.VerifyLibrary: %s", err)
+				continue
+			}
+			if check.Issues > 0 {
+				uc.logger.Warn("BookShelf - ScheduleVerifyLibrary - %d of %d books have issues", check.Issues, check.Books)
+			}
+		}
+	}
+}
+
+// LibraryIssues returns the last check with the problems still open.
+func (uc *BookShelf) LibraryIssues(ctx context.Context) (entity.LibraryCheck, []entity.BookIssue, error) {
+	check, err := uc.repo.LastLibraryCheck(ctx)
+	if err != nil {
+		return check, nil, fmt.Errorf("BookShelf - LibraryIssues - s.repo.LastLibraryCheck: %w", err)
+	}
+	issues, err := uc.repo.ListIssues(ctx)
+	if err != nil {
+		return check, nil, fmt.Errorf("BookShelf - LibraryIssues - s.repo.ListIssues: %w", err)
+	}
+	return check, issues, nil
+}
+
+func (uc *BookShelf) checkBook(ctx context.Context, book entity.Book) []entity.BookIssue {
+	var issues []entity.BookIssue
+	add := func(kind, format string, args ...interface{}) {
+		issues = append(issues, entity.BookIssue{BookID: book.ID, Title: book.Title, Kind: kind, Detail: fmt.Sprintf(format, args...)})
+	}
+
+	file, err := uc.storage.Open(ctx, book.FilePath)
+	switch {
+	case errors.Is(err, storage.ErrNotFound):
+		add(entity.IssueMissingFile, "%s", book.FilePath)
+	case err != nil:
+		add(entity.IssueUnreadableFile, "%s: %s", book.FilePath, err)
+	default:
+		documentID, err := utils.PartialMD5Reader(file)
+		file.Close()
+		if err != nil {
+			add(entity.IssueUnreadableFile, "%s: %s", book.FilePath, err)
+		} else if documentID != book.DocumentID {
+			add(entity.IssueHashMismatch, "%s has document id %s, expected %s", book.FilePath, documentID, book.DocumentID)
+		}
+	}
+
+	if book.CoverPath != "" && uc.bookNeedsCover(ctx, book) {
+		add(entity.IssueMissingCover, "%s", book.CoverPath)
+	}
+	return issues
+}
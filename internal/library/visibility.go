@@ -0,0 +1,171 @@
+package library
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// ErrNoUploader - books stored before uploaders were recorded belong to
+// no account and stay shared.
+var ErrNoUploader = errors.New("book has no uploader to keep it private")
+
+type readerKey struct{}
+
+// WithReader sets the account browsing the library in this request, books
+// other accounts keep private are hidden from it. Devices and visitors of
+// public pages pass an empty name and see only shared books. Calls without
+// a reader, from the command line and background jobs, see every book.
+func WithReader(ctx context.Context, username string) context.Context {
+	return context.WithValue(ctx, readerKey{}, username)
+}
+
+// ReaderFrom returns the account browsing the library, false when the call
+// is not made for a reader.
+func ReaderFrom(ctx context.Context) (string, bool) {
+	username, ok := ctx.Value(readerKey{}).(string)
+	return username, ok
+}
+
+// allBooks hides the reader of the request from the library, see
+// WithoutReader.
+type allBooks struct{ context.Context }
+
+func (c allBooks) Value(key any) any {
+	if _, ok := key.(readerKey); ok {
+		return nil
+	}
+	return c.Context.Value(key)
+}
+
+// WithoutReader detaches ctx from the reader of the request, so jobs over
+// the whole library started by an account see every book. Other values of
+// the request, such as its logger and trace, stay.
+func WithoutReader(ctx context.Context) context.Context {
+	return allBooks{ctx}
+}
+
+// forReader limits the filter to the books the reader of the request may
+// see.
+func (f BookFilter) forReader(ctx context.Context) BookFilter {
+	if username, ok := ReaderFrom(ctx); ok {
+		f.Reader, f.Restricted = username, true
+		f.HideScheduled = !seesScheduled(ctx)
+	}
+	return f
+}
+
+// visible reports whether the reader of the request may see the book.
+func visible(ctx context.Context, book entity.Book) bool {
+	username, ok := ReaderFrom(ctx)
+	if !ok {
+		return true
+	}
+	if book.Scheduled(time.Now()) && !seesScheduled(ctx) {
+		return false
+	}
+	return !book.Private || (username != "" && username == book.UploadedBy)
+}
+
+// seesScheduled tells whether the reader sees books before their visible
+// from date, only admins do.
+func seesScheduled(ctx context.Context) bool {
+	role, ok := entity.RoleFrom(ctx)
+	return ok && role.CanManage()
+}
+
+// SetBookVisibleFrom hides the book from all but admins until from, a
+// zero time shows it right away. Only admins schedule books.
+func (uc *BookShelf) SetBookVisibleFrom(ctx context.Context, bookID string, from time.Time) (entity.Book, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - SetBookVisibleFrom - %w", err)
+	}
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - SetBookVisibleFrom - s.getBook: %w", err)
+	}
+	if err = uc.repo.SetVisibleFrom(ctx, bookID, from); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - SetBookVisibleFrom - s.repo.SetVisibleFrom: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	book.VisibleFrom = from
+	return book, nil
+}
+
+// getBook returns the book unless it is private to another account than
+// the reader and not lent to it, then it is not found.
+func (uc *BookShelf) getBook(ctx context.Context, bookID string) (entity.Book, error) {
+	book, err := uc.repo.GetById(ctx, bookID)
+	if err != nil {
+		return entity.Book{}, err
+	}
+	if !uc.canSee(ctx)(book) {
+		return entity.Book{}, entity.ErrBookNotFound
+	}
+	return book, nil
+}
+
+type privateUploadKey struct{}
+
+// WithPrivateUpload sets whether the book uploaded in this request is
+// private to the uploader. Uploads without it follow the preference of the
+// uploader, see SetUploadPreferences.
+func WithPrivateUpload(ctx context.Context, private bool) context.Context {
+	return context.WithValue(ctx, privateUploadKey{}, private)
+}
+
+// SetUploadPreferences makes the uploads of accounts that keep them
+// private by default private, without it uploads are shared unless they
+// ask otherwise.
+func (uc *BookShelf) SetUploadPreferences(preferences UploadPreferences) {
+	uc.preferences = preferences
+}
+
+// privateUpload tells whether the book uploaded in this request is private.
+func (uc *BookShelf) privateUpload(ctx context.Context) bool {
+	if private, ok := ctx.Value(privateUploadKey{}).(bool); ok {
+		return private
+	}
+	username := UploaderFrom(ctx)
+	return uc.preferences != nil && username != "" && uc.preferences.PrivateUploads(ctx, username)
+}
+
+// SetBookPrivate hides the book from everyone but its uploader, or shares
+// it with the whole library again. Only the uploader and admins may change
+// it, books without an uploader can not be private.
+func (uc *BookShelf) SetBookPrivate(ctx context.Context, bookID string, private bool) (entity.Book, error) {
+	if err := entity.RequireEditor(ctx); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - SetBookPrivate - %w", err)
+	}
+	book, err := uc.getBook(ctx, bookID)
+	if err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - SetBookPrivate - s.getBook: %w", err)
+	}
+	if err = canChangeVisibility(ctx, book); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - SetBookPrivate - %w", err)
+	}
+	if private && book.UploadedBy == "" {
+		return entity.Book{}, fmt.Errorf("BookShelf - SetBookPrivate - %w", ErrNoUploader)
+	}
+	if err = uc.repo.SetPrivate(ctx, bookID, private); err != nil {
+		return entity.Book{}, fmt.Errorf("BookShelf - SetBookPrivate - s.repo.SetPrivate: %w", err)
+	}
+	uc.libraryChanged(ctx)
+	book.Private = private
+	return book, nil
+}
+
+// canChangeVisibility allows the uploader and admins to share or hide a
+// book.
+func canChangeVisibility(ctx context.Context, book entity.Book) error {
+	if role, ok := entity.RoleFrom(ctx); ok && role.CanManage() {
+		return nil
+	}
+	if username, ok := ReaderFrom(ctx); ok && username != book.UploadedBy {
+		return entity.ErrForbidden
+	}
+	return nil
+}
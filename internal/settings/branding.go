@@ -0,0 +1,45 @@
+package settings
+
+import (
+	"regexp"
+	"strings"
+)
+
+const DefaultInstanceName = "KOmpanion"
+
+const (
+	keyBrandingInstanceName = "branding.instance_name"
+	keyBrandingLogoURL      = "branding.logo_url"
+	keyBrandingAccentColor  = "branding.accent_color"
+	keyBrandingWelcomeText  = "branding.welcome_text"
+)
+
+// Branding is how the instance presents itself in the web UI, OPDS feeds and emails.
+type Branding struct {
+	InstanceName string `json:"instance_name" form:"instance_name"`
+	LogoURL      string `json:"logo_url" form:"logo_url"`
+	AccentColor  string `json:"accent_color" form:"accent_color"`
+	WelcomeText  string `json:"welcome_text" form:"welcome_text"`
+}
+
+var accentColorPattern = regexp.MustCompile(`^#([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+func (b Branding) withDefaults() Branding {
+	if strings.TrimSpace(b.InstanceName) == "" {
+		b.InstanceName = DefaultInstanceName
+	}
+	return b
+}
+
+func (b Branding) validate() error {
+	if b.AccentColor != "" && !accentColorPattern.MatchString(b.AccentColor) {
+		return ErrInvalidValue
+	}
+	if b.LogoURL != "" && !strings.HasPrefix(b.LogoURL, "https://") && !strings.HasPrefix(b.LogoURL, "http://") && !strings.HasPrefix(b.LogoURL, "/") {
+		return ErrInvalidValue
+	}
+	if len(b.InstanceName) > 64 {
+		return ErrInvalidValue
+	}
+	return nil
+}
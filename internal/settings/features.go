@@ -0,0 +1,62 @@
+package settings
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const (
+	FeatureOPDS        = "opds"
+	FeatureSync        = "sync"
+	FeatureWebDAV      = "webdav"
+	FeatureConversions = "conversions"
+	FeatureSharing     = "sharing"
+
+	keyFeaturesPrefix = "features."
+)
+
+// FeatureNames are the parts of the instance that can be switched off.
+var FeatureNames = []string{FeatureOPDS, FeatureSync, FeatureWebDAV, FeatureConversions, FeatureSharing}
+
+// Features tell which optional parts of the instance are on, so minimal
+// installs do not serve what they do not use. A feature not in the map is on.
+type Features map[string]bool
+
+// Enabled -.
+func (f Features) Enabled(name string) bool {
+	on, ok := f[name]
+	return !ok || on
+}
+
+// FeaturesWithout returns every feature on but the disabled ones, names as
+// in FeatureNames.
+func FeaturesWithout(disabled []string) (Features, error) {
+	features := make(Features, len(FeatureNames))
+	for _, name := range FeatureNames {
+		features[name] = true
+	}
+	for _, name := range disabled {
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+		if _, ok := features[name]; !ok {
+			return nil, fmt.Errorf("%w: unknown feature %q, known are %s", ErrInvalidValue, name, strings.Join(FeatureNames, ", "))
+		}
+		features[name] = false
+	}
+	return features, nil
+}
+
+// featuresFromValues lays the stored switches over the defaults.
+func featuresFromValues(defaults Features, values map[string]string) Features {
+	features := make(Features, len(FeatureNames))
+	for _, name := range FeatureNames {
+		features[name] = defaults.Enabled(name)
+		if on, err := strconv.ParseBool(values[keyFeaturesPrefix+name]); err == nil {
+			features[name] = on
+		}
+	}
+	return features
+}
@@ -0,0 +1,35 @@
+package settings
+
+import (
+	"context"
+	"errors"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+var ErrNotFound = errors.New("setting not found")
+var ErrInvalidValue = errors.New("invalid setting value")
+var ErrFeatureDisabled = errors.New("feature is disabled on this server")
+
+type (
+	// Settings -.
+	Settings interface {
+		Branding(ctx context.Context) (Branding, error)
+		UpdateBranding(ctx context.Context, branding Branding) (Branding, error)
+		Maintenance(ctx context.Context) (Maintenance, error)
+		SetMaintenance(ctx context.Context, maintenance Maintenance) (Maintenance, error)
+		Sharing(ctx context.Context) (Sharing, error)
+		SetSharing(ctx context.Context, sharing Sharing) (Sharing, error)
+		Features(ctx context.Context) (Features, error)
+		SetFeatures(ctx context.Context, changes Features) (Features, error)
+		MetadataRules(ctx context.Context) ([]entity.MetadataRule, error)
+		SetMetadataRules(ctx context.Context, rules []entity.MetadataRule) ([]entity.MetadataRule, error)
+	}
+
+	// SettingsRepo is a plain key-value store for instance-wide settings.
+	SettingsRepo interface {
+		Get(ctx context.Context, key string) (string, error)
+		List(ctx context.Context, prefix string) (map[string]string, error)
+		Set(ctx context.Context, key, value string) error
+	}
+)
@@ -0,0 +1,47 @@
+package settings
+
+import (
+	"strconv"
+	"time"
+)
+
+const (
+	keyMaintenanceReadOnly   = "maintenance.read_only"
+	keyMaintenanceMessage    = "maintenance.message"
+	keyMaintenanceRetryAfter = "maintenance.retry_after"
+
+	defaultMaintenanceRetryAfter = 5 * time.Minute
+)
+
+// Maintenance switches the instance to read-only: browsing and downloads work,
+// every mutation is rejected until the switch is turned off.
+type Maintenance struct {
+	ReadOnly   bool          `json:"read_only" form:"read_only"`
+	Message    string        `json:"message" form:"message"`
+	RetryAfter time.Duration `json:"-" form:"-"`
+}
+
+func maintenanceFromValues(values map[string]string) Maintenance {
+	m := Maintenance{
+		ReadOnly:   values[keyMaintenanceReadOnly] == "true",
+		Message:    values[keyMaintenanceMessage],
+		RetryAfter: defaultMaintenanceRetryAfter,
+	}
+	if seconds, err := strconv.Atoi(values[keyMaintenanceRetryAfter]); err == nil && seconds > 0 {
+		m.RetryAfter = time.Duration(seconds) * time.Second
+	}
+	return m
+}
+
+func (m Maintenance) values() map[string]string {
+	return map[string]string{
+		keyMaintenanceReadOnly:   strconv.FormatBool(m.ReadOnly),
+		keyMaintenanceMessage:    m.Message,
+		keyMaintenanceRetryAfter: strconv.Itoa(int(m.RetryAfter.Seconds())),
+	}
+}
+
+// RetryAfterMinutes rounds RetryAfter up to whole minutes for the settings form.
+func (m Maintenance) RetryAfterMinutes() int {
+	return int((m.RetryAfter + time.Minute - 1) / time.Minute)
+}
@@ -0,0 +1,22 @@
+package settings
+
+import (
+	"encoding/json"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+const keyMetadataRules = "metadata.rules"
+
+// metadataRulesFromValues reads the rules stored as JSON, a broken value
+// leaves uploads as they are.
+func metadataRulesFromValues(values map[string]string) ([]entity.MetadataRule, error) {
+	rules := []entity.MetadataRule{}
+	if values[keyMetadataRules] == "" {
+		return rules, nil
+	}
+	if err := json.Unmarshal([]byte(values[keyMetadataRules]), &rules); err != nil {
+		return []entity.MetadataRule{}, err
+	}
+	return rules, nil
+}
@@ -0,0 +1,48 @@
+package settings
+
+import (
+	"context"
+	"strings"
+	"sync"
+)
+
+type MemorySettingsRepo struct {
+	mu     sync.RWMutex
+	values map[string]string
+}
+
+func NewMemorySettingsRepo() *MemorySettingsRepo {
+	return &MemorySettingsRepo{values: make(map[string]string)}
+}
+
+func (r *MemorySettingsRepo) Get(ctx context.Context, key string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	value, ok := r.values[key]
+	if !ok {
+		return "", ErrNotFound
+	}
+	return value, nil
+}
+
+func (r *MemorySettingsRepo) List(ctx context.Context, prefix string) (map[string]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	values := make(map[string]string)
+	for key, value := range r.values {
+		if strings.HasPrefix(key, prefix) {
+			values[key] = value
+		}
+	}
+	return values, nil
+}
+
+func (r *MemorySettingsRepo) Set(ctx context.Context, key, value string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.values[key] = value
+	return nil
+}
@@ -0,0 +1,69 @@
+package settings
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+type SettingsDatabaseRepo struct {
+	*postgres.Postgres
+}
+
+func NewSettingsDatabaseRepo(pg *postgres.Postgres) *SettingsDatabaseRepo {
+	return &SettingsDatabaseRepo{pg}
+}
+
+func (r *SettingsDatabaseRepo) Get(ctx context.Context, key string) (string, error) {
+	sql := `SELECT value FROM settings_entry WHERE key = $1`
+
+	var value string
+	err := r.Pool.QueryRow(ctx, sql, key).Scan(&value)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("SettingsDatabaseRepo - Get - row.Scan: %w", err)
+	}
+	return value, nil
+}
+
+func (r *SettingsDatabaseRepo) List(ctx context.Context, prefix string) (map[string]string, error) {
+	sql := `SELECT key, value FROM settings_entry WHERE starts_with(key, $1)`
+
+	rows, err := r.Pool.Query(ctx, sql, prefix)
+	if err != nil {
+		return nil, fmt.Errorf("SettingsDatabaseRepo - List - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	values := make(map[string]string)
+	for rows.Next() {
+		var key, value string
+		if err = rows.Scan(&key, &value); err != nil {
+			return nil, fmt.Errorf("SettingsDatabaseRepo - List - rows.Scan: %w", err)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
+func (r *SettingsDatabaseRepo) Set(ctx context.Context, key, value string) error {
+	sql := `
+		INSERT INTO settings_entry (key, value)
+		VALUES ($1, $2)
+		ON CONFLICT (key) DO UPDATE
+		SET value = EXCLUDED.value,
+			updated_at = NOW()
+	`
+
+	_, err := r.Pool.Exec(ctx, sql, key, value)
+	if err != nil {
+		return fmt.Errorf("SettingsDatabaseRepo - Set - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,276 @@
+package settings
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/banjuer/kompanion/internal/entity"
+)
+
+// InstanceSettings keeps instance-wide settings and caches them in memory,
+// because they are read on every page render.
+type InstanceSettings struct {
+	repo SettingsRepo
+
+	mu          sync.RWMutex
+	branding    *Branding
+	maintenance *Maintenance
+	sharing     *Sharing
+	features    Features
+	rules       []entity.MetadataRule
+
+	defaultFeatures Features
+}
+
+func NewInstanceSettings(repo SettingsRepo) *InstanceSettings {
+	return &InstanceSettings{repo: repo}
+}
+
+func (s *InstanceSettings) Branding(ctx context.Context) (Branding, error) {
+	s.mu.RLock()
+	cached := s.branding
+	s.mu.RUnlock()
+	if cached != nil {
+		return *cached, nil
+	}
+
+	values, err := s.repo.List(ctx, "branding.")
+	if err != nil {
+		return Branding{}.withDefaults(), fmt.Errorf("InstanceSettings - Branding - s.repo.List: %w", err)
+	}
+
+	branding := Branding{
+		InstanceName: values[keyBrandingInstanceName],
+		LogoURL:      values[keyBrandingLogoURL],
+		AccentColor:  values[keyBrandingAccentColor],
+		WelcomeText:  values[keyBrandingWelcomeText],
+	}.withDefaults()
+
+	s.mu.Lock()
+	s.branding = &branding
+	s.mu.Unlock()
+
+	return branding, nil
+}
+
+func (s *InstanceSettings) UpdateBranding(ctx context.Context, branding Branding) (Branding, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return Branding{}, fmt.Errorf("InstanceSettings - UpdateBranding - %w", err)
+	}
+	branding.InstanceName = strings.TrimSpace(branding.InstanceName)
+	branding.LogoURL = strings.TrimSpace(branding.LogoURL)
+	branding.AccentColor = strings.TrimSpace(branding.AccentColor)
+	if err := branding.validate(); err != nil {
+		return Branding{}, fmt.Errorf("InstanceSettings - UpdateBranding - validate: %w", err)
+	}
+
+	values := map[string]string{
+		keyBrandingInstanceName: branding.InstanceName,
+		keyBrandingLogoURL:      branding.LogoURL,
+		keyBrandingAccentColor:  branding.AccentColor,
+		keyBrandingWelcomeText:  branding.WelcomeText,
+	}
+	for key, value := range values {
+		if err := s.repo.Set(ctx, key, value); err != nil {
+			s.invalidate()
+			return Branding{}, fmt.Errorf("InstanceSettings - UpdateBranding - s.repo.Set: %w", err)
+		}
+	}
+	s.invalidate()
+
+	return s.Branding(ctx)
+}
+
+// Maintenance is checked on every request, so it is served from cache
+// and only refreshed after SetMaintenance.
+func (s *InstanceSettings) Maintenance(ctx context.Context) (Maintenance, error) {
+	s.mu.RLock()
+	cached := s.maintenance
+	s.mu.RUnlock()
+	if cached != nil {
+		return *cached, nil
+	}
+
+	values, err := s.repo.List(ctx, "maintenance.")
+	if err != nil {
+		return maintenanceFromValues(nil), fmt.Errorf("InstanceSettings - Maintenance - s.repo.List: %w", err)
+	}
+	maintenance := maintenanceFromValues(values)
+
+	s.mu.Lock()
+	s.maintenance = &maintenance
+	s.mu.Unlock()
+
+	return maintenance, nil
+}
+
+func (s *InstanceSettings) SetMaintenance(ctx context.Context, maintenance Maintenance) (Maintenance, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return Maintenance{}, fmt.Errorf("InstanceSettings - SetMaintenance - %w", err)
+	}
+	if maintenance.RetryAfter < 0 {
+		return Maintenance{}, fmt.Errorf("InstanceSettings - SetMaintenance - validate: %w", ErrInvalidValue)
+	}
+	for key, value := range maintenance.values() {
+		if err := s.repo.Set(ctx, key, value); err != nil {
+			s.invalidate()
+			return Maintenance{}, fmt.Errorf("InstanceSettings - SetMaintenance - s.repo.Set: %w", err)
+		}
+	}
+	s.invalidate()
+
+	return s.Maintenance(ctx)
+}
+
+// Sharing is checked on every public page, so it is served from cache.
+func (s *InstanceSettings) Sharing(ctx context.Context) (Sharing, error) {
+	s.mu.RLock()
+	cached := s.sharing
+	s.mu.RUnlock()
+	if cached != nil {
+		return *cached, nil
+	}
+
+	values, err := s.repo.List(ctx, "sharing.")
+	if err != nil {
+		return Sharing{}, fmt.Errorf("InstanceSettings - Sharing - s.repo.List: %w", err)
+	}
+	sharing := sharingFromValues(values)
+
+	s.mu.Lock()
+	s.sharing = &sharing
+	s.mu.Unlock()
+
+	return sharing, nil
+}
+
+func (s *InstanceSettings) SetSharing(ctx context.Context, sharing Sharing) (Sharing, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return Sharing{}, fmt.Errorf("InstanceSettings - SetSharing - %w", err)
+	}
+	// pages that are not public can not be indexed
+	sharing.Indexable = sharing.Indexable && sharing.PublicPages
+	for key, value := range sharing.values() {
+		if err := s.repo.Set(ctx, key, value); err != nil {
+			s.invalidate()
+			return Sharing{}, fmt.Errorf("InstanceSettings - SetSharing - s.repo.Set: %w", err)
+		}
+	}
+	s.invalidate()
+
+	return s.Sharing(ctx)
+}
+
+// SetFeatureDefaults sets the features that are on until they are switched
+// with SetFeatures, all of them when not set.
+func (s *InstanceSettings) SetFeatureDefaults(defaults Features) {
+	s.mu.Lock()
+	s.defaultFeatures = defaults
+	s.features = nil
+	s.mu.Unlock()
+}
+
+// Features is checked on every request, so it is served from cache.
+func (s *InstanceSettings) Features(ctx context.Context) (Features, error) {
+	s.mu.RLock()
+	cached, defaults := s.features, s.defaultFeatures
+	s.mu.RUnlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	values, err := s.repo.List(ctx, keyFeaturesPrefix)
+	if err != nil {
+		return featuresFromValues(defaults, nil), fmt.Errorf("InstanceSettings - Features - s.repo.List: %w", err)
+	}
+	features := featuresFromValues(defaults, values)
+
+	s.mu.Lock()
+	s.features = features
+	s.mu.Unlock()
+
+	return features, nil
+}
+
+// SetFeatures switches the features in changes, the others stay as they are.
+func (s *InstanceSettings) SetFeatures(ctx context.Context, changes Features) (Features, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return nil, fmt.Errorf("InstanceSettings - SetFeatures - %w", err)
+	}
+	for name := range changes {
+		if _, err := FeaturesWithout([]string{name}); err != nil {
+			return nil, fmt.Errorf("InstanceSettings - SetFeatures - %w", err)
+		}
+	}
+	for name, on := range changes {
+		if err := s.repo.Set(ctx, keyFeaturesPrefix+name, strconv.FormatBool(on)); err != nil {
+			s.invalidate()
+			return nil, fmt.Errorf("InstanceSettings - SetFeatures - s.repo.Set: %w", err)
+		}
+	}
+	s.invalidate()
+
+	return s.Features(ctx)
+}
+
+// MetadataRules are applied to every upload, so they are served from cache.
+func (s *InstanceSettings) MetadataRules(ctx context.Context) ([]entity.MetadataRule, error) {
+	s.mu.RLock()
+	cached := s.rules
+	s.mu.RUnlock()
+	if cached != nil {
+		return cached, nil
+	}
+
+	values, err := s.repo.List(ctx, "metadata.")
+	if err != nil {
+		return nil, fmt.Errorf("InstanceSettings - MetadataRules - s.repo.List: %w", err)
+	}
+	rules, err := metadataRulesFromValues(values)
+	if err != nil {
+		return nil, fmt.Errorf("InstanceSettings - MetadataRules - json.Unmarshal: %w", err)
+	}
+
+	s.mu.Lock()
+	s.rules = rules
+	s.mu.Unlock()
+
+	return rules, nil
+}
+
+// SetMetadataRules replaces the rules, they are applied in order.
+func (s *InstanceSettings) SetMetadataRules(ctx context.Context, rules []entity.MetadataRule) ([]entity.MetadataRule, error) {
+	if err := entity.RequireAdmin(ctx); err != nil {
+		return nil, fmt.Errorf("InstanceSettings - SetMetadataRules - %w", err)
+	}
+	for i, rule := range rules {
+		if _, err := rule.Compile(); err != nil {
+			return nil, fmt.Errorf("InstanceSettings - SetMetadataRules - rule %d: %w: %w", i+1, ErrInvalidValue, err)
+		}
+	}
+	value, err := json.Marshal(rules)
+	if err != nil {
+		return nil, fmt.Errorf("InstanceSettings - SetMetadataRules - json.Marshal: %w", err)
+	}
+	if err = s.repo.Set(ctx, keyMetadataRules, string(value)); err != nil {
+		s.invalidate()
+		return nil, fmt.Errorf("InstanceSettings - SetMetadataRules - s.repo.Set: %w", err)
+	}
+	s.invalidate()
+
+	return s.MetadataRules(ctx)
+}
+
+func (s *InstanceSettings) invalidate() {
+	s.mu.Lock()
+	s.branding = nil
+	s.maintenance = nil
+	s.sharing = nil
+	s.features = nil
+	s.rules = nil
+	s.mu.Unlock()
+}
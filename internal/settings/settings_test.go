@@ -0,0 +1,182 @@
+package settings_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/settings"
+)
+
+func TestBrandingDefaults(t *testing.T) {
+	s := settings.NewInstanceSettings(settings.NewMemorySettingsRepo())
+
+	branding, err := s.Branding(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if branding.InstanceName != settings.DefaultInstanceName {
+		t.Fatalf("expected default instance name, got %q", branding.InstanceName)
+	}
+}
+
+func TestUpdateBrandingInvalidatesCache(t *testing.T) {
+	ctx := context.Background()
+	s := settings.NewInstanceSettings(settings.NewMemorySettingsRepo())
+
+	// warm up cache
+	if _, err := s.Branding(ctx); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	_, err := s.UpdateBranding(ctx, settings.Branding{
+		InstanceName: " Family Library ",
+		AccentColor:  "#aa3300",
+		WelcomeText:  "Welcome home",
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	branding, err := s.Branding(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if branding.InstanceName != "Family Library" || branding.AccentColor != "#aa3300" || branding.WelcomeText != "Welcome home" {
+		t.Fatalf("expected updated branding, got %+v", branding)
+	}
+}
+
+func TestUpdateBrandingRejectsInvalidColor(t *testing.T) {
+	s := settings.NewInstanceSettings(settings.NewMemorySettingsRepo())
+
+	_, err := s.UpdateBranding(context.Background(), settings.Branding{AccentColor: "red; background: url(x)"})
+	if !errors.Is(err, settings.ErrInvalidValue) {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+}
+
+func TestSetMaintenance(t *testing.T) {
+	ctx := context.Background()
+	s := settings.NewInstanceSettings(settings.NewMemorySettingsRepo())
+
+	m, err := s.Maintenance(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if m.ReadOnly {
+		t.Fatalf("expected instance to be writable by default")
+	}
+
+	_, err = s.SetMaintenance(ctx, settings.Maintenance{ReadOnly: true, Message: "moving storage", RetryAfter: 10 * time.Minute})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	m, err = s.Maintenance(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !m.ReadOnly || m.Message != "moving storage" || m.RetryAfter != 10*time.Minute {
+		t.Fatalf("expected maintenance to be stored, got %+v", m)
+	}
+}
+
+func TestSetSharing(t *testing.T) {
+	ctx := context.Background()
+	s := settings.NewInstanceSettings(settings.NewMemorySettingsRepo())
+
+	sharing, err := s.Sharing(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sharing.PublicPages || sharing.Indexable {
+		t.Fatalf("expected book pages private by default, got %+v", sharing)
+	}
+
+	sharing, err = s.SetSharing(ctx, settings.Sharing{Indexable: true})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if sharing.Indexable {
+		t.Errorf("expected private pages not to be indexable")
+	}
+
+	if _, err = s.SetSharing(ctx, settings.Sharing{PublicPages: true, Indexable: true}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	sharing, _ = s.Sharing(ctx)
+	if !sharing.PublicPages || !sharing.Indexable {
+		t.Errorf("expected public indexable pages, got %+v", sharing)
+	}
+}
+
+func TestFeatures(t *testing.T) {
+	ctx := context.Background()
+	s := settings.NewInstanceSettings(settings.NewMemorySettingsRepo())
+
+	defaults, err := settings.FeaturesWithout([]string{" OPDS", ""})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	s.SetFeatureDefaults(defaults)
+
+	features, err := s.Features(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if features.Enabled(settings.FeatureOPDS) || !features.Enabled(settings.FeatureSync) {
+		t.Fatalf("expected opds off by default, got %+v", features)
+	}
+
+	if _, err := s.SetFeatures(ctx, settings.Features{settings.FeatureOPDS: true, settings.FeatureSharing: false}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	features, err = s.Features(ctx)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if !features.Enabled(settings.FeatureOPDS) || features.Enabled(settings.FeatureSharing) || !features.Enabled(settings.FeatureWebDAV) {
+		t.Fatalf("expected updated features, got %+v", features)
+	}
+}
+
+func TestFeaturesRejectUnknownNames(t *testing.T) {
+	if _, err := settings.FeaturesWithout([]string{"llm"}); !errors.Is(err, settings.ErrInvalidValue) {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+	s := settings.NewInstanceSettings(settings.NewMemorySettingsRepo())
+	_, err := s.SetFeatures(context.Background(), settings.Features{"llm": false})
+	if !errors.Is(err, settings.ErrInvalidValue) {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+}
+
+func TestSetMetadataRules(t *testing.T) {
+	ctx := context.Background()
+	s := settings.NewInstanceSettings(settings.NewMemorySettingsRepo())
+
+	rules, err := s.MetadataRules(ctx)
+	if err != nil || len(rules) != 0 {
+		t.Fatalf("expected no rules, got %v, %v", rules, err)
+	}
+
+	_, err = s.SetMetadataRules(ctx, []entity.MetadataRule{{Field: "title", Pattern: "(unclosed"}})
+	if !errors.Is(err, settings.ErrInvalidValue) || !errors.Is(err, entity.ErrInvalidMetadataRule) {
+		t.Fatalf("expected ErrInvalidValue, got %v", err)
+	}
+
+	want := []entity.MetadataRule{
+		{Field: "title", Pattern: `\s*\[retail\]`},
+		{Field: "publisher", Pattern: `^Penguin Books$`, Replace: "Penguin"},
+	}
+	if _, err = s.SetMetadataRules(ctx, want); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	rules, _ = s.MetadataRules(ctx)
+	if len(rules) != 2 || rules[0] != want[0] || rules[1] != want[1] {
+		t.Fatalf("expected the rules in order, got %+v", rules)
+	}
+}
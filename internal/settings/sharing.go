@@ -0,0 +1,30 @@
+package settings
+
+import "strconv"
+
+const (
+	keySharingPublicPages = "sharing.public_pages"
+	keySharingIndexable   = "sharing.indexable"
+)
+
+// Sharing opens a page with the metadata and cover of every book to
+// everyone, so links shared in chat apps unfurl. Search engines are kept
+// away unless Indexable is set. Book files stay behind the login.
+type Sharing struct {
+	PublicPages bool `json:"public_pages" form:"public_pages"`
+	Indexable   bool `json:"indexable" form:"indexable"`
+}
+
+func sharingFromValues(values map[string]string) Sharing {
+	return Sharing{
+		PublicPages: values[keySharingPublicPages] == "true",
+		Indexable:   values[keySharingIndexable] == "true",
+	}
+}
+
+func (s Sharing) values() map[string]string {
+	return map[string]string{
+		keySharingPublicPages: strconv.FormatBool(s.PublicPages),
+		keySharingIndexable:   strconv.FormatBool(s.Indexable),
+	}
+}
@@ -0,0 +1,228 @@
+package stats
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// koreaderSchemaVersion is DB_SCHEMA_VERSION of the KOReader statistics plugin.
+const koreaderSchemaVersion = 20221111
+
+var koreaderDDL = []string{
+	`CREATE TABLE book
+	(
+		id integer PRIMARY KEY autoincrement,
+		title text,
+		authors text,
+		notes integer,
+		last_open integer,
+		highlights integer,
+		pages integer,
+		series text,
+		language text,
+		md5 text,
+		total_read_time integer,
+		total_read_pages integer
+	)`,
+	`CREATE UNIQUE INDEX book_title_authors_md5 ON book(title, authors, md5)`,
+	`CREATE TABLE page_stat_data
+	(
+		id_book integer,
+		page integer NOT NULL DEFAULT 0,
+		start_time integer NOT NULL DEFAULT 0,
+		duration integer NOT NULL DEFAULT 0,
+		total_pages integer NOT NULL DEFAULT 0,
+		UNIQUE (id_book, page, start_time),
+		FOREIGN KEY(id_book) REFERENCES book(id)
+	)`,
+	`CREATE INDEX page_stat_data_start_time ON page_stat_data(start_time)`,
+	`CREATE TABLE numbers
+	(
+		number INTEGER PRIMARY KEY
+	)`,
+	`WITH RECURSIVE counter AS (SELECT 1 AS n UNION ALL SELECT n + 1 FROM counter WHERE n < 1000)
+	INSERT INTO numbers SELECT n FROM counter`,
+	`CREATE VIEW page_stat AS
+	SELECT id_book, first_page + idx - 1 AS page, start_time, duration / (last_page - first_page + 1) AS duration
+	FROM (
+		SELECT id_book, page, total_pages, pages, start_time, duration,
+			((page - 1) * pages) / total_pages + 1 AS first_page,
+			max(((page - 1) * pages) / total_pages + 1, (page * pages) / total_pages) AS last_page,
+			idx
+		FROM page_stat_data
+		JOIN book ON book.id = id_book
+		JOIN (SELECT number as idx FROM numbers) AS N ON idx <= (last_page - first_page + 1)
+	)`,
+	fmt.Sprintf("PRAGMA user_version = %d", koreaderSchemaVersion),
+}
+
+// Export builds a KOReader statistics.sqlite3 holding the statistics of all
+// devices, so a new device starts with the full history. Pages read on
+// several devices are kept once. The returned file is open at the start and
+// already unlinked.
+func (s *KOReaderPGStats) Export(ctx context.Context) (*os.File, error) {
+	tempFile, err := os.CreateTemp("", "statistics-*.sqlite3")
+	if err != nil {
+		return nil, fmt.Errorf("KOReaderPGStats - Export - os.CreateTemp: %w", err)
+	}
+	path := tempFile.Name()
+	tempFile.Close()
+	defer os.Remove(path)
+
+	err = s.exportTo(ctx, path)
+	if err != nil {
+		return nil, fmt.Errorf("KOReaderPGStats - Export - %w", err)
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("KOReaderPGStats - Export - os.Open: %w", err)
+	}
+	return file, nil
+}
+
+func (s *KOReaderPGStats) exportTo(ctx context.Context, path string) error {
+	sqliteDB, err := sql.Open("sqlite3", path)
+	if err != nil {
+		return fmt.Errorf("sql.Open: %w", err)
+	}
+	defer sqliteDB.Close()
+
+	for _, ddl := range koreaderDDL {
+		if _, err = sqliteDB.ExecContext(ctx, ddl); err != nil {
+			return fmt.Errorf("create schema: %w", err)
+		}
+	}
+
+	tx, err := sqliteDB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqliteDB.BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	bookIDs, err := s.exportBooks(ctx, tx)
+	if err != nil {
+		return err
+	}
+	err = s.exportPageStatData(ctx, tx, bookIDs)
+	if err != nil {
+		return err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("tx.Commit: %w", err)
+	}
+	return sqliteDB.Close()
+}
+
+// exportBooks writes one book row per document, read totals are counted
+// from the merged page data. It returns the KOReader book id of each hash.
+func (s *KOReaderPGStats) exportBooks(ctx context.Context, tx *sql.Tx) (map[string]int64, error) {
+	rows, err := s.pg.Pool.Query(ctx, `
+		SELECT
+			b.koreader_partial_md5,
+			MAX(b.title),
+			COALESCE(MAX(b.authors), ''),
+			MAX(b.notes),
+			COALESCE(EXTRACT(EPOCH FROM MAX(b.last_open))::bigint, 0),
+			MAX(b.highlights),
+			MAX(b.pages),
+			MAX(b.series),
+			MAX(b.language),
+			COALESCE(p.total_read_time, 0),
+			COALESCE(p.total_read_pages, 0)
+		FROM stats_book b
+		LEFT JOIN (
+			SELECT koreader_partial_md5, SUM(duration) AS total_read_time, COUNT(DISTINCT page) AS total_read_pages
+			FROM (
+				SELECT koreader_partial_md5, page, start_time, MAX(duration) AS duration
+				FROM stats_page_stat_data
+				GROUP BY koreader_partial_md5, page, start_time
+			) merged
+			GROUP BY koreader_partial_md5
+		) p ON p.koreader_partial_md5 = b.koreader_partial_md5
+		WHERE b.koreader_partial_md5 IS NOT NULL
+		GROUP BY b.koreader_partial_md5, p.total_read_time, p.total_read_pages
+		ORDER BY b.koreader_partial_md5
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("s.pg.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	insert, err := tx.PrepareContext(ctx, `
+		INSERT INTO book (title, authors, notes, last_open, highlights, pages, series, language, md5, total_read_time, total_read_pages)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return nil, fmt.Errorf("tx.PrepareContext: %w", err)
+	}
+	defer insert.Close()
+
+	bookIDs := make(map[string]int64)
+	for rows.Next() {
+		var book Book
+		err = rows.Scan(&book.MD5, &book.Title, &book.Authors, &book.Notes, &book.LastOpen, &book.Highlights,
+			&book.Pages, &book.Series, &book.Language, &book.TotalReadTime, &book.TotalReadPages)
+		if err != nil {
+			return nil, fmt.Errorf("rows.Scan: %w", err)
+		}
+		result, err := insert.ExecContext(ctx, book.Title, book.Authors, book.Notes, book.LastOpen, book.Highlights,
+			book.Pages, book.Series, book.Language, book.MD5, book.TotalReadTime, book.TotalReadPages)
+		if err != nil {
+			return nil, fmt.Errorf("insert book: %w", err)
+		}
+		bookIDs[book.MD5], err = result.LastInsertId()
+		if err != nil {
+			return nil, fmt.Errorf("result.LastInsertId: %w", err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("rows.Err: %w", err)
+	}
+	return bookIDs, nil
+}
+
+func (s *KOReaderPGStats) exportPageStatData(ctx context.Context, tx *sql.Tx, bookIDs map[string]int64) error {
+	rows, err := s.pg.Pool.Query(ctx, `
+		SELECT koreader_partial_md5, page, EXTRACT(EPOCH FROM start_time)::bigint, MAX(duration), MAX(total_pages)
+		FROM stats_page_stat_data
+		GROUP BY koreader_partial_md5, page, start_time
+		ORDER BY start_time
+	`)
+	if err != nil {
+		return fmt.Errorf("s.pg.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	insert, err := tx.PrepareContext(ctx, `
+		INSERT OR IGNORE INTO page_stat_data (id_book, page, start_time, duration, total_pages)
+		VALUES (?, ?, ?, ?, ?)`)
+	if err != nil {
+		return fmt.Errorf("tx.PrepareContext: %w", err)
+	}
+	defer insert.Close()
+
+	for rows.Next() {
+		var pageData PageStatData
+		err = rows.Scan(&pageData.MD5, &pageData.Page, &pageData.StartTime, &pageData.Duration, &pageData.TotalPages)
+		if err != nil {
+			return fmt.Errorf("rows.Scan: %w", err)
+		}
+		bookID, ok := bookIDs[pageData.MD5]
+		if !ok {
+			continue
+		}
+		_, err = insert.ExecContext(ctx, bookID, pageData.Page, pageData.StartTime, pageData.Duration, pageData.TotalPages)
+		if err != nil {
+			return fmt.Errorf("insert page stat data: %w", err)
+		}
+	}
+	if err = rows.Err(); err != nil {
+		return fmt.Errorf("rows.Err: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+package stats_test
+
+import (
+	"context"
+	"database/sql"
+	"os"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v4"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/banjuer/kompanion/internal/stats"
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+func TestExport(t *testing.T) {
+	pgmock, err := pgxmock.NewPool()
+	require.NoError(t, err)
+	defer pgmock.Close()
+
+	pgmock.ExpectQuery(`SELECT b.koreader_partial_md5`).
+		WillReturnRows(pgmock.NewRows([]string{"md5", "title", "authors", "notes", "last_open", "highlights", "pages", "series", "language", "total_read_time", "total_read_pages"}).
+			AddRow("abc", "Crime and Punishment", "Fyodor Dostoevsky", int64(1), int64(1700000000), int64(2), int64(500), nil, "en", int64(90), int64(2)))
+	pgmock.ExpectQuery(`SELECT koreader_partial_md5, page`).
+		WillReturnRows(pgmock.NewRows([]string{"md5", "page", "start_time", "duration", "total_pages"}).
+			AddRow("abc", 1, 1700000000, 30, 500).
+			AddRow("abc", 2, 1700000030, 60, 500).
+			AddRow("unknown", 1, 1700000100, 10, 100))
+
+	file, err := stats.NewKOReaderPGStats(postgres.Mock(pgmock)).Export(context.Background())
+	require.NoError(t, err)
+	defer file.Close()
+	require.NoError(t, pgmock.ExpectationsWereMet())
+
+	// sqlite needs a path, copy the unlinked export back
+	copied, err := os.CreateTemp("", "statistics-*.sqlite3")
+	require.NoError(t, err)
+	defer os.Remove(copied.Name())
+	_, err = copied.ReadFrom(file)
+	require.NoError(t, err)
+	copied.Close()
+
+	db, err := sql.Open("sqlite3", copied.Name())
+	require.NoError(t, err)
+	defer db.Close()
+
+	var title string
+	var totalReadTime, version int
+	require.NoError(t, db.QueryRow(`SELECT title, total_read_time FROM book WHERE md5 = 'abc'`).Scan(&title, &totalReadTime))
+	assert.Equal(t, "Crime and Punishment", title)
+	assert.Equal(t, 90, totalReadTime)
+
+	var pages int
+	require.NoError(t, db.QueryRow(`SELECT COUNT(*) FROM page_stat_data`).Scan(&pages))
+	assert.Equal(t, 2, pages)
+
+	require.NoError(t, db.QueryRow(`PRAGMA user_version`).Scan(&version))
+	assert.Equal(t, 20221111, version)
+}
@@ -4,6 +4,7 @@ import (
 	"context"
 	"errors"
 	"io"
+	"os"
 	"time"
 )
 
@@ -27,4 +28,5 @@ type ReadingStats interface {
 	GetGeneralStats(ctx context.Context, from, to time.Time) (*GeneralStats, error)
 	GetDailyStats(ctx context.Context, from, to time.Time) ([]DailyStats, error)
 	Write(ctx context.Context, r io.ReadCloser, deviceName string) error
+	Export(ctx context.Context) (*os.File, error)
 }
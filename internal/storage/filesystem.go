@@ -54,25 +54,120 @@ func (s *FilesystemStorage) Write(ctx context.Context, src, dest string) error {
 	}
 	defer srcFile.Close()
 
+	return s.Put(ctx, dest, srcFile)
+}
+
+func (s *FilesystemStorage) Open(ctx context.Context, p string) (File, error) {
+	file, err := os.Open(path.Join(s.root, p))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+// TempFile is a file the caller wrote, owns and deletes after Put. Put may
+// take it over: filesystem storage hard links it instead of copying, other
+// readers, a user's own file among them, are always copied.
+type TempFile struct {
+	*os.File
+}
+
+// Put writes to a temp file next to dest, syncs it and renames it over
+// dest, so a crash leaves either the old file or the complete new one.
+func (s *FilesystemStorage) Put(ctx context.Context, dest string, r io.Reader) error {
 	dst := path.Join(s.root, dest)
 	dirPath := filepath.Dir(dst)
-	err = os.MkdirAll(dirPath, os.ModePerm)
+	err := os.MkdirAll(dirPath, os.ModePerm)
 	if err != nil {
 		return err
 	}
 
-	destFile, err := os.Create(dst)
-	if err != nil {
+	tmp := ""
+	if file, ok := r.(TempFile); ok {
+		tmp = linkFile(file.File, dirPath)
+	}
+	if tmp == "" {
+		if tmp, err = copyFile(r, dirPath); err != nil {
+			return err
+		}
+	}
+
+	if err = os.Rename(tmp, dst); err != nil {
+		os.Remove(tmp)
 		return err
 	}
-	defer destFile.Close()
+	return syncDir(dirPath)
+}
 
-	_, err = io.Copy(destFile, srcFile)
+// copyFile copies r into a synced temp file in dir and returns its name.
+func copyFile(r io.Reader, dir string) (string, error) {
+	tmp, err := os.CreateTemp(dir, tempPrefix)
 	if err != nil {
-		return err
+		return "", err
+	}
+	_, err = io.Copy(tmp, r)
+	if err == nil {
+		err = tmp.Sync()
 	}
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// temp files are private, stored files are readable by others
+		err = os.Chmod(tmp.Name(), 0o644)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return "", err
+	}
+	return tmp.Name(), nil
+}
 
-	return nil
+// linkFile hard links file to a temp name in dir instead of copying it,
+// which only works on the same filesystem and when the file is read from
+// the start. Uploads are not copied twice when the temp directory (TMPDIR)
+// is on the storage filesystem. The file is synced first, it usually was
+// just written. An empty name is returned when the file can not be linked.
+func linkFile(file *os.File, dir string) string {
+	if offset, err := file.Seek(0, io.SeekCurrent); err != nil || offset != 0 {
+		return ""
+	}
+	info, err := file.Stat()
+	if err != nil || !info.Mode().IsRegular() {
+		return ""
+	}
+	if err = file.Sync(); err != nil {
+		return ""
+	}
+
+	// reserve a name, the link takes its place
+	tmp, err := os.CreateTemp(dir, tempPrefix)
+	if err != nil {
+		return ""
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+	if err = os.Link(file.Name(), tmp.Name()); err != nil {
+		return ""
+	}
+	os.Chmod(tmp.Name(), 0o644)
+	return tmp.Name()
+}
+
+// tempPrefix marks files Put has not renamed yet, leftovers of a crash.
+const tempPrefix = ".put-"
+
+// syncDir makes a rename in dir durable.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	return d.Sync()
 }
 
 func (s *FilesystemStorage) Delete(ctx context.Context, p string) error {
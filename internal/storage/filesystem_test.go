@@ -1,8 +1,12 @@
 package storage_test
 
 import (
+	"bytes"
 	"context"
+	"errors"
+	"io"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/banjuer/kompanion/internal/storage"
@@ -51,3 +55,136 @@ func TestFilesystemStorage(t *testing.T) {
 		t.Errorf("Expected body %s, got %s", string(body), string(readBody))
 	}
 }
+
+func TestFilesystemStoragePutOpen(t *testing.T) {
+	tmpdir, err := os.MkdirTemp("", "")
+	if err != nil {
+		t.Fatalf("Error creating temp dir: %v", err)
+	}
+	defer os.RemoveAll(tmpdir)
+
+	st, err := storage.NewFilesystemStorage(tmpdir)
+	if err != nil {
+		t.Fatalf("Error creating filesystem storage: %v", err)
+	}
+	testPutOpen(t, st)
+}
+
+// testPutOpen streams a file in and out of st.
+func testPutOpen(t *testing.T, st storage.Storage) {
+	ctx := context.Background()
+	body := "Hello, World!"
+
+	err := st.Put(ctx, "books/test", strings.NewReader(body))
+	if err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+
+	file, err := st.Open(ctx, "books/test")
+	if err != nil {
+		t.Fatalf("Error opening file: %v", err)
+	}
+	defer file.Close()
+
+	var buf bytes.Buffer
+	if _, err = file.WriteTo(&buf); err != nil || buf.String() != body {
+		t.Errorf("Expected body %s, got %s (%v)", body, buf.String(), err)
+	}
+	size, err := file.Seek(0, io.SeekEnd)
+	if err != nil || size != int64(len(body)) {
+		t.Errorf("Expected size %d, got %d (%v)", len(body), size, err)
+	}
+
+	_, err = st.Open(ctx, "books/missing")
+	if !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestFilesystemStoragePutLinksFiles(t *testing.T) {
+	ctx := context.Background()
+	tmpdir := t.TempDir()
+	st, err := storage.NewFilesystemStorage(tmpdir + "/books")
+	if err != nil {
+		t.Fatalf("Error creating filesystem storage: %v", err)
+	}
+
+	upload, err := os.CreateTemp(tmpdir, "upload")
+	if err != nil {
+		t.Fatalf("Error creating temp file: %v", err)
+	}
+	defer upload.Close()
+	if _, err = upload.WriteString("Hello, World!"); err != nil {
+		t.Fatalf("Error writing to temp file: %v", err)
+	}
+	if _, err = upload.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file the caller does not hand over is copied, its mode kept
+	if err = upload.Chmod(0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err = st.Put(ctx, "own", upload); err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+	uploadInfo, _ := upload.Stat()
+	ownInfo, err := os.Stat(tmpdir + "/books/own")
+	if err != nil || os.SameFile(uploadInfo, ownInfo) || uploadInfo.Mode().Perm() != 0o600 {
+		t.Fatalf("Expected the file to be copied and left alone, got %v %v", uploadInfo.Mode(), err)
+	}
+
+	if _, err = upload.Seek(0, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err = st.Put(ctx, "linked", storage.TempFile{File: upload}); err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+	linkedInfo, err := os.Stat(tmpdir + "/books/linked")
+	if err != nil || !os.SameFile(uploadInfo, linkedInfo) {
+		t.Fatalf("Expected the upload to be hard linked, got %v", err)
+	}
+
+	// a temp file read part way is copied from its offset
+	if _, err = upload.Seek(7, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if err = st.Put(ctx, "copied", storage.TempFile{File: upload}); err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+	copied, err := os.ReadFile(tmpdir + "/books/copied")
+	if err != nil || string(copied) != "World!" {
+		t.Fatalf("Expected the rest of the file to be copied, got %q (%v)", copied, err)
+	}
+}
+
+type failingReader struct{}
+
+func (failingReader) Read([]byte) (int, error) {
+	return 0, errors.New("connection reset")
+}
+
+func TestFilesystemStoragePutKeepsOldFileOnError(t *testing.T) {
+	ctx := context.Background()
+	tmpdir := t.TempDir()
+	st, err := storage.NewFilesystemStorage(tmpdir)
+	if err != nil {
+		t.Fatalf("Error creating filesystem storage: %v", err)
+	}
+
+	if err = st.Put(ctx, "books/test", strings.NewReader("complete")); err != nil {
+		t.Fatalf("Error putting file: %v", err)
+	}
+	if err = st.Put(ctx, "books/test", io.MultiReader(strings.NewReader("trunc"), failingReader{})); err == nil {
+		t.Fatal("Expected the failed read to be returned")
+	}
+
+	body, err := os.ReadFile(tmpdir + "/books/test")
+	if err != nil || string(body) != "complete" {
+		t.Errorf("Expected the old file to be kept, got %q (%v)", body, err)
+	}
+	entries, err := os.ReadDir(tmpdir + "/books")
+	if err != nil || len(entries) != 1 {
+		t.Errorf("Expected no temp files left, got %v (%v)", entries, err)
+	}
+}
@@ -2,6 +2,7 @@ package storage
 
 import (
 	"context"
+	"io"
 	"os"
 )
 
@@ -9,4 +10,16 @@ type Storage interface {
 	Write(ctx context.Context, source string, filepath string) error
 	Read(ctx context.Context, filepath string) (*os.File, error)
 	Delete(ctx context.Context, filepath string) error
+
+	// Put stores everything read from r, without a temp file in between.
+	Put(ctx context.Context, filepath string, r io.Reader) error
+	// Open streams a stored file, the caller closes it.
+	Open(ctx context.Context, filepath string) (File, error)
+}
+
+// File is a stored file opened for reading. Seeking serves range requests,
+// WriteTo lets io.Copy hand the content over without an extra buffer.
+type File interface {
+	io.ReadSeekCloser
+	io.WriterTo
 }
@@ -1,8 +1,10 @@
 package storage
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"io"
 	"os"
 	"sync"
 )
@@ -29,21 +31,34 @@ func (s *MemoryStorage) Read(ctx context.Context, filepath string) (*os.File, er
 	if !ok {
 		return nil, ErrNotFound
 	}
-	// make by temp files
-	tempFile, err := os.CreateTemp("", "")
+	return tempFile(data)
+}
+
+func (s *MemoryStorage) Write(ctx context.Context, source string, filepath string) error {
+	data, err := os.ReadFile(source)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer tempFile.Close()
-	_, err = tempFile.Write(data)
-	if err != nil {
-		return nil, err
+
+	s.mu.Lock()
+	s.data[filepath] = data
+	s.mu.Unlock()
+	return nil
+}
+
+func (s *MemoryStorage) Open(ctx context.Context, filepath string) (File, error) {
+	s.mu.RLock()
+	data, ok := s.data[filepath]
+	s.mu.RUnlock()
+
+	if !ok {
+		return nil, ErrNotFound
 	}
-	return tempFile, nil
+	return memoryFile{bytes.NewReader(data)}, nil
 }
 
-func (s *MemoryStorage) Write(ctx context.Context, source string, filepath string) error {
-	data, err := os.ReadFile(source)
+func (s *MemoryStorage) Put(ctx context.Context, filepath string, r io.Reader) error {
+	data, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
@@ -60,3 +75,29 @@ func (s *MemoryStorage) Delete(ctx context.Context, filepath string) error {
 	s.mu.Unlock()
 	return nil
 }
+
+// memoryFile serves content that is already in memory.
+type memoryFile struct {
+	*bytes.Reader
+}
+
+func (memoryFile) Close() error {
+	return nil
+}
+
+// tempFile copies data to a temp file for callers that need a path, it is
+// returned open at the start.
+func tempFile(data []byte) (*os.File, error) {
+	file, err := os.CreateTemp("", "")
+	if err != nil {
+		return nil, err
+	}
+	if _, err = file.Write(data); err == nil {
+		_, err = file.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
@@ -41,3 +41,7 @@ func TestMemoryStorage(t *testing.T) {
 		t.Errorf("Expected body %s, got %s", string(body), string(readBody))
 	}
 }
+
+func TestMemoryStoragePutOpen(t *testing.T) {
+	testPutOpen(t, storage.NewMemoryStorage())
+}
@@ -1,10 +1,15 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 
+	"github.com/jackc/pgx/v5"
+
 	"github.com/banjuer/kompanion/pkg/postgres"
 	"github.com/banjuer/kompanion/pkg/utils"
 )
@@ -22,12 +27,22 @@ func (ps *PostgresStorage) Write(
 	source string,
 	filepath string,
 ) error {
-	data, err := os.ReadFile(source)
+	file, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	return ps.Put(ctx, filepath, file)
+}
+
+func (ps *PostgresStorage) Put(ctx context.Context, filepath string, r io.Reader) error {
+	data, err := io.ReadAll(r)
 	if err != nil {
 		return err
 	}
 
-	md5Hash, err := utils.PartialMD5(source)
+	md5Hash, err := utils.PartialMD5Reader(bytes.NewReader(data))
 	if err != nil {
 		return err
 	}
@@ -43,13 +58,29 @@ func (ps *PostgresStorage) Write(
 
 	_, err = ps.Pool.Exec(ctx, sql, args...)
 	if err != nil {
-		return fmt.Errorf("PostgresStorage - Write - r.Pool.Exec: %w", err)
+		return fmt.Errorf("PostgresStorage - Put - r.Pool.Exec: %w", err)
 	}
 
 	return nil
 }
 
 func (ps *PostgresStorage) Read(ctx context.Context, filepath string) (*os.File, error) {
+	data, err := ps.read(ctx, filepath)
+	if err != nil {
+		return nil, fmt.Errorf("PostgresStorage - Read - %w", err)
+	}
+	return tempFile(data)
+}
+
+func (ps *PostgresStorage) Open(ctx context.Context, filepath string) (File, error) {
+	data, err := ps.read(ctx, filepath)
+	if err != nil {
+		return nil, fmt.Errorf("PostgresStorage - Open - %w", err)
+	}
+	return memoryFile{bytes.NewReader(data)}, nil
+}
+
+func (ps *PostgresStorage) read(ctx context.Context, filepath string) ([]byte, error) {
 	sql := `
 		SELECT file_data
 		FROM storage_blob
@@ -59,21 +90,13 @@ func (ps *PostgresStorage) Read(ctx context.Context, filepath string) (*os.File,
 
 	var data []byte
 	err := ps.Pool.QueryRow(ctx, sql, args...).Scan(&data)
-	if err != nil {
-		return nil, fmt.Errorf("PostgresStorage - Read - r.Pool.QueryRow: %w", err)
-	}
-
-	// make by temp files
-	tempFile, err := os.CreateTemp("", "")
-	if err != nil {
-		return nil, err
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, ErrNotFound
 	}
-	defer tempFile.Close()
-	_, err = tempFile.Write(data)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("r.Pool.QueryRow: %w", err)
 	}
-	return tempFile, nil
+	return data, nil
 }
 
 func (ps *PostgresStorage) Delete(ctx context.Context, filepath string) error {
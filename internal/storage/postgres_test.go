@@ -77,7 +77,7 @@ func TestPostgresStorage(t *testing.T) {
 			WillReturnError(pgx.ErrNoRows)
 
 		_, err = store.Read(context.Background(), "non-existent.txt")
-		assert.Error(t, err)
+		assert.ErrorIs(t, err, storage.ErrNotFound)
 
 		err = mock.ExpectationsWereMet()
 		require.NoError(t, err)
@@ -2,10 +2,28 @@ package storage
 
 import (
 	"errors"
+	"sync"
 
 	"github.com/banjuer/kompanion/pkg/postgres"
 )
 
+// Factory opens a storage of a kind registered with Register, dir is the
+// configured KOMPANION_BSTORAGE_PATH.
+type Factory func(dir string) (Storage, error)
+
+var (
+	factoriesMu sync.RWMutex
+	factories   = make(map[string]Factory)
+)
+
+// Register adds a storage kind, e.g. from the init function of a package
+// built into the server. The built-in kinds can not be replaced.
+func Register(storageType string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[storageType] = factory
+}
+
 func NewStorage(storage_type, dir string, pg *postgres.Postgres) (Storage, error) {
 	switch storage_type {
 	case "memory":
@@ -17,5 +35,11 @@ func NewStorage(storage_type, dir string, pg *postgres.Postgres) (Storage, error
 		st := NewPostgresStorage(pg)
 		return st, nil
 	}
+	factoriesMu.RLock()
+	factory, ok := factories[storage_type]
+	factoriesMu.RUnlock()
+	if ok {
+		return factory(dir)
+	}
 	return nil, errors.New("unknown storage type")
 }
@@ -0,0 +1,64 @@
+package storage
+
+import (
+	"context"
+	"io"
+	"os"
+
+	"github.com/banjuer/kompanion/pkg/tracing"
+)
+
+// Traced adds a span for each operation on s in traced requests.
+func Traced(s Storage) Storage {
+	return tracedStorage{s}
+}
+
+type tracedStorage struct {
+	Storage
+}
+
+func startSpan(ctx context.Context, operation, filepath string) (context.Context, *tracing.Span) {
+	ctx, span := tracing.Start(ctx, "storage."+operation)
+	span.SetAttribute("storage.path", filepath)
+	return ctx, span
+}
+
+func (s tracedStorage) Write(ctx context.Context, source string, filepath string) error {
+	ctx, span := startSpan(ctx, "Write", filepath)
+	defer span.End()
+	err := s.Storage.Write(ctx, source, filepath)
+	span.RecordError(err)
+	return err
+}
+
+func (s tracedStorage) Read(ctx context.Context, filepath string) (*os.File, error) {
+	ctx, span := startSpan(ctx, "Read", filepath)
+	defer span.End()
+	file, err := s.Storage.Read(ctx, filepath)
+	span.RecordError(err)
+	return file, err
+}
+
+func (s tracedStorage) Delete(ctx context.Context, filepath string) error {
+	ctx, span := startSpan(ctx, "Delete", filepath)
+	defer span.End()
+	err := s.Storage.Delete(ctx, filepath)
+	span.RecordError(err)
+	return err
+}
+
+func (s tracedStorage) Put(ctx context.Context, filepath string, r io.Reader) error {
+	ctx, span := startSpan(ctx, "Put", filepath)
+	defer span.End()
+	err := s.Storage.Put(ctx, filepath, r)
+	span.RecordError(err)
+	return err
+}
+
+func (s tracedStorage) Open(ctx context.Context, filepath string) (File, error) {
+	ctx, span := startSpan(ctx, "Open", filepath)
+	defer span.End()
+	file, err := s.Storage.Open(ctx, filepath)
+	span.RecordError(err)
+	return file, err
+}
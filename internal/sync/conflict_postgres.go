@@ -0,0 +1,121 @@
+package sync
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+// ConflictDatabaseRepo -.
+type ConflictDatabaseRepo struct {
+	*postgres.Postgres
+}
+
+// NewConflictDatabaseRepo -.
+func NewConflictDatabaseRepo(pg *postgres.Postgres) *ConflictDatabaseRepo {
+	return &ConflictDatabaseRepo{pg}
+}
+
+const conflictColumns = `id, koreader_partial_md5,
+	ahead_percentage, ahead_progress, ahead_koreader_device, ahead_koreader_device_id, ahead_auth_device_name, ahead_at,
+	behind_percentage, behind_progress, behind_koreader_device, behind_koreader_device_id, behind_auth_device_name, behind_at,
+	created_at`
+
+// StoreConflict replaces the conflict of the device behind on the document,
+// a device reading on behind keeps one conflict.
+func (r *ConflictDatabaseRepo) StoreConflict(ctx context.Context, c entity.ProgressConflict) error {
+	sql := `INSERT INTO sync_conflict
+		(koreader_partial_md5,
+		ahead_percentage, ahead_progress, ahead_koreader_device, ahead_koreader_device_id, ahead_auth_device_name, ahead_at,
+		behind_percentage, behind_progress, behind_koreader_device, behind_koreader_device_id, behind_auth_device_name, behind_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		ON CONFLICT (koreader_partial_md5, behind_auth_device_name) DO UPDATE
+		SET ahead_percentage = EXCLUDED.ahead_percentage,
+			ahead_progress = EXCLUDED.ahead_progress,
+			ahead_koreader_device = EXCLUDED.ahead_koreader_device,
+			ahead_koreader_device_id = EXCLUDED.ahead_koreader_device_id,
+			ahead_auth_device_name = EXCLUDED.ahead_auth_device_name,
+			ahead_at = EXCLUDED.ahead_at,
+			behind_percentage = EXCLUDED.behind_percentage,
+			behind_progress = EXCLUDED.behind_progress,
+			behind_koreader_device = EXCLUDED.behind_koreader_device,
+			behind_koreader_device_id = EXCLUDED.behind_koreader_device_id,
+			behind_at = EXCLUDED.behind_at`
+	a, b := c.Ahead, c.Behind
+	args := []interface{}{c.Document,
+		a.Percentage, a.Progress, a.Device, a.DeviceID, a.AuthDeviceName, time.Unix(a.Timestamp, 0),
+		b.Percentage, b.Progress, b.Device, b.DeviceID, b.AuthDeviceName, time.Unix(b.Timestamp, 0)}
+
+	if _, err := r.Pool.Exec(ctx, sql, args...); err != nil {
+		return fmt.Errorf("ConflictDatabaseRepo - StoreConflict - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+// ClearConflict deletes the conflict of the device on the document.
+func (r *ConflictDatabaseRepo) ClearConflict(ctx context.Context, document, device string) error {
+	sql := `DELETE FROM sync_conflict WHERE koreader_partial_md5 = $1 AND behind_auth_device_name = $2`
+	if _, err := r.Pool.Exec(ctx, sql, document, device); err != nil {
+		return fmt.Errorf("ConflictDatabaseRepo - ClearConflict - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func (r *ConflictDatabaseRepo) ListConflicts(ctx context.Context) ([]entity.ProgressConflict, error) {
+	sql := `SELECT ` + conflictColumns + ` FROM sync_conflict ORDER BY behind_at DESC`
+	rows, err := r.Pool.Query(ctx, sql)
+	if err != nil {
+		return nil, fmt.Errorf("ConflictDatabaseRepo - ListConflicts - r.Pool.Query: %w", err)
+	}
+	defer rows.Close()
+
+	var conflicts []entity.ProgressConflict
+	for rows.Next() {
+		c, err := scanConflict(rows)
+		if err != nil {
+			return nil, fmt.Errorf("ConflictDatabaseRepo - ListConflicts - rows.Scan: %w", err)
+		}
+		conflicts = append(conflicts, c)
+	}
+	return conflicts, rows.Err()
+}
+
+func (r *ConflictDatabaseRepo) GetConflict(ctx context.Context, id int64) (entity.ProgressConflict, error) {
+	sql := `SELECT ` + conflictColumns + ` FROM sync_conflict WHERE id = $1`
+	c, err := scanConflict(r.Pool.QueryRow(ctx, sql, id))
+	if errors.Is(err, pgx.ErrNoRows) {
+		return entity.ProgressConflict{}, entity.ErrProgressConflictNotFound
+	}
+	if err != nil {
+		return entity.ProgressConflict{}, fmt.Errorf("ConflictDatabaseRepo - GetConflict - row.Scan: %w", err)
+	}
+	return c, nil
+}
+
+func (r *ConflictDatabaseRepo) DeleteConflict(ctx context.Context, id int64) error {
+	if _, err := r.Pool.Exec(ctx, `DELETE FROM sync_conflict WHERE id = $1`, id); err != nil {
+		return fmt.Errorf("ConflictDatabaseRepo - DeleteConflict - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
+
+func scanConflict(row pgx.Row) (entity.ProgressConflict, error) {
+	var c entity.ProgressConflict
+	var aheadAt, behindAt time.Time
+	err := row.Scan(&c.ID, &c.Document,
+		&c.Ahead.Percentage, &c.Ahead.Progress, &c.Ahead.Device, &c.Ahead.DeviceID, &c.Ahead.AuthDeviceName, &aheadAt,
+		&c.Behind.Percentage, &c.Behind.Progress, &c.Behind.Device, &c.Behind.DeviceID, &c.Behind.AuthDeviceName, &behindAt,
+		&c.CreatedAt)
+	if err != nil {
+		return c, err
+	}
+	c.Ahead.Document, c.Ahead.Timestamp = c.Document, aheadAt.Unix()
+	c.Behind.Document, c.Behind.Timestamp = c.Document, behindAt.Unix()
+	return c, nil
+}
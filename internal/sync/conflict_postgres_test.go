@@ -0,0 +1,60 @@
+package sync_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/pashagolub/pgxmock/v4"
+
+	"github.com/banjuer/kompanion/internal/entity"
+	"github.com/banjuer/kompanion/internal/sync"
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+func TestConflictRepo(t *testing.T) {
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer mock.Close()
+	repo := sync.NewConflictDatabaseRepo(postgres.Mock(mock))
+	ctx := context.Background()
+
+	ahead := entity.Progress{Document: "doc", Percentage: 0.5, Progress: "/body/p[5]", Device: "Kobo", DeviceID: "k1", AuthDeviceName: "kobo", Timestamp: 100}
+	behind := entity.Progress{Document: "doc", Percentage: 0.1, Progress: "/body/p[1]", Device: "Kindle", DeviceID: "k2", AuthDeviceName: "kindle", Timestamp: 200}
+	mock.ExpectExec("INSERT INTO sync_conflict").
+		WithArgs("doc", 0.5, "/body/p[5]", "Kobo", "k1", "kobo", time.Unix(100, 0), 0.1, "/body/p[1]", "Kindle", "k2", "kindle", time.Unix(200, 0)).
+		WillReturnResult(pgxmock.NewResult("INSERT", 1))
+	if err = repo.StoreConflict(ctx, entity.ProgressConflict{Document: "doc", Ahead: ahead, Behind: behind}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	columns := []string{"id", "koreader_partial_md5",
+		"ahead_percentage", "ahead_progress", "ahead_koreader_device", "ahead_koreader_device_id", "ahead_auth_device_name", "ahead_at",
+		"behind_percentage", "behind_progress", "behind_koreader_device", "behind_koreader_device_id", "behind_auth_device_name", "behind_at",
+		"created_at"}
+	mock.ExpectQuery("SELECT id, koreader_partial_md5").WithArgs(int64(1)).
+		WillReturnRows(pgxmock.NewRows(columns).AddRow(int64(1), "doc",
+			0.5, "/body/p[5]", "Kobo", "k1", "kobo", time.Unix(100, 0),
+			0.1, "/body/p[1]", "Kindle", "k2", "kindle", time.Unix(200, 0),
+			time.Unix(200, 0)))
+	conflict, err := repo.GetConflict(ctx, 1)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if conflict.Ahead != ahead || conflict.Behind != behind {
+		t.Errorf("unexpected conflict %+v", conflict)
+	}
+
+	mock.ExpectQuery("SELECT id, koreader_partial_md5").WithArgs(int64(2)).WillReturnError(pgx.ErrNoRows)
+	if _, err = repo.GetConflict(ctx, 2); !errors.Is(err, entity.ErrProgressConflictNotFound) {
+		t.Errorf("expected conflict not found, got %v", err)
+	}
+
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
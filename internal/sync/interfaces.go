@@ -13,8 +13,25 @@ type ProgressRepo interface {
 	GetBookHistory(ctx context.Context, bookID string, limit int) ([]entity.Progress, error)
 }
 
+// ConflictRepo - keeps the conflicts of the keep-both strategy, one per
+// document and device behind.
+type ConflictRepo interface {
+	StoreConflict(ctx context.Context, c entity.ProgressConflict) error
+	ClearConflict(ctx context.Context, document, device string) error
+	ListConflicts(ctx context.Context) ([]entity.ProgressConflict, error)
+	GetConflict(ctx context.Context, id int64) (entity.ProgressConflict, error)
+	DeleteConflict(ctx context.Context, id int64) error
+}
+
+// ProgressHook - is told about progress synced from a device.
+type ProgressHook interface {
+	ProgressUpdated(ctx context.Context, doc entity.Progress) error
+}
+
 // Progress -.
 type Progress interface {
 	Sync(context.Context, entity.Progress) (entity.Progress, error)
 	Fetch(ctx context.Context, bookID string) (entity.Progress, error)
+	Conflicts(ctx context.Context) ([]entity.ProgressConflict, error)
+	ResolveConflict(ctx context.Context, id int64, keepBehind bool) (entity.Progress, error)
 }
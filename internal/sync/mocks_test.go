@@ -64,6 +64,138 @@ func (mr *MockProgressRepoMockRecorder) Store(ctx, t interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Store", reflect.TypeOf((*MockProgressRepo)(nil).Store), ctx, t)
 }
 
+// MockConflictRepo is a mock of ConflictRepo interface.
+type MockConflictRepo struct {
+	ctrl     *gomock.Controller
+	recorder *MockConflictRepoMockRecorder
+}
+
+// MockConflictRepoMockRecorder is the mock recorder for MockConflictRepo.
+type MockConflictRepoMockRecorder struct {
+	mock *MockConflictRepo
+}
+
+// NewMockConflictRepo creates a new mock instance.
+func NewMockConflictRepo(ctrl *gomock.Controller) *MockConflictRepo {
+	mock := &MockConflictRepo{ctrl: ctrl}
+	mock.recorder = &MockConflictRepoMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockConflictRepo) EXPECT() *MockConflictRepoMockRecorder {
+	return m.recorder
+}
+
+// ClearConflict mocks base method.
+func (m *MockConflictRepo) ClearConflict(ctx context.Context, document, device string) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ClearConflict", ctx, document, device)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ClearConflict indicates an expected call of ClearConflict.
+func (mr *MockConflictRepoMockRecorder) ClearConflict(ctx, document, device interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ClearConflict", reflect.TypeOf((*MockConflictRepo)(nil).ClearConflict), ctx, document, device)
+}
+
+// DeleteConflict mocks base method.
+func (m *MockConflictRepo) DeleteConflict(ctx context.Context, id int64) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "DeleteConflict", ctx, id)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// DeleteConflict indicates an expected call of DeleteConflict.
+func (mr *MockConflictRepoMockRecorder) DeleteConflict(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteConflict", reflect.TypeOf((*MockConflictRepo)(nil).DeleteConflict), ctx, id)
+}
+
+// GetConflict mocks base method.
+func (m *MockConflictRepo) GetConflict(ctx context.Context, id int64) (entity.ProgressConflict, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "GetConflict", ctx, id)
+	ret0, _ := ret[0].(entity.ProgressConflict)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetConflict indicates an expected call of GetConflict.
+func (mr *MockConflictRepoMockRecorder) GetConflict(ctx, id interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetConflict", reflect.TypeOf((*MockConflictRepo)(nil).GetConflict), ctx, id)
+}
+
+// ListConflicts mocks base method.
+func (m *MockConflictRepo) ListConflicts(ctx context.Context) ([]entity.ProgressConflict, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListConflicts", ctx)
+	ret0, _ := ret[0].([]entity.ProgressConflict)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ListConflicts indicates an expected call of ListConflicts.
+func (mr *MockConflictRepoMockRecorder) ListConflicts(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListConflicts", reflect.TypeOf((*MockConflictRepo)(nil).ListConflicts), ctx)
+}
+
+// StoreConflict mocks base method.
+func (m *MockConflictRepo) StoreConflict(ctx context.Context, c entity.ProgressConflict) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "StoreConflict", ctx, c)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// StoreConflict indicates an expected call of StoreConflict.
+func (mr *MockConflictRepoMockRecorder) StoreConflict(ctx, c interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "StoreConflict", reflect.TypeOf((*MockConflictRepo)(nil).StoreConflict), ctx, c)
+}
+
+// MockProgressHook is a mock of ProgressHook interface.
+type MockProgressHook struct {
+	ctrl     *gomock.Controller
+	recorder *MockProgressHookMockRecorder
+}
+
+// MockProgressHookMockRecorder is the mock recorder for MockProgressHook.
+type MockProgressHookMockRecorder struct {
+	mock *MockProgressHook
+}
+
+// NewMockProgressHook creates a new mock instance.
+func NewMockProgressHook(ctrl *gomock.Controller) *MockProgressHook {
+	mock := &MockProgressHook{ctrl: ctrl}
+	mock.recorder = &MockProgressHookMockRecorder{mock}
+	return mock
+}
+
+// EXPECT returns an object that allows the caller to indicate expected use.
+func (m *MockProgressHook) EXPECT() *MockProgressHookMockRecorder {
+	return m.recorder
+}
+
+// ProgressUpdated mocks base method.
+func (m *MockProgressHook) ProgressUpdated(ctx context.Context, doc entity.Progress) error {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ProgressUpdated", ctx, doc)
+	ret0, _ := ret[0].(error)
+	return ret0
+}
+
+// ProgressUpdated indicates an expected call of ProgressUpdated.
+func (mr *MockProgressHookMockRecorder) ProgressUpdated(ctx, doc interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ProgressUpdated", reflect.TypeOf((*MockProgressHook)(nil).ProgressUpdated), ctx, doc)
+}
+
 // MockProgress is a mock of Progress interface.
 type MockProgress struct {
 	ctrl     *gomock.Controller
@@ -87,6 +219,21 @@ func (m *MockProgress) EXPECT() *MockProgressMockRecorder {
 	return m.recorder
 }
 
+// Conflicts mocks base method.
+func (m *MockProgress) Conflicts(ctx context.Context) ([]entity.ProgressConflict, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "Conflicts", ctx)
+	ret0, _ := ret[0].([]entity.ProgressConflict)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// Conflicts indicates an expected call of Conflicts.
+func (mr *MockProgressMockRecorder) Conflicts(ctx interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Conflicts", reflect.TypeOf((*MockProgress)(nil).Conflicts), ctx)
+}
+
 // Fetch mocks base method.
 func (m *MockProgress) Fetch(ctx context.Context, bookID string) (entity.Progress, error) {
 	m.ctrl.T.Helper()
@@ -102,6 +249,21 @@ func (mr *MockProgressMockRecorder) Fetch(ctx, bookID interface{}) *gomock.Call
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "Fetch", reflect.TypeOf((*MockProgress)(nil).Fetch), ctx, bookID)
 }
 
+// ResolveConflict mocks base method.
+func (m *MockProgress) ResolveConflict(ctx context.Context, id int64, keepBehind bool) (entity.Progress, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ResolveConflict", ctx, id, keepBehind)
+	ret0, _ := ret[0].(entity.Progress)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// ResolveConflict indicates an expected call of ResolveConflict.
+func (mr *MockProgressMockRecorder) ResolveConflict(ctx, id, keepBehind interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ResolveConflict", reflect.TypeOf((*MockProgress)(nil).ResolveConflict), ctx, id, keepBehind)
+}
+
 // Sync mocks base method.
 func (m *MockProgress) Sync(arg0 context.Context, arg1 entity.Progress) (entity.Progress, error) {
 	m.ctrl.T.Helper()
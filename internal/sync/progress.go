@@ -8,30 +8,116 @@ import (
 	"github.com/banjuer/kompanion/internal/entity"
 )
 
+// Strategies for a device pushing a position behind the latest one of
+// another device, like a device that missed the reading done on another.
+const (
+	// ConflictLatest stores the push unless it is older than the latest
+	// position, the newest timestamp wins as in KOSync. An older push of
+	// another device is recorded as a conflict.
+	ConflictLatest = "latest"
+	// ConflictFurthest ignores the push, devices keep the furthest position.
+	ConflictFurthest = "furthest"
+	// ConflictKeepBoth records a conflict for the user to resolve, devices
+	// keep the furthest position meanwhile.
+	ConflictKeepBoth = "keep-both"
+)
+
 // ProgressSyncUseCase -.
 type ProgressSyncUseCase struct {
-	repo ProgressRepo
+	repo      ProgressRepo
+	hooks     []ProgressHook
+	strategy  string
+	conflicts ConflictRepo
 }
 
 // NewProgressSync -.
 func NewProgressSync(r ProgressRepo) *ProgressSyncUseCase {
 	return &ProgressSyncUseCase{
-		repo: r,
+		repo:     r,
+		strategy: ConflictLatest,
 	}
 }
 
+// AddHook tells hook about every progress synced from now on.
+func (uc *ProgressSyncUseCase) AddHook(hook ProgressHook) {
+	uc.hooks = append(uc.hooks, hook)
+}
+
+// SetConflictStrategy - strategy is one of the Conflict constants, the
+// conflicts of keep-both are kept in conflicts.
+func (uc *ProgressSyncUseCase) SetConflictStrategy(strategy string, conflicts ConflictRepo) {
+	uc.strategy = strategy
+	uc.conflicts = conflicts
+}
+
 func (uc *ProgressSyncUseCase) Sync(ctx context.Context, doc entity.Progress) (entity.Progress, error) {
 	if doc.Timestamp == 0 {
 		doc.Timestamp = time.Now().Unix()
 	}
-	err := uc.repo.Store(ctx, doc)
+	stored, err := uc.resolve(ctx, doc)
+	if err != nil {
+		return doc, fmt.Errorf("ProgressSyncUseCase - Sync - %w", err)
+	}
+	if !stored {
+		// the device gets the position kept on its next pull
+		return doc, nil
+	}
+	err = uc.repo.Store(ctx, doc)
 	if err != nil {
 		return doc, fmt.Errorf("ProgressSyncUseCase - Sync - s.repo.Sync: %w", err)
 	}
+	uc.notify(ctx, doc)
 
 	return doc, nil
 }
 
+// resolve applies the conflict strategy to a push, false when it is not
+// to be stored. Latest keeps the newer timestamp, the other strategies the
+// further position of two devices.
+func (uc *ProgressSyncUseCase) resolve(ctx context.Context, doc entity.Progress) (bool, error) {
+	history, err := uc.repo.GetBookHistory(ctx, doc.Document, 1)
+	if err != nil {
+		return false, fmt.Errorf("s.repo.GetBookHistory: %w", err)
+	}
+	if len(history) == 0 {
+		return true, nil
+	}
+	latest := history[0]
+	otherDevice := latest.AuthDeviceName != doc.AuthDeviceName
+	behind := otherDevice && doc.Percentage < latest.Percentage
+	if uc.strategy == ConflictLatest {
+		behind = doc.Timestamp < latest.Timestamp
+	}
+	if uc.strategy == ConflictFurthest || uc.conflicts == nil {
+		return !behind, nil
+	}
+
+	if behind {
+		// an older push of the device itself is just late
+		if !otherDevice {
+			return false, nil
+		}
+		err = uc.conflicts.StoreConflict(ctx, entity.ProgressConflict{Document: doc.Document, Ahead: latest, Behind: doc})
+		if err != nil {
+			return false, fmt.Errorf("s.conflicts.StoreConflict: %w", err)
+		}
+		return false, nil
+	}
+	// the device caught up, its conflict is over
+	if err = uc.conflicts.ClearConflict(ctx, doc.Document, doc.AuthDeviceName); err != nil {
+		return false, fmt.Errorf("s.conflicts.ClearConflict: %w", err)
+	}
+	return true, nil
+}
+
+// notify runs the hooks, the progress is stored and a failing hook does
+// not fail the sync.
+func (uc *ProgressSyncUseCase) notify(ctx context.Context, doc entity.Progress) {
+	for _, hook := range uc.hooks {
+		_ = hook.ProgressUpdated(ctx, doc)
+	}
+}
+
 func (uc *ProgressSyncUseCase) Fetch(ctx context.Context, bookID string) (entity.Progress, error) {
 	doc, err := uc.repo.GetBookHistory(ctx, bookID, 1)
 	if err != nil {
@@ -52,3 +138,41 @@ func (uc *ProgressSyncUseCase) Fetch(ctx context.Context, bookID string) (entity
 
 	return last, nil
 }
+
+// Conflicts lists the conflicts of the keep-both strategy, newest first.
+func (uc *ProgressSyncUseCase) Conflicts(ctx context.Context) ([]entity.ProgressConflict, error) {
+	if uc.conflicts == nil {
+		return nil, nil
+	}
+	conflicts, err := uc.conflicts.ListConflicts(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("ProgressSyncUseCase - Conflicts - s.conflicts.ListConflicts: %w", err)
+	}
+	return conflicts, nil
+}
+
+// ResolveConflict keeps one position of a conflict. The position behind is
+// stored again as the newest, devices get it on their next pull.
+func (uc *ProgressSyncUseCase) ResolveConflict(ctx context.Context, id int64, keepBehind bool) (entity.Progress, error) {
+	if uc.conflicts == nil {
+		return entity.Progress{}, fmt.Errorf("ProgressSyncUseCase - ResolveConflict - %w", entity.ErrProgressConflictNotFound)
+	}
+	conflict, err := uc.conflicts.GetConflict(ctx, id)
+	if err != nil {
+		return entity.Progress{}, fmt.Errorf("ProgressSyncUseCase - ResolveConflict - s.conflicts.GetConflict: %w", err)
+	}
+
+	kept := conflict.Ahead
+	if keepBehind {
+		kept = conflict.Behind
+		kept.Timestamp = time.Now().Unix()
+		if err = uc.repo.Store(ctx, kept); err != nil {
+			return entity.Progress{}, fmt.Errorf("ProgressSyncUseCase - ResolveConflict - s.repo.Store: %w", err)
+		}
+		uc.notify(ctx, kept)
+	}
+	if err = uc.conflicts.DeleteConflict(ctx, id); err != nil {
+		return entity.Progress{}, fmt.Errorf("ProgressSyncUseCase - ResolveConflict - s.conflicts.DeleteConflict: %w", err)
+	}
+	return kept, nil
+}
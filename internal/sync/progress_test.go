@@ -91,6 +91,7 @@ func TestProgressSync(t *testing.T) {
 		{
 			name: "empty result",
 			mock: func() {
+				repo.EXPECT().GetBookHistory(context.Background(), "bookID", 1).Return(nil, nil)
 				repo.EXPECT().Store(context.Background(), progressDoc).Return(nil)
 			},
 			res: nil,
@@ -99,6 +100,7 @@ func TestProgressSync(t *testing.T) {
 		{
 			name: "result with error",
 			mock: func() {
+				repo.EXPECT().GetBookHistory(context.Background(), "bookID", 1).Return(nil, nil)
 				repo.EXPECT().Store(context.Background(), progressDoc).Return(errInternalServErr)
 			},
 			res: nil,
@@ -121,6 +123,101 @@ func TestProgressSync(t *testing.T) {
 	}
 }
 
+func TestProgressSyncRunsHooks(t *testing.T) {
+	t.Parallel()
+
+	mockCtl := gomock.NewController(t)
+	repo := NewMockProgressRepo(mockCtl)
+	hook := NewMockProgressHook(mockCtl)
+	progressSync := sync.NewProgressSync(repo)
+	progressSync.AddHook(hook)
+
+	progressDoc := entity.Progress{Document: "bookID", Timestamp: 1}
+	errInternalServErr := errors.New("internal server error")
+
+	// a failing hook does not fail the sync
+	repo.EXPECT().GetBookHistory(context.Background(), "bookID", 1).Return(nil, nil).Times(2)
+	repo.EXPECT().Store(context.Background(), progressDoc).Return(nil)
+	hook.EXPECT().ProgressUpdated(context.Background(), progressDoc).Return(errInternalServErr)
+	_, err := progressSync.Sync(context.Background(), progressDoc)
+	require.NoError(t, err)
+
+	// progress that is not stored is not announced
+	repo.EXPECT().Store(context.Background(), progressDoc).Return(errInternalServErr)
+	_, err = progressSync.Sync(context.Background(), progressDoc)
+	require.ErrorIs(t, err, errInternalServErr)
+}
+
+func TestProgressSyncConflicts(t *testing.T) {
+	t.Parallel()
+
+	ctx := context.Background()
+	mockCtl := gomock.NewController(t)
+	repo := NewMockProgressRepo(mockCtl)
+	conflicts := NewMockConflictRepo(mockCtl)
+	progressSync := sync.NewProgressSync(repo)
+
+	ahead := entity.Progress{Document: "bookID", Percentage: 0.5, AuthDeviceName: "kobo", Timestamp: 1}
+	behind := entity.Progress{Document: "bookID", Percentage: 0.1, AuthDeviceName: "kindle", Timestamp: 2}
+	onward := entity.Progress{Document: "bookID", Percentage: 0.6, AuthDeviceName: "kindle", Timestamp: 3}
+
+	// latest stores a push behind that is newer, and records an older one
+	// instead of letting it replace newer progress
+	progressSync.SetConflictStrategy(sync.ConflictLatest, conflicts)
+	repo.EXPECT().GetBookHistory(ctx, "bookID", 1).Return([]entity.Progress{ahead}, nil)
+	conflicts.EXPECT().ClearConflict(ctx, "bookID", "kindle").Return(nil)
+	repo.EXPECT().Store(ctx, behind).Return(nil)
+	_, err := progressSync.Sync(ctx, behind)
+	require.NoError(t, err)
+	repo.EXPECT().GetBookHistory(ctx, "bookID", 1).Return([]entity.Progress{onward}, nil)
+	conflicts.EXPECT().StoreConflict(ctx, entity.ProgressConflict{Document: "bookID", Ahead: onward, Behind: ahead}).Return(nil)
+	_, err = progressSync.Sync(ctx, ahead)
+	require.NoError(t, err)
+	late := behind
+	late.Timestamp = 1
+	repo.EXPECT().GetBookHistory(ctx, "bookID", 1).Return([]entity.Progress{onward}, nil)
+	_, err = progressSync.Sync(ctx, late)
+	require.NoError(t, err)
+
+	// furthest ignores the push behind and stores the one ahead
+	progressSync.SetConflictStrategy(sync.ConflictFurthest, conflicts)
+	repo.EXPECT().GetBookHistory(ctx, "bookID", 1).Return([]entity.Progress{ahead}, nil)
+	_, err = progressSync.Sync(ctx, behind)
+	require.NoError(t, err)
+	repo.EXPECT().GetBookHistory(ctx, "bookID", 1).Return([]entity.Progress{ahead}, nil)
+	repo.EXPECT().Store(ctx, onward).Return(nil)
+	_, err = progressSync.Sync(ctx, onward)
+	require.NoError(t, err)
+
+	// keep-both records the push behind until the device catches up
+	progressSync.SetConflictStrategy(sync.ConflictKeepBoth, conflicts)
+	repo.EXPECT().GetBookHistory(ctx, "bookID", 1).Return([]entity.Progress{ahead}, nil)
+	conflicts.EXPECT().StoreConflict(ctx, entity.ProgressConflict{Document: "bookID", Ahead: ahead, Behind: behind}).Return(nil)
+	_, err = progressSync.Sync(ctx, behind)
+	require.NoError(t, err)
+	repo.EXPECT().GetBookHistory(ctx, "bookID", 1).Return([]entity.Progress{ahead}, nil)
+	conflicts.EXPECT().ClearConflict(ctx, "bookID", "kindle").Return(nil)
+	repo.EXPECT().Store(ctx, onward).Return(nil)
+	_, err = progressSync.Sync(ctx, onward)
+	require.NoError(t, err)
+
+	// keeping the position behind stores it again as the newest
+	conflicts.EXPECT().GetConflict(ctx, int64(7)).Return(entity.ProgressConflict{ID: 7, Document: "bookID", Ahead: ahead, Behind: behind}, nil)
+	repo.EXPECT().Store(ctx, gomock.Any()).DoAndReturn(func(_ context.Context, doc entity.Progress) error {
+		require.Equal(t, "kindle", doc.AuthDeviceName)
+		require.Greater(t, doc.Timestamp, ahead.Timestamp)
+		return nil
+	})
+	conflicts.EXPECT().DeleteConflict(ctx, int64(7)).Return(nil)
+	kept, err := progressSync.ResolveConflict(ctx, 7, true)
+	require.NoError(t, err)
+	require.Equal(t, 0.1, kept.Percentage)
+
+	conflicts.EXPECT().GetConflict(ctx, int64(8)).Return(entity.ProgressConflict{}, entity.ErrProgressConflictNotFound)
+	_, err = progressSync.ResolveConflict(ctx, 8, false)
+	require.ErrorIs(t, err, entity.ErrProgressConflictNotFound)
+}
+
 func mockedProgress(t *testing.T) (*sync.ProgressSyncUseCase, *MockProgressRepo) {
 	t.Helper()
 
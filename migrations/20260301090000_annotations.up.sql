@@ -0,0 +1,20 @@
+CREATE TABLE annotation_entry (
+    id BIGSERIAL PRIMARY KEY,
+    koreader_partial_md5 TEXT NOT NULL,
+    kind TEXT NOT NULL DEFAULT 'highlight',
+    page INTEGER NOT NULL DEFAULT 0,
+    position TEXT NOT NULL DEFAULT '',
+    chapter TEXT,
+    text TEXT NOT NULL DEFAULT '',
+    note TEXT,
+    color TEXT,
+    auth_device_name TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (koreader_partial_md5, kind, page, position)
+);
+CREATE INDEX annotation_entry_koreader_partial_md5 ON annotation_entry(koreader_partial_md5);
+
+COMMENT ON TABLE annotation_entry IS 'Highlights, notes and bookmarks exported from KOReader';
+COMMENT ON COLUMN annotation_entry.position IS 'KOReader xpointer (pos0) for reflowable documents, empty for paged ones';
+COMMENT ON COLUMN annotation_entry.auth_device_name IS 'Device name from KOmpanion that pushed the annotation';
@@ -0,0 +1,7 @@
+CREATE TABLE settings_entry (
+    key TEXT PRIMARY KEY,
+    value TEXT NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+COMMENT ON TABLE settings_entry IS 'Instance-wide settings editable at runtime, keys are namespaced like branding.instance_name';
@@ -0,0 +1,20 @@
+CREATE TABLE backup_run (
+    id TEXT PRIMARY KEY,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ,
+    status TEXT NOT NULL,
+    error TEXT NOT NULL DEFAULT '',
+    database_path TEXT NOT NULL DEFAULT '',
+    manifest_path TEXT NOT NULL DEFAULT '',
+    size_bytes BIGINT NOT NULL DEFAULT 0,
+    book_count INTEGER NOT NULL DEFAULT 0,
+    is_uploaded BOOLEAN NOT NULL DEFAULT FALSE,
+    is_pruned BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX backup_run_started_at_idx ON backup_run (started_at DESC);
+
+COMMENT ON TABLE backup_run IS 'Database dump and storage manifest backups, rotated by retention';
+COMMENT ON COLUMN backup_run.status IS 'running, success or failed';
+COMMENT ON COLUMN backup_run.is_uploaded IS 'Copied to the secondary backup storage';
+COMMENT ON COLUMN backup_run.is_pruned IS 'Files removed by retention, the row is kept as history';
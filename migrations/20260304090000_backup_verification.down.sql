@@ -0,0 +1,3 @@
+ALTER TABLE backup_run DROP COLUMN IF EXISTS verify_error;
+ALTER TABLE backup_run DROP COLUMN IF EXISTS is_restorable;
+ALTER TABLE backup_run DROP COLUMN IF EXISTS verified_at;
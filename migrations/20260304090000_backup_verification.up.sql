@@ -0,0 +1,6 @@
+ALTER TABLE backup_run ADD COLUMN verified_at TIMESTAMPTZ;
+ALTER TABLE backup_run ADD COLUMN is_restorable BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE backup_run ADD COLUMN verify_error TEXT NOT NULL DEFAULT '';
+
+COMMENT ON COLUMN backup_run.verified_at IS 'Last restore test into a scratch schema, NULL when never verified';
+COMMENT ON COLUMN backup_run.is_restorable IS 'Result of the last restore test';
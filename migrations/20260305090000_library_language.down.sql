@@ -0,0 +1 @@
+DROP INDEX IF EXISTS library_book_language_idx;
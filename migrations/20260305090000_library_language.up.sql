@@ -0,0 +1 @@
+CREATE INDEX IF NOT EXISTS library_book_language_idx ON library_book (language);
@@ -0,0 +1 @@
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS file_size BIGINT;
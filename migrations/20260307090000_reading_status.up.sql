@@ -0,0 +1,12 @@
+CREATE TABLE reading_status (
+    username TEXT NOT NULL,
+    book_id UUID NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    status TEXT NOT NULL CHECK (status IN ('to_read', 'reading', 'finished')),
+    finished_at TIMESTAMPTZ,
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (username, book_id)
+);
+CREATE INDEX reading_status_username_status ON reading_status(username, status);
+
+COMMENT ON TABLE reading_status IS 'To-read, reading and finished shelves of each account';
+COMMENT ON COLUMN reading_status.username IS 'auth_user username, not a foreign key because users can be kept in memory';
@@ -0,0 +1,3 @@
+ALTER TABLE library_book DROP COLUMN IF EXISTS rating_count;
+ALTER TABLE library_book DROP COLUMN IF EXISTS rating_avg;
+DROP TABLE IF EXISTS book_review;
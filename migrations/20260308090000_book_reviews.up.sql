@@ -0,0 +1,16 @@
+CREATE TABLE book_review (
+    username TEXT NOT NULL,
+    book_id UUID NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    rating SMALLINT CHECK (rating BETWEEN 1 AND 5),
+    review TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (username, book_id)
+);
+CREATE INDEX book_review_book_id ON book_review(book_id);
+
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS rating_avg NUMERIC(3,2);
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS rating_count INTEGER NOT NULL DEFAULT 0;
+
+COMMENT ON TABLE book_review IS 'Star ratings and reviews of each account';
+COMMENT ON COLUMN library_book.rating_avg IS 'Average of book_review.rating, kept in sync by the application for sorting';
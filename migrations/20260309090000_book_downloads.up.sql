@@ -0,0 +1,14 @@
+CREATE TABLE book_download (
+    id BIGSERIAL PRIMARY KEY,
+    book_id UUID NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    username TEXT NOT NULL DEFAULT '',
+    device_name TEXT NOT NULL DEFAULT '',
+    client TEXT NOT NULL,
+    downloaded_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX book_download_username ON book_download(username, downloaded_at DESC);
+CREATE INDEX book_download_book_id ON book_download(book_id);
+
+COMMENT ON TABLE book_download IS 'Book file downloads through the web, OPDS and WebDAV';
+COMMENT ON COLUMN book_download.username IS 'Account that downloaded, empty when a device did';
+COMMENT ON COLUMN book_download.device_name IS 'auth_device name for OPDS and WebDAV downloads with device credentials';
@@ -0,0 +1,2 @@
+ALTER TABLE auth_user DROP COLUMN IF EXISTS merged_at;
+ALTER TABLE auth_user DROP COLUMN IF EXISTS merged_into;
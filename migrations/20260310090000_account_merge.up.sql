@@ -0,0 +1,4 @@
+ALTER TABLE auth_user ADD COLUMN IF NOT EXISTS merged_into TEXT;
+ALTER TABLE auth_user ADD COLUMN IF NOT EXISTS merged_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN auth_user.merged_into IS 'username the account was merged into, merged accounts can not log in';
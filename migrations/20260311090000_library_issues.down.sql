@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS library_check;
+DROP TABLE IF EXISTS library_issue;
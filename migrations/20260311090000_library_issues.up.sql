@@ -0,0 +1,21 @@
+CREATE TABLE library_issue (
+    book_id UUID NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('missing_file', 'unreadable_file', 'hash_mismatch', 'missing_cover')),
+    detail TEXT NOT NULL DEFAULT '',
+    detected_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    checked_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (book_id, kind)
+);
+
+CREATE TABLE library_check (
+    id BIGSERIAL PRIMARY KEY,
+    started_at TIMESTAMPTZ NOT NULL,
+    finished_at TIMESTAMPTZ NOT NULL,
+    book_count INTEGER NOT NULL,
+    issue_count INTEGER NOT NULL
+);
+
+COMMENT ON TABLE library_issue IS 'Problems found by the last integrity check of the book files';
+COMMENT ON COLUMN library_issue.detected_at IS 'First check that found the problem';
+COMMENT ON COLUMN library_issue.checked_at IS 'Last check that still found it, older rows are resolved';
+COMMENT ON TABLE library_check IS 'Integrity check runs over the whole library';
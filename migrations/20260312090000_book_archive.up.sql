@@ -0,0 +1,3 @@
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS archived_at TIMESTAMPTZ;
+
+COMMENT ON COLUMN library_book.archived_at IS 'archived books are kept as stored: no edits, no file or cover changes, no deletion';
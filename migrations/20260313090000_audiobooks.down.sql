@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS library_chapter;
+ALTER TABLE library_book DROP COLUMN IF EXISTS duration_seconds;
+ALTER TABLE library_book DROP COLUMN IF EXISTS media_type;
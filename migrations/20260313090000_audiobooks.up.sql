@@ -0,0 +1,13 @@
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS media_type TEXT NOT NULL DEFAULT 'book' CHECK (media_type IN ('book', 'audiobook'));
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS duration_seconds INTEGER;
+
+CREATE TABLE library_chapter (
+    book_id UUID NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    start_ms BIGINT NOT NULL,
+    PRIMARY KEY (book_id, position)
+);
+
+COMMENT ON COLUMN library_book.duration_seconds IS 'Playing time of audiobooks';
+COMMENT ON TABLE library_chapter IS 'Audiobook chapters in playing order, read from the m4b or mp3 tags';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS library_book_storage_cover_path;
+COMMENT ON COLUMN library_book.storage_cover_path IS NULL;
@@ -0,0 +1,3 @@
+CREATE INDEX IF NOT EXISTS library_book_storage_cover_path ON library_book(storage_cover_path);
+
+COMMENT ON COLUMN library_book.storage_cover_path IS 'Content addressed cover, covers/<sha256>.jpg, shared by books with the same cover';
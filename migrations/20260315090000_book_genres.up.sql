@@ -0,0 +1,3 @@
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS genres TEXT[] NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN library_book.genres IS 'Genres read from the book file, like the FB2 genre codes';
@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS library_book_file (
+    book_id UUID NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    format TEXT NOT NULL,
+    storage_file_path TEXT NOT NULL,
+    koreader_partial_md5 TEXT NOT NULL UNIQUE,
+    file_size BIGINT NOT NULL DEFAULT 0,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    PRIMARY KEY (book_id, format)
+);
+
+COMMENT ON TABLE library_book_file IS 'Formats of a book besides the file it was uploaded as, like a PDF next to an EPUB';
+COMMENT ON COLUMN library_book_file.koreader_partial_md5 IS 'Partial MD5 of this file, uploads of it are found as duplicates of the book';
@@ -0,0 +1 @@
+ALTER TABLE auth_device DROP COLUMN IF EXISTS language;
@@ -0,0 +1,3 @@
+ALTER TABLE auth_device ADD COLUMN IF NOT EXISTS language TEXT NOT NULL DEFAULT '';
+
+COMMENT ON COLUMN auth_device.language IS 'language of the OPDS feeds sent to the device, empty follows Accept-Language';
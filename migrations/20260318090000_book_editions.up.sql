@@ -0,0 +1,12 @@
+CREATE TABLE IF NOT EXISTS library_book_edition (
+    book_id UUID PRIMARY KEY REFERENCES library_book(id) ON DELETE CASCADE,
+    work_id UUID NOT NULL,
+    relation TEXT NOT NULL DEFAULT 'edition',
+    linked_at TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX IF NOT EXISTS library_book_edition_work_id_idx ON library_book_edition (work_id);
+
+COMMENT ON TABLE library_book_edition IS 'Books of the same work: editions, translations and formats uploaded as separate books';
+COMMENT ON COLUMN library_book_edition.work_id IS 'Shared by all books of a work, the id of the book the others were first linked to';
+COMMENT ON COLUMN library_book_edition.relation IS 'edition, translation or format';
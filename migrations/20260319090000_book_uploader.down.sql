@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS library_book_uploaded_by_idx;
+ALTER TABLE library_book DROP COLUMN IF EXISTS uploaded_by;
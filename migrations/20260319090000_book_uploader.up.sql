@@ -0,0 +1,5 @@
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS uploaded_by TEXT NOT NULL DEFAULT '';
+
+CREATE INDEX IF NOT EXISTS library_book_uploaded_by_idx ON library_book (uploaded_by);
+
+COMMENT ON COLUMN library_book.uploaded_by IS 'Account that uploaded the book, counted for the upload limit; empty for books stored before';
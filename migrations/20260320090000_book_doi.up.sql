@@ -0,0 +1,3 @@
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS doi TEXT;
+
+COMMENT ON COLUMN library_book.doi IS 'Digital Object Identifier of articles and papers, looked up on Crossref';
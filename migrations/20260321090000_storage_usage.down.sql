@@ -0,0 +1 @@
+ALTER TABLE library_book DROP COLUMN IF EXISTS cover_size;
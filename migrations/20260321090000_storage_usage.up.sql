@@ -0,0 +1,3 @@
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS cover_size BIGINT NOT NULL DEFAULT 0;
+
+COMMENT ON COLUMN library_book.cover_size IS 'Bytes of the cover file, counted for the storage quota of the uploader; 0 for covers written before';
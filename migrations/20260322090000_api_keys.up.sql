@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS auth_api_key (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    username TEXT NOT NULL REFERENCES auth_user(username) ON DELETE CASCADE,
+    device_name VARCHAR(32) REFERENCES auth_device(device_name) ON DELETE CASCADE,
+    name TEXT NOT NULL,
+    key_prefix TEXT NOT NULL,
+    key_hash TEXT NOT NULL UNIQUE,
+    scope TEXT NOT NULL CHECK (scope IN ('read', 'write')),
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_used_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS auth_api_key_username_idx ON auth_api_key (username);
+
+COMMENT ON TABLE auth_api_key IS 'long-lived keys for scripts and devices, used in place of the account password';
+COMMENT ON COLUMN auth_api_key.username IS 'account that created the key, user keys sign in as it';
+COMMENT ON COLUMN auth_api_key.device_name IS 'device the key signs in as, NULL for user keys';
+COMMENT ON COLUMN auth_api_key.key_prefix IS 'start of the key to tell keys apart, the key itself is not stored';
+COMMENT ON COLUMN auth_api_key.key_hash IS 'sha256 hash of the key';
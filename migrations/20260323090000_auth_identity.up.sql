@@ -0,0 +1,11 @@
+CREATE TABLE IF NOT EXISTS auth_identity (
+    issuer TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    username TEXT NOT NULL REFERENCES auth_user(username) ON DELETE CASCADE,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_login_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    PRIMARY KEY (issuer, subject)
+);
+
+COMMENT ON TABLE auth_identity IS 'accounts of OpenID Connect providers signing in as a user';
+COMMENT ON COLUMN auth_identity.subject IS 'sub claim, stable unlike the username at the provider';
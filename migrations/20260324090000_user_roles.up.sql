@@ -0,0 +1,6 @@
+-- accounts from before roles keep every right they had, new ones read
+ALTER TABLE auth_user ADD COLUMN IF NOT EXISTS role TEXT NOT NULL DEFAULT 'admin'
+    CHECK (role IN ('admin', 'editor', 'reader'));
+ALTER TABLE auth_user ALTER COLUMN role SET DEFAULT 'reader';
+
+COMMENT ON COLUMN auth_user.role IS 'admin manages accounts and settings, editor changes the library, reader reads';
@@ -0,0 +1,19 @@
+CREATE TABLE IF NOT EXISTS book_share_link (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id UUID NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    username TEXT NOT NULL,
+    token_prefix TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    max_downloads INTEGER NOT NULL DEFAULT 0,
+    downloads INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    revoked_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS book_share_link_username_idx ON book_share_link (username, book_id);
+
+COMMENT ON TABLE book_share_link IS 'links that let anyone holding the token download a book without an account';
+COMMENT ON COLUMN book_share_link.username IS 'account that created the link';
+COMMENT ON COLUMN book_share_link.token_prefix IS 'start of the token to tell links apart, the token itself is not stored';
+COMMENT ON COLUMN book_share_link.token_hash IS 'sha256 hash of the token';
+COMMENT ON COLUMN book_share_link.max_downloads IS 'how often the book may be downloaded with the link, 0 does not limit';
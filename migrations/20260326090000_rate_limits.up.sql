@@ -0,0 +1,11 @@
+CREATE UNLOGGED TABLE IF NOT EXISTS rate_limit_bucket (
+    key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMPTZ NOT NULL,
+    full_at TIMESTAMPTZ NOT NULL
+);
+
+COMMENT ON TABLE rate_limit_bucket IS 'token buckets of the rate limits shared by the servers, losing them on a crash only resets the limits';
+COMMENT ON COLUMN rate_limit_bucket.key IS 'limit and client, a hashed credential or the client IP';
+COMMENT ON COLUMN rate_limit_bucket.tokens IS 'calls left at updated_at, refilled with time';
+COMMENT ON COLUMN rate_limit_bucket.full_at IS 'when the bucket is full again and can be deleted';
@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS library_book_author_fold_idx;
+DROP FUNCTION IF EXISTS library_fold(text);
+DROP EXTENSION IF EXISTS unaccent;
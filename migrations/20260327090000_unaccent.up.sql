@@ -0,0 +1,11 @@
+CREATE EXTENSION IF NOT EXISTS unaccent;
+
+-- unaccent() is only stable because its dictionary could change, naming
+-- the dictionary makes the wrapper immutable so it can be indexed
+CREATE OR REPLACE FUNCTION library_fold(text) RETURNS text
+    LANGUAGE sql IMMUTABLE PARALLEL SAFE STRICT
+    AS $$ SELECT lower(public.unaccent('public.unaccent'::regdictionary, $1)) $$;
+
+CREATE INDEX IF NOT EXISTS library_book_author_fold_idx ON library_book (library_fold(author));
+
+COMMENT ON FUNCTION library_fold(text) IS 'lower case without accents, Émile Zola and emile zola fold to the same text';
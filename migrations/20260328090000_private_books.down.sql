@@ -0,0 +1,2 @@
+ALTER TABLE auth_user DROP COLUMN IF EXISTS is_private_by_default;
+ALTER TABLE library_book DROP COLUMN IF EXISTS is_private;
@@ -0,0 +1,6 @@
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS is_private BOOLEAN NOT NULL DEFAULT false;
+
+ALTER TABLE auth_user ADD COLUMN IF NOT EXISTS is_private_by_default BOOLEAN NOT NULL DEFAULT false;
+
+COMMENT ON COLUMN library_book.is_private IS 'Only the uploader sees the book, other accounts and devices find it neither listed nor by id';
+COMMENT ON COLUMN auth_user.is_private_by_default IS 'Books the account uploads are private unless the upload asks otherwise';
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS library_book_loan (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id UUID NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    lender TEXT NOT NULL,
+    borrower TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    expires_at TIMESTAMPTZ NOT NULL,
+    ended_at TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS library_book_loan_borrower_idx ON library_book_loan (borrower, book_id) WHERE ended_at IS NULL;
+CREATE INDEX IF NOT EXISTS library_book_loan_lender_idx ON library_book_loan (lender) WHERE ended_at IS NULL;
+
+COMMENT ON TABLE library_book_loan IS 'private books lent to another account for some days';
+COMMENT ON COLUMN library_book_loan.lender IS 'uploader of the book';
+COMMENT ON COLUMN library_book_loan.borrower IS 'account that sees the book until the loan expires or ends';
+COMMENT ON COLUMN library_book_loan.ended_at IS 'when the loan was ended or found expired and both accounts were told';
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS library_book_visible_from_idx;
+ALTER TABLE library_book DROP COLUMN IF EXISTS visible_from;
@@ -0,0 +1,4 @@
+ALTER TABLE library_book ADD COLUMN IF NOT EXISTS visible_from TIMESTAMPTZ;
+CREATE INDEX IF NOT EXISTS library_book_visible_from_idx ON library_book (visible_from) WHERE visible_from IS NOT NULL;
+
+COMMENT ON COLUMN library_book.visible_from IS 'Only admins see the book before, NULL when it is always visible';
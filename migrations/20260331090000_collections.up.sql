@@ -0,0 +1,24 @@
+CREATE TABLE IF NOT EXISTS library_collection (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    owner TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
+CREATE TABLE IF NOT EXISTS library_collection_entry (
+    collection_id UUID NOT NULL REFERENCES library_collection(id) ON DELETE CASCADE,
+    book_id UUID NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    prerequisites UUID[] NOT NULL DEFAULT '{}',
+    PRIMARY KEY (collection_id, book_id)
+);
+CREATE INDEX IF NOT EXISTS library_collection_entry_book_idx ON library_collection_entry (book_id);
+
+COMMENT ON TABLE library_collection IS 'reading paths through the library, like courses or curated lists';
+COMMENT ON COLUMN library_collection.owner IS 'account that edits the collection besides admins, empty once it was deleted';
+COMMENT ON TABLE library_collection_entry IS 'books of a collection in reading order';
+COMMENT ON COLUMN library_collection_entry.position IS 'place in the reading order, from 0';
+COMMENT ON COLUMN library_collection_entry.prerequisites IS 'books earlier in the collection to read before this one';
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS library_ingest_log;
@@ -0,0 +1,17 @@
+CREATE TABLE IF NOT EXISTS library_ingest_log (
+    id UUID PRIMARY KEY DEFAULT gen_random_uuid(),
+    book_id UUID NOT NULL,
+    document_id TEXT NOT NULL DEFAULT '',
+    file_path TEXT NOT NULL,
+    cover_path TEXT NOT NULL DEFAULT '',
+    state TEXT NOT NULL CHECK (state IN ('written', 'compensated', 'failed')),
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+CREATE INDEX IF NOT EXISTS library_ingest_log_state_idx ON library_ingest_log (state, updated_at);
+
+COMMENT ON TABLE library_ingest_log IS 'uploads whose files were written to storage but whose book was not stored, rows of stored books are removed';
+COMMENT ON COLUMN library_ingest_log.book_id IS 'id the book would have, there is no foreign key as the book may never be stored';
+COMMENT ON COLUMN library_ingest_log.state IS 'written while the book is stored, compensated once the files of a failed upload were removed, failed when removing them failed too';
+COMMENT ON COLUMN library_ingest_log.detail IS 'why storing the book or removing its files failed';
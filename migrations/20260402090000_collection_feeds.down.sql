@@ -0,0 +1,2 @@
+ALTER TABLE library_collection DROP COLUMN IF EXISTS feed_token_prefix;
+ALTER TABLE library_collection DROP COLUMN IF EXISTS feed_token_hash;
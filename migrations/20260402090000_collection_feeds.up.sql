@@ -0,0 +1,5 @@
+ALTER TABLE library_collection ADD COLUMN IF NOT EXISTS feed_token_hash TEXT UNIQUE;
+ALTER TABLE library_collection ADD COLUMN IF NOT EXISTS feed_token_prefix TEXT NOT NULL DEFAULT '';
+
+COMMENT ON COLUMN library_collection.feed_token_hash IS 'sha256 of the token of the shared OPDS feed of the collection, NULL when it is not shared';
+COMMENT ON COLUMN library_collection.feed_token_prefix IS 'first characters of the feed token to tell it apart, the token itself is not stored';
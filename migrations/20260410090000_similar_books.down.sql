@@ -0,0 +1,3 @@
+DROP INDEX IF EXISTS library_book_genres_idx;
+DROP INDEX IF EXISTS library_book_title_trgm_idx;
+DROP EXTENSION IF EXISTS pg_trgm;
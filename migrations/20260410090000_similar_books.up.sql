@@ -0,0 +1,6 @@
+CREATE EXTENSION IF NOT EXISTS pg_trgm;
+
+CREATE INDEX IF NOT EXISTS library_book_title_trgm_idx ON library_book USING gin (title gin_trgm_ops);
+CREATE INDEX IF NOT EXISTS library_book_genres_idx ON library_book USING gin (genres);
+
+COMMENT ON INDEX library_book_title_trgm_idx IS 'finds books with similar titles for the similar books of a book';
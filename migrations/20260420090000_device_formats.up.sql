@@ -0,0 +1,3 @@
+ALTER TABLE auth_device ADD COLUMN IF NOT EXISTS formats TEXT[] NOT NULL DEFAULT '{}';
+
+COMMENT ON COLUMN auth_device.formats IS 'file formats the device reads well, empty uses the defaults of the format advisor';
@@ -0,0 +1,20 @@
+CREATE TABLE IF NOT EXISTS sync_conflict (
+    id BIGSERIAL PRIMARY KEY,
+    koreader_partial_md5 TEXT NOT NULL,
+    ahead_percentage REAL NOT NULL,
+    ahead_progress TEXT NOT NULL DEFAULT '',
+    ahead_koreader_device TEXT NOT NULL DEFAULT '',
+    ahead_koreader_device_id TEXT NOT NULL DEFAULT '',
+    ahead_auth_device_name TEXT NOT NULL,
+    ahead_at TIMESTAMPTZ NOT NULL,
+    behind_percentage REAL NOT NULL,
+    behind_progress TEXT NOT NULL DEFAULT '',
+    behind_koreader_device TEXT NOT NULL DEFAULT '',
+    behind_koreader_device_id TEXT NOT NULL DEFAULT '',
+    behind_auth_device_name TEXT NOT NULL,
+    behind_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    UNIQUE (koreader_partial_md5, behind_auth_device_name)
+);
+
+COMMENT ON TABLE sync_conflict IS 'Progress pushed behind the latest position of another device, kept until the user picks one';
@@ -0,0 +1,21 @@
+CREATE TABLE IF NOT EXISTS library_job (
+    id BIGSERIAL PRIMARY KEY,
+    kind TEXT NOT NULL,
+    book_id UUID NOT NULL,
+    target TEXT NOT NULL DEFAULT '',
+    state TEXT NOT NULL DEFAULT 'queued' CHECK (state IN ('queued', 'running', 'failed')),
+    attempts INTEGER NOT NULL DEFAULT 0,
+    worker TEXT NOT NULL DEFAULT '',
+    detail TEXT NOT NULL DEFAULT '',
+    run_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    heartbeat_at TIMESTAMPTZ,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    updated_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    UNIQUE (kind, book_id, target)
+);
+CREATE INDEX IF NOT EXISTS library_job_claim_idx ON library_job (state, run_at);
+
+COMMENT ON TABLE library_job IS 'background jobs, like conversions, workers of all servers claim with FOR UPDATE SKIP LOCKED; done jobs are removed';
+COMMENT ON COLUMN library_job.book_id IS 'there is no foreign key as the library may be kept in SQLite';
+COMMENT ON COLUMN library_job.state IS 'queued until run_at, running while the worker sends heartbeats, failed after the last attempt';
+COMMENT ON COLUMN library_job.heartbeat_at IS 'last sign of life of the worker, running jobs without one for the visibility timeout are claimed again';
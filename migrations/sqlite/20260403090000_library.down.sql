@@ -0,0 +1,14 @@
+DROP TABLE IF EXISTS library_check;
+DROP TABLE IF EXISTS library_issue;
+DROP TABLE IF EXISTS library_ingest_log;
+DROP TABLE IF EXISTS library_collection_entry;
+DROP TABLE IF EXISTS library_collection;
+DROP TABLE IF EXISTS library_book_loan;
+DROP TABLE IF EXISTS book_share_link;
+DROP TABLE IF EXISTS book_download;
+DROP TABLE IF EXISTS book_review;
+DROP TABLE IF EXISTS reading_status;
+DROP TABLE IF EXISTS library_chapter;
+DROP TABLE IF EXISTS library_book_edition;
+DROP TABLE IF EXISTS library_book_file;
+DROP TABLE IF EXISTS library_book;
@@ -0,0 +1,176 @@
+-- The library of servers keeping it in SQLite, the tables of the Postgres
+-- migrations up to 20260402090000_collection_feeds. Times are stored as
+-- text in UTC, genres and prerequisites as JSON arrays.
+CREATE TABLE library_book (
+    id TEXT PRIMARY KEY,
+    storage_file_path TEXT NOT NULL UNIQUE,
+    koreader_partial_md5 TEXT NOT NULL UNIQUE,
+    storage_cover_path TEXT,
+
+    title TEXT NOT NULL,
+    author TEXT,
+    publisher TEXT,
+    year INTEGER,
+    isbn TEXT,
+    series TEXT,
+    series_index NUMERIC,
+    language TEXT,
+    pages INTEGER,
+    summary TEXT,
+    file_size INTEGER,
+    rating_avg REAL,
+    rating_count INTEGER NOT NULL DEFAULT 0,
+    archived_at TIMESTAMP,
+    media_type TEXT NOT NULL DEFAULT 'book' CHECK (media_type IN ('book', 'audiobook')),
+    duration_seconds INTEGER,
+    genres TEXT NOT NULL DEFAULT '[]',
+    uploaded_by TEXT NOT NULL DEFAULT '',
+    doi TEXT,
+    cover_size INTEGER NOT NULL DEFAULT 0,
+    is_private BOOLEAN NOT NULL DEFAULT 0,
+    visible_from TIMESTAMP,
+
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX library_book_title ON library_book (title);
+CREATE INDEX library_book_author ON library_book (author);
+CREATE INDEX library_book_language_idx ON library_book (language);
+CREATE INDEX library_book_storage_cover_path ON library_book (storage_cover_path);
+CREATE INDEX library_book_uploaded_by_idx ON library_book (uploaded_by);
+CREATE INDEX library_book_created_at_idx ON library_book (created_at, id);
+
+CREATE TABLE library_book_file (
+    book_id TEXT NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    format TEXT NOT NULL,
+    storage_file_path TEXT NOT NULL,
+    koreader_partial_md5 TEXT NOT NULL UNIQUE,
+    file_size INTEGER NOT NULL DEFAULT 0,
+    created_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (book_id, format)
+);
+
+CREATE TABLE library_book_edition (
+    book_id TEXT PRIMARY KEY REFERENCES library_book(id) ON DELETE CASCADE,
+    work_id TEXT NOT NULL,
+    relation TEXT NOT NULL DEFAULT 'edition',
+    linked_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP
+);
+CREATE INDEX library_book_edition_work_id_idx ON library_book_edition (work_id);
+
+CREATE TABLE library_chapter (
+    book_id TEXT NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    title TEXT NOT NULL,
+    start_ms INTEGER NOT NULL,
+    PRIMARY KEY (book_id, position)
+);
+
+CREATE TABLE reading_status (
+    username TEXT NOT NULL,
+    book_id TEXT NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    status TEXT NOT NULL CHECK (status IN ('to_read', 'reading', 'finished')),
+    finished_at TIMESTAMP,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (username, book_id)
+);
+CREATE INDEX reading_status_username_status ON reading_status (username, status);
+
+CREATE TABLE book_review (
+    username TEXT NOT NULL,
+    book_id TEXT NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    rating INTEGER CHECK (rating BETWEEN 1 AND 5),
+    review TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (username, book_id)
+);
+CREATE INDEX book_review_book_id ON book_review (book_id);
+
+CREATE TABLE book_download (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    book_id TEXT NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    username TEXT NOT NULL DEFAULT '',
+    device_name TEXT NOT NULL DEFAULT '',
+    client TEXT NOT NULL,
+    downloaded_at TIMESTAMP NOT NULL
+);
+CREATE INDEX book_download_username ON book_download (username, downloaded_at DESC);
+CREATE INDEX book_download_book_id ON book_download (book_id);
+
+CREATE TABLE book_share_link (
+    id TEXT PRIMARY KEY,
+    book_id TEXT NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    username TEXT NOT NULL,
+    token_prefix TEXT NOT NULL,
+    token_hash TEXT NOT NULL UNIQUE,
+    max_downloads INTEGER NOT NULL DEFAULT 0,
+    downloads INTEGER NOT NULL DEFAULT 0,
+    expires_at TIMESTAMP NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    revoked_at TIMESTAMP
+);
+CREATE INDEX book_share_link_username_idx ON book_share_link (username, book_id);
+
+CREATE TABLE library_book_loan (
+    id TEXT PRIMARY KEY,
+    book_id TEXT NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    lender TEXT NOT NULL,
+    borrower TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    expires_at TIMESTAMP NOT NULL,
+    ended_at TIMESTAMP
+);
+CREATE INDEX library_book_loan_borrower_idx ON library_book_loan (borrower, book_id) WHERE ended_at IS NULL;
+CREATE INDEX library_book_loan_lender_idx ON library_book_loan (lender) WHERE ended_at IS NULL;
+
+CREATE TABLE library_collection (
+    id TEXT PRIMARY KEY,
+    name TEXT NOT NULL,
+    description TEXT NOT NULL DEFAULT '',
+    owner TEXT NOT NULL DEFAULT '',
+    feed_token_hash TEXT UNIQUE,
+    feed_token_prefix TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+
+CREATE TABLE library_collection_entry (
+    collection_id TEXT NOT NULL REFERENCES library_collection(id) ON DELETE CASCADE,
+    book_id TEXT NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    note TEXT NOT NULL DEFAULT '',
+    prerequisites TEXT NOT NULL DEFAULT '[]',
+    PRIMARY KEY (collection_id, book_id)
+);
+CREATE INDEX library_collection_entry_book_idx ON library_collection_entry (book_id);
+
+CREATE TABLE library_ingest_log (
+    id TEXT PRIMARY KEY,
+    book_id TEXT NOT NULL,
+    document_id TEXT NOT NULL DEFAULT '',
+    file_path TEXT NOT NULL,
+    cover_path TEXT NOT NULL DEFAULT '',
+    state TEXT NOT NULL CHECK (state IN ('written', 'compensated', 'failed')),
+    detail TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP NOT NULL,
+    updated_at TIMESTAMP NOT NULL
+);
+CREATE INDEX library_ingest_log_state_idx ON library_ingest_log (state, updated_at);
+
+CREATE TABLE library_issue (
+    book_id TEXT NOT NULL REFERENCES library_book(id) ON DELETE CASCADE,
+    kind TEXT NOT NULL CHECK (kind IN ('missing_file', 'unreadable_file', 'hash_mismatch', 'missing_cover')),
+    detail TEXT NOT NULL DEFAULT '',
+    detected_at TIMESTAMP NOT NULL,
+    checked_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (book_id, kind)
+);
+
+CREATE TABLE library_check (
+    id INTEGER PRIMARY KEY AUTOINCREMENT,
+    started_at TIMESTAMP NOT NULL,
+    finished_at TIMESTAMP NOT NULL,
+    book_count INTEGER NOT NULL,
+    issue_count INTEGER NOT NULL
+);
@@ -0,0 +1,82 @@
+// Package cache keeps rendered responses, like book listings and covers,
+// in memory or, for servers sharing them, in Redis.
+package cache
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+// Memory keeps values of one server up to a size limit, evicting the
+// least recently used ones.
+type Memory struct {
+	maxBytes int64
+
+	mu    sync.Mutex
+	ll    *list.List // front is the most recently used
+	items map[string]*list.Element
+	size  int64
+}
+
+type entry struct {
+	key     string
+	value   []byte
+	expires time.Time // zero when the value does not expire
+}
+
+func (e *entry) size() int64 {
+	return int64(len(e.key) + len(e.value))
+}
+
+func NewMemory(maxBytes int64) *Memory {
+	return &Memory{maxBytes: maxBytes, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get returns a copy of the value of key, false when it is missing or expired.
+func (m *Memory) Get(_ context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	el, ok := m.items[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*entry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		m.remove(el)
+		return nil, false, nil
+	}
+	m.ll.MoveToFront(el)
+	return append([]byte(nil), e.value...), true, nil
+}
+
+// Set stores a copy of value for ttl, forever when ttl is 0. Values larger
+// than the whole cache are not kept.
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	e := &entry{key: key, value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if el, ok := m.items[key]; ok {
+		m.remove(el)
+	}
+	if e.size() > m.maxBytes {
+		return nil
+	}
+	m.items[key] = m.ll.PushFront(e)
+	m.size += e.size()
+	for m.size > m.maxBytes {
+		m.remove(m.ll.Back())
+	}
+	return nil
+}
+
+func (m *Memory) remove(el *list.Element) {
+	e := m.ll.Remove(el).(*entry)
+	delete(m.items, e.key)
+	m.size -= e.size()
+}
@@ -0,0 +1,148 @@
+package cache_test
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/cache"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	c := cache.NewMemory(20)
+
+	_ = c.Set(ctx, "a", []byte("12345678"), 0)
+	_ = c.Set(ctx, "b", []byte("12345678"), 0)
+	if _, ok, _ := c.Get(ctx, "a"); !ok {
+		t.Fatal("expected a cached")
+	}
+	// b is the least recently used and makes room for c
+	_ = c.Set(ctx, "c", []byte("12345678"), 0)
+	if _, ok, _ := c.Get(ctx, "b"); ok {
+		t.Error("expected b evicted")
+	}
+	if value, ok, _ := c.Get(ctx, "a"); !ok || string(value) != "12345678" {
+		t.Errorf("expected a kept, got %q", value)
+	}
+
+	_ = c.Set(ctx, "big", make([]byte, 64), 0)
+	if _, ok, _ := c.Get(ctx, "big"); ok {
+		t.Error("expected a value larger than the cache not kept")
+	}
+
+	_ = c.Set(ctx, "short", []byte("x"), time.Millisecond)
+	time.Sleep(5 * time.Millisecond)
+	if _, ok, _ := c.Get(ctx, "short"); ok {
+		t.Error("expected the value expired")
+	}
+}
+
+func TestRedis(t *testing.T) {
+	addr, commands := fakeRedis(t)
+	c, err := cache.NewRedis("redis://:secret@" + addr + "/2")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer c.Close()
+	ctx := context.Background()
+
+	if _, ok, err := c.Get(ctx, "missing"); ok || err != nil {
+		t.Fatalf("expected a miss, got %v %v", ok, err)
+	}
+	if err = c.Set(ctx, "key", []byte("line\r\nbreak"), time.Minute); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	value, ok, err := c.Get(ctx, "key")
+	if err != nil || !ok || string(value) != "line\r\nbreak" {
+		t.Fatalf("unexpected value %q %v %v", value, ok, err)
+	}
+
+	want := []string{"AUTH secret", "SELECT 2", "GET missing", "SET key line\r\nbreak PX 60000", "GET key"}
+	if got := commands(); strings.Join(got, "|") != strings.Join(want, "|") {
+		t.Errorf("expected one connection sending %q, got %q", want, got)
+	}
+
+	if _, err = cache.NewRedis("http://localhost"); err == nil {
+		t.Error("expected an error for another scheme")
+	}
+}
+
+// fakeRedis answers GET, SET, AUTH and SELECT and records the commands.
+func fakeRedis(t *testing.T) (string, func() []string) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	t.Cleanup(func() { ln.Close() })
+
+	var mu sync.Mutex
+	var commands []string
+	values := map[string]string{}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				r := bufio.NewReader(conn)
+				for {
+					args, err := readCommand(r)
+					if err != nil {
+						return
+					}
+					mu.Lock()
+					commands = append(commands, strings.Join(args, " "))
+					reply := "+OK\r\n"
+					switch args[0] {
+					case "GET":
+						if value, ok := values[args[1]]; ok {
+							reply = fmt.Sprintf("$%d\r\n%s\r\n", len(value), value)
+						} else {
+							reply = "$-1\r\n"
+						}
+					case "SET":
+						values[args[1]] = args[2]
+					}
+					mu.Unlock()
+					io.WriteString(conn, reply)
+				}
+			}()
+		}
+	}()
+	return ln.Addr().String(), func() []string {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]string(nil), commands...)
+	}
+}
+
+func readCommand(r *bufio.Reader) ([]string, error) {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	n, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+	args := make([]string, n)
+	for i := range args {
+		if line, err = r.ReadString('\n'); err != nil {
+			return nil, err
+		}
+		size, _ := strconv.Atoi(strings.TrimSpace(line[1:]))
+		arg := make([]byte, size+2)
+		if _, err = io.ReadFull(r, arg); err != nil {
+			return nil, err
+		}
+		args[i] = string(arg[:size])
+	}
+	return args, nil
+}
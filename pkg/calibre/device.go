@@ -0,0 +1,424 @@
+package calibre
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"path"
+	"strings"
+
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+const (
+	// DefaultPort is where Calibre listens for wireless devices.
+	DefaultPort = 9090
+	// protocolVersion is the version of the protocol Calibre speaks since 2013.
+	protocolVersion = 1
+	// bookPacketLength is the most Calibre sends of a book file at once.
+	bookPacketLength = 1 << 20
+	// defaultFreeSpace is reported unless set, Calibre refuses to send
+	// books to a device it believes full.
+	defaultFreeSpace = 1 << 40
+)
+
+var (
+	ErrPassword = errors.New("calibre refused the password")
+	ErrProtocol = errors.New("calibre speaks an unknown protocol version")
+)
+
+// Library is what a device shows to Calibre. Books are named by their lpath
+// in every call, those of Books and those Calibre sent in the session.
+type Library interface {
+	// Books lists the books on the device with their metadata.
+	Books(ctx context.Context) ([]Metadata, error)
+	// UpdateBook applies metadata Calibre changed.
+	UpdateBook(ctx context.Context, m Metadata) error
+	// AddBook stores a book Calibre sends, m.Lpath names it from then on.
+	AddBook(ctx context.Context, m Metadata, file io.Reader) error
+	DeleteBook(ctx context.Context, lpath string) error
+	OpenBook(ctx context.Context, lpath string) (io.ReadCloser, int64, error)
+}
+
+// Device is one connection to Calibre.
+type Device struct {
+	Name       string   // shown by Calibre
+	UUID       string   // Calibre keeps its cache of the device under it
+	Password   string   // the one set in Calibre, empty when none is
+	Extensions []string // formats Calibre may send, lower case
+	FreeSpace  int64    // reported free space in bytes, 1 TiB when unset
+	Library    Library
+	Logger     logger.Interface
+
+	conn net.Conn
+	r    *bufio.Reader
+	// reported holds what Books returned by lpath, Calibre sends all the
+	// metadata back and only the changed books are updated.
+	reported map[string]Metadata
+	// books is the last book list, Calibre asks for metadata by position
+	books []Metadata
+}
+
+// Discover finds a Calibre waiting for wireless devices on the local
+// network, it returns the address of its device port.
+func Discover(ctx context.Context) (string, error) {
+	conn, err := net.ListenPacket("udp4", ":0")
+	if err != nil {
+		return "", fmt.Errorf("calibre - Discover - net.ListenPacket: %w", err)
+	}
+	defer conn.Close()
+	go func() {
+		<-ctx.Done()
+		conn.Close()
+	}()
+
+	// Calibre listens on the first of these ports it can bind
+	for _, port := range []int{54982, 48123, 39001, 44044, 59678} {
+		addr := &net.UDPAddr{IP: net.IPv4bcast, Port: port}
+		if _, err = conn.WriteTo([]byte("hello"), addr); err != nil {
+			return "", fmt.Errorf("calibre - Discover - conn.WriteTo: %w", err)
+		}
+	}
+	buf := make([]byte, 1024)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			if ctx.Err() != nil {
+				return "", fmt.Errorf("calibre - Discover - %w", ctx.Err())
+			}
+			return "", fmt.Errorf("calibre - Discover - conn.ReadFrom: %w", err)
+		}
+		if port, ok := parseBroadcastReply(string(buf[:n])); ok {
+			host, _, _ := net.SplitHostPort(from.String())
+			return net.JoinHostPort(host, port), nil
+		}
+	}
+}
+
+// parseBroadcastReply reads "calibre wireless device client (on host);port,content port".
+func parseBroadcastReply(reply string) (string, bool) {
+	_, ports, found := strings.Cut(reply, ";")
+	if !strings.HasPrefix(reply, "calibre wireless device client") || !found {
+		return "", false
+	}
+	port, _, _ := strings.Cut(ports, ",")
+	return port, port != ""
+}
+
+// Connect dials Calibre and serves it until it ejects the device, the
+// connection closes or ctx is done.
+func (d *Device) Connect(ctx context.Context, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return fmt.Errorf("calibre - Connect - dialer.DialContext: %w", err)
+	}
+	return d.Serve(ctx, conn)
+}
+
+// Serve runs the protocol on an open connection, Calibre leads: it asks and
+// the device answers. The connection is closed on return.
+func (d *Device) Serve(ctx context.Context, conn net.Conn) error {
+	d.conn, d.r, d.reported = conn, bufio.NewReader(conn), map[string]Metadata{}
+	defer conn.Close()
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-done:
+		}
+	}()
+
+	for {
+		opcode, arg, err := readMessage(d.r)
+		if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+			if ctx.Err() != nil {
+				return fmt.Errorf("calibre - Serve - %w", ctx.Err())
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("calibre - Serve - readMessage: %w", err)
+		}
+		ejected, err := d.handle(ctx, opcode, arg)
+		if err != nil {
+			return fmt.Errorf("calibre - Serve - %w", err)
+		}
+		if ejected {
+			return nil
+		}
+	}
+}
+
+func (d *Device) handle(ctx context.Context, opcode int, arg json.RawMessage) (bool, error) {
+	switch opcode {
+	case opGetInitializationInfo:
+		return false, d.initializationInfo(arg)
+	case opGetDeviceInformation:
+		return false, d.reply(opOK, map[string]any{
+			"device_info": map[string]any{
+				"device_store_uuid": d.UUID,
+				"device_name":       d.Name,
+			},
+			"version":        "1",
+			"device_version": "1",
+		})
+	case opSetCalibreDeviceInfo, opSetCalibreDeviceName, opSetLibraryInfo:
+		return false, d.reply(opOK, nil)
+	case opTotalSpace, opFreeSpace:
+		space := d.FreeSpace
+		if space == 0 {
+			space = defaultFreeSpace
+		}
+		return false, d.reply(opOK, map[string]any{"total_space_on_device": space, "free_space_on_device": space})
+	case opGetBookCount:
+		return false, d.bookCount(ctx, arg)
+	case opNoop:
+		return d.noop(arg)
+	case opSendBooklists:
+		// the metadata follows book by book, Calibre expects no answer
+		return false, nil
+	case opSendBookMetadata:
+		d.bookMetadata(ctx, arg)
+		return false, nil
+	case opSendBook:
+		return false, d.receiveBook(ctx, arg)
+	case opDeleteBook:
+		return false, d.deleteBooks(ctx, arg)
+	case opGetBookFileSegment:
+		return false, d.sendBook(ctx, arg)
+	case opDisplayMessage:
+		var message struct {
+			Kind    int    `json:"messageKind"`
+			Message string `json:"message"`
+		}
+		_ = json.Unmarshal(arg, &message)
+		if message.Kind == 1 {
+			return false, ErrPassword
+		}
+		d.Logger.Info("calibre - %s", message.Message)
+		return false, nil
+	default:
+		// busy notices, book done and newer opcodes need no answer
+		return false, nil
+	}
+}
+
+func (d *Device) reply(opcode int, arg any) error {
+	if err := writeMessage(d.conn, opcode, arg); err != nil {
+		return fmt.Errorf("writeMessage: %w", err)
+	}
+	return nil
+}
+
+func (d *Device) initializationInfo(arg json.RawMessage) error {
+	var request struct {
+		Challenge       string `json:"passwordChallenge"`
+		ProtocolVersion int    `json:"serverProtocolVersion"`
+	}
+	if err := json.Unmarshal(arg, &request); err != nil {
+		return fmt.Errorf("%w: %s", ErrMessage, err)
+	}
+	if request.ProtocolVersion != protocolVersion {
+		return fmt.Errorf("%w: %d", ErrProtocol, request.ProtocolVersion)
+	}
+	passwordHash := ""
+	if request.Challenge != "" {
+		sum := sha1.Sum([]byte(d.Password + request.Challenge))
+		passwordHash = hex.EncodeToString(sum[:])
+	}
+	pathLengths := map[string]int{}
+	for _, ext := range d.Extensions {
+		pathLengths[ext] = 255
+	}
+	return d.reply(opOK, map[string]any{
+		"appName":                 "kompanion",
+		"deviceKind":              "kompanion library",
+		"deviceName":              d.Name,
+		"versionOK":               true,
+		"ccVersionNumber":         protocolVersion,
+		"maxBookContentPacketLen": bookPacketLength,
+		"acceptedExtensions":      d.Extensions,
+		"extensionPathLengths":    pathLengths,
+		"passwordHash":            passwordHash,
+		"canUseCachedMetadata":    true,
+		"canStreamBooks":          true,
+		"canStreamMetadata":       true,
+		"canReceiveBookBinary":    true,
+		"canDeleteMultipleBooks":  true,
+		"canSendOkToSendbook":     true,
+		"canAcceptLibraryInfo":    true,
+		"cacheUsesLpaths":         true,
+		"useUuidFileNames":        false,
+		"coverHeight":             0,
+	})
+}
+
+// bookCount lists the books. With cached metadata Calibre gets the short
+// entries first and then asks for the books it misses or that changed.
+func (d *Device) bookCount(ctx context.Context, arg json.RawMessage) error {
+	var request struct {
+		WillUseCachedMetadata bool `json:"willUseCachedMetadata"`
+	}
+	_ = json.Unmarshal(arg, &request)
+	books, err := d.Library.Books(ctx)
+	if err != nil {
+		d.Logger.Error("calibre - Books: %s", err)
+		return d.reply(opError, map[string]any{"message": err.Error()})
+	}
+	d.books, d.reported = books, make(map[string]Metadata, len(books))
+	for _, book := range books {
+		d.reported[book.Lpath] = book
+	}
+
+	if err = d.reply(opOK, map[string]any{"count": len(books), "willStream": true, "willScan": true}); err != nil {
+		return err
+	}
+	for i, book := range books {
+		var entry any = book
+		if request.WillUseCachedMetadata {
+			entry = bookListEntry{PriKey: i, UUID: book.UUID, Lpath: book.Lpath, LastModified: book.LastModified, Extension: strings.TrimPrefix(path.Ext(book.Lpath), ".")}
+		}
+		if err = d.reply(opOK, entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Device) noop(arg json.RawMessage) (bool, error) {
+	var request struct {
+		Ejecting bool `json:"ejecting"`
+		PriKey   *int `json:"priKey"`
+		Count    *int `json:"count"`
+	}
+	_ = json.Unmarshal(arg, &request)
+	switch {
+	case request.Ejecting:
+		return true, d.reply(opOK, nil)
+	case request.PriKey != nil:
+		// Calibre asks for the metadata of a book it has not cached
+		if *request.PriKey < 0 || *request.PriKey >= len(d.books) {
+			return false, d.reply(opError, map[string]any{"message": "unknown book"})
+		}
+		return false, d.reply(opOK, d.books[*request.PriKey])
+	case request.Count != nil:
+		// the number of books Calibre will ask for, they come one by one
+		return false, nil
+	default:
+		return false, d.reply(opOK, nil)
+	}
+}
+
+// bookMetadata applies the metadata Calibre sends for a book, unless it is
+// what the device reported.
+func (d *Device) bookMetadata(ctx context.Context, arg json.RawMessage) {
+	var request struct {
+		Data Metadata `json:"data"`
+	}
+	if err := json.Unmarshal(arg, &request); err != nil || request.Data.Lpath == "" {
+		d.Logger.Warn("calibre - book metadata: %s", shorten(arg))
+		return
+	}
+	if reported, ok := d.reported[request.Data.Lpath]; ok && reported.LastModified.Equal(request.Data.LastModified.Time) {
+		return
+	}
+	if err := d.Library.UpdateBook(ctx, request.Data); err != nil {
+		d.Logger.Warn("calibre - UpdateBook %s: %s", request.Data.Lpath, err)
+		return
+	}
+	d.reported[request.Data.Lpath] = request.Data
+}
+
+func (d *Device) receiveBook(ctx context.Context, arg json.RawMessage) error {
+	var request struct {
+		Lpath            string   `json:"lpath"`
+		Length           int64    `json:"length"`
+		Metadata         Metadata `json:"metadata"`
+		WantsSendOk      bool     `json:"wantsSendOkToSendbook"`
+		WillStreamBinary bool     `json:"willStreamBinary"`
+	}
+	if err := json.Unmarshal(arg, &request); err != nil {
+		return fmt.Errorf("%w: %s", ErrMessage, err)
+	}
+	if !request.WillStreamBinary {
+		return fmt.Errorf("%w: book not sent as binary", ErrMessage)
+	}
+	if request.WantsSendOk {
+		if err := d.reply(opOK, map[string]any{"lpath": request.Lpath}); err != nil {
+			return err
+		}
+	}
+	request.Metadata.Lpath = request.Lpath
+	file := io.LimitReader(d.r, request.Length)
+	if err := d.Library.AddBook(ctx, request.Metadata, file); err != nil {
+		d.Logger.Warn("calibre - AddBook %s: %s", request.Lpath, err)
+	} else {
+		d.reported[request.Lpath] = request.Metadata
+	}
+	// the rest of a book that failed to store is still on the wire
+	if _, err := io.Copy(io.Discard, file); err != nil {
+		return fmt.Errorf("read book: %w", err)
+	}
+	return nil
+}
+
+func (d *Device) deleteBooks(ctx context.Context, arg json.RawMessage) error {
+	var request struct {
+		Lpaths []string `json:"lpaths"`
+	}
+	if err := json.Unmarshal(arg, &request); err != nil {
+		return fmt.Errorf("%w: %s", ErrMessage, err)
+	}
+	if err := d.reply(opOK, nil); err != nil {
+		return err
+	}
+	for _, lpath := range request.Lpaths {
+		if err := d.Library.DeleteBook(ctx, lpath); err != nil {
+			d.Logger.Warn("calibre - DeleteBook %s: %s", lpath, err)
+		}
+		uuid := d.reported[lpath].UUID
+		delete(d.reported, lpath)
+		if err := d.reply(opOK, map[string]any{"uuid": uuid}); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *Device) sendBook(ctx context.Context, arg json.RawMessage) error {
+	var request struct {
+		Lpath    string `json:"lpath"`
+		Position int64  `json:"position"`
+	}
+	if err := json.Unmarshal(arg, &request); err != nil {
+		return fmt.Errorf("%w: %s", ErrMessage, err)
+	}
+	file, size, err := d.Library.OpenBook(ctx, request.Lpath)
+	if err != nil {
+		d.Logger.Warn("calibre - OpenBook %s: %s", request.Lpath, err)
+		return d.reply(opError, map[string]any{"message": err.Error()})
+	}
+	defer file.Close()
+	if request.Position > 0 {
+		if _, err = io.CopyN(io.Discard, file, request.Position); err != nil {
+			return d.reply(opError, map[string]any{"message": err.Error()})
+		}
+	}
+	length := size - request.Position
+	if err = d.reply(opOK, map[string]any{"fileLength": length}); err != nil {
+		return err
+	}
+	if _, err = io.CopyN(d.conn, file, length); err != nil {
+		return fmt.Errorf("send book: %w", err)
+	}
+	return nil
+}
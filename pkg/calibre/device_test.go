@@ -0,0 +1,186 @@
+package calibre_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/calibre"
+	"github.com/banjuer/kompanion/pkg/logger"
+)
+
+type fakeLibrary struct {
+	books   []calibre.Metadata
+	files   map[string]string
+	updated []string
+	deleted []string
+}
+
+func (l *fakeLibrary) Books(context.Context) ([]calibre.Metadata, error) {
+	return l.books, nil
+}
+
+func (l *fakeLibrary) UpdateBook(_ context.Context, m calibre.Metadata) error {
+	l.updated = append(l.updated, m.Lpath+" "+m.Title)
+	return nil
+}
+
+func (l *fakeLibrary) AddBook(_ context.Context, m calibre.Metadata, file io.Reader) error {
+	content, err := io.ReadAll(file)
+	l.files[m.Lpath] = string(content)
+	return err
+}
+
+func (l *fakeLibrary) DeleteBook(_ context.Context, lpath string) error {
+	l.deleted = append(l.deleted, lpath)
+	return nil
+}
+
+func (l *fakeLibrary) OpenBook(_ context.Context, lpath string) (io.ReadCloser, int64, error) {
+	content, ok := l.files[lpath]
+	if !ok {
+		return nil, 0, fmt.Errorf("no book %s", lpath)
+	}
+	return io.NopCloser(strings.NewReader(content)), int64(len(content)), nil
+}
+
+// fakeCalibre plays the Calibre side of the connection.
+type fakeCalibre struct {
+	t    *testing.T
+	conn net.Conn
+	r    *bufio.Reader
+}
+
+func (c *fakeCalibre) send(opcode int, arg any) {
+	c.t.Helper()
+	body, _ := json.Marshal([]any{opcode, arg})
+	if _, err := c.conn.Write([]byte(strconv.Itoa(len(body)) + string(body))); err != nil {
+		c.t.Fatal(err)
+	}
+}
+
+func (c *fakeCalibre) receive(v any) int {
+	c.t.Helper()
+	prefix, err := c.r.ReadString('[')
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	length, _ := strconv.Atoi(prefix[:len(prefix)-1])
+	body := make([]byte, length)
+	body[0] = '['
+	if _, err = io.ReadFull(c.r, body[1:]); err != nil {
+		c.t.Fatal(err)
+	}
+	var message []json.RawMessage
+	if err = json.Unmarshal(body, &message); err != nil {
+		c.t.Fatal(err)
+	}
+	opcode, _ := strconv.Atoi(string(message[0]))
+	if v != nil {
+		if err = json.Unmarshal(message[1], v); err != nil {
+			c.t.Fatal(err)
+		}
+	}
+	return opcode
+}
+
+func TestDeviceSession(t *testing.T) {
+	modified := time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC)
+	lib := &fakeLibrary{
+		books: []calibre.Metadata{
+			{Lpath: "a.epub", UUID: "a", Title: "Dune", LastModified: calibre.Time{Time: modified}},
+			{Lpath: "b.epub", UUID: "b", Title: "Emma", LastModified: calibre.Time{Time: modified}},
+		},
+		files: map[string]string{"a.epub": "dune content"},
+	}
+	device := &calibre.Device{Name: "library", UUID: "device", Password: "secret", Extensions: []string{"epub"}, Library: lib, Logger: logger.New("error")}
+	deviceConn, calibreConn := net.Pipe()
+	done := make(chan error, 1)
+	go func() { done <- device.Serve(context.Background(), deviceConn) }()
+	c := &fakeCalibre{t: t, conn: calibreConn, r: bufio.NewReader(calibreConn)}
+
+	var info map[string]any
+	c.send(9, map[string]any{"passwordChallenge": "challenge", "serverProtocolVersion": 1})
+	if op := c.receive(&info); op != 0 {
+		t.Fatalf("init opcode %d", op)
+	}
+	sum := sha1.Sum([]byte("secretchallenge"))
+	if info["passwordHash"] != hex.EncodeToString(sum[:]) || info["versionOK"] != true {
+		t.Fatalf("init info %v", info)
+	}
+
+	// the short list, then Calibre asks for the book it has not cached
+	var count struct{ Count int }
+	c.send(6, map[string]any{"willUseCachedMetadata": true})
+	c.receive(&count)
+	if count.Count != 2 {
+		t.Fatalf("count %d", count.Count)
+	}
+	for i := 0; i < 2; i++ {
+		var entry map[string]any
+		c.receive(&entry)
+		if entry["priKey"] != float64(i) || entry["title"] != nil {
+			t.Fatalf("entry %v", entry)
+		}
+	}
+	c.send(12, map[string]any{"count": 1})
+	c.send(12, map[string]any{"priKey": 1})
+	var book calibre.Metadata
+	c.receive(&book)
+	if book.Title != "Emma" || !book.LastModified.Equal(modified) {
+		t.Fatalf("metadata %+v", book)
+	}
+
+	// only the changed book is updated
+	c.send(7, map[string]any{"count": 2})
+	c.send(16, map[string]any{"index": 0, "data": map[string]any{"lpath": "a.epub", "title": "Dune", "last_modified": "2026-03-01T12:00:00+00:00"}})
+	c.send(16, map[string]any{"index": 1, "data": map[string]any{"lpath": "b.epub", "title": "Emma!", "last_modified": "2026-03-02T12:00:00+00:00"}})
+
+	c.send(8, map[string]any{"lpath": "new.epub", "length": 5, "metadata": map[string]any{"title": "New"}, "wantsSendOkToSendbook": true, "willStreamBinary": true})
+	var sendOK struct{ Lpath string }
+	if op := c.receive(&sendOK); op != 0 || sendOK.Lpath != "new.epub" {
+		t.Fatalf("send book %d %v", op, sendOK)
+	}
+	c.conn.Write([]byte("hello"))
+
+	var segment struct{ FileLength int }
+	c.send(14, map[string]any{"lpath": "a.epub", "position": 0})
+	c.receive(&segment)
+	content := make([]byte, segment.FileLength)
+	if _, err := io.ReadFull(c.r, content); err != nil || !bytes.Equal(content, []byte("dune content")) {
+		t.Fatalf("file %q %v", content, err)
+	}
+
+	var deleted struct{ UUID string }
+	c.send(13, map[string]any{"lpaths": []string{"a.epub"}})
+	c.receive(nil)
+	c.receive(&deleted)
+	if deleted.UUID != "a" {
+		t.Fatalf("deleted %v", deleted)
+	}
+
+	c.send(12, map[string]any{"ejecting": true})
+	c.receive(nil)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if len(lib.updated) != 1 || lib.updated[0] != "b.epub Emma!" {
+		t.Errorf("updated %v", lib.updated)
+	}
+	if lib.files["new.epub"] != "hello" {
+		t.Errorf("received %q", lib.files["new.epub"])
+	}
+	if len(lib.deleted) != 1 || lib.deleted[0] != "a.epub" {
+		t.Errorf("deleted %v", lib.deleted)
+	}
+}
@@ -0,0 +1,150 @@
+// Package calibre speaks the smart device protocol of Calibre, the one of
+// its "Connect to/share > Start wireless device connection" menu. The
+// library connects to Calibre as an e-reader would: Calibre sends books and
+// metadata to it, reads its book list and fetches files from it.
+package calibre
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Opcodes of the protocol, every message is [opcode, arguments].
+const (
+	opOK                    = 0
+	opSetCalibreDeviceInfo  = 1
+	opSetCalibreDeviceName  = 2
+	opGetDeviceInformation  = 3
+	opTotalSpace            = 4
+	opFreeSpace             = 5
+	opGetBookCount          = 6
+	opSendBooklists         = 7
+	opSendBook              = 8
+	opGetInitializationInfo = 9
+	opBookDone              = 11
+	opNoop                  = 12
+	opDeleteBook            = 13
+	opGetBookFileSegment    = 14
+	opGetBookMetadata       = 15
+	opSendBookMetadata      = 16
+	opDisplayMessage        = 17
+	opCalibreBusy           = 18
+	opSetLibraryInfo        = 19
+	opError                 = 20
+)
+
+// maxMessageLength bounds the JSON messages, book files are sent apart.
+const maxMessageLength = 64 << 20
+
+var ErrMessage = errors.New("malformed calibre message")
+
+// Time is a timestamp as Calibre writes them, ISO 8601 with the offset.
+// Calibre sends null or year 101 for unknown dates, both are the zero time.
+type Time struct {
+	time.Time
+}
+
+func (t Time) MarshalJSON() ([]byte, error) {
+	if t.IsZero() {
+		return []byte("null"), nil
+	}
+	return json.Marshal(t.UTC().Format("2006-01-02T15:04:05.000000+00:00"))
+}
+
+func (t *Time) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil || s == "" {
+		t.Time = time.Time{}
+		return nil
+	}
+	parsed, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil {
+		parsed, err = time.Parse("2006-01-02T15:04:05.999999", s)
+	}
+	if err != nil || parsed.Year() <= 101 {
+		t.Time = time.Time{}
+		return nil
+	}
+	t.Time = parsed
+	return nil
+}
+
+// Metadata is the part of Calibre's book metadata the library keeps. Lpath
+// is the path of the book on the device, it names the book in every call.
+type Metadata struct {
+	Lpath        string            `json:"lpath"`
+	UUID         string            `json:"uuid"`
+	LastModified Time              `json:"last_modified"`
+	Size         int64             `json:"size"`
+	Title        string            `json:"title"`
+	Authors      []string          `json:"authors"`
+	Publisher    string            `json:"publisher"`
+	Pubdate      Time              `json:"pubdate"`
+	Series       string            `json:"series"`
+	SeriesIndex  float64           `json:"series_index"`
+	Tags         []string          `json:"tags"`
+	Languages    []string          `json:"languages"`
+	Comments     string            `json:"comments"`
+	Identifiers  map[string]string `json:"identifiers"`
+}
+
+// bookListEntry is what the device sends for each book before Calibre asks
+// for the metadata it has not cached.
+type bookListEntry struct {
+	PriKey       int    `json:"priKey"`
+	UUID         string `json:"uuid"`
+	Lpath        string `json:"lpath"`
+	LastModified Time   `json:"last_modified"`
+	Extension    string `json:"extension"`
+}
+
+// readMessage reads one message, a decimal length then the JSON array.
+func readMessage(r *bufio.Reader) (int, json.RawMessage, error) {
+	prefix, err := r.ReadString('[')
+	if err != nil {
+		return 0, nil, err
+	}
+	length, err := strconv.Atoi(strings.TrimSpace(prefix[:len(prefix)-1]))
+	if err != nil || length < 2 || length > maxMessageLength {
+		return 0, nil, fmt.Errorf("%w: length %q", ErrMessage, prefix)
+	}
+	body := make([]byte, length)
+	body[0] = '['
+	if _, err = io.ReadFull(r, body[1:]); err != nil {
+		return 0, nil, err
+	}
+	var message []json.RawMessage
+	if err = json.Unmarshal(body, &message); err != nil || len(message) != 2 {
+		return 0, nil, fmt.Errorf("%w: %s", ErrMessage, shorten(body))
+	}
+	var opcode int
+	if err = json.Unmarshal(message[0], &opcode); err != nil {
+		return 0, nil, fmt.Errorf("%w: opcode %s", ErrMessage, message[0])
+	}
+	return opcode, message[1], nil
+}
+
+func writeMessage(w io.Writer, opcode int, arg any) error {
+	if arg == nil {
+		arg = struct{}{}
+	}
+	body, err := json.Marshal([]any{opcode, arg})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(w, strconv.Itoa(len(body))+string(body))
+	return err
+}
+
+func shorten(body []byte) string {
+	if len(body) > 80 {
+		return string(body[:80]) + "…"
+	}
+	return string(body)
+}
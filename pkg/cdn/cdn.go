@@ -0,0 +1,115 @@
+// Package cdn links to files a CDN serves from the book storage, so
+// downloads and covers do not pass through the server. Links are signed
+// with a CloudFront key pair (canned policy) when one is configured.
+package cdn
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type Signer struct {
+	base      *url.URL
+	keyPairID string
+	key       *rsa.PrivateKey
+	ttl       time.Duration
+	now       func() time.Time
+}
+
+// New links to files under baseURL. Without privateKeyPEM the links are
+// not signed, for a CDN that serves the files publicly.
+func New(baseURL, keyPairID string, privateKeyPEM []byte, ttl time.Duration) (*Signer, error) {
+	base, err := url.Parse(strings.TrimSuffix(baseURL, "/"))
+	if err != nil || base.Scheme == "" || base.Host == "" {
+		return nil, fmt.Errorf("cdn url %q is not an absolute url", baseURL)
+	}
+	if ttl <= 0 {
+		return nil, errors.New("cdn link ttl must be positive")
+	}
+	s := &Signer{base: base, ttl: ttl, now: time.Now}
+	if len(privateKeyPEM) == 0 {
+		return s, nil
+	}
+	if keyPairID == "" {
+		return nil, errors.New("cdn key pair id is required to sign links")
+	}
+	s.keyPairID = keyPairID
+	if s.key, err = parsePrivateKey(privateKeyPEM); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+func parsePrivateKey(data []byte) (*rsa.PrivateKey, error) {
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("cdn private key is not PEM encoded")
+	}
+	if key, err := x509.ParsePKCS1PrivateKey(block.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("cdn private key: %w", err)
+	}
+	rsaKey, ok := key.(*rsa.PrivateKey)
+	if !ok {
+		return nil, errors.New("cdn private key is not an RSA key")
+	}
+	return rsaKey, nil
+}
+
+// URL links to the file at the storage path p. Signed links expire between
+// one and two ttl from now: within a ttl window a file keeps one link, so
+// browsers and the CDN can cache it.
+func (s *Signer) URL(p string) string {
+	segments := strings.Split(strings.TrimPrefix(p, "/"), "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	link := s.base.String() + "/" + strings.Join(segments, "/")
+	if s.key == nil {
+		return link
+	}
+
+	expires := s.now().Truncate(s.ttl).Add(2 * s.ttl).Unix()
+	signature, err := s.sign(cannedPolicy(link, expires))
+	if err != nil {
+		return link
+	}
+	q := url.Values{
+		"Expires":     {strconv.FormatInt(expires, 10)},
+		"Signature":   {signature},
+		"Key-Pair-Id": {s.keyPairID},
+	}
+	return link + "?" + q.Encode()
+}
+
+// cannedPolicy grants access to resource until expires, CloudFront
+// compares it byte for byte.
+func cannedPolicy(resource string, expires int64) string {
+	return `{"Statement":[{"Resource":"` + resource + `","Condition":{"DateLessThan":{"AWS:EpochTime":` + strconv.FormatInt(expires, 10) + `}}}]}`
+}
+
+// cloudFrontEncoding replaces the characters of base64 that are not safe
+// in a query string the way CloudFront expects.
+var cloudFrontEncoding = strings.NewReplacer("+", "-", "=", "_", "/", "~")
+
+func (s *Signer) sign(policy string) (string, error) {
+	digest := sha1.Sum([]byte(policy))
+	signature, err := rsa.SignPKCS1v15(nil, s.key, crypto.SHA1, digest[:])
+	if err != nil {
+		return "", err
+	}
+	return cloudFrontEncoding.Replace(base64.StdEncoding.EncodeToString(signature)), nil
+}
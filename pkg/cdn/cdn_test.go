@@ -0,0 +1,85 @@
+package cdn_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/cdn"
+)
+
+func TestUnsignedURL(t *testing.T) {
+	s, err := cdn.New("https://cdn.example.com/books/", "", nil, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	got := s.URL("Le Guin/The Dispossessed (1974).epub")
+	want := "https://cdn.example.com/books/Le%20Guin/The%20Dispossessed%20%281974%29.epub"
+	if got != want {
+		t.Errorf("expected %q, got %q", want, got)
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	s, err := cdn.New("https://d111111abcdef8.cloudfront.net", "K2JCJMDEHXQW5F", keyPEM, time.Hour)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	link := s.URL("2026/03/16/1.epub")
+	if link != s.URL("2026/03/16/1.epub") {
+		t.Errorf("expected one link within a ttl window")
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	q := u.Query()
+	if q.Get("Key-Pair-Id") != "K2JCJMDEHXQW5F" {
+		t.Errorf("unexpected key pair id %q", q.Get("Key-Pair-Id"))
+	}
+	expires, err := strconv.ParseInt(q.Get("Expires"), 10, 64)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if left := time.Until(time.Unix(expires, 0)); left < time.Hour-time.Minute || left > 2*time.Hour {
+		t.Errorf("expected the link to expire in one to two hours, got %v", left)
+	}
+
+	resource := "https://d111111abcdef8.cloudfront.net/2026/03/16/1.epub"
+	policy := `{"Statement":[{"Resource":"` + resource + `","Condition":{"DateLessThan":{"AWS:EpochTime":` + q.Get("Expires") + `}}}]}`
+	signature, err := base64.StdEncoding.DecodeString(strings.NewReplacer("-", "+", "_", "=", "~", "/").Replace(q.Get("Signature")))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	digest := sha1.Sum([]byte(policy))
+	if err := rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA1, digest[:], signature); err != nil {
+		t.Errorf("signature does not verify: %v", err)
+	}
+}
+
+func TestNewRejectsInvalidConfig(t *testing.T) {
+	if _, err := cdn.New("cdn.example.com", "", nil, time.Hour); err == nil {
+		t.Errorf("expected an error for a relative url")
+	}
+	if _, err := cdn.New("https://cdn.example.com", "", []byte("not a key"), time.Hour); err == nil {
+		t.Errorf("expected an error for a key pair id missing")
+	}
+	if _, err := cdn.New("https://cdn.example.com", "K1", []byte("not a key"), time.Hour); err == nil {
+		t.Errorf("expected an error for a key that is not PEM")
+	}
+}
@@ -0,0 +1,134 @@
+// Package comic reads CBZ and CBR comic archives: the page images in
+// reading order and the ComicInfo.xml metadata. CBR archives are read with
+// the unrar command line tool.
+package comic
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+var (
+	ErrUnsupportedFormat = errors.New("not a comic archive")
+	ErrPageNotFound      = errors.New("page not found")
+)
+
+// Archive is an opened comic archive.
+type Archive interface {
+	// Files lists every file in the archive.
+	Files() []string
+	// Pages lists the page images in reading order.
+	Pages() []string
+	// ReadFile returns the content of a file in the archive.
+	ReadFile(ctx context.Context, name string) ([]byte, error)
+	Close() error
+}
+
+// Reader opens comic archives.
+type Reader struct {
+	unrar string
+}
+
+// New - unrar is the executable used for CBR archives, looked up in PATH
+// when empty.
+func New(unrar string) *Reader {
+	if unrar == "" {
+		unrar = "unrar"
+	}
+	return &Reader{unrar: unrar}
+}
+
+// Open opens file as a comic archive of format "cbz" or "cbr". A CBR file
+// has to stay on disk under its name while the archive is used.
+func (r *Reader) Open(ctx context.Context, file *os.File, format string) (Archive, error) {
+	switch format {
+	case "cbz":
+		return openZip(file)
+	case "cbr":
+		return openRar(ctx, r.unrar, file.Name())
+	default:
+		return nil, fmt.Errorf("comic - Open - %s: %w", format, ErrUnsupportedFormat)
+	}
+}
+
+// Page returns the image of page n, counted from 1.
+func Page(ctx context.Context, a Archive, n int) (string, []byte, error) {
+	pages := a.Pages()
+	if n < 1 || n > len(pages) {
+		return "", nil, ErrPageNotFound
+	}
+	data, err := a.ReadFile(ctx, pages[n-1])
+	if err != nil {
+		return "", nil, fmt.Errorf("comic - Page - %s: %w", pages[n-1], err)
+	}
+	return pages[n-1], data, nil
+}
+
+var imageExtensions = map[string]bool{
+	".jpg": true, ".jpeg": true, ".png": true, ".gif": true, ".webp": true, ".avif": true, ".bmp": true,
+}
+
+// IsImage reports whether name looks like a page image. Hidden files and
+// the resource forks macOS adds to archives are skipped.
+func IsImage(name string) bool {
+	if strings.HasPrefix(name, "__MACOSX/") || strings.HasPrefix(path.Base(name), ".") {
+		return false
+	}
+	return imageExtensions[strings.ToLower(path.Ext(name))]
+}
+
+// pages keeps the images of names and sorts them the way a reader expects,
+// "page2" before "page10".
+func pages(names []string) []string {
+	images := make([]string, 0, len(names))
+	for _, name := range names {
+		if IsImage(name) {
+			images = append(images, name)
+		}
+	}
+	sort.SliceStable(images, func(i, j int) bool { return naturalLess(images[i], images[j]) })
+	return images
+}
+
+// naturalLess compares runs of digits by their value and everything else
+// case insensitively.
+func naturalLess(a, b string) bool {
+	ra, rb := []rune(a), []rune(b)
+	i, j := 0, 0
+	for i < len(ra) && j < len(rb) {
+		if unicode.IsDigit(ra[i]) && unicode.IsDigit(rb[j]) {
+			si, sj := i, j
+			for i < len(ra) && unicode.IsDigit(ra[i]) {
+				i++
+			}
+			for j < len(rb) && unicode.IsDigit(rb[j]) {
+				j++
+			}
+			na := strings.TrimLeft(string(ra[si:i]), "0")
+			nb := strings.TrimLeft(string(rb[sj:j]), "0")
+			if len(na) != len(nb) {
+				return len(na) < len(nb)
+			}
+			if na != nb {
+				return na < nb
+			}
+			continue
+		}
+		ca, cb := unicode.ToLower(ra[i]), unicode.ToLower(rb[j])
+		if ca != cb {
+			return ca < cb
+		}
+		i++
+		j++
+	}
+	if len(ra)-i != len(rb)-j {
+		return len(ra)-i < len(rb)-j
+	}
+	return a < b
+}
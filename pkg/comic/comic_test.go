@@ -0,0 +1,114 @@
+package comic_test
+
+import (
+	"archive/zip"
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/comic"
+)
+
+func writeCbz(t *testing.T, files map[string]string) *os.File {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "comic-*.cbz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { file.Close() })
+	w := zip.NewWriter(file)
+	for name, content := range files {
+		f, err := w.Create(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err = f.Write([]byte(content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestCbzPagesAndComicInfo(t *testing.T) {
+	ctx := context.Background()
+	file := writeCbz(t, map[string]string{
+		"Issue 1/page10.jpg":           "ten",
+		"Issue 1/page2.jpg":            "two",
+		"Issue 1/Page1.png":            "one",
+		"Issue 1/.DS_Store":            "",
+		"__MACOSX/Issue 1/._page2.jpg": "",
+		"Issue 1/ComicInfo.xml":        "not this one",
+		"ComicInfo.xml":                comicInfo,
+	})
+
+	archive, err := comic.New("").Open(ctx, file, "cbz")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer archive.Close()
+
+	want := []string{"Issue 1/Page1.png", "Issue 1/page2.jpg", "Issue 1/page10.jpg"}
+	if got := archive.Pages(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected pages %v, got %v", want, got)
+	}
+
+	info, err := comic.ReadInfo(ctx, archive)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if info.Title != "The Beginning" || info.Series != "Saga" || info.Number != "1" || info.Writer != "Brian K. Vaughan" {
+		t.Fatalf("unexpected info: %+v", info)
+	}
+	if info.Date() != "2012-03" || info.LanguageISO != "en" {
+		t.Fatalf("unexpected date or language: %q %q", info.Date(), info.LanguageISO)
+	}
+
+	cover, err := comic.Cover(ctx, archive, info)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if string(cover) != "two" {
+		t.Fatalf("expected the front cover page, got %q", cover)
+	}
+
+	if _, _, err = comic.Page(ctx, archive, 4); err != comic.ErrPageNotFound {
+		t.Fatalf("expected ErrPageNotFound past the last page, got %v", err)
+	}
+}
+
+func TestCoverDefaultsToFirstPage(t *testing.T) {
+	ctx := context.Background()
+	file := writeCbz(t, map[string]string{"b.jpg": "second", "a.jpg": "first"})
+
+	archive, err := comic.New("").Open(ctx, file, "cbz")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	info, err := comic.ReadInfo(ctx, archive)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	cover, err := comic.Cover(ctx, archive, info)
+	if err != nil || string(cover) != "first" {
+		t.Fatalf("expected the first page as cover, got %q, %v", cover, err)
+	}
+}
+
+const comicInfo = `<?xml version="1.0"?>
+<ComicInfo>
+  <Title>The Beginning</Title>
+  <Series>Saga</Series>
+  <Number>1</Number>
+  <Writer>Brian K. Vaughan</Writer>
+  <Year>2012</Year>
+  <Month>3</Month>
+  <LanguageISO>en</LanguageISO>
+  <Pages>
+    <Page Image="0" />
+    <Page Image="1" Type="FrontCover" />
+  </Pages>
+</ComicInfo>`
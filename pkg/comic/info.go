@@ -0,0 +1,91 @@
+package comic
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"path"
+	"strings"
+)
+
+// Info is the part of ComicInfo.xml, the ComicRack metadata most comic
+// tools write, the library keeps.
+type Info struct {
+	Title       string `xml:"Title"`
+	Series      string `xml:"Series"`
+	Number      string `xml:"Number"`
+	Summary     string `xml:"Summary"`
+	Year        int    `xml:"Year"`
+	Month       int    `xml:"Month"`
+	Day         int    `xml:"Day"`
+	Writer      string `xml:"Writer"`
+	Publisher   string `xml:"Publisher"`
+	LanguageISO string `xml:"LanguageISO"`
+	GTIN        string `xml:"GTIN"`
+	PageCount   int    `xml:"PageCount"`
+	Pages       []struct {
+		Image int    `xml:"Image,attr"`
+		Type  string `xml:"Type,attr"`
+	} `xml:"Pages>Page"`
+}
+
+// Date formats the publication date as far as it is known.
+func (i Info) Date() string {
+	switch {
+	case i.Year == 0:
+		return ""
+	case i.Month == 0:
+		return fmt.Sprintf("%04d", i.Year)
+	case i.Day == 0:
+		return fmt.Sprintf("%04d-%02d", i.Year, i.Month)
+	default:
+		return fmt.Sprintf("%04d-%02d-%02d", i.Year, i.Month, i.Day)
+	}
+}
+
+// ReadInfo parses the ComicInfo.xml of the archive, the zero Info is
+// returned when there is none.
+func ReadInfo(ctx context.Context, a Archive) (Info, error) {
+	var info Info
+	name := ""
+	for _, file := range a.Files() {
+		if !strings.EqualFold(path.Base(file), "ComicInfo.xml") {
+			continue
+		}
+		// archives often wrap the pages in one folder, the top one wins
+		if name == "" || strings.Count(file, "/") < strings.Count(name, "/") {
+			name = file
+		}
+	}
+	if name == "" {
+		return info, nil
+	}
+	data, err := a.ReadFile(ctx, name)
+	if err != nil {
+		return info, fmt.Errorf("comic - ReadInfo - %w", err)
+	}
+	if err = xml.Unmarshal(data, &info); err != nil {
+		return info, fmt.Errorf("comic - ReadInfo - xml.Unmarshal: %w", err)
+	}
+	return info, nil
+}
+
+// Cover returns the image of the page ComicInfo.xml marks as front cover,
+// otherwise of the first page.
+func Cover(ctx context.Context, a Archive, info Info) ([]byte, error) {
+	n := 1
+	for _, page := range info.Pages {
+		if page.Type == "FrontCover" {
+			n = page.Image + 1
+			break
+		}
+	}
+	_, data, err := Page(ctx, a, n)
+	if err == ErrPageNotFound && n != 1 {
+		_, data, err = Page(ctx, a, 1)
+	}
+	if err == ErrPageNotFound {
+		return nil, nil
+	}
+	return data, err
+}
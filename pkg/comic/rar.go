@@ -0,0 +1,71 @@
+package comic
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+var ErrNotInstalled = errors.New("unrar is not installed")
+
+// rarArchive runs unrar for every read, it keeps only the file listing.
+type rarArchive struct {
+	binary string
+	path   string
+	files  map[string]bool
+	names  []string
+	pages  []string
+}
+
+func openRar(ctx context.Context, unrar, path string) (*rarArchive, error) {
+	binary, err := exec.LookPath(unrar)
+	if err != nil {
+		return nil, ErrNotInstalled
+	}
+	// lb lists bare names, -p- never waits for a password
+	out, err := exec.CommandContext(ctx, binary, "lb", "-p-", "--", path).Output()
+	if err != nil {
+		return nil, fmt.Errorf("comic - openRar - %s lb: %w", unrar, err)
+	}
+
+	a := &rarArchive{binary: binary, path: path, files: make(map[string]bool)}
+	for _, line := range strings.Split(string(out), "\n") {
+		name := strings.ReplaceAll(strings.TrimRight(line, "\r"), `\`, "/")
+		if name == "" {
+			continue
+		}
+		a.files[name] = true
+		a.names = append(a.names, name)
+	}
+	a.pages = pages(a.names)
+	return a, nil
+}
+
+func (a *rarArchive) Files() []string {
+	return a.names
+}
+
+func (a *rarArchive) Pages() []string {
+	return a.pages
+}
+
+func (a *rarArchive) ReadFile(ctx context.Context, name string) ([]byte, error) {
+	if !a.files[name] {
+		return nil, ErrPageNotFound
+	}
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, a.binary, "p", "-inul", "-p-", "--", a.path, name)
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("comic - ReadFile - unrar p: %w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return out, nil
+}
+
+func (a *rarArchive) Close() error {
+	return nil
+}
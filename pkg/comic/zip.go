@@ -0,0 +1,65 @@
+package comic
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io"
+	"os"
+)
+
+type zipArchive struct {
+	files map[string]*zip.File
+	names []string
+	pages []string
+}
+
+func openZip(file *os.File) (*zipArchive, error) {
+	info, err := file.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("comic - openZip - file.Stat: %w", err)
+	}
+	r, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return nil, fmt.Errorf("comic - openZip - zip.NewReader: %w", err)
+	}
+
+	a := &zipArchive{files: make(map[string]*zip.File, len(r.File))}
+	names := make([]string, 0, len(r.File))
+	for _, f := range r.File {
+		if f.FileInfo().IsDir() {
+			continue
+		}
+		a.files[f.Name] = f
+		names = append(names, f.Name)
+	}
+	a.names = names
+	a.pages = pages(names)
+	return a, nil
+}
+
+func (a *zipArchive) Files() []string {
+	return a.names
+}
+
+func (a *zipArchive) Pages() []string {
+	return a.pages
+}
+
+func (a *zipArchive) ReadFile(_ context.Context, name string) ([]byte, error) {
+	f, ok := a.files[name]
+	if !ok {
+		return nil, ErrPageNotFound
+	}
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return io.ReadAll(rc)
+}
+
+// Close - the file belongs to the caller.
+func (a *zipArchive) Close() error {
+	return nil
+}
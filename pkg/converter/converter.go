@@ -0,0 +1,62 @@
+// Package converter wraps calibre's ebook-convert command line tool.
+package converter
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+var ErrNotInstalled = errors.New("ebook-convert is not installed")
+
+// Calibre -.
+type Calibre struct {
+	binary string
+}
+
+// New - binary is the ebook-convert executable, looked up in PATH when empty.
+func New(binary string) *Calibre {
+	if binary == "" {
+		binary = "ebook-convert"
+	}
+	return &Calibre{binary: binary}
+}
+
+// Convert converts source into format and returns the path of a temporary
+// file. The caller removes it.
+func (c *Calibre) Convert(ctx context.Context, source, format string) (string, error) {
+	binary, err := exec.LookPath(c.binary)
+	if err != nil {
+		return "", ErrNotInstalled
+	}
+
+	// ebook-convert picks the output format from the extension
+	tmp, err := os.CreateTemp("", "convert-*."+format)
+	if err != nil {
+		return "", fmt.Errorf("converter - Convert - os.CreateTemp: %w", err)
+	}
+	target := tmp.Name()
+	tmp.Close()
+
+	out, err := exec.CommandContext(ctx, binary, source, target).CombinedOutput()
+	if err != nil {
+		os.Remove(target)
+		return "", fmt.Errorf("converter - Convert - %s: %w: %s", c.binary, err, lastLine(out))
+	}
+
+	return target, nil
+}
+
+func lastLine(out []byte) string {
+	end := len(out)
+	for end > 0 && (out[end-1] == '\n' || out[end-1] == '\r') {
+		end--
+	}
+	start := end
+	for start > 0 && out[start-1] != '\n' {
+		start--
+	}
+	return string(out[start:end])
+}
@@ -0,0 +1,160 @@
+// Package diskcache keeps generated files in a directory up to a size
+// limit, evicting the least recently used ones.
+package diskcache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	name string
+	size int64
+}
+
+type Cache struct {
+	dir      string
+	maxBytes int64
+
+	mu    sync.Mutex
+	ll    *list.List // front is the most recently used
+	items map[string]*list.Element
+	size  int64
+}
+
+// New opens the cache directory. Files left by a previous run are kept,
+// ordered by modification time, which Get refreshes.
+func New(dir string, maxBytes int64) (*Cache, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("diskcache - New - os.MkdirAll: %w", err)
+	}
+	c := &Cache{dir: dir, maxBytes: maxBytes, ll: list.New(), items: make(map[string]*list.Element)}
+
+	dirEntries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, fmt.Errorf("diskcache - New - os.ReadDir: %w", err)
+	}
+	type existing struct {
+		entry
+		modTime time.Time
+	}
+	files := make([]existing, 0, len(dirEntries))
+	for _, de := range dirEntries {
+		info, err := de.Info()
+		if err != nil || !info.Mode().IsRegular() {
+			continue
+		}
+		if filepath.Ext(de.Name()) == ".tmp" {
+			_ = os.Remove(filepath.Join(dir, de.Name()))
+			continue
+		}
+		files = append(files, existing{entry{de.Name(), info.Size()}, info.ModTime()})
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].modTime.After(files[j].modTime) })
+	for _, f := range files {
+		c.items[f.name] = c.ll.PushBack(&f.entry)
+		c.size += f.size
+	}
+	c.mu.Lock()
+	c.evict()
+	c.mu.Unlock()
+	return c, nil
+}
+
+// Get opens the cached file for key, the caller closes it.
+func (c *Cache) Get(key string) (*os.File, bool) {
+	name := fileName(key)
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	el, ok := c.items[name]
+	if !ok {
+		return nil, false
+	}
+	file, err := os.Open(filepath.Join(c.dir, name))
+	if err != nil {
+		c.remove(el)
+		return nil, false
+	}
+	c.ll.MoveToFront(el)
+	now := time.Now()
+	_ = os.Chtimes(file.Name(), now, now)
+	return file, true
+}
+
+// Put stores data under key and returns it opened for reading.
+func (c *Cache) Put(key string, data []byte) (*os.File, error) {
+	name := fileName(key)
+	path := filepath.Join(c.dir, name)
+
+	tmp, err := os.CreateTemp(c.dir, name+"-*.tmp")
+	if err != nil {
+		return nil, fmt.Errorf("diskcache - Put - os.CreateTemp: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	if _, err = tmp.Write(data); err != nil {
+		tmp.Close()
+		return nil, fmt.Errorf("diskcache - Put - tmp.Write: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return nil, fmt.Errorf("diskcache - Put - tmp.Close: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		return nil, fmt.Errorf("diskcache - Put - os.Rename: %w", err)
+	}
+	if el, ok := c.items[name]; ok {
+		c.size -= el.Value.(*entry).size
+		c.ll.Remove(el)
+	}
+	c.items[name] = c.ll.PushFront(&entry{name, int64(len(data))})
+	c.size += int64(len(data))
+
+	// open before evicting, a file larger than the cache is still served once
+	file, err := os.Open(path)
+	c.evict()
+	if err != nil {
+		return nil, fmt.Errorf("diskcache - Put - os.Open: %w", err)
+	}
+	return file, nil
+}
+
+// Size is the total size of cached files in bytes.
+func (c *Cache) Size() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.size
+}
+
+func (c *Cache) evict() {
+	for c.size > c.maxBytes {
+		el := c.ll.Back()
+		if el == nil {
+			return
+		}
+		c.remove(el)
+	}
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	c.ll.Remove(el)
+	delete(c.items, e.name)
+	c.size -= e.size
+	_ = os.Remove(filepath.Join(c.dir, e.name))
+}
+
+// fileName hashes keys, they may contain anything.
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
@@ -0,0 +1,58 @@
+package diskcache_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/diskcache"
+)
+
+func TestCacheEvictsLeastRecentlyUsed(t *testing.T) {
+	dir := t.TempDir()
+	cache, err := diskcache.New(dir, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	for _, key := range []string{"a", "b"} {
+		file, err := cache.Put(key, []byte("1234"))
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		file.Close()
+	}
+	file, ok := cache.Get("a")
+	if !ok {
+		t.Fatal("expected a to be cached")
+	}
+	data, _ := io.ReadAll(file)
+	file.Close()
+	if string(data) != "1234" {
+		t.Errorf("unexpected content %q", data)
+	}
+
+	file, err = cache.Put("c", []byte("1234"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	file.Close()
+	if _, ok = cache.Get("b"); ok {
+		t.Error("expected b to be evicted")
+	}
+	if cache.Size() != 8 {
+		t.Errorf("expected 8 bytes, got %d", cache.Size())
+	}
+
+	reopened, err := diskcache.New(dir, 10)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	for _, key := range []string{"a", "c"} {
+		file, ok := reopened.Get(key)
+		if !ok {
+			t.Errorf("expected %s to survive a restart", key)
+			continue
+		}
+		file.Close()
+	}
+}
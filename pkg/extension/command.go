@@ -0,0 +1,87 @@
+package extension
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+// Events commands run on.
+const (
+	EventBookAdded    = "book.added"
+	EventBookFinished = "book.finished"
+)
+
+// Event is written as JSON to the stdin of a command. The event, username
+// and book are also in its environment as KOMPANION_EVENT,
+// KOMPANION_USERNAME and KOMPANION_BOOK_ID, _TITLE, _AUTHOR, _ISBN,
+// _FORMAT and so on.
+type Event struct {
+	Event    string `json:"event"`
+	Username string `json:"username,omitempty"`
+	Book     Book   `json:"book"`
+}
+
+func (e Event) env() []string {
+	return []string{
+		"KOMPANION_EVENT=" + e.Event,
+		"KOMPANION_USERNAME=" + e.Username,
+		"KOMPANION_BOOK_ID=" + e.Book.ID,
+		"KOMPANION_BOOK_TITLE=" + e.Book.Title,
+		"KOMPANION_BOOK_AUTHOR=" + e.Book.Author,
+		"KOMPANION_BOOK_PUBLISHER=" + e.Book.Publisher,
+		"KOMPANION_BOOK_YEAR=" + strconv.Itoa(e.Book.Year),
+		"KOMPANION_BOOK_ISBN=" + e.Book.ISBN,
+		"KOMPANION_BOOK_SERIES=" + e.Book.Series,
+		"KOMPANION_BOOK_LANGUAGE=" + e.Book.Language,
+		"KOMPANION_BOOK_FORMAT=" + e.Book.Format,
+	}
+}
+
+// Command is a shell command run on an event, unlike a Process it does not
+// answer.
+type Command struct {
+	command string
+	timeout time.Duration
+}
+
+// NewCommand - command is run with sh -c, it may take timeout at most.
+func NewCommand(command string, timeout time.Duration) *Command {
+	return &Command{command: command, timeout: timeout}
+}
+
+func (c *Command) String() string {
+	return c.command
+}
+
+// Run runs the command for event and returns what it wrote to stdout and
+// stderr, also when it failed.
+func (c *Command) Run(ctx context.Context, event Event) ([]byte, error) {
+	input, err := json.Marshal(event)
+	if err != nil {
+		return nil, fmt.Errorf("extension - Run - json.Marshal: %w", err)
+	}
+	if c.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+	}
+
+	var output limitedBuffer
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.command)
+	cmd.Env = append(os.Environ(), event.env()...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+	cmd.WaitDelay = time.Second
+	err = cmd.Run()
+	if err != nil {
+		err = fmt.Errorf("extension - Run - %s: %w", event.Event, err)
+	}
+	return bytes.TrimSpace(output.Bytes()), err
+}
@@ -0,0 +1,44 @@
+package extension_test
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/extension"
+)
+
+func TestCommandRun(t *testing.T) {
+	c := extension.NewCommand(`echo "$KOMPANION_EVENT $KOMPANION_BOOK_TITLE"; cat`, time.Second)
+	output, err := c.Run(context.Background(), extension.Event{
+		Event:    extension.EventBookFinished,
+		Username: "reader",
+		Book:     extension.Book{ID: "book-id", Title: "Dune"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	lines := strings.SplitN(string(output), "\n", 2)
+	if len(lines) != 2 || lines[0] != "book.finished Dune" {
+		t.Fatalf("unexpected output %q", output)
+	}
+	if !strings.Contains(lines[1], `"username":"reader"`) || !strings.Contains(lines[1], `"id":"book-id"`) {
+		t.Fatalf("expected the event as JSON on stdin, got %q", lines[1])
+	}
+}
+
+func TestCommandRunFails(t *testing.T) {
+	output, err := extension.NewCommand("echo broken >&2; exit 1", time.Second).Run(context.Background(), extension.Event{})
+	if err == nil || string(output) != "broken" {
+		t.Fatalf("expected the error with the output, got %q, %v", output, err)
+	}
+
+	start := time.Now()
+	if _, err = extension.NewCommand("sleep 5", 100*time.Millisecond).Run(context.Background(), extension.Event{}); err == nil {
+		t.Fatal("expected the command to time out")
+	}
+	if time.Since(start) > 3*time.Second {
+		t.Fatalf("command ran for %v", time.Since(start))
+	}
+}
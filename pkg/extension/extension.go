@@ -0,0 +1,183 @@
+// Package extension runs external programs that extend the server. The
+// program is started for every call, reads one JSON request from stdin and
+// writes one JSON response to stdout:
+//
+//	{"hook": "post_ingest", "data": {"book": {...}}}
+//	{"data": {...}} or {"error": "message"}
+//
+// Asked for the describe hook, a program names itself and the hooks it
+// handles: {"data": {"name": "notify", "hooks": ["post_ingest"]}}.
+//
+// A Command is simpler, a shell command run on an event that does not
+// answer.
+package extension
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Hooks a program can handle.
+const (
+	HookDescribe = "describe"
+	// HookPostIngest gets an IngestRequest after a book was added.
+	HookPostIngest = "post_ingest"
+	// HookPreDownload gets a DownloadRequest before a book file is handed
+	// out, an error refuses the download.
+	HookPreDownload = "pre_download"
+	// HookMetadata gets a MetadataRequest and answers a MetadataResponse.
+	HookMetadata = "metadata"
+)
+
+// ErrFailed is returned when the program answered an error.
+var ErrFailed = errors.New("extension failed")
+
+// maxOutput keeps a runaway program from filling the memory.
+const maxOutput = 16 << 20
+
+type Book struct {
+	ID        string   `json:"id,omitempty"`
+	Title     string   `json:"title,omitempty"`
+	Author    string   `json:"author,omitempty"`
+	Publisher string   `json:"publisher,omitempty"`
+	Year      int      `json:"year,omitempty"`
+	ISBN      string   `json:"isbn,omitempty"`
+	DOI       string   `json:"doi,omitempty"`
+	Series    string   `json:"series,omitempty"`
+	Language  string   `json:"language,omitempty"`
+	Format    string   `json:"format,omitempty"`
+	Genres    []string `json:"genres,omitempty"`
+	// Description is HTML.
+	Description string `json:"description,omitempty"`
+}
+
+type IngestRequest struct {
+	Book Book `json:"book"`
+}
+
+type DownloadRequest struct {
+	Book   Book   `json:"book"`
+	Format string `json:"format"`
+}
+
+type MetadataRequest struct {
+	ISBN string `json:"isbn"`
+}
+
+type MetadataResponse struct {
+	Book Book `json:"book"`
+	// Cover is the image, base64 encoded in JSON.
+	Cover []byte `json:"cover,omitempty"`
+}
+
+// Description is the answer to the describe hook.
+type Description struct {
+	Name  string   `json:"name"`
+	Hooks []string `json:"hooks"`
+}
+
+// Handles reports whether the program handles hook.
+func (d Description) Handles(hook string) bool {
+	for _, h := range d.Hooks {
+		if h == hook {
+			return true
+		}
+	}
+	return false
+}
+
+type request struct {
+	Hook string      `json:"hook"`
+	Data interface{} `json:"data"`
+}
+
+type response struct {
+	Data  json.RawMessage `json:"data"`
+	Error string          `json:"error"`
+}
+
+// Process is an external program.
+type Process struct {
+	path    string
+	timeout time.Duration
+}
+
+// New - path is the executable, a call may take timeout at most.
+func New(path string, timeout time.Duration) *Process {
+	return &Process{path: path, timeout: timeout}
+}
+
+// Path returns the executable of the program.
+func (p *Process) Path() string {
+	return p.path
+}
+
+// Describe asks the program for its name and hooks.
+func (p *Process) Describe(ctx context.Context) (Description, error) {
+	var d Description
+	if err := p.Call(ctx, HookDescribe, struct{}{}, &d); err != nil {
+		return Description{}, err
+	}
+	if d.Name == "" {
+		return Description{}, fmt.Errorf("extension - Describe - %s: no name", p.path)
+	}
+	return d, nil
+}
+
+// Call runs the program with the hook and in, the data of the response is
+// decoded into out unless it is nil.
+func (p *Process) Call(ctx context.Context, hook string, in, out interface{}) error {
+	input, err := json.Marshal(request{Hook: hook, Data: in})
+	if err != nil {
+		return fmt.Errorf("extension - Call - json.Marshal: %w", err)
+	}
+	if p.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.timeout)
+		defer cancel()
+	}
+
+	var stdout, stderr limitedBuffer
+	cmd := exec.CommandContext(ctx, p.path)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	// children of a killed shell script would keep the output open
+	cmd.WaitDelay = time.Second
+	if err = cmd.Run(); err != nil {
+		return fmt.Errorf("extension - Call - %s %s: %w: %s", p.path, hook, err, strings.TrimSpace(stderr.String()))
+	}
+
+	var resp response
+	if err = json.Unmarshal(stdout.Bytes(), &resp); err != nil {
+		return fmt.Errorf("extension - Call - %s %s: invalid response: %w", p.path, hook, err)
+	}
+	if resp.Error != "" {
+		return fmt.Errorf("%w: %s", ErrFailed, resp.Error)
+	}
+	if out != nil && len(resp.Data) > 0 {
+		if err = json.Unmarshal(resp.Data, out); err != nil {
+			return fmt.Errorf("extension - Call - %s %s: invalid data: %w", p.path, hook, err)
+		}
+	}
+	return nil
+}
+
+// limitedBuffer drops what is written past maxOutput.
+type limitedBuffer struct {
+	bytes.Buffer
+}
+
+func (b *limitedBuffer) Write(p []byte) (int, error) {
+	if left := maxOutput - b.Len(); left < len(p) {
+		b.Buffer.Write(p[:max(left, 0)])
+		return len(p), nil
+	}
+	return b.Buffer.Write(p)
+}
@@ -0,0 +1,78 @@
+package extension_test
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/extension"
+)
+
+// script writes a shell program answering the hooks like an extension.
+func script(t *testing.T, body string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "extension.sh")
+	if err := os.WriteFile(path, []byte("#!/bin/sh\n"+body), 0o755); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return path
+}
+
+const metadataScript = `request=$(cat)
+case "$request" in
+*'"hook":"describe"'*)
+	echo '{"data": {"name": "local", "hooks": ["metadata"]}}' ;;
+*'"isbn":"9780000000002"'*)
+	echo '{"data": {"book": {"title": "Dune", "author": "Frank Herbert", "year": 1965}}}' ;;
+*)
+	echo '{"error": "not found"}' ;;
+esac
+`
+
+func TestDescribe(t *testing.T) {
+	p := extension.New(script(t, metadataScript), time.Second)
+	d, err := p.Describe(context.Background())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if d.Name != "local" || !d.Handles(extension.HookMetadata) || d.Handles(extension.HookPreDownload) {
+		t.Fatalf("unexpected description %+v", d)
+	}
+}
+
+func TestCall(t *testing.T) {
+	p := extension.New(script(t, metadataScript), time.Second)
+
+	var resp extension.MetadataResponse
+	err := p.Call(context.Background(), extension.HookMetadata, extension.MetadataRequest{ISBN: "9780000000002"}, &resp)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if resp.Book.Title != "Dune" || resp.Book.Author != "Frank Herbert" || resp.Book.Year != 1965 {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	err = p.Call(context.Background(), extension.HookMetadata, extension.MetadataRequest{ISBN: "9780000000019"}, &resp)
+	if !errors.Is(err, extension.ErrFailed) {
+		t.Fatalf("expected ErrFailed, got %v", err)
+	}
+}
+
+func TestCallFailures(t *testing.T) {
+	for name, body := range map[string]string{
+		"exit status":  "echo broken >&2; exit 3\n",
+		"invalid json": "echo nonsense\n",
+		"timeout":      "sleep 5\n",
+		"no name":      "echo '{\"data\": {\"hooks\": []}}'\n",
+	} {
+		t.Run(name, func(t *testing.T) {
+			p := extension.New(script(t, body), 200*time.Millisecond)
+			if _, err := p.Describe(context.Background()); err == nil {
+				t.Fatal("expected an error")
+			}
+		})
+	}
+}
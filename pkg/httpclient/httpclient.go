@@ -0,0 +1,68 @@
+// Package httpclient builds the client for requests leaving the server, to
+// metadata providers and other services, behind a proxy and with the
+// certificate authorities of the network when it needs them.
+package httpclient
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+)
+
+const _defaultTimeout = 8 * time.Second
+
+// Options -. Zero values take the defaults.
+type Options struct {
+	// Proxy is an http, https or socks5 URL. Empty follows the
+	// HTTP_PROXY, HTTPS_PROXY and NO_PROXY environment.
+	Proxy string
+	// CAFile is a PEM bundle trusted besides the system roots, for proxies
+	// that inspect TLS.
+	CAFile string
+	// Timeout of a whole request, the body read included.
+	Timeout time.Duration
+}
+
+// New returns a client for opts, an error when the proxy URL or the CA
+// bundle can not be used.
+func New(opts Options) (*http.Client, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+
+	if opts.Proxy != "" {
+		proxy, err := url.Parse(opts.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("httpclient - New - url.Parse: %w", err)
+		}
+		switch proxy.Scheme {
+		case "http", "https", "socks5", "socks5h":
+		default:
+			return nil, fmt.Errorf("httpclient - New - proxy scheme %q is not http, https or socks5", proxy.Scheme)
+		}
+		transport.Proxy = http.ProxyURL(proxy)
+	}
+
+	if opts.CAFile != "" {
+		pem, err := os.ReadFile(opts.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("httpclient - New - os.ReadFile: %w", err)
+		}
+		roots, err := x509.SystemCertPool()
+		if err != nil {
+			roots = x509.NewCertPool()
+		}
+		if !roots.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("httpclient - New - no certificates in %s", opts.CAFile)
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: roots, MinVersion: tls.VersionTLS12}
+	}
+
+	timeout := opts.Timeout
+	if timeout <= 0 {
+		timeout = _defaultTimeout
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}, nil
+}
@@ -0,0 +1,74 @@
+package httpclient_test
+
+import (
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/httpclient"
+)
+
+func TestNewSendsThroughProxy(t *testing.T) {
+	var requested string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requested = r.URL.String()
+	}))
+	defer proxy.Close()
+
+	client, err := httpclient.New(httpclient.Options{Proxy: proxy.URL})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := client.Get("http://metadata.invalid/isbn/9780000000000")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+	if requested != "http://metadata.invalid/isbn/9780000000000" {
+		t.Errorf("proxy got %q", requested)
+	}
+}
+
+func TestNewTrustsCAFile(t *testing.T) {
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	client, err := httpclient.New(httpclient.Options{})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err = client.Get(server.URL); err == nil {
+		t.Fatal("unknown certificate authority trusted")
+	}
+
+	caFile := filepath.Join(t.TempDir(), "ca.pem")
+	cert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+	if err = os.WriteFile(caFile, cert, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	client, err = httpclient.New(httpclient.Options{CAFile: caFile})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp, err := client.Get(server.URL)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	resp.Body.Close()
+}
+
+func TestNewRejectsBadOptions(t *testing.T) {
+	if _, err := httpclient.New(httpclient.Options{Proxy: "ftp://proxy.example.com"}); err == nil {
+		t.Error("expected an error for an ftp proxy")
+	}
+	empty := filepath.Join(t.TempDir(), "empty.pem")
+	if err := os.WriteFile(empty, nil, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := httpclient.New(httpclient.Options{CAFile: empty}); err == nil {
+		t.Error("expected an error for a bundle without certificates")
+	}
+}
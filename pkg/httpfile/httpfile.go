@@ -0,0 +1,68 @@
+// Package httpfile serves stored files with validators, so clients can
+// skip unchanged files and resume interrupted downloads.
+package httpfile
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// NotModified reports whether the client copy, named by If-None-Match or
+// If-Modified-Since, is current. It is checked before opening the file,
+// unchanged files are then never read from storage.
+func NotModified(r *http.Request, etag string, modTime time.Time) bool {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		return false
+	}
+	if inm := r.Header.Get("If-None-Match"); inm != "" {
+		return etag != "" && matchETag(inm, etag)
+	}
+	ims, err := http.ParseTime(r.Header.Get("If-Modified-Since"))
+	if err != nil || modTime.IsZero() {
+		return false
+	}
+	return !modTime.Truncate(time.Second).After(ims)
+}
+
+// WriteNotModified answers a conditional request that NotModified accepted.
+func WriteNotModified(w http.ResponseWriter, etag string, modTime time.Time) {
+	setValidators(w, etag, modTime)
+	w.WriteHeader(http.StatusNotModified)
+}
+
+// Serve sends content with ETag and Last-Modified. Conditional and Range
+// requests, including If-Range on resume, are answered by http.ServeContent.
+func Serve(w http.ResponseWriter, r *http.Request, etag string, modTime time.Time, content io.ReadSeeker) {
+	setValidators(w, etag, modTime)
+	http.ServeContent(w, r, "", modTime, content)
+}
+
+// Resumed reports whether the request continues an earlier download, a
+// Range that does not start at the first byte.
+func Resumed(r *http.Request) bool {
+	rng := r.Header.Get("Range")
+	return rng != "" && !strings.HasPrefix(rng, "bytes=0-")
+}
+
+func setValidators(w http.ResponseWriter, etag string, modTime time.Time) {
+	if etag != "" {
+		w.Header().Set("ETag", etag)
+	}
+	if !modTime.IsZero() {
+		w.Header().Set("Last-Modified", modTime.UTC().Format(http.TimeFormat))
+	}
+}
+
+// matchETag is the weak comparison of If-None-Match.
+func matchETag(header, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,72 @@
+package httpfile_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/httpfile"
+)
+
+var modTime = time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+
+func TestNotModified(t *testing.T) {
+	tests := []struct {
+		name   string
+		header string
+		value  string
+		want   bool
+	}{
+		{"no validators", "", "", false},
+		{"matching etag", "If-None-Match", `"abc"`, true},
+		{"weak etag", "If-None-Match", `W/"abc", "def"`, true},
+		{"other etag", "If-None-Match", `"def"`, false},
+		{"not modified since", "If-Modified-Since", modTime.Format(http.TimeFormat), true},
+		{"modified since", "If-Modified-Since", modTime.Add(-time.Hour).Format(http.TimeFormat), false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/book", nil)
+		if tt.header != "" {
+			req.Header.Set(tt.header, tt.value)
+		}
+		if got := httpfile.NotModified(req, `"abc"`, modTime); got != tt.want {
+			t.Errorf("%s: expected %v, got %v", tt.name, tt.want, got)
+		}
+	}
+}
+
+func TestServeRange(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/book", nil)
+	req.Header.Set("Range", "bytes=4-")
+	req.Header.Set("If-Range", `"abc"`)
+	w := httptest.NewRecorder()
+
+	httpfile.Serve(w, req, `"abc"`, modTime, strings.NewReader("0123456789"))
+
+	if w.Code != http.StatusPartialContent || w.Body.String() != "456789" {
+		t.Fatalf("expected partial content, got %d %q", w.Code, w.Body.String())
+	}
+	if w.Header().Get("ETag") != `"abc"` || w.Header().Get("Last-Modified") == "" {
+		t.Errorf("missing validators: %v", w.Header())
+	}
+
+	// a changed file is sent whole
+	req.Header.Set("If-Range", `"old"`)
+	w = httptest.NewRecorder()
+	httpfile.Serve(w, req, `"abc"`, modTime, strings.NewReader("0123456789"))
+	if w.Code != http.StatusOK || w.Body.String() != "0123456789" {
+		t.Errorf("expected full content, got %d %q", w.Code, w.Body.String())
+	}
+}
+
+func TestResumed(t *testing.T) {
+	for rng, want := range map[string]bool{"": false, "bytes=0-": false, "bytes=0-99": false, "bytes=100-": true} {
+		req := httptest.NewRequest(http.MethodGet, "/book", nil)
+		req.Header.Set("Range", rng)
+		if got := httpfile.Resumed(req); got != want {
+			t.Errorf("Resumed(%q) = %v, want %v", rng, got, want)
+		}
+	}
+}
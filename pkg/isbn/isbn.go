@@ -0,0 +1,113 @@
+// Package isbn validates ISBN-10 and ISBN-13 and writes them in one form,
+// so the same book is not stored under different looking identifiers.
+package isbn
+
+import (
+	"errors"
+	"strings"
+)
+
+var ErrInvalid = errors.New("invalid isbn")
+
+// Clean removes an ISBN or urn:isbn: prefix, hyphens and spaces, and
+// writes the check digit X in upper case. It does not validate.
+func Clean(s string) string {
+	s = strings.TrimPrefix(strings.ToUpper(strings.TrimSpace(s)), "URN:")
+	if rest, ok := strings.CutPrefix(s, "ISBN"); ok {
+		rest = strings.TrimPrefix(strings.TrimPrefix(rest, "-13"), "-10")
+		s = strings.TrimLeft(rest, ": ")
+	}
+	return strings.NewReplacer("-", "", " ", "", "‐", "", "–", "").Replace(s)
+}
+
+// Valid reports whether s is an ISBN-10 or ISBN-13 with a correct check
+// digit, hyphens and spaces allowed.
+func Valid(s string) bool {
+	_, err := Normalize(s)
+	return err == nil
+}
+
+// Normalize returns the ISBN-13 of s without hyphens, ISBN-10 are
+// converted.
+func Normalize(s string) (string, error) {
+	s = Clean(s)
+	switch len(s) {
+	case 10:
+		return To13(s)
+	case 13:
+		if !digits(s) || (!strings.HasPrefix(s, "978") && !strings.HasPrefix(s, "979")) || checkDigit13(s[:12]) != s[12] {
+			return "", ErrInvalid
+		}
+		return s, nil
+	}
+	return "", ErrInvalid
+}
+
+// To13 converts an ISBN-10 to its ISBN-13.
+func To13(isbn10 string) (string, error) {
+	s := Clean(isbn10)
+	if len(s) != 10 || !digits(s[:9]) || checkDigit10(s[:9]) != s[9] {
+		return "", ErrInvalid
+	}
+	isbn13 := "978" + s[:9]
+	return isbn13 + string(checkDigit13(isbn13)), nil
+}
+
+// To10 converts an ISBN-13 starting with 978 to its ISBN-10, the ones
+// starting with 979 have none.
+func To10(isbn13 string) (string, error) {
+	s, err := Normalize(isbn13)
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasPrefix(s, "978") {
+		return "", ErrInvalid
+	}
+	return s[3:12] + string(checkDigit10(s[3:12])), nil
+}
+
+// Forms returns the ways the ISBN of s may be stored, ISBN-13 first and
+// then ISBN-10, or s cleaned when it is not a valid ISBN.
+func Forms(s string) []string {
+	isbn13, err := Normalize(s)
+	if err != nil {
+		return []string{Clean(s)}
+	}
+	if isbn10, err := To10(isbn13); err == nil {
+		return []string{isbn13, isbn10}
+	}
+	return []string{isbn13}
+}
+
+func checkDigit10(first9 string) byte {
+	sum := 0
+	for i := 0; i < 9; i++ {
+		sum += int(first9[i]-'0') * (10 - i)
+	}
+	check := (11 - sum%11) % 11
+	if check == 10 {
+		return 'X'
+	}
+	return byte('0' + check)
+}
+
+func checkDigit13(first12 string) byte {
+	sum := 0
+	for i := 0; i < 12; i++ {
+		weight := 1
+		if i%2 == 1 {
+			weight = 3
+		}
+		sum += int(first12[i]-'0') * weight
+	}
+	return byte('0' + (10-sum%10)%10)
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,58 @@
+package isbn_test
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/isbn"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+		invalid        bool
+	}{
+		{name: "isbn-13 with hyphens", in: "978-0-306-40615-7", want: "9780306406157"},
+		{name: "isbn-10 is converted", in: "0-306-40615-2", want: "9780306406157"},
+		{name: "check digit x", in: "0-8044-2957-x", want: "9780804429573"},
+		{name: "prefix and spaces", in: "ISBN-13: 978 0 306 40615 7", want: "9780306406157"},
+		{name: "979 isbn", in: "979-10-90636-07-1", want: "9791090636071"},
+		{name: "wrong isbn-13 check digit", in: "9780306406158", invalid: true},
+		{name: "wrong isbn-10 check digit", in: "0306406153", invalid: true},
+		{name: "not a bookland prefix", in: "1234567890128", invalid: true},
+		{name: "letters", in: "B00ABCDEFG", invalid: true},
+		{name: "empty", in: "", invalid: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := isbn.Normalize(tt.in)
+			if tt.invalid {
+				if err == nil {
+					t.Fatalf("Normalize(%q) = %q, want an error", tt.in, got)
+				}
+				return
+			}
+			if err != nil || got != tt.want {
+				t.Errorf("Normalize(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+			}
+		})
+	}
+}
+
+func TestTo10(t *testing.T) {
+	if got, err := isbn.To10("978-0-8044-2957-3"); err != nil || got != "080442957X" {
+		t.Errorf("To10 = %q, %v, want 080442957X", got, err)
+	}
+	if _, err := isbn.To10("9791090636071"); err == nil {
+		t.Error("To10 of a 979 isbn should fail")
+	}
+}
+
+func TestForms(t *testing.T) {
+	if got := isbn.Forms("0-306-40615-2"); !slices.Equal(got, []string{"9780306406157", "0306406152"}) {
+		t.Errorf("Forms = %v", got)
+	}
+	if got := isbn.Forms("asin b00-x"); !slices.Equal(got, []string{"ASINB00X"}) {
+		t.Errorf("Forms of an invalid isbn = %v", got)
+	}
+}
@@ -0,0 +1,215 @@
+// Package mailer sends plain text emails with attachments over SMTP.
+package mailer
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/smtp"
+	"net/textproto"
+	"strconv"
+	"time"
+)
+
+const (
+	_defaultTimeout = 30 * time.Second
+	_implicitTLS    = 465
+	_lineLength     = 76
+)
+
+// Attachment -.
+type Attachment struct {
+	Filename    string
+	ContentType string
+	Content     io.Reader
+}
+
+// Message -. HTML is sent as an alternative to the plain text Body when set.
+type Message struct {
+	To          string
+	Subject     string
+	Body        string
+	HTML        string
+	Attachments []Attachment
+}
+
+// SMTP -.
+type SMTP struct {
+	host     string
+	port     int
+	username string
+	password string
+	from     string
+	timeout  time.Duration
+}
+
+// New -.
+func New(host string, port int, username, password, from string) *SMTP {
+	return &SMTP{
+		host:     host,
+		port:     port,
+		username: username,
+		password: password,
+		from:     from,
+		timeout:  _defaultTimeout,
+	}
+}
+
+// Send delivers msg. Port 465 uses implicit TLS, any other port upgrades
+// with STARTTLS when the server offers it.
+func (m *SMTP) Send(ctx context.Context, msg Message) error {
+	body, err := m.compose(msg)
+	if err != nil {
+		return fmt.Errorf("mailer - Send - compose: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, m.timeout)
+	defer cancel()
+
+	addr := net.JoinHostPort(m.host, strconv.Itoa(m.port))
+	dialer := &net.Dialer{}
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return fmt.Errorf("mailer - Send - dial: %w", err)
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	tlsConfig := &tls.Config{ServerName: m.host}
+	if m.port == _implicitTLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("mailer - Send - smtp.NewClient: %w", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok && m.port != _implicitTLS {
+		if err = client.StartTLS(tlsConfig); err != nil {
+			return fmt.Errorf("mailer - Send - StartTLS: %w", err)
+		}
+	}
+	if m.username != "" {
+		if err = client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("mailer - Send - Auth: %w", err)
+		}
+	}
+	if err = client.Mail(m.from); err != nil {
+		return fmt.Errorf("mailer - Send - Mail: %w", err)
+	}
+	if err = client.Rcpt(msg.To); err != nil {
+		return fmt.Errorf("mailer - Send - Rcpt: %w", err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("mailer - Send - Data: %w", err)
+	}
+	if _, err = w.Write(body); err != nil {
+		return fmt.Errorf("mailer - Send - Write: %w", err)
+	}
+	if err = w.Close(); err != nil {
+		return fmt.Errorf("mailer - Send - Close: %w", err)
+	}
+
+	return client.Quit()
+}
+
+func (m *SMTP) compose(msg Message) ([]byte, error) {
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+
+	fmt.Fprintf(&buf, "From: %s\r\n", m.from)
+	fmt.Fprintf(&buf, "To: %s\r\n", msg.To)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", msg.Subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	fmt.Fprintf(&buf, "MIME-Version: 1.0\r\n")
+	fmt.Fprintf(&buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n", mw.Boundary())
+
+	if err := writeText(mw, msg); err != nil {
+		return nil, err
+	}
+
+	for _, a := range msg.Attachments {
+		contentType := a.ContentType
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		part, err := mw.CreatePart(textproto.MIMEHeader{
+			"Content-Type":              {contentType},
+			"Content-Transfer-Encoding": {"base64"},
+			"Content-Disposition":       {mime.FormatMediaType("attachment", map[string]string{"filename": a.Filename})},
+		})
+		if err != nil {
+			return nil, err
+		}
+		content, err := io.ReadAll(a.Content)
+		if err != nil {
+			return nil, err
+		}
+		encoded := base64.StdEncoding.EncodeToString(content)
+		for len(encoded) > _lineLength {
+			if _, err = io.WriteString(part, encoded[:_lineLength]+"\r\n"); err != nil {
+				return nil, err
+			}
+			encoded = encoded[_lineLength:]
+		}
+		if _, err = io.WriteString(part, encoded+"\r\n"); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := mw.Close(); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeText writes the body of msg, with the HTML body in a
+// multipart/alternative part after the plain text when there is one.
+func writeText(mw *multipart.Writer, msg Message) error {
+	if msg.HTML == "" {
+		return writeTextPart(mw, "text/plain", msg.Body)
+	}
+	var buf bytes.Buffer
+	alternative := multipart.NewWriter(&buf)
+	if err := writeTextPart(alternative, "text/plain", msg.Body); err != nil {
+		return err
+	}
+	if err := writeTextPart(alternative, "text/html", msg.HTML); err != nil {
+		return err
+	}
+	if err := alternative.Close(); err != nil {
+		return err
+	}
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type": {"multipart/alternative; boundary=" + alternative.Boundary()},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = part.Write(buf.Bytes())
+	return err
+}
+
+func writeTextPart(mw *multipart.Writer, contentType, text string) error {
+	part, err := mw.CreatePart(textproto.MIMEHeader{
+		"Content-Type":              {contentType + "; charset=utf-8"},
+		"Content-Transfer-Encoding": {"8bit"},
+	})
+	if err != nil {
+		return err
+	}
+	_, err = io.WriteString(part, text)
+	return err
+}
@@ -0,0 +1,49 @@
+package mailer
+
+import (
+	"bytes"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/mail"
+	"testing"
+)
+
+func TestComposeHTMLAlternative(t *testing.T) {
+	m := New("smtp.example.com", 587, "", "", "library@example.com")
+	raw, err := m.compose(Message{To: "reader@example.com", Subject: "New books", Body: "plain", HTML: "<p>html</p>"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	_, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	part, err := multipart.NewReader(msg.Body, params["boundary"]).NextPart()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	mediaType, params, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+	if mediaType != "multipart/alternative" {
+		t.Fatalf("expected the text in an alternative part, got %s", mediaType)
+	}
+
+	alternatives := multipart.NewReader(part, params["boundary"])
+	for _, want := range []struct{ contentType, body string }{
+		{"text/plain; charset=utf-8", "plain"},
+		{"text/html; charset=utf-8", "<p>html</p>"},
+	} {
+		p, err := alternatives.NextPart()
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		body, _ := io.ReadAll(p)
+		if p.Header.Get("Content-Type") != want.contentType || string(body) != want.body {
+			t.Errorf("expected %s %q, got %s %q", want.contentType, want.body, p.Header.Get("Content-Type"), body)
+		}
+	}
+}
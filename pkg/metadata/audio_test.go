@@ -0,0 +1,140 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+func box(typ string, content ...[]byte) []byte {
+	body := bytes.Join(content, nil)
+	b := binary.BigEndian.AppendUint32(nil, uint32(8+len(body)))
+	return append(append(b, typ...), body...)
+}
+
+func u32(v uint32) []byte { return binary.BigEndian.AppendUint32(nil, v) }
+
+func ilstItem(typ string, value []byte) []byte {
+	return box(typ, box("data", u32(1), u32(0), value))
+}
+
+func writeTemp(t *testing.T, content []byte) *os.File {
+	t.Helper()
+	file, err := os.CreateTemp(t.TempDir(), "audio-")
+	require.NoError(t, err)
+	t.Cleanup(func() { file.Close() })
+	_, err = file.Write(content)
+	require.NoError(t, err)
+	return file
+}
+
+func TestExtractM4bMetadata(t *testing.T) {
+	// version 0 mvhd: times, timescale 1000, duration 90 minutes
+	mvhd := box("mvhd", u32(0), u32(0), u32(0), u32(1000), u32(90*60*1000), make([]byte, 80))
+	chpl := box("chpl", []byte{1, 0, 0, 0}, u32(0), []byte{2},
+		binary.BigEndian.AppendUint64(nil, 0), []byte{7}, []byte("Opening"),
+		binary.BigEndian.AppendUint64(nil, uint64(45*time.Minute/100)), []byte{6}, []byte("Ending"))
+	meta := box("meta", u32(0), box("hdlr", make([]byte, 25)), box("ilst",
+		ilstItem("\xa9nam", []byte("The Book")),
+		ilstItem("\xa9ART", []byte("An Author")),
+		ilstItem("\xa9day", []byte("2020")),
+		ilstItem("desc", []byte("What it is about")),
+		ilstItem("covr", []byte("jpeg bytes")),
+	))
+	// mdhd language "eng" packed in 5 bit letters
+	lang := uint32(('e'-0x60)<<10 | ('n'-0x60)<<5 | ('g' - 0x60))
+	trak := box("trak", box("mdia",
+		box("mdhd", u32(0), u32(0), u32(0), u32(44100), u32(0), u32(lang<<16)),
+		box("hdlr", u32(0), u32(0), []byte("soun"), make([]byte, 13)),
+	))
+	file := writeTemp(t, bytes.Join([][]byte{
+		box("ftyp", []byte("M4B "), u32(0), []byte("mp42isom")),
+		box("moov", mvhd, trak, box("udta", chpl, meta)),
+		box("mdat", make([]byte, 1024)),
+	}, nil))
+
+	m, err := ExtractBookMetadata(file)
+	require.NoError(t, err)
+	require.Equal(t, "m4b", m.Format)
+	require.Equal(t, "The Book", m.Title)
+	require.Equal(t, "An Author", m.Author)
+	require.Equal(t, "2020", m.Date)
+	require.Equal(t, "What it is about", m.Description)
+	require.Equal(t, []byte("jpeg bytes"), m.Cover)
+	require.Equal(t, "eng", m.Language)
+	require.Equal(t, 90*time.Minute, m.Duration)
+	require.Equal(t, []Chapter{{"Opening", 0}, {"Ending", 45 * time.Minute}}, m.Chapters)
+}
+
+func TestReadMp4ChapterTrack(t *testing.T) {
+	// two text samples in one chunk at offset 8 of the file
+	samples := append(append([]byte{0, 5}, "Intro"...), append([]byte{0, 4}, "Main"...)...)
+	stbl := box("stbl",
+		box("stts", u32(0), u32(2), u32(1), u32(600*30), u32(1), u32(600*60)),
+		box("stsz", u32(0), u32(0), u32(2), u32(7), u32(6)),
+		box("stsc", u32(0), u32(1), u32(1), u32(2), u32(1)),
+		box("stco", u32(0), u32(1), u32(8)),
+	)
+	audio := box("trak", box("tkhd", u32(0), u32(0), u32(0), u32(1)), box("tref", box("chap", u32(2))))
+	text := box("trak", box("tkhd", u32(0), u32(0), u32(0), u32(2)), box("mdia",
+		box("mdhd", u32(0), u32(0), u32(0), u32(600), u32(0), u32(0)),
+		box("minf", stbl),
+	))
+	file := writeTemp(t, append(box("mdat", samples), box("moov", audio, text)...))
+
+	moov, err := readMp4Boxes(file, 8+int64(len(samples))+8, int64(len(audio)+len(text)))
+	require.NoError(t, err)
+	require.Equal(t, []Chapter{{"Intro", 0}, {"Main", 30 * time.Second}}, readMp4ChapterTrack(file, moov))
+}
+
+func id3Frame(id string, body ...[]byte) []byte {
+	content := bytes.Join(body, nil)
+	return append(append([]byte(id), u32(uint32(len(content)))...), append([]byte{0, 0}, content...)...)
+}
+
+func id3Tag(frames ...[]byte) []byte {
+	content := bytes.Join(frames, nil)
+	size := len(content)
+	return append([]byte{'I', 'D', '3', 3, 0, 0, byte(size >> 21 & 0x7f), byte(size >> 14 & 0x7f), byte(size >> 7 & 0x7f), byte(size & 0x7f)}, content...)
+}
+
+func TestExtractMp3Metadata(t *testing.T) {
+	utf16Title := []byte{1, 0xFF, 0xFE, 'C', 0, 'h', 0, '.', 0, ' ', 0, '2', 0}
+	tag := id3Tag(
+		id3Frame("TIT2", []byte("\x00Track 1")),
+		id3Frame("TALB", []byte("\x03The Book")),
+		id3Frame("TPE1", []byte("\x00An Author")),
+		id3Frame("TLAN", []byte("\x00eng")),
+		id3Frame("COMM", []byte("\x00eng\x00What it is about")),
+		id3Frame("APIC", []byte("\x00image/png\x00\x00\x00other")),
+		id3Frame("APIC", []byte("\x00image/jpeg\x00\x03\x00front")),
+		id3Frame("CHAP", []byte("ch2\x00"), u32(60000), u32(120000), u32(0xFFFFFFFF), u32(0xFFFFFFFF), id3Frame("TIT2", utf16Title)),
+		id3Frame("CHAP", []byte("ch1\x00"), u32(0), u32(60000), u32(0xFFFFFFFF), u32(0xFFFFFFFF)),
+	)
+	// MPEG-1 Layer III, 128 kbit/s, 44.1 kHz, then 16000 bytes of audio
+	audio := make([]byte, 16000)
+	copy(audio, []byte{0xFF, 0xFB, 0x90, 0x00})
+	file := writeTemp(t, append(tag, audio...))
+
+	m, err := ExtractBookMetadata(file)
+	require.NoError(t, err)
+	require.Equal(t, "mp3", m.Format)
+	require.Equal(t, "The Book", m.Title)
+	require.Equal(t, "An Author", m.Author)
+	require.Equal(t, "eng", m.Language)
+	require.Equal(t, "What it is about", m.Description)
+	require.Equal(t, []byte("front"), m.Cover)
+	require.Equal(t, time.Second, m.Duration)
+	require.Equal(t, []Chapter{{"ch1", 0}, {"Ch. 2", time.Minute}}, m.Chapters)
+}
+
+func TestParseMpegHeaderRejectsOtherData(t *testing.T) {
+	for _, b := range [][]byte{{0xFF, 0xD8, 0xFF, 0xE0}, {0xFF, 0xFB, 0xF0, 0x00}, []byte("%PDF")} {
+		_, ok := parseMpegHeader(b)
+		require.False(t, ok, "%x", b)
+	}
+}
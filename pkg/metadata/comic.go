@@ -0,0 +1,44 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"os"
+	"strings"
+
+	"github.com/banjuer/kompanion/pkg/comic"
+)
+
+// IsComic reports whether format is a comic archive. Their metadata and
+// pages are read with pkg/comic, CBR needs the unrar tool.
+func IsComic(format string) bool {
+	return format == "cbz" || format == "cbr"
+}
+
+// isRar recognizes RAR 4 and RAR 5 archives.
+func isRar(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("Rar!\x1a\x07"))
+}
+
+// isComicZip tells a CBZ from an EPUB without a leading mimetype file: it
+// has page images and no META-INF/container.xml.
+func isComicZip(file *os.File) bool {
+	info, err := file.Stat()
+	if err != nil {
+		return false
+	}
+	r, err := zip.NewReader(file, info.Size())
+	if err != nil {
+		return false
+	}
+	images := 0
+	for _, f := range r.File {
+		if f.Name == "mimetype" || strings.EqualFold(f.Name, "META-INF/container.xml") {
+			return false
+		}
+		if comic.IsImage(f.Name) {
+			images++
+		}
+	}
+	return images > 0
+}
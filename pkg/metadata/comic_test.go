@@ -0,0 +1,33 @@
+package metadata
+
+import (
+	"archive/zip"
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func zipOf(t *testing.T, names ...string) []byte {
+	var buf bytes.Buffer
+	w := zip.NewWriter(&buf)
+	for _, name := range names {
+		_, err := w.Create(name)
+		require.NoError(t, err)
+	}
+	require.NoError(t, w.Close())
+	return buf.Bytes()
+}
+
+func TestGuessComicFormats(t *testing.T) {
+	for content, format := range map[string]string{
+		string(zipOf(t, "001.jpg", "002.jpg", "ComicInfo.xml")):       "cbz",
+		string(zipOf(t, "META-INF/container.xml", "OEBPS/cover.jpg")): "epub",
+		"Rar!\x1a\x07\x01\x00" + string(make([]byte, 32)):             "cbr",
+	} {
+		file := writeTemp(t, []byte(content))
+		got, err := guessExtention(file)
+		require.NoError(t, err)
+		require.Equal(t, format, got)
+	}
+}
@@ -0,0 +1,247 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// maxDjvuAnnotations bounds the annotation chunk read into memory.
+const maxDjvuAnnotations = 1 << 20
+
+// isDjvu recognizes single page (DJVU) and multi page (DJVM) documents.
+func isDjvu(data []byte) bool {
+	if len(data) < 16 || string(data[:8]) != "AT&TFORM" {
+		return false
+	}
+	form := string(data[12:16])
+	return form == "DJVU" || form == "DJVM"
+}
+
+// djvuChunk is an IFF chunk, offset and size describe the content after
+// the id and size fields.
+type djvuChunk struct {
+	id     string
+	offset int64
+	size   int64
+}
+
+// getDjvuMetadata counts the pages and reads the metadata djvused stores in
+// uncompressed annotations (ANTa). Compressed annotations (ANTz) and the
+// page images are not decoded, DjVu books get no cover from the file.
+func getDjvuMetadata(file *os.File) (Metadata, error) {
+	var m Metadata
+	info, err := file.Stat()
+	if err != nil {
+		return m, fmt.Errorf("djvu: %w", err)
+	}
+	header := make([]byte, 16)
+	if _, err = file.ReadAt(header, 0); err != nil {
+		return m, fmt.Errorf("djvu: %w", err)
+	}
+	formSize := min(int64(binary.BigEndian.Uint32(header[8:12]))-4, info.Size()-16)
+	chunks, err := readDjvuChunks(file, 16, formSize)
+	if err != nil {
+		return m, err
+	}
+
+	var annotations []djvuChunk
+	if string(header[12:16]) == "DJVU" {
+		m.Pages = 1
+		annotations = findDjvuChunks(chunks, "ANTa")
+	} else {
+		for _, chunk := range chunks {
+			switch chunk.id {
+			case "FORM:DJVU":
+				m.Pages++
+			case "FORM:DJVI":
+				// shared annotations are kept in an include file
+				if children, err := readDjvuChunks(file, chunk.offset+4, chunk.size-4); err == nil {
+					annotations = append(annotations, findDjvuChunks(children, "ANTa")...)
+				}
+			case "DIRM":
+				if m.Pages == 0 {
+					m.Pages = djvuIndirectPages(file, chunk)
+				}
+			}
+		}
+		// the first page carries the document annotations when there is no include
+		if len(annotations) == 0 {
+			for _, chunk := range chunks {
+				if chunk.id != "FORM:DJVU" {
+					continue
+				}
+				if children, err := readDjvuChunks(file, chunk.offset+4, chunk.size-4); err == nil {
+					annotations = findDjvuChunks(children, "ANTa")
+				}
+				break
+			}
+		}
+	}
+
+	for _, chunk := range annotations {
+		if chunk.size > maxDjvuAnnotations {
+			continue
+		}
+		body := make([]byte, chunk.size)
+		if _, err = file.ReadAt(body, chunk.offset); err != nil {
+			continue
+		}
+		readDjvuAnnotations(&m, string(body))
+	}
+	return m, nil
+}
+
+// readDjvuChunks lists the chunks between offset and offset+size, nested
+// forms are named FORM:<type> and their offset points at the type.
+func readDjvuChunks(file *os.File, offset, size int64) ([]djvuChunk, error) {
+	var chunks []djvuChunk
+	end := offset + size
+	header := make([]byte, 12)
+	for offset+8 <= end {
+		n, _ := file.ReadAt(header, offset)
+		if n < 8 {
+			return chunks, fmt.Errorf("djvu: truncated chunk at %d", offset)
+		}
+		chunk := djvuChunk{
+			id:     string(header[:4]),
+			offset: offset + 8,
+			size:   int64(binary.BigEndian.Uint32(header[4:8])),
+		}
+		if chunk.offset+chunk.size > end {
+			return chunks, fmt.Errorf("djvu: chunk %s exceeds its form", chunk.id)
+		}
+		if chunk.id == "FORM" && n == 12 {
+			chunk.id = "FORM:" + string(header[8:12])
+		}
+		chunks = append(chunks, chunk)
+		// chunks start at even offsets
+		offset = chunk.offset + chunk.size + chunk.size%2
+	}
+	return chunks, nil
+}
+
+func findDjvuChunks(chunks []djvuChunk, id string) []djvuChunk {
+	var found []djvuChunk
+	for _, chunk := range chunks {
+		if chunk.id == id {
+			found = append(found, chunk)
+		}
+	}
+	return found
+}
+
+// djvuIndirectPages reads the file count of a DIRM chunk whose pages are
+// kept in separate files, bundled documents count their page forms.
+func djvuIndirectPages(file *os.File, dirm djvuChunk) int {
+	header := make([]byte, 3)
+	if _, err := file.ReadAt(header, dirm.offset); err != nil || header[0]&0x80 != 0 {
+		return 0
+	}
+	return int(binary.BigEndian.Uint16(header[1:]))
+}
+
+// readDjvuAnnotations fills m from the (metadata (key "value") ...)
+// expression of an annotation chunk. Fields already set are kept.
+func readDjvuAnnotations(m *Metadata, text string) {
+	start := strings.Index(text, "(metadata")
+	if start < 0 {
+		return
+	}
+	p := &sexpr{s: text, i: start + len("(metadata")}
+	for {
+		p.skipSpace()
+		if p.i >= len(p.s) || p.s[p.i] != '(' {
+			return
+		}
+		p.i++
+		p.skipSpace()
+		key := strings.ToLower(p.atom())
+		p.skipSpace()
+		value := p.value()
+		p.skipTo(')')
+
+		set := func(field *string) {
+			if *field == "" {
+				*field = value
+			}
+		}
+		switch key {
+		case "title", "booktitle":
+			set(&m.Title)
+		case "author":
+			set(&m.Author)
+		case "publisher":
+			set(&m.Publisher)
+		case "year", "date":
+			set(&m.Date)
+		case "isbn":
+			set(&m.ISBN)
+		case "language", "lang":
+			set(&m.Language)
+		case "subject", "description", "note":
+			set(&m.Description)
+		case "series":
+			set(&m.Series)
+		case "volume", "number":
+			set(&m.SeriesIndex)
+		}
+	}
+}
+
+// sexpr reads the lisp-like syntax of DjVu annotations.
+type sexpr struct {
+	s string
+	i int
+}
+
+func (p *sexpr) skipSpace() {
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n", p.s[p.i]) >= 0 {
+		p.i++
+	}
+}
+
+func (p *sexpr) atom() string {
+	start := p.i
+	for p.i < len(p.s) && strings.IndexByte(" \t\r\n()\"", p.s[p.i]) < 0 {
+		p.i++
+	}
+	return p.s[start:p.i]
+}
+
+// value reads a quoted string with C escapes, or a bare atom.
+func (p *sexpr) value() string {
+	if p.i >= len(p.s) || p.s[p.i] != '"' {
+		return p.atom()
+	}
+	start := p.i
+	for p.i++; p.i < len(p.s); p.i++ {
+		if p.s[p.i] == '\\' {
+			p.i++
+			continue
+		}
+		if p.s[p.i] == '"' {
+			p.i++
+			break
+		}
+	}
+	value, err := strconv.Unquote(p.s[start:p.i])
+	if err != nil {
+		return strings.Trim(p.s[start:p.i], `"`)
+	}
+	return value
+}
+
+// skipTo moves past the closing parenthesis of the current expression.
+func (p *sexpr) skipTo(c byte) {
+	for p.i < len(p.s) && p.s[p.i] != c {
+		if p.s[p.i] == '"' {
+			p.value()
+			continue
+		}
+		p.i++
+	}
+	p.i++
+}
@@ -0,0 +1,104 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// doiPattern matches a DOI as printed, trailing punctuation of the
+// sentence around it is trimmed by NormalizeDOI.
+var doiPattern = regexp.MustCompile(`(?i)\b10\.\d{4,9}/[-._;()/:<>a-z0-9]+`)
+
+// pdfDOIFields are where publishers put the DOI of an article: XMP
+// (prism, dc:identifier) and the document info dictionary.
+var pdfDOIFields = regexp.MustCompile(`(?i)(?:prism:doi>|<dc:identifier>\s*(?:doi:)?|/doi\s*\(|\bdoi:\s*)\s*(10\.\d{4,9}/[^\s<)]+)`)
+
+var pdfStream = regexp.MustCompile(`(?s)<<(.{0,512}?)>>\s*stream\r?\n`)
+
+// pdfTextOp matches the TJ arrays and the strings shown by Tj, ' and ".
+var pdfTextOp = regexp.MustCompile(`\[((?:\\.|[^\\\]])*)\]\s*TJ|\(((?:\\.|[^\\)])*)\)\s*(?:Tj|'|")`)
+
+// pdfTJPart matches the strings and kerning offsets of a TJ array.
+var pdfTJPart = regexp.MustCompile(`\(((?:\\.|[^\\)])*)\)|(-?\d+(?:\.\d+)?)`)
+
+var pdfUnescape = strings.NewReplacer(`\(`, "(", `\)`, ")", `\\`, `\`)
+
+const (
+	// the first page is near the start of the file, DOIs in reference
+	// lists further down belong to other articles
+	pdfDOIScanSize = 4 << 20
+	pdfDOIStreams  = 16
+	pdfMaxInflated = 2 << 20
+)
+
+// NormalizeDOI returns the DOI in s in lower case, DOIs are not case
+// sensitive, and "" when there is none. URLs and the doi: prefix are accepted.
+func NormalizeDOI(s string) string {
+	doi := doiPattern.FindString(s)
+	return strings.ToLower(strings.TrimRight(doi, ".,;:)>"))
+}
+
+// pdfDOI finds the DOI of a PDF in its metadata or on its first pages.
+// Text in fonts with custom encodings is not readable this way.
+func pdfDOI(r io.ReaderAt, size int64) string {
+	if size > pdfDOIScanSize {
+		size = pdfDOIScanSize
+	}
+	data := make([]byte, size)
+	n, _ := r.ReadAt(data, 0)
+	data = data[:n]
+
+	if m := pdfDOIFields.FindSubmatch(data); m != nil {
+		if doi := NormalizeDOI(string(m[1])); doi != "" {
+			return doi
+		}
+	}
+
+	streams := 0
+	for _, loc := range pdfStream.FindAllSubmatchIndex(data, -1) {
+		dict := data[loc[2]:loc[3]]
+		if !bytes.Contains(dict, []byte("/FlateDecode")) || bytes.Contains(dict, []byte("/Image")) {
+			continue
+		}
+		if streams++; streams > pdfDOIStreams {
+			break
+		}
+		zr, err := zlib.NewReader(bytes.NewReader(data[loc[1]:]))
+		if err != nil {
+			continue
+		}
+		content, _ := io.ReadAll(io.LimitReader(zr, pdfMaxInflated))
+		zr.Close()
+		if doi := NormalizeDOI(pdfText(content)); doi != "" {
+			return doi
+		}
+	}
+	return ""
+}
+
+// pdfText reads the text of a content stream, a space between text
+// operators and for wide gaps within TJ arrays.
+func pdfText(content []byte) string {
+	var b strings.Builder
+	for _, m := range pdfTextOp.FindAllSubmatch(content, -1) {
+		if m[1] == nil {
+			b.WriteString(pdfUnescape.Replace(string(m[2])))
+			b.WriteString(" ")
+			continue
+		}
+		for _, part := range pdfTJPart.FindAllSubmatch(m[1], -1) {
+			if part[1] != nil {
+				b.WriteString(pdfUnescape.Replace(string(part[1])))
+			} else if gap, err := strconv.ParseFloat(string(part[2]), 64); err == nil && gap < -200 {
+				// offsets are thousandths of the font size, a word space
+				b.WriteString(" ")
+			}
+		}
+		b.WriteString(" ")
+	}
+	return b.String()
+}
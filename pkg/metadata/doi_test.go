@@ -0,0 +1,33 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+func TestNormalizeDOI(t *testing.T) {
+	require.Equal(t, "10.1038/nphys1170", NormalizeDOI("https://doi.org/10.1038/nphys1170"))
+	require.Equal(t, "10.1000/xyz-123", NormalizeDOI("see doi:10.1000/xyz-123."))
+	require.Equal(t, "", NormalizeDOI("ISBN 978-0-261-10221-7"))
+}
+
+func TestPDFDOIFromMetadata(t *testing.T) {
+	pdf := []byte("%PDF-1.7\n<x:xmpmeta><prism:doi>10.1145/3132747.3132763</prism:doi></x:xmpmeta>")
+	require.Equal(t, "10.1145/3132747.3132763", pdfDOI(bytes.NewReader(pdf), int64(len(pdf))))
+}
+
+func TestPDFDOIFromFirstPage(t *testing.T) {
+	var content bytes.Buffer
+	zw := zlib.NewWriter(&content)
+	zw.Write([]byte("BT /F1 9 Tf 72 720 Td [(DOI:)-333(10.1016/j.cell.)10(2009.01.042)]TJ 0 -12 Td (Received 2 March 2008) Tj ET"))
+	zw.Close()
+
+	var pdf bytes.Buffer
+	pdf.WriteString("%PDF-1.5\n4 0 obj\n<< /Length 99 /Filter /FlateDecode >>\nstream\n")
+	pdf.Write(content.Bytes())
+	pdf.WriteString("\nendstream\nendobj\n")
+	require.Equal(t, "10.1016/j.cell.2009.01.042", pdfDOI(bytes.NewReader(pdf.Bytes()), int64(pdf.Len())))
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io"
 	"os"
+	"path"
 	"strings"
 )
 
@@ -43,10 +44,16 @@ type EpubMetadata struct {
 	} `xml:"metadata"`
 	Manifest struct {
 		Items []struct {
-			ID   string `xml:"id,attr"`
-			Href string `xml:"href,attr"`
+			ID        string `xml:"id,attr"`
+			Href      string `xml:"href,attr"`
+			MediaType string `xml:"media-type,attr"`
 		} `xml:"item"`
 	} `xml:"manifest"`
+	Spine struct {
+		Itemrefs []struct {
+			IDRef string `xml:"idref,attr"`
+		} `xml:"itemref"`
+	} `xml:"spine"`
 }
 
 func getEpubMetadata(tmpFile *os.File) (Metadata, error) {
@@ -82,6 +89,11 @@ func getEpubMetadata(tmpFile *os.File) (Metadata, error) {
 	cover := findEpubCover(reader, metadata)
 	series, seriesIndex := extractEpubSeries(metadata)
 
+	language := metadata.Metadata.Language
+	if language == "" {
+		language = DetectLanguage(epubTextSample(reader, metadata, metadataFilepath))
+	}
+
 	return Metadata{
 		ISBN:        metadata.Metadata.ISBN,
 		Title:       metadata.Metadata.Title,
@@ -89,13 +101,36 @@ func getEpubMetadata(tmpFile *os.File) (Metadata, error) {
 		Author:      metadata.Metadata.Creator,
 		Date:        metadata.Metadata.Date,
 		Publisher:   metadata.Metadata.Publisher,
-		Language:    metadata.Metadata.Language,
+		Language:    language,
 		Cover:       cover,
 		Series:      series,
 		SeriesIndex: seriesIndex,
+		Pages:       estimatePages(epubSpineSize(reader, metadata, metadataFilepath)),
 	}, nil
 }
 
+// epubSpineSize sums uncompressed sizes of the reading order documents,
+// read from the zip directory without inflating anything.
+func epubSpineSize(reader *zip.Reader, metadata EpubMetadata, metadataFilepath string) int64 {
+	dir := path.Dir(metadataFilepath)
+	hrefs := make(map[string]string, len(metadata.Manifest.Items))
+	for _, item := range metadata.Manifest.Items {
+		hrefs[item.ID] = path.Join(dir, item.Href)
+	}
+	files := make(map[string]*zip.File, len(reader.File))
+	for _, f := range reader.File {
+		files[f.Name] = f
+	}
+
+	var size int64
+	for _, ref := range metadata.Spine.Itemrefs {
+		if f, ok := files[hrefs[ref.IDRef]]; ok {
+			size += int64(f.UncompressedSize64)
+		}
+	}
+	return size
+}
+
 func findEpubCover(reader *zip.Reader, metadata EpubMetadata) []byte {
 	var coverID string
 	for _, meta := range metadata.Metadata.Meta {
@@ -118,6 +153,32 @@ func findEpubCover(reader *zip.Reader, metadata EpubMetadata) []byte {
 	return nil
 }
 
+// epubTextSample collects text from the first content documents for language detection.
+func epubTextSample(reader *zip.Reader, metadata EpubMetadata, metadataFilepath string) string {
+	dir := path.Dir(metadataFilepath)
+	var sample strings.Builder
+	for _, item := range metadata.Manifest.Items {
+		if item.MediaType != "application/xhtml+xml" {
+			continue
+		}
+		f, err := reader.Open(path.Join(dir, item.Href))
+		if err != nil {
+			continue
+		}
+		content, err := io.ReadAll(io.LimitReader(f, languageSampleSize))
+		f.Close()
+		if err != nil {
+			continue
+		}
+		sample.WriteString(stripHTMLTags(string(content)))
+		sample.WriteString(" ")
+		if sample.Len() >= languageSampleSize {
+			break
+		}
+	}
+	return sample.String()
+}
+
 func parseMetadata(f *zip.File) EpubMetadata {
 	content, _ := readFileContent(f)
 
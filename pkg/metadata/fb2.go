@@ -15,7 +15,10 @@ import (
 type FictionBook struct {
 	XMLName     xml.Name    `xml:"FictionBook"`
 	Description Description `xml:"description"`
-	Binary      []struct {
+	Body        []struct {
+		Content string `xml:",innerxml"`
+	} `xml:"body"`
+	Binary []struct {
 		ID      string `xml:"id,attr"`
 		Content string `xml:",chardata"`
 	} `xml:"binary"`
@@ -26,13 +29,16 @@ type Description struct {
 	XMLName xml.Name  `xml:"description"`
 	Title   TitleInfo `xml:"title-info"`
 	Publish PubInfo   `xml:"publish-info"`
-	Author  []Author  `xml:"author"`
 }
 
 // TitleInfo struct holds title metadata
 type TitleInfo struct {
 	XMLName    xml.Name `xml:"title-info"`
 	BookTitle  string   `xml:"book-title"`
+	Author     []Author `xml:"author"`
+	Genre      []string `xml:"genre"`
+	Date       string   `xml:"date"`
+	Lang       string   `xml:"lang"`
 	Annotation struct {
 		Content string `xml:",innerxml"`
 	} `xml:"annotation"`
@@ -54,25 +60,50 @@ type PubInfo struct {
 	XMLName   xml.Name `xml:"publish-info"`
 	Publisher string   `xml:"publisher"`
 	Year      string   `xml:"year"`
+	ISBN      string   `xml:"isbn"`
 }
 
 // Author struct for author information
 type Author struct {
-	XMLName   xml.Name `xml:"author"`
-	FirstName string   `xml:"first-name"`
-	LastName  string   `xml:"last-name"`
+	XMLName    xml.Name `xml:"author"`
+	FirstName  string   `xml:"first-name"`
+	MiddleName string   `xml:"middle-name"`
+	LastName   string   `xml:"last-name"`
+	Nickname   string   `xml:"nickname"`
+}
+
+// Name joins the name parts, the nickname is used for authors without one.
+func (a Author) Name() string {
+	name := strings.Join(strings.Fields(a.FirstName+" "+a.MiddleName+" "+a.LastName), " ")
+	if name == "" {
+		return strings.TrimSpace(a.Nickname)
+	}
+	return name
+}
+
+// fb2Charsets are the legacy encodings FB2 libraries still use.
+var fb2Charsets = map[string]*charmap.Charmap{
+	"windows-1251": charmap.Windows1251,
+	"cp1251":       charmap.Windows1251,
+	"windows-1250": charmap.Windows1250,
+	"windows-1252": charmap.Windows1252,
+	"koi8-r":       charmap.KOI8R,
+	"koi8-u":       charmap.KOI8U,
+	"iso-8859-1":   charmap.ISO8859_1,
+	"iso-8859-2":   charmap.ISO8859_2,
+	"iso-8859-5":   charmap.ISO8859_5,
+	"cp866":        charmap.CodePage866,
+	"ibm866":       charmap.CodePage866,
 }
 
 func getFb2Metatada(tmpFile *os.File) (Metadata, error) {
 	// Parse the XML data
 	d := xml.NewDecoder(tmpFile)
 	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
-		switch charset {
-		case "windows-1251":
-			return charmap.Windows1251.NewDecoder().Reader(input), nil
-		default:
-			return nil, fmt.Errorf("unknown charset: %s", charset)
+		if cm, ok := fb2Charsets[strings.ToLower(charset)]; ok {
+			return cm.NewDecoder().Reader(input), nil
 		}
+		return nil, fmt.Errorf("unknown charset: %s", charset)
 	}
 	var book FictionBook
 	err := d.Decode(&book)
@@ -93,10 +124,43 @@ func getFb2Metatada(tmpFile *os.File) (Metadata, error) {
 
 	description := stripHTMLTags(book.Description.Title.Annotation.Content)
 
+	var bodySize int64
+	for _, body := range book.Body {
+		bodySize += int64(len(body.Content))
+	}
+
+	language := book.Description.Title.Lang
+	if language == "" && len(book.Body) > 0 {
+		language = DetectLanguage(stripHTMLTags(book.Body[0].Content))
+	}
+
+	var authors []string
+	for _, author := range book.Description.Title.Author {
+		if name := author.Name(); name != "" {
+			authors = append(authors, name)
+		}
+	}
+	var genres []string
+	for _, genre := range book.Description.Title.Genre {
+		if genre = strings.TrimSpace(genre); genre != "" {
+			genres = append(genres, genre)
+		}
+	}
+	date := strings.TrimSpace(book.Description.Publish.Year)
+	if date == "" {
+		date = strings.TrimSpace(book.Description.Title.Date)
+	}
+
 	return Metadata{
 		Title:       book.Description.Title.BookTitle,
+		Author:      strings.Join(authors, ", "),
 		Description: description,
 		Publisher:   book.Description.Publish.Publisher,
+		ISBN:        strings.TrimSpace(book.Description.Publish.ISBN),
+		Date:        date,
+		Genres:      genres,
+		Language:    language,
+		Pages:       estimatePages(bodySize),
 		Series:      series,
 		SeriesIndex: seriesIndex,
 		Cover:       cover,
@@ -0,0 +1,88 @@
+package metadata
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"golang.org/x/text/encoding/charmap"
+)
+
+func TestExtractFb2Metadata(t *testing.T) {
+	description := `<description><title-info>
+		<genre>sf_fantasy</genre><genre>adv_history</genre>
+		<author><first-name>Аркадий</first-name><middle-name>Натанович</middle-name><last-name>Стругацкий</last-name></author>
+		<author><first-name>Борис</first-name><last-name>Стругацкий</last-name></author>
+		<author><nickname>Anon</nickname></author>
+		<book-title>Трудно быть богом</book-title>
+		<date>1964</date>
+		<lang>ru</lang>
+		<coverpage><image l:href="#cover.jpg"/></coverpage>
+	</title-info>
+	<publish-info><publisher>Молодая гвардия</publisher><isbn>978-5-17-000000-0</isbn></publish-info></description>`
+	body := `<?xml version="1.0" encoding="windows-1251"?>
+<FictionBook xmlns="http://www.gribuser.ru/xml/fictionbook/2.0" xmlns:l="http://www.w3.org/1999/xlink">` +
+		description + `<body><p>Текст</p></body>
+<binary id="cover.jpg" content-type="image/jpeg">` + base64.StdEncoding.EncodeToString([]byte("jpeg bytes")) + `</binary>
+</FictionBook>`
+	encoded, err := charmap.Windows1251.NewEncoder().String(body)
+	require.NoError(t, err)
+
+	file := writeTemp(t, []byte(encoded))
+	_, err = file.Seek(0, io.SeekStart)
+	require.NoError(t, err)
+
+	m, err := ExtractBookMetadata(file)
+	require.NoError(t, err)
+	require.Equal(t, "fb2", m.Format)
+	require.Equal(t, "Трудно быть богом", m.Title)
+	require.Equal(t, "Аркадий Натанович Стругацкий, Борис Стругацкий, Anon", m.Author)
+	require.Equal(t, "Молодая гвардия", m.Publisher)
+	require.Equal(t, "978-5-17-000000-0", m.ISBN)
+	require.Equal(t, "1964", m.Date)
+	require.Equal(t, "ru", m.Language)
+	require.Equal(t, []string{"sf_fantasy", "adv_history"}, m.Genres)
+	require.Equal(t, []byte("jpeg bytes"), m.Cover)
+}
+
+func iffChunk(id string, body []byte) []byte {
+	b := append([]byte(id), binary.BigEndian.AppendUint32(nil, uint32(len(body)))...)
+	b = append(b, body...)
+	if len(body)%2 == 1 {
+		b = append(b, 0)
+	}
+	return b
+}
+
+func TestExtractDjvuMetadata(t *testing.T) {
+	page := iffChunk("FORM", append([]byte("DJVU"), iffChunk("INFO", make([]byte, 10))...))
+	annotations := iffChunk("ANTa", []byte(`(metadata (title "Sur la th\303\251orie") (author "Henri Poincar\303\251") (year "1902") (ISBN 2-08-081017-3))`))
+	include := iffChunk("FORM", append([]byte("DJVI"), annotations...))
+	form := append([]byte("DJVM"), iffChunk("DIRM", []byte{0x81, 0, 3})...)
+	form = append(form, include...)
+	form = append(form, page...)
+	form = append(form, page...)
+	file := writeTemp(t, append([]byte("AT&T"), iffChunk("FORM", form)...))
+
+	m, err := ExtractBookMetadata(file)
+	require.NoError(t, err)
+	require.Equal(t, "djvu", m.Format)
+	require.Equal(t, 2, m.Pages)
+	require.Equal(t, "Sur la théorie", m.Title)
+	require.Equal(t, "Henri Poincaré", m.Author)
+	require.Equal(t, "1902", m.Date)
+	require.Equal(t, "2-08-081017-3", m.ISBN)
+}
+
+func TestExtractSinglePageDjvu(t *testing.T) {
+	form := append([]byte("DJVU"), iffChunk("INFO", make([]byte, 10))...)
+	form = append(form, iffChunk("ANTa", []byte(`(background #ffffff) (metadata (title "Plan"))`))...)
+	file := writeTemp(t, append([]byte("AT&T"), iffChunk("FORM", form)...))
+
+	m, err := ExtractBookMetadata(file)
+	require.NoError(t, err)
+	require.Equal(t, 1, m.Pages)
+	require.Equal(t, "Plan", m.Title)
+}
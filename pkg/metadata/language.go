@@ -0,0 +1,145 @@
+package metadata
+
+import (
+	"strings"
+	"unicode"
+)
+
+// languageSampleSize bounds how much text is read for language detection.
+const languageSampleSize = 16 * 1024
+
+// minDetectLetters is the least number of letters needed to guess a language.
+const minDetectLetters = 100
+
+// iso639Alpha3 maps the ISO 639-2 codes books commonly use to ISO 639-1.
+var iso639Alpha3 = map[string]string{
+	"eng": "en", "rus": "ru", "ukr": "uk", "bel": "be",
+	"deu": "de", "ger": "de", "fra": "fr", "fre": "fr",
+	"spa": "es", "ita": "it", "por": "pt", "nld": "nl", "dut": "nl",
+	"pol": "pl", "ces": "cs", "cze": "cs", "swe": "sv", "nor": "no",
+	"dan": "da", "fin": "fi", "tur": "tr", "ell": "el", "gre": "el",
+	"heb": "he", "ara": "ar", "hin": "hi", "tha": "th",
+	"zho": "zh", "chi": "zh", "jpn": "ja", "kor": "ko",
+}
+
+// NormalizeLanguage reduces a language tag such as "en-US", "EN_gb" or "eng"
+// to its lowercase ISO 639-1 primary subtag. Unknown or undetermined tags
+// are returned as is, "und" becomes empty.
+func NormalizeLanguage(tag string) string {
+	tag = strings.ToLower(strings.TrimSpace(tag))
+	if i := strings.IndexAny(tag, "-_"); i >= 0 {
+		tag = tag[:i]
+	}
+	if tag == "und" || tag == "mul" {
+		return ""
+	}
+	if code, ok := iso639Alpha3[tag]; ok {
+		return code
+	}
+	return tag
+}
+
+// scriptLanguages are detected by the writing system alone.
+var scriptLanguages = []struct {
+	language string
+	table    *unicode.RangeTable
+}{
+	{"ja", unicode.Hiragana},
+	{"ja", unicode.Katakana},
+	{"ko", unicode.Hangul},
+	{"zh", unicode.Han},
+	{"ar", unicode.Arabic},
+	{"el", unicode.Greek},
+	{"he", unicode.Hebrew},
+	{"th", unicode.Thai},
+	{"hi", unicode.Devanagari},
+}
+
+// stopwords are frequent short words telling Latin and Cyrillic languages apart.
+var stopwords = map[string][]string{
+	"en": {"the", "and", "of", "to", "is", "in", "that", "it", "was", "he", "with", "for"},
+	"de": {"der", "die", "und", "das", "ist", "nicht", "ein", "ich", "zu", "mit", "sie", "den"},
+	"fr": {"le", "la", "les", "et", "est", "des", "une", "que", "pas", "il", "dans", "du"},
+	"es": {"el", "los", "las", "y", "es", "que", "una", "por", "del", "con", "para", "se"},
+	"it": {"il", "di", "che", "e", "non", "un", "per", "una", "sono", "della", "gli", "con"},
+	"pt": {"o", "os", "e", "que", "não", "um", "uma", "do", "da", "com", "para", "em"},
+	"nl": {"de", "het", "een", "en", "van", "niet", "dat", "is", "ik", "je", "op", "zijn"},
+	"ru": {"и", "в", "не", "что", "на", "он", "я", "с", "как", "это", "но", "она"},
+	"uk": {"і", "в", "не", "що", "на", "він", "я", "з", "як", "це", "але", "та"},
+}
+
+// DetectLanguage guesses the language of a text sample. It returns an empty
+// string when the sample is too short or no language clearly wins.
+func DetectLanguage(text string) string {
+	if len(text) > languageSampleSize {
+		text = text[:languageSampleSize]
+	}
+
+	letters := 0
+	scripts := make(map[string]int)
+	for _, r := range text {
+		if !unicode.IsLetter(r) {
+			continue
+		}
+		letters++
+		for _, s := range scriptLanguages {
+			if unicode.Is(s.table, r) {
+				scripts[s.language]++
+				break
+			}
+		}
+	}
+	if letters < minDetectLetters {
+		return ""
+	}
+
+	// kana is mixed with kanji in Japanese, any amount of it wins over Chinese
+	if scripts["ja"] > letters/20 {
+		return "ja"
+	}
+	best, bestCount := "", 0
+	for language, count := range scripts {
+		if count > bestCount {
+			best, bestCount = language, count
+		}
+	}
+	if bestCount > letters/2 {
+		return best
+	}
+
+	return detectByStopwords(text)
+}
+
+func detectByStopwords(text string) string {
+	words := strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && r != '\''
+	})
+
+	counts := make(map[string]int)
+	for language, list := range stopwords {
+		set := make(map[string]struct{}, len(list))
+		for _, w := range list {
+			set[w] = struct{}{}
+		}
+		for _, w := range words {
+			if _, ok := set[w]; ok {
+				counts[language]++
+			}
+		}
+	}
+
+	best, bestCount, second := "", 0, 0
+	for language, count := range counts {
+		switch {
+		case count > bestCount:
+			best, bestCount, second = language, count, bestCount
+		case count > second:
+			second = count
+		}
+	}
+	// ask for a clear winner, closely related languages share many words
+	if bestCount < 5 || bestCount*2 < second*3 {
+		return ""
+	}
+	return best
+}
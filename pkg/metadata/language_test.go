@@ -0,0 +1,71 @@
+package metadata_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/metadata"
+)
+
+func TestNormalizeLanguage(t *testing.T) {
+	tests := map[string]string{
+		"en-us": "en",
+		"EN_GB": "en",
+		"rus":   "ru",
+		"ger":   "de",
+		"und":   "",
+		" fr ":  "fr",
+		"":      "",
+	}
+	for tag, want := range tests {
+		if got := metadata.NormalizeLanguage(tag); got != want {
+			t.Errorf("NormalizeLanguage(%q) = %q, want %q", tag, got, want)
+		}
+	}
+}
+
+func TestDetectLanguage(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want string
+	}{
+		{
+			name: "english",
+			text: "It was the best of times, it was the worst of times, it was the age of wisdom, and the age of foolishness. He went to the house with his friend and the dog.",
+			want: "en",
+		},
+		{
+			name: "german",
+			text: "Als Gregor Samsa eines Morgens aus unruhigen Träumen erwachte, fand er sich in seinem Bett zu einem ungeheueren Ungeziefer verwandelt. Er lag auf seinem panzerartig harten Rücken und sah, wenn er den Kopf ein wenig hob, seinen gewölbten Bauch. Das ist nicht der Traum, sagte ich zu mir, und die Tür war mit dem Schlüssel verschlossen.",
+			want: "de",
+		},
+		{
+			name: "russian",
+			text: "В начале июля, в чрезвычайно жаркое время, под вечер, один молодой человек вышел из своей каморки, которую нанимал от жильцов в С-м переулке, на улицу и медленно, как бы в нерешимости, отправился к К-ну мосту. Он не был труслив, но что-то с ним было не так, и она это знала.",
+			want: "ru",
+		},
+		{
+			name: "chinese",
+			text: strings.Repeat("天下大势，分久必合，合久必分。周末七国分争，并入于秦。", 5),
+			want: "zh",
+		},
+		{
+			name: "japanese",
+			text: strings.Repeat("吾輩は猫である。名前はまだ無い。どこで生れたかとんと見当がつかぬ。", 5),
+			want: "ja",
+		},
+		{
+			name: "too short",
+			text: "the and of",
+			want: "",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := metadata.DetectLanguage(tt.text); got != tt.want {
+				t.Errorf("DetectLanguage() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
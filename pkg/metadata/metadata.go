@@ -5,10 +5,12 @@ import (
 	"io"
 	"net/http"
 	"os"
+	"time"
 )
 
 type Metadata struct {
 	ISBN        string
+	DOI         string // of academic PDFs, from metadata or the first page
 	Title       string
 	Description string
 	Author      string
@@ -19,6 +21,32 @@ type Metadata struct {
 	Cover       []byte
 	Series      string
 	SeriesIndex string
+	Size        int64         // file size in bytes
+	Pages       int           // page count for PDF, an estimate for reflowable formats
+	Duration    time.Duration // playing time of audiobooks
+	Chapters    []Chapter     // audiobook chapters in playing order
+	Genres      []string      // genre codes, like FB2 "sf_fantasy"
+}
+
+// Chapter is a named position in an audiobook.
+type Chapter struct {
+	Title string
+	Start time.Duration
+}
+
+// IsAudio reports whether format is one of the audiobook containers.
+func IsAudio(format string) bool {
+	return format == "m4b" || format == "mp3"
+}
+
+// bytesPerPage estimates a printed page of reflowable markup.
+const bytesPerPage = 2048
+
+func estimatePages(markupSize int64) int {
+	if markupSize <= 0 {
+		return 0
+	}
+	return int((markupSize + bytesPerPage - 1) / bytesPerPage)
 }
 
 // ExtractBookMetadata extracts metadata from a book file
@@ -44,8 +72,26 @@ func ExtractBookMetadata(tempFile *os.File) (Metadata, error) {
 		if err != nil {
 			return Metadata{}, err
 		}
+	case "djvu":
+		m, err = getDjvuMetadata(tempFile)
+		if err != nil {
+			return Metadata{}, err
+		}
+	case "m4b":
+		m, err = getMp4Metadata(tempFile)
+		if err != nil {
+			return Metadata{}, err
+		}
+	case "mp3":
+		m, err = getMp3Metadata(tempFile)
+		if err != nil {
+			return Metadata{}, err
+		}
 	}
 	m.Format = extension
+	if info, err := tempFile.Stat(); err == nil {
+		m.Size = info.Size()
+	}
 	return m, nil
 }
 
@@ -56,6 +102,18 @@ func guessExtention(file *os.File) (string, error) {
 	if err != nil && err != io.EOF {
 		return "", err
 	}
+	if isMp4Audio(data) {
+		return "m4b", nil
+	}
+	if isMp3(data) {
+		return "mp3", nil
+	}
+	if isRar(data) {
+		return "cbr", nil
+	}
+	if isDjvu(data) {
+		return "djvu", nil
+	}
 	mimeType := http.DetectContentType(data)
 	fmt.Println(mimeType)
 	switch mimeType {
@@ -64,6 +122,9 @@ func guessExtention(file *os.File) (string, error) {
 	case "application/epub+zip":
 		return "epub", nil
 	case "application/zip":
+		if isComicZip(file) {
+			return "cbz", nil
+		}
 		return "epub", nil
 	case "application/x-fictionbook+xml":
 		return "fb2", nil
@@ -9,7 +9,7 @@ import (
 	"github.com/banjuer/kompanion/pkg/metadata"
 )
 
-const pathToTestDataFolder = "../../../test/test_data/books/"
+const pathToTestDataFolder = "../../test/test_data/books/"
 
 func readAll(path string) []byte {
 	file, err := os.Open(path)
@@ -36,6 +36,8 @@ func TestExtractBookMetadata(t *testing.T) {
 				Title:  "A Princess of Mars",
 				Author: "Edgar Rice Burroughs",
 				Format: "pdf",
+				Size:   986775,
+				Pages:  252,
 			},
 		},
 		{
@@ -50,6 +52,8 @@ func TestExtractBookMetadata(t *testing.T) {
 				Title:       "Crime and Punishment",
 				Description: "(From Wikipedia): Crime and Punishment (Russian: Преступлéние и наказáние, Prestupleniye i nakazaniye) is a novel by the Russian author Fyodor Dostoyevsky. It was first published in the literary journal The Russian Messenger in twelve monthly installments during 1866. It was later published in a single volume. It is the second of Dostoyevsky’s full-length novels following his return from ten years of exile in Siberia. Crime and Punishment is the first great novel of his “mature” period of writing. Crime and Punishment focuses on the mental anguish and moral dilemmas of Rodion Raskolnikov, an impoverished ex-student in St. Petersburg who formulates and executes a plan to kill an unscrupulous pawnbroker for her cash. Raskolnikov argues that with the pawnbroker’s money he can perform good deeds to counterbalance the crime, while ridding the world of a worthless vermin. He also commits this murder to test his own hypothesis that some people are naturally capable of such things, and even have the right to do them. Several times throughout the novel, Raskolnikov justifies his actions by comparing himself with Napoleon Bonaparte, believing that murder is permissible in pursuit of a higher purpose.",
 				Format:      "epub",
+				Size:        810892,
+				Pages:       596,
 				Cover:       readAll(pathToTestDataFolder + "../covers/CrimePunishment-EPUB2.jpg"),
 			},
 		},
@@ -57,9 +61,16 @@ func TestExtractBookMetadata(t *testing.T) {
 			name:     "FB2",
 			fileName: "Great Expectations -- Charles Dickens.fb2",
 			want: metadata.Metadata{
-				Title:  "Great Expectations",
-				Format: "fb2",
-				Cover:  readAll(pathToTestDataFolder + "../covers/Great Expectations -- Charles Dickens.jpg"),
+				Title:       "Great Expectations",
+				Author:      "Charles Dickens",
+				Description: "Great Expectations chronicles the progress of Pip from childhood through adulthood. As he moves from the marshes of Kent to London society, he encounters a variety of extraordinary characters: from Magwitch, the escaped convict, to Miss Havisham and her ward, the arrogant and beautiful Estella. In this fascinating story, Dickens shows the dangers of being driven by a desire for wealth and social status. Pip must establish a sense of self against the plans which others seem to have for him пїЅ and somehow discover a firm set of values and priorities.",
+				Date:        "1860-1861",
+				Language:    "en",
+				Genres:      []string{"prose_classic"},
+				Format:      "fb2",
+				Size:        1091967,
+				Pages:       512,
+				Cover:       readAll(pathToTestDataFolder + "../covers/Great Expectations -- Charles Dickens.jpg"),
 			},
 		},
 	}
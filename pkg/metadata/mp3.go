@@ -0,0 +1,348 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// isMp3 recognizes an ID3v2 tag or a bare MPEG audio frame.
+func isMp3(data []byte) bool {
+	if len(data) >= 10 && string(data[:3]) == "ID3" {
+		return true
+	}
+	_, ok := parseMpegHeader(data)
+	return ok
+}
+
+// getMp3Metadata reads the ID3v2.3 or v2.4 tag in front of the audio, with
+// chapters from CHAP frames. The duration comes from the TLEN frame, or
+// is computed from the first MPEG frame.
+func getMp3Metadata(file *os.File) (Metadata, error) {
+	var m Metadata
+	header := make([]byte, 10)
+	if _, err := file.ReadAt(header, 0); err != nil {
+		return m, fmt.Errorf("mp3: %w", err)
+	}
+
+	audioStart := int64(0)
+	if string(header[:3]) == "ID3" {
+		version, flags := header[3], header[5]
+		size := syncsafe(header[6:10])
+		audioStart = 10 + int64(size)
+		if flags&0x10 != 0 {
+			// footer
+			audioStart += 10
+		}
+		if version == 3 || version == 4 {
+			tag := make([]byte, size)
+			if _, err := file.ReadAt(tag, 10); err != nil {
+				return m, fmt.Errorf("mp3: id3 tag: %w", err)
+			}
+			if flags&0x80 != 0 && version == 3 {
+				tag = removeUnsync(tag)
+			}
+			if flags&0x40 != 0 {
+				tag = skipExtendedHeader(tag, version)
+			}
+			readID3Frames(&m, tag, version)
+		}
+	}
+
+	if m.Duration == 0 {
+		if info, err := file.Stat(); err == nil {
+			m.Duration = mp3Duration(file, audioStart, info.Size())
+		}
+	}
+	sort.SliceStable(m.Chapters, func(i, j int) bool { return m.Chapters[i].Start < m.Chapters[j].Start })
+	return m, nil
+}
+
+func readID3Frames(m *Metadata, tag []byte, version byte) {
+	var album, albumArtist, movement, movementIndex string
+	coverType := -1
+	for len(tag) >= 10 && tag[0] != 0 {
+		id := string(tag[:4])
+		size := int(binary.BigEndian.Uint32(tag[4:8]))
+		if version == 4 {
+			size = syncsafe(tag[4:8])
+		}
+		if size < 0 || size > len(tag)-10 {
+			return
+		}
+		formatFlags := tag[9]
+		body := tag[10 : 10+size]
+		tag = tag[10+size:]
+
+		if version == 4 {
+			if formatFlags&0x02 != 0 {
+				body = removeUnsync(body)
+			}
+			if formatFlags&0x01 != 0 && len(body) >= 4 {
+				// data length indicator
+				body = body[4:]
+			}
+		}
+		if len(body) == 0 {
+			continue
+		}
+
+		switch id {
+		case "TIT2":
+			m.Title = id3Text(body)
+		case "TALB":
+			album = id3Text(body)
+		case "TPE1":
+			m.Author = id3Text(body)
+		case "TPE2":
+			albumArtist = id3Text(body)
+		case "TPUB":
+			m.Publisher = id3Text(body)
+		case "TYER", "TDRC":
+			m.Date = id3Text(body)
+		case "TLAN":
+			m.Language = id3Text(body)
+		case "TLEN":
+			if ms, err := strconv.ParseInt(id3Text(body), 10, 64); err == nil {
+				m.Duration = time.Duration(ms) * time.Millisecond
+			}
+		case "MVNM":
+			movement = id3Text(body)
+		case "MVIN":
+			movementIndex, _, _ = strings.Cut(id3Text(body), "/")
+		case "TXXX":
+			name, value := id3Pair(body)
+			switch strings.ToUpper(name) {
+			case "SERIES":
+				m.Series = value
+			case "SERIES-PART", "SERIES_PART":
+				m.SeriesIndex = value
+			}
+		case "COMM":
+			// language and short description come first
+			if len(body) > 4 {
+				_, text := id3Pair(append([]byte{body[0]}, body[4:]...))
+				if m.Description == "" || len(text) > len(m.Description) {
+					m.Description = text
+				}
+			}
+		case "APIC":
+			picType, data := id3Picture(body)
+			// the front cover wins over other pictures
+			if data != nil && coverType != 3 && (coverType == -1 || picType == 3) {
+				m.Cover, coverType = data, picType
+			}
+		case "CHAP":
+			if chapter, ok := id3Chapter(body, version); ok {
+				m.Chapters = append(m.Chapters, chapter)
+			}
+		}
+	}
+
+	// audiobooks are often tagged per track, the album is the book
+	if album != "" {
+		m.Title = album
+	}
+	if m.Author == "" {
+		m.Author = albumArtist
+	}
+	if m.Series == "" && movement != "" {
+		m.Series, m.SeriesIndex = movement, movementIndex
+	}
+}
+
+// id3Chapter reads a CHAP frame: element id, start and end in
+// milliseconds, byte offsets and then embedded frames with the title.
+func id3Chapter(body []byte, version byte) (Chapter, bool) {
+	end := bytes.IndexByte(body, 0)
+	if end < 0 || len(body) < end+17 {
+		return Chapter{}, false
+	}
+	elementID := string(body[:end])
+	start := binary.BigEndian.Uint32(body[end+1:])
+
+	var sub Metadata
+	readID3Frames(&sub, body[end+17:], version)
+	title := sub.Title
+	if title == "" {
+		title = elementID
+	}
+	return Chapter{Title: title, Start: time.Duration(start) * time.Millisecond}, true
+}
+
+// id3Picture returns the picture type and image data of an APIC frame.
+func id3Picture(body []byte) (int, []byte) {
+	encoding := body[0]
+	mimeEnd := bytes.IndexByte(body[1:], 0)
+	if mimeEnd < 0 || len(body) < mimeEnd+3 {
+		return 0, nil
+	}
+	rest := body[mimeEnd+2:]
+	picType := int(rest[0])
+	_, data := splitID3Text(encoding, rest[1:])
+	if len(data) == 0 {
+		return 0, nil
+	}
+	return picType, data
+}
+
+// id3Text decodes a text frame, only the first of several values is kept.
+func id3Text(body []byte) string {
+	text, _ := splitID3Text(body[0], body[1:])
+	return text
+}
+
+// id3Pair decodes frames with a description and a value, like TXXX.
+func id3Pair(body []byte) (string, string) {
+	description, rest := splitID3Text(body[0], body[1:])
+	return description, decodeID3Text(body[0], rest)
+}
+
+// splitID3Text decodes text up to its terminator and returns what follows.
+func splitID3Text(encoding byte, b []byte) (string, []byte) {
+	if encoding == 1 || encoding == 2 {
+		for i := 0; i+1 < len(b); i += 2 {
+			if b[i] == 0 && b[i+1] == 0 {
+				return decodeID3Text(encoding, b[:i]), b[i+2:]
+			}
+		}
+		return decodeID3Text(encoding, b), nil
+	}
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		return decodeID3Text(encoding, b[:i]), b[i+1:]
+	}
+	return decodeID3Text(encoding, b), nil
+}
+
+// decodeID3Text converts ISO-8859-1, UTF-16 with BOM, UTF-16BE or UTF-8.
+func decodeID3Text(encoding byte, b []byte) string {
+	switch encoding {
+	case 0:
+		runes := make([]rune, len(b))
+		for i, c := range b {
+			runes[i] = rune(c)
+		}
+		return strings.TrimRight(string(runes), "\x00")
+	case 1, 2:
+		order := binary.ByteOrder(binary.BigEndian)
+		if encoding == 1 && len(b) >= 2 {
+			if b[0] == 0xFF && b[1] == 0xFE {
+				order = binary.LittleEndian
+			}
+			if (b[0] == 0xFF && b[1] == 0xFE) || (b[0] == 0xFE && b[1] == 0xFF) {
+				b = b[2:]
+			}
+		}
+		units := make([]uint16, len(b)/2)
+		for i := range units {
+			units[i] = order.Uint16(b[2*i:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	default:
+		return strings.TrimRight(string(b), "\x00")
+	}
+}
+
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7f)<<21 | int(b[1]&0x7f)<<14 | int(b[2]&0x7f)<<7 | int(b[3]&0x7f)
+}
+
+// removeUnsync drops the zero bytes unsynchronisation put after 0xFF.
+func removeUnsync(b []byte) []byte {
+	return bytes.ReplaceAll(b, []byte{0xFF, 0x00}, []byte{0xFF})
+}
+
+func skipExtendedHeader(tag []byte, version byte) []byte {
+	if len(tag) < 4 {
+		return nil
+	}
+	size := int(binary.BigEndian.Uint32(tag)) + 4
+	if version == 4 {
+		// v2.4 counts the size field itself
+		size = syncsafe(tag)
+	}
+	if size > len(tag) {
+		return nil
+	}
+	return tag[size:]
+}
+
+// mpegHeader is the part of an MPEG audio frame header needed for the duration.
+type mpegHeader struct {
+	bitrate         int // bits per second
+	sampleRate      int
+	samplesPerFrame int
+	// xingOffset is where a Xing or Info header would follow the frame header
+	xingOffset int
+}
+
+var (
+	mpeg1Bitrates = [16]int{0, 32, 40, 48, 56, 64, 80, 96, 112, 128, 160, 192, 224, 256, 320, 0}
+	mpeg2Bitrates = [16]int{0, 8, 16, 24, 32, 40, 48, 56, 64, 80, 96, 112, 128, 144, 160, 0}
+	sampleRates   = map[byte][3]int{3: {44100, 48000, 32000}, 2: {22050, 24000, 16000}, 0: {11025, 12000, 8000}}
+)
+
+// parseMpegHeader reads a MPEG-1, 2 or 2.5 Layer III frame header.
+func parseMpegHeader(b []byte) (mpegHeader, bool) {
+	if len(b) < 4 || b[0] != 0xFF || b[1]&0xE0 != 0xE0 {
+		return mpegHeader{}, false
+	}
+	version := (b[1] >> 3) & 3
+	layer := (b[1] >> 1) & 3
+	bitrateIndex := b[2] >> 4
+	rateIndex := (b[2] >> 2) & 3
+	rates, ok := sampleRates[version]
+	if !ok || layer != 1 || rateIndex == 3 || bitrateIndex == 0 || bitrateIndex == 15 {
+		return mpegHeader{}, false
+	}
+
+	mono := b[3]>>6 == 3
+	h := mpegHeader{sampleRate: rates[rateIndex]}
+	if version == 3 {
+		h.bitrate = mpeg1Bitrates[bitrateIndex] * 1000
+		h.samplesPerFrame = 1152
+		h.xingOffset = 4 + 32
+		if mono {
+			h.xingOffset = 4 + 17
+		}
+	} else {
+		h.bitrate = mpeg2Bitrates[bitrateIndex] * 1000
+		h.samplesPerFrame = 576
+		h.xingOffset = 4 + 17
+		if mono {
+			h.xingOffset = 4 + 9
+		}
+	}
+	return h, true
+}
+
+// mp3Duration uses the frame count of a Xing or Info header for variable
+// bitrate files, otherwise the bitrate of the first frame.
+func mp3Duration(file *os.File, audioStart, fileSize int64) time.Duration {
+	buf := make([]byte, 4096)
+	n, _ := file.ReadAt(buf, audioStart)
+	buf = buf[:n]
+	for i := 0; i+4 <= len(buf); i++ {
+		h, ok := parseMpegHeader(buf[i:])
+		if !ok {
+			continue
+		}
+		if x := buf[i:]; len(x) >= h.xingOffset+12 {
+			tag := string(x[h.xingOffset : h.xingOffset+4])
+			flags := binary.BigEndian.Uint32(x[h.xingOffset+4:])
+			if (tag == "Xing" || tag == "Info") && flags&1 != 0 {
+				frames := int64(binary.BigEndian.Uint32(x[h.xingOffset+8:]))
+				return time.Duration(frames*int64(h.samplesPerFrame)*1000/int64(h.sampleRate)) * time.Millisecond
+			}
+		}
+		audioSize := fileSize - audioStart - int64(i)
+		return time.Duration(audioSize*8*1000/int64(h.bitrate)) * time.Millisecond
+	}
+	return 0
+}
@@ -0,0 +1,508 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// maxMp4BoxRead keeps a broken size field from allocating the whole file,
+// metadata and sample tables of audiobooks stay well below it.
+const maxMp4BoxRead = 64 << 20
+
+var errMp4Box = errors.New("mp4: malformed box")
+
+// isMp4Audio recognizes the ftyp box of iTunes audio files, .m4b and .m4a.
+func isMp4Audio(data []byte) bool {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return false
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size < 16 || size > len(data) {
+		return false
+	}
+	// major brand, minor version, then compatible brands
+	brands := []string{string(data[8:12])}
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, string(data[i:i+4]))
+	}
+	for _, brand := range brands {
+		if brand == "M4B " || brand == "M4A " {
+			return true
+		}
+	}
+	return false
+}
+
+// mp4Box is a box of the ISO base media file format, offset and size
+// describe the content after the header.
+type mp4Box struct {
+	typ    string
+	offset int64
+	size   int64
+}
+
+// getMp4Metadata reads iTunes tags from moov/udta/meta/ilst, the duration
+// from mvhd and chapters from a Nero chpl box or a QuickTime chapter track.
+// Only the boxes needed are read, the audio data is skipped.
+func getMp4Metadata(file *os.File) (Metadata, error) {
+	var m Metadata
+	info, err := file.Stat()
+	if err != nil {
+		return m, fmt.Errorf("mp4: %w", err)
+	}
+	top, err := readMp4Boxes(file, 0, info.Size())
+	if err != nil {
+		return m, err
+	}
+	moov, ok := findMp4Box(top, "moov")
+	if !ok {
+		return m, fmt.Errorf("mp4: no moov box")
+	}
+	children, err := readMp4Boxes(file, moov.offset, moov.size)
+	if err != nil {
+		return m, err
+	}
+
+	if mvhd, ok := findMp4Box(children, "mvhd"); ok {
+		if body, err := readMp4Body(file, mvhd); err == nil {
+			m.Duration = mvhdDuration(body)
+		}
+	}
+	if udta, ok := findMp4Box(children, "udta"); ok {
+		readMp4UserData(file, udta, &m)
+	}
+	if len(m.Chapters) == 0 {
+		m.Chapters = readMp4ChapterTrack(file, children)
+	}
+	m.Language = mp4AudioLanguage(file, children)
+	return m, nil
+}
+
+func readMp4Boxes(r io.ReaderAt, offset, size int64) ([]mp4Box, error) {
+	var boxes []mp4Box
+	end := offset + size
+	header := make([]byte, 16)
+	for pos := offset; pos+8 <= end; {
+		if _, err := r.ReadAt(header[:8], pos); err != nil {
+			return nil, fmt.Errorf("mp4: %w", err)
+		}
+		boxSize := int64(binary.BigEndian.Uint32(header))
+		headerSize := int64(8)
+		switch boxSize {
+		case 0:
+			// to the end of the file
+			boxSize = end - pos
+		case 1:
+			if _, err := r.ReadAt(header[8:16], pos+8); err != nil {
+				return nil, fmt.Errorf("mp4: %w", err)
+			}
+			boxSize = int64(binary.BigEndian.Uint64(header[8:]))
+			headerSize = 16
+		}
+		if boxSize < headerSize || pos+boxSize > end {
+			return nil, errMp4Box
+		}
+		boxes = append(boxes, mp4Box{typ: string(header[4:8]), offset: pos + headerSize, size: boxSize - headerSize})
+		pos += boxSize
+	}
+	return boxes, nil
+}
+
+func readMp4Body(r io.ReaderAt, box mp4Box) ([]byte, error) {
+	if box.size > maxMp4BoxRead {
+		return nil, errMp4Box
+	}
+	body := make([]byte, box.size)
+	if _, err := r.ReadAt(body, box.offset); err != nil {
+		return nil, fmt.Errorf("mp4: %w", err)
+	}
+	return body, nil
+}
+
+func findMp4Box(boxes []mp4Box, typ string) (mp4Box, bool) {
+	for _, box := range boxes {
+		if box.typ == typ {
+			return box, true
+		}
+	}
+	return mp4Box{}, false
+}
+
+// findMp4Path walks down nested boxes, e.g. "mdia", "minf", "stbl".
+func findMp4Path(r io.ReaderAt, parent mp4Box, path ...string) (mp4Box, bool) {
+	box := parent
+	for _, typ := range path {
+		children, err := readMp4Boxes(r, box.offset, box.size)
+		if err != nil {
+			return mp4Box{}, false
+		}
+		var ok bool
+		if box, ok = findMp4Box(children, typ); !ok {
+			return mp4Box{}, false
+		}
+	}
+	return box, true
+}
+
+func mvhdDuration(body []byte) time.Duration {
+	timescale, duration := mp4Times(body)
+	if timescale == 0 {
+		return 0
+	}
+	return time.Duration(duration*1000/timescale) * time.Millisecond
+}
+
+// mp4Times reads mvhd and mdhd boxes of version 0 and 1, both start with
+// creation and modification times.
+func mp4Times(body []byte) (timescale, duration uint64) {
+	if len(body) >= 24 && body[0] == 0 {
+		return uint64(binary.BigEndian.Uint32(body[12:])), uint64(binary.BigEndian.Uint32(body[16:]))
+	}
+	if len(body) >= 36 && body[0] == 1 {
+		return uint64(binary.BigEndian.Uint32(body[20:])), binary.BigEndian.Uint64(body[24:])
+	}
+	return 0, 0
+}
+
+func readMp4UserData(r io.ReaderAt, udta mp4Box, m *Metadata) {
+	boxes, err := readMp4Boxes(r, udta.offset, udta.size)
+	if err != nil {
+		return
+	}
+	if chpl, ok := findMp4Box(boxes, "chpl"); ok {
+		if body, err := readMp4Body(r, chpl); err == nil {
+			m.Chapters = neroChapters(body)
+		}
+	}
+	meta, ok := findMp4Box(boxes, "meta")
+	if !ok {
+		return
+	}
+	// iTunes writes meta as a full box, QuickTime without version and flags
+	head := make([]byte, 8)
+	if _, err := r.ReadAt(head, meta.offset); err != nil {
+		return
+	}
+	if string(head[4:8]) != "hdlr" {
+		meta.offset, meta.size = meta.offset+4, meta.size-4
+	}
+	ilst, ok := findMp4Path(r, meta, "ilst")
+	if !ok {
+		return
+	}
+	items, err := readMp4Boxes(r, ilst.offset, ilst.size)
+	if err != nil {
+		return
+	}
+
+	var album, albumArtist, comment string
+	for _, item := range items {
+		body, err := readMp4Body(r, item)
+		if err != nil {
+			continue
+		}
+		value, ok := mp4ItemData(body)
+		if !ok {
+			continue
+		}
+		switch item.typ {
+		case "\xa9nam":
+			m.Title = string(value)
+		case "\xa9alb":
+			album = string(value)
+		case "\xa9ART":
+			m.Author = string(value)
+		case "aART":
+			albumArtist = string(value)
+		case "\xa9day":
+			m.Date = string(value)
+		case "\xa9pub":
+			m.Publisher = string(value)
+		case "desc", "ldes":
+			if len(value) > len(m.Description) {
+				m.Description = string(value)
+			}
+		case "\xa9cmt":
+			comment = string(value)
+		case "covr":
+			if m.Cover == nil {
+				m.Cover = value
+			}
+		}
+	}
+	if m.Title == "" {
+		m.Title = album
+	}
+	if m.Author == "" {
+		m.Author = albumArtist
+	}
+	if m.Description == "" {
+		m.Description = comment
+	}
+}
+
+// mp4ItemData returns the value of the first data box of an ilst item.
+func mp4ItemData(body []byte) ([]byte, bool) {
+	if len(body) < 16 || string(body[4:8]) != "data" {
+		return nil, false
+	}
+	size := int(binary.BigEndian.Uint32(body))
+	if size < 16 || size > len(body) {
+		return nil, false
+	}
+	// type indicator and locale come before the value
+	return body[16:size], true
+}
+
+// neroChapters reads a chpl box, start times are in 100 nanosecond units.
+func neroChapters(body []byte) []Chapter {
+	if len(body) < 5 {
+		return nil
+	}
+	pos := 4
+	if body[0] == 1 {
+		pos += 4
+	}
+	if len(body) <= pos {
+		return nil
+	}
+	count := int(body[pos])
+	pos++
+	chapters := make([]Chapter, 0, count)
+	for i := 0; i < count && pos+9 <= len(body); i++ {
+		start := binary.BigEndian.Uint64(body[pos:])
+		length := int(body[pos+8])
+		pos += 9
+		if pos+length > len(body) {
+			break
+		}
+		chapters = append(chapters, Chapter{Title: string(body[pos : pos+length]), Start: time.Duration(start * 100)})
+		pos += length
+	}
+	return chapters
+}
+
+// readMp4ChapterTrack reads the text track a tref/chap box of another
+// track points to, every sample is the title of a chapter.
+func readMp4ChapterTrack(r io.ReaderAt, moov []mp4Box) []Chapter {
+	tracks := map[uint32]mp4Box{}
+	var chapterTrack uint32
+	for _, trak := range moov {
+		if trak.typ != "trak" {
+			continue
+		}
+		if tkhd, ok := findMp4Path(r, trak, "tkhd"); ok {
+			if body, err := readMp4Body(r, tkhd); err == nil {
+				tracks[tkhdTrackID(body)] = trak
+			}
+		}
+		if chap, ok := findMp4Path(r, trak, "tref", "chap"); ok && chap.size >= 4 {
+			if body, err := readMp4Body(r, chap); err == nil {
+				chapterTrack = binary.BigEndian.Uint32(body)
+			}
+		}
+	}
+	trak, ok := tracks[chapterTrack]
+	if chapterTrack == 0 || !ok {
+		return nil
+	}
+
+	mdhd, ok := findMp4Path(r, trak, "mdia", "mdhd")
+	if !ok {
+		return nil
+	}
+	mdhdBody, err := readMp4Body(r, mdhd)
+	if err != nil {
+		return nil
+	}
+	timescale, _ := mp4Times(mdhdBody)
+	stbl, ok := findMp4Path(r, trak, "mdia", "minf", "stbl")
+	if !ok || timescale == 0 {
+		return nil
+	}
+	table, err := readSampleTable(r, stbl)
+	if err != nil {
+		return nil
+	}
+
+	chapters := make([]Chapter, 0, len(table.offsets))
+	var start uint64
+	for i, offset := range table.offsets {
+		chapters = append(chapters, Chapter{
+			Title: readTextSample(r, offset, table.sizes[i]),
+			Start: time.Duration(start*1000/timescale) * time.Millisecond,
+		})
+		start += uint64(table.durations[i])
+	}
+	return chapters
+}
+
+func tkhdTrackID(body []byte) uint32 {
+	if len(body) >= 16 && body[0] == 0 {
+		return binary.BigEndian.Uint32(body[12:])
+	}
+	if len(body) >= 24 && body[0] == 1 {
+		return binary.BigEndian.Uint32(body[20:])
+	}
+	return 0
+}
+
+// sampleTable has the file offset, size and duration of every sample.
+type sampleTable struct {
+	offsets   []int64
+	sizes     []uint32
+	durations []uint32
+}
+
+func readSampleTable(r io.ReaderAt, stbl mp4Box) (sampleTable, error) {
+	var t sampleTable
+	boxes, err := readMp4Boxes(r, stbl.offset, stbl.size)
+	if err != nil {
+		return t, err
+	}
+	body := func(typ string) []byte {
+		box, ok := findMp4Box(boxes, typ)
+		if !ok {
+			return nil
+		}
+		b, _ := readMp4Body(r, box)
+		return b
+	}
+
+	// time to sample: runs of sample count and duration
+	stts := body("stts")
+	for _, e := range fullBoxEntries(stts, 8) {
+		for n := binary.BigEndian.Uint32(e); n > 0; n-- {
+			t.durations = append(t.durations, binary.BigEndian.Uint32(e[4:]))
+		}
+	}
+
+	// sample sizes, either one for all or one per sample
+	stsz := body("stsz")
+	if len(stsz) < 12 {
+		return t, errMp4Box
+	}
+	uniform, count := binary.BigEndian.Uint32(stsz[4:]), int(binary.BigEndian.Uint32(stsz[8:]))
+	for i := 0; i < count; i++ {
+		if uniform != 0 {
+			t.sizes = append(t.sizes, uniform)
+		} else if 12+4*i+4 <= len(stsz) {
+			t.sizes = append(t.sizes, binary.BigEndian.Uint32(stsz[12+4*i:]))
+		}
+	}
+
+	var chunks []int64
+	if stco := body("stco"); stco != nil {
+		for _, e := range fullBoxEntries(stco, 4) {
+			chunks = append(chunks, int64(binary.BigEndian.Uint32(e)))
+		}
+	} else {
+		for _, e := range fullBoxEntries(body("co64"), 8) {
+			chunks = append(chunks, int64(binary.BigEndian.Uint64(e)))
+		}
+	}
+
+	// sample to chunk: from first chunk on, samples per chunk
+	stsc := fullBoxEntries(body("stsc"), 12)
+	sample := 0
+	for i, e := range stsc {
+		first := int(binary.BigEndian.Uint32(e)) - 1
+		perChunk := int(binary.BigEndian.Uint32(e[4:]))
+		last := len(chunks)
+		if i+1 < len(stsc) {
+			last = int(binary.BigEndian.Uint32(stsc[i+1])) - 1
+		}
+		for chunk := first; chunk >= 0 && chunk < last && chunk < len(chunks); chunk++ {
+			offset := chunks[chunk]
+			for n := 0; n < perChunk && sample < len(t.sizes); n++ {
+				t.offsets = append(t.offsets, offset)
+				offset += int64(t.sizes[sample])
+				sample++
+			}
+		}
+	}
+
+	n := min(len(t.offsets), len(t.sizes), len(t.durations))
+	t.offsets, t.sizes, t.durations = t.offsets[:n], t.sizes[:n], t.durations[:n]
+	return t, nil
+}
+
+// fullBoxEntries splits the entries of a full box with a 32 bit count.
+func fullBoxEntries(body []byte, size int) [][]byte {
+	if len(body) < 8 {
+		return nil
+	}
+	count := int(binary.BigEndian.Uint32(body[4:]))
+	entries := make([][]byte, 0, min(count, (len(body)-8)/size))
+	for i := 0; i < count && 8+(i+1)*size <= len(body); i++ {
+		entries = append(entries, body[8+i*size:8+(i+1)*size])
+	}
+	return entries
+}
+
+// readTextSample reads a QuickTime text sample, a 16 bit length and UTF-8
+// or UTF-16 text with a byte order mark.
+func readTextSample(r io.ReaderAt, offset int64, size uint32) string {
+	if size < 2 || size > 64<<10 {
+		return ""
+	}
+	b := make([]byte, size)
+	if _, err := r.ReadAt(b, offset); err != nil {
+		return ""
+	}
+	length := int(binary.BigEndian.Uint16(b))
+	if length > len(b)-2 {
+		return ""
+	}
+	text := b[2 : 2+length]
+	if len(text) >= 2 && text[0] == 0xFE && text[1] == 0xFF {
+		units := make([]uint16, (len(text)-2)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(text[2+2*i:])
+		}
+		return string(utf16.Decode(units))
+	}
+	return strings.TrimRight(string(text), "\x00")
+}
+
+// mp4AudioLanguage is the ISO 639-2 code in the mdhd box of the sound track.
+func mp4AudioLanguage(r io.ReaderAt, moov []mp4Box) string {
+	for _, trak := range moov {
+		if trak.typ != "trak" {
+			continue
+		}
+		hdlr, ok := findMp4Path(r, trak, "mdia", "hdlr")
+		if !ok {
+			continue
+		}
+		body, err := readMp4Body(r, hdlr)
+		if err != nil || len(body) < 12 || string(body[8:12]) != "soun" {
+			continue
+		}
+		mdhd, ok := findMp4Path(r, trak, "mdia", "mdhd")
+		if !ok {
+			return ""
+		}
+		body, err = readMp4Body(r, mdhd)
+		if err != nil {
+			return ""
+		}
+		pos := 20
+		if len(body) > 0 && body[0] == 1 {
+			pos = 32
+		}
+		if len(body) < pos+2 {
+			return ""
+		}
+		// three 5 bit letters offset by 0x60
+		packed := binary.BigEndian.Uint16(body[pos:])
+		return string([]byte{byte(packed>>10&0x1f) + 0x60, byte(packed>>5&0x1f) + 0x60, byte(packed&0x1f) + 0x60})
+	}
+	return ""
+}
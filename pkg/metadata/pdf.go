@@ -2,10 +2,15 @@ package metadata
 
 import (
 	"bufio"
+	"io"
 	"os"
+	"regexp"
 	"strings"
 )
 
+// pdfPageObject matches page objects but not the /Pages tree nodes.
+var pdfPageObject = regexp.MustCompile(`/Type\s*/Page[^s]`)
+
 // PDFMetadata holds the extracted PDFmetadata information
 type PDFMetadata struct {
 	Title    string
@@ -44,9 +49,37 @@ func extractPdfMetadata(tmpFile *os.File) (Metadata, error) {
 		return Metadata{}, err
 	}
 
+	if info, err := tmpFile.Stat(); err == nil {
+		PDFmetadata.Pages = pdfPageCount(tmpFile, info.Size())
+		PDFmetadata.DOI = pdfDOI(tmpFile, info.Size())
+	}
+
 	return PDFmetadata, nil
 }
 
+// pdfPageCount counts page objects in the raw file. Pages inside compressed
+// object streams are not visible this way and the count is 0 then.
+func pdfPageCount(r io.ReaderAt, size int64) int {
+	const chunk = 1 << 20
+	const overlap = 64
+
+	count := 0
+	buf := make([]byte, chunk+overlap)
+	for off := int64(0); off < size; off += chunk {
+		n, err := r.ReadAt(buf, off)
+		for _, m := range pdfPageObject.FindAllIndex(buf[:n], -1) {
+			// matches in the overlap are counted with the next chunk
+			if m[0] < chunk {
+				count++
+			}
+		}
+		if err != nil {
+			break
+		}
+	}
+	return count
+}
+
 // extractValue extracts the value for a specific metadata field
 func extractValue(line string, field string) string {
 	start := strings.Index(line, field+"(")
@@ -0,0 +1,103 @@
+package metrics
+
+import (
+	"context"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// _collectTimeout bounds the queries run while scraping.
+const _collectTimeout = 5 * time.Second
+
+// StorageFunc reports the books in the library and the bytes their files
+// take in the book storage.
+type StorageFunc func(ctx context.Context) (books int, bytes int64, err error)
+
+type storageCollector struct {
+	usage StorageFunc
+	books *prometheus.Desc
+	bytes *prometheus.Desc
+}
+
+// NewStorageCollector reports the library size on every scrape.
+func NewStorageCollector(usage StorageFunc) prometheus.Collector {
+	return storageCollector{
+		usage: usage,
+		books: prometheus.NewDesc(namespace+"_books", "Books in the library.", nil, nil),
+		bytes: prometheus.NewDesc(namespace+"_storage_bytes", "Bytes the book files, formats and covers take.", nil, nil),
+	}
+}
+
+func (c storageCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.books
+	ch <- c.bytes
+}
+
+func (c storageCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), _collectTimeout)
+	defer cancel()
+	books, bytes, err := c.usage(ctx)
+	if err != nil {
+		ch <- prometheus.NewInvalidMetric(c.books, err)
+		ch <- prometheus.NewInvalidMetric(c.bytes, err)
+		return
+	}
+	ch <- prometheus.MustNewConstMetric(c.books, prometheus.GaugeValue, float64(books))
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(bytes))
+}
+
+// PoolStater is a database pool, like *pgxpool.Pool.
+type PoolStater interface {
+	Stat() *pgxpool.Stat
+}
+
+type poolCollector struct {
+	pool            PoolStater
+	acquired        *prometheus.Desc
+	idle            *prometheus.Desc
+	total           *prometheus.Desc
+	max             *prometheus.Desc
+	acquires        *prometheus.Desc
+	emptyAcquires   *prometheus.Desc
+	acquireDuration *prometheus.Desc
+}
+
+// NewPoolCollector reports the connections of a database pool.
+func NewPoolCollector(pool PoolStater) prometheus.Collector {
+	desc := func(name, help string) *prometheus.Desc {
+		return prometheus.NewDesc(namespace+"_db_pool_"+name, help, nil, nil)
+	}
+	return poolCollector{
+		pool:            pool,
+		acquired:        desc("acquired_connections", "Connections in use."),
+		idle:            desc("idle_connections", "Idle connections."),
+		total:           desc("connections", "Open connections."),
+		max:             desc("max_connections", "Connections the pool may open."),
+		acquires:        desc("acquires_total", "Connections taken from the pool."),
+		emptyAcquires:   desc("empty_acquires_total", "Connections taken while the pool had none idle."),
+		acquireDuration: desc("acquire_duration_seconds_total", "Time spent waiting for connections."),
+	}
+}
+
+func (c poolCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.acquired
+	ch <- c.idle
+	ch <- c.total
+	ch <- c.max
+	ch <- c.acquires
+	ch <- c.emptyAcquires
+	ch <- c.acquireDuration
+}
+
+func (c poolCollector) Collect(ch chan<- prometheus.Metric) {
+	stat := c.pool.Stat()
+	ch <- prometheus.MustNewConstMetric(c.acquired, prometheus.GaugeValue, float64(stat.AcquiredConns()))
+	ch <- prometheus.MustNewConstMetric(c.idle, prometheus.GaugeValue, float64(stat.IdleConns()))
+	ch <- prometheus.MustNewConstMetric(c.total, prometheus.GaugeValue, float64(stat.TotalConns()))
+	ch <- prometheus.MustNewConstMetric(c.max, prometheus.GaugeValue, float64(stat.MaxConns()))
+	ch <- prometheus.MustNewConstMetric(c.acquires, prometheus.CounterValue, float64(stat.AcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.emptyAcquires, prometheus.CounterValue, float64(stat.EmptyAcquireCount()))
+	ch <- prometheus.MustNewConstMetric(c.acquireDuration, prometheus.CounterValue, stat.AcquireDuration().Seconds())
+}
@@ -0,0 +1,76 @@
+// Package metrics defines the Prometheus metrics of the server. They are
+// registered with the default registry, /metrics serves them next to the Go
+// runtime ones.
+package metrics
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "kompanion"
+
+var (
+	requests = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "HTTP requests by route, method and status code.",
+	}, []string{"route", "method", "code"})
+	requestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Time spent answering HTTP requests by route and method.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"route", "method"})
+	uploads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "uploads_total",
+		Help:      "Uploaded books by result: stored, duplicate or failed.",
+	}, []string{"result"})
+	ingestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "ingest_duration_seconds",
+		Help:      "Time spent storing an uploaded book by result.",
+		Buckets:   []float64{.1, .25, .5, 1, 2.5, 5, 10, 30, 60, 120},
+	}, []string{"result"})
+	metadataDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "metadata_extraction_duration_seconds",
+		Help:      "Time spent reading the metadata of an uploaded file by format.",
+		Buckets:   []float64{.01, .05, .1, .25, .5, 1, 2.5, 5, 10, 30},
+	}, []string{"format"})
+	downloads = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "downloads_total",
+		Help:      "Downloaded book files by format.",
+	}, []string{"format"})
+)
+
+// ObserveRequest records an HTTP request. route is the pattern the request
+// matched, like /syncs/progress/:document, so the series stay few.
+func ObserveRequest(route, method string, code int, took time.Duration) {
+	requests.WithLabelValues(route, method, strconv.Itoa(code)).Inc()
+	requestDuration.WithLabelValues(route, method).Observe(took.Seconds())
+}
+
+// Library records what the book library does.
+type Library struct{}
+
+// BookUploaded -.
+func (Library) BookUploaded(result string, took time.Duration) {
+	uploads.WithLabelValues(result).Inc()
+	ingestDuration.WithLabelValues(result).Observe(took.Seconds())
+}
+
+// MetadataExtracted -.
+func (Library) MetadataExtracted(format string, took time.Duration) {
+	metadataDuration.WithLabelValues(format).Observe(took.Seconds())
+}
+
+// BookDownloaded -.
+func (Library) BookDownloaded(format string) {
+	downloads.WithLabelValues(format).Inc()
+}
@@ -0,0 +1,63 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+
+	"github.com/banjuer/kompanion/pkg/metrics"
+)
+
+func TestStorageCollector(t *testing.T) {
+	collector := metrics.NewStorageCollector(func(context.Context) (int, int64, error) {
+		return 3, 4096, nil
+	})
+	expected := `
+# HELP kompanion_books Books in the library.
+# TYPE kompanion_books gauge
+kompanion_books 3
+# HELP kompanion_storage_bytes Bytes the book files, formats and covers take.
+# TYPE kompanion_storage_bytes gauge
+kompanion_storage_bytes 4096
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected)); err != nil {
+		t.Fatal(err)
+	}
+
+	failing := metrics.NewStorageCollector(func(context.Context) (int, int64, error) {
+		return 0, 0, errors.New("database down")
+	})
+	registry := prometheus.NewPedanticRegistry()
+	registry.MustRegister(failing)
+	if _, err := registry.Gather(); err == nil || !strings.Contains(err.Error(), "database down") {
+		t.Fatalf("expected the query error, got %v", err)
+	}
+}
+
+func TestLibraryCountsUploads(t *testing.T) {
+	metrics.Library{}.BookUploaded("stored", time.Second)
+	metrics.Library{}.BookUploaded("duplicate", time.Millisecond)
+	metrics.Library{}.BookUploaded("stored", 2*time.Second)
+
+	families, err := prometheus.DefaultGatherer.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	counts := map[string]float64{}
+	for _, family := range families {
+		if family.GetName() != "kompanion_uploads_total" {
+			continue
+		}
+		for _, m := range family.GetMetric() {
+			counts[m.GetLabel()[0].GetValue()] = m.GetCounter().GetValue()
+		}
+	}
+	if counts["stored"] != 2 || counts["duplicate"] != 1 {
+		t.Fatalf("unexpected upload counts %v", counts)
+	}
+}
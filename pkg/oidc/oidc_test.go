@@ -0,0 +1,122 @@
+package oidc_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/oidc"
+)
+
+type fakeProvider struct {
+	*httptest.Server
+	claims   map[string]interface{}
+	verifier string
+	user     string
+}
+
+func newFakeProvider(t *testing.T) *fakeProvider {
+	p := &fakeProvider{}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 p.URL,
+			"authorization_endpoint": p.URL + "/authorize",
+			"token_endpoint":         p.URL + "/token",
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		p.user, _, _ = r.BasicAuth()
+		p.verifier = r.FormValue("code_verifier")
+		if r.FormValue("code") != "good-code" {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+			return
+		}
+		payload, _ := json.Marshal(p.claims)
+		token := "eyJhbGciOiJSUzI1NiJ9." + base64.RawURLEncoding.EncodeToString(payload) + ".c2ln"
+		json.NewEncoder(w).Encode(map[string]string{"id_token": token, "access_token": "at"})
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	p.claims = map[string]interface{}{
+		"iss":                p.URL,
+		"sub":                "248289761001",
+		"aud":                []string{"kompanion"},
+		"exp":                time.Now().Add(time.Hour).Unix(),
+		"nonce":              "n-0S6",
+		"preferred_username": "alice",
+		"email":              "alice@example.com",
+	}
+	return p
+}
+
+func TestAuthCodeURL(t *testing.T) {
+	p := newFakeProvider(t)
+	client := oidc.New(oidc.Config{Issuer: p.URL, ClientID: "kompanion", RedirectURL: "https://books.example.com/auth/oidc/callback"}, p.Client())
+
+	link, err := client.AuthCodeURL(context.Background(), "state", "nonce", "verifier")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatal(err)
+	}
+	q := u.Query()
+	if u.Path != "/authorize" || q.Get("scope") != "openid email profile" || q.Get("state") != "state" ||
+		q.Get("code_challenge_method") != "S256" || q.Get("code_challenge") == "" {
+		t.Errorf("unexpected login link %s", link)
+	}
+}
+
+func TestExchange(t *testing.T) {
+	p := newFakeProvider(t)
+	client := oidc.New(oidc.Config{Issuer: p.URL, ClientID: "kompanion", ClientSecret: "secret"}, p.Client())
+
+	claims, err := client.Exchange(context.Background(), "good-code", "verifier", "n-0S6")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if claims.Subject != "248289761001" || claims.Username != "alice" || claims.Issuer != p.URL {
+		t.Errorf("unexpected claims %+v", claims)
+	}
+	if p.user != "kompanion" || p.verifier != "verifier" {
+		t.Errorf("token request without client credentials or verifier: %q, %q", p.user, p.verifier)
+	}
+
+	if _, err = client.Exchange(context.Background(), "bad-code", "verifier", "n-0S6"); err == nil {
+		t.Error("expected an error for a rejected code")
+	}
+}
+
+func TestExchangeRejectsTokens(t *testing.T) {
+	tests := []struct {
+		name  string
+		claim string
+		value interface{}
+		want  error
+	}{
+		{"other client", "aud", "someone-else", oidc.ErrInvalidToken},
+		{"expired", "exp", time.Now().Add(-time.Hour).Unix(), oidc.ErrInvalidToken},
+		{"replayed", "nonce", "other", oidc.ErrInvalidToken},
+		{"other issuer", "iss", "https://evil.example.com", oidc.ErrInvalidToken},
+		{"no username", "preferred_username", "", oidc.ErrNoUsername},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := newFakeProvider(t)
+			p.claims[tt.claim] = tt.value
+			client := oidc.New(oidc.Config{Issuer: p.URL, ClientID: "kompanion"}, p.Client())
+			if _, err := client.Exchange(context.Background(), "good-code", "verifier", "n-0S6"); !errors.Is(err, tt.want) {
+				t.Errorf("expected %v, got %v", tt.want, err)
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+// Package position formats where a book was last read as a URL other tools
+// can read, and as links that open the book there in readers that support
+// it.
+//
+// A position URL is
+//
+//	/books/<book id>/position?document=<partial md5>&percentage=0.42&xpointer=...&synced_at=<unix>
+//
+// with page instead of xpointer for documents of fixed pages, and device
+// when it is known. Percentage is between 0 and 1.
+package position
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var ErrInvalid = errors.New("invalid position url")
+
+// Position is where a document was last read.
+type Position struct {
+	BookID     string    `json:"book_id"`
+	Document   string    `json:"document"`
+	Percentage float64   `json:"percentage"`
+	XPointer   string    `json:"xpointer,omitempty"` // in reflowable documents, as KOReader writes it
+	Page       int       `json:"page,omitempty"`     // in documents of fixed pages, from 1
+	Device     string    `json:"device,omitempty"`
+	SyncedAt   time.Time `json:"synced_at"`
+}
+
+// FromProgress reads the progress KOReader syncs, a page number for
+// documents of fixed pages and an xpointer for the others.
+func FromProgress(bookID, document, progress string, percentage float64, device string, timestamp int64) Position {
+	p := Position{
+		BookID:     bookID,
+		Document:   document,
+		Percentage: percentage,
+		Device:     device,
+		SyncedAt:   time.Unix(timestamp, 0).UTC(),
+	}
+	if page, err := strconv.Atoi(progress); err == nil && page > 0 {
+		p.Page = page
+	} else {
+		p.XPointer = progress
+	}
+	return p
+}
+
+// Path returns the position URL without scheme and host.
+func (p Position) Path() string {
+	q := url.Values{}
+	q.Set("document", p.Document)
+	q.Set("percentage", strconv.FormatFloat(p.Percentage, 'f', -1, 64))
+	if p.Page > 0 {
+		q.Set("page", strconv.Itoa(p.Page))
+	} else if p.XPointer != "" {
+		q.Set("xpointer", p.XPointer)
+	}
+	if p.Device != "" {
+		q.Set("device", p.Device)
+	}
+	q.Set("synced_at", strconv.FormatInt(p.SyncedAt.Unix(), 10))
+	return "/books/" + url.PathEscape(p.BookID) + "/position?" + q.Encode()
+}
+
+// Parse reads a position URL, absolute or not.
+func Parse(rawURL string) (Position, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return Position{}, fmt.Errorf("%w: %w", ErrInvalid, err)
+	}
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if len(parts) < 3 || parts[len(parts)-3] != "books" || parts[len(parts)-1] != "position" {
+		return Position{}, ErrInvalid
+	}
+
+	q := u.Query()
+	p := Position{
+		BookID:   parts[len(parts)-2],
+		Document: q.Get("document"),
+		XPointer: q.Get("xpointer"),
+		Device:   q.Get("device"),
+	}
+	if p.Percentage, err = strconv.ParseFloat(q.Get("percentage"), 64); err != nil || p.Percentage < 0 || p.Percentage > 1 {
+		return Position{}, fmt.Errorf("%w: percentage", ErrInvalid)
+	}
+	if page := q.Get("page"); page != "" {
+		if p.Page, err = strconv.Atoi(page); err != nil || p.Page < 1 {
+			return Position{}, fmt.Errorf("%w: page", ErrInvalid)
+		}
+	}
+	if synced := q.Get("synced_at"); synced != "" {
+		seconds, err := strconv.ParseInt(synced, 10, 64)
+		if err != nil {
+			return Position{}, fmt.Errorf("%w: synced_at", ErrInvalid)
+		}
+		p.SyncedAt = time.Unix(seconds, 0).UTC()
+	}
+	return p, nil
+}
+
+// DeepLink returns the link to the file of the book that opens it at the
+// position. PDF viewers go to the page of a #page fragment (RFC 8118); the
+// other formats open where KOReader left them by syncing the progress of
+// the file, so their link is the file itself.
+func (p Position) DeepLink(fileURL, format string) string {
+	if format == "pdf" && p.Page > 0 {
+		return fileURL + "#page=" + strconv.Itoa(p.Page)
+	}
+	return fileURL
+}
@@ -0,0 +1,40 @@
+package position_test
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/position"
+)
+
+func TestPosition(t *testing.T) {
+	p := position.FromProgress("book-1", "abc", "/body/DocFragment[12]/body/p[3]/text().0", 0.42, "Kobo", 1700000000)
+	parsed, err := position.Parse("https://books.example.com" + p.Path())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if parsed != p {
+		t.Errorf("expected %+v, got %+v", p, parsed)
+	}
+	if link := p.DeepLink("/dl/book-1", "epub"); link != "/dl/book-1" {
+		t.Errorf("expected the file link, got %s", link)
+	}
+
+	p = position.FromProgress("book-2", "def", "37", 0.5, "", 1700000000)
+	if p.Page != 37 || p.XPointer != "" {
+		t.Errorf("expected page 37, got %+v", p)
+	}
+	if link := p.DeepLink("/dl/book-2?sig=x", "pdf"); link != "/dl/book-2?sig=x#page=37" {
+		t.Errorf("expected a link to the page, got %s", link)
+	}
+
+	for _, rawURL := range []string{
+		"/books/book-1/position?percentage=1.5",
+		"/books/book-1?percentage=0.5",
+		"/books/book-1/position?percentage=0.5&page=0",
+	} {
+		if _, err = position.Parse(rawURL); !errors.Is(err, position.ErrInvalid) {
+			t.Errorf("expected %s to be invalid, got %v", rawURL, err)
+		}
+	}
+}
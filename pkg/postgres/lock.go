@@ -0,0 +1,197 @@
+package postgres
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"sync"
+	"time"
+
+	pgx "github.com/jackc/pgx/v5"
+)
+
+// ErrLocked - the lock is held by another process.
+var ErrLocked = errors.New("lock is held by another process")
+
+// lockRetry is how often Lock asks again for a lock another process holds.
+const lockRetry = 50 * time.Millisecond
+
+// LockConn is the part of pgx.Conn the Locker uses.
+type LockConn interface {
+	QueryRow(ctx context.Context, sql string, args ...any) pgx.Row
+	Ping(ctx context.Context) error
+	Close(ctx context.Context) error
+}
+
+// Locker takes session advisory locks on a connection of its own, outside
+// the pool: a lock is held until it is released or the process loses the
+// connection, so a server that crashed never keeps one.
+type Locker struct {
+	dial func(ctx context.Context) (LockConn, error)
+
+	mu   sync.Mutex
+	conn LockConn
+}
+
+// NewLocker - the connection is opened on the first lock.
+func NewLocker(url string) *Locker {
+	return &Locker{dial: func(ctx context.Context) (LockConn, error) {
+		return pgx.Connect(ctx, url)
+	}}
+}
+
+// MockLocker -.
+func MockLocker(conn LockConn) *Locker {
+	return &Locker{dial: func(context.Context) (LockConn, error) {
+		return conn, nil
+	}}
+}
+
+// lockKey maps name into the bigint key space of advisory locks.
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte("kompanion:" + name))
+	return int64(h.Sum64())
+}
+
+// TryLock takes the lock name without waiting, ErrLocked when another
+// process holds it. release gives the lock back.
+func (l *Locker) TryLock(ctx context.Context, name string) (release func(), err error) {
+	key := lockKey(name)
+	conn, err := l.tryLock(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return func() { l.unlock(conn, key) }, nil
+}
+
+// Lock waits for the lock name until ctx is done. A session takes one
+// advisory lock any number of times, goroutines sharing the Locker have to
+// lock among themselves first.
+func (l *Locker) Lock(ctx context.Context, name string) (release func(), err error) {
+	for {
+		release, err = l.TryLock(ctx, name)
+		if !errors.Is(err, ErrLocked) {
+			return release, err
+		}
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("postgres - Locker - Lock: %w", ctx.Err())
+		case <-time.After(lockRetry):
+		}
+	}
+}
+
+func (l *Locker) tryLock(ctx context.Context, key int64) (LockConn, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.conn == nil {
+		conn, err := l.dial(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("postgres - Locker - dial: %w", err)
+		}
+		l.conn = conn
+	}
+	var locked bool
+	if err := l.conn.QueryRow(ctx, `SELECT pg_try_advisory_lock($1)`, key).Scan(&locked); err != nil {
+		// the connection may be broken, its locks are gone with it
+		l.reset()
+		return nil, fmt.Errorf("postgres - Locker - pg_try_advisory_lock: %w", err)
+	}
+	if !locked {
+		return nil, ErrLocked
+	}
+	return l.conn, nil
+}
+
+func (l *Locker) unlock(conn LockConn, key int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	// a lock taken on a connection that was closed since is released
+	if conn != l.conn {
+		return
+	}
+	var unlocked bool
+	if err := conn.QueryRow(context.Background(), `SELECT pg_advisory_unlock($1)`, key).Scan(&unlocked); err != nil {
+		l.reset()
+	}
+}
+
+// held reports whether the locks taken on conn are still held.
+func (l *Locker) held(ctx context.Context, conn LockConn) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if conn != l.conn {
+		return false
+	}
+	if err := conn.Ping(ctx); err != nil {
+		l.reset()
+		return false
+	}
+	return true
+}
+
+// reset closes the connection, every lock on it is released. l.mu is held.
+func (l *Locker) reset() {
+	if l.conn != nil {
+		l.conn.Close(context.Background())
+		l.conn = nil
+	}
+}
+
+// Lead runs lead while this process holds the lock name and tries to take
+// it every interval while another process does, so exactly one process of
+// those sharing the database leads. The context of lead is canceled when
+// the connection holding the lock is lost. Lead returns once ctx is done
+// and lead returned.
+func (l *Locker) Lead(ctx context.Context, name string, interval time.Duration, lead func(ctx context.Context)) {
+	key := lockKey(name)
+	for {
+		if conn, err := l.tryLock(ctx, key); err == nil {
+			l.hold(ctx, conn, interval, lead)
+			l.unlock(conn, key)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+// hold runs lead and checks the connection holding its lock every interval.
+func (l *Locker) hold(ctx context.Context, conn LockConn, interval time.Duration, lead func(ctx context.Context)) {
+	leadCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		lead(leadCtx)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if !l.held(ctx, conn) {
+				cancel()
+				<-done
+				return
+			}
+		}
+	}
+}
+
+// Close closes the connection, which releases every lock.
+func (l *Locker) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.reset()
+}
@@ -0,0 +1,113 @@
+package postgres_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/pashagolub/pgxmock/v4"
+
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+func TestLockerTryLock(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker := postgres.MockLocker(conn)
+
+	conn.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).
+		WithArgs(pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"locked"}).AddRow(true))
+	conn.ExpectQuery(`SELECT pg_advisory_unlock\(\$1\)`).
+		WithArgs(pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"unlocked"}).AddRow(true))
+	release, err := locker.TryLock(ctx, "backup")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	release()
+
+	conn.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).
+		WithArgs(pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"locked"}).AddRow(false))
+	if _, err = locker.TryLock(ctx, "backup"); !errors.Is(err, postgres.ErrLocked) {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+
+	if err = conn.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLockerLockWaitsForOtherProcesses(t *testing.T) {
+	ctx := context.Background()
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker := postgres.MockLocker(conn)
+
+	conn.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).
+		WithArgs(pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"locked"}).AddRow(false))
+	conn.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).
+		WithArgs(pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"locked"}).AddRow(true))
+	conn.ExpectQuery(`SELECT pg_advisory_unlock\(\$1\)`).
+		WithArgs(pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"unlocked"}).AddRow(true))
+	release, err := locker.Lock(ctx, "ingest:hash")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	release()
+
+	conn.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).
+		WithArgs(pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"locked"}).AddRow(false))
+	canceled, cancel := context.WithTimeout(ctx, 10*time.Millisecond)
+	defer cancel()
+	if _, err = locker.Lock(canceled, "ingest:hash"); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected the deadline, got %v", err)
+	}
+
+	if err = conn.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestLockerLeadStopsWhenTheConnectionIsLost(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	conn, err := pgxmock.NewConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	locker := postgres.MockLocker(conn)
+
+	conn.ExpectQuery(`SELECT pg_try_advisory_lock\(\$1\)`).
+		WithArgs(pgxmock.AnyArg()).
+		WillReturnRows(pgxmock.NewRows([]string{"locked"}).AddRow(true))
+	conn.ExpectPing().WillReturnError(errors.New("connection reset"))
+	conn.ExpectClose()
+
+	stopped := make(chan struct{})
+	go locker.Lead(ctx, "scheduler", 10*time.Millisecond, func(ctx context.Context) {
+		<-ctx.Done()
+		close(stopped)
+		cancel()
+	})
+
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatalf("expected the leader to stop once its connection was lost")
+	}
+	if err = conn.ExpectationsWereMet(); err != nil {
+		t.Fatalf("there were unfulfilled expectations: %s", err)
+	}
+}
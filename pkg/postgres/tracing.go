@@ -0,0 +1,32 @@
+package postgres
+
+import (
+	"context"
+	"strings"
+
+	pgx "github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/pkg/tracing"
+)
+
+// queryTracer adds a span for each query of a traced request.
+type queryTracer struct{}
+
+func (queryTracer) TraceQueryStart(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryStartData) context.Context {
+	if tracing.SpanFrom(ctx) == nil {
+		return ctx
+	}
+	statement := strings.Join(strings.Fields(data.SQL), " ")
+	operation, _, _ := strings.Cut(statement, " ")
+	ctx, span := tracing.StartKind(ctx, strings.ToUpper(operation), tracing.KindClient)
+	span.SetAttribute("db.system", "postgresql")
+	span.SetAttribute("db.statement", statement)
+	return ctx
+}
+
+func (queryTracer) TraceQueryEnd(ctx context.Context, _ *pgx.Conn, data pgx.TraceQueryEndData) {
+	span := tracing.SpanFrom(ctx)
+	span.RecordError(data.Err)
+	span.SetAttribute("db.rows_affected", data.CommandTag.RowsAffected())
+	span.End()
+}
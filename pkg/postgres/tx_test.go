@@ -0,0 +1,48 @@
+package postgres_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/pashagolub/pgxmock/v4"
+
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+func TestWithTx(t *testing.T) {
+	ctx := context.Background()
+	mock, err := pgxmock.NewPool()
+	if err != nil {
+		t.Fatal(err)
+	}
+	pg := postgres.Mock(mock)
+
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE library_book").WillReturnResult(pgxmock.NewResult("UPDATE", 1))
+	mock.ExpectExec("DELETE FROM library_book_file").WillReturnResult(pgxmock.NewResult("DELETE", 1))
+	mock.ExpectCommit()
+	err = pg.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := pg.Conn(ctx).Exec(ctx, "UPDATE library_book SET title = 'x'"); err != nil {
+			return err
+		}
+		// joins the outer transaction
+		return pg.WithTx(ctx, func(ctx context.Context) error {
+			_, err := pg.Conn(ctx).Exec(ctx, "DELETE FROM library_book_file")
+			return err
+		})
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	failed := errors.New("failed")
+	mock.ExpectBegin()
+	mock.ExpectRollback()
+	if err = pg.WithTx(ctx, func(context.Context) error { return failed }); !errors.Is(err, failed) {
+		t.Fatalf("expected the error of fn, got %v", err)
+	}
+	if err = mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
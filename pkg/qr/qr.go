@@ -0,0 +1,38 @@
+// Package qr draws links as QR codes, so a phone or e-reader with a camera
+// opens them without typing.
+package qr
+
+import (
+	"encoding/base64"
+	"errors"
+
+	"github.com/skip2/go-qrcode"
+)
+
+// Sizes of the images in pixels.
+const (
+	DefaultSize = 256
+	MinSize     = 64
+	MaxSize     = 1024
+)
+
+var ErrInvalidSize = errors.New("invalid qr code size")
+
+// PNG draws content as a QR code of size by size pixels, with medium error
+// correction. It fails when content is too long for a QR code.
+func PNG(content string, size int) ([]byte, error) {
+	if size < MinSize || size > MaxSize {
+		return nil, ErrInvalidSize
+	}
+	return qrcode.Encode(content, qrcode.Medium, size)
+}
+
+// DataURL returns the QR code of content as a PNG data URL for an img,
+// empty when content can not be drawn.
+func DataURL(content string, size int) string {
+	png, err := PNG(content, size)
+	if err != nil {
+		return ""
+	}
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(png)
+}
@@ -0,0 +1,32 @@
+package qr_test
+
+import (
+	"bytes"
+	"errors"
+	"image/png"
+	"strings"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/qr"
+)
+
+func TestPNG(t *testing.T) {
+	data, err := qr.PNG("https://books.example.com/dl/book-1?expires=1700000000&sig=abc", qr.DefaultSize)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	img, err := png.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("expected a PNG, got %v", err)
+	}
+	if size := img.Bounds().Dx(); size != qr.DefaultSize {
+		t.Errorf("expected %d pixels, got %d", qr.DefaultSize, size)
+	}
+
+	if _, err = qr.PNG("https://books.example.com", qr.MaxSize+1); !errors.Is(err, qr.ErrInvalidSize) {
+		t.Errorf("expected the size to be refused, got %v", err)
+	}
+	if url := qr.DataURL(strings.Repeat("x", 5000), qr.DefaultSize); url != "" {
+		t.Errorf("expected no QR code of content too long for one, got %d bytes", len(url))
+	}
+}
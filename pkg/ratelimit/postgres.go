@@ -0,0 +1,59 @@
+package ratelimit
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+
+	"github.com/banjuer/kompanion/pkg/postgres"
+)
+
+// Postgres keeps the buckets in the database, servers sharing it share
+// the limits.
+type Postgres struct {
+	pg *postgres.Postgres
+}
+
+func NewPostgres(pg *postgres.Postgres) *Postgres {
+	return &Postgres{pg: pg}
+}
+
+// refilled is the token count of the bucket b now, at most its burst.
+const refilled = `LEAST($2::float8, b.tokens + EXTRACT(EPOCH FROM now() - b.updated_at)::float8 * $3::float8)`
+
+func (p *Postgres) Take(ctx context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	// the update is skipped when the bucket is empty, then no row returns
+	var tokens float64
+	err := p.pg.Pool.QueryRow(ctx, `
+		INSERT INTO rate_limit_bucket AS b (key, tokens, updated_at, full_at)
+		VALUES ($1, $2::float8 - 1, now(), now() + $4::float8 * interval '1 second')
+		ON CONFLICT (key) DO UPDATE
+		SET tokens = `+refilled+` - 1, updated_at = now(), full_at = now() + $4::float8 * interval '1 second'
+		WHERE `+refilled+` >= 1
+		RETURNING tokens
+	`, key, limit.Burst, limit.Rate, limit.fullAfter().Seconds()).Scan(&tokens)
+	if err == nil {
+		return true, 0, nil
+	}
+	if !errors.Is(err, pgx.ErrNoRows) {
+		return false, 0, fmt.Errorf("ratelimit - Postgres - Take - r.Pool.QueryRow: %w", err)
+	}
+
+	err = p.pg.Pool.QueryRow(ctx, `SELECT `+refilled+` FROM rate_limit_bucket b WHERE key = $1`,
+		key, limit.Burst, limit.Rate).Scan(&tokens)
+	if err != nil {
+		return false, 0, fmt.Errorf("ratelimit - Postgres - Take - r.Pool.QueryRow: %w", err)
+	}
+	return false, limit.wait(tokens), nil
+}
+
+// Prune deletes the buckets that filled up again.
+func (p *Postgres) Prune(ctx context.Context) error {
+	if _, err := p.pg.Pool.Exec(ctx, `DELETE FROM rate_limit_bucket WHERE full_at < now()`); err != nil {
+		return fmt.Errorf("ratelimit - Postgres - Prune - r.Pool.Exec: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+// Package ratelimit limits how often a client may call, with a token
+// bucket per key. Buckets are kept in memory or, for servers sharing a
+// database, in Postgres.
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Limit lets Burst calls through at once and refills Rate calls per
+// second after that.
+type Limit struct {
+	Rate  float64
+	Burst int
+}
+
+// ParseLimit reads limits like "120/m": that many calls per second, minute
+// or hour, all of them allowed at once. An empty string is no limit.
+func ParseLimit(s string) (Limit, bool, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return Limit{}, false, nil
+	}
+	count, unit, ok := strings.Cut(s, "/")
+	n, err := strconv.Atoi(strings.TrimSpace(count))
+	if !ok || err != nil || n < 1 {
+		return Limit{}, false, fmt.Errorf("rate limit %q is not like 120/m", s)
+	}
+	per := map[string]time.Duration{"s": time.Second, "m": time.Minute, "h": time.Hour}[strings.TrimSpace(unit)]
+	if per == 0 {
+		return Limit{}, false, fmt.Errorf("rate limit %q is per s, m or h", s)
+	}
+	return Limit{Rate: float64(n) / per.Seconds(), Burst: n}, true, nil
+}
+
+// Store takes a token from the bucket of key. A call that finds the bucket
+// empty is refused and told how long until the next token.
+type Store interface {
+	Take(ctx context.Context, key string, limit Limit) (ok bool, retryAfter time.Duration, err error)
+}
+
+// wait is the time until the bucket holds a whole token again.
+func (l Limit) wait(tokens float64) time.Duration {
+	return time.Duration(math.Ceil((1-tokens)/l.Rate*1000)) * time.Millisecond
+}
+
+// fullAfter is the time an untouched bucket takes to fill up.
+func (l Limit) fullAfter() time.Duration {
+	return time.Duration(float64(l.Burst) / l.Rate * float64(time.Second))
+}
+
+// Memory keeps the buckets of one server.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+	swept   time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+	full    time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{buckets: make(map[string]*bucket)}
+}
+
+// _sweepInterval is how often buckets that filled up again are dropped.
+const _sweepInterval = time.Minute
+
+func (m *Memory) Take(_ context.Context, key string, limit Limit) (bool, time.Duration, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	now := time.Now()
+	if now.Sub(m.swept) > _sweepInterval {
+		for k, b := range m.buckets {
+			if now.After(b.full) {
+				delete(m.buckets, k)
+			}
+		}
+		m.swept = now
+	}
+
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{tokens: float64(limit.Burst), updated: now}
+		m.buckets[key] = b
+	}
+	b.tokens = math.Min(float64(limit.Burst), b.tokens+now.Sub(b.updated).Seconds()*limit.Rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return false, limit.wait(b.tokens), nil
+	}
+	b.tokens--
+	b.full = now.Add(limit.fullAfter())
+	return true, 0, nil
+}
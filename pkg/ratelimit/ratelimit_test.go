@@ -0,0 +1,46 @@
+package ratelimit_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/ratelimit"
+)
+
+func TestParseLimit(t *testing.T) {
+	limit, ok, err := ratelimit.ParseLimit("120/m")
+	if err != nil || !ok || limit.Burst != 120 || limit.Rate != 2 {
+		t.Fatalf("unexpected limit %+v %v %v", limit, ok, err)
+	}
+	if _, ok, err = ratelimit.ParseLimit(""); ok || err != nil {
+		t.Fatalf("expected no limit, got %v %v", ok, err)
+	}
+	for _, s := range []string{"120", "0/m", "ten/s", "5/d"} {
+		if _, _, err = ratelimit.ParseLimit(s); err == nil {
+			t.Errorf("ParseLimit(%q): expected an error", s)
+		}
+	}
+}
+
+func TestMemoryTake(t *testing.T) {
+	ctx := context.Background()
+	store := ratelimit.NewMemory()
+	limit := ratelimit.Limit{Rate: 1.0 / 3600, Burst: 2}
+
+	for i := 0; i < 2; i++ {
+		if ok, _, err := store.Take(ctx, "sync:a", limit); !ok || err != nil {
+			t.Fatalf("call %d: expected it to go through, got %v %v", i, ok, err)
+		}
+	}
+	ok, retryAfter, err := store.Take(ctx, "sync:a", limit)
+	if ok || err != nil {
+		t.Fatalf("expected the third call refused, got %v %v", ok, err)
+	}
+	if retryAfter < 59*time.Minute || retryAfter > time.Hour {
+		t.Errorf("expected to wait about an hour, got %s", retryAfter)
+	}
+	if ok, _, _ = store.Take(ctx, "sync:b", limit); !ok {
+		t.Error("expected other clients to have their own bucket")
+	}
+}
@@ -0,0 +1,163 @@
+// Package richtext cleans the HTML found in book descriptions (EPUB
+// dc:description, FB2 annotation) down to a few formatting tags.
+package richtext
+
+import (
+	"html"
+	"strings"
+)
+
+// allowedTags are kept without attributes, everything else is unwrapped.
+var allowedTags = map[string]bool{
+	"p": true, "br": true, "b": true, "strong": true, "i": true, "em": true,
+	"ul": true, "ol": true, "li": true, "blockquote": true,
+}
+
+// droppedTags lose their content as well.
+var droppedTags = map[string]bool{
+	"script": true, "style": true, "head": true, "title": true,
+}
+
+// blockTags end a line in plain text.
+var blockTags = map[string]bool{
+	"p": true, "br": true, "li": true, "blockquote": true, "div": true,
+	"h1": true, "h2": true, "h3": true, "h4": true, "h5": true, "h6": true,
+}
+
+type token struct {
+	text    string // unescaped text when tag is empty
+	tag     string
+	closing bool
+}
+
+// Sanitize returns HTML safe to embed in a page: allowed tags without
+// attributes and escaped text. Unclosed tags are closed at the end.
+func Sanitize(s string) string {
+	var b strings.Builder
+	var open []string
+	for _, t := range tokenize(s) {
+		switch {
+		case t.tag == "":
+			b.WriteString(html.EscapeString(t.text))
+		case !allowedTags[t.tag]:
+		case t.tag == "br":
+			b.WriteString("<br>")
+		case !t.closing:
+			open = append(open, t.tag)
+			b.WriteString("<" + t.tag + ">")
+		default:
+			for i := len(open) - 1; i >= 0; i-- {
+				if open[i] != t.tag {
+					continue
+				}
+				for j := len(open) - 1; j >= i; j-- {
+					b.WriteString("</" + open[j] + ">")
+				}
+				open = open[:i]
+				break
+			}
+		}
+	}
+	for i := len(open) - 1; i >= 0; i-- {
+		b.WriteString("</" + open[i] + ">")
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// PlainText drops all markup, block elements become line breaks.
+func PlainText(s string) string {
+	var b strings.Builder
+	for _, t := range tokenize(s) {
+		switch {
+		case t.tag == "":
+			// line breaks in the source are plain whitespace in HTML
+			b.WriteString(strings.NewReplacer("\r", " ", "\n", " ").Replace(t.text))
+		case blockTags[t.tag] && (t.closing || t.tag == "br"):
+			b.WriteString("\n")
+		}
+	}
+
+	lines := strings.Split(b.String(), "\n")
+	result := lines[:0]
+	for _, line := range lines {
+		if line = strings.Join(strings.Fields(line), " "); line != "" {
+			result = append(result, line)
+		}
+	}
+	return strings.Join(result, "\n")
+}
+
+// tokenize splits s into text and tags, text of dropped elements and
+// comments is skipped.
+func tokenize(s string) []token {
+	var tokens []token
+	skip := ""
+	for len(s) > 0 {
+		lt := strings.IndexByte(s, '<')
+		if lt != 0 {
+			text := s
+			if lt > 0 {
+				text = s[:lt]
+			}
+			if skip == "" {
+				tokens = append(tokens, token{text: html.UnescapeString(text)})
+			}
+			if lt < 0 {
+				break
+			}
+			s = s[lt:]
+			continue
+		}
+		if strings.HasPrefix(s, "<!--") {
+			end := strings.Index(s, "-->")
+			if end < 0 {
+				break
+			}
+			s = s[end+3:]
+			continue
+		}
+		gt := strings.IndexByte(s, '>')
+		if gt < 0 {
+			// a stray "<" is text
+			if skip == "" {
+				tokens = append(tokens, token{text: s})
+			}
+			break
+		}
+		t := parseTag(s[1:gt])
+		s = s[gt+1:]
+		if skip != "" {
+			if t.closing && t.tag == skip {
+				skip = ""
+			}
+			continue
+		}
+		if droppedTags[t.tag] && !t.closing {
+			skip = t.tag
+			continue
+		}
+		if t.tag != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+func parseTag(s string) token {
+	var t token
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "/") {
+		t.closing = true
+		s = s[1:]
+	}
+	s = strings.TrimSuffix(s, "/")
+	if i := strings.IndexAny(s, " \t\n\r/"); i >= 0 {
+		s = s[:i]
+	}
+	// namespaced XHTML tags such as <h:p>
+	if i := strings.IndexByte(s, ':'); i >= 0 {
+		s = s[i+1:]
+	}
+	t.tag = strings.ToLower(s)
+	return t
+}
@@ -0,0 +1,36 @@
+package richtext_test
+
+import (
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/richtext"
+)
+
+func TestSanitize(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"plain text is escaped", "Tom & Jerry <3", "Tom &amp; Jerry &lt;3"},
+		{"allowed tags lose attributes", `<p class="x" onclick="alert(1)">A <em>novel</em></p>`, "<p>A <em>novel</em></p>"},
+		{"other tags are unwrapped", `<div><a href="javascript:x">link</a></div>`, "link"},
+		{"scripts are dropped", "<p>ok</p><script>alert(1)</script>", "<p>ok</p>"},
+		{"unclosed tags are closed", "<p><b>bold", "<p><b>bold</b></p>"},
+		{"stray closing tags are ignored", "</p>text</b>", "text"},
+		{"entities survive", "<p>caf&eacute; &lt;b&gt;</p>", "<p>café &lt;b&gt;</p>"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := richtext.Sanitize(tt.in); got != tt.want {
+				t.Errorf("Sanitize(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPlainText(t *testing.T) {
+	in := "<p>First\n  paragraph with <b>bold</b> text.</p><p>Second &amp; last.</p><style>p{}</style>"
+	want := "First paragraph with bold text.\nSecond & last."
+	if got := richtext.PlainText(in); got != want {
+		t.Errorf("PlainText() = %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,162 @@
+// Package sentry sends error events to Sentry or a compatible server (GlitchTip)
+// over the plain store API, without pulling in the full SDK.
+package sentry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const _defaultTimeout = 5 * time.Second
+
+// Frame is a single stack frame, Event.Frames are ordered oldest call first.
+type Frame struct {
+	Function string `json:"function"`
+	Module   string `json:"module,omitempty"`
+	Filename string `json:"filename"`
+	AbsPath  string `json:"abs_path,omitempty"`
+	Lineno   int    `json:"lineno"`
+	InApp    bool   `json:"in_app"`
+}
+
+// Request describes the HTTP request an event happened in.
+type Request struct {
+	Method      string            `json:"method"`
+	URL         string            `json:"url"`
+	QueryString string            `json:"query_string,omitempty"`
+	Headers     map[string]string `json:"headers,omitempty"`
+}
+
+// Event -.
+type Event struct {
+	Type    string
+	Message string
+	Frames  []Frame
+	Request *Request
+	Tags    map[string]string
+}
+
+// Client -.
+type Client struct {
+	endpoint    string
+	key         string
+	release     string
+	environment string
+	client      *http.Client
+}
+
+// New parses a DSN like https://<key>@sentry.example.com/<project>.
+func New(dsn, release, environment string) (*Client, error) {
+	u, err := url.Parse(dsn)
+	if err != nil {
+		return nil, fmt.Errorf("sentry - New - url.Parse: %w", err)
+	}
+	if u.User == nil || u.User.Username() == "" {
+		return nil, fmt.Errorf("sentry - New - dsn has no public key")
+	}
+	i := strings.LastIndex(u.Path, "/")
+	project := u.Path[i+1:]
+	if project == "" {
+		return nil, fmt.Errorf("sentry - New - dsn has no project id")
+	}
+
+	endpoint := fmt.Sprintf("%s://%s%s/api/%s/store/", u.Scheme, u.Host, u.Path[:i], project)
+	return &Client{
+		endpoint:    endpoint,
+		key:         u.User.Username(),
+		release:     release,
+		environment: environment,
+		client:      &http.Client{Timeout: _defaultTimeout},
+	}, nil
+}
+
+// SetHTTPClient sends events with client, e.g. one going through a proxy.
+func (c *Client) SetHTTPClient(client *http.Client) {
+	c.client = client
+}
+
+// Capture sends the event and returns its id.
+func (c *Client) Capture(ctx context.Context, e Event) (string, error) {
+	id, err := eventID()
+	if err != nil {
+		return "", fmt.Errorf("sentry - Capture - eventID: %w", err)
+	}
+
+	body, err := json.Marshal(c.payload(id, e))
+	if err != nil {
+		return "", fmt.Errorf("sentry - Capture - json.Marshal: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return "", fmt.Errorf("sentry - Capture - http.NewRequest: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Sentry-Auth", fmt.Sprintf(
+		"Sentry sentry_version=7, sentry_client=kompanion/%s, sentry_key=%s", c.release, c.key,
+	))
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("sentry - Capture - client.Do: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return "", fmt.Errorf("sentry - Capture - status %d: %s", resp.StatusCode, msg)
+	}
+	return id, nil
+}
+
+func (c *Client) payload(id string, e Event) map[string]interface{} {
+	typ := e.Type
+	if typ == "" {
+		typ = "error"
+	}
+	p := map[string]interface{}{
+		"event_id":  id,
+		"timestamp": time.Now().UTC().Format(time.RFC3339),
+		"level":     "error",
+		"platform":  "go",
+		"logger":    "kompanion",
+		"exception": map[string]interface{}{
+			"values": []interface{}{
+				map[string]interface{}{
+					"type":       typ,
+					"value":      e.Message,
+					"stacktrace": map[string]interface{}{"frames": e.Frames},
+				},
+			},
+		},
+	}
+	if c.release != "" {
+		p["release"] = c.release
+	}
+	if c.environment != "" {
+		p["environment"] = c.environment
+	}
+	if e.Request != nil {
+		p["request"] = e.Request
+	}
+	if len(e.Tags) > 0 {
+		p["tags"] = e.Tags
+	}
+	return p
+}
+
+func eventID() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
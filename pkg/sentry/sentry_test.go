@@ -0,0 +1,57 @@
+package sentry_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/sentry"
+)
+
+func TestNewRejectsInvalidDSN(t *testing.T) {
+	for _, dsn := range []string{"https://sentry.example.com/1", "https://key@sentry.example.com/"} {
+		if _, err := sentry.New(dsn, "", ""); err == nil {
+			t.Errorf("expected error for %q", dsn)
+		}
+	}
+}
+
+func TestCapturePostsToStoreEndpoint(t *testing.T) {
+	var path, auth string
+	var body map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		path = r.URL.Path
+		auth = r.Header.Get("X-Sentry-Auth")
+		_ = json.NewDecoder(r.Body).Decode(&body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	dsn := strings.Replace(server.URL, "://", "://public@", 1) + "/glitchtip/42"
+	client, err := sentry.New(dsn, "1.0.0", "test")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	id, err := client.Capture(context.Background(), sentry.Event{
+		Type:    "panic",
+		Message: "boom",
+		Request: &sentry.Request{Method: "GET", URL: "/books"},
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if path != "/glitchtip/api/42/store/" {
+		t.Errorf("expected store endpoint, got %q", path)
+	}
+	if !strings.Contains(auth, "sentry_key=public") {
+		t.Errorf("expected public key in auth header, got %q", auth)
+	}
+	if body["event_id"] != id || body["environment"] != "test" {
+		t.Errorf("unexpected payload %v", body)
+	}
+}
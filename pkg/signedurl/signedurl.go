@@ -0,0 +1,67 @@
+// Package signedurl makes short-lived links that carry their own
+// authorization, for clients that cannot send credentials.
+package signedurl
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid link signature")
+	ErrExpired          = errors.New("link expired")
+)
+
+type Signer struct {
+	key []byte
+	ttl time.Duration
+	now func() time.Time
+}
+
+// New signs links valid for ttl. Without a key a random one is used,
+// links then stop working on restart.
+func New(key []byte, ttl time.Duration) (*Signer, error) {
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, err
+		}
+	}
+	return &Signer{key: key, ttl: ttl, now: time.Now}, nil
+}
+
+// Sign returns path with expires and sig query parameters.
+func (s *Signer) Sign(path string) (string, time.Time) {
+	expires := s.now().Add(s.ttl).Truncate(time.Second)
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	q := url.Values{"expires": {exp}, "sig": {s.signature(path, exp)}}
+	return path + "?" + q.Encode(), expires
+}
+
+// Verify checks the expires and sig parameters of a link to path.
+func (s *Signer) Verify(path string, query url.Values) error {
+	exp := query.Get("expires")
+	expires, err := strconv.ParseInt(exp, 10, 64)
+	if err != nil {
+		return ErrInvalidSignature
+	}
+	if !hmac.Equal([]byte(query.Get("sig")), []byte(s.signature(path, exp))) {
+		return ErrInvalidSignature
+	}
+	if s.now().Unix() > expires {
+		return ErrExpired
+	}
+	return nil
+}
+
+func (s *Signer) signature(path, expires string) string {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write([]byte(path + "\n" + expires))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,50 @@
+package signedurl_test
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/signedurl"
+)
+
+func query(t *testing.T, link string) url.Values {
+	t.Helper()
+	u, err := url.Parse(link)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return u.Query()
+}
+
+func TestSignAndVerify(t *testing.T) {
+	s, err := signedurl.New([]byte("key"), time.Minute)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	link, expires := s.Sign("/dl/1")
+	if !strings.HasPrefix(link, "/dl/1?") || time.Until(expires) > time.Minute {
+		t.Fatalf("unexpected link %q expiring %v", link, expires)
+	}
+	if err := s.Verify("/dl/1", query(t, link)); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := s.Verify("/dl/2", query(t, link)); !errors.Is(err, signedurl.ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for another path, got %v", err)
+	}
+
+	other, _ := signedurl.New([]byte("other"), time.Minute)
+	if err := other.Verify("/dl/1", query(t, link)); !errors.Is(err, signedurl.ErrInvalidSignature) {
+		t.Errorf("expected ErrInvalidSignature for another key, got %v", err)
+	}
+}
+
+func TestVerifyExpired(t *testing.T) {
+	s, _ := signedurl.New([]byte("key"), -time.Minute)
+	link, _ := s.Sign("/dl/1")
+	if err := s.Verify("/dl/1", query(t, link)); !errors.Is(err, signedurl.ErrExpired) {
+		t.Errorf("expected ErrExpired, got %v", err)
+	}
+}
@@ -0,0 +1,189 @@
+// Package sqlite implements a connection to a database file, for servers
+// running without Postgres.
+package sqlite
+
+import (
+	"database/sql"
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/mattn/go-sqlite3"
+	"golang.org/x/text/unicode/norm"
+
+	"github.com/banjuer/kompanion/pkg/trigram"
+)
+
+// DriverName is the database/sql driver of New, sqlite3 with the functions
+// the shared SQL of the repos expects from Postgres.
+const DriverName = "sqlite3_kompanion"
+
+// TimeFormat is how times are stored, in UTC they sort as text.
+const TimeFormat = "2006-01-02 15:04:05.999999999-07:00"
+
+func init() {
+	sql.Register(DriverName, &sqlite3.SQLiteDriver{
+		ConnectHook: func(conn *sqlite3.SQLiteConn) error {
+			functions := []struct {
+				name string
+				impl any
+				pure bool
+			}{
+				{"now", func() string { return time.Now().UTC().Format(TimeFormat) }, false},
+				// lower and upper of SQLite only know ASCII
+				{"lower", strict(strings.ToLower), true},
+				{"upper", strict(strings.ToUpper), true},
+				{"unaccent", strict(unaccent), true},
+				{"regexp_replace", regexpReplace, true},
+				{"similarity", similarity, true},
+			}
+			for _, f := range functions {
+				if err := conn.RegisterFunc(f.name, f.impl, f.pure); err != nil {
+					return fmt.Errorf("sqlite - RegisterFunc %s: %w", f.name, err)
+				}
+			}
+			return nil
+		},
+	})
+}
+
+// SQLite -.
+type SQLite struct {
+	DB *sql.DB
+}
+
+// New opens the database file at path, created when missing. Statements
+// share one connection: SQLite writes one at a time anyway and a
+// transaction of WithTx sees every statement of its context.
+func New(path string) (*SQLite, error) {
+	db, err := sql.Open(DriverName, DSN(path))
+	if err != nil {
+		return nil, fmt.Errorf("sqlite - New - sql.Open: %w", err)
+	}
+	db.SetMaxOpenConns(1)
+	if err = db.Ping(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("sqlite - New - db.Ping: %w", err)
+	}
+	return &SQLite{DB: db}, nil
+}
+
+// DSN is the data source of the file at path with foreign keys enforced.
+func DSN(path string) string {
+	q := url.Values{}
+	q.Set("_foreign_keys", "1")
+	q.Set("_busy_timeout", "5000")
+	q.Set("_journal_mode", "WAL")
+	return "file:" + path + "?" + q.Encode()
+}
+
+// Close -.
+func (s *SQLite) Close() {
+	if s.DB != nil {
+		s.DB.Close()
+	}
+}
+
+// strict makes f return NULL for NULL like the functions of SQL, the
+// driver can not pass NULL as a string.
+func strict(f func(string) string) func(any) any {
+	return func(v any) any {
+		switch s := v.(type) {
+		case string:
+			return f(s)
+		case []byte:
+			if s == nil {
+				return nil
+			}
+			return f(string(s))
+		case nil:
+			return nil
+		default:
+			return f(fmt.Sprint(s))
+		}
+	}
+}
+
+// unaccent drops the accents of s like the extension of Postgres.
+func unaccent(s string) string {
+	var b strings.Builder
+	for _, r := range norm.NFD.String(s) {
+		if !unicode.Is(unicode.Mn, r) {
+			b.WriteRune(r)
+		}
+	}
+	return norm.NFC.String(b.String())
+}
+
+// regexpReplace follows regexp_replace of Postgres for the flags i and g.
+func regexpReplace(v any, pattern, replacement, flags string) (any, error) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, nil
+	}
+	if strings.Contains(flags, "i") {
+		pattern = "(?i)" + pattern
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	if strings.Contains(flags, "g") {
+		return re.ReplaceAllString(s, replacement), nil
+	}
+	loc := re.FindStringSubmatchIndex(s)
+	if loc == nil {
+		return s, nil
+	}
+	return s[:loc[0]] + string(re.ExpandString(nil, replacement, s, loc)) + s[loc[1]:], nil
+}
+
+// Time scans a time SQLite returns as text, as expressions like max() of a
+// TIMESTAMP column lose the type the driver parses by. NULL scans as the
+// zero time.
+func Time(t *time.Time) sql.Scanner {
+	return timeScanner{t}
+}
+
+type timeScanner struct {
+	t *time.Time
+}
+
+func (s timeScanner) Scan(v any) error {
+	switch v := v.(type) {
+	case nil:
+		*s.t = time.Time{}
+	case time.Time:
+		*s.t = v
+	case string:
+		return s.parse(v)
+	case []byte:
+		return s.parse(string(v))
+	default:
+		return fmt.Errorf("sqlite - Time - can not scan %T", v)
+	}
+	return nil
+}
+
+func (s timeScanner) parse(v string) error {
+	for _, format := range sqlite3.SQLiteTimestampFormats {
+		if t, err := time.Parse(format, v); err == nil {
+			*s.t = t
+			return nil
+		}
+	}
+	return fmt.Errorf("sqlite - Time - can not parse %q", v)
+}
+
+// similarity follows similarity() of the pg_trgm extension.
+func similarity(a, b any) any {
+	sa, okA := a.(string)
+	sb, okB := b.(string)
+	if !okA || !okB {
+		return nil
+	}
+	return trigram.Similarity(sa, sb)
+}
@@ -0,0 +1,72 @@
+package sqlite_test
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/sqlite"
+)
+
+func TestRebind(t *testing.T) {
+	got := sqlite.Rebind(`SELECT '$1', "$2" FROM t WHERE a = $2 AND b = $1`)
+	if want := `SELECT '$1', "$2" FROM t WHERE a = ?2 AND b = ?1`; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestSQLite(t *testing.T) {
+	ctx := context.Background()
+	db, err := sqlite.New(filepath.Join(t.TempDir(), "test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	if _, err = db.DB.Exec(`CREATE TABLE t (name TEXT, at TIMESTAMP)`); err != nil {
+		t.Fatal(err)
+	}
+
+	var folded, replaced, title string
+	var missing *string
+	err = db.Conn(ctx).QueryRow(ctx, `
+		SELECT lower(unaccent($1)), regexp_replace($2, '[- ]', '', 'g'),
+			regexp_replace($3, '^(the)\s+', '', 'i'), upper(NULL)
+	`, "ÉCOLE Ürün", "978-3 16", "The Hobbit").Scan(&folded, &replaced, &title, &missing)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if folded != "ecole urun" || replaced != "978316" || title != "Hobbit" || missing != nil {
+		t.Errorf("unexpected results %q %q %q %v", folded, replaced, title, missing)
+	}
+
+	at := time.Date(2024, 5, 1, 12, 0, 0, 0, time.FixedZone("CEST", 2*60*60))
+	failed := errors.New("failed")
+	err = db.WithTx(ctx, func(ctx context.Context) error {
+		if _, err := db.Conn(ctx).Exec(ctx, `INSERT INTO t VALUES ($1, $2)`, "rolled back", at); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("expected the error of the transaction, got %v", err)
+	}
+	err = db.WithTx(ctx, func(ctx context.Context) error {
+		_, err := db.Conn(ctx).Exec(ctx, `INSERT INTO t VALUES ($1, $2)`, "kept", at)
+		return err
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	var names int
+	var latest time.Time
+	err = db.Conn(ctx).QueryRow(ctx, `SELECT count(*), max(at) FROM t WHERE at < now()`).Scan(&names, sqlite.Time(&latest))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if names != 1 || !latest.Equal(at) {
+		t.Errorf("expected the kept row at %v, got %d at %v", at, names, latest)
+	}
+}
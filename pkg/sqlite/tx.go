@@ -0,0 +1,114 @@
+package sqlite
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Querier runs statements, on the database or in a transaction. Parameters
+// are numbered $1, $2, ... like in Postgres.
+type Querier interface {
+	Exec(ctx context.Context, query string, args ...any) (sql.Result, error)
+	Query(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRow(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+// execer is what *sql.DB and *sql.Tx have in common.
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...any) (sql.Result, error)
+	QueryContext(ctx context.Context, query string, args ...any) (*sql.Rows, error)
+	QueryRowContext(ctx context.Context, query string, args ...any) *sql.Row
+}
+
+type querier struct {
+	db execer
+}
+
+func (q querier) Exec(ctx context.Context, query string, args ...any) (sql.Result, error) {
+	return q.db.ExecContext(ctx, Rebind(query), bindArgs(args)...)
+}
+
+func (q querier) Query(ctx context.Context, query string, args ...any) (*sql.Rows, error) {
+	return q.db.QueryContext(ctx, Rebind(query), bindArgs(args)...)
+}
+
+func (q querier) QueryRow(ctx context.Context, query string, args ...any) *sql.Row {
+	return q.db.QueryRowContext(ctx, Rebind(query), bindArgs(args)...)
+}
+
+type txKey struct{}
+
+// WithTx runs fn in a transaction, committed when fn returns nil and rolled
+// back otherwise. Repos take the transaction from the context of fn with
+// Conn; a WithTx inside fn joins it.
+func (s *SQLite) WithTx(ctx context.Context, fn func(ctx context.Context) error) error {
+	if _, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return fn(ctx)
+	}
+	tx, err := s.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("sqlite - WithTx - s.DB.BeginTx: %w", err)
+	}
+	defer tx.Rollback()
+
+	if err = fn(context.WithValue(ctx, txKey{}, tx)); err != nil {
+		return err
+	}
+	if err = tx.Commit(); err != nil {
+		return fmt.Errorf("sqlite - WithTx - tx.Commit: %w", err)
+	}
+	return nil
+}
+
+// Conn returns the transaction WithTx runs ctx in, the database outside of
+// one.
+func (s *SQLite) Conn(ctx context.Context) Querier {
+	if tx, ok := ctx.Value(txKey{}).(*sql.Tx); ok {
+		return querier{tx}
+	}
+	return querier{s.DB}
+}
+
+// Rebind turns the $n parameters of Postgres into ?n, SQLite numbers $n by
+// their first appearance instead. Quoted text is left alone.
+func Rebind(query string) string {
+	var b strings.Builder
+	b.Grow(len(query))
+	quote := byte(0)
+	for i := 0; i < len(query); i++ {
+		c := query[i]
+		switch {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			}
+		case c == '\'' || c == '"':
+			quote = c
+		case c == '$' && i+1 < len(query) && query[i+1] >= '0' && query[i+1] <= '9':
+			c = '?'
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// bindArgs stores times in UTC, the driver writes them with their offset
+// and text comparisons need the same one.
+func bindArgs(args []any) []any {
+	for i, arg := range args {
+		switch v := arg.(type) {
+		case time.Time:
+			args[i] = v.UTC().Format(TimeFormat)
+		case *time.Time:
+			if v == nil {
+				args[i] = nil
+			} else {
+				args[i] = v.UTC().Format(TimeFormat)
+			}
+		}
+	}
+	return args
+}
@@ -0,0 +1,128 @@
+package thumbnail
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image/png"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+var ErrNoEncoder = errors.New("no encoder for the image format")
+
+// Encoder makes WebP and AVIF covers with cwebp of libwebp and avifenc of
+// libavif, Go has no encoders for them. A format whose tool is not
+// installed is not offered.
+type Encoder struct {
+	binaries map[string]string
+}
+
+// NewEncoder - webp and avif are the cwebp and avifenc executables, looked
+// up in PATH when empty.
+func NewEncoder(webp, avif string) *Encoder {
+	if webp == "" {
+		webp = "cwebp"
+	}
+	if avif == "" {
+		avif = "avifenc"
+	}
+	e := &Encoder{binaries: map[string]string{}}
+	for format, binary := range map[string]string{FormatWebP: webp, FormatAVIF: avif} {
+		if path, err := exec.LookPath(binary); err == nil {
+			e.binaries[format] = path
+		}
+	}
+	return e
+}
+
+// Formats lists the formats besides JPEG and PNG the encoder makes, AVIF
+// first as it is the smaller.
+func (e *Encoder) Formats() []string {
+	var formats []string
+	for _, format := range []string{FormatAVIF, FormatWebP} {
+		if _, ok := e.binaries[format]; ok {
+			formats = append(formats, format)
+		}
+	}
+	return formats
+}
+
+// Resize is thumbnail.Resize making WebP and AVIF as well.
+func (e *Encoder) Resize(ctx context.Context, r io.Reader, o Options) ([]byte, string, error) {
+	binary, ok := e.binaries[o.Format]
+	if !ok {
+		return Resize(r, o)
+	}
+	dst, _, err := decodeScaled(r, o)
+	if err != nil {
+		return nil, "", err
+	}
+
+	dir, err := os.MkdirTemp("", "thumbnail-")
+	if err != nil {
+		return nil, "", fmt.Errorf("thumbnail - Resize - os.MkdirTemp: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	source, target := filepath.Join(dir, "cover.png"), filepath.Join(dir, "cover."+o.Format)
+	file, err := os.Create(source)
+	if err != nil {
+		return nil, "", fmt.Errorf("thumbnail - Resize - os.Create: %w", err)
+	}
+	err = png.Encode(file, dst)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("thumbnail - Resize - png.Encode: %w", err)
+	}
+
+	var args []string
+	if o.Format == FormatWebP {
+		args = []string{"-quiet", "-q", strconv.Itoa(jpegQuality), source, "-o", target}
+	} else {
+		args = []string{"-q", "60", source, target}
+	}
+	if out, err := exec.CommandContext(ctx, binary, args...).CombinedOutput(); err != nil {
+		return nil, "", fmt.Errorf("thumbnail - Resize - %s: %w: %s", filepath.Base(binary), err, strings.TrimSpace(string(out)))
+	}
+	data, err := os.ReadFile(target)
+	if err != nil {
+		return nil, "", fmt.Errorf("thumbnail - Resize - os.ReadFile: %w", err)
+	}
+	return data, "image/" + o.Format, nil
+}
+
+// Negotiate picks the format of a cover from the Accept header of the
+// request: the first of formats the client takes, empty when it takes
+// none of them or leaves the type to the server.
+func Negotiate(accept string, formats []string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		if strings.ReplaceAll(params, " ", "") == "q=0" {
+			continue
+		}
+		accepted[strings.ToLower(strings.TrimSpace(mediaType))] = true
+	}
+	for _, format := range formats {
+		if accepted["image/"+format] {
+			return format
+		}
+	}
+	return ""
+}
+
+// DetectContentType is http.DetectContentType knowing AVIF, which the
+// standard library sniffs as application/octet-stream.
+func DetectContentType(data []byte) string {
+	if len(data) >= 12 && string(data[4:8]) == "ftyp" && (string(data[8:12]) == "avif" || string(data[8:12]) == "avis") {
+		return "image/avif"
+	}
+	return http.DetectContentType(data)
+}
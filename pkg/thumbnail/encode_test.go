@@ -0,0 +1,73 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/thumbnail"
+)
+
+func TestNegotiate(t *testing.T) {
+	formats := []string{thumbnail.FormatAVIF, thumbnail.FormatWebP}
+	tests := []struct {
+		accept string
+		want   string
+	}{
+		{"image/avif,image/webp,image/apng,image/*,*/*;q=0.8", thumbnail.FormatAVIF},
+		{"image/webp,*/*", thumbnail.FormatWebP},
+		{"image/avif;q=0, image/webp", thumbnail.FormatWebP},
+		{"*/*", ""},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := thumbnail.Negotiate(tc.accept, formats); got != tc.want {
+			t.Errorf("Negotiate(%q) = %q, want %q", tc.accept, got, tc.want)
+		}
+	}
+	if got := thumbnail.Negotiate("image/avif", nil); got != "" {
+		t.Errorf("expected no format without encoders, got %q", got)
+	}
+}
+
+func TestEncoderResize(t *testing.T) {
+	// a cwebp writing its input to the file after -o
+	script := filepath.Join(t.TempDir(), "cwebp")
+	err := os.WriteFile(script, []byte("#!/bin/sh\nwhile [ \"$1\" != \"-o\" ]; do in=$1; shift; done\nprintf 'RIFF' > \"$2\"\ncat \"$in\" >> \"$2\"\n"), 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoder := thumbnail.NewEncoder(script, filepath.Join(t.TempDir(), "missing"))
+	if formats := encoder.Formats(); len(formats) != 1 || formats[0] != thumbnail.FormatWebP {
+		t.Fatalf("expected only webp, got %v", formats)
+	}
+
+	data, contentType, err := encoder.Resize(context.Background(), bytes.NewReader(cover(t, 600, 900)), thumbnail.Options{Width: 300, Fit: thumbnail.FitContain, Format: thumbnail.FormatWebP})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if contentType != "image/webp" || !bytes.HasPrefix(data, []byte("RIFF\x89PNG")) {
+		t.Fatalf("expected the scaled PNG passed to cwebp, got %s %q", contentType, data[:min(len(data), 8)])
+	}
+
+	// formats without a tool fall back to Resize
+	_, contentType, err = encoder.Resize(context.Background(), bytes.NewReader(cover(t, 600, 900)), thumbnail.Options{Width: 300, Fit: thumbnail.FitContain})
+	if err != nil || contentType != "image/jpeg" {
+		t.Fatalf("expected a jpeg, got %s %v", contentType, err)
+	}
+	if _, _, err = thumbnail.Resize(bytes.NewReader(cover(t, 60, 90)), thumbnail.Options{Width: 30, Fit: thumbnail.FitContain, Format: thumbnail.FormatAVIF}); !errors.Is(err, thumbnail.ErrNoEncoder) {
+		t.Fatal("expected ErrNoEncoder for avif without an encoder")
+	}
+}
+
+func TestDetectContentType(t *testing.T) {
+	if got := thumbnail.DetectContentType([]byte("\x00\x00\x00\x1cftypavif\x00\x00\x00\x00avifmif1")); got != "image/avif" {
+		t.Errorf("expected image/avif, got %s", got)
+	}
+	if got := thumbnail.DetectContentType(cover(t, 10, 10)); got != "image/jpeg" {
+		t.Errorf("expected image/jpeg, got %s", got)
+	}
+}
@@ -0,0 +1,203 @@
+// Package thumbnail scales cover images to the size a client asks for.
+package thumbnail
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"strconv"
+
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp"
+)
+
+// MaxSize bounds requested dimensions, larger covers are never needed.
+const MaxSize = 2000
+
+const jpegQuality = 85
+
+// Fit decides how the image is placed into the requested box.
+type Fit string
+
+const (
+	// FitContain scales the image to fit inside the box, keeping its ratio.
+	FitContain Fit = "contain"
+	// FitCover fills the box and crops what sticks out.
+	FitCover Fit = "cover"
+	// FitFill stretches the image to the box.
+	FitFill Fit = "fill"
+)
+
+var ErrInvalidOptions = errors.New("invalid thumbnail options")
+
+// Image formats a cover can be sent in, WebP and AVIF need an Encoder.
+const (
+	FormatJPEG = "jpeg"
+	FormatPNG  = "png"
+	FormatWebP = "webp"
+	FormatAVIF = "avif"
+)
+
+// Options is the requested box, a zero width or height follows the ratio.
+// An empty format keeps PNG and GIF sources and makes JPEG of the others.
+type Options struct {
+	Width  int
+	Height int
+	Fit    Fit
+	Format string
+}
+
+// ParseOptions reads w, h and fit query parameters.
+func ParseOptions(w, h, fit string) (Options, error) {
+	var o Options
+	var err error
+	if w != "" {
+		if o.Width, err = strconv.Atoi(w); err != nil {
+			return Options{}, fmt.Errorf("%w: width %q", ErrInvalidOptions, w)
+		}
+	}
+	if h != "" {
+		if o.Height, err = strconv.Atoi(h); err != nil {
+			return Options{}, fmt.Errorf("%w: height %q", ErrInvalidOptions, h)
+		}
+	}
+	o.Fit = Fit(fit)
+	if o.Fit == "" {
+		o.Fit = FitContain
+	}
+	return o, o.Validate()
+}
+
+func (o Options) Validate() error {
+	if o.Width < 0 || o.Height < 0 || o.Width > MaxSize || o.Height > MaxSize {
+		return fmt.Errorf("%w: size must be between 0 and %d", ErrInvalidOptions, MaxSize)
+	}
+	switch o.Fit {
+	case FitContain, FitCover, FitFill:
+	default:
+		return fmt.Errorf("%w: unknown fit %q", ErrInvalidOptions, o.Fit)
+	}
+	switch o.Format {
+	case "", FormatJPEG, FormatPNG, FormatWebP, FormatAVIF:
+	default:
+		return fmt.Errorf("%w: unknown format %q", ErrInvalidOptions, o.Format)
+	}
+	return nil
+}
+
+// IsZero reports whether no resizing was asked for.
+func (o Options) IsZero() bool {
+	return o.Width == 0 && o.Height == 0
+}
+
+// IsOriginal reports whether the stored image answers the options, no
+// resizing nor another format was asked for.
+func (o Options) IsOriginal() bool {
+	return o.IsZero() && o.Format == ""
+}
+
+// String identifies the options in cache keys.
+func (o Options) String() string {
+	if o.Format != "" {
+		return fmt.Sprintf("%dx%d-%s.%s", o.Width, o.Height, o.Fit, o.Format)
+	}
+	return fmt.Sprintf("%dx%d-%s", o.Width, o.Height, o.Fit)
+}
+
+// Resize decodes a JPEG, PNG, GIF or WebP image and scales it down to the
+// options. Images are never enlarged. The result is PNG when the source is
+// PNG, so transparency survives, and JPEG otherwise, unless the options ask
+// for JPEG or PNG. WebP and AVIF are made by Encoder.Resize.
+func Resize(r io.Reader, o Options) ([]byte, string, error) {
+	dst, format, err := decodeScaled(r, o)
+	if err != nil {
+		return nil, "", err
+	}
+	if o.Format != "" {
+		format = o.Format
+	}
+	return encode(dst, format)
+}
+
+func decodeScaled(r io.Reader, o Options) (image.Image, string, error) {
+	if err := o.Validate(); err != nil {
+		return nil, "", err
+	}
+	src, format, err := image.Decode(r)
+	if err != nil {
+		return nil, "", fmt.Errorf("thumbnail - Resize - image.Decode: %w", err)
+	}
+	return scale(src, o), format, nil
+}
+
+func encode(dst image.Image, format string) ([]byte, string, error) {
+	var buf bytes.Buffer
+	var err error
+	switch format {
+	case FormatPNG:
+		err = png.Encode(&buf, dst)
+		return buf.Bytes(), "image/png", err
+	case "gif":
+		err = gif.Encode(&buf, dst, nil)
+		return buf.Bytes(), "image/gif", err
+	case FormatWebP, FormatAVIF:
+		return nil, "", fmt.Errorf("thumbnail - Resize - %w: %s", ErrNoEncoder, format)
+	}
+	err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	return buf.Bytes(), "image/jpeg", err
+}
+
+func scale(src image.Image, o Options) image.Image {
+	bounds := src.Bounds()
+	sw, sh := bounds.Dx(), bounds.Dy()
+	if sw == 0 || sh == 0 || o.IsZero() {
+		return src
+	}
+
+	w, h := o.Width, o.Height
+	switch {
+	case w == 0:
+		w = sw * h / sh
+	case h == 0:
+		h = sh * w / sw
+	}
+
+	crop := bounds
+	switch o.Fit {
+	case FitContain:
+		if sw*h > sh*w {
+			h = sh * w / sw
+		} else {
+			w = sw * h / sh
+		}
+	case FitCover:
+		// cut the source to the box ratio, centered
+		if sw*h > sh*w {
+			cw := sh * w / h
+			crop.Min.X += (sw - cw) / 2
+			crop.Max.X = crop.Min.X + cw
+		} else {
+			ch := sw * h / w
+			crop.Min.Y += (sh - ch) / 2
+			crop.Max.Y = crop.Min.Y + ch
+		}
+	}
+	if w > crop.Dx() || h > crop.Dy() {
+		w, h = crop.Dx(), crop.Dy()
+	}
+	if w < 1 {
+		w = 1
+	}
+	if h < 1 {
+		h = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, crop, draw.Src, nil)
+	return dst
+}
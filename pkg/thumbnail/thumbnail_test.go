@@ -0,0 +1,84 @@
+package thumbnail_test
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/thumbnail"
+)
+
+func cover(t *testing.T, w, h int) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Set(x, y, color.RGBA{uint8(x), uint8(y), 100, 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResize(t *testing.T) {
+	src := cover(t, 600, 900)
+	tests := []struct {
+		opts thumbnail.Options
+		w, h int
+	}{
+		{thumbnail.Options{Width: 300, Fit: thumbnail.FitContain}, 300, 450},
+		{thumbnail.Options{Width: 300, Height: 300, Fit: thumbnail.FitContain}, 200, 300},
+		{thumbnail.Options{Width: 300, Height: 300, Fit: thumbnail.FitCover}, 300, 300},
+		{thumbnail.Options{Width: 300, Height: 300, Fit: thumbnail.FitFill}, 300, 300},
+		{thumbnail.Options{Width: 1200, Height: 1800, Fit: thumbnail.FitContain}, 600, 900},
+	}
+	for _, tt := range tests {
+		data, contentType, err := thumbnail.Resize(bytes.NewReader(src), tt.opts)
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.opts, err)
+		}
+		if contentType != "image/jpeg" {
+			t.Errorf("%v: expected jpeg, got %q", tt.opts, contentType)
+		}
+		cfg, err := jpeg.DecodeConfig(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%v: unexpected error: %v", tt.opts, err)
+		}
+		if cfg.Width != tt.w || cfg.Height != tt.h {
+			t.Errorf("%v: expected %dx%d, got %dx%d", tt.opts, tt.w, tt.h, cfg.Width, cfg.Height)
+		}
+	}
+}
+
+func TestResizeKeepsPNG(t *testing.T) {
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewNRGBA(image.Rect(0, 0, 40, 40))); err != nil {
+		t.Fatal(err)
+	}
+	_, contentType, err := thumbnail.Resize(&buf, thumbnail.Options{Width: 20, Fit: thumbnail.FitContain})
+	if err != nil || contentType != "image/png" {
+		t.Errorf("expected png, got %q, %v", contentType, err)
+	}
+}
+
+func TestParseOptions(t *testing.T) {
+	opts, err := thumbnail.ParseOptions("300", "450", "cover")
+	if err != nil || opts != (thumbnail.Options{Width: 300, Height: 450, Fit: thumbnail.FitCover}) {
+		t.Errorf("unexpected options %v, %v", opts, err)
+	}
+	if opts, _ = thumbnail.ParseOptions("", "", ""); !opts.IsZero() || opts.Fit != thumbnail.FitContain {
+		t.Errorf("unexpected default options %v", opts)
+	}
+	for _, q := range [][3]string{{"abc", "", ""}, {"-1", "", ""}, {"5000", "", ""}, {"300", "", "zoom"}} {
+		if _, err := thumbnail.ParseOptions(q[0], q[1], q[2]); !errors.Is(err, thumbnail.ErrInvalidOptions) {
+			t.Errorf("%v: expected invalid options, got %v", q, err)
+		}
+	}
+}
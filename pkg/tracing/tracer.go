@@ -0,0 +1,263 @@
+package tracing
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Tracer starts the traces of requests and exports the finished spans in
+// batches. Spans finished while the export queue is full are dropped.
+type Tracer struct {
+	endpoint string
+	service  string
+	headers  map[string]string
+	client   *http.Client
+	ratio    float64
+
+	spans   chan *Span
+	mu      sync.Mutex
+	dropped int
+}
+
+// New exports to the OTLP/HTTP endpoint of a collector, like
+// http://localhost:4318; spans are posted to its /v1/traces.
+func New(endpoint, service string, client *http.Client) *Tracer {
+	if service == "" {
+		service = _defaultService
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if !strings.HasSuffix(endpoint, "/v1/traces") {
+		endpoint += "/v1/traces"
+	}
+	return &Tracer{
+		endpoint: endpoint,
+		service:  service,
+		client:   client,
+		ratio:    1,
+		spans:    make(chan *Span, _queueSize),
+	}
+}
+
+// SetHeaders adds headers to the exports, e.g. the API key of a hosted
+// collector.
+func (t *Tracer) SetHeaders(headers map[string]string) {
+	t.headers = headers
+}
+
+// SetSampleRatio traces that share of the requests starting a trace, from
+// 0 to 1. Requests continuing a sampled trace are always traced.
+func (t *Tracer) SetSampleRatio(ratio float64) {
+	t.ratio = ratio
+}
+
+// StartRequest starts the span of a request, continuing the trace of the
+// traceparent header it came with. It returns a nil span for requests that
+// are not sampled, here or by the caller.
+func (t *Tracer) StartRequest(ctx context.Context, name, traceparent string) (context.Context, *Span) {
+	traceID, parentID, sampled, ok := parseTraceParent(traceparent)
+	switch {
+	case ok && !sampled:
+		return ctx, nil
+	case !ok && t.ratio < 1 && rand.Float64() >= t.ratio:
+		return ctx, nil
+	case !ok:
+		traceID, parentID = newTraceID(), [8]byte{}
+	}
+	span := &Span{
+		tracer:   t,
+		traceID:  traceID,
+		spanID:   newSpanID(),
+		parentID: parentID,
+		name:     name,
+		kind:     KindServer,
+		start:    time.Now(),
+	}
+	return context.WithValue(ctx, spanKey{}, span), span
+}
+
+func (t *Tracer) queue(span *Span) {
+	select {
+	case t.spans <- span:
+	default:
+		t.mu.Lock()
+		t.dropped++
+		t.mu.Unlock()
+	}
+}
+
+// Run exports the finished spans every few seconds until ctx is done, then
+// exports the ones left. Failed exports are passed to report.
+func (t *Tracer) Run(ctx context.Context, report func(error)) {
+	ticker := time.NewTicker(_defaultInterval)
+	defer ticker.Stop()
+	batch := make([]*Span, 0, _defaultBatchSize)
+	flush := func(ctx context.Context) {
+		if len(batch) == 0 {
+			return
+		}
+		if err := t.Export(ctx, batch); err != nil {
+			report(err)
+		}
+		batch = batch[:0]
+	}
+	for {
+		select {
+		case span := <-t.spans:
+			batch = append(batch, span)
+			if len(batch) >= _defaultBatchSize {
+				flush(ctx)
+			}
+		case <-ticker.C:
+			flush(ctx)
+			t.mu.Lock()
+			dropped := t.dropped
+			t.dropped = 0
+			t.mu.Unlock()
+			if dropped > 0 {
+				report(fmt.Errorf("tracing - Run - %d spans dropped, the export queue was full", dropped))
+			}
+		case <-ctx.Done():
+			for len(t.spans) > 0 {
+				batch = append(batch, <-t.spans)
+			}
+			shutdown, cancel := context.WithTimeout(context.WithoutCancel(ctx), _defaultInterval)
+			flush(shutdown)
+			cancel()
+			return
+		}
+	}
+}
+
+// Export posts spans to the collector.
+func (t *Tracer) Export(ctx context.Context, spans []*Span) error {
+	body, err := json.Marshal(t.request(spans))
+	if err != nil {
+		return fmt.Errorf("tracing - Export - json.Marshal: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("tracing - Export - http.NewRequest: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range t.headers {
+		req.Header.Set(key, value)
+	}
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("tracing - Export - client.Do: %w", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("tracing - Export - status %s", resp.Status)
+	}
+	return nil
+}
+
+// The OTLP JSON encoding, ids are hex and 64 bit numbers strings.
+type (
+	exportRequest struct {
+		ResourceSpans []resourceSpans `json:"resourceSpans"`
+	}
+	resourceSpans struct {
+		Resource   resource     `json:"resource"`
+		ScopeSpans []scopeSpans `json:"scopeSpans"`
+	}
+	resource struct {
+		Attributes []keyValue `json:"attributes"`
+	}
+	scopeSpans struct {
+		Scope scope      `json:"scope"`
+		Spans []spanJSON `json:"spans"`
+	}
+	scope struct {
+		Name string `json:"name"`
+	}
+	spanJSON struct {
+		TraceID           string     `json:"traceId"`
+		SpanID            string     `json:"spanId"`
+		ParentSpanID      string     `json:"parentSpanId,omitempty"`
+		Name              string     `json:"name"`
+		Kind              int        `json:"kind"`
+		StartTimeUnixNano string     `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string     `json:"endTimeUnixNano"`
+		Attributes        []keyValue `json:"attributes,omitempty"`
+		Status            *status    `json:"status,omitempty"`
+	}
+	status struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	keyValue struct {
+		Key   string   `json:"key"`
+		Value anyValue `json:"value"`
+	}
+	anyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+// statusError is the OTLP status code of failed spans.
+const statusError = 2
+
+func (t *Tracer) request(spans []*Span) exportRequest {
+	encoded := make([]spanJSON, 0, len(spans))
+	for _, s := range spans {
+		s.mu.Lock()
+		span := spanJSON{
+			TraceID:           hex.EncodeToString(s.traceID[:]),
+			SpanID:            hex.EncodeToString(s.spanID[:]),
+			Name:              s.name,
+			Kind:              s.kind,
+			StartTimeUnixNano: strconv.FormatInt(s.start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.end.UnixNano(), 10),
+		}
+		if s.parentID != [8]byte{} {
+			span.ParentSpanID = hex.EncodeToString(s.parentID[:])
+		}
+		for _, attr := range s.attrs {
+			span.Attributes = append(span.Attributes, keyValue{attr.key, newAnyValue(attr.value)})
+		}
+		if s.err != "" {
+			span.Status = &status{Code: statusError, Message: s.err}
+		}
+		s.mu.Unlock()
+		encoded = append(encoded, span)
+	}
+	return exportRequest{ResourceSpans: []resourceSpans{{
+		Resource:   resource{Attributes: []keyValue{{"service.name", newAnyValue(t.service)}}},
+		ScopeSpans: []scopeSpans{{Scope: scope{Name: _defaultService}, Spans: encoded}},
+	}}}
+}
+
+func newAnyValue(value interface{}) anyValue {
+	switch v := value.(type) {
+	case string:
+		return anyValue{StringValue: &v}
+	case bool:
+		return anyValue{BoolValue: &v}
+	case int:
+		s := strconv.Itoa(v)
+		return anyValue{IntValue: &s}
+	case int64:
+		s := strconv.FormatInt(v, 10)
+		return anyValue{IntValue: &s}
+	case float64:
+		return anyValue{DoubleValue: &v}
+	}
+	s := fmt.Sprint(value)
+	return anyValue{StringValue: &s}
+}
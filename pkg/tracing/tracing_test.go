@@ -0,0 +1,114 @@
+package tracing_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/tracing"
+)
+
+type exported struct {
+	ResourceSpans []struct {
+		ScopeSpans []struct {
+			Spans []struct {
+				TraceID      string `json:"traceId"`
+				SpanID       string `json:"spanId"`
+				ParentSpanID string `json:"parentSpanId"`
+				Name         string `json:"name"`
+				Status       *struct {
+					Code    int    `json:"code"`
+					Message string `json:"message"`
+				} `json:"status"`
+			} `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func TestTraceContinuesAndExports(t *testing.T) {
+	var body exported
+	collector := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/traces" || r.Header.Get("X-Api-Key") != "key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}))
+	defer collector.Close()
+
+	var sent string
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		sent = r.Header.Get("traceparent")
+	}))
+	defer upstream.Close()
+
+	tracer := tracing.New(collector.URL, "kompanion", collector.Client())
+	tracer.SetHeaders(map[string]string{"X-Api-Key": "key"})
+	parent := "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"
+	ctx, request := tracer.StartRequest(context.Background(), "POST /books/upload", parent)
+	ctx, store := tracing.Start(ctx, "BookShelf.StoreBook")
+	store.RecordError(errors.New("disk full"))
+
+	client := &http.Client{Transport: tracing.Transport(nil)}
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, upstream.URL, nil)
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	store.End()
+	request.End()
+
+	if !strings.HasPrefix(sent, "00-4bf92f3577b34da6a3ce929d0e0e4736-") || strings.Contains(sent, "00f067aa0ba902b7") {
+		t.Fatalf("expected the trace passed on with a span of its own, got %q", sent)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	tracer.Run(ctx, func(err error) { t.Fatalf("unexpected error: %v", err) })
+
+	spans := body.ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 3 {
+		t.Fatalf("expected 3 spans, got %+v", spans)
+	}
+	byName := map[string]int{}
+	for i, span := range spans {
+		if span.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" {
+			t.Fatalf("unexpected trace of %+v", span)
+		}
+		byName[span.Name] = i
+	}
+	outgoing, stored, served := spans[0], spans[byName["BookShelf.StoreBook"]], spans[byName["POST /books/upload"]]
+	if served.ParentSpanID != "00f067aa0ba902b7" || stored.ParentSpanID != served.SpanID || outgoing.ParentSpanID != stored.SpanID {
+		t.Fatalf("unexpected span tree %+v", spans)
+	}
+	if stored.Status == nil || stored.Status.Code != 2 || stored.Status.Message != "disk full" {
+		t.Fatalf("expected the store span failed, got %+v", stored.Status)
+	}
+}
+
+func TestUntracedRequests(t *testing.T) {
+	tracer := tracing.New("http://localhost:4318", "", http.DefaultClient)
+	_, span := tracer.StartRequest(context.Background(), "GET /books", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-00")
+	if span != nil {
+		t.Fatal("expected no span for a trace the caller did not sample")
+	}
+
+	tracer.SetSampleRatio(0)
+	if _, span = tracer.StartRequest(context.Background(), "GET /books", ""); span != nil {
+		t.Fatal("expected no span with a sample ratio of 0")
+	}
+
+	// spans below an untraced request are nil and record nothing
+	ctx, child := tracing.Start(context.Background(), "BookShelf.ListBooks")
+	child.SetAttribute("books", 3)
+	child.RecordError(errors.New("ignored"))
+	child.End()
+	if child != nil || tracing.SpanFrom(ctx) != nil {
+		t.Fatal("expected no span outside a traced request")
+	}
+}
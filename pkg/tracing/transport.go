@@ -0,0 +1,43 @@
+package tracing
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Transport traces the requests of a client and passes the trace on in
+// their traceparent header. base is http.DefaultTransport when nil.
+func Transport(base http.RoundTripper) http.RoundTripper {
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return transport{base}
+}
+
+type transport struct {
+	base http.RoundTripper
+}
+
+func (t transport) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx, span := StartKind(req.Context(), req.Method+" "+req.URL.Host, KindClient)
+	if span == nil {
+		return t.base.RoundTrip(req)
+	}
+	defer span.End()
+	span.SetAttribute("http.request.method", req.Method)
+	span.SetAttribute("server.address", req.URL.Host)
+	span.SetAttribute("url.path", req.URL.Path)
+
+	req = req.Clone(ctx)
+	req.Header.Set("traceparent", span.TraceParent())
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		span.RecordError(err)
+		return resp, err
+	}
+	span.SetAttribute("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 500 {
+		span.RecordError(errors.New("status " + resp.Status))
+	}
+	return resp, nil
+}
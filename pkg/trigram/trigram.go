@@ -0,0 +1,42 @@
+// Package trigram compares text by the three letter sequences it shares,
+// like similarity() of the pg_trgm extension of Postgres, for stores
+// without it.
+package trigram
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Trigrams returns the set of trigrams of s. Like pg_trgm each word of
+// letters and digits is lower cased and padded with two spaces in front
+// and one behind, so short words and word starts count more.
+func Trigrams(s string) map[string]bool {
+	set := make(map[string]bool)
+	words := strings.FieldsFunc(strings.ToLower(s), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+	for _, word := range words {
+		runes := []rune("  " + word + " ")
+		for i := 0; i+3 <= len(runes); i++ {
+			set[string(runes[i:i+3])] = true
+		}
+	}
+	return set
+}
+
+// Similarity is the share of the trigrams of a and b they have in common,
+// from 0 for none to 1 for the same trigrams.
+func Similarity(a, b string) float64 {
+	ta, tb := Trigrams(a), Trigrams(b)
+	if len(ta) == 0 || len(tb) == 0 {
+		return 0
+	}
+	shared := 0
+	for t := range ta {
+		if tb[t] {
+			shared++
+		}
+	}
+	return float64(shared) / float64(len(ta)+len(tb)-shared)
+}
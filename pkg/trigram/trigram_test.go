@@ -0,0 +1,28 @@
+package trigram_test
+
+import (
+	"math"
+	"testing"
+
+	"github.com/banjuer/kompanion/pkg/trigram"
+)
+
+func TestSimilarity(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want float64
+	}{
+		// the values of similarity() in Postgres
+		{"word", "two words", 0.36363637},
+		{"The Hobbit", "the hobbit!", 1},
+		{"Dune", "Emma", 0},
+		{"", "Emma", 0},
+	} {
+		if got := trigram.Similarity(tt.a, tt.b); math.Abs(got-tt.want) > 1e-6 {
+			t.Errorf("Similarity(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+	if n := len(trigram.Trigrams("cat")); n != 4 {
+		t.Errorf("expected the trigrams of a padded word, got %d", n)
+	}
+}
@@ -21,6 +21,11 @@ func PartialMD5(filepath string) (string, error) {
 	}
 	defer file.Close()
 
+	return PartialMD5Reader(file)
+}
+
+// PartialMD5Reader is PartialMD5 of content that is not in a file.
+func PartialMD5Reader(file io.ReadSeeker) (string, error) {
 	step := int64(1024)
 	size := 1024
 	hash := md5.New()
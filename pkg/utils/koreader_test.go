@@ -1,7 +1,9 @@
 package utils_test
 
 import (
+	"bytes"
 	"fmt"
+	"os"
 	"testing"
 
 	"github.com/banjuer/kompanion/pkg/utils"
@@ -17,3 +19,29 @@ func TestPartialMd5(t *testing.T) {
 		t.Fatalf("Expected MD5 %s, got %x", expected, actual)
 	}
 }
+
+func TestPartialMd5Reader(t *testing.T) {
+	data := bytes.Repeat([]byte("0123456789abcdef"), 1<<16)
+	file, err := os.CreateTemp("", "")
+	if err != nil {
+		t.Fatalf("Error creating temp file: %v", err)
+	}
+	defer os.Remove(file.Name())
+	_, err = file.Write(data)
+	file.Close()
+	if err != nil {
+		t.Fatalf("Error writing temp file: %v", err)
+	}
+
+	expected, err := utils.PartialMD5(file.Name())
+	if err != nil {
+		t.Fatalf("Error calculating MD5: %v", err)
+	}
+	actual, err := utils.PartialMD5Reader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Error calculating MD5: %v", err)
+	}
+	if expected != actual {
+		t.Fatalf("Expected MD5 %s, got %s", expected, actual)
+	}
+}
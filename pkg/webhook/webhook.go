@@ -0,0 +1,128 @@
+// Package webhook posts events as JSON to URLs of other services, like Home
+// Assistant or a Discord bot. With a secret the body is signed with
+// HMAC-SHA256 in the X-Kompanion-Signature header, "sha256=<hex>", so the
+// receiver can check where it came from.
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	_defaultRetries = 3
+	_defaultBackoff = 2 * time.Second
+)
+
+// Payload is the body of a request.
+type Payload struct {
+	Event  string      `json:"event"`
+	SentAt time.Time   `json:"sent_at"`
+	Data   interface{} `json:"data"`
+}
+
+// Sender posts events to every URL. Failed deliveries are retried with a
+// growing pause, unless the receiver refused the request.
+type Sender struct {
+	urls    []string
+	secret  []byte
+	client  *http.Client
+	retries int
+	backoff time.Duration
+}
+
+// New -. An empty secret leaves requests unsigned.
+func New(urls []string, secret string, client *http.Client) *Sender {
+	return &Sender{
+		urls:    urls,
+		secret:  []byte(secret),
+		client:  client,
+		retries: _defaultRetries,
+		backoff: _defaultBackoff,
+	}
+}
+
+// SetRetries sets how often a delivery is retried and the pause before the
+// first retry, it doubles for the ones after.
+func (s *Sender) SetRetries(retries int, backoff time.Duration) {
+	s.retries = retries
+	s.backoff = backoff
+}
+
+// Sign returns the signature of body with secret.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// Send posts event with data to every URL and returns the errors of the
+// deliveries that failed for good.
+func (s *Sender) Send(ctx context.Context, event string, data interface{}) error {
+	body, err := json.Marshal(Payload{Event: event, SentAt: time.Now().UTC(), Data: data})
+	if err != nil {
+		return fmt.Errorf("webhook - Send - json.Marshal: %w", err)
+	}
+	var errs []error
+	for _, url := range s.urls {
+		if err = s.deliver(ctx, url, event, body); err != nil {
+			errs = append(errs, fmt.Errorf("webhook - Send - %s: %w", url, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (s *Sender) deliver(ctx context.Context, url, event string, body []byte) error {
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		retry, err := s.post(ctx, url, event, body)
+		if err == nil || !retry || attempt >= s.retries {
+			return err
+		}
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+// post reports whether a failed request is worth retrying.
+func (s *Sender) post(ctx context.Context, url, event string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "kompanion-webhook")
+	req.Header.Set("X-Kompanion-Event", event)
+	if len(s.secret) > 0 {
+		req.Header.Set("X-Kompanion-Signature", Sign(s.secret, body))
+	}
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, io.LimitReader(resp.Body, 1<<16))
+
+	switch {
+	case resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("status %s", resp.Status)
+	default:
+		return false, fmt.Errorf("status %s", resp.Status)
+	}
+}
@@ -0,0 +1,59 @@
+package webhook_test
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/banjuer/kompanion/pkg/webhook"
+)
+
+func TestSendSignsAndRetries(t *testing.T) {
+	var attempts int
+	var payload webhook.Payload
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("X-Kompanion-Signature") != webhook.Sign([]byte("secret"), body) || r.Header.Get("X-Kompanion-Event") != "book.added" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		_ = json.Unmarshal(body, &payload)
+	}))
+	defer server.Close()
+
+	s := webhook.New([]string{server.URL}, "secret", server.Client())
+	s.SetRetries(2, time.Millisecond)
+	if err := s.Send(context.Background(), "book.added", map[string]string{"id": "book-id"}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if attempts != 2 || payload.Event != "book.added" || payload.Data.(map[string]interface{})["id"] != "book-id" {
+		t.Fatalf("unexpected delivery after %d attempts: %+v", attempts, payload)
+	}
+}
+
+func TestSendDoesNotRetryRefusedRequests(t *testing.T) {
+	var attempts int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		w.WriteHeader(http.StatusBadRequest)
+	}))
+	defer server.Close()
+
+	s := webhook.New([]string{server.URL}, "", server.Client())
+	s.SetRetries(3, time.Millisecond)
+	if err := s.Send(context.Background(), "book.deleted", nil); err == nil {
+		t.Fatal("expected an error")
+	}
+	if attempts != 1 {
+		t.Fatalf("expected one attempt, got %d", attempts)
+	}
+}